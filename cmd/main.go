@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -9,12 +12,30 @@ import (
 	"github.com/gin-gonic/gin"
 	"pr-previews/internal/config"
 	"pr-previews/internal/handlers"
+	"pr-previews/internal/services"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// A mistyped image pattern would refuse every deploy, so don't start
+	if err := services.SharedImagePolicy().Configure(cfg.Images.Allow, cfg.Images.Deny); err != nil {
+		fmt.Printf("❌ Invalid image policy: %v\n", err)
+		os.Exit(1)
+	}
+	// Beacon paths end up in ingress annotations, so they're signed with a
+	// key of their own rather than the webhook secret
+	if cfg.Access.Tracking && cfg.Access.Secret == "" {
+		fmt.Printf("❌ ACCESS_TRACKING needs ACCESS_SECRET to sign beacon paths\n")
+		os.Exit(1)
+	}
+	// A cap below 1 would turn every /scale into a scale to zero or worse
+	if cfg.Preview.ScaleMaxReplicas <= 0 {
+		fmt.Printf("❌ Invalid SCALE_MAX_REPLICAS: %d; it must be at least 1\n", cfg.Preview.ScaleMaxReplicas)
+		os.Exit(1)
+	}
+
 	// Create router
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
@@ -22,28 +43,145 @@ func main() {
 
 	// Initialize handlers
 	h := handlers.New(cfg)
+	r.Use(h.IsolateShareHost)
+
+	// Background workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Start(ctx)
+	go services.SharedLeader().Run(ctx, cfg)
+	if cmdService, err := services.NewCommandServiceK8s(cfg); err == nil {
+		go cmdService.StartVaultRenewer(ctx)
+		go cmdService.StartPreviewReporter(ctx)
+		go cmdService.StartWarmPool(ctx)
+		go cmdService.StartPodHealthWatcher(ctx)
+		go cmdService.StartCapabilityDetector(ctx)
+		go cmdService.StartScaleToZero(ctx)
+		go cmdService.StartReadinessChecks(ctx)
+		go cmdService.StartDependencySweeper(ctx)
+		go cmdService.StartTapReaper(ctx)
+	} else {
+		fmt.Printf("⚠️  Background workers disabled: %v\n", err)
+	}
 
 	// Setup routes
 	r.GET("/health", h.Health)
+	r.GET("/healthz", h.Health)
+	r.GET("/readyz", h.Ready)
 	r.GET("/metrics", h.Metrics)
+	webhookLimits := h.LimitWebhookPayload()
 	r.GET("/webhook/github", h.GitHubWebhook)
-	r.POST("/webhook/github", h.GitHubWebhook)
+	r.POST("/webhook/github", webhookLimits, h.GitHubWebhook)
+	r.POST("/webhook/azure-devops", webhookLimits, h.AzureDevOpsWebhook)
+	r.Any("/access/:namespace/:signature", h.RecordPreviewAccess)
+	r.Any("/share/:namespace/:token/*path", h.ServeShareLink)
+	// ingress-nginx falls back to / for asleep previews
+	r.Any("/", h.ServePreviewWakeup)
 	r.GET("/test/k8s", h.TestK8s) // ← New K8s test endpoint
 
+	// Webhook simulator for local testing; it runs commands as any user, so
+	// it stays off in production
+	if cfg.Debug.Simulate {
+		debug := r.Group("/debug")
+		debug.POST("/simulate", h.SimulateWebhook)
+		debug.GET("/simulate", h.ListSimulations)
+		debug.GET("/simulate/:id", h.GetSimulation)
+	}
+
+	// Profiling exposes internals and can stall the server for the length of
+	// a CPU profile or trace, so it's opt-in and for admins only
+	if cfg.Debug.Profiling {
+		profiling := r.Group("/debug/pprof", h.AdminAuth, h.RequireAdminRole(services.AdminRoleAdmin))
+		profiling.GET("/", h.Profile)
+		profiling.GET("/:profile", h.Profile)
+		profiling.POST("/:profile", h.Profile)
+	}
+
+	// Preview API. Kubeconfig checks the caller's GitHub token itself; the
+	// rest go through the API auth chain. Onboarding also acts with the
+	// caller's GitHub token, sent as the bearer token the chain accepts.
+	api := r.Group("/api/v1")
+	api.GET("/previews/:pr/kubeconfig", h.DeveloperAuth, h.GetKubeconfig)
+	authed := api.Group("", h.APIAuth)
+	read := h.RequireAPIAccess(services.APIAccessRead)
+	write := h.RequireAPIAccess(services.APIAccessWrite)
+	authed.GET("/previews", read, h.ListPreviews)
+	authed.POST("/previews", write, h.CreatePreview)
+	authed.DELETE("/previews/:pr", write, h.DeletePreview)
+	authed.GET("/previews/:pr/events", read, h.StreamPreviewEvents)
+	authed.GET("/previews/:pr/artifacts", read, h.ListArtifacts)
+	authed.GET("/previews/:pr/artifacts/*key", read, h.GetArtifact)
+	authed.GET("/previews/:pr/snapshots", read, h.ListSnapshots)
+	authed.GET("/previews/:pr/timeline", read, h.GetTimeline)
+	authed.GET("/previews/:pr/status", read, h.GetPreviewStatus)
+	authed.GET("/previews/:pr/:service/wait", read, h.WaitForPreview)
+	authed.GET("/previews/:pr/:service/logs", write, h.StreamPreviewLogs)
+	authed.POST("/previews/:pr/shares/:id/password", write, h.SetShareLinkPassword)
+	authed.GET("/stats/webhooks", read, h.WebhookStats)
+	authed.GET("/reports/previews", read, h.PreviewInventory)
+	authed.POST("/onboard", read, h.Onboard)
+
+	// Admin API
+	admin := r.Group("/api/admin", h.AdminAuth)
+	viewer := h.RequireAdminRole(services.AdminRoleViewer)
+	operator := h.RequireAdminRole(services.AdminRoleOperator)
+	adminOnly := h.RequireAdminRole(services.AdminRoleAdmin)
+	admin.GET("/queue", viewer, h.ListQueue)
+	admin.DELETE("/queue/:id", operator, h.CancelQueueJob)
+	admin.POST("/queue/:id/priority", operator, h.ReprioritizeQueueJob)
+	admin.POST("/reports/previews", operator, h.RunPreviewReport)
+	admin.GET("/namespaces/stuck", viewer, h.ListStuckNamespaces)
+	admin.POST("/namespaces/:name/force-cleanup", operator, h.ForceCleanupNamespace)
+	admin.GET("/comments", viewer, h.ListPendingComments)
+	admin.POST("/comments/:id/retry", operator, h.RetryPendingComment)
+	admin.DELETE("/comments/:id", operator, h.DiscardPendingComment)
+	admin.GET("/dead-letters", viewer, h.ListDeadLetters)
+	admin.GET("/dead-letters/:id", viewer, h.GetDeadLetter)
+	admin.POST("/dead-letters/:id/reprocess", operator, h.ReprocessDeadLetter)
+	admin.DELETE("/dead-letters/:id", operator, h.DiscardDeadLetter)
+	admin.GET("/deployers", viewer, h.ListDeployers)
+	admin.POST("/deployers", adminOnly, h.GrantDeployer)
+	admin.DELETE("/deployers/:login", adminOnly, h.RevokeDeployer)
+	admin.GET("/shares", viewer, h.ListShareLinks)
+	admin.DELETE("/shares/:namespace/:id", operator, h.RevokeShareLink)
+	admin.GET("/maintenance", viewer, h.GetMaintenance)
+	admin.POST("/maintenance", adminOnly, h.SetMaintenance)
+	admin.POST("/graphql", viewer, h.GraphQL)
+	admin.GET("/debug/runtime", viewer, h.RuntimeStats)
+
 	// Start server
 	fmt.Printf("🚀 pr-previews server starting on port %s\n", cfg.Server.Port)
 	fmt.Printf("📊 Health: http://localhost:%s/health\n", cfg.Server.Port)
 	fmt.Printf("🪝 Webhook: http://localhost:%s/webhook/github\n", cfg.Server.Port)
 	fmt.Printf("☸️  K8s Test: http://localhost:%s/test/k8s\n", cfg.Server.Port)
+	fmt.Printf("⏳ Queue: http://localhost:%s/api/admin/queue\n", cfg.Server.Port)
+	if cfg.Debug.Profiling {
+		fmt.Printf("🔬 Profiling: http://localhost:%s/debug/pprof/ (admin auth)\n", cfg.Server.Port)
+	}
+	if cfg.Debug.Simulate {
+		fmt.Printf("🧪 Simulator: http://localhost:%s/debug/simulate (DEBUG_SIMULATE is on; disable it in production)\n", cfg.Server.Port)
+	}
 
-	// Graceful shutdown
+	srv := newHTTPServer(cfg, r)
 	go func() {
-		r.Run(":" + cfg.Server.Port)
+		if err := serve(cfg, srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("❌ Server failed: %v\n", err)
+			os.Exit(1)
+		}
 	}()
 
+	// Graceful shutdown: stop accepting connections and let in-flight
+	// requests finish
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Printf("⚠️  Forced shutdown: %v\n", err)
+	}
+	cancel()
+
 	fmt.Println("\n✅ Server shut down gracefully")
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+	"pr-previews/internal/config"
+)
+
+// newHTTPServer builds the public-facing server with timeouts and header
+// limits so slow or oversized clients can't pin connections open
+func newHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              cfg.Server.Host + ":" + cfg.Server.Port,
+		Handler:           handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    cfg.Server.MaxHeaderBytes,
+	}
+
+	// HTTP/2 is negotiated automatically over TLS; h2c only matters for
+	// plaintext listeners behind a terminating proxy
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(cfg.Server.H2C)
+	srv.Protocols = protocols
+
+	if len(cfg.Server.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Server.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.Server.AutocertCache),
+		}
+		// tls-alpn-01 challenges are answered on the TLS listener itself
+		srv.TLSConfig = manager.TLSConfig()
+	} else if cfg.Server.TLSCertFile != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if cfg.AdminAuth.ClientCAFile != "" {
+		if err := requestClientCerts(srv, cfg.AdminAuth.ClientCAFile); err != nil {
+			fmt.Printf("⚠️  Admin client certificates disabled: %v\n", err)
+		}
+	}
+
+	return srv
+}
+
+// requestClientCerts asks TLS clients for a certificate signed by the admin
+// CA. Certificates stay optional at the handshake so webhooks and the public
+// API keep working; AdminAuth decides what a verified certificate may do.
+func requestClientCerts(srv *http.Server, caFile string) error {
+	if srv.TLSConfig == nil {
+		return fmt.Errorf("ADMIN_CLIENT_CA_FILE needs TLS (TLS_CERT_FILE or AUTOCERT_DOMAINS)")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	srv.TLSConfig.ClientCAs = pool
+	srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// serve starts the listener in the mode selected by the TLS settings
+func serve(cfg *config.Config, srv *http.Server) error {
+	switch {
+	case len(cfg.Server.AutocertDomains) > 0:
+		fmt.Printf("🔒 TLS via autocert for %v\n", cfg.Server.AutocertDomains)
+		return srv.ListenAndServeTLS("", "")
+	case cfg.Server.TLSCertFile != "":
+		fmt.Printf("🔒 TLS with certificate %s\n", cfg.Server.TLSCertFile)
+		return srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+	default:
+		return srv.ListenAndServe()
+	}
+}
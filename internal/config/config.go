@@ -1,16 +1,381 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
 	Server struct {
-		Port string
-		Host string
+		Port       string
+		Host       string
+		AdminToken string
+
+		ReadTimeout     time.Duration
+		WriteTimeout    time.Duration
+		IdleTimeout     time.Duration
+		ShutdownTimeout time.Duration
+		MaxHeaderBytes  int
+		MaxWebhookBytes int // largest webhook payload accepted; GitHub caps deliveries at 25MB
+
+		TLSCertFile     string
+		TLSKeyFile      string
+		AutocertDomains []string // Let's Encrypt hostnames; overrides cert files
+		AutocertCache   string
+		H2C             bool // HTTP/2 without TLS, for use behind a terminating proxy
+
+		PublicURL string // externally reachable base URL, used in links the bot posts
+		Replica   string // this replica's name, POD_NAME or the hostname; keys the state each replica keeps
+		Namespace string // POD_NAMESPACE, where this replica runs; empty outside a cluster
+	}
+	Leader struct {
+		// Background work that must run once per installation, such as
+		// scaling previews to zero, runs on the replica holding this Lease,
+		// which needs get, create and update on Leases in Namespace, and
+		// get and list on Pods in its own, to wait out a rollout.
+		// Without a namespace, e.g. outside a cluster, the replica leads
+		// alone.
+		Namespace     string
+		Lease         string
+		LeaseDuration time.Duration
+	}
+	AdminAuth struct {
+		ClientCAFile      string        // CA bundle for admin client certificates (mTLS); needs TLS
+		ClientCertMaxTTL  time.Duration // refuse client certificates valid for longer; 0 allows any
+		OIDCIssuer        string
+		OIDCAudience      string
+		OIDCIdentityClaim string   // claim naming the caller, e.g. email or sub
+		OIDCGroupsClaim   string   // claim listing the caller's groups
+		RoleBindings      []string // subject=role or group:<name>=role; roles are viewer, operator and admin
+	}
+	APIAuth struct {
+		Providers      []string      // api_key, service_token and/or github, tried in order
+		APIKeys        []string      // name:key=access, sent as X-API-Key; access is read, write or admin
+		ServiceTokens  []string      // name=token, sent as a bearer token; always write access
+		GitHubRepo     string        // owner/name whose permissions GitHub tokens get when a request names no repo
+		Repos          []string      // owner/name the API may act on; GitHub tokens are only authorized against these and GitHubRepo
+		Anonymous      string        // access for callers without credentials: none to require them, or read
+		GitHubLoginTTL time.Duration // how long a GitHub token's login is remembered
 	}
 	GitHub struct {
 		WebhookSecret string
 		Token         string
-		CoreTeam      []string
+		CoreTeam      []string      // users who can always deploy; /grant adds more at runtime
+		OpsRepo       string        // owner/name whose issues accept ops commands
+		Admins        []string      // users allowed to run ops commands
+		CacheTTL      time.Duration // how long API reads are served without revalidating
+		RateReserve   int           // remaining requests below which summary edits are skipped
+
+		CommentRetryBackoff  time.Duration // first wait before re-posting a comment GitHub refused
+		CommentRetryMax      time.Duration // longest wait between attempts
+		CommentRetryAttempts int           // attempts before a comment is left stuck for an admin
+
+		// A GitHub App whose installation token is preferred over Token,
+		// which is only used when the App can't mint one
+		AppID             int64
+		AppInstallationID int64
+		AppPrivateKey     string        // PEM, or a path to it
+		CredentialCheck   time.Duration // how often /readyz's credential health is refreshed
+		ExpiryWarning     time.Duration // alert when a credential expires sooner than this
+
+		// Open PRs of these owner/name repositories are checked on startup
+		// for deployment comments and check runs a restart left claiming
+		// work that is no longer happening
+		ReconcileRepos  []string
+		ReconcileMaxAge time.Duration // older comments are left alone
+
+		// Org-wide defaults for .pr-previews.yaml are read from this
+		// repository of each PR repo's owner, .github by convention, and
+		// every repo's own file is layered over them; empty disables
+		OrgConfigRepo string
+		OrgConfigPath string
+
+		// A check run of this name on each PR's head commit carries the last
+		// outcome of every command, E2E results and links to the deployment
+		// artifacts, so they outlive minimized or deleted comments; check
+		// runs need the GitHub App. Empty disables.
+		SummaryCheck string
+	}
+	AzureDevOps struct {
+		OrgURL       string // e.g. https://dev.azure.com/acme; empty disables the integration
+		Token        string // PAT or OAuth access token replies are posted with
+		Auth         string // pat or oauth
+		HookUsername string // basic auth the service hook is configured to send; required with OrgURL
+		HookPassword string
+	}
+	Locale struct {
+		Language string // bot response language, e.g. en, id
+		Dir      string // optional directory of <locale>.yaml language packs
+	}
+	Webhook struct {
+		BufferSize int // deliveries held before shedding load
+		Workers    int
+
+		HandleEdits  bool          // re-run commands from edited comments
+		EditDebounce time.Duration // quiet period before an edit is acted on
+
+		MaxAttempts   int           // tries before a failed delivery is dead-lettered
+		RetryBackoff  time.Duration // wait before the first retry, doubled after each
+		DeadLetterMax int           // failed deliveries kept for replay, oldest dropped first
+	}
+	Preview struct {
+		MaxReplicas   int32
+		ScalingOptOut []string // services that keep their HPAs and replica counts
+		MaxConcurrent int      // deployments allowed to run at once before queueing
+		PriorityBurst int      // extra concurrent slots for priority PRs
+		PriorityLabel string   // PR label that marks a PR as priority
+		Domain        string   // base domain for preview URLs
+		HostDomains   []string // other domains a repo's custom preview hosts may be under
+		NamingMode    string   // pr (pr-<n>-<service>) or branch (sticky <branch-slug>)
+		IngressClass  string
+		StagingNS     string        // target namespace for /promote
+		GCMaxAge      time.Duration // default age threshold for /gc
+		MaxTTL        time.Duration // longest TTL a deploy may ask for; 0 allows any
+		CleanupWait   time.Duration // how long /cleanup watches namespaces terminate; 0 doesn't wait
+		StuckAfter    time.Duration // Terminating longer than this counts as stuck
+		StuckInterval time.Duration // how often to look for stuck namespaces; 0 disables
+		ProgressEvery time.Duration // how often rollout progress is written to the summary; 0 disables
+		WaitMax       time.Duration // longest a /wait request may block
+
+		KubeconfigTTL time.Duration // lifetime of namespace-scoped debug tokens
+		APIServerURL  string        // API server address written into kubeconfigs
+
+		ScaleMaxReplicas int32 // most replicas /scale sets; larger requests are clamped
+
+		DescriptionLinks bool // keep a preview links section in the PR description
+		SyncCleanup      bool // delete previews of services a push removes from the PR
+
+		// A redeploy over a running per-service preview brings the new
+		// revision's Deployments up beside the live ones and switches the
+		// Services over once they're ready, instead of refusing until
+		// /cleanup; a revision not ready within SwapTimeout is abandoned.
+		// Off by default, since both revisions run at once.
+		BlueGreen   bool
+		SwapTimeout time.Duration
+
+		PodAlerts         bool          // comment on the PR when a preview pod is OOMKilled, evicted or crash-loops
+		PodAlertCooldown  time.Duration // least time between comments on the same container and failure
+		CrashLoopRestarts int           // restarts before a once-ready pod counts as crash-looping
+
+		ArchAffinity bool // pin workloads to the node architectures their images are built for on mixed-arch clusters
+
+		// Dry-run a manifest's resources through the cluster's admission
+		// webhooks before creating anything, and explain Gatekeeper, Kyverno
+		// and ValidatingAdmissionPolicy denials on the PR
+		AdmissionPreflight bool
+
+		// Previews nobody visited for ScaleToZeroAfter have their Deployments
+		// scaled to zero, and their Ingresses fall back to the bot, which
+		// shows a waking up page and scales them back on the next request.
+		// Needs access tracking, ActivatorHost, the host:port the cluster
+		// reaches the bot at, and ActivatorSources, the CIDRs the ingress
+		// controller connects from; wakeups from anywhere else are refused.
+		// 0 disables.
+		ScaleToZeroAfter time.Duration
+		ActivatorHost    string
+		ActivatorSources []string
+		WakeTimeout      time.Duration // longest the waking up page is shown before the preview's own errors are
+	}
+	E2E struct {
+		Dispatch     string        // repository_dispatch or workflow_dispatch; empty disables E2E triggers
+		EventType    string        // repository_dispatch event_type
+		Workflow     string        // workflow file for workflow_dispatch, e.g. e2e.yml; with repository_dispatch, runs of other workflows must name their check_run_id
+		CheckName    string        // check run the E2E result is reported on
+		ReadyTimeout time.Duration // how long a preview gets to become ready before E2E is skipped
+	}
+	PrePull struct {
+		Enabled      bool
+		NodeSelector []string // key=value labels of the preview node pool
+		Timeout      time.Duration
+		PauseImage   string // its static /bin/busybox idles the pre-pull containers
+	}
+	ImageGC struct {
+		Methods       []string      // node (crictl rmi via a DaemonSet) and/or registry (untag); empty disables
+		TagPattern    string        // regexp of PR-specific tags; {pr} is the PR number
+		Namespace     string        // where the privileged GC DaemonSet runs
+		Image         string        // GC container; crictl comes from the node via chroot
+		NodeSelector  []string      // key=value labels of nodes to clean
+		Timeout       time.Duration // wait for namespaces to go, then for the GC to finish
+		Registry      string        // registry host whose PR tags may be deleted
+		RegistryToken string        // bearer token for the registry API
+	}
+	Terraform struct {
+		Binary    string // terraform or tofu
+		ModuleDir string // repo directory holding the preview module
+	}
+	Helm struct {
+		Binary      string
+		Credentials []string      // name@host=username:password for private chart sources on host, referenced by name from .pr-previews.yaml
+		Timeout     time.Duration // chart download and templating
+	}
+	VCluster struct {
+		Binary       string
+		ChartVersion string        // vcluster chart to start previews with, empty for the CLI's default
+		Timeout      time.Duration // creating a virtual cluster and waiting for it
+	}
+	Operators struct {
+		// name=path of a YAML file or directory holding namespaced CRDs and
+		// the operator serving them, referenced by name from .pr-previews.yaml
+		Bundles   []string
+		Namespace string // where bundle objects without a namespace are installed
+	}
+	LoadTest struct {
+		MaxReplicas int32
+		MaxDuration time.Duration
+		Image       string
+		Rate        int // requests per second
+	}
+	Chaos struct {
+		Enabled     bool
+		ProxyImage  string // toxiproxy sidecar placed in front of the app
+		ClientImage string // image with sh and curl that configures the toxics
+		MaxLatency  time.Duration
+	}
+	Tap struct {
+		Enabled     bool
+		ProxyImage  string // envoy, put between the preview's Ingress and its Service while tapping
+		MaxDuration time.Duration
+	}
+	Share struct {
+		// Share links reach a preview through the bot's /share/ proxy, which
+		// talks to the preview's Service directly, so the bot must run in the
+		// cluster. The proxy only answers on Host, a hostname of its own
+		// routed to the bot, so previews never share an origin with the API.
+		Enabled       bool
+		Host          string
+		DefaultExpiry time.Duration
+		MaxExpiry     time.Duration
+	}
+	Canary struct {
+		// service=host pairs naming the base environment host whose traffic
+		// /canary splits; the controller must serve that host and support
+		// ingress-nginx canary annotations
+		BaseHosts    []string
+		IngressClass string // class of the base environment's Ingress; defaults to PREVIEW_INGRESS_CLASS
+		MaxWeight    int    // largest share of traffic, in percent, a preview may take
+	}
+	Access struct {
+		// Tracking has ingress-nginx mirror every preview request to the bot
+		// to count visits; needs SERVER_PUBLIC_URL
+		Tracking      bool
+		Secret        string        // signs the per-namespace beacon paths; required with Tracking
+		FlushInterval time.Duration // how often counts are saved to the artifact store
+		IdleAfter     time.Duration // /gc also deletes previews nobody visited for this long; 0 disables
+	}
+	Monitoring struct {
+		// URL templates; {namespace}, {service} and {pr} are substituted
+		GrafanaURLTemplate    string
+		PrometheusURLTemplate string
+	}
+	Metrics struct {
+		PushInterval   time.Duration
+		PushgatewayURL string // Prometheus pushgateway, e.g. http://pushgateway:9091
+		PushJob        string
+		StatsDAddr     string // host:port of a StatsD/DogStatsD agent
+		StatsDPrefix   string
+		StatsDTags     []string // DogStatsD tags such as env:prod
+	}
+	Vault struct {
+		Address       string
+		Token         string
+		RenewInterval time.Duration
+	}
+	Artifacts struct {
+		Backend     string // file or s3
+		Dir         string
+		S3Endpoint  string
+		S3Bucket    string
+		S3Region    string
+		S3AccessKey string
+		S3SecretKey string
+	}
+	Secrets struct {
+		SopsBinary string
+		AgeKeyFile string
+		KMSKeyARN  string
+	}
+	Report struct {
+		Schedule           string // cron expression, e.g. "0 9 * * 1"
+		GitHubIssue        string // owner/repo#number to comment on
+		SlackWebhookURL    string
+		CleanupURLTemplate string // dashboard link; {namespace}, {service} and {pr} are substituted
+		CPUHourCost        float64
+		MemoryGBHourCost   float64
+	}
+	Snapshots struct {
+		VolumeSnapshotClass string // empty uses the cluster default
+	}
+	Audit struct {
+		LogFile string // JSON lines; empty writes to stdout
+	}
+	GraphQL struct {
+		MaxDepth      int // deepest selection nesting a dashboard query may use
+		MaxComplexity int // fields per query, with list fields counted once per item
+	}
+	Debug struct {
+		Simulate        bool // serve /debug/simulate; never enable in production
+		SimulateHistory int  // simulated deliveries kept for inspection
+		Profiling       bool // serve /debug/pprof to admins
+	}
+	Timeline struct {
+		Retention  time.Duration // how long bot comments and logs are kept per PR; 0 disables
+		MaxEntries int           // newest entries kept per PR
+	}
+	Network struct {
+		IPFamilyPolicy string   // SingleStack, PreferDualStack or RequireDualStack; empty uses the cluster default
+		IPFamilies     []string // IPv4 and/or IPv6, primary first
+		IngressService string   // namespace/name of the ingress controller Service, checked for matching families
+	}
+	Namespaces struct {
+		QuotaCPU    string   // requests.cpu allowed per preview namespace; empty skips the quota
+		QuotaMemory string   // requests.memory allowed per preview namespace
+		Isolation   bool     // refuse traffic from other previews with a NetworkPolicy
+		PullSecret  string   // namespace/name of a registry secret copied into every preview
+		Labels      []string // key=value labels on every preview namespace and workload, e.g. cost-center=eng
+		Annotations []string // key=value annotations on every preview namespace and workload
+
+		// Label and annotation keys a repo's .pr-previews.yaml may set on
+		// its namespaces, e.g. team or example.com/*; the repo's other keys
+		// only go on workloads, so a PR can't relax Pod Security Admission
+		// or mesh injection for its namespace
+		RepoMetadataKeys []string
+	}
+	Tenants struct {
+		NamespacePrefixes []string // owner/repo=prefix or owner=prefix; replaces "preview" in the tenant's namespace names
+		QuotaCPU          string   // requests.cpu allowed across all previews of one repo; empty is unlimited
+		QuotaMemory       string   // requests.memory allowed across all previews of one repo
+		Quotas            []string // owner/repo=cpu/memory or owner=cpu/memory overrides
+	}
+	Maintenance struct {
+		Enabled bool   // pause new deployments from startup; /maintenance can't switch it off
+		Message string // reason shown on paused commands
+	}
+	WarmPool struct {
+		Size     int           // empty, prepared namespaces kept ready to claim; 0 disables the pool
+		Interval time.Duration // how often the pool is topped up
+	}
+	ClusterBreaker struct {
+		Threshold  int           // consecutive failed Kubernetes calls that trip the breaker; 0 disables it
+		MinBackoff time.Duration // first wait before probing the API again
+		MaxBackoff time.Duration // longest wait between probes
+		MaxHeld    int           // deploys and cleanups held for when the cluster is back
+	}
+	PodSecurity struct {
+		Level string // Pod Security Standard to enforce: privileged (off), baseline or restricted
+	}
+	Images struct {
+		// Registries and repositories preview containers may use, e.g.
+		// ghcr.io/myorg/*; empty allows any image not denied
+		Allow []string
+		Deny  []string // refused even when allowed
+	}
+	Policy struct {
+		Mode      string // enforce, warn or off
+		File      string // extra CEL rules; see services.PolicyRule
+		MaxCPU    string // largest CPU request allowed per pod
+		MaxMemory string // largest memory request allowed per pod
 	}
 }
 
@@ -18,9 +383,226 @@ func Load() *Config {
 	cfg := &Config{}
 	cfg.Server.Host = getEnv("SERVER_HOST", "0.0.0.0")
 	cfg.Server.Port = getEnv("SERVER_PORT", "8080")
+	cfg.Server.AdminToken = getEnv("ADMIN_API_TOKEN", "")
+	cfg.Server.ReadTimeout = getEnvDuration("SERVER_READ_TIMEOUT", 15*time.Second)
+	cfg.Server.WriteTimeout = getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second)
+	cfg.Server.IdleTimeout = getEnvDuration("SERVER_IDLE_TIMEOUT", 120*time.Second)
+	cfg.Server.ShutdownTimeout = getEnvDuration("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second)
+	cfg.Server.MaxHeaderBytes = getEnvInt("SERVER_MAX_HEADER_BYTES", 1<<20)
+	cfg.Server.MaxWebhookBytes = getEnvInt("SERVER_MAX_WEBHOOK_BYTES", 25<<20)
+	cfg.Server.TLSCertFile = getEnv("TLS_CERT_FILE", "")
+	cfg.Server.TLSKeyFile = getEnv("TLS_KEY_FILE", "")
+	cfg.Server.AutocertDomains = getEnvList("AUTOCERT_DOMAINS")
+	cfg.Server.AutocertCache = getEnv("AUTOCERT_CACHE_DIR", "./autocert-cache")
+	cfg.Server.H2C = getEnv("SERVER_H2C", "") == "true"
+	cfg.Server.PublicURL = strings.TrimRight(getEnv("SERVER_PUBLIC_URL", ""), "/")
+	hostname, _ := os.Hostname()
+	cfg.Server.Replica = getEnv("POD_NAME", hostname)
+	cfg.Server.Namespace = getEnv("POD_NAMESPACE", "")
+	cfg.Leader.Namespace = getEnv("LEADER_NAMESPACE", cfg.Server.Namespace)
+	cfg.Leader.Lease = getEnv("LEADER_LEASE", "pr-previews-leader")
+	cfg.Leader.LeaseDuration = getEnvDuration("LEADER_LEASE_DURATION", 15*time.Second)
+	cfg.AdminAuth.ClientCAFile = getEnv("ADMIN_CLIENT_CA_FILE", "")
+	cfg.AdminAuth.ClientCertMaxTTL = getEnvDuration("ADMIN_CLIENT_CERT_MAX_TTL", 24*time.Hour)
+	cfg.AdminAuth.OIDCIssuer = getEnv("ADMIN_OIDC_ISSUER", "")
+	cfg.AdminAuth.OIDCAudience = getEnv("ADMIN_OIDC_AUDIENCE", "")
+	cfg.AdminAuth.OIDCIdentityClaim = getEnv("ADMIN_OIDC_IDENTITY_CLAIM", "email")
+	cfg.AdminAuth.OIDCGroupsClaim = getEnv("ADMIN_OIDC_GROUPS_CLAIM", "groups")
+	cfg.AdminAuth.RoleBindings = getEnvList("ADMIN_ROLE_BINDINGS")
+	cfg.APIAuth.Providers = getEnvList("API_AUTH_PROVIDERS")
+	if len(cfg.APIAuth.Providers) == 0 {
+		cfg.APIAuth.Providers = []string{"api_key", "service_token", "github"}
+	}
+	cfg.APIAuth.APIKeys = getEnvList("API_KEYS")
+	cfg.APIAuth.ServiceTokens = getEnvList("API_SERVICE_TOKENS")
+	cfg.APIAuth.GitHubRepo = getEnv("API_GITHUB_REPO", "")
+	cfg.APIAuth.Repos = getEnvList("API_REPOS")
+	cfg.APIAuth.Anonymous = getEnv("API_ANONYMOUS_ACCESS", "none")
+	cfg.APIAuth.GitHubLoginTTL = getEnvDuration("API_GITHUB_LOGIN_TTL", 5*time.Minute)
 	cfg.GitHub.WebhookSecret = getEnv("GITHUB_WEBHOOK_SECRET", "")
 	cfg.GitHub.Token = getEnv("GITHUB_TOKEN", "")
-	cfg.GitHub.CoreTeam = []string{"abdullahainun"}
+	cfg.GitHub.CoreTeam = getEnvList("GITHUB_CORE_TEAM")
+	if len(cfg.GitHub.CoreTeam) == 0 {
+		cfg.GitHub.CoreTeam = []string{"abdullahainun"}
+	}
+	cfg.GitHub.OpsRepo = getEnv("GITHUB_OPS_REPO", "")
+	cfg.GitHub.Admins = getEnvList("GITHUB_ADMINS")
+	cfg.GitHub.CacheTTL = getEnvDuration("GITHUB_CACHE_TTL", time.Minute)
+	cfg.GitHub.RateReserve = getEnvInt("GITHUB_RATE_LIMIT_RESERVE", 500)
+	cfg.GitHub.CommentRetryBackoff = getEnvDuration("GITHUB_COMMENT_RETRY_BACKOFF", 30*time.Second)
+	cfg.GitHub.CommentRetryMax = getEnvDuration("GITHUB_COMMENT_RETRY_MAX", 30*time.Minute)
+	cfg.GitHub.CommentRetryAttempts = getEnvInt("GITHUB_COMMENT_RETRY_ATTEMPTS", 8)
+	cfg.GitHub.AppID = int64(getEnvInt("GITHUB_APP_ID", 0))
+	cfg.GitHub.AppInstallationID = int64(getEnvInt("GITHUB_APP_INSTALLATION_ID", 0))
+	cfg.GitHub.AppPrivateKey = getEnv("GITHUB_APP_PRIVATE_KEY", "")
+	cfg.GitHub.CredentialCheck = getEnvDuration("GITHUB_CREDENTIAL_CHECK_INTERVAL", 15*time.Minute)
+	cfg.GitHub.ExpiryWarning = getEnvDuration("GITHUB_CREDENTIAL_EXPIRY_WARNING", 7*24*time.Hour)
+	cfg.GitHub.ReconcileRepos = getEnvList("GITHUB_RECONCILE_REPOS")
+	cfg.GitHub.ReconcileMaxAge = getEnvDuration("GITHUB_RECONCILE_MAX_AGE", 24*time.Hour)
+	cfg.GitHub.OrgConfigRepo = getEnv("GITHUB_ORG_CONFIG_REPO", ".github")
+	cfg.GitHub.OrgConfigPath = getEnv("GITHUB_ORG_CONFIG_PATH", ".pr-previews.yaml")
+	cfg.GitHub.SummaryCheck = getEnv("GITHUB_SUMMARY_CHECK", "")
+	cfg.AzureDevOps.OrgURL = strings.TrimRight(getEnv("AZURE_DEVOPS_ORG_URL", ""), "/")
+	cfg.AzureDevOps.Token = getEnv("AZURE_DEVOPS_TOKEN", "")
+	cfg.AzureDevOps.Auth = getEnv("AZURE_DEVOPS_AUTH", "pat")
+	cfg.AzureDevOps.HookUsername = getEnv("AZURE_DEVOPS_HOOK_USERNAME", "")
+	cfg.AzureDevOps.HookPassword = getEnv("AZURE_DEVOPS_HOOK_PASSWORD", "")
+	cfg.Locale.Language = getEnv("BOT_LOCALE", "en")
+	cfg.Locale.Dir = getEnv("BOT_LOCALE_DIR", "")
+	cfg.Webhook.BufferSize = getEnvInt("WEBHOOK_BUFFER_SIZE", 100)
+	cfg.Webhook.Workers = getEnvInt("WEBHOOK_WORKERS", 4)
+	cfg.Webhook.HandleEdits = getEnv("WEBHOOK_HANDLE_EDITS", "") == "true"
+	cfg.Webhook.EditDebounce = getEnvDuration("WEBHOOK_EDIT_DEBOUNCE", 10*time.Second)
+	cfg.Webhook.MaxAttempts = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 3)
+	cfg.Webhook.RetryBackoff = getEnvDuration("WEBHOOK_RETRY_BACKOFF", 5*time.Second)
+	cfg.Webhook.DeadLetterMax = getEnvInt("WEBHOOK_DEAD_LETTER_MAX", 500)
+	cfg.Preview.MaxReplicas = int32(getEnvInt("PREVIEW_MAX_REPLICAS", 1))
+	cfg.Preview.ScalingOptOut = getEnvList("PREVIEW_SCALING_OPT_OUT")
+	cfg.Preview.HostDomains = getEnvList("PREVIEW_HOST_DOMAINS")
+	cfg.Preview.ScaleMaxReplicas = int32(getEnvInt("SCALE_MAX_REPLICAS", 3))
+	cfg.Preview.MaxConcurrent = getEnvInt("PREVIEW_MAX_CONCURRENT", 3)
+	cfg.Preview.PriorityBurst = getEnvInt("PREVIEW_PRIORITY_BURST", 1)
+	cfg.Preview.PriorityLabel = getEnv("PREVIEW_PRIORITY_LABEL", "preview-priority")
+	cfg.Preview.Domain = getEnv("PREVIEW_DOMAIN", "preview.example.com")
+	cfg.Preview.NamingMode = getEnv("PREVIEW_NAMING_MODE", "pr")
+	cfg.Preview.IngressClass = getEnv("PREVIEW_INGRESS_CLASS", "")
+	cfg.Preview.StagingNS = getEnv("STAGING_NAMESPACE", "staging")
+	cfg.Preview.GCMaxAge = getEnvDuration("PREVIEW_GC_MAX_AGE", 7*24*time.Hour)
+	cfg.Preview.MaxTTL = getEnvDuration("PREVIEW_MAX_TTL", 30*24*time.Hour)
+	cfg.Preview.CleanupWait = getEnvDuration("PREVIEW_CLEANUP_WAIT", 10*time.Minute)
+	cfg.Preview.StuckAfter = getEnvDuration("PREVIEW_STUCK_AFTER", 30*time.Minute)
+	cfg.Preview.StuckInterval = getEnvDuration("PREVIEW_STUCK_INTERVAL", 5*time.Minute)
+	cfg.Preview.ProgressEvery = getEnvDuration("PREVIEW_PROGRESS_INTERVAL", 15*time.Second)
+	cfg.Preview.WaitMax = getEnvDuration("PREVIEW_WAIT_MAX", 15*time.Minute)
+	cfg.Preview.KubeconfigTTL = getEnvDuration("PREVIEW_KUBECONFIG_TTL", time.Hour)
+	cfg.Preview.APIServerURL = getEnv("PREVIEW_API_SERVER_URL", "")
+	cfg.Preview.DescriptionLinks = getEnv("PREVIEW_DESCRIPTION_LINKS", "") == "true"
+	cfg.Preview.SyncCleanup = getEnv("PREVIEW_SYNC_CLEANUP", "true") == "true"
+	cfg.Preview.BlueGreen = getEnv("PREVIEW_BLUE_GREEN", "") == "true"
+	cfg.Preview.SwapTimeout = getEnvDuration("PREVIEW_SWAP_TIMEOUT", 10*time.Minute)
+	cfg.Preview.PodAlerts = getEnv("PREVIEW_POD_ALERTS", "true") == "true"
+	cfg.Preview.PodAlertCooldown = getEnvDuration("PREVIEW_POD_ALERT_COOLDOWN", 30*time.Minute)
+	cfg.Preview.CrashLoopRestarts = getEnvInt("PREVIEW_CRASHLOOP_RESTARTS", 3)
+	cfg.Preview.ArchAffinity = getEnv("PREVIEW_ARCH_AFFINITY", "true") == "true"
+	cfg.Preview.AdmissionPreflight = getEnv("PREVIEW_ADMISSION_PREFLIGHT", "true") == "true"
+	cfg.Preview.ScaleToZeroAfter = getEnvDuration("PREVIEW_SCALE_TO_ZERO_AFTER", 0)
+	cfg.Preview.ActivatorHost = getEnv("PREVIEW_ACTIVATOR_HOST", "")
+	cfg.Preview.ActivatorSources = getEnvList("PREVIEW_ACTIVATOR_SOURCES")
+	cfg.Preview.WakeTimeout = getEnvDuration("PREVIEW_WAKE_TIMEOUT", 10*time.Minute)
+	cfg.E2E.Dispatch = getEnv("E2E_DISPATCH", "")
+	cfg.E2E.EventType = getEnv("E2E_EVENT_TYPE", "preview-ready")
+	cfg.E2E.Workflow = getEnv("E2E_WORKFLOW", "")
+	cfg.E2E.CheckName = getEnv("E2E_CHECK_NAME", "Preview E2E")
+	cfg.E2E.ReadyTimeout = getEnvDuration("E2E_READY_TIMEOUT", 10*time.Minute)
+	cfg.PrePull.Enabled = getEnv("PREPULL_IMAGES", "") == "true"
+	cfg.PrePull.NodeSelector = getEnvList("PREPULL_NODE_SELECTOR")
+	cfg.PrePull.Timeout = getEnvDuration("PREPULL_TIMEOUT", 5*time.Minute)
+	cfg.PrePull.PauseImage = getEnv("PREPULL_PAUSE_IMAGE", "busybox:1.36")
+	cfg.ImageGC.Methods = getEnvList("IMAGE_GC_METHODS")
+	cfg.ImageGC.TagPattern = getEnv("IMAGE_GC_TAG_PATTERN", `^pr-{pr}(-.+)?$`)
+	cfg.ImageGC.Namespace = getEnv("IMAGE_GC_NAMESPACE", "kube-system")
+	cfg.ImageGC.Image = getEnv("IMAGE_GC_IMAGE", "busybox:1.36")
+	cfg.ImageGC.NodeSelector = getEnvList("IMAGE_GC_NODE_SELECTOR")
+	cfg.ImageGC.Timeout = getEnvDuration("IMAGE_GC_TIMEOUT", 15*time.Minute)
+	cfg.ImageGC.Registry = getEnv("IMAGE_GC_REGISTRY", "")
+	cfg.ImageGC.RegistryToken = getEnv("IMAGE_GC_REGISTRY_TOKEN", "")
+	cfg.Terraform.Binary = getEnv("TERRAFORM_BINARY", "terraform")
+	cfg.Terraform.ModuleDir = getEnv("TERRAFORM_MODULE_DIR", "preview")
+	cfg.Helm.Binary = getEnv("HELM_BINARY", "helm")
+	cfg.Helm.Credentials = getEnvList("HELM_CREDENTIALS")
+	cfg.Helm.Timeout = getEnvDuration("HELM_TIMEOUT", 2*time.Minute)
+	cfg.VCluster.Binary = getEnv("VCLUSTER_BINARY", "vcluster")
+	cfg.VCluster.ChartVersion = getEnv("VCLUSTER_CHART_VERSION", "")
+	cfg.VCluster.Timeout = getEnvDuration("VCLUSTER_TIMEOUT", 5*time.Minute)
+	cfg.Operators.Bundles = getEnvList("OPERATOR_BUNDLES")
+	cfg.Operators.Namespace = getEnv("OPERATOR_NAMESPACE", "pr-previews-operators")
+	cfg.LoadTest.MaxReplicas = int32(getEnvInt("LOADTEST_MAX_REPLICAS", 5))
+	cfg.LoadTest.MaxDuration = getEnvDuration("LOADTEST_MAX_DURATION", 30*time.Minute)
+	cfg.LoadTest.Image = getEnv("LOADTEST_IMAGE", "peterevans/vegeta:latest")
+	cfg.LoadTest.Rate = getEnvInt("LOADTEST_RATE", 50)
+	cfg.Chaos.Enabled = getEnv("CHAOS_ENABLED", "") == "true"
+	cfg.Chaos.ProxyImage = getEnv("CHAOS_PROXY_IMAGE", "ghcr.io/shopify/toxiproxy:2.9.0")
+	cfg.Chaos.ClientImage = getEnv("CHAOS_CLIENT_IMAGE", "curlimages/curl:8.8.0")
+	cfg.Chaos.MaxLatency = getEnvDuration("CHAOS_MAX_LATENCY", 10*time.Second)
+	cfg.Tap.Enabled = getEnv("TAP_ENABLED", "") == "true"
+	cfg.Tap.ProxyImage = getEnv("TAP_PROXY_IMAGE", "envoyproxy/envoy:v1.31.2")
+	cfg.Tap.MaxDuration = getEnvDuration("TAP_MAX_DURATION", 5*time.Minute)
+	cfg.Share.Enabled = getEnv("SHARE_ENABLED", "") == "true"
+	cfg.Share.Host = getEnv("SHARE_HOST", "")
+	cfg.Share.DefaultExpiry = getEnvDuration("SHARE_DEFAULT_EXPIRY", 24*time.Hour)
+	cfg.Share.MaxExpiry = getEnvDuration("SHARE_MAX_EXPIRY", 7*24*time.Hour)
+	cfg.Canary.BaseHosts = getEnvList("CANARY_BASE_HOSTS")
+	cfg.Canary.IngressClass = getEnv("CANARY_INGRESS_CLASS", cfg.Preview.IngressClass)
+	cfg.Canary.MaxWeight = getEnvInt("CANARY_MAX_WEIGHT", 50)
+	cfg.Access.Tracking = getEnv("ACCESS_TRACKING", "") == "true"
+	cfg.Access.Secret = getEnv("ACCESS_SECRET", "")
+	cfg.Access.FlushInterval = getEnvDuration("ACCESS_FLUSH_INTERVAL", time.Minute)
+	cfg.Access.IdleAfter = getEnvDuration("ACCESS_IDLE_AFTER", 0)
+	cfg.Monitoring.GrafanaURLTemplate = getEnv("GRAFANA_URL_TEMPLATE", "")
+	cfg.Monitoring.PrometheusURLTemplate = getEnv("PROMETHEUS_URL_TEMPLATE", "")
+	cfg.Metrics.PushInterval = getEnvDuration("METRICS_PUSH_INTERVAL", 30*time.Second)
+	cfg.Metrics.PushgatewayURL = strings.TrimRight(getEnv("METRICS_PUSHGATEWAY_URL", ""), "/")
+	cfg.Metrics.PushJob = getEnv("METRICS_PUSH_JOB", "pr-previews")
+	cfg.Metrics.StatsDAddr = getEnv("METRICS_STATSD_ADDR", "")
+	cfg.Metrics.StatsDPrefix = getEnv("METRICS_STATSD_PREFIX", "pr_previews.")
+	cfg.Metrics.StatsDTags = getEnvList("METRICS_STATSD_TAGS")
+	cfg.Vault.Address = getEnv("VAULT_ADDR", "")
+	cfg.Vault.Token = getEnv("VAULT_TOKEN", "")
+	cfg.Vault.RenewInterval = getEnvDuration("VAULT_RENEW_INTERVAL", 5*time.Minute)
+	cfg.Artifacts.Backend = getEnv("ARTIFACT_BACKEND", "file")
+	cfg.Artifacts.Dir = getEnv("ARTIFACT_DIR", "./artifacts")
+	cfg.Artifacts.S3Endpoint = getEnv("ARTIFACT_S3_ENDPOINT", "https://s3.amazonaws.com")
+	cfg.Artifacts.S3Bucket = getEnv("ARTIFACT_S3_BUCKET", "")
+	cfg.Artifacts.S3Region = getEnv("ARTIFACT_S3_REGION", "us-east-1")
+	cfg.Artifacts.S3AccessKey = getEnv("ARTIFACT_S3_ACCESS_KEY", "")
+	cfg.Artifacts.S3SecretKey = getEnv("ARTIFACT_S3_SECRET_KEY", "")
+	cfg.Secrets.SopsBinary = getEnv("SOPS_BINARY", "sops")
+	cfg.Secrets.AgeKeyFile = getEnv("SOPS_AGE_KEY_FILE", "")
+	cfg.Secrets.KMSKeyARN = getEnv("SOPS_KMS_ARN", "")
+	cfg.Report.Schedule = getEnv("REPORT_SCHEDULE", "0 9 * * 1")
+	cfg.Report.GitHubIssue = getEnv("REPORT_GITHUB_ISSUE", "")
+	cfg.Report.SlackWebhookURL = getEnv("REPORT_SLACK_WEBHOOK_URL", "")
+	cfg.Report.CleanupURLTemplate = getEnv("REPORT_CLEANUP_URL_TEMPLATE", "")
+	cfg.Report.CPUHourCost = getEnvFloat("COST_PER_CPU_HOUR", 0.04)
+	cfg.Report.MemoryGBHourCost = getEnvFloat("COST_PER_GB_HOUR", 0.005)
+	cfg.Snapshots.VolumeSnapshotClass = getEnv("VOLUME_SNAPSHOT_CLASS", "")
+	cfg.Audit.LogFile = getEnv("AUDIT_LOG_FILE", "")
+	cfg.GraphQL.MaxDepth = getEnvInt("GRAPHQL_MAX_DEPTH", 6)
+	cfg.GraphQL.MaxComplexity = getEnvInt("GRAPHQL_MAX_COMPLEXITY", 5000)
+	cfg.Debug.Simulate = getEnv("DEBUG_SIMULATE", "") == "true"
+	cfg.Debug.SimulateHistory = getEnvInt("DEBUG_SIMULATE_HISTORY", 50)
+	cfg.Debug.Profiling = getEnv("DEBUG_PPROF", "") == "true"
+	cfg.Timeline.Retention = getEnvDuration("TIMELINE_RETENTION", 30*24*time.Hour)
+	cfg.Timeline.MaxEntries = getEnvInt("TIMELINE_MAX_ENTRIES", 500)
+	cfg.Network.IPFamilyPolicy = getEnv("SERVICE_IP_FAMILY_POLICY", "")
+	cfg.Network.IPFamilies = getEnvList("SERVICE_IP_FAMILIES")
+	cfg.Network.IngressService = getEnv("INGRESS_CONTROLLER_SERVICE", "")
+	cfg.Namespaces.QuotaCPU = getEnv("NAMESPACE_QUOTA_CPU", "")
+	cfg.Namespaces.QuotaMemory = getEnv("NAMESPACE_QUOTA_MEMORY", "")
+	cfg.Namespaces.Isolation = getEnv("NAMESPACE_ISOLATION", "") == "true"
+	cfg.Namespaces.PullSecret = getEnv("NAMESPACE_PULL_SECRET", "")
+	cfg.Namespaces.Labels = getEnvList("NAMESPACE_LABELS")
+	cfg.Namespaces.Annotations = getEnvList("NAMESPACE_ANNOTATIONS")
+	cfg.Namespaces.RepoMetadataKeys = getEnvList("NAMESPACE_REPO_METADATA_KEYS")
+	cfg.Tenants.NamespacePrefixes = getEnvList("TENANT_NAMESPACE_PREFIXES")
+	cfg.Tenants.QuotaCPU = getEnv("REPO_QUOTA_CPU", "")
+	cfg.Tenants.QuotaMemory = getEnv("REPO_QUOTA_MEMORY", "")
+	cfg.Tenants.Quotas = getEnvList("REPO_QUOTAS")
+	cfg.Maintenance.Enabled = getEnv("MAINTENANCE_MODE", "") == "true"
+	cfg.Maintenance.Message = getEnv("MAINTENANCE_MESSAGE", "")
+	cfg.WarmPool.Size = getEnvInt("WARM_POOL_SIZE", 0)
+	cfg.WarmPool.Interval = getEnvDuration("WARM_POOL_INTERVAL", time.Minute)
+	cfg.ClusterBreaker.Threshold = getEnvInt("CLUSTER_BREAKER_THRESHOLD", 3)
+	cfg.ClusterBreaker.MinBackoff = getEnvDuration("CLUSTER_BREAKER_MIN_BACKOFF", 5*time.Second)
+	cfg.ClusterBreaker.MaxBackoff = getEnvDuration("CLUSTER_BREAKER_MAX_BACKOFF", 2*time.Minute)
+	cfg.ClusterBreaker.MaxHeld = getEnvInt("CLUSTER_BREAKER_MAX_HELD", 20)
+	cfg.PodSecurity.Level = getEnv("PREVIEW_POD_SECURITY_LEVEL", "baseline")
+	cfg.Images.Allow = getEnvList("PREVIEW_IMAGE_ALLOW")
+	cfg.Images.Deny = getEnvList("PREVIEW_IMAGE_DENY")
+	cfg.Policy.Mode = getEnv("POLICY_MODE", "enforce")
+	cfg.Policy.File = getEnv("POLICY_FILE", "")
+	cfg.Policy.MaxCPU = getEnv("POLICY_MAX_CPU", "4")
+	cfg.Policy.MaxMemory = getEnv("POLICY_MAX_MEMORY", "8Gi")
 	return cfg
 }
 
@@ -30,3 +612,34 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
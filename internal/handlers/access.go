@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+// RecordPreviewAccess counts a request ingress-nginx mirrored from a
+// preview. Mirrors keep the original method, so any method is accepted, and
+// nginx discards the response.
+func (h *Handler) RecordPreviewAccess(c *gin.Context) {
+	if !h.config.Access.Tracking {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	tracker := services.SharedAccessTracker()
+	namespace := c.Param("namespace")
+	if !tracker.Verify(namespace, c.Param("signature")) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+	tracker.Record(namespace)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// AdminAuth guards admin endpoints with a client certificate, an OIDC
+// bearer token or ADMIN_API_TOKEN. The caller's identity and role are stored
+// on the context for RequireAdminRole and the audit log.
+func (h *Handler) AdminAuth(c *gin.Context) {
+	identity, err := h.adminAuth.Authenticate(c.Request)
+	if err != nil || identity == nil {
+		h.respondError(c, http.StatusUnauthorized, "Admin authentication required", err)
+		c.Abort()
+		return
+	}
+	if identity.Role == "" {
+		h.respondError(c, http.StatusForbidden, fmt.Sprintf("%s has no admin role", identity.Subject), nil)
+		c.Abort()
+		return
+	}
+
+	c.Set("user", identity.Subject)
+	c.Set("admin_role", identity.Role)
+	c.Next()
+}
+
+// RequireAdminRole rejects admin callers below role
+func (h *Handler) RequireAdminRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.AdminRoleAllows(c.GetString("admin_role"), role) {
+			h.respondError(c, http.StatusForbidden, fmt.Sprintf("Requires the %s role", role), nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func (h *Handler) ListQueue(c *gin.Context) {
+	response := types.Response{
+		Success:   true,
+		Message:   "Deployment queue",
+		Timestamp: time.Now(),
+		Data:      h.queue.Snapshot(),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) CancelQueueJob(c *gin.Context) {
+	jobID := c.Param("id")
+	if err := h.queue.Cancel(jobID); err != nil {
+		h.respondError(c, http.StatusNotFound, "Failed to cancel job", err)
+		return
+	}
+	h.audit.Record("queue.cancel", c.GetString("user"), "", 0, map[string]interface{}{
+		"job_id": jobID,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Job cancelled",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"job_id": jobID,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) ReprioritizeQueueJob(c *gin.Context) {
+	var request struct {
+		Priority int `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	jobID := c.Param("id")
+	if err := h.queue.SetPriority(jobID, request.Priority); err != nil {
+		h.respondError(c, http.StatusNotFound, "Failed to reprioritize job", err)
+		return
+	}
+	h.audit.Record("queue.reprioritize", c.GetString("user"), "", 0, map[string]interface{}{
+		"job_id":   jobID,
+		"priority": request.Priority,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Job reprioritized",
+		Timestamp: time.Now(),
+		Data:      h.queue.Snapshot(),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RunPreviewReport posts the active preview report now instead of waiting
+// for REPORT_SCHEDULE
+func (h *Handler) RunPreviewReport(c *gin.Context) {
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to create K8s service", err)
+		return
+	}
+
+	report, err := cmdService.PostPreviewReport(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusBadGateway, "Failed to post preview report", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview report posted",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"report": report,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListStuckNamespaces returns preview namespaces stuck in Terminating, from
+// the last background check or a fresh one with ?refresh=true
+func (h *Handler) ListStuckNamespaces(c *gin.Context) {
+	if c.Query("refresh") == "true" {
+		if err := h.stuck.Check(c.Request.Context()); err != nil {
+			h.respondError(c, http.StatusBadGateway, "Failed to check namespaces", err)
+			return
+		}
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Stuck namespaces",
+		Timestamp: time.Now(),
+		Data:      h.stuck.Snapshot(),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ForceCleanupNamespace clears the finalizers of a stuck preview namespace
+func (h *Handler) ForceCleanupNamespace(c *gin.Context) {
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to create K8s service", err)
+		return
+	}
+
+	namespace := c.Param("name")
+	blocker, err := cmdService.ForceCleanupNamespace(c.Request.Context(), namespace)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Failed to force-clean namespace", err)
+		return
+	}
+	h.audit.Record("namespace.force_cleanup", c.GetString("user"), "", 0, map[string]interface{}{
+		"namespace":  namespace,
+		"finalizers": blocker.Finalizers,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Namespace force-cleaned",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"namespace": namespace,
+			"blocker":   blocker,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListPendingComments returns the comments GitHub refused that are waiting
+// for a retry, and the stuck ones that ran out of attempts
+func (h *Handler) ListPendingComments(c *gin.Context) {
+	comments, err := services.SharedCommentOutbox().List(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to load pending comments", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Pending comments",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"comments": comments,
+			"stats":    services.SharedCommentOutbox().Stats(),
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RetryPendingComment posts a pending comment now, e.g. once the token has
+// the missing scope
+func (h *Handler) RetryPendingComment(c *gin.Context) {
+	comment, err := services.SharedCommentOutbox().Retry(c.Request.Context(), h.github, c.Param("id"))
+	if errors.Is(err, services.ErrCommentClaimed) {
+		h.respondError(c, http.StatusConflict, "Comment is being posted", err)
+		return
+	}
+	if comment == nil && err != nil {
+		h.respondError(c, http.StatusNotFound, "Pending comment not found", err)
+		return
+	}
+	if err != nil {
+		h.respondError(c, http.StatusBadGateway, "Failed to post comment", err)
+		return
+	}
+	h.audit.Record("comment.retry", c.GetString("user"), comment.Repo, comment.PRNumber, map[string]interface{}{
+		"comment":  comment.ID,
+		"attempts": comment.Attempts,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Comment posted",
+		Timestamp: time.Now(),
+		Data:      comment,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// DiscardPendingComment drops a pending comment without posting it
+func (h *Handler) DiscardPendingComment(c *gin.Context) {
+	comment, err := services.SharedCommentOutbox().Discard(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Pending comment not found", err)
+		return
+	}
+	h.audit.Record("comment.discard", c.GetString("user"), comment.Repo, comment.PRNumber, map[string]interface{}{
+		"comment":    comment.ID,
+		"attempts":   comment.Attempts,
+		"last_error": comment.LastError,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Pending comment discarded",
+		Timestamp: time.Now(),
+		Data:      comment,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListDeployers returns the core team and the users granted at runtime
+func (h *Handler) ListDeployers(c *gin.Context) {
+	coreTeam, granted, err := h.team.List(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to load deployers", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Deployers",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"core_team": coreTeam,
+			"granted":   granted,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GrantDeployer gives a GitHub user deploy rights
+func (h *Handler) GrantDeployer(c *gin.Context) {
+	var request struct {
+		Login string `json:"login" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	login, err := h.github.ResolveUser(c.Request.Context(), strings.TrimPrefix(request.Login, "@"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Unknown GitHub user", err)
+		return
+	}
+	deployer, err := h.team.Grant(c.Request.Context(), login, c.GetString("user"))
+	if err != nil {
+		h.respondError(c, http.StatusConflict, "Failed to grant deployer", err)
+		return
+	}
+	h.audit.Record("team.grant", c.GetString("user"), "", 0, map[string]interface{}{
+		"login": login,
+		"role":  "deployer",
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Deployer granted",
+		Timestamp: time.Now(),
+		Data:      deployer,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeDeployer removes a runtime grant
+func (h *Handler) RevokeDeployer(c *gin.Context) {
+	deployer, err := h.team.Revoke(c.Request.Context(), c.Param("login"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Failed to revoke deployer", err)
+		return
+	}
+	h.audit.Record("team.revoke", c.GetString("user"), "", 0, map[string]interface{}{
+		"login": deployer.Login,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Deployer revoked",
+		Timestamp: time.Now(),
+		Data:      deployer,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetMaintenance reports whether new deployments are paused
+func (h *Handler) GetMaintenance(c *gin.Context) {
+	response := types.Response{
+		Success:   true,
+		Message:   "Maintenance state",
+		Timestamp: time.Now(),
+		Data:      h.maintenance.Status(c.Request.Context()),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetMaintenance pauses or resumes new deployments
+func (h *Handler) SetMaintenance(c *gin.Context) {
+	var request struct {
+		Enabled *bool  `json:"enabled" binding:"required"`
+		Reason  string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+
+	state, err := h.maintenance.Set(c.Request.Context(), *request.Enabled, strings.TrimSpace(request.Reason), c.GetString("user"))
+	if err != nil {
+		h.respondError(c, http.StatusConflict, "Failed to switch maintenance mode", err)
+		return
+	}
+	action := "maintenance.off"
+	if *request.Enabled {
+		action = "maintenance.on"
+	}
+	h.audit.Record(action, c.GetString("user"), "", 0, map[string]interface{}{
+		"reason": state.Reason,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Maintenance mode switched",
+		Timestamp: time.Now(),
+		Data:      state,
+	}
+	c.JSON(http.StatusOK, response)
+}
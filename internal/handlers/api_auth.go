@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+// APIAuth identifies REST API callers through the API_AUTH_PROVIDERS chain.
+// GitHub tokens are checked against the repository in ?repo=, if the request
+// names one. The caller and their access level are stored on the context for
+// RequireAPIAccess, and the repository they were authorized on for apiRepo.
+func (h *Handler) APIAuth(c *gin.Context) {
+	identity, err := h.apiAuth.Authenticate(c.Request, c.Query("repo"))
+	if err != nil || identity == nil {
+		h.respondError(c, http.StatusUnauthorized, "API authentication required", err)
+		c.Abort()
+		return
+	}
+
+	c.Set("user", identity.Subject)
+	c.Set("api_access", identity.Access)
+	c.Set("api_repo", identity.Repo)
+	c.Next()
+}
+
+// RequireAPIAccess rejects API callers below access
+func (h *Handler) RequireAPIAccess(access string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.APIAccessAllows(c.GetString("api_access"), access) {
+			status := http.StatusForbidden
+			if c.GetString("user") == "anonymous" {
+				status = http.StatusUnauthorized
+			}
+			h.respondError(c, status, fmt.Sprintf("Requires %s access", access), nil)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+func (h *Handler) ListArtifacts(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifact storage is not configured", nil)
+		return
+	}
+
+	keys, err := h.artifacts.List(c.Request.Context(), fmt.Sprintf("pr-%d/", prNumber))
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list artifacts", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Deployment artifacts",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"pr_number": prNumber,
+			"artifacts": keys,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) GetArtifact(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifact storage is not configured", nil)
+		return
+	}
+
+	key := fmt.Sprintf("pr-%d/%s", prNumber, strings.TrimPrefix(c.Param("key"), "/"))
+	content, err := h.artifacts.Get(c.Request.Context(), key)
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Artifact not found", err)
+		return
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasSuffix(key, ".json") {
+		contentType = "application/json"
+	}
+	c.Data(http.StatusOK, contentType, content)
+}
+
+func (h *Handler) ListSnapshots(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	if h.artifacts == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Artifact storage is not configured", nil)
+		return
+	}
+
+	snapshots, err := services.ListSnapshots(c.Request.Context(), h.artifacts, prNumber)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list snapshots", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview snapshots",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"pr_number": prNumber,
+			"snapshots": snapshots,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetTimeline returns what the bot did and said on a PR, oldest first.
+// ?repo= narrows it to one repository.
+func (h *Handler) GetTimeline(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	if h.timeline == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Timeline retention is not configured", nil)
+		return
+	}
+
+	entries, err := h.timeline.Entries(c.Request.Context(), apiRepoFilter(c), prNumber)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to read timeline", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview timeline",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"pr_number": prNumber,
+			"retention": h.config.Timeline.Retention.String(),
+			"entries":   entries,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
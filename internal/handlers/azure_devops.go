@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// azureDevOpsStatsEvent names Azure DevOps deliveries in the webhook stats
+const azureDevOpsStatsEvent = "azure-devops.pullrequest-comment"
+
+// AzureDevOpsWebhook receives the "Pull request commented on" service hook.
+// Commands run like GitHub comments and are answered in the comment's thread.
+// Authors are identified by their uniqueName (usually an email), which is
+// what GITHUB_CORE_TEAM, /grant and GITHUB_ADMINS must list for them.
+func (h *Handler) AzureDevOpsWebhook(c *gin.Context) {
+	if h.azureDevOps == nil {
+		h.respondError(c, http.StatusNotFound, "Azure DevOps integration is not configured", nil)
+		return
+	}
+	if !h.validAzureDevOpsHookAuth(c) {
+		c.Header("WWW-Authenticate", `Basic realm="pr-previews"`)
+		h.respondError(c, http.StatusUnauthorized, "Invalid service hook credentials", nil)
+		return
+	}
+
+	body, ok := h.readWebhookBody(c)
+	if !ok {
+		return
+	}
+
+	comment, ok := services.ParseAzureDevOpsComment(body)
+	if !ok || !strings.HasPrefix(strings.TrimSpace(comment.Body), "/") {
+		h.webhookStats.Record(comment.Repo, azureDevOpsStatsEvent, "", services.WebhookIgnored)
+		response := types.Response{
+			Success:   true,
+			Message:   "Event ignored",
+			Timestamp: time.Now(),
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	letter := services.DeadLetter{
+		Source:   services.DeadLetterAzureDevOps,
+		Event:    azureDevOpsStatsEvent,
+		Repo:     comment.Repo,
+		PRNumber: comment.PRNumber,
+		Payload:  body,
+	}
+	accepted := h.webhooks.Submit(func(ctx context.Context) {
+		outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+			return h.processAzureDevOpsComment(ctx, comment)
+		})
+		h.webhookStats.Record(comment.Repo, azureDevOpsStatsEvent, "", outcome)
+	})
+	if !accepted {
+		h.webhookStats.Record(comment.Repo, azureDevOpsStatsEvent, "", services.WebhookError)
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Webhook accepted for processing",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"repo":      comment.Repo,
+			"pr_number": comment.PRNumber,
+			"thread_id": comment.ThreadID,
+			"user":      comment.User,
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// validAzureDevOpsHookAuth checks the basic auth the service hook sends;
+// without configured credentials nothing is accepted
+func (h *Handler) validAzureDevOpsHookAuth(c *gin.Context) bool {
+	expectedUser := h.config.AzureDevOps.HookUsername
+	if expectedUser == "" || h.config.AzureDevOps.HookPassword == "" {
+		return false
+	}
+	user, password, ok := c.Request.BasicAuth()
+	return ok &&
+		subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(h.config.AzureDevOps.HookPassword)) == 1
+}
+
+// processAzureDevOpsComment runs a buffered Azure DevOps comment command and
+// replies in its thread. Only a command that never ran is retried; a lost
+// reply is dead-lettered straight away, as there's no outbox for threads.
+func (h *Handler) processAzureDevOpsComment(ctx context.Context, comment services.AzureDevOpsComment) deliveryResult {
+	basicService := services.NewCommandService(h.lang, h.team)
+	cmd, err := basicService.ParseCommand(comment.Body, comment.User, comment.PRNumber)
+	if err != nil {
+		fmt.Printf("Ignoring comment on %s!%d: %v\n", comment.Repo, comment.PRNumber, err)
+		return deliveryResult{Outcome: services.WebhookIgnored}
+	}
+	cmd.Repo = comment.Repo
+	if services.ValidateBranchName(comment.Branch) == nil {
+		cmd.Branch = comment.Branch
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		fmt.Printf("Failed to process /%s on %s!%d: %v\n", cmd.Type, comment.Repo, comment.PRNumber, err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err, Retryable: true}
+	}
+	cmdService.WithCommenter(h.azureDevOps)
+
+	cmdResponse := h.dispatchCommand(ctx, cmdService, basicService, cmd)
+	cmdService.RecordCommand(ctx, cmd, comment.Body, cmdResponse)
+	if cmdResponse.Content == "" && cmdResponse.Result == nil {
+		return deliveryResult{Outcome: services.WebhookProcessed}
+	}
+
+	reply, err := services.RenderResponse("azure-devops", cmdResponse)
+	if err != nil {
+		fmt.Printf("Warning: failed to render /%s for %s!%d: %v\n", cmd.Type, comment.Repo, comment.PRNumber, err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err}
+	}
+	if err := h.azureDevOps.ReplyToComment(ctx, comment, reply); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err}
+	}
+	return deliveryResult{Outcome: services.WebhookProcessed}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// deliveryResult is what processing a buffered delivery came to. Err is set
+// only when the delivery failed in a way nothing else recovers from; a
+// comment GitHub refused is left to the comment outbox.
+type deliveryResult struct {
+	Outcome   string
+	Err       error
+	Retryable bool   // nothing ran yet, so trying again can't repeat a side effect
+	Trace     string // stack of a panic
+}
+
+// deliver processes a buffered delivery, retrying failures that are safe to
+// repeat with backoff, and dead-letters it with its payload once the
+// attempts run out. It returns the delivery's outcome.
+func (h *Handler) deliver(ctx context.Context, letter services.DeadLetter, process func(ctx context.Context) deliveryResult) string {
+	backoff := h.config.Webhook.RetryBackoff
+	var result deliveryResult
+	for attempt := 1; ; attempt++ {
+		result = attemptDelivery(ctx, process)
+		letter.Attempts = attempt
+		if result.Err == nil {
+			return result.Outcome
+		}
+		if !result.Retryable || attempt >= h.config.Webhook.MaxAttempts {
+			break
+		}
+		fmt.Printf("Retrying %s delivery for %s#%d in %s: %v\n", letter.Event, letter.Repo, letter.PRNumber, backoff, result.Err)
+		select {
+		case <-ctx.Done():
+			result.Err = fmt.Errorf("%v (shut down before retry %d)", result.Err, attempt+1)
+		case <-time.After(backoff):
+			backoff *= 2
+			continue
+		}
+		break
+	}
+
+	letter.Error = result.Err.Error()
+	letter.Trace = result.Trace
+	h.deadLetters.Add(context.Background(), letter)
+	return services.WebhookError
+}
+
+// attemptDelivery runs process once, turning a panic into a failure so one
+// bad delivery can't take a worker down with it
+func attemptDelivery(ctx context.Context, process func(ctx context.Context) deliveryResult) (result deliveryResult) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = deliveryResult{
+				Outcome: services.WebhookError,
+				Err:     fmt.Errorf("panic: %v", recovered),
+				Trace:   string(debug.Stack()),
+			}
+		}
+	}()
+	return process(ctx)
+}
+
+// githubDeadLetter starts the dead letter of a GitHub delivery
+func githubDeadLetter(c *gin.Context, event, action, repo string, prNumber int, payload map[string]interface{}) services.DeadLetter {
+	raw, _ := json.Marshal(payload)
+	return services.DeadLetter{
+		Source:   services.DeadLetterGitHub,
+		Event:    event,
+		Action:   action,
+		Delivery: c.GetHeader("X-GitHub-Delivery"),
+		Repo:     repo,
+		PRNumber: prNumber,
+		Payload:  raw,
+	}
+}
+
+// replayDeadLetter sends a dead letter's payload back through the worker
+// pool. Filters that decided whether it was worth running are skipped: it
+// already passed them once.
+func (h *Handler) replayDeadLetter(letter services.DeadLetter) (bool, error) {
+	letter.Replays++
+	letter.Attempts = 0
+
+	if letter.Source == services.DeadLetterAzureDevOps {
+		if h.azureDevOps == nil {
+			return false, fmt.Errorf("the Azure DevOps integration is not configured")
+		}
+		comment, ok := services.ParseAzureDevOpsComment(letter.Payload)
+		if !ok {
+			return false, fmt.Errorf("payload is not an Azure DevOps comment")
+		}
+		return h.webhooks.Submit(func(ctx context.Context) {
+			outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+				return h.processAzureDevOpsComment(ctx, comment)
+			})
+			h.webhookStats.Record(comment.Repo, azureDevOpsStatsEvent, "", outcome)
+		}), nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(letter.Payload, &payload); err != nil {
+		return false, fmt.Errorf("invalid payload: %v", err)
+	}
+
+	var process func(ctx context.Context) deliveryResult
+	switch {
+	case letter.Event == "pull_request" && letter.Action == "edited":
+		edit, ok := extractDescriptionEdit(payload)
+		if !ok {
+			return false, fmt.Errorf("payload is not a description edit")
+		}
+		process = func(ctx context.Context) deliveryResult {
+			return h.applyDescriptionEdit(ctx, edit, nil)
+		}
+	case letter.Event == "pull_request" && letter.Action == "synchronize":
+		sync, ok := extractPullRequestSync(payload)
+		if !ok {
+			return false, fmt.Errorf("payload is not a pull request push")
+		}
+		process = func(ctx context.Context) deliveryResult {
+			return h.applyPullRequestSync(ctx, sync)
+		}
+	case letter.Event == "workflow_run":
+		run, ok := extractWorkflowRun(payload)
+		if !ok {
+			return false, fmt.Errorf("payload is not a completed workflow run")
+		}
+		process = func(ctx context.Context) deliveryResult {
+			return h.applyWorkflowRun(ctx, run)
+		}
+	default:
+		comment, ok := extractCommentEvent(letter.Event, payload)
+		if !ok {
+			return false, fmt.Errorf("payload is not a comment")
+		}
+		process = func(ctx context.Context) deliveryResult {
+			return h.processCommentEvent(ctx, comment)
+		}
+	}
+
+	return h.webhooks.Submit(func(ctx context.Context) {
+		outcome := h.deliver(ctx, letter, process)
+		h.webhookStats.Record(letter.Repo, letter.Event, letter.Action, outcome)
+	}), nil
+}
+
+// ListDeadLetters returns the deliveries that failed processing, without
+// their payloads
+func (h *Handler) ListDeadLetters(c *gin.Context) {
+	letters, err := h.deadLetters.List(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to load dead letters", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Dead-lettered webhook deliveries",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"dead_letters": letters,
+			"count":        len(letters),
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDeadLetter returns one failed delivery with its payload and trace
+func (h *Handler) GetDeadLetter(c *gin.Context) {
+	letter, err := h.deadLetters.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Dead letter not found", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Dead-lettered webhook delivery",
+		Timestamp: time.Now(),
+		Data:      letter,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ReprocessDeadLetter sends a failed delivery back through the worker pool.
+// Once accepted it leaves the dead letters; failing again files it anew.
+func (h *Handler) ReprocessDeadLetter(c *gin.Context) {
+	ctx := c.Request.Context()
+	letter, err := h.deadLetters.Get(ctx, c.Param("id"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Dead letter not found", err)
+		return
+	}
+
+	accepted, err := h.replayDeadLetter(*letter)
+	if err != nil {
+		h.respondError(c, http.StatusUnprocessableEntity, "Dead letter can't be replayed", err)
+		return
+	}
+	if !accepted {
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+	if _, err := h.deadLetters.Remove(ctx, letter.ID); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	h.audit.Record("dead_letter.reprocess", c.GetString("user"), letter.Repo, letter.PRNumber, map[string]interface{}{
+		"dead_letter": letter.ID,
+		"event":       letter.Event,
+		"action":      letter.Action,
+		"replays":     letter.Replays + 1,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Dead letter accepted for processing",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"id":      letter.ID,
+			"event":   letter.Event,
+			"repo":    letter.Repo,
+			"replays": letter.Replays + 1,
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// DiscardDeadLetter drops a failed delivery without processing it
+func (h *Handler) DiscardDeadLetter(c *gin.Context) {
+	letter, err := h.deadLetters.Remove(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Dead letter not found", err)
+		return
+	}
+	h.audit.Record("dead_letter.discard", c.GetString("user"), letter.Repo, letter.PRNumber, map[string]interface{}{
+		"dead_letter": letter.ID,
+		"event":       letter.Event,
+		"error":       letter.Error,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Dead letter discarded",
+		Timestamp: time.Now(),
+		Data:      letter,
+	}
+	c.JSON(http.StatusOK, response)
+}
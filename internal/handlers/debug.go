@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// runtimeStats is the runtime snapshot plus how busy the worker pools are
+type runtimeStats struct {
+	services.RuntimeSnapshot
+	WebhookWorkers  webhookWorkerStats  `json:"webhook_workers"`
+	DeploymentSlots deploymentSlotStats `json:"deployment_slots"`
+	CommentOutbox   map[string]int64    `json:"comment_outbox"`
+}
+
+type webhookWorkerStats struct {
+	Workers       int     `json:"workers"`
+	Busy          int64   `json:"busy"`
+	Utilization   float64 `json:"utilization"`
+	QueueDepth    int     `json:"queue_depth"`
+	QueueCapacity int     `json:"queue_capacity"`
+}
+
+type deploymentSlotStats struct {
+	Running     int     `json:"running"`
+	Slots       int     `json:"slots"`
+	Utilization float64 `json:"utilization"`
+	Queued      int     `json:"queued"`
+}
+
+// RuntimeStats reports the Go runtime and how busy the worker pools are,
+// for investigating the server under webhook load
+func (h *Handler) RuntimeStats(c *gin.Context) {
+	running, slots := h.queue.Utilization()
+	webhookStats := h.webhooks.Stats()
+
+	stats := runtimeStats{
+		RuntimeSnapshot: services.RuntimeStats(),
+		WebhookWorkers: webhookWorkerStats{
+			Workers:       webhookStats.Workers,
+			Busy:          webhookStats.BusyWorkers,
+			Utilization:   utilization(float64(webhookStats.BusyWorkers), float64(webhookStats.Workers)),
+			QueueDepth:    webhookStats.QueueDepth,
+			QueueCapacity: webhookStats.QueueCapacity,
+		},
+		DeploymentSlots: deploymentSlotStats{
+			Running:     running,
+			Slots:       slots,
+			Utilization: utilization(float64(running), float64(slots)),
+			Queued:      h.queue.Length(),
+		},
+		CommentOutbox: services.SharedCommentOutbox().Stats(),
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Runtime stats",
+		Timestamp: time.Now(),
+		Data:      stats,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// utilization is used out of capacity, 0 without capacity, where the
+// division would give NaN, which JSON can't encode
+func utilization(used, capacity float64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return used / capacity
+}
+
+// Profile serves net/http/pprof under /debug/pprof. The index links to
+// profiles relative to that prefix, so it can't move under /api/admin.
+func (h *Handler) Profile(c *gin.Context) {
+	switch name := c.Param("profile"); name {
+	case "":
+		pprof.Index(c.Writer, c.Request)
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+const graphQLTimeout = 30 * time.Second
+
+// GraphQL answers dashboard queries. The body is the standard
+// {"query", "operationName", "variables"} and the reply the standard
+// {"data", "errors"}: field failures are reported next to the data that did
+// resolve, so the status is 200 once the request itself is readable.
+func (h *Handler) GraphQL(c *gin.Context) {
+	var request services.GraphQLRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid GraphQL request", err)
+		return
+	}
+
+	if h.graphql == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "GraphQL API is disabled: no cluster connection", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), graphQLTimeout)
+	defer cancel()
+
+	c.JSON(http.StatusOK, h.graphql.Execute(ctx, request))
+}
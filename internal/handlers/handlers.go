@@ -1,7 +1,13 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"net/http"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,11 +17,206 @@ import (
 )
 
 type Handler struct {
-	config *config.Config
+	config       *config.Config
+	lang         *services.LanguagePack
+	queue        *services.DeploymentQueue
+	webhooks     *services.WebhookBuffer
+	edits        *services.CommentEditTracker
+	github       *services.GitHubClient
+	artifacts    services.ArtifactStore
+	audit        *services.AuditLog
+	stuck        *services.StuckNamespaceMonitor
+	webhookStats *services.WebhookEventStats
+	timeline     *services.PreviewTimeline
+	team         *services.DeployerRoster
+	maintenance  *services.MaintenanceSwitch
+	deadLetters  *services.DeadLetterQueue
+	adminAuth    *services.AdminAuthenticator
+	apiAuth      *services.APIAuthenticator
+	azureDevOps  *services.AzureDevOpsClient // nil unless AZURE_DEVOPS_ORG_URL is set
+	shares       *services.ShareProxy        // nil unless SHARE_ENABLED is set
+	activator    *services.PreviewActivator  // nil unless PREVIEW_SCALE_TO_ZERO_AFTER is set
+	simulations  *services.SimulationLog
+	graphql      *services.GraphQLSchema // nil without a cluster
 }
 
 func New(cfg *config.Config) *Handler {
-	return &Handler{config: cfg}
+	lang, err := services.LoadLanguagePack(cfg.Locale.Language, cfg.Locale.Dir)
+	if err != nil {
+		fmt.Printf("⚠️  Falling back to English: %v\n", err)
+		lang = services.DefaultLanguagePack()
+	}
+
+	artifacts, err := services.NewArtifactStore(cfg)
+	if err != nil {
+		fmt.Printf("⚠️  Artifact storage disabled: %v\n", err)
+	}
+
+	audit, err := services.NewAuditLog(cfg.Audit.LogFile)
+	if err != nil {
+		fmt.Printf("⚠️  Audit log falling back to stdout: %v\n", err)
+		audit, _ = services.NewAuditLog("")
+	}
+
+	adminAuth, err := services.NewAdminAuthenticator(cfg)
+	if err != nil {
+		// Bad bindings leave only the static token, which is always admin
+		fmt.Printf("⚠️  Admin SSO disabled: %v\n", err)
+		withoutSSO := *cfg
+		withoutSSO.AdminAuth.ClientCAFile = ""
+		withoutSSO.AdminAuth.OIDCIssuer = ""
+		withoutSSO.AdminAuth.RoleBindings = nil
+		adminAuth, _ = services.NewAdminAuthenticator(&withoutSSO)
+	}
+
+	err = services.SharedGitHubCredentials().Configure(cfg.GitHub.AppID, cfg.GitHub.AppInstallationID, cfg.GitHub.AppPrivateKey, cfg.GitHub.Token, cfg.GitHub.ExpiryWarning)
+	if err != nil {
+		// GITHUB_TOKEN alone still posts comments
+		fmt.Printf("⚠️  GitHub App disabled: %v\n", err)
+	}
+
+	github := services.NewGitHubClient(cfg.GitHub.Token, cfg.GitHub.CacheTTL)
+	apiAuth, err := services.NewAPIAuthenticator(cfg, github)
+	if err != nil {
+		// A broken chain must not leave the API open, so only the static
+		// token gets in
+		fmt.Printf("⚠️  API authentication limited to ADMIN_API_TOKEN: %v\n", err)
+		locked := *cfg
+		locked.APIAuth.Providers = nil
+		locked.APIAuth.Anonymous = services.APIAccessNone
+		apiAuth, _ = services.NewAPIAuthenticator(&locked, github)
+	}
+
+	timeline := services.NewPreviewTimeline(artifacts, cfg.Timeline.Retention, cfg.Timeline.MaxEntries)
+	services.SharedCommentOutbox().Configure(artifacts, cfg.Server.Replica, cfg.GitHub.CommentRetryBackoff, cfg.GitHub.CommentRetryMax, cfg.GitHub.CommentRetryAttempts)
+	services.SharedGitHubRateBudget().Configure(cfg.GitHub.RateReserve)
+	services.SharedAccessTracker().Configure(artifacts, cfg.Access.Secret, cfg.Server.Replica)
+	if err := services.SharedPolicyEngine().Configure(cfg.Policy.Mode, cfg.Policy.File, cfg.Policy.MaxCPU, cfg.Policy.MaxMemory); err != nil {
+		fmt.Printf("⚠️  Deploy policies degraded: %v\n", err)
+	}
+	if err := services.SharedOperatorBundles().Configure(cfg.Operators.Bundles, cfg.Operators.Namespace); err != nil {
+		fmt.Printf("⚠️  Operator bundles left out: %v\n", err)
+	}
+	services.SharedClusterBreaker().Configure(cfg.ClusterBreaker.Threshold, cfg.ClusterBreaker.MinBackoff, cfg.ClusterBreaker.MaxBackoff, cfg.ClusterBreaker.MaxHeld)
+
+	var azureDevOps *services.AzureDevOpsClient
+	if cfg.AzureDevOps.OrgURL != "" {
+		azureDevOps, err = services.NewAzureDevOpsClient(cfg.AzureDevOps.OrgURL, cfg.AzureDevOps.Token, cfg.AzureDevOps.Auth)
+		if err == nil && (cfg.AzureDevOps.HookUsername == "" || cfg.AzureDevOps.HookPassword == "") {
+			// Without them anyone could post comments as any author
+			azureDevOps, err = nil, fmt.Errorf("AZURE_DEVOPS_HOOK_USERNAME and AZURE_DEVOPS_HOOK_PASSWORD are required")
+		}
+		if err != nil {
+			fmt.Printf("⚠️  Azure DevOps integration disabled: %v\n", err)
+		} else {
+			azureDevOps.WithTimeline(timeline)
+		}
+	}
+
+	var shares *services.ShareProxy
+	if cfg.Share.Enabled && cfg.Share.Host == "" {
+		fmt.Println("⚠️  Share links disabled: SHARE_HOST must name a host of their own")
+	} else if cfg.Share.Enabled {
+		shares, err = services.NewShareProxy()
+		if err != nil {
+			fmt.Printf("⚠️  Share links disabled: %v\n", err)
+		}
+	}
+
+	var activator *services.PreviewActivator
+	if cfg.Preview.ScaleToZeroAfter > 0 {
+		activator, err = services.NewPreviewActivator(cfg)
+		if err != nil {
+			fmt.Printf("⚠️  Preview wakeups disabled: %v\n", err)
+		}
+	}
+
+	h := &Handler{
+		config:       cfg,
+		lang:         lang,
+		queue:        services.NewDeploymentQueue(cfg.Preview.MaxConcurrent, cfg.Preview.PriorityBurst),
+		webhooks:     services.NewWebhookBuffer(cfg.Webhook.BufferSize, cfg.Webhook.Workers),
+		edits:        services.NewCommentEditTracker(cfg.Webhook.EditDebounce),
+		github:       github.WithTimeline(timeline),
+		artifacts:    artifacts,
+		audit:        audit,
+		stuck:        services.NewStuckNamespaceMonitor(cfg.Preview.StuckAfter),
+		webhookStats: services.NewWebhookEventStats(),
+		timeline:     timeline,
+		team:         services.NewDeployerRoster(artifacts, cfg.GitHub.CoreTeam),
+		maintenance:  services.NewMaintenanceSwitch(artifacts, cfg.Maintenance.Enabled, cfg.Maintenance.Message),
+		deadLetters:  services.NewDeadLetterQueue(artifacts, cfg.Webhook.DeadLetterMax),
+		adminAuth:    adminAuth,
+		apiAuth:      apiAuth,
+		azureDevOps:  azureDevOps,
+		shares:       shares,
+		activator:    activator,
+		simulations:  services.NewSimulationLog(cfg.Debug.SimulateHistory),
+	}
+
+	if cmdService, err := services.NewCommandServiceK8s(cfg); err == nil {
+		h.graphql = cmdService.DashboardSchema(h.queue, h.audit, h.stuck)
+	} else {
+		fmt.Printf("⚠️  GraphQL API disabled: %v\n", err)
+	}
+	return h
+}
+
+// Start launches the handler's background workers
+func (h *Handler) Start(ctx context.Context) {
+	h.webhooks.Start(ctx)
+	go h.stuck.Run(ctx, h.config.Preview.StuckInterval)
+	go services.SharedCommentOutbox().Run(ctx, h.github)
+	if h.config.Access.Tracking {
+		go services.SharedAccessTracker().Run(ctx, h.config.Access.FlushInterval)
+	}
+	go services.SharedGitHubCredentials().Run(ctx, h.config.GitHub.CredentialCheck)
+	go services.StartMetricsPusher(ctx, services.NewMetricsSinks(h.config), h.config.Metrics.PushInterval, h.collectMetrics)
+	if len(h.config.GitHub.ReconcileRepos) > 0 {
+		// Once per process, on the leader
+		var reconcile sync.Once
+		services.SharedLeader().OnStartedLeading(func(ctx context.Context) {
+			reconcile.Do(func() { h.reconcileGitHubState(ctx) })
+		})
+	}
+}
+
+// reconcileRolloutTimeout bounds how long reconciliation waits for the
+// previous rollout's replicas to stop
+const reconcileRolloutTimeout = 10 * time.Minute
+
+// reconcileGitHubState corrects the deployment comments and check runs the
+// previous process left claiming work that is no longer happening. It waits
+// for the previous rollout's replicas to stop first, since until then the
+// work may still be happening.
+func (h *Handler) reconcileGitHubState(ctx context.Context) {
+	stopped, err := services.WaitForOldReplicas(ctx, h.config.Server.Namespace, h.config.Server.Replica, reconcileRolloutTimeout)
+	if err != nil || !stopped {
+		if err == nil {
+			err = fmt.Errorf("replicas of the previous rollout are still running after %s", reconcileRolloutTimeout)
+		}
+		fmt.Printf("⚠️  Skipped GitHub state reconciliation: %v\n", err)
+		return
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		fmt.Printf("⚠️  Skipped GitHub state reconciliation: %v\n", err)
+		return
+	}
+
+	report := cmdService.ReconcileGitHubState(ctx, h.config.GitHub.ReconcileRepos, h.config.GitHub.ReconcileMaxAge)
+	fmt.Printf("Reconciled %d open PRs: rewrote %d stale comments, cancelled %d dangling check runs\n",
+		report.PullRequests, len(report.Comments), len(report.CheckRuns))
+	for _, problem := range report.Errors {
+		fmt.Printf("Warning: %s\n", problem)
+	}
+	if len(report.Comments) > 0 || len(report.CheckRuns) > 0 {
+		h.audit.Record("github.reconcile", "pr-previews", "", 0, map[string]interface{}{
+			"comments":   report.Comments,
+			"check_runs": report.CheckRuns,
+		})
+	}
 }
 
 func (h *Handler) Health(c *gin.Context) {
@@ -24,30 +225,168 @@ func (h *Handler) Health(c *gin.Context) {
 		Message:   "pr-previews service is healthy",
 		Timestamp: time.Now(),
 		Data: map[string]interface{}{
-			"service": "pr-previews",
-			"version": "0.1.0",
-			"status":  "healthy",
+			"service":           "pr-previews",
+			"version":           "0.1.0",
+			"status":            "healthy",
+			"github_rate_limit": services.GitHubRateLimitStats(),
+			"cluster_breaker":   services.SharedClusterBreaker().Stats(),
 		},
 	}
 	c.JSON(http.StatusOK, response)
 }
 
+// Ready reports whether the bot can act on GitHub: 503 when no credential
+// works, with each credential's expiry, scopes and problems
+func (h *Handler) Ready(c *gin.Context) {
+	credentials := services.SharedGitHubCredentials()
+	health := credentials.Health()
+	if len(health) == 0 && credentials.Configured() {
+		health = credentials.Check(c.Request.Context())
+	}
+
+	usable, warnings := false, 0
+	for _, credential := range health {
+		if credential.Healthy {
+			usable = true
+		}
+		warnings += len(credential.Problems)
+	}
+
+	status, message := http.StatusOK, "pr-previews is ready"
+	switch {
+	case !usable:
+		status, message = http.StatusServiceUnavailable, "No working GitHub credential"
+	case warnings > 0:
+		message = "pr-previews is ready; a GitHub credential needs attention"
+	}
+	c.JSON(status, types.Response{
+		Success:   usable,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"github_credentials": health,
+		},
+	})
+}
+
+// Metrics reports service metrics as JSON, or in the Prometheus text format
+// for scrapers (?format=prometheus or a text/plain Accept header)
 func (h *Handler) Metrics(c *gin.Context) {
+	accept := c.GetHeader("Accept")
+	if c.Query("format") == "prometheus" || strings.Contains(accept, "text/plain") || strings.Contains(accept, "openmetrics") {
+		var body bytes.Buffer
+		services.WritePrometheusGauges(&body, h.collectMetrics(c.Request.Context()))
+		h.webhookStats.WritePrometheus(&body)
+		services.SharedCommentMetrics().WritePrometheus(&body)
+		c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", body.Bytes())
+		return
+	}
+
+	webhookStats := h.webhooks.Stats()
 	response := types.Response{
 		Success:   true,
 		Message:   "Metrics endpoint",
 		Timestamp: time.Now(),
 		Data: map[string]interface{}{
-			"webhooks_received":  "TODO",
+			"webhooks_received":  webhookStats.Received,
+			"webhook_buffer":     webhookStats,
+			"github_cache":       services.GitHubCacheStats(),
+			"repo_config_cache":  services.SharedRepoConfigCache().Stats(),
+			"deployment_queue":   h.queue.Length(),
+			"stuck_namespaces":   h.stuck.Count(),
+			"webhook_events":     h.webhookStats.Snapshot()["totals"],
+			"pending_comments":   services.SharedCommentOutbox().Stats(),
 			"active_previews":    "TODO",
-			"commands_processed": "TODO",
+			"commands_processed": webhookStats.Processed,
 		},
 	}
 	c.JSON(http.StatusOK, response)
 }
 
+// collectMetrics snapshots the gauges sent to push-based metrics sinks
+func (h *Handler) collectMetrics(ctx context.Context) map[string]float64 {
+	webhookStats := h.webhooks.Stats()
+	cacheStats := services.GitHubCacheStats()
+	rateStats := services.GitHubRateLimitStats()
+	commentStats := services.SharedCommentOutbox().Stats()
+	breakerStats := services.SharedClusterBreaker().Stats()
+
+	gauges := map[string]float64{
+		"webhooks_received":       float64(webhookStats.Received),
+		"webhooks_dropped":        float64(webhookStats.Dropped),
+		"webhooks_processed":      float64(webhookStats.Processed),
+		"webhook_buffer_depth":    float64(webhookStats.QueueDepth),
+		"github_cache_entries":    float64(cacheStats.Entries),
+		"github_cache_hits":       float64(cacheStats.Hits),
+		"github_cache_misses":     float64(cacheStats.Misses),
+		"deployment_queue_length": float64(h.queue.Length()),
+		"stuck_namespaces":        float64(h.stuck.Count()),
+		"comments_pending":        float64(commentStats["pending"]),
+		"comments_stuck":          float64(commentStats["stuck"]),
+		"comment_post_failures":   float64(commentStats["failures"]),
+		"e2e_runs_pending":        float64(len(services.SharedE2ERuns().Pending())),
+		"webhooks_dead_lettered":  float64(h.deadLetters.Count()),
+		"cluster_breaker_open":    0,
+		"cluster_breaker_trips":   float64(breakerStats.Trips),
+		"commands_held":           float64(breakerStats.Held),
+	}
+	if breakerStats.State == services.BreakerOpen {
+		gauges["cluster_breaker_open"] = 1
+	}
+
+	// Only once GitHub has told us, so a fresh start doesn't read as exhausted
+	if rateStats.Known {
+		gauges["github_rate_limit_remaining"] = float64(rateStats.Remaining)
+		gauges["github_rate_limit_limit"] = float64(rateStats.Limit)
+		gauges["github_rate_limit_reset_seconds"] = services.SharedGitHubRateBudget().ResetIn().Seconds()
+	}
+	gauges["github_calls_throttled"] = float64(rateStats.Throttled)
+	gauges["command_reply_p95_seconds"] = services.SharedCommentMetrics().P95().Seconds()
+
+	configCacheStats := services.SharedRepoConfigCache().Stats()
+	gauges["repo_config_cache_files"] = float64(configCacheStats.Files)
+	gauges["repo_config_cache_hits"] = float64(configCacheStats.Hits)
+	gauges["repo_config_cache_misses"] = float64(configCacheStats.Misses)
+
+	// One per credential needing attention, for alerting on expiry or scopes
+	gauges["github_credential_problems"] = 0
+	for _, credential := range services.SharedGitHubCredentials().Health() {
+		gauges["github_credential_problems"] += float64(len(credential.Problems))
+	}
+
+	running, _ := h.queue.Utilization()
+	gauges["webhook_workers_busy"] = float64(webhookStats.BusyWorkers)
+	gauges["deployments_running"] = float64(running)
+	gauges["goroutines"] = float64(runtime.NumGoroutine())
+
+	// Preview counts need the cluster; skip them rather than the whole push
+	if cmdService, err := services.NewCommandServiceK8s(h.config); err == nil {
+		if count, err := cmdService.PreviewCount(ctx); err == nil {
+			gauges["active_previews"] = float64(count)
+		}
+	}
+
+	return gauges
+}
+
+// WebhookStats breaks webhook deliveries down by repository, event, action
+// and outcome
+func (h *Handler) WebhookStats(c *gin.Context) {
+	// Reply latency and comment failures show whether deliveries turn into
+	// answers on the PR in good time
+	stats := h.webhookStats.Snapshot()
+	stats["comments"] = services.SharedCommentMetrics().Snapshot()
+	response := types.Response{
+		Success:   true,
+		Message:   "Webhook delivery stats",
+		Timestamp: time.Now(),
+		Data:      stats,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
 func (h *Handler) TestK8s(c *gin.Context) {
-	cmdService, err := services.NewCommandServiceK8s()
+	cmdService, err := services.NewCommandServiceK8s(h.config)
 	if err != nil {
 		h.respondError(c, http.StatusInternalServerError, "Failed to create K8s service", err)
 		return
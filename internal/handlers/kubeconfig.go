@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+// DeveloperAuth accepts either the admin token or a GitHub token belonging to
+// a core team member, whose login is stored on the context
+func (h *Handler) DeveloperAuth(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		h.respondError(c, http.StatusUnauthorized, "Authentication required", nil)
+		c.Abort()
+		return
+	}
+
+	if h.config.Server.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.config.Server.AdminToken)) == 1 {
+		c.Set("user", "admin")
+		c.Next()
+		return
+	}
+
+	login, err := h.github.GetAuthenticatedUser(c.Request.Context(), token)
+	if err != nil {
+		h.respondError(c, http.StatusUnauthorized, "Invalid GitHub token", err)
+		c.Abort()
+		return
+	}
+	if !h.hasDeploymentPermission(c.Request.Context(), login) {
+		h.respondError(c, http.StatusForbidden, "Only core team can access previews", nil)
+		c.Abort()
+		return
+	}
+
+	c.Set("user", login)
+	c.Next()
+}
+
+// GetKubeconfig issues a short-lived kubeconfig for one preview namespace
+func (h *Handler) GetKubeconfig(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+
+	service := c.Query("service")
+	if service == "" {
+		h.respondError(c, http.StatusBadRequest, "service query parameter is required", nil)
+		return
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+
+	kubeconfig, expiry, err := cmdService.PreviewKubeconfig(c.Request.Context(), prNumber, service)
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Failed to issue kubeconfig", err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=preview-pr-"+strconv.Itoa(prNumber)+".kubeconfig")
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Expires-At", expiry.UTC().Format(http.TimeFormat))
+	c.Data(http.StatusOK, "application/yaml", kubeconfig)
+}
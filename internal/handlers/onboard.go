@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+var repoNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+// Onboard checks whether a repository is ready for previews and suggests a
+// .pr-previews.yaml. Everything is read with the caller's GitHub token, from
+// the Authorization header, so it only reveals what the caller can already
+// see.
+func (h *Handler) Onboard(c *gin.Context) {
+	var request struct {
+		Repo   string `json:"repo" binding:"required"`
+		OpenPR bool   `json:"open_pr"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	if !repoNamePattern.MatchString(request.Repo) {
+		h.respondError(c, http.StatusBadRequest, "repo must be owner/name", nil)
+		return
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		h.respondError(c, http.StatusUnauthorized, "A GitHub token is required in the Authorization header", nil)
+		return
+	}
+	login, err := h.github.GetAuthenticatedUser(c.Request.Context(), token)
+	if err != nil {
+		h.respondError(c, http.StatusUnauthorized, "Invalid GitHub token", err)
+		return
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+
+	report, err := cmdService.Onboard(c.Request.Context(), services.NewUserGitHubClient(token), h.github, request.Repo, request.OpenPR)
+	if err != nil {
+		h.respondError(c, http.StatusNotFound, "Failed to read repository", err)
+		return
+	}
+	h.audit.Record("repo.onboard", login, report.Repo, 0, map[string]interface{}{
+		"ready":        report.Ready,
+		"gaps":         len(report.Gaps),
+		"pull_request": report.PullRequest,
+	})
+
+	message := "Repository is ready for previews"
+	if !report.Ready {
+		message = "Repository needs changes before previews work"
+	}
+	response := types.Response{
+		Success:   true,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data:      report,
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// defaultPreviewWait is how long /wait blocks without ?timeout=
+const defaultPreviewWait = 5 * time.Minute
+
+// WaitForPreview long-polls until a preview is ready or failed, so a CI
+// pipeline can deploy, wait, then run E2E tests against the returned URL.
+// Ready answers 200, failed 422 and a wait that ran out 408, so
+// `curl --fail` only passes once the preview is up.
+func (h *Handler) WaitForPreview(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+
+	timeout := defaultPreviewWait
+	if value := c.Query("timeout"); value != "" {
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			h.respondError(c, http.StatusBadRequest, "timeout must be a positive duration, e.g. 300s", err)
+			return
+		}
+	}
+	if h.config.Preview.WaitMax > 0 && timeout > h.config.Preview.WaitMax {
+		timeout = h.config.Preview.WaitMax
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+
+	// The server's write timeout is shorter than a rollout
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(timeout + 30*time.Second)); err != nil {
+		fmt.Printf("Warning: can't extend the write deadline for /wait: %v\n", err)
+	}
+
+	result, err := cmdService.WaitForPreview(c.Request.Context(), h.apiRepo(c), prNumber, c.Param("service"), timeout)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to check the preview", err)
+		return
+	}
+
+	status, message := http.StatusOK, "Preview is ready"
+	switch result.State {
+	case services.PreviewWaitFailed:
+		status, message = http.StatusUnprocessableEntity, "Preview failed"
+	case services.PreviewWaitTimeout:
+		status, message = http.StatusRequestTimeout, "Timed out waiting for the preview"
+	}
+
+	response := types.Response{
+		Success:   result.State == services.PreviewWaitReady,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data:      result,
+	}
+	c.JSON(status, response)
+}
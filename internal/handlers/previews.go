@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// previewEventsInterval is how often an event stream looks for new
+// timeline entries
+const previewEventsInterval = 3 * time.Second
+
+// The --key=value flags /preview accepts in a comment
+var (
+	apiFlagKey   = regexp.MustCompile(`^[a-z-]+$`)
+	apiFlagValue = regexp.MustCompile(`^\S+$`)
+)
+
+// CreatePreviewRequest is the body of POST /api/v1/previews
+type CreatePreviewRequest struct {
+	PRNumber int               `json:"pr_number"`
+	Service  string            `json:"service"`
+	Args     map[string]string `json:"args,omitempty"` // /preview flags without the dashes, e.g. ttl: 2d
+}
+
+// ListPreviews returns the running previews, optionally of one repository
+// (?repo=) or PR (?pr=)
+func (h *Handler) ListPreviews(c *gin.Context) {
+	prFilter := 0
+	if value := c.Query("pr"); value != "" {
+		var err error
+		if prFilter, err = strconv.Atoi(value); err != nil {
+			h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+			return
+		}
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+	previews, err := cmdService.ListPreviews(c.Request.Context(), apiRepoFilter(c), prFilter)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list previews", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Previews",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"previews": previews,
+			"count":    len(previews),
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetPreviewStatus returns the typed status of the PR's previews, optionally
+// of one repository (?repo=): their services, pods, URLs and expiry. ready
+// is true once every preview is, so CI can gate on it.
+func (h *Handler) GetPreviewStatus(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+	status, err := cmdService.PreviewStatusOf(c.Request.Context(), apiRepoFilter(c), prNumber)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to get preview status", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, types.Response{
+		Success:   true,
+		Message:   "Preview status",
+		Timestamp: time.Now(),
+		Data:      status,
+	})
+}
+
+// CreatePreview deploys a service's preview like a /preview comment from the
+// API caller would, replying on the PR. The deploy runs in the background;
+// follow it with the returned wait URL.
+func (h *Handler) CreatePreview(c *gin.Context) {
+	var request CreatePreviewRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	repo := h.apiRepo(c)
+	if repo == "" || request.PRNumber <= 0 {
+		h.respondError(c, http.StatusBadRequest, "pr_number and ?repo= are required", nil)
+		return
+	}
+	if request.Service != "" {
+		if err := services.ValidateServiceName(request.Service); err != nil {
+			h.respondError(c, http.StatusBadRequest, "Invalid service", err)
+			return
+		}
+	}
+	for key, value := range request.Args {
+		if !apiFlagKey.MatchString(key) || !apiFlagValue.MatchString(value) {
+			h.respondError(c, http.StatusBadRequest, fmt.Sprintf("Invalid argument %q", services.SanitizeEcho(key)), nil)
+			return
+		}
+	}
+
+	cmd := &types.Command{
+		Type:     "preview",
+		Service:  request.Service,
+		User:     c.GetString("user"),
+		PRNumber: request.PRNumber,
+		Repo:     repo,
+		Args:     request.Args,
+	}
+	if services.MaintenancePauses(cmd.Type) && h.maintenance.Status(c.Request.Context()).Enabled {
+		c.Header("Retry-After", "300")
+		h.respondError(c, http.StatusServiceUnavailable, "Deployments are paused for maintenance", nil)
+		return
+	}
+	if branch, err := h.github.GetPullRequestBranch(c.Request.Context(), repo, request.PRNumber); err == nil && services.ValidateBranchName(branch) == nil {
+		cmd.Branch = branch
+	}
+
+	if services.SharedClusterBreaker().Open() {
+		c.Header("Retry-After", "60")
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes cluster unavailable, retry later", services.ErrClusterUnavailable)
+		return
+	}
+	if !h.submitAPICommand(cmd, apiCommandText(cmd)) {
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+	h.audit.Record("api.preview", cmd.User, repo, cmd.PRNumber, map[string]interface{}{
+		"service": cmd.Service,
+		"args":    cmd.Args,
+	})
+
+	data := map[string]interface{}{
+		"repo":      repo,
+		"pr_number": cmd.PRNumber,
+		"service":   cmd.Service,
+		"status":    "accepted",
+	}
+	if cmd.Service != "" {
+		data["wait"] = fmt.Sprintf("/api/v1/previews/%d/%s/wait?repo=%s", cmd.PRNumber, cmd.Service, url.QueryEscape(repo))
+	}
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview deployment accepted",
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// DeletePreview cleans up every preview of a PR like /cleanup, in the
+// background
+func (h *Handler) DeletePreview(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	repo := h.apiRepo(c)
+	if repo == "" {
+		h.respondError(c, http.StatusBadRequest, "?repo= is required", nil)
+		return
+	}
+
+	cmd := &types.Command{Type: "cleanup", User: c.GetString("user"), PRNumber: prNumber, Repo: repo}
+	if services.SharedClusterBreaker().Open() {
+		c.Header("Retry-After", "60")
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes cluster unavailable, retry later", services.ErrClusterUnavailable)
+		return
+	}
+	if !h.submitAPICommand(cmd, apiCommandText(cmd)) {
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+	h.audit.Record("api.cleanup", cmd.User, repo, prNumber, nil)
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview cleanup accepted",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"repo":      repo,
+			"pr_number": prNumber,
+			"status":    "accepted",
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// StreamPreviewEvents sends the timeline of the repository's PR as
+// server-sent events: the retained entries after ?since=, then new ones as
+// they're recorded. The stream ends after PREVIEW_WAIT_MAX; reconnect with
+// the last entry's time.
+func (h *Handler) StreamPreviewEvents(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	if h.timeline == nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Timeline retention is not configured", nil)
+		return
+	}
+	repo := h.apiRepo(c)
+	if repo == "" {
+		h.respondError(c, http.StatusBadRequest, "?repo= is required", nil)
+		return
+	}
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		if since, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			h.respondError(c, http.StatusBadRequest, "since must be an RFC 3339 time", err)
+			return
+		}
+	}
+
+	ctx, cancel := h.streamContext(c)
+	defer cancel()
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(previewEventsInterval)
+	defer ticker.Stop()
+	for {
+		entries, err := h.timeline.Entries(ctx, repo, prNumber)
+		if err != nil && ctx.Err() == nil {
+			c.SSEvent("error", err.Error())
+		}
+		for _, entry := range entries {
+			if entry.Time.After(since) {
+				c.SSEvent("timeline", entry)
+				since = entry.Time
+			}
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StreamPreviewLogs sends the logs of a service's preview as
+// newline-delimited JSON, one line per object. ?follow=true keeps streaming
+// until PREVIEW_WAIT_MAX; ?tail=, ?pod= and ?container= narrow it down.
+// Logs can hold secrets, so this needs write access to the repository.
+func (h *Handler) StreamPreviewLogs(c *gin.Context) {
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	repo := h.apiRepo(c)
+	if repo == "" {
+		h.respondError(c, http.StatusBadRequest, "?repo= is required", nil)
+		return
+	}
+	opts := services.PreviewLogOptions{
+		Pod:       c.Query("pod"),
+		Container: c.Query("container"),
+		Follow:    c.Query("follow") == "true",
+	}
+	if value := c.Query("tail"); value != "" {
+		if opts.TailLines, err = strconv.ParseInt(value, 10, 64); err != nil || opts.TailLines < 0 {
+			h.respondError(c, http.StatusBadRequest, "tail must be a number of lines", err)
+			return
+		}
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+
+	ctx, cancel := h.streamContext(c)
+	defer cancel()
+	started := false
+	encoder := json.NewEncoder(c.Writer)
+	err = cmdService.StreamPreviewLogs(ctx, repo, prNumber, c.Param("service"), opts, func(line services.PreviewLogLine) {
+		if !started {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+			started = true
+		}
+		if err := encoder.Encode(line); err == nil {
+			c.Writer.Flush()
+		}
+	})
+	if err != nil && !started {
+		h.respondError(c, http.StatusNotFound, "Failed to read preview logs", err)
+		return
+	}
+	if !started {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Status(http.StatusOK)
+	}
+}
+
+// streamContext bounds a streaming response by PREVIEW_WAIT_MAX and lifts
+// the server's write timeout, which is shorter, for that long
+func (h *Handler) streamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	limit := h.config.Preview.WaitMax
+	if limit <= 0 {
+		limit = defaultPreviewWait
+	}
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(limit + 30*time.Second)); err != nil {
+		fmt.Printf("Warning: can't extend the write deadline for %s: %v\n", c.FullPath(), err)
+	}
+	return context.WithTimeout(c.Request.Context(), limit)
+}
+
+// apiRepo is the repository an API request acts on: the one a GitHub token
+// caller was authorized against, ?repo=, or API_GITHUB_REPO
+func (h *Handler) apiRepo(c *gin.Context) string {
+	if repo := apiRepoFilter(c); repo != "" {
+		return repo
+	}
+	return h.config.APIAuth.GitHubRepo
+}
+
+// apiRepoFilter is the repository an API read is narrowed to: the one a
+// GitHub token caller was authorized against, or ?repo=; empty is all of
+// them
+func apiRepoFilter(c *gin.Context) string {
+	if repo := c.GetString("api_repo"); repo != "" {
+		return repo
+	}
+	return c.Query("repo")
+}
+
+// submitAPICommand runs a command from the API in the worker pool the way a
+// PR comment would run, replying on the PR. False means the pool is full.
+func (h *Handler) submitAPICommand(cmd *types.Command, text string) bool {
+	return h.webhooks.Submit(func(ctx context.Context) {
+		cmdService, err := services.NewCommandServiceK8s(h.config)
+		if err != nil {
+			fmt.Printf("Failed to process API %s on PR #%d: %v\n", text, cmd.PRNumber, err)
+			return
+		}
+
+		var response *types.CommandResponse
+		switch cmd.Type {
+		case "preview":
+			response = h.runPreviews(ctx, cmdService, cmd, ".")
+		default:
+			response = cmdService.HandleCleanupK8s(ctx, cmd, ".")
+		}
+		cmdService.RecordCommand(ctx, cmd, text, response)
+		if response.Content != "" {
+			if err := cmdService.Commenter().PostComment(ctx, cmd.Repo, cmd.PRNumber, response.Content); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+		cmdService.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+	})
+}
+
+// apiCommandText is the comment an API command stands for, for the timeline
+func apiCommandText(cmd *types.Command) string {
+	text := "/" + cmd.Type
+	if cmd.Service != "" {
+		text += " " + cmd.Service
+	}
+	keys := make([]string, 0, len(cmd.Args))
+	for key := range cmd.Args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text += fmt.Sprintf(" --%s=%s", key, cmd.Args[key])
+	}
+	return text
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// PreviewInventory exports every running preview with its repo, PR, owner,
+// age, status and estimated cost, as JSON or, with ?format=csv, a CSV file.
+// ?repo= narrows it to one repository and ?from= and ?to= (RFC 3339 or
+// YYYY-MM-DD) to previews created in that range.
+func (h *Handler) PreviewInventory(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		h.respondError(c, http.StatusBadRequest, "Invalid format", fmt.Errorf("format must be json or csv, got %q", format))
+		return
+	}
+
+	filter := services.InventoryFilter{Repo: apiRepoFilter(c)}
+	var err error
+	if filter.From, err = parseReportTime(c.Query("from")); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid from time", err)
+		return
+	}
+	if filter.To, err = parseReportTime(c.Query("to")); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid to time", err)
+		return
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		h.respondError(c, http.StatusBadRequest, "Invalid time range", fmt.Errorf("from must be before to"))
+		return
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		h.respondError(c, http.StatusServiceUnavailable, "Kubernetes is not available", err)
+		return
+	}
+	entries, err := cmdService.PreviewInventory(c.Request.Context(), filter)
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to build preview inventory", err)
+		return
+	}
+
+	if format == "csv" {
+		c.Header("Content-Disposition", "attachment; filename=previews-"+time.Now().UTC().Format("20060102-150405")+".csv")
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := services.WritePreviewInventoryCSV(c.Writer, entries); err != nil {
+			fmt.Printf("Warning: failed to write preview inventory: %v\n", err)
+		}
+		return
+	}
+
+	var soFar, weekly float64
+	for _, entry := range entries {
+		soFar += entry.CostSoFar
+		weekly += entry.WeeklyCost
+	}
+	response := types.Response{
+		Success:   true,
+		Message:   "Preview inventory",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"previews":    entries,
+			"count":       len(entries),
+			"cost_so_far": soFar,
+			"weekly_cost": weekly,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// parseReportTime reads an RFC 3339 time or a YYYY-MM-DD date, which starts
+// at midnight UTC; empty is the zero time
+func parseReportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a YYYY-MM-DD date", value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// onShareHost reports whether the request came in on SHARE_HOST
+func (h *Handler) onShareHost(c *gin.Context) bool {
+	host := c.Request.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	return h.config.Share.Host != "" && strings.EqualFold(host, h.config.Share.Host)
+}
+
+// IsolateShareHost keeps SHARE_HOST to share links, so the previews served
+// there can't reach the API or the dashboard from their origin
+func (h *Handler) IsolateShareHost(c *gin.Context) {
+	if h.onShareHost(c) && !strings.HasPrefix(c.Request.URL.Path, "/share/") {
+		c.String(http.StatusNotFound, "Not found.")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// ServeShareLink proxies a share link holder's request to the preview once
+// they give the link's password. It answers in plain text, since the caller
+// is a browser rather than an API client.
+func (h *Handler) ServeShareLink(c *gin.Context) {
+	if h.shares == nil || !h.onShareHost(c) {
+		c.String(http.StatusNotFound, "Share links are not enabled.")
+		return
+	}
+	namespace, token := c.Param("namespace"), c.Param("token")
+	if !strings.HasPrefix(namespace, "preview-") {
+		c.String(http.StatusNotFound, "This share link is invalid.")
+		return
+	}
+
+	_, password, _ := c.Request.BasicAuth()
+	link, err := h.shares.Resolve(c.Request.Context(), namespace, token, password)
+	if errors.Is(err, services.ErrShareLinkInvalid) {
+		c.String(http.StatusNotFound, "This share link is invalid, has expired or was revoked. Ask whoever shared it for a new one.")
+		return
+	}
+	if errors.Is(err, services.ErrSharePassword) {
+		c.Header("WWW-Authenticate", `Basic realm="Preview share link", charset="UTF-8"`)
+		c.String(http.StatusUnauthorized, "This share link needs its password. Ask whoever shared it.")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadGateway, "The preview can't be reached right now; try again in a minute.")
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	// Share links go straight to the Service, past the Ingress fallback
+	// that wakes asleep previews, so wake them here when they don't answer
+	unavailable := func(w http.ResponseWriter, r *http.Request) bool {
+		return h.activator != nil && h.wakePreview(w, r, namespace, strings.Contains(r.Header.Get("Accept"), "text/html"))
+	}
+	h.shares.Handler(namespace, link, services.SharePath(namespace, token), c.Param("path"), unavailable).ServeHTTP(c.Writer, c.Request)
+}
+
+func (h *Handler) ListShareLinks(c *gin.Context) {
+	if h.shares == nil {
+		h.respondError(c, http.StatusNotFound, "Share links are not enabled", nil)
+		return
+	}
+	links, err := h.shares.List(c.Request.Context())
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to list share links", err)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Share links",
+		Timestamp: time.Now(),
+		Data:      links,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// SetShareLinkPassword gives one of the PR's share links a new password and
+// returns it. This is the only place the password is shown, so it never
+// lands in the PR thread.
+func (h *Handler) SetShareLinkPassword(c *gin.Context) {
+	if h.shares == nil {
+		h.respondError(c, http.StatusNotFound, "Share links are not enabled", nil)
+		return
+	}
+	prNumber, err := strconv.Atoi(c.Param("pr"))
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid PR number", err)
+		return
+	}
+	repo := h.apiRepo(c)
+	if repo == "" {
+		h.respondError(c, http.StatusBadRequest, "?repo= is required", nil)
+		return
+	}
+
+	link, password, err := h.shares.SetPassword(c.Request.Context(), repo, prNumber, c.Param("id"))
+	if errors.Is(err, services.ErrShareLinkInvalid) {
+		h.respondError(c, http.StatusNotFound, "No active share link with that ID", nil)
+		return
+	}
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to set share link password", err)
+		return
+	}
+	h.audit.Record("share.password", c.GetString("user"), repo, prNumber, map[string]interface{}{
+		"link_id": link.ID,
+	})
+
+	c.Header("Cache-Control", "no-store")
+	response := types.Response{
+		Success:   true,
+		Message:   "Share link password set",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"link_id":    link.ID,
+			"password":   password,
+			"expires_at": link.ExpiresAt,
+		},
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) RevokeShareLink(c *gin.Context) {
+	if h.shares == nil {
+		h.respondError(c, http.StatusNotFound, "Share links are not enabled", nil)
+		return
+	}
+	namespace, id := c.Param("namespace"), c.Param("id")
+	revoked, err := h.shares.Revoke(c.Request.Context(), namespace, id, c.GetString("user"))
+	if err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to revoke share link", err)
+		return
+	}
+	if !revoked {
+		h.respondError(c, http.StatusNotFound, "No active share link with that ID", nil)
+		return
+	}
+	h.audit.Record("share.revoke", c.GetString("user"), "", 0, map[string]interface{}{
+		"namespace": namespace,
+		"link_id":   id,
+	})
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Share link revoked",
+		Timestamp: time.Now(),
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+	"pr-previews/internal/types"
+)
+
+// SimulateWebhook builds a GitHub delivery from a few parameters and runs it
+// through the webhook pipeline synchronously: signature check, event
+// filters, command dispatch and replies. Replies and follow-ups are recorded
+// instead of posted. A failed signature check is noted but doesn't stop the
+// run, and edits skip the debounce.
+func (h *Handler) SimulateWebhook(c *gin.Context) {
+	var request services.SimulationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid request body", err)
+		return
+	}
+	event, body, err := services.BuildSimulatedDelivery(&request)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid simulation", err)
+		return
+	}
+
+	started := time.Now()
+	simulation := &services.Simulation{
+		Time:    started,
+		Event:   event,
+		Payload: body,
+		Request: request,
+	}
+
+	// Signed with the configured secret like GitHub would, unless the
+	// request brings its own signature to try a rejection
+	secret := h.config.GitHub.WebhookSecret
+	simulation.Signature.Header = request.Signature
+	if simulation.Signature.Header == "" && secret != "" {
+		simulation.Signature.Header = services.SignGitHubPayload(secret, body)
+	}
+	simulation.Signature.Checked = secret != ""
+	if err := services.VerifyGitHubSignature(secret, body, simulation.Signature.Header); err != nil {
+		simulation.Signature.Rejected = true
+		simulation.Signature.Error = err.Error()
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		h.respondError(c, http.StatusInternalServerError, "Failed to decode simulated payload", err)
+		return
+	}
+
+	recorder := services.NewCommentRecorder()
+	switch event {
+	case "pull_request":
+		edit, ok := extractDescriptionEdit(payload)
+		if !ok {
+			simulation.Outcome = services.WebhookIgnored
+			break
+		}
+		simulation.Outcome = h.applyDescriptionEdit(c.Request.Context(), edit, recorder).Outcome
+	default:
+		comment, ok := extractCommentEvent(event, payload)
+		if !ok || !h.acceptsComment(comment) {
+			simulation.Outcome = services.WebhookIgnored
+			break
+		}
+		run := h.runCommentCommand(c.Request.Context(), comment, request.Branch, recorder)
+		simulation.Outcome = run.Outcome
+		simulation.Error = run.Error
+		simulation.Command = run.Command
+		simulation.Response = run.Response
+		if run.Response != nil && request.Format != "" {
+			simulation.Rendered, _ = services.RenderResponse(request.Format, run.Response)
+		}
+	}
+	simulation.Comments = recorder.Comments()
+	simulation.Duration = time.Since(started).Round(time.Millisecond).String()
+	h.simulations.Add(simulation)
+
+	response := types.Response{
+		Success:   simulation.Outcome != services.WebhookError,
+		Message:   "Simulated delivery " + simulation.Outcome,
+		Timestamp: time.Now(),
+		Data:      simulation,
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ListSimulations returns the recorded simulations, newest first
+func (h *Handler) ListSimulations(c *gin.Context) {
+	response := types.Response{
+		Success:   true,
+		Message:   "Simulated deliveries",
+		Timestamp: time.Now(),
+		Data:      h.simulations.List(),
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetSimulation returns one recorded simulation
+func (h *Handler) GetSimulation(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Invalid simulation ID", err)
+		return
+	}
+	simulation, ok := h.simulations.Get(id)
+	if !ok {
+		h.respondError(c, http.StatusNotFound, "Simulation not found", nil)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Simulated delivery",
+		Timestamp: time.Now(),
+		Data:      simulation,
+	}
+	c.JSON(http.StatusOK, response)
+}
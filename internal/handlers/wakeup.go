@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+// wakeRetrySeconds is how soon the waking up page reloads
+const wakeRetrySeconds = 3
+
+// wakingPage reloads itself until the preview answers in its place
+const wakingPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="%d">
+<title>Waking up %s</title>
+<style>body{font-family:system-ui,sans-serif;text-align:center;margin-top:20vh;color:#333}</style>
+</head>
+<body>
+<h1>💤 Waking up the preview</h1>
+<p>%s was scaled to zero while nobody was using it. It's starting again and this page reloads once it's ready, usually within a minute.</p>
+</body>
+</html>
+`
+
+// ServePreviewWakeup answers the requests ingress-nginx sends the bot when
+// an asleep preview has no pods to serve them. It wakes the preview and
+// shows a page that reloads until the preview answers instead. ingress-nginx
+// rewrites these requests to / and names the preview in X-Namespace, which
+// is only believed from PREVIEW_ACTIVATOR_SOURCES.
+func (h *Handler) ServePreviewWakeup(c *gin.Context) {
+	namespace := c.GetHeader("X-Namespace")
+	if h.activator == nil || c.GetHeader("X-Code") == "" || !strings.HasPrefix(namespace, "preview-") || !h.activator.TrustedSource(c.Request.RemoteAddr) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if !h.wakePreview(c.Writer, c.Request, namespace, strings.Contains(c.GetHeader("X-Format"), "text/html")) {
+		c.String(http.StatusServiceUnavailable, "The preview is not responding. It may still be starting; try again in a minute.")
+	}
+}
+
+// wakePreview wakes the preview in namespace and answers with the waking up
+// page, or a plain text note unless page. It's false, having written
+// nothing, when the preview isn't asleep.
+func (h *Handler) wakePreview(w http.ResponseWriter, r *http.Request, namespace string, page bool) bool {
+	awake, err := h.activator.Wake(r.Context(), namespace)
+	if errors.Is(err, services.ErrPreviewNotAsleep) {
+		return false
+	}
+	if err != nil {
+		// Concurrent wakeups conflict on the namespace; the first one wins
+		// and the page retries either way
+		fmt.Printf("Warning: failed to wake %s: %v\n", namespace, err)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	retry := wakeRetrySeconds
+	if awake {
+		retry = 0
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(retry))
+	if !page {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "The preview is waking up; retry in a few seconds.")
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, wakingPage, retry, html.EscapeString(namespace), html.EscapeString(namespace))
+	return true
+}
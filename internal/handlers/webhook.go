@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,70 +15,64 @@ import (
 	"pr-previews/internal/types"
 )
 
+// GitHubWebhook receives GitHub deliveries. They are checked against
+// GITHUB_WEBHOOK_SECRET and for the fields their event needs, then buffered
+// and processed asynchronously; to try commands without GitHub, use
+// /debug/simulate.
 func (h *Handler) GitHubWebhook(c *gin.Context) {
-	var payload map[string]interface{}
-
-	if c.Request.Method == "POST" {
-		c.ShouldBindJSON(&payload)
-	}
-
-	if payload == nil {
-		payload = make(map[string]interface{})
-	}
-
-	commentBody := c.Query("comment")
-	if commentBody == "" {
+	event := c.GetHeader("X-GitHub-Event")
+	if event == "" {
 		response := types.Response{
 			Success:   true,
-			Message:   "GitHub webhook received",
+			Message:   "GitHub webhook endpoint",
 			Timestamp: time.Now(),
 			Data: map[string]interface{}{
-				"note":   "Add ?comment=/help&user=yourname to test",
-				"method": c.Request.Method,
-				"examples": map[string]string{
-					"help":    "/webhook/github?comment=/help&user=testuser",
-					"status":  "/webhook/github?comment=/status&user=testuser",
-					"preview": "/webhook/github?comment=/preview&user=abdullahainun",
-					"cleanup": "/webhook/github?comment=/cleanup&user=abdullahainun",
-				},
+				"note":      "Deliveries need an X-GitHub-Event header",
+				"simulator": h.config.Debug.Simulate,
 			},
 		}
 		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	user := c.Query("user")
-	if user == "" {
-		user = "testuser"
+	body, ok := h.readWebhookBody(c)
+	if !ok {
+		return
 	}
-	prNumber := 123
-
-	// Create services
-	cmdService, err := services.NewCommandServiceK8s()
-	if err != nil {
-		h.respondError(c, http.StatusInternalServerError, "Failed to create K8s service", err)
+	if err := services.VerifyGitHubSignature(h.config.GitHub.WebhookSecret, body, c.GetHeader(services.GitHubSignatureHeader)); err != nil {
+		h.webhookStats.Record("", event, "", services.WebhookRejected)
+		h.respondError(c, http.StatusUnauthorized, "Invalid webhook signature", err)
 		return
 	}
 
-	basicService := services.NewCommandService()
-	cmd, err := basicService.ParseCommand(commentBody, user, prNumber)
-	if err != nil {
-		response := types.Response{
-			Success:   false,
-			Message:   "Command parsing failed",
-			Error:     err.Error(),
-			Timestamp: time.Now(),
-		}
-		c.JSON(http.StatusBadRequest, response)
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		h.webhookStats.Record("", event, "", services.WebhookInvalid)
+		h.respondError(c, http.StatusBadRequest, "Invalid JSON payload", err)
 		return
 	}
+	if err := services.ValidateGitHubPayload(event, payload); err != nil {
+		repo, action := deliveryRepoAction(payload)
+		h.webhookStats.Record(repo, event, action, services.WebhookInvalid)
+		h.respondError(c, http.StatusUnprocessableEntity, "Webhook payload failed validation", err)
+		return
+	}
+	h.enqueueGitHubEvent(c, event, payload)
+}
 
+// dispatchCommand routes a parsed command to its handler, enforcing permissions
+func (h *Handler) dispatchCommand(ctx context.Context, cmdService *services.CommandServiceK8s, basicService *services.CommandService, cmd *types.Command) *types.CommandResponse {
 	// Process command
 	var cmdResponse *types.CommandResponse
 
+	// Maintenance windows turn away new deployments; reads and cleanup run
+	if services.MaintenancePauses(cmd.Type) && h.maintenance.Status(ctx).Enabled {
+		return h.maintenance.PausedResponse(ctx, h.lang, cmd)
+	}
+
 	switch cmd.Type {
 	case "help":
-		cmdResponse = basicService.ProcessCommand(cmd)
+		cmdResponse = basicService.ProcessCommand(ctx, cmd)
 		if cmdResponse.Success {
 			// Add manifest services info to help
 			repoPath := "."
@@ -86,31 +85,174 @@ func (h *Handler) GitHubWebhook(c *gin.Context) {
 			cmdResponse.Content += manifestInfo
 		}
 	case "status":
-		cmdResponse = cmdService.HandleStatusK8s(c.Request.Context(), cmd)
+		cmdResponse = cmdService.HandleStatusK8s(ctx, cmd)
 	case "plan":
-		cmdResponse = basicService.ProcessCommand(cmd)
+		// Plans run the PR's Terraform and read its manifests
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.plan"),
+			}
+		} else {
+			cmdResponse = basicService.ProcessCommand(ctx, cmd)
+			impactSection, impact := cmdService.ResourceImpactSection(ctx, cmd, ".")
+			cmdResponse.Content += impactSection
+			if cmdResponse.Data != nil {
+				cmdResponse.Data["resource_impact"] = impact
+			}
+			cmdResponse.Content += cmdService.TerraformPlanSection(ctx, cmd.PRNumber, ".")
+		}
+	case "queue":
+		cmdResponse = basicService.HandleQueue(cmd, h.queue)
+	case "services":
+		cmdResponse = cmdService.HandleServicesK8s(ctx, cmd, ".")
+	case "inspect":
+		cmdResponse = cmdService.HandleInspectK8s(ctx, cmd)
+	case "config":
+		cmdResponse = cmdService.HandleConfigK8s(ctx, cmd, ".")
 	case "preview":
-		if !hasDeploymentPermission(cmd.User) {
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
 			cmdResponse = &types.CommandResponse{
 				Success: false,
 				Message: "Access denied",
-				Content: "🔒 Access denied. Only core team can deploy.",
+				Content: h.lang.T("denied.deploy"),
 			}
 		} else {
 			// Use enhanced preview with manifest support
 			repoPath := "." // Current directory
-			cmdResponse = cmdService.HandlePreviewK8sEnhanced(c.Request.Context(), cmd, repoPath)
+			cmdResponse = h.runPreviews(ctx, cmdService, cmd, repoPath)
 		}
 	case "cleanup":
-		if !hasDeploymentPermission(cmd.User) {
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
 			cmdResponse = &types.CommandResponse{
 				Success: false,
 				Message: "Access denied",
-				Content: "🔒 Access denied. Only core team can cleanup.",
+				Content: h.lang.T("denied.cleanup"),
 			}
 		} else {
-			cmdResponse = cmdService.HandleCleanupK8s(c.Request.Context(), cmd)
+			cmdResponse = cmdService.HandleCleanupK8s(ctx, cmd, ".")
+		}
+	case "loadtest":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.loadtest"),
+			}
+		} else {
+			repoPath := "."
+			cmdResponse = cmdService.HandleLoadTestK8s(ctx, cmd, repoPath)
 		}
+	case "promote":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.promote"),
+			}
+		} else {
+			cmdResponse = cmdService.HandlePromoteK8s(ctx, cmd)
+		}
+	case "snapshot", "restore":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.snapshot"),
+			}
+		} else if cmd.Type == "snapshot" {
+			cmdResponse = cmdService.HandleSnapshotK8s(ctx, cmd)
+		} else {
+			cmdResponse = cmdService.HandleRestoreK8s(ctx, cmd, ".")
+		}
+	case "kubeconfig":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.kubeconfig"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleKubeconfigK8s(ctx, cmd)
+		}
+	case "chaos":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.chaos"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleChaosK8s(ctx, cmd)
+		}
+	case "canary":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.canary"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleCanaryK8s(ctx, cmd)
+		}
+	case "scale":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.scale"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleScaleK8s(ctx, cmd)
+		}
+	case "tap":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.tap"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleTapK8s(ctx, cmd)
+		}
+	case "share":
+		if !h.hasDeploymentPermission(ctx, cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.share"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleShareK8s(ctx, cmd)
+			if cmdResponse.Success && cmdResponse.Data != nil {
+				// The URL carries the token, which stays out of the audit log
+				action, details := "share.create", map[string]interface{}{}
+				for key, value := range cmdResponse.Data {
+					if key != "url" {
+						details[key] = value
+					}
+				}
+				if cmd.Args["revoke"] == "true" {
+					action = "share.revoke"
+				}
+				h.audit.Record(action, cmd.User, cmd.Repo, cmd.PRNumber, details)
+			}
+		}
+	case "cluster-status":
+		// Admins check cluster health from wherever they are, not only the
+		// ops repository
+		if !h.hasAdminPermission(cmd.User) {
+			cmdResponse = &types.CommandResponse{
+				Success: false,
+				Message: "Access denied",
+				Content: h.lang.T("denied.cl_status"),
+			}
+		} else {
+			cmdResponse = cmdService.HandleClusterStatusK8s(ctx, cmd)
+		}
+	case "gc", "list-previews", "cluster-info", "force-cleanup", "grant", "revoke", "maintenance":
+		cmdResponse = h.dispatchOpsCommand(ctx, cmdService, cmd)
 	default:
 		cmdResponse = &types.CommandResponse{
 			Success: false,
@@ -118,32 +260,820 @@ func (h *Handler) GitHubWebhook(c *gin.Context) {
 		}
 	}
 
+	return cmdResponse
+}
+
+// dispatchOpsCommand runs cluster-wide commands, which are only accepted from
+// the ops repository and only from admins
+func (h *Handler) dispatchOpsCommand(ctx context.Context, cmdService *services.CommandServiceK8s, cmd *types.Command) *types.CommandResponse {
+	if h.config.GitHub.OpsRepo == "" || !strings.EqualFold(cmd.Repo, h.config.GitHub.OpsRepo) {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Ops commands are disabled here",
+			Content: h.lang.T("ops.wrong_repo"),
+		}
+	}
+	if !h.hasAdminPermission(cmd.User) {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Access denied",
+			Content: h.lang.T("denied.ops"),
+		}
+	}
+
+	switch cmd.Type {
+	case "gc":
+		return cmdService.HandleGarbageCollectK8s(ctx, cmd)
+	case "list-previews":
+		return cmdService.HandleListPreviewsK8s(ctx, cmd)
+	case "force-cleanup":
+		h.audit.Record("namespace.force_cleanup", cmd.User, cmd.Repo, 0, map[string]interface{}{
+			"namespace": cmd.Args["namespace"],
+		})
+		return cmdService.HandleForceCleanupK8s(ctx, cmd)
+	case "grant":
+		result := h.team.HandleGrant(ctx, h.github, cmd)
+		if result.Success {
+			h.audit.Record("team.grant", cmd.User, cmd.Repo, 0, result.Data)
+		}
+		return result
+	case "revoke":
+		result := h.team.HandleRevoke(ctx, cmd)
+		if result.Success {
+			h.audit.Record("team.revoke", cmd.User, cmd.Repo, 0, result.Data)
+		}
+		return result
+	case "maintenance":
+		result := h.maintenance.HandleMaintenance(ctx, cmd)
+		if result.Success && cmd.Args["mode"] != "" {
+			h.audit.Record("maintenance."+cmd.Args["mode"], cmd.User, cmd.Repo, 0, result.Data)
+		}
+		return result
+	default:
+		return cmdService.HandleClusterInfoK8s(ctx, cmd)
+	}
+}
+
+// enqueueGitHubEvent buffers a GitHub delivery for the worker pool, shedding
+// load with 503 when the buffer is full
+func (h *Handler) enqueueGitHubEvent(c *gin.Context, event string, payload map[string]interface{}) {
+	repo, action := deliveryRepoAction(payload)
+	if event == "pull_request" && action == "edited" {
+		h.enqueueDescriptionEdit(c, payload)
+		return
+	}
+	if event == "pull_request" && action == "synchronize" {
+		h.enqueuePullRequestSync(c, payload)
+		return
+	}
+	if event == "workflow_run" && action == "completed" {
+		h.enqueueWorkflowRun(c, payload)
+		return
+	}
+
+	comment, ok := extractCommentEvent(event, payload)
+	if !ok || !h.acceptsComment(comment) {
+		h.webhookStats.Record(repo, event, action, services.WebhookIgnored)
+		response := types.Response{
+			Success:   true,
+			Message:   "Event ignored",
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"event": event,
+			},
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	comment.ReceivedAt = time.Now()
+	letter := githubDeadLetter(c, event, action, comment.Repo, comment.Number, payload)
+	submit := func() bool {
+		return h.webhooks.Submit(func(ctx context.Context) {
+			outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+				return h.processCommentEvent(ctx, comment)
+			})
+			h.webhookStats.Record(repo, event, action, outcome)
+		})
+	}
+
+	// Edits are debounced so a burst of typo fixes runs the command once
+	if comment.Edited {
+		h.edits.Debounce(comment.Key(), func() {
+			if !submit() {
+				h.webhookStats.Record(repo, event, action, services.WebhookError)
+				fmt.Printf("Dropping edited comment %d on PR #%d: webhook buffer full\n", comment.ID, comment.Number)
+			}
+		})
+	} else if !submit() {
+		h.webhookStats.Record(repo, event, action, services.WebhookError)
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Webhook accepted for processing",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"event":     event,
+			"repo":      comment.Repo,
+			"pr_number": comment.Number,
+			"user":      comment.User,
+			"edited":    comment.Edited,
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// enqueueDescriptionEdit re-applies the PR description's settings block to
+// running previews when the body changed
+func (h *Handler) enqueueDescriptionEdit(c *gin.Context, payload map[string]interface{}) {
+	edit, ok := extractDescriptionEdit(payload)
+	if !ok {
+		h.webhookStats.Record(edit.Repo, "pull_request", "edited", services.WebhookIgnored)
+		response := types.Response{
+			Success:   true,
+			Message:   "Event ignored",
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"event": "pull_request",
+			},
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	h.github.InvalidatePullRequest(edit.Repo, edit.Number)
+	letter := githubDeadLetter(c, "pull_request", "edited", edit.Repo, edit.Number, payload)
+	accepted := h.webhooks.Submit(func(ctx context.Context) {
+		outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+			return h.applyDescriptionEdit(ctx, edit, nil)
+		})
+		h.webhookStats.Record(edit.Repo, "pull_request", "edited", outcome)
+	})
+	if !accepted {
+		h.webhookStats.Record(edit.Repo, "pull_request", "edited", services.WebhookError)
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+
 	response := types.Response{
-		Success:   cmdResponse.Success,
-		Message:   cmdResponse.Message,
+		Success:   true,
+		Message:   "Webhook accepted for processing",
 		Timestamp: time.Now(),
 		Data: map[string]interface{}{
-			"command":        cmd,
-			"command_result": cmdResponse,
-			"github_content": cmdResponse.Content,
-			"method":         c.Request.Method,
+			"event":     "pull_request",
+			"repo":      edit.Repo,
+			"pr_number": edit.Number,
 		},
 	}
+	c.JSON(http.StatusAccepted, response)
+}
 
-	if !cmdResponse.Success {
-		response.Error = cmdResponse.Message
-		response.Success = false
+// enqueuePullRequestSync cleans up the previews of services a push removed
+// from the PR
+func (h *Handler) enqueuePullRequestSync(c *gin.Context, payload map[string]interface{}) {
+	sync, ok := extractPullRequestSync(payload)
+	if !ok {
+		h.webhookStats.Record(sync.Repo, "pull_request", "synchronize", services.WebhookIgnored)
+		response := types.Response{
+			Success:   true,
+			Message:   "Event ignored",
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"event": "pull_request",
+			},
+		}
+		c.JSON(http.StatusOK, response)
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	// The head moved, so a cached branch, head or file list is stale
+	h.github.InvalidatePullRequest(sync.Repo, sync.Number)
+	letter := githubDeadLetter(c, "pull_request", "synchronize", sync.Repo, sync.Number, payload)
+	accepted := h.webhooks.Submit(func(ctx context.Context) {
+		outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+			return h.applyPullRequestSync(ctx, sync)
+		})
+		h.webhookStats.Record(sync.Repo, "pull_request", "synchronize", outcome)
+	})
+	if !accepted {
+		h.webhookStats.Record(sync.Repo, "pull_request", "synchronize", services.WebhookError)
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Webhook accepted for processing",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"event":     "pull_request",
+			"repo":      sync.Repo,
+			"pr_number": sync.Number,
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
 }
 
-func hasDeploymentPermission(user string) bool {
-	coreTeam := []string{"abdullahainun"}
-	for _, member := range coreTeam {
-		if user == member {
+// enqueueWorkflowRun completes the check run of an E2E suite the bot
+// dispatched
+func (h *Handler) enqueueWorkflowRun(c *gin.Context, payload map[string]interface{}) {
+	run, ok := extractWorkflowRun(payload)
+	if !ok || h.config.E2E.Dispatch == "" {
+		h.webhookStats.Record(run.Repo, "workflow_run", "completed", services.WebhookIgnored)
+		response := types.Response{
+			Success:   true,
+			Message:   "Event ignored",
+			Timestamp: time.Now(),
+			Data: map[string]interface{}{
+				"event": "workflow_run",
+			},
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	letter := githubDeadLetter(c, "workflow_run", "completed", run.Repo, 0, payload)
+	accepted := h.webhooks.Submit(func(ctx context.Context) {
+		outcome := h.deliver(ctx, letter, func(ctx context.Context) deliveryResult {
+			return h.applyWorkflowRun(ctx, run)
+		})
+		h.webhookStats.Record(run.Repo, "workflow_run", "completed", outcome)
+	})
+	if !accepted {
+		h.webhookStats.Record(run.Repo, "workflow_run", "completed", services.WebhookError)
+		c.Header("Retry-After", "30")
+		h.respondError(c, http.StatusServiceUnavailable, "Webhook buffer full, retry later", nil)
+		return
+	}
+
+	response := types.Response{
+		Success:   true,
+		Message:   "Webhook accepted for processing",
+		Timestamp: time.Now(),
+		Data: map[string]interface{}{
+			"event": "workflow_run",
+			"repo":  run.Repo,
+		},
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// applyWorkflowRun reports a finished E2E workflow on its check run
+func (h *Handler) applyWorkflowRun(ctx context.Context, result services.WorkflowRunResult) deliveryResult {
+	run, err := services.CompleteE2ERun(ctx, h.github, h.config.E2E.Workflow, result)
+	if run == nil && err == nil {
+		return deliveryResult{Outcome: services.WebhookIgnored}
+	}
+	if err != nil {
+		fmt.Printf("Failed to report E2E result on %s: %v\n", result.Repo, err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err, Retryable: true}
+	}
+	h.timeline.Record(ctx, services.TimelineEntry{
+		Kind:     services.TimelineLog,
+		Repo:     run.Repo,
+		PRNumber: run.PRNumber,
+		Message:  fmt.Sprintf("E2E for %s finished: %s (%s)", run.Service, result.Conclusion, result.HTMLURL),
+		Details: map[string]interface{}{
+			"e2e":        true,
+			"service":    run.Service,
+			"conclusion": result.Conclusion,
+			"url":        result.HTMLURL,
+		},
+	})
+	if h.config.GitHub.SummaryCheck != "" {
+		if cmdService, err := services.NewCommandServiceK8s(h.config); err == nil {
+			cmdService.RefreshCheckSummary(run.Repo, run.PRNumber)
+		}
+	}
+	return deliveryResult{Outcome: services.WebhookProcessed}
+}
+
+// applyPullRequestSync deletes previews of services the push removed.
+// Cleanup can be repeated, so every failure is retried.
+func (h *Handler) applyPullRequestSync(ctx context.Context, sync pullRequestSync) deliveryResult {
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		fmt.Printf("Failed to check PR #%d for removed services: %v\n", sync.Number, err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err, Retryable: true}
+	}
+
+	result := cmdService.HandlePullRequestSync(ctx, sync.Repo, sync.Number, sync.Before, sync.After)
+	if !result.Success {
+		fmt.Printf("Sync cleanup on PR #%d: %s %v\n", sync.Number, result.Message, result.Data)
+		return deliveryResult{Outcome: services.WebhookError, Err: fmt.Errorf("%s: %v", result.Message, result.Data), Retryable: true}
+	}
+	if result.Content == "" {
+		return deliveryResult{Outcome: services.WebhookIgnored}
+	}
+	h.audit.Record("preview.sync_cleanup", sync.Sender, sync.Repo, sync.Number, result.Data)
+	return deliveryResult{Outcome: services.WebhookProcessed}
+}
+
+// applyDescriptionEdit updates running previews from a description edited
+// by a deployer and posts what changed through comments, or on the GitHub PR
+// when comments is nil
+func (h *Handler) applyDescriptionEdit(ctx context.Context, edit descriptionEdit, comments services.PullRequestCommenter) deliveryResult {
+	// The settings block sets env and lifetimes like /preview does
+	if edit.Editor == "" || !h.hasDeploymentPermission(ctx, edit.Editor) {
+		fmt.Printf("Ignoring description edit on %s#%d by %s: not a deployer\n", edit.Repo, edit.Number, edit.Editor)
+		return deliveryResult{Outcome: services.WebhookIgnored}
+	}
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		fmt.Printf("Failed to apply PR settings on PR #%d: %v\n", edit.Number, err)
+		return deliveryResult{Outcome: services.WebhookError, Err: err, Retryable: true}
+	}
+	if comments != nil {
+		cmdService.WithCommenter(comments)
+	}
+
+	result := cmdService.HandleDescriptionEdit(ctx, edit.Repo, edit.Number, edit.Previous, edit.Current)
+	if result.Content == "" {
+		return deliveryResult{Outcome: services.WebhookIgnored}
+	}
+	outcome := services.WebhookProcessed
+	if err := cmdService.Commenter().PostComment(ctx, edit.Repo, edit.Number, result.Content); err != nil {
+		outcome = services.WebhookError
+		fmt.Printf("Warning: %v\n", err)
+	}
+	cmdService.RefreshPreviewSummary(edit.Repo, edit.Number)
+	return deliveryResult{Outcome: outcome}
+}
+
+// acceptsComment applies the filters a comment passes before a worker runs
+// it: a PR (or ops issue) comment starting with "/", and for edits, one that
+// changes the command
+func (h *Handler) acceptsComment(comment commentEvent) bool {
+	isOpsIssue := h.config.GitHub.OpsRepo != "" && strings.EqualFold(comment.Repo, h.config.GitHub.OpsRepo)
+	if !comment.IsPR && !isOpsIssue {
+		return false
+	}
+	if !strings.HasPrefix(strings.TrimSpace(comment.Body), "/") {
+		return false
+	}
+	return !comment.Edited || h.shouldRerunEdit(comment)
+}
+
+// shouldRerunEdit decides whether an edited comment re-issues its command:
+// edits must be enabled, the new body must parse, and the command must differ
+// from what the comment said (or last ran) before
+func (h *Handler) shouldRerunEdit(comment commentEvent) bool {
+	if !h.config.Webhook.HandleEdits {
+		return false
+	}
+
+	basicService := services.NewCommandService(h.lang, h.team)
+	cmd, err := basicService.ParseCommand(comment.Body, comment.User, comment.Number)
+	if err != nil {
+		return false
+	}
+	if previous, err := basicService.ParseCommand(comment.PreviousBody, comment.User, comment.Number); err == nil {
+		if services.CommandKey(previous) == services.CommandKey(cmd) {
+			return false
+		}
+	}
+
+	return h.edits.MarkExecuted(comment.Key(), cmd)
+}
+
+// processCommentEvent runs a buffered comment command on a worker and posts
+// the result back to the PR. Only a command that never ran is retried; a
+// reply GitHub refused is already in the comment outbox.
+func (h *Handler) processCommentEvent(ctx context.Context, comment commentEvent) deliveryResult {
+	var branch string
+	if comment.IsPR {
+		branch, _ = h.github.GetPullRequestBranch(ctx, comment.Repo, comment.Number)
+	}
+	run := h.runCommentCommand(ctx, comment, branch, nil)
+	if run.Outcome == services.WebhookError && run.Response == nil {
+		return deliveryResult{Outcome: run.Outcome, Err: errors.New(run.Error), Retryable: true}
+	}
+	return deliveryResult{Outcome: run.Outcome}
+}
+
+// commentRun is what running one comment command produced
+type commentRun struct {
+	Command  *types.Command
+	Response *types.CommandResponse
+	Outcome  string
+	Error    string
+}
+
+// runCommentCommand parses and runs a comment's command on branch, posting
+// the reply and any follow-ups through comments, or on the GitHub PR when
+// comments is nil
+func (h *Handler) runCommentCommand(ctx context.Context, comment commentEvent, branch string, comments services.PullRequestCommenter) commentRun {
+	basicService := services.NewCommandService(h.lang, h.team)
+	cmd, err := basicService.ParseCommand(comment.Body, comment.User, comment.Number)
+	if err != nil {
+		fmt.Printf("Ignoring comment on PR #%d: %v\n", comment.Number, err)
+		return commentRun{Outcome: services.WebhookIgnored, Error: err.Error()}
+	}
+	cmd.Repo = comment.Repo
+	if branch != "" && services.ValidateBranchName(branch) == nil {
+		cmd.Branch = branch
+	}
+
+	cmdService, err := services.NewCommandServiceK8s(h.config)
+	if err != nil {
+		fmt.Printf("Failed to process /%s on PR #%d: %v\n", cmd.Type, comment.Number, err)
+		return commentRun{Command: cmd, Outcome: services.WebhookError, Error: err.Error()}
+	}
+	if comments != nil {
+		cmdService.WithCommenter(comments)
+	}
+
+	// While the cluster is unreachable, commands that need it fail fast
+	// instead of each timing out; deploys and cleanups wait for it
+	var cmdResponse *types.CommandResponse
+	if services.NeedsCluster(cmd.Type) && services.SharedClusterBreaker().Open() {
+		cmdResponse = h.clusterUnavailable(cmd, func() {
+			// Back on a worker, under the server's context
+			if !h.webhooks.Submit(func(ctx context.Context) {
+				h.runCommentCommand(ctx, comment, branch, comments)
+			}) {
+				fmt.Printf("Warning: held /%s on %s#%d dropped: webhook buffer full\n", cmd.Type, comment.Repo, comment.Number)
+			}
+		})
+	} else {
+		cmdResponse = h.dispatchCommand(ctx, cmdService, basicService, cmd)
+	}
+	cmdService.RecordCommand(ctx, cmd, comment.Body, cmdResponse)
+	run := commentRun{Command: cmd, Response: cmdResponse, Outcome: services.WebhookProcessed}
+	if cmdResponse.Content == "" {
+		return run
+	}
+	if err := cmdService.Commenter().PostComment(ctx, comment.Repo, comment.Number, cmdResponse.Content); err != nil {
+		run.Outcome = services.WebhookError
+		run.Error = err.Error()
+		fmt.Printf("Warning: %v\n", err)
+	} else if !comment.ReceivedAt.IsZero() && comments == nil {
+		services.SharedCommentMetrics().ObserveReply(cmd.Type, time.Since(comment.ReceivedAt))
+	}
+
+	// Keep the pinned summary in step with commands that change previews;
+	// the summary check run records every command
+	switch cmd.Type {
+	case "preview", "cleanup", "restore":
+		cmdService.RefreshPreviewSummary(comment.Repo, comment.Number)
+	default:
+		if comments == nil {
+			cmdService.RefreshCheckSummary(comment.Repo, comment.Number)
+		}
+	}
+
+	return run
+}
+
+// deliveryRepoAction reads the repository and action common to most GitHub
+// deliveries; either may be empty
+func deliveryRepoAction(payload map[string]interface{}) (string, string) {
+	repository, _ := payload["repository"].(map[string]interface{})
+	repo, _ := repository["full_name"].(string)
+	action, _ := payload["action"].(string)
+	return repo, action
+}
+
+// descriptionEdit is the part of an edited pull_request delivery the bot
+// acts on
+type descriptionEdit struct {
+	Repo     string
+	Number   int
+	Previous string
+	Current  string
+	Editor   string
+}
+
+// extractDescriptionEdit reads an edited pull_request delivery, which only
+// matters when the body changed
+func extractDescriptionEdit(payload map[string]interface{}) (descriptionEdit, bool) {
+	pr, _ := payload["pull_request"].(map[string]interface{})
+	changes, _ := payload["changes"].(map[string]interface{})
+	bodyChange, _ := changes["body"].(map[string]interface{})
+	repository, _ := payload["repository"].(map[string]interface{})
+	repo, _ := repository["full_name"].(string)
+	sender, _ := payload["sender"].(map[string]interface{})
+	number, _ := pr["number"].(float64)
+
+	edit := descriptionEdit{Repo: repo, Number: int(number)}
+	edit.Previous, _ = bodyChange["from"].(string)
+	edit.Current, _ = pr["body"].(string)
+	edit.Editor, _ = sender["login"].(string)
+	return edit, pr != nil && bodyChange != nil && repo != "" && number > 0
+}
+
+// pullRequestSync is the part of a synchronize pull_request delivery the
+// bot acts on: the head before and after the push
+type pullRequestSync struct {
+	Repo   string
+	Number int
+	Before string
+	After  string
+	Sender string
+}
+
+func extractPullRequestSync(payload map[string]interface{}) (pullRequestSync, bool) {
+	pr, _ := payload["pull_request"].(map[string]interface{})
+	repository, _ := payload["repository"].(map[string]interface{})
+	sender, _ := payload["sender"].(map[string]interface{})
+	number, _ := pr["number"].(float64)
+
+	sync := pullRequestSync{Number: int(number)}
+	sync.Repo, _ = repository["full_name"].(string)
+	sync.Before, _ = payload["before"].(string)
+	sync.After, _ = payload["after"].(string)
+	sync.Sender, _ = sender["login"].(string)
+	return sync, pr != nil && sync.Repo != "" && number > 0 && sync.Before != "" && sync.After != ""
+}
+
+func extractWorkflowRun(payload map[string]interface{}) (services.WorkflowRunResult, bool) {
+	workflowRun, _ := payload["workflow_run"].(map[string]interface{})
+	repository, _ := payload["repository"].(map[string]interface{})
+
+	var run services.WorkflowRunResult
+	run.Repo, _ = repository["full_name"].(string)
+	run.HeadSHA, _ = workflowRun["head_sha"].(string)
+	run.Event, _ = workflowRun["event"].(string)
+	run.Path, _ = workflowRun["path"].(string)
+	run.DisplayTitle, _ = workflowRun["display_title"].(string)
+	run.Conclusion, _ = workflowRun["conclusion"].(string)
+	run.HTMLURL, _ = workflowRun["html_url"].(string)
+	return run, workflowRun != nil && run.Repo != ""
+}
+
+// commentEvent is the part of a comment delivery the bot acts on: an issue
+// or PR comment, a review comment on a diff line, or a review's body
+type commentEvent struct {
+	Event        string // the delivery's event type, which scopes ID
+	ID           int64
+	Body         string
+	PreviousBody string // body before the edit, for edited comments
+	User         string
+	Repo         string
+	Number       int
+	IsPR         bool
+	Edited       bool
+	ReceivedAt   time.Time // when the webhook arrived, for reply latency; zero for replays
+}
+
+// Key identifies the comment across event types: issue comments, review
+// comments and reviews are numbered separately, so their IDs can collide
+func (ce commentEvent) Key() string {
+	return fmt.Sprintf("%s/%d", ce.Event, ce.ID)
+}
+
+// extractCommentEvent pulls the comment, author, repo and issue number from
+// an issue_comment, pull_request_review_comment or pull_request_review
+// delivery, and whether the issue is a pull request. All three feed the same
+// commands, so they work wherever reviewers type them.
+func extractCommentEvent(event string, payload map[string]interface{}) (commentEvent, bool) {
+	action, _ := payload["action"].(string)
+
+	var comment, issue map[string]interface{}
+	var isPR bool
+	switch event {
+	case "issue_comment":
+		if action != "created" && action != "edited" {
+			return commentEvent{}, false
+		}
+		comment, _ = payload["comment"].(map[string]interface{})
+		issue, _ = payload["issue"].(map[string]interface{})
+		isPR = issue["pull_request"] != nil
+	case "pull_request_review_comment":
+		if action != "created" && action != "edited" {
+			return commentEvent{}, false
+		}
+		comment, _ = payload["comment"].(map[string]interface{})
+		issue, _ = payload["pull_request"].(map[string]interface{})
+		isPR = true
+	case "pull_request_review":
+		// A review's body arrives once, when it's submitted
+		if action != "submitted" && action != "edited" {
+			return commentEvent{}, false
+		}
+		comment, _ = payload["review"].(map[string]interface{})
+		issue, _ = payload["pull_request"].(map[string]interface{})
+		isPR = true
+	default:
+		return commentEvent{}, false
+	}
+	if comment == nil || issue == nil {
+		return commentEvent{}, false
+	}
+
+	body, _ := comment["body"].(string)
+	id, _ := comment["id"].(float64)
+	author, _ := comment["user"].(map[string]interface{})
+	login, _ := author["login"].(string)
+	number, _ := issue["number"].(float64)
+	repository, _ := payload["repository"].(map[string]interface{})
+	repo, _ := repository["full_name"].(string)
+
+	var previousBody string
+	if changes, ok := payload["changes"].(map[string]interface{}); ok {
+		if previous, ok := changes["body"].(map[string]interface{}); ok {
+			previousBody, _ = previous["from"].(string)
+		}
+	}
+
+	parsed := commentEvent{
+		Event:        event,
+		ID:           int64(id),
+		Body:         body,
+		PreviousBody: previousBody,
+		User:         login,
+		Repo:         repo,
+		Number:       int(number),
+		IsPR:         isPR,
+		Edited:       action == "edited",
+	}
+	return parsed, body != "" && login != "" && number > 0
+}
+
+// runPreviews deploys the requested service, or every service listed in the
+// PR description's settings block for a bare /preview
+func (h *Handler) runPreviews(ctx context.Context, cmdService *services.CommandServiceK8s, cmd *types.Command, repoPath string) *types.CommandResponse {
+	if cmd.Service == services.PreviewAllService {
+		return h.runPreviewAll(ctx, cmdService, cmd, repoPath)
+	}
+	if cmd.Service != "" {
+		return h.runQueuedPreview(ctx, cmdService, cmd, repoPath)
+	}
+
+	settings, err := cmdService.LoadPRSettings(ctx, cmd)
+	if err != nil || settings == nil || len(settings.Services) == 0 {
+		// Errors surface from the deploy itself, which re-reads the settings
+		return h.runQueuedPreview(ctx, cmdService, cmd, repoPath)
+	}
+
+	var contents []string
+	success := true
+	for _, service := range settings.Services {
+		serviceCmd := *cmd
+		serviceCmd.Service = service
+		result := h.runQueuedPreview(ctx, cmdService, &serviceCmd, repoPath)
+		success = success && result.Success
+		contents = append(contents, result.Content)
+	}
+
+	return &types.CommandResponse{
+		Success: success,
+		Message: "Previews from PR settings",
+		Content: strings.Join(contents, "\n\n---\n\n"),
+		Data: map[string]interface{}{
+			"services": settings.Services,
+		},
+	}
+}
+
+// runPreviewAll deploys every service of the repo into the PR's shared
+// namespace, once the quotas have room for all of them
+func (h *Handler) runPreviewAll(ctx context.Context, cmdService *services.CommandServiceK8s, cmd *types.Command, repoPath string) *types.CommandResponse {
+	plan, refused := cmdService.PlanPreviewAll(ctx, cmd, repoPath)
+	if refused != nil {
+		return refused
+	}
+
+	contents := []string{fmt.Sprintf("## 🌐 Preview All\n\nDeploying %d services into one namespace; each container gets the others' URLs (e.g. `%s`) from the `preview-services` ConfigMap.",
+		len(plan.Services), exampleEndpoint(plan.Endpoints))}
+	for _, warning := range plan.Warnings {
+		contents[0] += "\n- ⚠️ " + warning
+	}
+	success := true
+	for _, service := range plan.Services {
+		result := h.runQueuedPreview(ctx, cmdService, services.PreviewAllCommand(cmd, service), repoPath)
+		success = success && result.Success
+		contents = append(contents, result.Content)
+	}
+
+	h.audit.Record("preview.all", cmd.User, cmd.Repo, cmd.PRNumber, map[string]interface{}{
+		"services": plan.Services,
+		"success":  success,
+	})
+	return &types.CommandResponse{
+		Success: success,
+		Message: "Previews of every service",
+		Content: strings.Join(contents, "\n\n---\n\n"),
+		Data: map[string]interface{}{
+			"services": plan.Services,
+			"plan":     plan,
+		},
+	}
+}
+
+// exampleEndpoint picks one wired URL variable to show
+func exampleEndpoint(endpoints map[string]string) string {
+	names := make([]string, 0, len(endpoints))
+	for name := range endpoints {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "<SERVICE>_URL"
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// runQueuedPreview deploys right away when a slot is free, otherwise queues
+// the deployment and reports its position
+func (h *Handler) runQueuedPreview(ctx context.Context, cmdService *services.CommandServiceK8s, cmd *types.Command, repoPath string) *types.CommandResponse {
+	job := h.queue.NewJob(cmd.Repo, cmd.PRNumber, cmd.Service, cmd.User, func() {
+		result := cmdService.HandlePreviewK8sEnhanced(context.Background(), cmd, repoPath)
+		if err := cmdService.Commenter().PostComment(context.Background(), cmd.Repo, cmd.PRNumber, result.Content); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		cmdService.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+	})
+
+	if reason := h.previewPriority(ctx, cmd); reason != "" {
+		job.Priority = services.PriorityUrgent
+		h.audit.Record("queue.priority", cmd.User, cmd.Repo, cmd.PRNumber, map[string]interface{}{
+			"job_id":  job.ID,
+			"service": cmd.Service,
+			"reason":  reason,
+		})
+	}
+
+	if !h.queue.TryStart(job) {
+		queueResponse := services.NewCommandService(h.lang, h.team).HandleQueue(cmd, h.queue)
+		return &types.CommandResponse{
+			Success: true,
+			Message: "Preview deployment queued",
+			Content: h.lang.T("queue.queued") + "\n\n" + queueResponse.Content,
+			Data: map[string]interface{}{
+				"job_id": job.ID,
+				"status": "queued",
+				"queue":  queueResponse.Data,
+			},
+		}
+	}
+	defer h.queue.Finish(job.ID)
+
+	return cmdService.HandlePreviewK8sEnhanced(ctx, cmd, repoPath)
+}
+
+// previewPriority returns why a preview should jump the queue (the
+// --priority flag or the priority label), or "" for normal priority
+func (h *Handler) previewPriority(ctx context.Context, cmd *types.Command) string {
+	if cmd.Args["priority"] == "true" {
+		return "--priority flag"
+	}
+	if cmd.Repo == "" || h.config.Preview.PriorityLabel == "" {
+		return ""
+	}
+
+	labels, err := h.github.GetPullRequestLabels(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		return ""
+	}
+	for _, label := range labels {
+		if strings.EqualFold(label, h.config.Preview.PriorityLabel) {
+			return fmt.Sprintf("%s label", label)
+		}
+	}
+	return ""
+}
+
+// hasAdminPermission checks the elevated role required for ops commands
+func (h *Handler) hasAdminPermission(user string) bool {
+	for _, admin := range h.config.GitHub.Admins {
+		if strings.EqualFold(user, admin) {
 			return true
 		}
 	}
 	return false
 }
+
+// hasDeploymentPermission checks the core team and runtime /grant roster
+func (h *Handler) hasDeploymentPermission(ctx context.Context, user string) bool {
+	return h.team.IsDeployer(ctx, user)
+}
+
+// clusterUnavailable answers a command while the cluster breaker is open,
+// holding retry for when the cluster is back if the command waits for it
+func (h *Handler) clusterUnavailable(cmd *types.Command, retry func()) *types.CommandResponse {
+	held := services.BreakerHolds(cmd.Type) && services.SharedClusterBreaker().Hold(retry)
+	content := fmt.Sprintf(h.lang.T("cluster.down"), cmd.Type)
+	if held {
+		content = fmt.Sprintf(h.lang.T("cluster.held"), cmd.Type)
+	}
+	h.audit.Record("cluster.unavailable", cmd.User, cmd.Repo, cmd.PRNumber, map[string]interface{}{
+		"command": cmd.Type,
+		"held":    held,
+	})
+	return &types.CommandResponse{
+		Success: false,
+		Message: "Cluster unavailable",
+		Content: content,
+		Data: map[string]interface{}{
+			"held":    held,
+			"breaker": services.SharedClusterBreaker().Stats(),
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"pr-previews/internal/services"
+)
+
+// LimitWebhookPayload turns away webhook deliveries that aren't JSON or are
+// larger than SERVER_MAX_WEBHOOK_BYTES before anything reads them. Bodies
+// without a Content-Length are capped while they're read.
+func (h *Handler) LimitWebhookPayload() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			h.webhookStats.Record("", c.GetHeader("X-GitHub-Event"), "", services.WebhookInvalid)
+			message := "Webhook payloads must be sent as application/json"
+			if mediaType == "application/x-www-form-urlencoded" {
+				message += "; set the webhook's content type to application/json in its settings"
+			}
+			h.respondError(c, http.StatusUnsupportedMediaType, message, fmt.Errorf("got Content-Type %q", c.GetHeader("Content-Type")))
+			c.Abort()
+			return
+		}
+
+		limit := int64(h.config.Server.MaxWebhookBytes)
+		if limit > 0 {
+			if c.Request.ContentLength > limit {
+				h.respondPayloadTooLarge(c, c.Request.ContentLength)
+				c.Abort()
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// readWebhookBody reads a delivery's body, answering 413 or 400 itself when
+// it can't
+func (h *Handler) readWebhookBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.respondPayloadTooLarge(c, -1)
+		return nil, false
+	}
+	if err != nil {
+		h.respondError(c, http.StatusBadRequest, "Failed to read payload", err)
+		return nil, false
+	}
+	if len(body) == 0 {
+		h.webhookStats.Record("", c.GetHeader("X-GitHub-Event"), "", services.WebhookInvalid)
+		h.respondError(c, http.StatusBadRequest, "Webhook payload is empty", nil)
+		return nil, false
+	}
+	return body, true
+}
+
+// respondPayloadTooLarge answers 413; size is -1 when the body had no
+// Content-Length and was cut off while reading
+func (h *Handler) respondPayloadTooLarge(c *gin.Context, size int64) {
+	h.webhookStats.Record("", c.GetHeader("X-GitHub-Event"), "", services.WebhookInvalid)
+	err := fmt.Errorf("payload exceeds the %d byte limit", h.config.Server.MaxWebhookBytes)
+	if size >= 0 {
+		err = fmt.Errorf("payload is %d bytes; the limit is %d", size, h.config.Server.MaxWebhookBytes)
+	}
+	h.respondError(c, http.StatusRequestEntityTooLarge, "Webhook payload too large", err)
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// accessStatsKey is where preview visits lived in the artifact store
+	// before each replica kept its own; it's still read
+	accessStatsKey = "analytics/preview-access.json"
+
+	// accessStatsPrefix holds each replica's visits, in <replica>.json
+	accessStatsPrefix = "analytics/preview-access/"
+
+	// accessRefreshInterval is how stale the other replicas' visits may be
+	accessRefreshInterval = 30 * time.Second
+
+	// accessRetention drops the visits of namespaces unseen for this long,
+	// which are long gone, and the files of replicas gone as long
+	accessRetention = 30 * 24 * time.Hour
+
+	// ingress-nginx copies every request to the mirror target and drops the
+	// mirror's response
+	nginxMirrorTargetAnnotation = "nginx.ingress.kubernetes.io/mirror-target"
+	nginxMirrorBodyAnnotation   = "nginx.ingress.kubernetes.io/mirror-request-body"
+	nginxMirrorHostAnnotation   = "nginx.ingress.kubernetes.io/mirror-host"
+)
+
+// PreviewAccess is how much a preview is used
+type PreviewAccess struct {
+	Requests     int64     `json:"requests"`
+	LastAccessed time.Time `json:"last_accessed"`
+}
+
+// merge adds other's visits to a
+func (a *PreviewAccess) merge(other PreviewAccess) {
+	a.Requests += other.Requests
+	if other.LastAccessed.After(a.LastAccessed) {
+		a.LastAccessed = other.LastAccessed
+	}
+}
+
+// AccessTracker counts requests to each preview namespace, reported by the
+// ingress controller mirroring them to the bot. Each replica keeps the
+// visits it was sent in memory and flushes them to a key of its own in the
+// artifact store periodically, so a restart loses at most one interval.
+// Reads add up every replica's, refreshed every accessRefreshInterval, since
+// the ingress controller mirrors any preview's requests to any replica.
+type AccessTracker struct {
+	mu      sync.Mutex
+	store   ArtifactStore
+	secret  []byte
+	replica string
+	stats   map[string]*PreviewAccess // this replica's
+	others  map[string]PreviewAccess  // every other replica's, added up
+	loaded  bool
+	synced  time.Time // when others was read
+	dirty   bool
+}
+
+// sharedAccessTracker is fed by the webhook server and read by every
+// command service, like the comment outbox
+var sharedAccessTracker = &AccessTracker{stats: map[string]*PreviewAccess{}}
+
+// SharedAccessTracker is the tracker preview ingresses report to
+func SharedAccessTracker() *AccessTracker {
+	return sharedAccessTracker
+}
+
+// Configure sets where visits are persisted, the replica they're persisted
+// as and the key that signs beacon paths. Without a store they are only
+// kept in memory.
+func (t *AccessTracker) Configure(store ArtifactStore, secret, replica string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.store = store
+	t.secret = []byte(secret)
+	t.replica = replica
+	if t.replica == "" {
+		t.replica = "default"
+	}
+	t.loaded = false
+	t.synced = time.Time{}
+}
+
+// BeaconPath is the bot path a namespace's ingress mirrors requests to,
+// signed so visits can't be counted for other namespaces
+func (t *AccessTracker) BeaconPath(namespace string) string {
+	return fmt.Sprintf("/access/%s/%s", namespace, t.sign(namespace))
+}
+
+// Verify reports whether signature belongs to namespace
+func (t *AccessTracker) Verify(namespace, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(t.sign(namespace)))
+}
+
+func (t *AccessTracker) sign(namespace string) string {
+	t.mu.Lock()
+	mac := hmac.New(sha256.New, t.secret)
+	t.mu.Unlock()
+	mac.Write([]byte(namespace))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Record counts one request to the namespace
+func (t *AccessTracker) Record(namespace string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats, ok := t.stats[namespace]
+	if !ok {
+		stats = &PreviewAccess{}
+		t.stats[namespace] = stats
+	}
+	stats.Requests++
+	stats.LastAccessed = time.Now().UTC()
+	t.dirty = true
+}
+
+// Get returns the namespace's visits across replicas, or false when it has
+// none
+func (t *AccessTracker) Get(ctx context.Context, namespace string) (PreviewAccess, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.loadLocked(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := t.syncLocked(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	access, found := t.others[namespace]
+	if stats, ok := t.stats[namespace]; ok {
+		access.merge(*stats)
+		found = true
+	}
+	return access, found
+}
+
+// Run flushes the counts every interval until ctx is cancelled, and once
+// more on the way out
+func (t *AccessTracker) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(context.Background()); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(ctx); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+}
+
+// Flush saves this replica's counts under its own key, dropping namespaces
+// unseen for accessRetention
+func (t *AccessTracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	if err := t.loadLocked(ctx); err != nil {
+		return err
+	}
+	for namespace, stats := range t.stats {
+		if time.Since(stats.LastAccessed) > accessRetention {
+			delete(t.stats, namespace)
+		}
+	}
+	if t.store == nil {
+		t.dirty = false
+		return nil
+	}
+
+	content, err := json.MarshalIndent(t.stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := t.store.Save(ctx, t.keyLocked(), content); err != nil {
+		return fmt.Errorf("failed to save preview access stats: %v", err)
+	}
+	t.dirty = false
+	return nil
+}
+
+// keyLocked is where this replica's visits are stored
+func (t *AccessTracker) keyLocked() string {
+	return accessStatsPrefix + t.replica + ".json"
+}
+
+// loadLocked merges this replica's stored counts, from before a restart,
+// into the ones recorded since
+func (t *AccessTracker) loadLocked(ctx context.Context) error {
+	if t.loaded || t.store == nil {
+		t.loaded = true
+		return nil
+	}
+
+	keys, err := t.store.List(ctx, accessStatsPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load preview access stats: %v", err)
+	}
+	for _, key := range keys {
+		if key != t.keyLocked() {
+			continue
+		}
+		stored, err := t.readLocked(ctx, key)
+		if err != nil {
+			return err
+		}
+		for namespace, previous := range stored {
+			if current, ok := t.stats[namespace]; ok {
+				current.merge(previous)
+				continue
+			}
+			t.stats[namespace] = &previous
+		}
+	}
+
+	t.loaded = true
+	return nil
+}
+
+// syncLocked re-reads the other replicas' counts, and the ones stored
+// before each replica kept its own, once they're accessRefreshInterval old.
+// Files of replicas that recorded nothing for accessRetention are deleted.
+func (t *AccessTracker) syncLocked(ctx context.Context) error {
+	if t.store == nil || time.Since(t.synced) < accessRefreshInterval {
+		return nil
+	}
+
+	keys, err := t.store.List(ctx, accessStatsPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load preview access stats: %v", err)
+	}
+	legacy, err := t.store.List(ctx, accessStatsKey)
+	if err != nil {
+		return fmt.Errorf("failed to load preview access stats: %v", err)
+	}
+	others := map[string]PreviewAccess{}
+	for _, key := range append(keys, legacy...) {
+		if key == t.keyLocked() || (key != accessStatsKey && !strings.HasPrefix(key, accessStatsPrefix)) {
+			continue
+		}
+		stored, err := t.readLocked(ctx, key)
+		if err != nil {
+			return err
+		}
+		var latest time.Time
+		for namespace, previous := range stored {
+			access := others[namespace]
+			access.merge(previous)
+			others[namespace] = access
+			if previous.LastAccessed.After(latest) {
+				latest = previous.LastAccessed
+			}
+		}
+		if key != accessStatsKey && time.Since(latest) > accessRetention {
+			if err := t.store.Delete(ctx, key); err != nil {
+				fmt.Printf("Warning: failed to delete stale preview access stats %s: %v\n", key, err)
+			}
+		}
+	}
+
+	t.others = others
+	t.synced = time.Now()
+	return nil
+}
+
+// readLocked reads one stored set of counts
+func (t *AccessTracker) readLocked(ctx context.Context, key string) (map[string]PreviewAccess, error) {
+	content, err := t.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load preview access stats: %v", err)
+	}
+	var stored map[string]PreviewAccess
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", key, err)
+	}
+	return stored, nil
+}
+
+// accessMirrorAnnotations make ingress-nginx report every request to the
+// preview to the bot, when access tracking is on
+func (cs *CommandServiceK8s) accessMirrorAnnotations(namespace string) map[string]string {
+	if !cs.config.Access.Tracking || cs.config.Server.PublicURL == "" {
+		return nil
+	}
+	target := cs.config.Server.PublicURL + SharedAccessTracker().BeaconPath(namespace)
+	annotations := map[string]string{
+		nginxMirrorTargetAnnotation: target,
+		nginxMirrorBodyAnnotation:   "off",
+	}
+	if parsed, err := url.Parse(target); err == nil && parsed.Host != "" {
+		annotations[nginxMirrorHostAnnotation] = parsed.Host
+	}
+	return annotations
+}
+
+// previewAccess is the namespace's visits. Visits from before the namespace
+// was created belong to an earlier preview of the same name. It's false when
+// access isn't tracked.
+func (cs *CommandServiceK8s) previewAccess(ctx context.Context, ns PreviewNamespace) (PreviewAccess, bool) {
+	if !cs.config.Access.Tracking {
+		return PreviewAccess{}, false
+	}
+	access, ok := SharedAccessTracker().Get(ctx, ns.Name)
+	if !ok || access.LastAccessed.Before(ns.CreatedAt) {
+		return PreviewAccess{}, true
+	}
+	return access, true
+}
+
+// previewLastActive is when the preview was last visited, or deployed when
+// nobody visited it since. It's false when access isn't tracked.
+func (cs *CommandServiceK8s) previewLastActive(ctx context.Context, ns PreviewNamespace) (time.Time, bool) {
+	access, tracked := cs.previewAccess(ctx, ns)
+	if !tracked {
+		return time.Time{}, false
+	}
+	if access.Requests > 0 {
+		return access.LastAccessed, true
+	}
+	return ns.CreatedAt, !ns.CreatedAt.IsZero()
+}
+
+// formatPreviewAccess describes the preview's visits for /status
+func formatPreviewAccess(access PreviewAccess) string {
+	if access.Requests == 0 {
+		return "No visits yet"
+	}
+	return fmt.Sprintf("%d requests, last %s (%s ago)", access.Requests, access.LastAccessed.Format("2006-01-02 15:04 UTC"), formatAge(time.Since(access.LastAccessed)))
+}
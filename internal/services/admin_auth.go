@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"pr-previews/internal/config"
+)
+
+// Admin API roles, each allowing everything the previous one does
+const (
+	AdminRoleViewer   = "viewer"   // read the queue, reports and GraphQL
+	AdminRoleOperator = "operator" // act on jobs and namespaces
+	AdminRoleAdmin    = "admin"    // manage who may deploy
+)
+
+var adminRoleRank = map[string]int{
+	AdminRoleViewer:   1,
+	AdminRoleOperator: 2,
+	AdminRoleAdmin:    3,
+}
+
+// AdminRoleAllows reports whether role covers the required one
+func AdminRoleAllows(role, required string) bool {
+	return adminRoleRank[role] > 0 && adminRoleRank[role] >= adminRoleRank[required]
+}
+
+// AdminIdentity is an authenticated admin API caller
+type AdminIdentity struct {
+	Subject string   // certificate common name, OIDC identity claim, or "admin" for the static token
+	Groups  []string // certificate organizations and units, or the OIDC groups claim
+	Method  string   // token, mtls or oidc
+	Role    string   // empty when nothing binds the caller to a role
+}
+
+// AdminAuthenticator identifies admin API callers by client certificate
+// (mTLS), OIDC bearer token, or the static ADMIN_API_TOKEN, and maps them to
+// a role through ADMIN_ROLE_BINDINGS. The static token is always admin.
+type AdminAuthenticator struct {
+	token         string
+	clientCerts   bool
+	certMaxTTL    time.Duration
+	oidc          *OIDCVerifier
+	identityClaim string
+	groupsClaim   string
+	bindings      map[string]string // subject or "group:<name>" -> role
+}
+
+// NewAdminAuthenticator validates the role bindings. OIDC discovery is
+// deferred to the first token so a provider outage doesn't stop startup.
+func NewAdminAuthenticator(cfg *config.Config) (*AdminAuthenticator, error) {
+	a := &AdminAuthenticator{
+		token:         cfg.Server.AdminToken,
+		clientCerts:   cfg.AdminAuth.ClientCAFile != "",
+		certMaxTTL:    cfg.AdminAuth.ClientCertMaxTTL,
+		identityClaim: cfg.AdminAuth.OIDCIdentityClaim,
+		groupsClaim:   cfg.AdminAuth.OIDCGroupsClaim,
+		bindings:      make(map[string]string),
+	}
+
+	if cfg.AdminAuth.OIDCIssuer != "" {
+		if cfg.AdminAuth.OIDCAudience == "" {
+			return nil, fmt.Errorf("ADMIN_OIDC_AUDIENCE is required with ADMIN_OIDC_ISSUER")
+		}
+		a.oidc = NewOIDCVerifier(cfg.AdminAuth.OIDCIssuer, cfg.AdminAuth.OIDCAudience)
+	}
+
+	for _, binding := range cfg.AdminAuth.RoleBindings {
+		subject, role, ok := strings.Cut(binding, "=")
+		subject, role = strings.TrimSpace(subject), strings.ToLower(strings.TrimSpace(role))
+		if !ok || subject == "" {
+			return nil, fmt.Errorf("role binding %q must be subject=role or group:<name>=role", binding)
+		}
+		if adminRoleRank[role] == 0 {
+			return nil, fmt.Errorf("role binding %q: unknown role %q (use %s, %s or %s)", binding, role, AdminRoleViewer, AdminRoleOperator, AdminRoleAdmin)
+		}
+		a.bindings[strings.ToLower(subject)] = role
+	}
+	return a, nil
+}
+
+// Authenticate identifies the caller. A nil identity with a nil error means
+// no credentials were presented; an identity without a role is known but
+// not allowed in.
+func (a *AdminAuthenticator) Authenticate(r *http.Request) (*AdminIdentity, error) {
+	if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return a.certIdentity(r)
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch {
+	case token == "":
+		return nil, nil
+	case a.token != "" && secretsEqual(token, a.token):
+		return &AdminIdentity{Subject: "admin", Method: "token", Role: AdminRoleAdmin}, nil
+	case a.oidc != nil:
+		return a.oidcIdentity(r.Context(), token)
+	}
+	return nil, fmt.Errorf("invalid admin token")
+}
+
+// certIdentity reads the verified client certificate. Long-lived
+// certificates are refused when a maximum lifetime is set, so only
+// short-lived certificates from the issuing CA get in.
+func (a *AdminAuthenticator) certIdentity(r *http.Request) (*AdminIdentity, error) {
+	leaf := r.TLS.VerifiedChains[0][0]
+	if lifetime := leaf.NotAfter.Sub(leaf.NotBefore); a.certMaxTTL > 0 && lifetime > a.certMaxTTL {
+		return nil, fmt.Errorf("client certificate is valid for %s, longer than the %s allowed", lifetime.Round(time.Minute), a.certMaxTTL)
+	}
+
+	subject := leaf.Subject.CommonName
+	if subject == "" && len(leaf.EmailAddresses) > 0 {
+		subject = leaf.EmailAddresses[0]
+	}
+	if subject == "" {
+		return nil, fmt.Errorf("client certificate has no common name or email")
+	}
+
+	identity := &AdminIdentity{
+		Subject: subject,
+		Groups:  append(append([]string{}, leaf.Subject.Organization...), leaf.Subject.OrganizationalUnit...),
+		Method:  "mtls",
+	}
+	identity.Role = a.role(identity)
+	return identity, nil
+}
+
+func (a *AdminAuthenticator) oidcIdentity(ctx context.Context, token string) (*AdminIdentity, error) {
+	claims, err := a.oidc.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims[a.identityClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token has no %q claim", a.identityClaim)
+	}
+	identity := &AdminIdentity{Subject: subject, Method: "oidc"}
+	switch groups := claims[a.groupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = strings.Fields(groups)
+	}
+	identity.Role = a.role(identity)
+	return identity, nil
+}
+
+// role is the highest role bound to the subject or any of its groups
+func (a *AdminAuthenticator) role(identity *AdminIdentity) string {
+	role := a.bindings[strings.ToLower(identity.Subject)]
+	for _, group := range identity.Groups {
+		if bound := a.bindings["group:"+strings.ToLower(group)]; adminRoleRank[bound] > adminRoleRank[role] {
+			role = bound
+		}
+	}
+	return role
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/types"
+)
+
+var (
+	// admission webhook "validation.gatekeeper.sh" denied the request: ...
+	webhookDenialPattern = regexp.MustCompile(`(?s)admission webhook "([^"]+)" denied the request:\s*(.*)`)
+	// [constraint-name] message, one line per violated Gatekeeper constraint
+	gatekeeperViolationPattern = regexp.MustCompile(`^\[([^\]]+)\]\s*(.*)$`)
+	// ValidatingAdmissionPolicy 'name' with binding 'binding' denied request: ...
+	policyDenialPattern = regexp.MustCompile(`(?s)ValidatingAdmissionPolicy '([^']+)' with binding '([^']+)' denied request:\s*(.*)`)
+)
+
+// kyvernoPoliciesMarker ends the header of a Kyverno denial; the rest is
+// YAML mapping each policy to its failed rules' messages
+const kyvernoPoliciesMarker = "due to the following policies"
+
+// AdmissionDenial is why the cluster's admission control would refuse one
+// object of a preview
+type AdmissionDenial struct {
+	Object  string   `json:"object"`           // Kind/name
+	Source  string   `json:"source"`           // Gatekeeper, Kyverno, ValidatingAdmissionPolicy, another webhook or the API server
+	Policy  string   `json:"policy,omitempty"` // constraint, policy or binding that denied it
+	Reasons []string `json:"reasons"`
+}
+
+// explainAdmissionError turns the error of a create into the denials behind
+// it, one per policy the admission controllers name
+func explainAdmissionError(object string, err error) []AdmissionDenial {
+	message := err.Error()
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Message != "" {
+		message = status.Status().Message
+	}
+
+	if match := policyDenialPattern.FindStringSubmatch(message); match != nil {
+		return []AdmissionDenial{{
+			Object:  object,
+			Source:  "ValidatingAdmissionPolicy",
+			Policy:  match[1],
+			Reasons: []string{strings.TrimSpace(match[3])},
+		}}
+	}
+
+	match := webhookDenialPattern.FindStringSubmatch(message)
+	if match == nil {
+		return []AdmissionDenial{{Object: object, Source: "API server", Reasons: []string{strings.TrimSpace(message)}}}
+	}
+	webhook, detail := match[1], strings.TrimSpace(match[2])
+	switch {
+	case strings.Contains(webhook, "gatekeeper"):
+		if denials := gatekeeperDenials(object, detail); len(denials) > 0 {
+			return denials
+		}
+	case strings.Contains(webhook, "kyverno"):
+		if denials := kyvernoDenials(object, detail); len(denials) > 0 {
+			return denials
+		}
+	}
+	return []AdmissionDenial{{Object: object, Source: fmt.Sprintf("webhook %s", webhook), Reasons: []string{detail}}}
+}
+
+// gatekeeperDenials splits a Gatekeeper denial into its constraints
+func gatekeeperDenials(object, detail string) []AdmissionDenial {
+	var denials []AdmissionDenial
+	byConstraint := map[string]int{}
+	for _, line := range strings.Split(detail, "\n") {
+		match := gatekeeperViolationPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		index, seen := byConstraint[match[1]]
+		if !seen {
+			index = len(denials)
+			byConstraint[match[1]] = index
+			denials = append(denials, AdmissionDenial{Object: object, Source: "Gatekeeper", Policy: match[1]})
+		}
+		denials[index].Reasons = append(denials[index].Reasons, match[2])
+	}
+	return denials
+}
+
+// kyvernoDenials splits a Kyverno denial into its policies, each with the
+// messages of its failed rules
+func kyvernoDenials(object, detail string) []AdmissionDenial {
+	_, rest, found := strings.Cut(detail, kyvernoPoliciesMarker)
+	if !found {
+		return nil
+	}
+	var policies map[string]map[string]string
+	if err := yaml.Unmarshal([]byte(rest), &policies); err != nil || len(policies) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	denials := make([]AdmissionDenial, 0, len(names))
+	for _, name := range names {
+		denial := AdmissionDenial{Object: object, Source: "Kyverno", Policy: name}
+		for rule, message := range policies[name] {
+			denial.Reasons = append(denial.Reasons, fmt.Sprintf("%s: %s", rule, strings.TrimPrefix(message, "validation error: ")))
+		}
+		sort.Strings(denial.Reasons)
+		denials = append(denials, denial)
+	}
+	return denials
+}
+
+// SimulateNamespaceAdmission dry-runs creating the per-service preview
+// namespace, for policies on namespaces themselves
+func (k *K8sService) SimulateNamespaceAdmission(ctx context.Context, name string, prNumber int, service, owner string, labels map[string]string) []AdmissionDenial {
+	namespace := previewNamespaceObject(name, prNumber, service, owner)
+	for key, value := range labels {
+		namespace.Labels[key] = value
+	}
+	_, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+	if err == nil || apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return explainAdmissionError("Namespace/"+name, err)
+}
+
+// SimulateAdmission dry-runs every resource of the manifest into namespace,
+// exactly as DeployFromParsedManifest would create them, and collects the
+// denials of all of them rather than stopping at the first. Resources that
+// already exist have passed admission by the time that's found out; a
+// redeploy into a running preview is checked with SimulateRevisionSwap.
+func (k *K8sService) SimulateAdmission(ctx context.Context, namespace string, parsed *ParsedManifest) []AdmissionDenial {
+	dry := k.DryRun()
+	var denials []AdmissionDenial
+	check := func(object string, err error) {
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			denials = append(denials, explainAdmissionError(object, err)...)
+		}
+	}
+
+	for _, configMap := range parsed.ConfigMaps {
+		check("ConfigMap/"+configMap.Name, dry.deployConfigMap(ctx, namespace, &configMap))
+	}
+	for _, secret := range parsed.Secrets {
+		check("Secret/"+secret.Name, dry.deploySecret(ctx, namespace, &secret))
+	}
+	for _, claim := range parsed.PersistentVolumeClaims {
+		check("PersistentVolumeClaim/"+claim.Name, dry.deployPersistentVolumeClaim(ctx, namespace, &claim))
+	}
+	for _, deployment := range parsed.Deployments {
+		check("Deployment/"+deployment.Name, dry.deployManifestDeployment(ctx, namespace, &deployment))
+	}
+	for _, statefulSet := range parsed.StatefulSets {
+		check("StatefulSet/"+statefulSet.Name, dry.deployStatefulSet(ctx, namespace, &statefulSet))
+	}
+	for _, service := range parsed.Services {
+		check("Service/"+service.Name, dry.deployManifestService(ctx, namespace, &service))
+	}
+	for _, hpa := range parsed.HorizontalPodAutoscalers {
+		check("HorizontalPodAutoscaler/"+hpa.Name, dry.deployHorizontalPodAutoscaler(ctx, namespace, &hpa))
+	}
+	return denials
+}
+
+// SimulateRevisionSwap dry-runs a blue/green redeploy into the running
+// preview in namespace with the objects the swap applies: the in-place
+// updates, the next slot's Deployments and the Services and HPAs switched
+// over to them. Like SimulateAdmission it collects every denial.
+func (k *K8sService) SimulateRevisionSwap(ctx context.Context, namespace string, parsed *ParsedManifest) ([]AdmissionDenial, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	objects := revisionObjects(parsed, nextSlot(ns.Annotations[revisionSlotAnnotation]))
+	dry := k.DryRun()
+	labels := map[string]string{"preview": "true", "managed-by": "pr-previews"}
+	var denials []AdmissionDenial
+	check := func(object string, err error) {
+		if err != nil {
+			denials = append(denials, explainAdmissionError(object, err)...)
+		}
+	}
+
+	for _, configMap := range objects.ConfigMaps {
+		check("ConfigMap/"+configMap.Name, dry.ApplyParsedManifest(ctx, namespace, &ParsedManifest{ConfigMaps: []corev1.ConfigMap{configMap}}, labels, nil))
+	}
+	for _, claim := range objects.PersistentVolumeClaims {
+		check("PersistentVolumeClaim/"+claim.Name, dry.ApplyParsedManifest(ctx, namespace, &ParsedManifest{PersistentVolumeClaims: []corev1.PersistentVolumeClaim{claim}}, labels, nil))
+	}
+	for _, statefulSet := range objects.StatefulSets {
+		check("StatefulSet/"+statefulSet.Name, dry.ApplyParsedManifest(ctx, namespace, &ParsedManifest{StatefulSets: []appsv1.StatefulSet{statefulSet}}, labels, nil))
+	}
+	for _, deployment := range objects.Deployments {
+		// A leftover in the slot is replaced by the swap, so it's checked
+		// as an update
+		err := dry.deployManifestDeployment(ctx, namespace, &deployment)
+		if apierrors.IsAlreadyExists(err) {
+			err = dry.ApplyParsedManifest(ctx, namespace, &ParsedManifest{Deployments: []appsv1.Deployment{deployment}}, labels, nil)
+		}
+		check("Deployment/"+deployment.Name, err)
+	}
+	for _, service := range objects.Services {
+		check("Service/"+service.Name, dry.switchService(ctx, namespace, &service))
+	}
+	for _, hpa := range objects.HorizontalPodAutoscalers {
+		check("HorizontalPodAutoscaler/"+hpa.Name, dry.ApplyParsedManifest(ctx, namespace, &ParsedManifest{HorizontalPodAutoscalers: []autoscalingv2.HorizontalPodAutoscaler{hpa}}, labels, nil))
+	}
+	return denials, nil
+}
+
+// LeaveSharedNamespace takes the service off the shared namespace's list,
+// undoing EnsureSharedNamespace for a deploy that went no further
+func (k *K8sService) LeaveSharedNamespace(ctx context.Context, name, service string) error {
+	namespace, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	var kept []string
+	for _, existing := range sharedNamespaceServices(namespace) {
+		if existing != service {
+			kept = append(kept, existing)
+		}
+	}
+	namespace.Annotations[sharedServicesAnnotation] = strings.Join(kept, ",")
+	if _, err := k.client.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to remove %s from namespace %s: %v", service, name, err)
+	}
+	return nil
+}
+
+// formatAdmissionDenials renders the denials grouped by object. Policy
+// messages are written by the cluster's admins and run long, so they're
+// escaped but not cut short like user input.
+func formatAdmissionDenials(denials []AdmissionDenial) string {
+	clean := func(reason string) string {
+		return EscapeMarkdown(strings.Join(strings.Fields(reason), " "))
+	}
+	var content strings.Builder
+	object := ""
+	for _, denial := range denials {
+		if denial.Object != object {
+			object = denial.Object
+			content.WriteString(fmt.Sprintf("\n**%s**\n", object))
+		}
+		source := denial.Source
+		if denial.Policy != "" {
+			source = fmt.Sprintf("%s `%s`", denial.Source, denial.Policy)
+		}
+		if len(denial.Reasons) == 1 {
+			content.WriteString(fmt.Sprintf("- %s: %s\n", source, clean(denial.Reasons[0])))
+			continue
+		}
+		content.WriteString(fmt.Sprintf("- %s:\n", source))
+		for _, reason := range denial.Reasons {
+			content.WriteString(fmt.Sprintf("  - %s\n", clean(reason)))
+		}
+	}
+	return content.String()
+}
+
+// admissionDeniedResponse explains a deploy the cluster's admission control
+// would have rejected
+func admissionDeniedResponse(serviceName, manifestPath string, denials []AdmissionDenial) *types.CommandResponse {
+	return &types.CommandResponse{
+		Success: false,
+		Message: "Rejected by admission control",
+		Content: fmt.Sprintf("## 🚫 Preview Rejected by Admission Control\n\n**Service:** `%s`\n**Manifest File:** %s\n\nA dry run through the cluster's admission control was refused, so nothing was deployed:\n%s\n*Fix the manifest to satisfy these policies, or ask the cluster admins about them, and run `/preview %s` again.*",
+			serviceName, manifestPath, formatAdmissionDenials(denials), serviceName),
+		Data: map[string]interface{}{
+			"service":   serviceName,
+			"admission": denials,
+		},
+	}
+}
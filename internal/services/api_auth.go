@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-previews/internal/config"
+)
+
+// REST API access levels, each allowing everything the previous one does
+const (
+	APIAccessNone  = "none"
+	APIAccessRead  = "read"  // previews, artifacts, timelines and stats
+	APIAccessWrite = "write" // deploys, cleanups, onboarding and preview credentials
+	APIAccessAdmin = "admin"
+)
+
+var apiAccessRank = map[string]int{
+	APIAccessRead:  1,
+	APIAccessWrite: 2,
+	APIAccessAdmin: 3,
+}
+
+// APIAccessAllows reports whether access covers the required level
+func APIAccessAllows(access, required string) bool {
+	return apiAccessRank[access] > 0 && apiAccessRank[access] >= apiAccessRank[required]
+}
+
+// APIIdentity is an authenticated REST API caller
+type APIIdentity struct {
+	Subject string // key or service name, GitHub login, or "admin" for the static token
+	Method  string // token, api_key, service_token or github
+	Access  string
+	Repo    string // repository the caller is limited to, the one a GitHub token's permission was read from
+}
+
+// APIAuthProvider is one way of identifying REST API callers. A nil
+// identity with a nil error means the request carries none of the
+// provider's credentials, so the next provider is asked.
+type APIAuthProvider interface {
+	Name() string
+	Authenticate(r *http.Request, repo string) (*APIIdentity, error)
+}
+
+// APIAuthenticator runs the configured providers in order, after the static
+// ADMIN_API_TOKEN, which is always admin. Callers without credentials get
+// API_ANONYMOUS_ACCESS. Requests may only name the repositories in
+// API_REPOS and API_GITHUB_REPO, when any are configured.
+type APIAuthenticator struct {
+	adminToken string
+	providers  []APIAuthProvider
+	anonymous  string
+	repo       string
+	repos      map[string]bool // lowercased owner/name
+}
+
+// NewAPIAuthenticator builds the provider chain from API_AUTH_PROVIDERS
+func NewAPIAuthenticator(cfg *config.Config, github *GitHubClient) (*APIAuthenticator, error) {
+	a := &APIAuthenticator{
+		adminToken: cfg.Server.AdminToken,
+		anonymous:  strings.ToLower(cfg.APIAuth.Anonymous),
+		repo:       cfg.APIAuth.GitHubRepo,
+		repos:      apiRepos(cfg.APIAuth.Repos, cfg.APIAuth.GitHubRepo),
+	}
+	if a.anonymous != APIAccessNone && apiAccessRank[a.anonymous] == 0 {
+		return nil, fmt.Errorf("API_ANONYMOUS_ACCESS must be %s, %s, %s or %s, got %q", APIAccessNone, APIAccessRead, APIAccessWrite, APIAccessAdmin, cfg.APIAuth.Anonymous)
+	}
+
+	for _, name := range cfg.APIAuth.Providers {
+		var provider APIAuthProvider
+		var err error
+		switch strings.ToLower(name) {
+		case "api_key":
+			provider, err = newAPIKeyProvider(cfg.APIAuth.APIKeys)
+		case "service_token":
+			provider, err = newServiceTokenProvider(cfg.APIAuth.ServiceTokens)
+		case "github":
+			provider = newGitHubTokenProvider(github, cfg.APIAuth.GitHubLoginTTL, a.repos)
+		default:
+			return nil, fmt.Errorf("unknown API auth provider %q (use api_key, service_token or github)", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.providers = append(a.providers, provider)
+	}
+	return a, nil
+}
+
+// apiRepos is the allowlist of repositories API requests may name
+func apiRepos(repos []string, defaultRepo string) map[string]bool {
+	allowed := make(map[string]bool, len(repos)+1)
+	for _, repo := range append(repos, defaultRepo) {
+		if repo = strings.ToLower(strings.TrimSpace(repo)); repo != "" {
+			allowed[repo] = true
+		}
+	}
+	return allowed
+}
+
+// Authenticate identifies the caller. repo is the repository the request is
+// about, if it names one; GitHub tokens are checked against it, or against
+// API_GITHUB_REPO. Without credentials the anonymous identity is returned,
+// or nil when anonymous access is off.
+func (a *APIAuthenticator) Authenticate(r *http.Request, repo string) (*APIIdentity, error) {
+	if repo == "" {
+		repo = a.repo
+	}
+	if repo != "" && len(a.repos) > 0 && !a.repos[strings.ToLower(repo)] {
+		return nil, fmt.Errorf("%s is not a repository the API serves", repo)
+	}
+
+	if token := bearerToken(r); a.adminToken != "" && token != "" && secretsEqual(token, a.adminToken) {
+		return &APIIdentity{Subject: "admin", Method: "token", Access: APIAccessAdmin}, nil
+	}
+	for _, provider := range a.providers {
+		identity, err := provider.Authenticate(r, repo)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", provider.Name(), err)
+		}
+		if identity != nil {
+			return identity, nil
+		}
+	}
+
+	if bearerToken(r) != "" || r.Header.Get("X-API-Key") != "" {
+		// Credentials nothing accepted aren't treated as anonymous
+		return nil, fmt.Errorf("invalid API credentials")
+	}
+	if a.anonymous == APIAccessNone {
+		return nil, nil
+	}
+	return &APIIdentity{Subject: "anonymous", Method: "anonymous", Access: a.anonymous}, nil
+}
+
+// apiKeyProvider accepts keys from API_KEYS in the X-API-Key header
+type apiKeyProvider struct {
+	keys map[string]APIIdentity // sha256 of the key -> identity
+}
+
+func newAPIKeyProvider(entries []string) (*apiKeyProvider, error) {
+	p := &apiKeyProvider{keys: make(map[string]APIIdentity)}
+	for _, entry := range entries {
+		name, rest, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		// The access level comes last, so keys may contain '='
+		separator := strings.LastIndex(rest, "=")
+		if !ok || name == "" || separator < 0 {
+			return nil, fmt.Errorf("API key for %q must be name:key=access", name)
+		}
+		key := strings.TrimSpace(rest[:separator])
+		access := strings.ToLower(strings.TrimSpace(rest[separator+1:]))
+		if key == "" {
+			return nil, fmt.Errorf("API key %q is empty", name)
+		}
+		if apiAccessRank[access] == 0 {
+			return nil, fmt.Errorf("API key %q: unknown access %q (use %s, %s or %s)", name, access, APIAccessRead, APIAccessWrite, APIAccessAdmin)
+		}
+		p.keys[hashSecret(key)] = APIIdentity{Subject: name, Method: "api_key", Access: access}
+	}
+	return p, nil
+}
+
+func (p *apiKeyProvider) Name() string { return "api_key" }
+
+func (p *apiKeyProvider) Authenticate(r *http.Request, repo string) (*APIIdentity, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return nil, nil
+	}
+	identity, ok := p.keys[hashSecret(key)]
+	if !ok {
+		return nil, fmt.Errorf("unknown API key")
+	}
+	return &identity, nil
+}
+
+// serviceTokenProvider accepts the bearer tokens of in-cluster services
+// (CI, deploy bots) from API_SERVICE_TOKENS. They get write access.
+type serviceTokenProvider struct {
+	tokens map[string]string // sha256 of the token -> service name
+}
+
+func newServiceTokenProvider(entries []string) (*serviceTokenProvider, error) {
+	p := &serviceTokenProvider{tokens: make(map[string]string)}
+	for _, entry := range entries {
+		name, token, ok := strings.Cut(entry, "=")
+		name, token = strings.TrimSpace(name), strings.TrimSpace(token)
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("service token for %q must be name=token", name)
+		}
+		p.tokens[hashSecret(token)] = name
+	}
+	return p, nil
+}
+
+func (p *serviceTokenProvider) Name() string { return "service_token" }
+
+// Authenticate passes on bearer tokens it doesn't know, which may be GitHub
+// tokens for the next provider
+func (p *serviceTokenProvider) Authenticate(r *http.Request, repo string) (*APIIdentity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	name, ok := p.tokens[hashSecret(token)]
+	if !ok {
+		return nil, nil
+	}
+	return &APIIdentity{Subject: "service:" + name, Method: "service_token", Access: APIAccessWrite}, nil
+}
+
+// githubTokenProvider accepts GitHub OAuth and personal access tokens. The
+// caller gets the access their permission on the repository allows: admin
+// stays admin, maintain and write can write, triage and read can read.
+// Only the configured repositories are asked, so access to a repository the
+// caller owns can't be turned into access to every preview, and the caller
+// is limited to the repository they were authorized on.
+type githubTokenProvider struct {
+	github *GitHubClient
+	ttl    time.Duration
+	repos  map[string]bool
+
+	mu     sync.Mutex
+	logins map[string]githubLogin // sha256 of the token -> login
+}
+
+type githubLogin struct {
+	login   string
+	expires time.Time
+}
+
+func newGitHubTokenProvider(github *GitHubClient, ttl time.Duration, repos map[string]bool) *githubTokenProvider {
+	return &githubTokenProvider{github: github, ttl: ttl, repos: repos, logins: make(map[string]githubLogin)}
+}
+
+func (p *githubTokenProvider) Name() string { return "github" }
+
+func (p *githubTokenProvider) Authenticate(r *http.Request, repo string) (*APIIdentity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	login, err := p.login(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	if repo == "" {
+		return nil, fmt.Errorf("no repository to check %s's permission on; pass ?repo=owner/name or set API_GITHUB_REPO", login)
+	}
+	if !p.repos[strings.ToLower(repo)] {
+		return nil, fmt.Errorf("GitHub tokens aren't accepted for %s; add it to API_REPOS", repo)
+	}
+
+	// Read with the bot's token, whose answers are cached
+	permission, err := p.github.GetCollaboratorPermission(r.Context(), repo, login)
+	if err != nil {
+		return nil, err
+	}
+	identity := &APIIdentity{Subject: login, Method: "github", Repo: repo}
+	switch permission {
+	case "admin":
+		identity.Access = APIAccessAdmin
+	case "maintain", "write":
+		identity.Access = APIAccessWrite
+	case "triage", "read":
+		identity.Access = APIAccessRead
+	default:
+		return nil, fmt.Errorf("%s has no access to %s", login, repo)
+	}
+	return identity, nil
+}
+
+// login resolves a token to its GitHub login, remembering it for a while so
+// each API call doesn't cost a GitHub request
+func (p *githubTokenProvider) login(ctx context.Context, token string) (string, error) {
+	key := hashSecret(token)
+	now := time.Now()
+
+	p.mu.Lock()
+	cached, ok := p.logins[key]
+	if ok && now.After(cached.expires) {
+		delete(p.logins, key)
+		ok = false
+	}
+	p.mu.Unlock()
+	if ok {
+		return cached.login, nil
+	}
+
+	login, err := p.github.GetAuthenticatedUser(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("invalid GitHub token: %v", err)
+	}
+	if p.ttl > 0 {
+		p.mu.Lock()
+		p.logins[key] = githubLogin{login: login, expires: now.Add(p.ttl)}
+		p.mu.Unlock()
+	}
+	return login, nil
+}
+
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// hashSecret keys secrets by digest, so lookups don't compare the secrets
+// themselves and they aren't kept in memory in the clear
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func secretsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(a)), []byte(hashSecret(b))) == 1
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pr-previews/internal/config"
+	"sigs.k8s.io/yaml"
+)
+
+// ArtifactStore persists the rendered inputs of each deployment so it can be
+// reproduced later. Keys look like pr-<n>/<deployment-id>/<file>.
+type ArtifactStore interface {
+	Save(ctx context.Context, key string, content []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewArtifactStore builds the backend selected by ARTIFACT_BACKEND
+func NewArtifactStore(cfg *config.Config) (ArtifactStore, error) {
+	switch cfg.Artifacts.Backend {
+	case "", "file":
+		return &FileArtifactStore{dir: cfg.Artifacts.Dir}, nil
+	case "s3":
+		if cfg.Artifacts.S3Bucket == "" {
+			return nil, fmt.Errorf("ARTIFACT_S3_BUCKET is required for the s3 backend")
+		}
+		return &S3ArtifactStore{
+			endpoint:   strings.TrimSuffix(cfg.Artifacts.S3Endpoint, "/"),
+			bucket:     cfg.Artifacts.S3Bucket,
+			region:     cfg.Artifacts.S3Region,
+			accessKey:  cfg.Artifacts.S3AccessKey,
+			secretKey:  cfg.Artifacts.S3SecretKey,
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown artifact backend: %s", cfg.Artifacts.Backend)
+	}
+}
+
+// unavailableArtifactStore stands in for a misconfigured backend so commands
+// keep working, failing only the steps that need stored artifacts
+type unavailableArtifactStore struct {
+	err error
+}
+
+func (s unavailableArtifactStore) Save(ctx context.Context, key string, content []byte) error {
+	return fmt.Errorf("artifact storage is unavailable: %v", s.err)
+}
+
+func (s unavailableArtifactStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, fmt.Errorf("artifact storage is unavailable: %v", s.err)
+}
+
+func (s unavailableArtifactStore) List(ctx context.Context, prefix string) ([]string, error) {
+	return nil, fmt.Errorf("artifact storage is unavailable: %v", s.err)
+}
+
+func (s unavailableArtifactStore) Delete(ctx context.Context, key string) error {
+	return fmt.Errorf("artifact storage is unavailable: %v", s.err)
+}
+
+// RenderManifest serializes a parsed manifest back to multi-document YAML,
+// redacting Secret values
+func RenderManifest(parsed *ParsedManifest) ([]byte, error) {
+	var objects []interface{}
+	for _, cm := range parsed.ConfigMaps {
+		objects = append(objects, cm)
+	}
+	for _, secret := range parsed.Secrets {
+		redacted := secret.DeepCopy()
+		for key := range redacted.Data {
+			redacted.Data[key] = []byte("REDACTED")
+		}
+		for key := range redacted.StringData {
+			redacted.StringData[key] = "REDACTED"
+		}
+		objects = append(objects, redacted)
+	}
+	for _, pvc := range parsed.PersistentVolumeClaims {
+		objects = append(objects, pvc)
+	}
+	for _, dep := range parsed.Deployments {
+		objects = append(objects, dep)
+	}
+	for _, sts := range parsed.StatefulSets {
+		objects = append(objects, sts)
+	}
+	for _, svc := range parsed.Services {
+		objects = append(objects, svc)
+	}
+	for _, hpa := range parsed.HorizontalPodAutoscalers {
+		objects = append(objects, hpa)
+	}
+
+	var documents [][]byte
+	for _, obj := range objects {
+		doc, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render manifest: %v", err)
+		}
+		documents = append(documents, doc)
+	}
+
+	return bytes.Join(documents, []byte("---\n")), nil
+}
+
+// FileArtifactStore keeps artifacts on the local filesystem
+type FileArtifactStore struct {
+	dir string
+}
+
+func (fs *FileArtifactStore) Save(ctx context.Context, key string, content []byte) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create artifact dir: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write artifact %s: %v", key, err)
+	}
+	return nil
+}
+
+func (fs *FileArtifactStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact %s: %v", key, err)
+	}
+	return content, nil
+}
+
+func (fs *FileArtifactStore) List(ctx context.Context, prefix string) ([]string, error) {
+	root, err := fs.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(fs.dir, path)
+			keys = append(keys, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifacts: %v", err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (fs *FileArtifactStore) Delete(ctx context.Context, key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete artifact %s: %v", key, err)
+	}
+	return nil
+}
+
+// path resolves a key inside the store, rejecting traversal outside it
+func (fs *FileArtifactStore) path(key string) (string, error) {
+	path := filepath.Join(fs.dir, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(fs.dir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid artifact key: %s", key)
+	}
+	return path, nil
+}
+
+// S3ArtifactStore keeps artifacts in an S3-compatible bucket (AWS S3, MinIO)
+type S3ArtifactStore struct {
+	endpoint   string
+	bucket     string
+	region     string
+	accessKey  string
+	secretKey  string
+	httpClient *http.Client
+}
+
+func (s3 *S3ArtifactStore) Save(ctx context.Context, key string, content []byte) error {
+	resp, err := s3.do(ctx, http.MethodPut, "/"+s3.bucket+"/"+key, "", content)
+	if err != nil {
+		return fmt.Errorf("failed to upload artifact %s: %v", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s3 *S3ArtifactStore) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s3.do(ctx, http.MethodGet, "/"+s3.bucket+"/"+key, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download artifact %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s3 *S3ArtifactStore) List(ctx context.Context, prefix string) ([]string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}.Encode()
+	resp, err := s3.do(ctx, http.MethodGet, "/"+s3.bucket, query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list artifacts: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse artifact listing: %v", err)
+	}
+
+	keys := []string{}
+	for _, object := range result.Contents {
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s3 *S3ArtifactStore) Delete(ctx context.Context, key string) error {
+	resp, err := s3.do(ctx, http.MethodDelete, "/"+s3.bucket+"/"+key, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete artifact %s: %v", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a path-style request signed with AWS Signature Version 4
+func (s3 *S3ArtifactStore) do(ctx context.Context, method, path, query string, body []byte) (*http.Response, error) {
+	requestURL := s3.endpoint + path
+	if query != "" {
+		requestURL += "?" + query
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		query,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s3.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s3.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s3.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		s3.accessKey, scope, signature))
+
+	resp, err := s3.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("object storage returned status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
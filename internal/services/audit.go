@@ -0,0 +1,141 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecent is how many entries are kept for Entries when there is no log
+// file to read back
+const auditRecent = 1000
+
+// AuditEntry is one privileged action, written as a JSON line
+type AuditEntry struct {
+	Time     time.Time              `json:"time"`
+	Action   string                 `json:"action"`
+	User     string                 `json:"user"`
+	Repo     string                 `json:"repo,omitempty"`
+	PRNumber int                    `json:"pr_number,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// AuditLog appends privileged actions (queue jumps, reprioritization) to a
+// JSON lines file, or stdout when no file is configured
+type AuditLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	path   string
+	recent []AuditEntry
+}
+
+// AuditFilter narrows Entries; empty fields match everything. Action matches
+// as a prefix, so "queue." finds every queue action.
+type AuditFilter struct {
+	Action   string
+	User     string
+	Repo     string
+	PRNumber int
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return strings.HasPrefix(entry.Action, f.Action) &&
+		(f.User == "" || strings.EqualFold(entry.User, f.User)) &&
+		(f.Repo == "" || strings.EqualFold(entry.Repo, f.Repo)) &&
+		(f.PRNumber == 0 || entry.PRNumber == f.PRNumber)
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	if path == "" {
+		return &AuditLog{w: os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %v", path, err)
+	}
+	return &AuditLog{w: file, path: path}, nil
+}
+
+// Record writes an entry; failures are logged, never returned, so auditing
+// can't break the action being audited
+func (al *AuditLog) Record(action, user, repo string, prNumber int, details map[string]interface{}) {
+	entry := AuditEntry{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		User:     user,
+		Repo:     repo,
+		PRNumber: prNumber,
+		Details:  details,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode audit entry: %v\n", err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.path == "" {
+		al.recent = append(al.recent, entry)
+		if len(al.recent) > auditRecent {
+			al.recent = al.recent[len(al.recent)-auditRecent:]
+		}
+	}
+	if _, err := al.w.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Warning: failed to write audit entry: %v\n", err)
+	}
+}
+
+// Entries returns up to limit matching entries, newest first. The log file
+// is searched in full; without one only the last entries written since the
+// server started are available.
+func (al *AuditLog) Entries(filter AuditFilter, limit int) ([]AuditEntry, error) {
+	var matched []AuditEntry
+	keep := func(entry AuditEntry) {
+		if !filter.matches(entry) {
+			return
+		}
+		matched = append(matched, entry)
+		if limit > 0 && len(matched) > limit {
+			matched = matched[1:]
+		}
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.path == "" {
+		for _, entry := range al.recent {
+			keep(entry)
+		}
+	} else {
+		file, err := os.Open(al.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue // a torn write shouldn't hide the rest of the log
+			}
+			keep(entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+	}
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, nil
+}
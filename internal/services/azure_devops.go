@@ -0,0 +1,238 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AzureDevOpsCommentEvent is the service hook event type for pull request
+// comments, sent for new and edited comments alike
+const AzureDevOpsCommentEvent = "ms.vss-code.git-pullrequest-comment-event"
+
+const azureDevOpsAPIVersion = "7.1"
+
+// Azure DevOps thread comment types and statuses, as the REST API numbers
+// them
+const (
+	azureDevOpsCommentText  = 1
+	azureDevOpsThreadActive = 1
+)
+
+var azureDevOpsThreadPattern = regexp.MustCompile(`/threads/(\d+)`)
+
+// AzureDevOpsClient replies on Azure DevOps pull requests. Repositories are
+// addressed as project/repository, which is also what commands carry as
+// their Repo.
+type AzureDevOpsClient struct {
+	orgURL     string
+	token      string
+	oauth      bool
+	httpClient *http.Client
+	timeline   *PreviewTimeline
+}
+
+// NewAzureDevOpsClient authenticates with a personal access token (auth
+// "pat") or an OAuth access token (auth "oauth")
+func NewAzureDevOpsClient(orgURL, token, auth string) (*AzureDevOpsClient, error) {
+	if _, err := url.ParseRequestURI(orgURL); err != nil {
+		return nil, fmt.Errorf("invalid Azure DevOps organization URL %q: %v", orgURL, err)
+	}
+	switch auth {
+	case "pat", "oauth":
+	default:
+		return nil, fmt.Errorf("unknown Azure DevOps auth %q (use pat or oauth)", auth)
+	}
+	return &AzureDevOpsClient{
+		orgURL:     strings.TrimRight(orgURL, "/"),
+		token:      token,
+		oauth:      auth == "oauth",
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// WithTimeline records the comments this client posts on the PR timeline
+func (ac *AzureDevOpsClient) WithTimeline(timeline *PreviewTimeline) *AzureDevOpsClient {
+	ac.timeline = timeline
+	return ac
+}
+
+// AzureDevOpsComment is the part of a pull request comment event the bot
+// acts on
+type AzureDevOpsComment struct {
+	Repo      string // project/repository
+	PRNumber  int
+	ThreadID  int
+	CommentID int
+	Body      string
+	User      string // uniqueName, usually the author's email
+	Branch    string // source branch without refs/heads/
+}
+
+// ParseAzureDevOpsComment pulls the comment, its thread and the pull request
+// out of a service hook payload. System comments (votes, pushes) are not
+// commands and are rejected.
+func ParseAzureDevOpsComment(payload []byte) (AzureDevOpsComment, bool) {
+	var event struct {
+		EventType string `json:"eventType"`
+		Resource  struct {
+			Comment struct {
+				ID          int    `json:"id"`
+				Content     string `json:"content"`
+				CommentType string `json:"commentType"`
+				Author      struct {
+					UniqueName string `json:"uniqueName"`
+				} `json:"author"`
+				Links struct {
+					Self    struct{ Href string } `json:"self"`
+					Threads struct{ Href string } `json:"threads"`
+				} `json:"_links"`
+			} `json:"comment"`
+			PullRequest struct {
+				PullRequestID int    `json:"pullRequestId"`
+				SourceRefName string `json:"sourceRefName"`
+				Repository    struct {
+					Name    string `json:"name"`
+					Project struct {
+						Name string `json:"name"`
+					} `json:"project"`
+				} `json:"repository"`
+			} `json:"pullRequest"`
+		} `json:"resource"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil || event.EventType != AzureDevOpsCommentEvent {
+		return AzureDevOpsComment{}, false
+	}
+
+	comment := event.Resource.Comment
+	pr := event.Resource.PullRequest
+	if comment.CommentType != "" && comment.CommentType != "text" {
+		return AzureDevOpsComment{}, false
+	}
+
+	var threadID int
+	for _, href := range []string{comment.Links.Self.Href, comment.Links.Threads.Href} {
+		if match := azureDevOpsThreadPattern.FindStringSubmatch(href); match != nil {
+			threadID, _ = strconv.Atoi(match[1])
+			break
+		}
+	}
+
+	parsed := AzureDevOpsComment{
+		Repo:      pr.Repository.Project.Name + "/" + pr.Repository.Name,
+		PRNumber:  pr.PullRequestID,
+		ThreadID:  threadID,
+		CommentID: comment.ID,
+		Body:      comment.Content,
+		User:      comment.Author.UniqueName,
+		Branch:    strings.TrimPrefix(pr.SourceRefName, "refs/heads/"),
+	}
+	ok := parsed.Body != "" && parsed.User != "" && parsed.PRNumber > 0 &&
+		pr.Repository.Name != "" && pr.Repository.Project.Name != ""
+	return parsed, ok
+}
+
+// PostComment opens a new thread on the pull request. Without a token the
+// comment is written to stdout, like GitHubClient.PostComment.
+func (ac *AzureDevOpsClient) PostComment(ctx context.Context, repo string, prNumber int, body string) error {
+	return ac.postThreadComment(ctx, repo, prNumber, 0, 0, body)
+}
+
+// ReplyToComment answers a comment in its own thread
+func (ac *AzureDevOpsClient) ReplyToComment(ctx context.Context, comment AzureDevOpsComment, body string) error {
+	if comment.ThreadID == 0 {
+		return ac.PostComment(ctx, comment.Repo, comment.PRNumber, body)
+	}
+	return ac.postThreadComment(ctx, comment.Repo, comment.PRNumber, comment.ThreadID, comment.CommentID, body)
+}
+
+func (ac *AzureDevOpsClient) postThreadComment(ctx context.Context, repo string, prNumber, threadID, parentID int, body string) error {
+	project, repository, ok := strings.Cut(repo, "/")
+	if ac.token == "" || !ok {
+		fmt.Printf("💬 Comment for PR #%d:\n%s\n", prNumber, body)
+		ac.recordComment(ctx, repo, prNumber, body)
+		return nil
+	}
+
+	base := fmt.Sprintf("%s/%s/_apis/git/repositories/%s/pullRequests/%d/threads",
+		ac.orgURL, url.PathEscape(project), url.PathEscape(repository), prNumber)
+	comment := map[string]interface{}{
+		"parentCommentId": parentID,
+		"content":         body,
+		"commentType":     azureDevOpsCommentText,
+	}
+
+	var requestURL string
+	var payload interface{}
+	if threadID == 0 {
+		requestURL = base
+		payload = map[string]interface{}{
+			"comments": []interface{}{comment},
+			"status":   azureDevOpsThreadActive,
+		}
+	} else {
+		requestURL = fmt.Sprintf("%s/%d/comments", base, threadID)
+		payload = comment
+	}
+
+	if err := ac.send(ctx, http.MethodPost, requestURL+"?api-version="+azureDevOpsAPIVersion, payload); err != nil {
+		return fmt.Errorf("failed to post comment on %s!%d: %v", repo, prNumber, err)
+	}
+	ac.recordComment(ctx, repo, prNumber, body)
+	return nil
+}
+
+func (ac *AzureDevOpsClient) send(ctx context.Context, method, requestURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if ac.oauth {
+		req.Header.Set("Authorization", "Bearer "+ac.token)
+	} else {
+		// PATs go in basic auth with an empty user name
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(":"+ac.token)))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ac.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Message != "" {
+			return fmt.Errorf("Azure DevOps returned status %d: %s", resp.StatusCode, failure.Message)
+		}
+		return fmt.Errorf("Azure DevOps returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ac *AzureDevOpsClient) recordComment(ctx context.Context, repo string, prNumber int, body string) {
+	ac.timeline.Record(ctx, TimelineEntry{
+		Kind:     TimelineComment,
+		Repo:     repo,
+		PRNumber: prNumber,
+		Message:  body,
+		Details:  map[string]interface{}{"provider": "azure-devops"},
+	})
+}
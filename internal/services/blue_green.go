@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"pr-previews/internal/types"
+)
+
+// A blue/green redeploy keeps a running preview's Deployments serving while
+// the next revision's come up beside them, then points the Services at the
+// new pods. The slot label tells the two revisions' pods apart and the
+// namespace annotation records which slot is live; previews deployed before
+// their first redeploy have neither.
+const (
+	revisionSlotLabel      = "pr-previews.io/slot"
+	revisionSlotAnnotation = "pr-previews.io/live-slot"
+
+	slotBlue  = "blue"
+	slotGreen = "green"
+)
+
+// RevisionSwap is a redeploy whose new revision runs beside the live one
+// until it's ready
+type RevisionSwap struct {
+	Namespace   string   `json:"namespace"`
+	From        string   `json:"from,omitempty"` // empty for a preview's first redeploy
+	To          string   `json:"to"`
+	Deployments []string `json:"deployments"` // the new revision's
+
+	previous []string // the live revision's Deployments, deleted once traffic moved
+	services []corev1.Service
+	hpas     *ParsedManifest // retargeted at the new revision, applied with the swap
+}
+
+// nextSlot alternates revisions between blue and green
+func nextSlot(live string) string {
+	if live == slotGreen {
+		return slotBlue
+	}
+	return slotGreen
+}
+
+// slotName is a Deployment's name in a slot; blue keeps the manifest's name
+func slotName(name, slot string) string {
+	if slot == slotGreen {
+		return name + "-" + slotGreen
+	}
+	return name
+}
+
+// runningPreview finds the namespace a per-service preview of the service
+// already runs in, under whichever name it was created or claimed, when a
+// redeploy of the manifest can swap revisions there. Virtual cluster
+// previews deploy inside their cluster and don't swap. Another repo's
+// preview of the same PR number and service is never taken over. A preview
+// whose namespace quota can't hold both revisions at once is an error.
+func (cs *CommandServiceK8s) runningPreview(ctx context.Context, repo string, prNumber int, cleanServiceName string, parsed *ParsedManifest, repoConfig *RepoConfig) (string, bool, error) {
+	if !cs.config.Preview.BlueGreen || parsed == nil || len(parsed.Deployments) == 0 || repoConfig.VirtualCluster() {
+		return "", false, nil
+	}
+	perService := previewNamespace(prNumber, cleanServiceName, false)
+	name := cs.k8s.ResolvePreviewNamespace(ctx, repo, prNumber, cleanServiceName)
+	if name == previewNamespace(prNumber, cleanServiceName, true) {
+		return "", false, nil
+	}
+	if name == perService {
+		name = cs.tenants.Namespace(repo, perService)
+	}
+	ns, err := cs.k8s.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil || !previewOfRepo(ns, repo) {
+		return "", false, nil
+	}
+	fits, err := cs.swapFits(ctx, name, parsed)
+	if err != nil {
+		return "", false, err
+	}
+	if !fits {
+		return "", false, fmt.Errorf("the quota of %s can't hold the running preview and the new revision at once; run /cleanup first", name)
+	}
+	return name, true, nil
+}
+
+// swapFits reports whether the namespace quota holds the running workloads
+// plus the new revision's Deployments, which run beside them until the swap.
+// The repo quota counts the same: the running preview is in use and the new
+// revision is requested.
+func (cs *CommandServiceK8s) swapFits(ctx context.Context, namespace string, parsed *ParsedManifest) (bool, error) {
+	baseline := cs.k8s.baseline
+	if baseline == nil || (baseline.QuotaCPU == nil && baseline.QuotaMemory == nil) {
+		return true, nil
+	}
+	deployments, err := cs.k8s.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	statefulSets, err := cs.k8s.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	defaults := cs.requestDefaults()
+	total, _ := manifestRequests(&ParsedManifest{Deployments: deployments.Items, StatefulSets: statefulSets.Items}, defaults)
+	next, _ := manifestRequests(&ParsedManifest{Deployments: parsed.Deployments}, defaults)
+	total.Add(next)
+	if baseline.QuotaCPU != nil && total.CPU.Cmp(*baseline.QuotaCPU) > 0 {
+		return false, nil
+	}
+	if baseline.QuotaMemory != nil && total.Memory.Cmp(*baseline.QuotaMemory) > 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// StartRevisionSwap applies a redeploy's manifest beside the running
+// revision. ConfigMaps, Secrets, claims and StatefulSets are updated in
+// place; the Deployments are created in the next slot while the Services and
+// HPAs stay on the live one until FinishRevisionSwap.
+func (k *K8sService) StartRevisionSwap(ctx context.Context, namespace string, parsed *ParsedManifest) (*RevisionSwap, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	if ns.Status.Phase == corev1.NamespaceTerminating {
+		return nil, fmt.Errorf("namespace %s is still terminating from a cleanup; try again shortly", namespace)
+	}
+	live := ns.Annotations[revisionSlotAnnotation]
+	swap := &RevisionSwap{Namespace: namespace, From: live, To: nextSlot(live)}
+	objects := revisionObjects(parsed, swap.To)
+
+	manifestNames := make(map[string]bool, len(parsed.Deployments))
+	for _, dep := range parsed.Deployments {
+		manifestNames[dep.Name] = true
+	}
+
+	// The live revision is the live slot's Deployments or, before the first
+	// swap, the ones the manifest names. Leftovers in the next slot never
+	// went live, e.g. a swap a restart interrupted, and are replaced.
+	existing, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, dep := range existing.Items {
+		slot, slotted := dep.Labels[revisionSlotLabel]
+		switch {
+		case slotted && slot == swap.To:
+			if err := k.deleteDeployment(ctx, namespace, dep.Name); err != nil {
+				return nil, err
+			}
+		case slotted || (live == "" && manifestNames[dep.Name]):
+			swap.previous = append(swap.previous, dep.Name)
+		}
+	}
+
+	inPlace := &ParsedManifest{
+		ConfigMaps:             objects.ConfigMaps,
+		Secrets:                objects.Secrets,
+		PersistentVolumeClaims: objects.PersistentVolumeClaims,
+		StatefulSets:           objects.StatefulSets,
+	}
+	if err := k.ApplyParsedManifest(ctx, namespace, inPlace, map[string]string{"preview": "true", "managed-by": "pr-previews"}, nil); err != nil {
+		return nil, err
+	}
+
+	for _, dep := range objects.Deployments {
+		if err := k.deployManifestDeployment(ctx, namespace, &dep); err != nil {
+			k.abandonRevision(swap)
+			return nil, fmt.Errorf("failed to deploy deployment %s: %v", dep.Name, err)
+		}
+		swap.Deployments = append(swap.Deployments, dep.Name)
+	}
+
+	swap.services = objects.Services
+	// The live revision keeps its scaling until the swap
+	swap.hpas = &ParsedManifest{HorizontalPodAutoscalers: objects.HorizontalPodAutoscalers}
+	return swap, nil
+}
+
+// revisionObjects are the objects a swap to slot applies: the ConfigMaps,
+// Secrets, claims and StatefulSets updated in place, the Deployments renamed
+// and labelled for the slot, and the Services and HPAs pointed at them
+func revisionObjects(parsed *ParsedManifest, slot string) *ParsedManifest {
+	objects := &ParsedManifest{
+		ConfigMaps:             parsed.ConfigMaps,
+		Secrets:                parsed.Secrets,
+		PersistentVolumeClaims: parsed.PersistentVolumeClaims,
+	}
+	// Their pods keep the label the pod health watcher selects on
+	for _, statefulSet := range parsed.StatefulSets {
+		sts := statefulSet.DeepCopy()
+		if sts.Spec.Template.Labels == nil {
+			sts.Spec.Template.Labels = make(map[string]string)
+		}
+		sts.Spec.Template.Labels["preview"] = "true"
+		objects.StatefulSets = append(objects.StatefulSets, *sts)
+	}
+
+	manifestNames := make(map[string]bool, len(parsed.Deployments))
+	newSelects := map[string]bool{} // manifest Services that select the new revision's pods
+	for _, deployment := range parsed.Deployments {
+		manifestNames[deployment.Name] = true
+		for _, svc := range parsed.Services {
+			if len(svc.Spec.Selector) > 0 && labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(deployment.Spec.Template.Labels)) {
+				newSelects[svc.Name] = true
+			}
+		}
+
+		dep := deployment.DeepCopy()
+		dep.Name = slotName(deployment.Name, slot)
+		if dep.Labels == nil {
+			dep.Labels = make(map[string]string)
+		}
+		dep.Labels[revisionSlotLabel] = slot
+		if dep.Spec.Template.Labels == nil {
+			dep.Spec.Template.Labels = make(map[string]string)
+		}
+		dep.Spec.Template.Labels[revisionSlotLabel] = slot
+		// Keep the revisions' selectors apart so neither adopts the other's pods
+		if dep.Spec.Selector == nil {
+			dep.Spec.Selector = &metav1.LabelSelector{}
+		}
+		if dep.Spec.Selector.MatchLabels == nil {
+			dep.Spec.Selector.MatchLabels = make(map[string]string)
+		}
+		dep.Spec.Selector.MatchLabels[revisionSlotLabel] = slot
+		objects.Deployments = append(objects.Deployments, *dep)
+	}
+
+	for _, manifest := range parsed.Services {
+		svc := manifest.DeepCopy()
+		if newSelects[svc.Name] {
+			svc.Spec.Selector = copyStringMap(svc.Spec.Selector)
+			svc.Spec.Selector[revisionSlotLabel] = slot
+		}
+		objects.Services = append(objects.Services, *svc)
+	}
+	for _, autoscaler := range parsed.HorizontalPodAutoscalers {
+		hpa := autoscaler.DeepCopy()
+		if hpa.Spec.ScaleTargetRef.Kind == "Deployment" && manifestNames[hpa.Spec.ScaleTargetRef.Name] {
+			hpa.Spec.ScaleTargetRef.Name = slotName(hpa.Spec.ScaleTargetRef.Name, slot)
+		}
+		objects.HorizontalPodAutoscalers = append(objects.HorizontalPodAutoscalers, *hpa)
+	}
+	return objects
+}
+
+// FinishRevisionSwap waits for the new revision's Deployments to be ready,
+// then points the Services at its pods and deletes the previous revision. A
+// revision that isn't ready in time is deleted and the live one keeps
+// serving.
+func (k *K8sService) FinishRevisionSwap(ctx context.Context, swap *RevisionSwap, timeout time.Duration) error {
+	for _, name := range swap.Deployments {
+		if err := k.waitForRevision(ctx, swap.Namespace, name, timeout); err != nil {
+			k.abandonRevision(swap)
+			return fmt.Errorf("deployment %s wasn't ready within %s: %v", name, timeout, err)
+		}
+	}
+
+	for _, svc := range swap.services {
+		if err := k.switchService(ctx, swap.Namespace, &svc); err != nil {
+			return err
+		}
+	}
+	if err := k.ApplyParsedManifest(ctx, swap.Namespace, swap.hpas, map[string]string{"preview": "true", "managed-by": "pr-previews"}, nil); err != nil {
+		return err
+	}
+
+	if err := k.AnnotateNamespace(ctx, swap.Namespace, nil, map[string]string{revisionSlotAnnotation: swap.To}); err != nil {
+		return err
+	}
+	for _, name := range swap.previous {
+		if err := k.deleteDeployment(ctx, swap.Namespace, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// switchService updates the selector and ports of a Service the preview
+// already has, or creates one the new revision added
+func (k *K8sService) switchService(ctx context.Context, namespace string, svc *corev1.Service) error {
+	client := k.client.CoreV1().Services(namespace)
+	existing, err := client.Get(ctx, svc.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return k.deployManifestService(ctx, namespace, svc)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get service %s: %v", svc.Name, err)
+	}
+	existing.Spec.Selector = svc.Spec.Selector
+	existing.Spec.Ports = svc.Spec.Ports
+	if _, err := client.Update(ctx, existing, k.updateOptions()); err != nil {
+		return fmt.Errorf("failed to switch service %s: %v", svc.Name, err)
+	}
+	return nil
+}
+
+// waitForRevision waits until every replica the Deployment asks for is ready
+func (k *K8sService) waitForRevision(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	return wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		dep, err := k.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return revisionReady(dep), nil
+	})
+}
+
+func revisionReady(dep *appsv1.Deployment) bool {
+	want := int32(1)
+	if dep.Spec.Replicas != nil {
+		want = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration >= dep.Generation &&
+		dep.Status.UpdatedReplicas >= want && dep.Status.ReadyReplicas >= want
+}
+
+// abandonRevision deletes the new revision's Deployments; it runs after a
+// failure, so errors are only logged
+func (k *K8sService) abandonRevision(swap *RevisionSwap) {
+	for _, name := range swap.Deployments {
+		if err := k.deleteDeployment(context.Background(), swap.Namespace, name); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
+
+func (k *K8sService) deleteDeployment(ctx context.Context, namespace, name string) error {
+	err := k.client.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployment %s: %v", name, err)
+	}
+	return nil
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in)+1)
+	for key, value := range in {
+		out[key] = value
+	}
+	return out
+}
+
+// completeRevisionSwap moves a redeployed preview's traffic to the new
+// revision once it's ready, reporting on the PR only when it can't
+func (cs *CommandServiceK8s) completeRevisionSwap(cmd *types.Command, service string, swap *RevisionSwap) {
+	ctx, cancel := context.WithTimeout(context.Background(), cs.config.Preview.SwapTimeout+time.Minute)
+	defer cancel()
+
+	if err := cs.k8s.FinishRevisionSwap(ctx, swap, cs.config.Preview.SwapTimeout); err != nil {
+		fmt.Printf("Warning: revision swap in %s failed: %v\n", swap.Namespace, err)
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "Revision %s of %s abandoned: %v", swap.To, swap.Namespace, err)
+		comment := fmt.Sprintf("## ⚠️ Redeploy Not Switched\n\n**📦 Namespace:** `%s`\n**Error:** %s\n\nThe previous revision keeps serving the preview. Check the new pods with `/inspect %s`, or run `/cleanup` then `/preview %s` to deploy from scratch.",
+			swap.Namespace, err.Error(), service, service)
+		if err := cs.comments.PostComment(context.Background(), cmd.Repo, cmd.PRNumber, comment); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		return
+	}
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "Switched %s to revision %s", swap.Namespace, swap.To)
+	cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ChangeRules decide which of a PR's changed files count when detecting the
+// services it touches. Patterns are slash-separated globs: one ending in /
+// matches a directory and everything under it (docs/, .github/), one without
+// a / matches file names anywhere (*.md), and any other matches whole paths
+// from the repo root (deploy/*.tf).
+type ChangeRules struct {
+	// Ignore lists files that never change a service on their own
+	Ignore []string `yaml:"ignore"`
+
+	// Always lists files that change every service, such as a shared base
+	// image or lockfile; they win over Ignore
+	Always []string `yaml:"always"`
+}
+
+func (r ChangeRules) validate() error {
+	for _, pattern := range append(append([]string{}, r.Ignore...), r.Always...) {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("patterns can't be empty")
+		}
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Empty reports whether every changed file counts
+func (r ChangeRules) Empty() bool {
+	return len(r.Ignore) == 0 && len(r.Always) == 0
+}
+
+// Relevant drops ignored files from a PR's changed files
+func (r ChangeRules) Relevant(files []string) []string {
+	var relevant []string
+	for _, file := range files {
+		if !matchesChangePattern(r.Ignore, file) || matchesChangePattern(r.Always, file) {
+			relevant = append(relevant, file)
+		}
+	}
+	return relevant
+}
+
+// AlwaysChanged reports whether any file changes every service
+func (r ChangeRules) AlwaysChanged(files []string) bool {
+	for _, file := range files {
+		if matchesChangePattern(r.Always, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceChanged applies the rules to serviceChanged
+func (r ChangeRules) ServiceChanged(service DiscoveredService, files []string) bool {
+	return r.AlwaysChanged(files) || serviceChanged(service, r.Relevant(files))
+}
+
+func matchesChangePattern(patterns []string, file string) bool {
+	file = strings.TrimPrefix(file, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "/")
+		switch {
+		case strings.HasSuffix(pattern, "/"):
+			dir := strings.TrimSuffix(pattern, "/")
+			for prefix := path.Dir(file); prefix != "."; prefix = path.Dir(prefix) {
+				if ok, _ := path.Match(dir, prefix); ok {
+					return true
+				}
+			}
+		case !strings.Contains(pattern, "/"):
+			if ok, _ := path.Match(pattern, path.Base(file)); ok {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(pattern, file); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
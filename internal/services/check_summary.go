@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// checkOutputLimit is the most characters GitHub keeps of a check run's
+// summary, and separately of its text
+const checkOutputLimit = 65535
+
+// commandOutcome is the last run of one command on one service of a PR
+type commandOutcome struct {
+	Command string
+	Service string
+	User    string
+	Success bool
+	Result  string
+	Time    time.Time
+}
+
+// e2eOutcome is the last E2E result of one service
+type e2eOutcome struct {
+	Service    string
+	Conclusion string
+	URL        string
+	Time       time.Time
+}
+
+// deploymentArtifacts is one deploy's files in the artifact store
+type deploymentArtifacts struct {
+	Namespace  string
+	DeployedAt time.Time
+	Keys       []string
+}
+
+// finalOutcomes reduces the timeline to the last run of every command per
+// service and the last E2E result per service, oldest first
+func finalOutcomes(entries []TimelineEntry) ([]commandOutcome, []e2eOutcome) {
+	commands := map[string]commandOutcome{}
+	e2e := map[string]e2eOutcome{}
+	for _, entry := range entries {
+		switch {
+		case entry.Kind == TimelineCommand:
+			command, _ := entry.Details["type"].(string)
+			service, _ := entry.Details["service"].(string)
+			success, _ := entry.Details["success"].(bool)
+			result, _ := entry.Details["result"].(string)
+			if command == "" {
+				continue
+			}
+			commands[command+"/"+service] = commandOutcome{Command: command, Service: service, User: entry.User, Success: success, Result: result, Time: entry.Time}
+		case entry.Kind == TimelineLog && entry.Details["e2e"] == true:
+			service, _ := entry.Details["service"].(string)
+			conclusion, _ := entry.Details["conclusion"].(string)
+			url, _ := entry.Details["url"].(string)
+			e2e[service] = e2eOutcome{Service: service, Conclusion: conclusion, URL: url, Time: entry.Time}
+		}
+	}
+
+	outcomes := make([]commandOutcome, 0, len(commands))
+	for _, outcome := range commands {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].Time.Before(outcomes[j].Time) })
+	results := make([]e2eOutcome, 0, len(e2e))
+	for _, result := range e2e {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Service < results[j].Service })
+	return outcomes, results
+}
+
+// latestDeployments groups the PR's artifact keys by deploy, as laid out by
+// saveDeploymentArtifacts, keeping the newest deploy of each namespace
+func latestDeployments(prNumber int, keys []string) []deploymentArtifacts {
+	prefix := fmt.Sprintf("pr-%d/", prNumber)
+	latest := map[string]*deploymentArtifacts{}
+	for _, key := range keys {
+		deploymentID, _, found := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		if !found || len(deploymentID) < 18 {
+			continue
+		}
+		deployedAt, err := time.Parse("20060102T150405Z", deploymentID[:16])
+		if err != nil {
+			continue // timelines, snapshots and other artifacts
+		}
+		namespace := deploymentID[17:]
+		current := latest[namespace]
+		if current == nil || deployedAt.After(current.DeployedAt) {
+			current = &deploymentArtifacts{Namespace: namespace, DeployedAt: deployedAt}
+			latest[namespace] = current
+		}
+		if deployedAt.Equal(current.DeployedAt) {
+			current.Keys = append(current.Keys, key)
+		}
+	}
+
+	deployments := make([]deploymentArtifacts, 0, len(latest))
+	for _, deployment := range latest {
+		deployments = append(deployments, *deployment)
+	}
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].Namespace < deployments[j].Namespace })
+	return deployments
+}
+
+// repoDeployments keeps the deploys whose metadata names repo: PR numbers,
+// and so artifact prefixes, are shared by every repository
+func (cs *CommandServiceK8s) repoDeployments(ctx context.Context, repo string, deployments []deploymentArtifacts) []deploymentArtifacts {
+	kept := make([]deploymentArtifacts, 0, len(deployments))
+	for _, deployment := range deployments {
+		for _, key := range deployment.Keys {
+			if !strings.HasSuffix(key, "/metadata.json") {
+				continue
+			}
+			content, err := cs.artifacts.Get(ctx, key)
+			if err != nil {
+				break
+			}
+			var metadata struct {
+				Repo string `json:"repo"`
+			}
+			if json.Unmarshal(content, &metadata) == nil && strings.EqualFold(metadata.Repo, repo) {
+				kept = append(kept, deployment)
+			}
+			break
+		}
+	}
+	return kept
+}
+
+// checkSummaryLink links path on the bot's API when SERVER_PUBLIC_URL is
+// set, and otherwise names it in code
+func (cs *CommandServiceK8s) checkSummaryLink(label, path string) string {
+	if cs.config.Server.PublicURL == "" {
+		return fmt.Sprintf("`%s`", label)
+	}
+	return fmt.Sprintf("[%s](%s/api/v1%s)", label, cs.config.Server.PublicURL, path)
+}
+
+// buildCheckSummary renders the summary check run: the final state of the
+// PR's commands, previews and E2E runs, and its deployment artifacts
+func (cs *CommandServiceK8s) buildCheckSummary(prNumber int, commands []commandOutcome, e2e []e2eOutcome, rows []previewSummaryRow, deployments []deploymentArtifacts) CheckRun {
+	failed := 0
+	var summary strings.Builder
+	summary.WriteString("### Commands\n\n")
+	if len(commands) == 0 {
+		summary.WriteString("_No commands recorded._\n")
+	} else {
+		summary.WriteString("| Command | Service | Last run | By | Result |\n|---------|---------|----------|----|--------|\n")
+		for _, outcome := range commands {
+			status := "✅"
+			if !outcome.Success {
+				status = "❌"
+				failed++
+			}
+			service := "—"
+			if outcome.Service != "" {
+				service = fmt.Sprintf("`%s`", outcome.Service)
+			}
+			summary.WriteString(fmt.Sprintf("| `/%s` | %s | %s | @%s | %s %s |\n", outcome.Command, service,
+				outcome.Time.UTC().Format("2006-01-02 15:04 UTC"), outcome.User, status, strings.ReplaceAll(SanitizeEcho(outcome.Result), "|", "\\|")))
+		}
+	}
+
+	summary.WriteString("\n### Previews\n\n")
+	if len(rows) == 0 {
+		summary.WriteString("_No preview environments are active._\n")
+	} else {
+		summary.WriteString("| Service | Status | URL | Logs |\n|---------|--------|-----|------|\n")
+		for _, row := range rows {
+			url := "—"
+			if row.Host != "" {
+				url = fmt.Sprintf("[%s](https://%s)", row.Host, row.Host)
+			}
+			if strings.HasPrefix(row.Status, "⚠️") {
+				failed++
+			}
+			summary.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s |\n", row.Service, row.Status, url,
+				cs.checkSummaryLink("logs", fmt.Sprintf("/previews/%d/%s/logs", prNumber, row.Service))))
+		}
+	}
+
+	if len(e2e) > 0 {
+		summary.WriteString("\n### E2E\n\n| Service | Result | Run |\n|---------|--------|-----|\n")
+		for _, result := range e2e {
+			if result.Conclusion != "success" {
+				failed++
+			}
+			run := "—"
+			if result.URL != "" {
+				run = fmt.Sprintf("[workflow run](%s)", result.URL)
+			}
+			summary.WriteString(fmt.Sprintf("| `%s` | %s | %s |\n", result.Service, result.Conclusion, run))
+		}
+	}
+	summary.WriteString(fmt.Sprintf("\n%s of every command, comment and background warning on this PR.\n",
+		cs.checkSummaryLink("Timeline", fmt.Sprintf("/previews/%d/timeline", prNumber))))
+
+	var text strings.Builder
+	text.WriteString("### Deployment artifacts\n\n")
+	if len(deployments) == 0 {
+		text.WriteString("_No deployment artifacts are stored._\n")
+	}
+	for _, deployment := range deployments {
+		files := make([]string, 0, len(deployment.Keys))
+		for _, key := range deployment.Keys {
+			name := key[strings.LastIndex(key, "/")+1:]
+			files = append(files, cs.checkSummaryLink(name, fmt.Sprintf("/previews/%d/artifacts/%s", prNumber, strings.TrimPrefix(key, fmt.Sprintf("pr-%d/", prNumber)))))
+		}
+		text.WriteString(fmt.Sprintf("- **%s** (%s): %s\n", deployment.Namespace, deployment.DeployedAt.Format("2006-01-02 15:04 UTC"), strings.Join(files, ", ")))
+	}
+	if cs.config.Server.PublicURL != "" {
+		text.WriteString("\n*Links go through the bot's API and need a token with read access.*\n")
+	}
+
+	conclusion, title := "success", fmt.Sprintf("%d commands, %d previews", len(commands), len(rows))
+	if failed > 0 {
+		// Neutral, so the summary never blocks a merge on its own
+		conclusion, title = "neutral", fmt.Sprintf("%s, %d need attention", title, failed)
+	}
+	return CheckRun{
+		Status:     "completed",
+		Conclusion: conclusion,
+		Output: CheckRunOutput{
+			Title:   title,
+			Summary: truncateCheckOutput(summary.String()),
+			Text:    truncateCheckOutput(text.String()),
+		},
+	}
+}
+
+// truncateCheckOutput cuts output GitHub would reject for its length
+func truncateCheckOutput(output string) string {
+	const note = "\n\n_Truncated; see the timeline for the rest._"
+	if len(output) <= checkOutputLimit {
+		return output
+	}
+	cut := checkOutputLimit - len(note)
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return output[:cut] + note
+}
+
+// UpdateCheckSummary writes the PR's summary check run on its head commit,
+// replacing the one an earlier update left there. It's skipped while the
+// rate limit budget is low; the next update catches up.
+func (cs *CommandServiceK8s) UpdateCheckSummary(ctx context.Context, repo string, prNumber int) error {
+	name := cs.config.GitHub.SummaryCheck
+	if name == "" || repo == "" || !cs.github.authenticated() {
+		return nil
+	}
+	if !cs.github.budget.allowNonEssential() {
+		return errGitHubBudgetLow
+	}
+
+	var entries []TimelineEntry
+	if cs.timeline != nil {
+		var err error
+		if entries, err = cs.timeline.Entries(ctx, repo, prNumber); err != nil {
+			return err
+		}
+	}
+	commands, e2e := finalOutcomes(entries)
+	rows, err := cs.previewSummaryRows(ctx, repo, prNumber)
+	if err != nil {
+		return err
+	}
+	keys, err := cs.artifacts.List(ctx, fmt.Sprintf("pr-%d/", prNumber))
+	if err != nil {
+		return err
+	}
+	deployments := cs.repoDeployments(ctx, repo, latestDeployments(prNumber, keys))
+	check := cs.buildCheckSummary(prNumber, commands, e2e, rows, deployments)
+
+	sha, _, err := cs.github.GetPullRequestHead(ctx, repo, prNumber)
+	if err != nil {
+		return err
+	}
+	runs, err := cs.github.ListCheckRuns(ctx, repo, sha, name)
+	if err != nil {
+		return err
+	}
+	if len(runs) > 0 {
+		return cs.github.UpdateCheckRun(ctx, repo, runs[0].ID, check)
+	}
+	check.Name, check.HeadSHA = name, sha
+	_, err = cs.github.CreateCheckRun(ctx, repo, check)
+	return err
+}
+
+// RefreshCheckSummary updates the summary check run in the background,
+// logging rather than failing the command that triggered it
+func (cs *CommandServiceK8s) RefreshCheckSummary(repo string, prNumber int) {
+	sharedSummaryRefreshes.schedule(repo, prNumber, false, func(comment bool) {
+		cs.refreshSummaries(repo, prNumber, comment)
+	})
+}
+
+func (cs *CommandServiceK8s) refreshCheckSummary(repo string, prNumber int) {
+	err := cs.UpdateCheckSummary(context.Background(), repo, prNumber)
+	if errors.Is(err, errGitHubBudgetLow) {
+		fmt.Printf("Skipped summary check update for PR #%d: %v\n", prNumber, err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to update summary check for PR #%d: %v\n", prNumber, err)
+		cs.logTimeline(repo, prNumber, "Failed to update the summary check: %v", err)
+	}
+}
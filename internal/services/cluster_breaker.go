@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrClusterUnavailable is returned by Kubernetes calls while the breaker
+// is open, instead of waiting for them to time out
+var ErrClusterUnavailable = errors.New("the Kubernetes API is unreachable; calls are failing fast until it's back")
+
+// Breaker states
+const (
+	BreakerClosed = "closed" // calls go through
+	BreakerOpen   = "open"   // calls fail fast while probes back off
+)
+
+// Commands that don't touch the cluster, or cope without it
+var clusterFreeCommands = map[string]bool{
+	"help":        true,
+	"queue":       true,
+	"grant":       true,
+	"revoke":      true,
+	"maintenance": true,
+}
+
+// Commands held for when the cluster is back rather than turned away
+var breakerHeldCommands = map[string]bool{
+	"preview": true,
+	"cleanup": true,
+}
+
+// NeedsCluster reports whether the command can't run while the breaker is
+// open
+func NeedsCluster(commandType string) bool {
+	return !clusterFreeCommands[commandType]
+}
+
+// BreakerHolds reports whether the command waits for the cluster while the
+// breaker is open
+func BreakerHolds(commandType string) bool {
+	return breakerHeldCommands[commandType]
+}
+
+// probeContextKey marks the breaker's own probes, which go through while
+// it's open
+type probeContextKey struct{}
+
+// ClusterBreaker trips after consecutive Kubernetes API calls fail to reach
+// the cluster. While open, calls fail fast with ErrClusterUnavailable and a
+// probe checks the API with exponential backoff; the first probe that gets
+// through closes it and runs the commands held meanwhile.
+type ClusterBreaker struct {
+	mu         sync.Mutex
+	threshold  int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxHeld    int
+
+	state    string
+	failures int
+	lastErr  string
+	openedAt time.Time
+	trips    int64
+	held     []func()
+	probing  bool
+
+	// probe checks the API; probeCluster when nil
+	probe func(ctx context.Context) error
+}
+
+// Kubernetes clients are created per command, so they share one breaker
+// like GitHub clients share a rate budget
+var sharedClusterBreaker = &ClusterBreaker{
+	threshold:  3,
+	minBackoff: 5 * time.Second,
+	maxBackoff: 2 * time.Minute,
+	maxHeld:    20,
+	state:      BreakerClosed,
+}
+
+// SharedClusterBreaker is the breaker every K8sService reports to
+func SharedClusterBreaker() *ClusterBreaker {
+	return sharedClusterBreaker
+}
+
+// Configure sets how many consecutive failures trip the breaker, the probe
+// backoff bounds and how many commands are held while it's open. A
+// threshold below 1 disables the breaker.
+func (b *ClusterBreaker) Configure(threshold int, minBackoff, maxBackoff time.Duration, maxHeld int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	if minBackoff > 0 {
+		b.minBackoff = minBackoff
+	}
+	if maxBackoff >= b.minBackoff {
+		b.maxBackoff = maxBackoff
+	}
+	if maxHeld >= 0 {
+		b.maxHeld = maxHeld
+	}
+}
+
+// Open reports whether calls are failing fast
+func (b *ClusterBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == BreakerOpen
+}
+
+// Hold queues run for when the cluster is back. False means too many are
+// held already and the command should be turned away. run is called from
+// the probe goroutine, so it should hand the command to a worker rather
+// than run it.
+func (b *ClusterBreaker) Hold(run func()) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen || len(b.held) >= b.maxHeld {
+		return false
+	}
+	b.held = append(b.held, run)
+	return true
+}
+
+// ClusterBreakerStats is the breaker as /health and /metrics report it
+type ClusterBreakerStats struct {
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	Trips     int64  `json:"trips"`
+	Held      int    `json:"held"`
+	OpenSince string `json:"open_since,omitempty"` // only while open
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Stats reports the breaker for /health and /metrics
+func (b *ClusterBreaker) Stats() ClusterBreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := ClusterBreakerStats{
+		State:    b.state,
+		Failures: b.failures,
+		Trips:    b.trips,
+		Held:     len(b.held),
+	}
+	if b.state == BreakerOpen {
+		stats.OpenSince = b.openedAt.UTC().Format(time.RFC3339)
+		stats.LastError = b.lastErr
+	}
+	return stats
+}
+
+// success resets the failure count
+func (b *ClusterBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// failure counts a call that didn't reach the cluster and trips the breaker
+// at the threshold
+func (b *ClusterBreaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold < 1 || b.state == BreakerOpen {
+		return
+	}
+	b.failures++
+	b.lastErr = err.Error()
+	if b.failures < b.threshold {
+		return
+	}
+
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.trips++
+	fmt.Printf("🔌 Kubernetes API unreachable after %d failed calls, failing fast: %v\n", b.failures, err)
+	if !b.probing {
+		b.probing = true
+		go b.probeUntilClosed()
+	}
+}
+
+// probeUntilClosed checks the API with exponential backoff until a probe
+// gets through, then closes the breaker and runs the held commands in order
+func (b *ClusterBreaker) probeUntilClosed() {
+	b.mu.Lock()
+	backoff, probe := b.minBackoff, b.probe
+	b.mu.Unlock()
+	if probe == nil {
+		probe = probeCluster
+	}
+
+	for {
+		time.Sleep(backoff)
+
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), probeContextKey{}, true), 10*time.Second)
+		err := probe(ctx)
+		cancel()
+
+		b.mu.Lock()
+		if err != nil {
+			b.lastErr = err.Error()
+			if backoff *= 2; backoff > b.maxBackoff {
+				backoff = b.maxBackoff
+			}
+			b.mu.Unlock()
+			continue
+		}
+
+		downtime := time.Since(b.openedAt).Round(time.Second)
+		held := b.held
+		b.state, b.failures, b.held, b.probing = BreakerClosed, 0, nil, false
+		b.mu.Unlock()
+
+		fmt.Printf("🔌 Kubernetes API reachable again after %s, running %d held commands\n", downtime, len(held))
+		for _, run := range held {
+			run()
+		}
+		return
+	}
+}
+
+// probeCluster asks the API server for one namespace
+func probeCluster(ctx context.Context) error {
+	k8s, err := NewK8sService()
+	if err != nil {
+		return err
+	}
+	return k8s.TestConnection(ctx)
+}
+
+// wrap makes a Kubernetes client transport report to the breaker and fail
+// fast while it's open
+func (b *ClusterBreaker) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &breakerTransport{breaker: b, next: rt}
+}
+
+type breakerTransport struct {
+	breaker *ClusterBreaker
+	next    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe := req.Context().Value(probeContextKey{}) != nil
+	if !probe && t.breaker.Open() {
+		return nil, ErrClusterUnavailable
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case probe:
+	case err != nil:
+		// The caller giving up says nothing about the cluster
+		if req.Context().Err() == nil {
+			t.breaker.failure(err)
+		}
+	default:
+		// Any answer means the API server is up; a 503 comes from one
+		// aggregated API (metrics-server, say), not the whole cluster
+		t.breaker.success()
+	}
+	return resp, err
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Optional cluster APIs previews depend on
+const (
+	CapabilityIngress         = "ingress"
+	CapabilityGatewayAPI      = "gateway-api"
+	CapabilityAutoscaling     = "autoscaling-v2"
+	CapabilityMetricsServer   = "metrics-server"
+	CapabilityCertManager     = "cert-manager"
+	CapabilityVolumeSnapshots = "volume-snapshots"
+
+	// minSupportedMinor is the oldest Kubernetes 1.x every API previews use
+	// by default is served on (autoscaling/v2)
+	minSupportedMinor = 23
+
+	capabilityRefreshInterval = 15 * time.Minute
+)
+
+// clusterCapabilityChecks is what detection looks for and what goes
+// without each. Gating capabilities turn features off when missing; the
+// rest are reported only.
+var clusterCapabilityChecks = []struct {
+	Name         string
+	GroupVersion string
+	Resource     string
+	Affects      string
+	Gates        bool
+}{
+	{CapabilityIngress, "networking.k8s.io/v1", "ingresses", "preview URLs, `/tap`, `/canary` and `/share`", true},
+	{CapabilityGatewayAPI, "gateway.networking.k8s.io/v1", "httproutes", "nothing yet; previews are exposed with Ingress", false},
+	{CapabilityAutoscaling, "autoscaling/v2", "horizontalpodautoscalers", "HorizontalPodAutoscalers of services that keep their scaling", true},
+	{CapabilityMetricsServer, "metrics.k8s.io/v1beta1", "pods", "HorizontalPodAutoscalers scaling on CPU or memory", false},
+	{CapabilityCertManager, "cert-manager.io/v1", "certificates", "TLS certificates requested through Ingress annotations", false},
+	{CapabilityVolumeSnapshots, "snapshot.storage.k8s.io/v1", "volumesnapshots", "`/snapshot --volumes=true` and restoring volume data", true},
+}
+
+// ClusterCapability is whether the cluster serves an API a feature needs
+type ClusterCapability struct {
+	Name         string `json:"name"`
+	GroupVersion string `json:"group_version"`
+	Resource     string `json:"resource"`
+	Available    bool   `json:"available"`
+	Error        string `json:"error,omitempty"` // discovery failed, e.g. an aggregated API is down
+	Affects      string `json:"affects"`
+	Gates        bool   `json:"gates"` // features are turned off without it
+}
+
+// ClusterCapabilities is the result of API discovery against the cluster
+type ClusterCapabilities struct {
+	Version      string              `json:"version"`
+	Supported    bool                `json:"supported"` // at least 1.minSupportedMinor
+	Capabilities []ClusterCapability `json:"capabilities"`
+	DetectedAt   time.Time           `json:"detected_at"`
+}
+
+// Has reports whether the cluster serves the capability. Anything not
+// positively found missing counts as present, so a failed discovery never
+// turns features off.
+func (c *ClusterCapabilities) Has(name string) bool {
+	if c == nil {
+		return true
+	}
+	for _, capability := range c.Capabilities {
+		if capability.Name == name {
+			return capability.Available || capability.Error != ""
+		}
+	}
+	return true
+}
+
+// Missing lists the capabilities discovery found absent
+func (c *ClusterCapabilities) Missing() []ClusterCapability {
+	var missing []ClusterCapability
+	for _, capability := range c.Capabilities {
+		if !capability.Available && capability.Error == "" {
+			missing = append(missing, capability)
+		}
+	}
+	return missing
+}
+
+// DetectCapabilities asks the API server for its version and which of the
+// optional APIs it serves
+func (k *K8sService) DetectCapabilities(ctx context.Context) (*ClusterCapabilities, error) {
+	discovery := k.client.Discovery()
+	info, err := discovery.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %v", err)
+	}
+
+	minor, _ := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	detected := &ClusterCapabilities{
+		Version:    info.GitVersion,
+		Supported:  info.Major != "1" || minor >= minSupportedMinor,
+		DetectedAt: time.Now().UTC(),
+	}
+	for _, check := range clusterCapabilityChecks {
+		capability := ClusterCapability{Name: check.Name, GroupVersion: check.GroupVersion, Resource: check.Resource, Affects: check.Affects, Gates: check.Gates}
+		resources, err := discovery.ServerResourcesForGroupVersion(check.GroupVersion)
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			capability.Error = err.Error()
+		default:
+			for _, resource := range resources.APIResources {
+				capability.Available = capability.Available || resource.Name == check.Resource
+			}
+		}
+		detected.Capabilities = append(detected.Capabilities, capability)
+	}
+	return detected, nil
+}
+
+// capabilityStore keeps the last detection for every command service
+type capabilityStore struct {
+	mu       sync.RWMutex
+	detected *ClusterCapabilities
+}
+
+var sharedCapabilities = &capabilityStore{}
+
+// KnownClusterCapabilities returns the last detection, or nil before the
+// first one finished
+func KnownClusterCapabilities() *ClusterCapabilities {
+	sharedCapabilities.mu.RLock()
+	defer sharedCapabilities.mu.RUnlock()
+	return sharedCapabilities.detected
+}
+
+// RefreshClusterCapabilities detects the cluster's capabilities again and
+// remembers them
+func (cs *CommandServiceK8s) RefreshClusterCapabilities(ctx context.Context) (*ClusterCapabilities, error) {
+	detected, err := cs.k8s.DetectCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sharedCapabilities.mu.Lock()
+	sharedCapabilities.detected = detected
+	sharedCapabilities.mu.Unlock()
+	return detected, nil
+}
+
+// StartCapabilityDetector detects the cluster's capabilities at startup,
+// logging what's missing, and again periodically to notice CRDs installed
+// since
+func (cs *CommandServiceK8s) StartCapabilityDetector(ctx context.Context) {
+	ticker := time.NewTicker(capabilityRefreshInterval)
+	defer ticker.Stop()
+
+	logged := false
+	for {
+		detected, err := cs.RefreshClusterCapabilities(ctx)
+		if err != nil {
+			fmt.Printf("Warning: capability detection failed: %v\n", err)
+		} else if !logged {
+			logged = true
+			fmt.Printf("☸️  Kubernetes %s\n", detected.Version)
+			if !detected.Supported {
+				fmt.Printf("⚠️  Kubernetes older than 1.%d is not supported; deployments may fail\n", minSupportedMinor)
+			}
+			var absent []string
+			for _, capability := range detected.Missing() {
+				if capability.Gates {
+					fmt.Printf("⚠️  %s unavailable (%s); disabled: %s\n", capability.Name, capability.GroupVersion, capability.Affects)
+				} else {
+					absent = append(absent, capability.Name)
+				}
+			}
+			if len(absent) > 0 {
+				fmt.Printf("ℹ️  Not detected: %s\n", strings.Join(absent, ", "))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// formatCapabilities renders the capability table of /cluster-status
+func formatCapabilities(detected *ClusterCapabilities) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("\n### 🧩 Capabilities\n\n**Kubernetes:** %s", detected.Version))
+	if !detected.Supported {
+		content.WriteString(fmt.Sprintf(" ⚠️ older than 1.%d, which is not supported", minSupportedMinor))
+	}
+	content.WriteString("\n\n| API | Available | Without it |\n|-----|-----------|------------|\n")
+	for _, capability := range detected.Capabilities {
+		status := yesNo(capability.Available)
+		if capability.Error != "" {
+			status = "⚠️ discovery failed"
+		}
+		content.WriteString(fmt.Sprintf("| %s (`%s`) | %s | %s |\n", capability.Name, capability.GroupVersion, status, capability.Affects))
+	}
+	return content.String()
+}
+
+// capabilityError explains a feature being turned off because the cluster
+// lacks an API it needs; nil unless the capability is known to be missing
+func capabilityError(name string) error {
+	detected := KnownClusterCapabilities()
+	if detected.Has(name) {
+		return nil
+	}
+	for _, capability := range detected.Capabilities {
+		if capability.Name == name {
+			return fmt.Errorf("the cluster doesn't serve %s (`%s`), which %s need", capability.Resource, capability.GroupVersion, capability.Affects)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/types"
+)
+
+// Node conditions that mean the kubelet is short of something
+var nodePressureConditions = []corev1.NodeConditionType{
+	corev1.NodeMemoryPressure,
+	corev1.NodeDiskPressure,
+	corev1.NodePIDPressure,
+}
+
+// NodeHealth is a node's scheduling state and the pressure it reports
+type NodeHealth struct {
+	Name          string   `json:"name"`
+	Ready         bool     `json:"ready"`
+	Unschedulable bool     `json:"unschedulable"`
+	Pressure      []string `json:"pressure,omitempty"` // e.g. MemoryPressure, DiskPressure
+}
+
+// Healthy reports a ready, schedulable node without pressure
+func (n NodeHealth) Healthy() bool {
+	return n.Ready && !n.Unschedulable && len(n.Pressure) == 0
+}
+
+// ListNodeHealth reports every node's readiness and pressure conditions
+func (k *K8sService) ListNodeHealth(ctx context.Context) ([]NodeHealth, error) {
+	nodes, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	var health []NodeHealth
+	for _, node := range nodes.Items {
+		entry := NodeHealth{
+			Name:          node.Name,
+			Ready:         nodeReady(&node),
+			Unschedulable: node.Spec.Unschedulable,
+		}
+		for _, condition := range node.Status.Conditions {
+			for _, pressure := range nodePressureConditions {
+				if condition.Type == pressure && condition.Status == corev1.ConditionTrue {
+					entry.Pressure = append(entry.Pressure, string(pressure))
+				}
+			}
+		}
+		health = append(health, entry)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Name < health[j].Name })
+	return health, nil
+}
+
+// PreviewRequests sums the requests of pods still running in preview
+// namespaces, and counts them
+func (k *K8sService) PreviewRequests(ctx context.Context) (ResourceTotals, int, error) {
+	var totals ResourceTotals
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: "preview=true",
+	})
+	if err != nil {
+		return totals, 0, fmt.Errorf("failed to list preview namespaces: %v", err)
+	}
+
+	count := 0
+	for _, ns := range namespaces.Items {
+		pods, err := k.client.CoreV1().Pods(ns.Name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return totals, 0, fmt.Errorf("failed to list pods in %s: %v", ns.Name, err)
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			requests, _ := effectivePodRequests(&pod.Spec, nil)
+			totals.CPU.Add(requests.CPU)
+			totals.Memory.Add(requests.Memory)
+			count++
+		}
+	}
+	return totals, count, nil
+}
+
+// HandleClusterStatusK8s is an operator's health check: node pressure, how
+// much of the cluster previews request, previews per repository, what
+// cleanup is behind on and which optional APIs the cluster serves
+func (cs *CommandServiceK8s) HandleClusterStatusK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	nodes, err := cs.k8s.ListNodeHealth(ctx)
+	if err != nil {
+		return failedResponse("Cluster status failed", "Cluster Status Failed", err)
+	}
+	headroom, err := cs.k8s.GetClusterHeadroom(ctx)
+	if err != nil {
+		return failedResponse("Cluster status failed", "Cluster Status Failed", err)
+	}
+	previewRequests, previewPods, err := cs.k8s.PreviewRequests(ctx)
+	if err != nil {
+		return failedResponse("Cluster status failed", "Cluster Status Failed", err)
+	}
+	previews, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return failedResponse("Cluster status failed", "Cluster Status Failed", err)
+	}
+	stuck, err := cs.k8s.ListStuckPreviewNamespaces(ctx, cs.config.Preview.StuckAfter)
+	if err != nil {
+		return failedResponse("Cluster status failed", "Cluster Status Failed", err)
+	}
+
+	var content strings.Builder
+	content.WriteString("## 🩺 Cluster Status\n\n")
+
+	// Nodes
+	var unhealthy []NodeHealth
+	ready := 0
+	for _, node := range nodes {
+		if node.Ready {
+			ready++
+		}
+		if !node.Healthy() {
+			unhealthy = append(unhealthy, node)
+		}
+	}
+	content.WriteString(fmt.Sprintf("### 🖥️ Nodes\n\n**%d of %d ready.**", ready, len(nodes)))
+	if len(unhealthy) == 0 {
+		content.WriteString(" No node reports memory, disk or PID pressure.\n")
+	} else {
+		content.WriteString("\n\n| Node | Ready | Schedulable | Pressure |\n|------|-------|-------------|----------|\n")
+		for _, node := range unhealthy {
+			pressure := "—"
+			if len(node.Pressure) > 0 {
+				pressure = "⚠️ " + strings.Join(node.Pressure, ", ")
+			}
+			content.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s |\n", node.Name, yesNo(node.Ready), yesNo(!node.Unschedulable), pressure))
+		}
+	}
+
+	// Capacity
+	content.WriteString("\n### 📊 Requests vs Allocatable\n\n")
+	content.WriteString("| | CPU | Memory |\n|---|-----|--------|\n")
+	content.WriteString(fmt.Sprintf("| Allocatable (%d schedulable nodes) | %s | %s |\n",
+		headroom.Nodes, headroom.Allocatable.CPU.String(), headroom.Allocatable.Memory.String()))
+	content.WriteString(fmt.Sprintf("| Requested, all pods | %s | %s |\n",
+		formatShare(headroom.Requested.CPU, headroom.Allocatable.CPU), formatShare(headroom.Requested.Memory, headroom.Allocatable.Memory)))
+	content.WriteString(fmt.Sprintf("| Requested by previews (%d pods) | %s | %s |\n",
+		previewPods, formatShare(previewRequests.CPU, headroom.Allocatable.CPU), formatShare(previewRequests.Memory, headroom.Allocatable.Memory)))
+
+	// Previews by repository
+	byRepo := map[string]int{}
+	for _, ns := range previews {
+		repo := ns.Repo
+		if repo == "" {
+			repo = "unknown"
+		}
+		byRepo[repo]++
+	}
+	repos := make([]string, 0, len(byRepo))
+	for repo := range byRepo {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		if byRepo[repos[i]] != byRepo[repos[j]] {
+			return byRepo[repos[i]] > byRepo[repos[j]]
+		}
+		return repos[i] < repos[j]
+	})
+	content.WriteString(fmt.Sprintf("\n### 📦 Previews by Repository\n\n**Total:** %d preview namespaces\n", len(previews)))
+	if len(repos) > 0 {
+		content.WriteString("\n| Repository | Previews |\n|------------|----------|\n")
+		for _, repo := range repos {
+			content.WriteString(fmt.Sprintf("| %s | %d |\n", repo, byRepo[repo]))
+		}
+	}
+
+	// Reaper backlog: previews /gc would delete, and deletions that stall
+	var expired []string
+	for _, ns := range previews {
+		if ns.Terminating() {
+			continue
+		}
+		if expiresAt, ok := cs.previewExpiry(ns); ok && !time.Now().Before(expiresAt) {
+			expired = append(expired, ns.Name)
+		}
+	}
+	sort.Strings(expired)
+	content.WriteString("\n### 🧹 Reaper Backlog\n\n")
+	content.WriteString(fmt.Sprintf("- **Past their TTL:** %d%s\n", len(expired), formatBacklogSample(expired)))
+	var stuckNames []string
+	for _, blocker := range stuck {
+		stuckNames = append(stuckNames, blocker.Namespace)
+	}
+	content.WriteString(fmt.Sprintf("- **Terminating longer than %s:** %d%s\n", cs.config.Preview.StuckAfter, len(stuck), formatBacklogSample(stuckNames)))
+	if len(expired) > 0 {
+		content.WriteString("\n*Run `/gc` in the ops repository to delete expired previews.*\n")
+	}
+	if len(stuck) > 0 {
+		content.WriteString("\n*Run `/force-cleanup <namespace>` for namespaces stuck on finalizers.*\n")
+	}
+
+	// Detect again, so CRDs installed since startup are picked up
+	capabilities, err := cs.RefreshClusterCapabilities(ctx)
+	if err != nil {
+		content.WriteString(fmt.Sprintf("\n### 🧩 Capabilities\n\n⚠️ Detection failed: %s\n", err.Error()))
+	} else {
+		content.WriteString(formatCapabilities(capabilities))
+	}
+
+	content.WriteString(fmt.Sprintf("\n*Requested by: @%s*", cmd.User))
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Cluster status",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"nodes":            nodes,
+			"headroom":         headroom,
+			"preview_requests": previewRequests,
+			"preview_pods":     previewPods,
+			"previews_by_repo": byRepo,
+			"expired":          expired,
+			"stuck":            stuck,
+			"capabilities":     capabilities,
+		},
+	}
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "✅"
+	}
+	return "❌"
+}
+
+// formatShare renders a request with its share of the allocatable amount
+func formatShare(requested, allocatable resource.Quantity) string {
+	if allocatable.IsZero() {
+		return requested.String()
+	}
+	percent := float64(requested.MilliValue()) / float64(allocatable.MilliValue()) * 100
+	return fmt.Sprintf("%s (%.0f%%)", requested.String(), percent)
+}
+
+// formatBacklogSample names the first few backlog namespaces
+func formatBacklogSample(names []string) string {
+	const shown = 5
+	if len(names) == 0 {
+		return ""
+	}
+	sample := names
+	if len(sample) > shown {
+		sample = sample[:shown]
+	}
+	more := ""
+	if len(names) > shown {
+		more = fmt.Sprintf(" and %d more", len(names)-shown)
+	}
+	return fmt.Sprintf(" (`%s`%s)", strings.Join(sample, "`, `"), more)
+}
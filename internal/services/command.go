@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -9,24 +10,60 @@ import (
 )
 
 type CommandService struct {
-	// Will add dependencies later
+	lang *LanguagePack
+	team *DeployerRoster
 }
 
-func NewCommandService() *CommandService {
-	return &CommandService{}
+// NewCommandService answers the basic commands; team decides who may deploy,
+// and a nil team lets nobody
+func NewCommandService(lang *LanguagePack, team *DeployerRoster) *CommandService {
+	if lang == nil {
+		lang = DefaultLanguagePack()
+	}
+	return &CommandService{lang: lang, team: team}
 }
 
 // ParseCommand parses GitHub comment text into Command
 func (cs *CommandService) ParseCommand(commentBody, user string, prNumber int) (*types.Command, error) {
-	comment := strings.TrimSpace(commentBody)
+	comment := cs.lang.Canonicalize(strings.TrimSpace(commentBody))
+
+	if err := ValidateGitHubLogin(user); err != nil {
+		return nil, err
+	}
 
 	// Command patterns
 	patterns := map[string]*regexp.Regexp{
-		"help":    regexp.MustCompile(`^/help\s*$`),
-		"status":  regexp.MustCompile(`^/status\s*$`),
-		"plan":    regexp.MustCompile(`^/plan(?:\s+([a-zA-Z0-9/-]+))?\s*$`),
-		"preview": regexp.MustCompile(`^/preview(?:\s+([a-zA-Z0-9/-]+))?\s*$`),
-		"cleanup": regexp.MustCompile(`^/cleanup\s*$`),
+		"help":       regexp.MustCompile(`^/help\s*$`),
+		"status":     regexp.MustCompile(`^/status((?:\s+--[a-z-]+=\S+)*)\s*$`),
+		"plan":       regexp.MustCompile(`^/plan(?:\s+([a-zA-Z0-9/-]+))?\s*$`),
+		"preview":    regexp.MustCompile(`^/preview(?:\s+([a-zA-Z0-9/-]+))?((?:\s+--[a-z-]+(?:=\S+)?)*)\s*$`),
+		"cleanup":    regexp.MustCompile(`^/cleanup\s*$`),
+		"queue":      regexp.MustCompile(`^/queue\s*$`),
+		"services":   regexp.MustCompile(`^/services\s*$`),
+		"inspect":    regexp.MustCompile(`^/inspect\s+([a-zA-Z0-9/-]+)\s*$`),
+		"config":     regexp.MustCompile(`^/config(?:\s+([a-zA-Z0-9/-]+))?((?:\s+--[a-z-]+=\S+)*)\s*$`),
+		"promote":    regexp.MustCompile(`^/promote\s+([a-zA-Z0-9/-]+)\s*$`),
+		"snapshot":   regexp.MustCompile(`^/snapshot(?:\s+([a-zA-Z0-9/-]+))?((?:\s+--[a-z-]+=\S+)*)\s*$`),
+		"restore":    regexp.MustCompile(`^/restore\s+(snap-[0-9]{8}-[0-9]{6}(?:-[0-9a-f]{6})?)\s*$`),
+		"kubeconfig": regexp.MustCompile(`^/kubeconfig\s+([a-zA-Z0-9/-]+)\s*$`),
+		"chaos":      regexp.MustCompile(`^/chaos\s+([a-zA-Z0-9/-]+)((?:\s+--[a-z-]+=\S+)*)(\s+off)?\s*$`),
+		"canary":     regexp.MustCompile(`^/canary\s+([a-zA-Z0-9/-]+)((?:\s+--[a-z-]+=\S+)*)(\s+off)?\s*$`),
+		"scale":      regexp.MustCompile(`^/scale\s+([a-zA-Z0-9/-]+)\s+([0-9]+)\s*$`),
+		"tap":        regexp.MustCompile(`^/tap\s+([a-zA-Z0-9/-]+)((?:\s+--[a-z-]+=\S+)*)\s*$`),
+		"share":      regexp.MustCompile(`^/share(\s+revoke)?(?:\s+([a-zA-Z0-9/-]+))?((?:\s+--[a-z-]+=\S+)*)\s*$`),
+
+		// Ops commands, only honoured in the ops repository
+		"gc":            regexp.MustCompile(`^/gc((?:\s+--[a-z-]+=\S+)*)\s*$`),
+		"list-previews": regexp.MustCompile(`^/list-previews\s*$`),
+		"cluster-info":  regexp.MustCompile(`^/cluster-info\s*$`),
+		"force-cleanup": regexp.MustCompile(`^/force-cleanup\s+(preview-[a-z0-9-]+)\s*$`),
+		"grant":         regexp.MustCompile(`^/grant\s+@([A-Za-z0-9-]+)\s+(deployer)\s*$`),
+		"revoke":        regexp.MustCompile(`^/revoke\s+@([A-Za-z0-9-]+)\s*$`),
+		"maintenance":   regexp.MustCompile(`^/maintenance(?:\s+(on|off)(?:\s+(.+?))?)?\s*$`),
+		"loadtest":      regexp.MustCompile(`^/loadtest\s+([a-zA-Z0-9/-]+)((?:\s+--[a-z-]+=\S+)*)\s*$`),
+
+		// Admin commands accepted from any repository
+		"cluster-status": regexp.MustCompile(`^/cluster-status\s*$`),
 	}
 
 	for cmdType, pattern := range patterns {
@@ -37,31 +74,130 @@ func (cs *CommandService) ParseCommand(commentBody, user string, prNumber int) (
 				PRNumber: prNumber,
 			}
 
+			// /restore takes a snapshot ID rather than a service
+			if cmdType == "restore" {
+				cmd.Args = map[string]string{"snapshot": matches[1]}
+				return cmd, nil
+			}
+
+			// /chaos off (or /chaos <service> off) removes fault injection,
+			// /canary off the traffic split
+			if cmdType == "chaos" || cmdType == "canary" {
+				off := matches[1] == "off" || matches[3] != ""
+				if matches[1] != "off" {
+					if err := ValidateServiceName(matches[1]); err != nil {
+						return nil, err
+					}
+					cmd.Service = matches[1]
+				}
+				cmd.Args = parseCommandFlags(matches[2])
+				if off {
+					cmd.Args["off"] = "true"
+				}
+				return cmd, nil
+			}
+
+			// /share revoke [service] ends share links instead of minting one
+			if cmdType == "share" {
+				if matches[2] != "" {
+					if err := ValidateServiceName(matches[2]); err != nil {
+						return nil, err
+					}
+					cmd.Service = matches[2]
+				}
+				cmd.Args = parseCommandFlags(matches[3])
+				if matches[1] != "" {
+					cmd.Args["revoke"] = "true"
+				}
+				return cmd, nil
+			}
+
+			// /scale takes a replica count rather than flags
+			if cmdType == "scale" {
+				if err := ValidateServiceName(matches[1]); err != nil {
+					return nil, err
+				}
+				cmd.Service = matches[1]
+				cmd.Args = map[string]string{"replicas": matches[2]}
+				return cmd, nil
+			}
+
+			// /force-cleanup takes a namespace rather than a service
+			if cmdType == "force-cleanup" {
+				cmd.Args = map[string]string{"namespace": matches[1]}
+				return cmd, nil
+			}
+
+			// /grant and /revoke take a GitHub login rather than a service
+			if cmdType == "grant" || cmdType == "revoke" {
+				if err := ValidateGitHubLogin(matches[1]); err != nil {
+					return nil, err
+				}
+				cmd.Args = map[string]string{"login": matches[1]}
+				if cmdType == "grant" {
+					cmd.Args["role"] = matches[2]
+				}
+				return cmd, nil
+			}
+
+			// /maintenance takes on or off and a free-text reason
+			if cmdType == "maintenance" {
+				cmd.Args = map[string]string{"mode": matches[1], "reason": strings.TrimSpace(matches[2])}
+				return cmd, nil
+			}
+
+			// /gc and /status take flags only
+			if cmdType == "gc" || cmdType == "status" {
+				cmd.Args = parseCommandFlags(matches[1])
+				return cmd, nil
+			}
+
 			// Extract service name if provided
 			if len(matches) > 1 && matches[1] != "" {
+				if err := ValidateServiceName(matches[1]); err != nil {
+					return nil, err
+				}
 				cmd.Service = matches[1]
 			}
 
+			// Extract --key=value flags if provided
+			if len(matches) > 2 && matches[2] != "" {
+				cmd.Args = parseCommandFlags(matches[2])
+			}
+
 			return cmd, nil
 		}
 	}
 
-	return nil, fmt.Errorf("unknown command: %s", comment)
+	return nil, fmt.Errorf("unknown command: %s", SanitizeEcho(comment))
+}
+
+func parseCommandFlags(raw string) map[string]string {
+	args := make(map[string]string)
+	for _, field := range strings.Fields(raw) {
+		key, value, found := strings.Cut(strings.TrimPrefix(field, "--"), "=")
+		if !found {
+			// Bare flags like --priority are booleans
+			value = "true"
+		}
+		args[key] = value
+	}
+	return args
 }
 
 // ProcessCommand processes parsed command and returns response
-func (cs *CommandService) ProcessCommand(cmd *types.Command) *types.CommandResponse {
+func (cs *CommandService) ProcessCommand(ctx context.Context, cmd *types.Command) *types.CommandResponse {
 	switch cmd.Type {
 	case "help":
-		return cs.handleHelp(cmd)
+		return cs.handleHelp(ctx, cmd)
 	case "status":
 		return cs.handleStatus(cmd)
 	case "plan":
 		return cs.handlePlan(cmd)
 	case "preview":
-		return cs.handlePreview(cmd)
+		return cs.handlePreview(ctx, cmd)
 	case "cleanup":
-		return cs.handleCleanup(cmd)
+		return cs.handleCleanup(ctx, cmd)
 	default:
 		return &types.CommandResponse{
 			Success: false,
@@ -70,40 +206,90 @@ func (cs *CommandService) ProcessCommand(cmd *types.Command) *types.CommandRespo
 	}
 }
 
-func (cs *CommandService) handleHelp(cmd *types.Command) *types.CommandResponse {
-	helpText := `## 🤖 Available Commands
-
-**📖 Read-Only Commands (Available to Everyone):**
-- ` + "`/help`" + ` - Show this help message
-- ` + "`/status`" + ` - Show current preview environments
-- ` + "`/plan`" + ` - Show what would be deployed (dry-run)
-- ` + "`/plan <service>`" + ` - Show plan for specific service
-
-**🚀 Deployment Commands (Core Team Only):**
-- ` + "`/preview`" + ` - Deploy all changed services to preview
-- ` + "`/preview <service>`" + ` - Deploy specific service
-- ` + "`/cleanup`" + ` - Cleanup preview environments
-
-**Examples:**
+func (cs *CommandService) handleHelp(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	helpText := cs.lang.T("help.title") + `
+
+` + cs.lang.T("help.read_only") + `
+- ` + "`/help`" + ` - ` + cs.lang.T("help.cmd.help") + `
+- ` + "`/status`" + ` - ` + cs.lang.T("help.cmd.status") + `
+- ` + "`/status --format=json`" + ` - ` + cs.lang.T("help.cmd.status_js") + `
+- ` + "`/queue`" + ` - ` + cs.lang.T("help.cmd.queue") + `
+- ` + "`/services`" + ` - ` + cs.lang.T("help.cmd.services") + `
+- ` + "`/inspect <service>`" + ` - ` + cs.lang.T("help.cmd.inspect") + `
+- ` + "`/config [service] [--class=small] [--ttl=3d]`" + ` - ` + cs.lang.T("help.cmd.config") + `
+
+` + cs.lang.T("help.deploy") + `
+- ` + "`/plan`" + ` - ` + cs.lang.T("help.cmd.plan") + `
+- ` + "`/plan <service>`" + ` - ` + cs.lang.T("help.cmd.plan_svc") + `
+- ` + "`/preview`" + ` - ` + cs.lang.T("help.cmd.preview") + `
+- ` + "`/preview <service>`" + ` - ` + cs.lang.T("help.cmd.preview_sv") + `
+- ` + "`/preview all`" + ` - ` + cs.lang.T("help.cmd.preview_al") + `
+- ` + "`/preview <service> --class=small`" + ` - ` + cs.lang.T("help.cmd.preview_cl") + `
+- ` + "`/preview <service> --priority`" + ` - ` + cs.lang.T("help.cmd.preview_pr") + `
+- ` + "`/preview <service> --ttl=7d`" + ` - ` + cs.lang.T("help.cmd.preview_tt") + `
+- ` + "`/cleanup`" + ` - ` + cs.lang.T("help.cmd.cleanup") + `
+- ` + "`/loadtest <service> --replicas=N --duration=30m`" + ` - ` + cs.lang.T("help.cmd.loadtest") + `
+- ` + "`/promote <service>`" + ` - ` + cs.lang.T("help.cmd.promote") + `
+- ` + "`/snapshot [service] [--volumes=true]`" + ` - ` + cs.lang.T("help.cmd.snapshot") + `
+- ` + "`/restore <snapshot-id>`" + ` - ` + cs.lang.T("help.cmd.restore") + `
+- ` + "`/kubeconfig <service>`" + ` - ` + cs.lang.T("help.cmd.kubeconfig") + `
+- ` + "`/chaos <service> --latency=200ms --error-rate=5%`" + ` - ` + cs.lang.T("help.cmd.chaos") + `
+- ` + "`/chaos off`" + ` - ` + cs.lang.T("help.cmd.chaos_off") + `
+- ` + "`/canary <service> --weight=10`" + ` - ` + cs.lang.T("help.cmd.canary") + `
+- ` + "`/canary off`" + ` - ` + cs.lang.T("help.cmd.canary_off") + `
+- ` + "`/scale <service> <replicas>`" + ` - ` + cs.lang.T("help.cmd.scale") + `
+- ` + "`/tap <service> --duration=30s`" + ` - ` + cs.lang.T("help.cmd.tap") + `
+- ` + "`/share <service> --expires=24h`" + ` - ` + cs.lang.T("help.cmd.share") + `
+- ` + "`/share revoke [service] [--id=<link>]`" + ` - ` + cs.lang.T("help.cmd.share_rev") + `
+
+` + cs.lang.T("help.ops") + `
+- ` + "`/list-previews`" + ` - ` + cs.lang.T("help.cmd.list_prev") + `
+- ` + "`/cluster-info`" + ` - ` + cs.lang.T("help.cmd.cluster") + `
+- ` + "`/cluster-status`" + ` - ` + cs.lang.T("help.cmd.cl_status") + `
+- ` + "`/gc [--older-than=72h] [--idle-for=3d] [--dry-run=true]`" + ` - ` + cs.lang.T("help.cmd.gc") + `
+- ` + "`/force-cleanup <namespace>`" + ` - ` + cs.lang.T("help.cmd.force_cl") + `
+- ` + "`/grant @user deployer`" + ` - ` + cs.lang.T("help.cmd.grant") + `
+- ` + "`/revoke @user`" + ` - ` + cs.lang.T("help.cmd.revoke") + `
+- ` + "`/maintenance on [reason]`" + ` / ` + "`/maintenance off`" + ` - ` + cs.lang.T("help.cmd.maint") + `
+
+` + cs.lang.T("help.examples") + `
 ` + "```" + `
 /help
 /status
 /plan
 /plan ai/open-webui
+/queue
+/services
+/inspect myapp
+/config api
 /preview
 /preview ai/open-webui
+/preview api --class=small
 /cleanup
+/loadtest myapp --replicas=3 --duration=5m
+/promote myapp
+/snapshot --volumes=true
+/restore snap-20250101-120000-3fa9c1
+/kubeconfig myapp
+/chaos myapp --latency=200ms --error-rate=5%
+/chaos off
+/canary api --weight=10
+/canary off
+/scale api 3
+/tap api --duration=1m
+/share web --expires=3d
+/share revoke web
 ` + "```" + `
 
-*Triggered by: @` + cmd.User + `*`
+` + cs.lang.T("triggered_by", cmd.User)
 
 	return &types.CommandResponse{
 		Success: true,
 		Message: "Help information",
 		Content: helpText,
 		Data: map[string]interface{}{
-			"available_commands": []string{"help", "status", "plan", "preview", "cleanup"},
-			"user_permissions":   cs.getUserPermissions(cmd.User),
+			"available_commands": []string{"help", "status", "plan", "queue", "services", "inspect", "config", "preview", "cleanup", "loadtest", "promote", "snapshot", "restore", "kubeconfig", "chaos", "canary", "scale", "tap", "share", "gc", "list-previews", "cluster-info", "cluster-status", "force-cleanup", "grant", "revoke", "maintenance"},
+			"user_permissions":   cs.getUserPermissions(ctx, cmd.User),
 		},
 	}
 }
@@ -113,19 +299,8 @@ func (cs *CommandService) handleStatus(cmd *types.Command) *types.CommandRespons
 	return &types.CommandResponse{
 		Success: true,
 		Message: "Preview Environment Status",
-		Content: fmt.Sprintf(`## 📊 Preview Environment Status
-
-**PR:** #%d
-
-### ℹ️ No Preview Environments Found
-
-No preview environments are currently active for this PR.
-
-**To create preview environments:**
-- Run `+"`/preview`"+` to deploy all changed services
-- Run `+"`/preview <service>`"+` to deploy a specific service
-
-*Status checked by: @%s*`, cmd.PRNumber, cmd.User),
+		Content: fmt.Sprintf("%s\n\n**PR:** #%d\n\n%s\n\n%s\n\n%s",
+			cs.lang.T("status.title"), cmd.PRNumber, cs.lang.T("status.none"), cs.lang.T("status.create_hint"), cs.lang.T("status.checked_by", cmd.User)),
 		Data: map[string]interface{}{
 			"pr_number":       cmd.PRNumber,
 			"active_previews": []string{}, // TODO: Get from K8s
@@ -156,7 +331,7 @@ func (cs *CommandService) handlePlan(cmd *types.Command) *types.CommandResponse
 - Run `+"`/preview`"+` to deploy these services
 - Run `+"`/preview <service>`"+` to deploy specific service
 
-*This plan is read-only and safe for everyone to use.*`,
+*Plans run the PR's Terraform, so /plan is limited to deployers.*`,
 		cmd.User, serviceName, cmd.PRNumber, serviceName)
 
 	return &types.CommandResponse{
@@ -164,16 +339,15 @@ func (cs *CommandService) handlePlan(cmd *types.Command) *types.CommandResponse
 		Message: "Deployment plan generated",
 		Content: planContent,
 		Data: map[string]interface{}{
-			"services":    []string{serviceName},
-			"pr_number":   cmd.PRNumber,
-			"safe_to_run": true,
+			"services":  []string{serviceName},
+			"pr_number": cmd.PRNumber,
 		},
 	}
 }
 
-func (cs *CommandService) handlePreview(cmd *types.Command) *types.CommandResponse {
+func (cs *CommandService) handlePreview(ctx context.Context, cmd *types.Command) *types.CommandResponse {
 	// Check permissions for deployment commands
-	if !cs.hasDeploymentPermission(cmd.User) {
+	if !cs.hasDeploymentPermission(ctx, cmd.User) {
 		return &types.CommandResponse{
 			Success: false,
 			Message: "Access denied",
@@ -182,7 +356,6 @@ func (cs *CommandService) handlePreview(cmd *types.Command) *types.CommandRespon
 Sorry, you don't have permission to trigger deployments.
 
 **Available options:**
-- 📋 Use `+"`/plan`"+` to see what would be deployed (read-only)
 - 📊 Use `+"`/status`"+` to check current preview environments
 - 📖 Use `+"`/help`"+` to see all available commands
 
@@ -224,9 +397,9 @@ Contact @abdullahainun for collaboration opportunities.`, cmd.User),
 	}
 }
 
-func (cs *CommandService) handleCleanup(cmd *types.Command) *types.CommandResponse {
+func (cs *CommandService) handleCleanup(ctx context.Context, cmd *types.Command) *types.CommandResponse {
 	// Check permissions
-	if !cs.hasDeploymentPermission(cmd.User) {
+	if !cs.hasDeploymentPermission(ctx, cmd.User) {
 		return &types.CommandResponse{
 			Success: false,
 			Message: "Access denied",
@@ -257,24 +430,54 @@ func (cs *CommandService) handleCleanup(cmd *types.Command) *types.CommandRespon
 	}
 }
 
-func (cs *CommandService) hasDeploymentPermission(user string) bool {
-	// Core team members
-	coreTeam := []string{"abdullahainun"}
+// HandleQueue shows the PR's queued deployments with position and ETA
+func (cs *CommandService) HandleQueue(cmd *types.Command, queue *DeploymentQueue) *types.CommandResponse {
+	positions := queue.PositionsForPR(cmd.Repo, cmd.PRNumber)
+	total := queue.Length()
 
-	for _, member := range coreTeam {
-		if user == member {
-			return true
+	if len(positions) == 0 {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "No queued deployments",
+			Content: fmt.Sprintf("%s\n\n**PR:** #%d\n\n%s\n\n%s\n\n%s",
+				cs.lang.T("queue.title"), cmd.PRNumber, cs.lang.T("queue.none"), cs.lang.T("queue.total", total), cs.lang.T("queue.checked_by", cmd.User)),
+			Data: map[string]interface{}{
+				"pr_number":    cmd.PRNumber,
+				"queued_jobs":  []map[string]interface{}{},
+				"total_queued": total,
+			},
 		}
 	}
 
-	// TODO: Check repository permissions via GitHub API
-	return false
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("%s\n\n**PR:** #%d\n\n%s\n|---|---|---|---|\n", cs.lang.T("queue.title"), cmd.PRNumber, cs.lang.T("queue.table")))
+	for _, job := range positions {
+		content.WriteString(fmt.Sprintf("| %s | @%s | %d of %d | ~%s |\n", job["service"], job["user"], job["position"], total, job["eta"]))
+	}
+	content.WriteString("\n" + cs.lang.T("queue.checked_by", cmd.User))
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Deployment queue",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"pr_number":    cmd.PRNumber,
+			"queued_jobs":  positions,
+			"total_queued": total,
+		},
+	}
+}
+
+// hasDeploymentPermission checks the core team and runtime /grant roster
+func (cs *CommandService) hasDeploymentPermission(ctx context.Context, user string) bool {
+	return cs.team != nil && cs.team.IsDeployer(ctx, user)
 }
 
-func (cs *CommandService) getUserPermissions(user string) map[string]bool {
+func (cs *CommandService) getUserPermissions(ctx context.Context, user string) map[string]bool {
+	deployer := cs.hasDeploymentPermission(ctx, user)
 	return map[string]bool{
 		"can_read":   true,
-		"can_deploy": cs.hasDeploymentPermission(user),
-		"is_core":    cs.hasDeploymentPermission(user),
+		"can_deploy": deployer,
+		"is_core":    deployer,
 	}
 }
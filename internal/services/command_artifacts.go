@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// saveDeploymentArtifacts stores the rendered manifest and deployment metadata
+// under pr-<n>/<deployment-id>/ and returns that prefix. The ID is built from
+// the per-service namespace name even in a shared namespace, so each
+// service's deploys stay apart.
+func (cs *CommandServiceK8s) saveDeploymentArtifacts(ctx context.Context, cmd *types.Command, namespace, cleanServiceName, method, manifestPath string, parsed *ParsedManifest, resources []string) (string, error) {
+	deploymentID := fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), previewNamespace(cmd.PRNumber, cleanServiceName, false))
+	prefix := fmt.Sprintf("pr-%d/%s", cmd.PRNumber, deploymentID)
+
+	if parsed != nil {
+		rendered, err := RenderManifest(parsed)
+		if err != nil {
+			return "", err
+		}
+		if err := cs.artifacts.Save(ctx, prefix+"/manifest.yaml", rendered); err != nil {
+			return "", err
+		}
+	}
+
+	metadata, err := json.MarshalIndent(map[string]interface{}{
+		"repo":          cmd.Repo,
+		"pr_number":     cmd.PRNumber,
+		"service":       cmd.Service,
+		"user":          cmd.User,
+		"namespace":     namespace,
+		"method":        method,
+		"manifest_path": manifestPath,
+		"resources":     resources,
+		"deployed_at":   time.Now().UTC().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := cs.artifacts.Save(ctx, prefix+"/metadata.json", metadata); err != nil {
+		return "", err
+	}
+
+	return prefix, nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// defaultCanaryWeight is the share /canary takes without --weight
+const defaultCanaryWeight = 10
+
+// HandleCanaryK8s sends a share of a service's base environment traffic to
+// its preview through an ingress-nginx canary, or with "off" stops it. The
+// base environment is whatever serves the host CANARY_BASE_HOSTS names.
+func (cs *CommandServiceK8s) HandleCanaryK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	baseHosts, err := parseCanaryBaseHosts(cs.config.Canary.BaseHosts)
+	if err != nil {
+		return failedResponse("Canary routing failed", "Canary Routing Failed", err)
+	}
+	if len(baseHosts) == 0 {
+		return resultResponse(false, "Canary routing is disabled", &types.Result{
+			Status:  types.StatusError,
+			Icon:    "❌",
+			Title:   "Canary Routing Disabled",
+			Summary: "No base environment hosts are configured on this installation (`CANARY_BASE_HOSTS=service=host`).",
+		}, nil)
+	}
+
+	if cmd.Args["off"] == "true" {
+		return cs.disableCanary(ctx, cmd)
+	}
+
+	weight, err := parseCanaryWeight(cmd.Args["weight"], cs.config.Canary.MaxWeight)
+	if err != nil {
+		return failedResponse("Invalid canary arguments", "Invalid Canary Arguments",
+			fmt.Errorf("%v\n\n**Usage:** `/canary <service> --weight=10` or `/canary off`", err))
+	}
+
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	host, ok := baseHosts[cmd.Service]
+	if !ok {
+		host, ok = baseHosts[cleanServiceName]
+	}
+	if !ok {
+		var known []string
+		for service := range baseHosts {
+			known = append(known, "`"+service+"`")
+		}
+		sort.Strings(known)
+		return failedResponse("No base environment", "Canary Routing Failed",
+			fmt.Errorf("%s has no base environment host; canaries are configured for %s", cmd.Service, strings.Join(known, ", ")))
+	}
+
+	namespace := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err == nil && !exists {
+		err = fmt.Errorf("preview %s does not exist; run `/preview %s` first", namespace, cmd.Service)
+	}
+	if err != nil {
+		return failedResponse("Canary routing failed", "Canary Routing Failed", err)
+	}
+
+	backend, err := cs.k8s.PreviewIngressBackend(ctx, namespace, cleanServiceName)
+	if err != nil {
+		return failedResponse("Canary routing failed", "Canary Routing Failed", err)
+	}
+
+	// One canary per service, so a shared namespace can split several hosts
+	name := "preview-canary-" + cleanServiceName
+	if err := cs.k8s.SetCanaryIngress(ctx, namespace, name, host, cs.config.Canary.IngressClass, backend, weight); err != nil {
+		return failedResponse("Canary routing failed", "Canary Routing Failed", err)
+	}
+
+	return resultResponse(true, "Canary routing enabled", &types.Result{
+		Status:  types.StatusSuccess,
+		Icon:    "🐤",
+		Title:   "Canary Routing Enabled",
+		Summary: fmt.Sprintf("**%d%%** of `%s` traffic now goes to the preview of **%s**.", weight, host, cmd.Service),
+		Sections: []types.Section{{
+			Fields: []types.Field{
+				{Name: "👤 Triggered by", Value: "@" + cmd.User},
+				{Name: "🔗 PR", Value: fmt.Sprintf("#%d", cmd.PRNumber)},
+				{Name: "📦 Namespace", Value: fmt.Sprintf("`%s`", namespace)},
+				{Name: "🎯 Backend", Value: fmt.Sprintf("`%s:%d`", backend.Service, backend.Port)},
+			},
+		}},
+		Footer: "*Real users reach this preview. Run `/canary off` to send all traffic back; `/cleanup` removes the canary along with the preview.*",
+	}, map[string]interface{}{
+		"service":   cmd.Service,
+		"namespace": namespace,
+		"host":      host,
+		"weight":    weight,
+		"pr_number": cmd.PRNumber,
+	})
+}
+
+// disableCanary removes the canary of one preview, or of every preview of
+// the PR when no service is given
+func (cs *CommandServiceK8s) disableCanary(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	var namespaces []string
+	if cmd.Service != "" {
+		namespaces = []string{cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, strings.ReplaceAll(cmd.Service, "/", "-"))}
+	} else {
+		previews, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
+		if err != nil {
+			return failedResponse("Canary removal failed", "Canary Removal Failed", err)
+		}
+		namespaces = uniqueNamespaces(previews)
+	}
+
+	var removed []string
+	for _, namespace := range namespaces {
+		canaries, err := cs.k8s.RemoveCanaryIngresses(ctx, namespace)
+		if err != nil {
+			return failedResponse("Canary removal failed", "Canary Removal Failed", err)
+		}
+		for _, canary := range canaries {
+			removed = append(removed, fmt.Sprintf("`%s` (%s%% to `%s`)", canary.Host, canary.Weight, namespace))
+		}
+	}
+
+	if len(removed) == 0 {
+		return resultResponse(true, "Canary routing was not enabled", &types.Result{
+			Status:  types.StatusInfo,
+			Icon:    "ℹ️",
+			Title:   "Canary Routing Not Enabled",
+			Summary: fmt.Sprintf("No preview of PR #%d takes base environment traffic.", cmd.PRNumber),
+			Footer:  fmt.Sprintf("*Triggered by: @%s*", cmd.User),
+		}, nil)
+	}
+
+	return resultResponse(true, "Canary routing disabled", &types.Result{
+		Status:   types.StatusSuccess,
+		Icon:     "🛑",
+		Title:    "Canary Routing Disabled",
+		Summary:  fmt.Sprintf("All traffic goes back to the base environment for PR #%d.", cmd.PRNumber),
+		Sections: []types.Section{{Title: "♻️ Removed", Items: removed}},
+		Footer:   fmt.Sprintf("*Triggered by: @%s*", cmd.User),
+	}, map[string]interface{}{
+		"namespaces": namespaces,
+		"removed":    removed,
+		"pr_number":  cmd.PRNumber,
+	})
+}
+
+// parseCanaryBaseHosts reads CANARY_BASE_HOSTS entries of service=host
+func parseCanaryBaseHosts(entries []string) (map[string]string, error) {
+	hosts := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		service, host, ok := strings.Cut(entry, "=")
+		service, host = strings.TrimSpace(service), strings.ToLower(strings.TrimSpace(host))
+		if !ok || service == "" || !validHostname.MatchString(host) {
+			return nil, fmt.Errorf("invalid canary base host %q (want service=host)", entry)
+		}
+		hosts[service] = host
+	}
+	return hosts, nil
+}
+
+// parseCanaryWeight reads --weight=10 (the % is optional) as a percentage
+// between 1 and max
+func parseCanaryWeight(value string, max int) (int, error) {
+	if value == "" {
+		value = strconv.Itoa(defaultCanaryWeight)
+	}
+	weight, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid weight %q", value)
+	}
+	if weight < 1 || weight > max {
+		return 0, fmt.Errorf("weight must be between 1%% and %d%%", max)
+	}
+	return weight, nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+const (
+	chaosProxyName               = "chaos-proxy"  // toxiproxy sidecar, its volume and ConfigMap
+	chaosConfigName              = "chaos-config" // sidecar that creates the toxics
+	chaosAnnotation              = "pr-previews.io/chaos"
+	chaosOriginalPortsAnnotation = "pr-previews.io/chaos-original-ports"
+
+	// chaosProxyPortOffset is added to an app port to get the proxy's listen port
+	chaosProxyPortOffset = 10000
+)
+
+// ChaosSpec describes the faults /chaos injects
+type ChaosSpec struct {
+	Latency   time.Duration
+	ErrorRate float64 // fraction of connections reset, 0-1
+}
+
+func (s ChaosSpec) String() string {
+	return fmt.Sprintf("latency=%s error-rate=%g%%", s.Latency, s.ErrorRate*100)
+}
+
+// chaosToxicsScript waits for toxiproxy and adds a latency and a reset_peer
+// toxic to every proxy, then idles so the pod stays Running
+func chaosToxicsScript(ports []int32, spec ChaosSpec) string {
+	var script strings.Builder
+	script.WriteString("until curl -sf http://127.0.0.1:8474/version >/dev/null; do sleep 1; done\n")
+	for _, port := range ports {
+		endpoint := fmt.Sprintf("http://127.0.0.1:8474/proxies/port-%d/toxics", port)
+		if spec.Latency > 0 {
+			script.WriteString(fmt.Sprintf("curl -sf -X POST %s -d '{\"name\":\"latency\",\"type\":\"latency\",\"stream\":\"downstream\",\"attributes\":{\"latency\":%d}}'\n",
+				endpoint, spec.Latency.Milliseconds()))
+		}
+		if spec.ErrorRate > 0 {
+			script.WriteString(fmt.Sprintf("curl -sf -X POST %s -d '{\"name\":\"errors\",\"type\":\"reset_peer\",\"stream\":\"downstream\",\"toxicity\":%g,\"attributes\":{\"timeout\":0}}'\n",
+				endpoint, spec.ErrorRate))
+		}
+	}
+	script.WriteString("exec tail -f /dev/null\n")
+	return script.String()
+}
+
+// HandleChaosK8s routes a preview's traffic through toxiproxy to simulate a
+// slow or flaky service, or with "off" puts the original wiring back
+func (cs *CommandServiceK8s) HandleChaosK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	if !cs.config.Chaos.Enabled {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Chaos mode is disabled",
+			Content: "## ❌ Chaos Mode Disabled\n\nChaos mode is not enabled on this installation (`CHAOS_ENABLED=true`).",
+		}
+	}
+
+	if cmd.Args["off"] == "true" {
+		return cs.disableChaos(ctx, cmd)
+	}
+
+	spec, err := parseChaosArgs(cmd.Args, cs.config.Chaos.MaxLatency)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Invalid chaos arguments",
+			Content: fmt.Sprintf("## ❌ Invalid Chaos Arguments\n\n**Error:** %s\n\n**Usage:** `/chaos <service> --latency=200ms --error-rate=5%%` or `/chaos off`", err.Error()),
+		}
+	}
+
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	namespaceName := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespaceName)
+	if err != nil {
+		return failedResponse("Chaos injection failed", "Chaos Injection Failed", err)
+	}
+	if !exists {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Preview not found",
+			Content: fmt.Sprintf("## ❌ Preview Not Found\n\n**Namespace:** `%s`\n\n*Run `/preview %s` first.*", namespaceName, cmd.Service),
+		}
+	}
+
+	proxied, err := cs.k8s.InjectChaosProxy(ctx, namespaceName, spec, cs.config.Chaos.ProxyImage, cs.config.Chaos.ClientImage)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Chaos injection failed",
+			Content: fmt.Sprintf("## ❌ Chaos Injection Failed\n\n**Error:** %s\n\n**Namespace:** `%s`", err.Error(), namespaceName),
+		}
+	}
+	if len(proxied) == 0 {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Nothing to proxy",
+			Content: fmt.Sprintf("## ❌ Nothing to Proxy\n\nNo Service in `%s` routes to a Deployment port, so there is nothing to put the proxy in front of.", namespaceName),
+		}
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Chaos mode enabled",
+		Content: fmt.Sprintf("## 🌪️ Chaos Mode Enabled\n\n**👤 Triggered by:** @%s\n**🎯 Service:** %s\n**🔗 PR:** #%d\n**📦 Namespace:** `%s`\n\n### ⚙️ Faults\n- **Latency:** %s\n- **Connection resets:** %g%%\n\n### 🔌 Proxied Ports\n%s\n*Pods restart with a toxiproxy sidecar. Run `/chaos off` to restore normal traffic; `/cleanup` removes it along with the preview.*",
+			cmd.User, cmd.Service, cmd.PRNumber, namespaceName, spec.Latency, spec.ErrorRate*100, cs.formatResourcesList(proxied)),
+		Data: map[string]interface{}{
+			"service":    cmd.Service,
+			"namespace":  namespaceName,
+			"latency":    spec.Latency.String(),
+			"error_rate": spec.ErrorRate,
+			"proxied":    proxied,
+			"pr_number":  cmd.PRNumber,
+		},
+	}
+}
+
+// disableChaos removes the proxy from one preview, or every preview of the
+// PR when no service is given
+func (cs *CommandServiceK8s) disableChaos(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	var namespaces []string
+	if cmd.Service != "" {
+		namespaces = []string{cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, strings.ReplaceAll(cmd.Service, "/", "-"))}
+	} else {
+		previews, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
+		if err != nil {
+			return failedResponse("Chaos removal failed", "Chaos Removal Failed", err)
+		}
+		namespaces = uniqueNamespaces(previews)
+	}
+
+	var restored []string
+	for _, namespace := range namespaces {
+		resources, err := cs.k8s.RemoveChaosProxy(ctx, namespace)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Chaos removal failed",
+				Content: fmt.Sprintf("## ❌ Chaos Removal Failed\n\n**Error:** %s\n\n**Namespace:** `%s`", err.Error(), namespace),
+			}
+		}
+		for _, resource := range resources {
+			restored = append(restored, fmt.Sprintf("%s (`%s`)", resource, namespace))
+		}
+	}
+
+	if len(restored) == 0 {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "Chaos mode was not enabled",
+			Content: fmt.Sprintf("## ℹ️ Chaos Mode Not Enabled\n\nNo preview of PR #%d has a chaos proxy.\n\n*Triggered by: @%s*", cmd.PRNumber, cmd.User),
+		}
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Chaos mode disabled",
+		Content: fmt.Sprintf("## 🧯 Chaos Mode Disabled\n\n**👤 Triggered by:** @%s\n**🔗 PR:** #%d\n\n### ♻️ Restored\n%s",
+			cmd.User, cmd.PRNumber, cs.formatResourcesList(restored)),
+		Data: map[string]interface{}{
+			"namespaces": namespaces,
+			"restored":   restored,
+			"pr_number":  cmd.PRNumber,
+		},
+	}
+}
+
+// parseChaosArgs reads --latency=200ms and --error-rate=5% (the % is optional)
+func parseChaosArgs(args map[string]string, maxLatency time.Duration) (ChaosSpec, error) {
+	var spec ChaosSpec
+
+	if value, ok := args["latency"]; ok {
+		latency, err := time.ParseDuration(value)
+		if err != nil {
+			return spec, fmt.Errorf("invalid latency %q: %v", value, err)
+		}
+		if latency < 0 || latency > maxLatency {
+			return spec, fmt.Errorf("latency must be between 0 and %s", maxLatency)
+		}
+		spec.Latency = latency
+	}
+
+	if value, ok := args["error-rate"]; ok {
+		rate, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return spec, fmt.Errorf("invalid error rate %q", value)
+		}
+		if rate < 0 || rate > 100 {
+			return spec, fmt.Errorf("error rate must be between 0%% and 100%%")
+		}
+		spec.ErrorRate = rate / 100
+	}
+
+	if spec.Latency == 0 && spec.ErrorRate == 0 {
+		return spec, fmt.Errorf("set --latency and/or --error-rate")
+	}
+	return spec, nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// maskedConfigValue stands in for secret values /config shows the keys of
+const maskedConfigValue = "••••••"
+
+// configLayer is one source of preview settings. Layers apply in order, each
+// over the ones before it.
+type configLayer struct {
+	Name    string                 `json:"name"`
+	Source  string                 `json:"source"`
+	Found   bool                   `json:"found"`
+	Problem string                 `json:"problem,omitempty"` // why the layer couldn't be read
+	Values  map[string]interface{} `json:"-"`
+}
+
+// configSetting is one effective setting and the layer it came from
+type configSetting struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+	Layer string      `json:"layer"`
+}
+
+// configLayers reads every layer of preview settings for the command: the
+// server's defaults, the org-wide config, the repo's .pr-previews.yaml, the
+// PR description's block and the command's own flags
+func (cs *CommandServiceK8s) configLayers(ctx context.Context, cmd *types.Command, repoPath string, prSettings *PRSettings, prErr error) []configLayer {
+	layers := []configLayer{{
+		Name:   "server",
+		Source: "server defaults",
+		Found:  true,
+		Values: map[string]interface{}{
+			"namespace": NamespacePerService,
+			"isolation": IsolationNamespace,
+			"ttl":       formatAge(cs.config.Preview.GCMaxAge),
+		},
+	}}
+
+	org := configLayer{Name: "org", Source: "not configured (`GITHUB_ORG_CONFIG_REPO`)"}
+	if source, ok := cs.orgConfigRepo(cmd.Repo); ok {
+		org.Source = fmt.Sprintf("`%s/%s`", source, cs.config.GitHub.OrgConfigPath)
+		content, err := cs.orgConfigContent(ctx, cmd.Repo)
+		switch {
+		case err != nil:
+			org.Problem = err.Error()
+		case content != nil:
+			org.Values, err = configValues(content)
+			org.Found = err == nil
+			if err != nil {
+				org.Problem = err.Error()
+			}
+		}
+	}
+	layers = append(layers, org)
+
+	repo := configLayer{Name: "repo", Source: fmt.Sprintf("`%s`", repoConfigFile)}
+	content, err := readRepoConfigFile(repoPath, cs.decryptor)
+	switch {
+	case err != nil:
+		repo.Problem = err.Error()
+	case content != nil:
+		repo.Values, err = configValues(content)
+		repo.Found = err == nil
+		if err != nil {
+			repo.Problem = err.Error()
+		}
+	}
+	layers = append(layers, repo)
+
+	pr := configLayer{Name: "pr", Source: "`pr-previews` block in the PR description"}
+	switch {
+	case prErr != nil:
+		pr.Problem = prErr.Error()
+	case prSettings != nil:
+		pr.Found = true
+		pr.Values = map[string]interface{}{}
+		if prSettings.TTL != "" {
+			pr.Values["ttl"] = prSettings.TTL
+		}
+		if len(prSettings.Services) > 0 {
+			pr.Values["services"] = prSettings.Services
+		}
+		if len(prSettings.Env) > 0 {
+			env := map[string]interface{}{}
+			for name, value := range prSettings.Env {
+				env[name] = value
+			}
+			pr.Values["env"] = env
+		}
+	}
+	layers = append(layers, pr)
+
+	flags := configLayer{Name: "flags", Source: "flags of this command", Values: map[string]interface{}{}}
+	for _, name := range []string{"class", "ttl"} {
+		if value := cmd.Args[name]; value != "" {
+			flags.Values[name] = value
+			flags.Found = true
+		}
+	}
+	return append(layers, flags)
+}
+
+// effectiveSettings flattens the layers into dotted keys, each with the value
+// of the last layer that set it. Secret values are masked.
+func effectiveSettings(layers []configLayer) []configSetting {
+	settings := map[string]configSetting{}
+	for _, layer := range layers {
+		flat := map[string]interface{}{}
+		flattenConfigValues("", layer.Values, flat)
+		for key, value := range flat {
+			if strings.HasPrefix(key, "secrets.") {
+				value = maskedConfigValue
+			}
+			settings[key] = configSetting{Key: key, Value: value, Layer: layer.Name}
+		}
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	effective := make([]configSetting, 0, len(keys))
+	for _, key := range keys {
+		effective = append(effective, settings[key])
+	}
+	return effective
+}
+
+// flattenConfigValues walks mappings into dotted keys, the same way layers
+// merge; lists and scalars are leaves
+func flattenConfigValues(prefix string, values map[string]interface{}, out map[string]interface{}) {
+	for key, value := range values {
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenConfigValues(prefix+key+".", nested, out)
+			continue
+		}
+		out[prefix+key] = value
+	}
+}
+
+// formatConfigValue renders a setting on one line of the /config table
+func formatConfigValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "*(unset)*"
+	case string:
+		return SanitizeEcho(v)
+	case []interface{}, []string, map[string]interface{}:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return SanitizeEcho(fmt.Sprintf("%v", v))
+		}
+		return SanitizeEcho(string(encoded))
+	default:
+		return SanitizeEcho(fmt.Sprintf("%v", v))
+	}
+}
+
+// HandleConfigK8s shows the preview settings in effect for the PR and which
+// layer each one comes from, to debug why a preview was deployed the way it
+// was. With a service, it also resolves what a deploy of it would get.
+func (cs *CommandServiceK8s) HandleConfigK8s(ctx context.Context, cmd *types.Command, repoPath string) *types.CommandResponse {
+	prSettings, prErr := cs.LoadPRSettings(ctx, cmd)
+	layers := cs.configLayers(ctx, cmd, repoPath, prSettings, prErr)
+	effective := effectiveSettings(layers)
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## ⚙️ Effective Preview Config\n\n**🔗 PR:** #%d\n\n### Layers\n\nEach layer overrides the ones above it; mappings such as `labels` merge key by key, lists and values are replaced whole.\n\n", cmd.PRNumber))
+	for i, layer := range layers {
+		status := "➖ none"
+		if layer.Found {
+			status = "✅"
+		} else if layer.Problem != "" {
+			status = "⚠️ " + SanitizeEcho(layer.Problem)
+		}
+		content.WriteString(fmt.Sprintf("%d. **%s** — %s %s\n", i+1, layer.Name, layer.Source, status))
+	}
+
+	content.WriteString("\n### Settings\n\n")
+	if len(effective) == 0 {
+		content.WriteString("*Nothing is set.*\n")
+	} else {
+		content.WriteString("| Setting | Value | From |\n|---------|-------|------|\n")
+		for _, setting := range effective {
+			content.WriteString(fmt.Sprintf("| %s | %s | %s |\n", SanitizeEcho(setting.Key), formatConfigValue(setting.Value), setting.Layer))
+		}
+	}
+
+	// The layers must also be valid together, the way a deploy reads them
+	repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath)
+	if err != nil {
+		content.WriteString(fmt.Sprintf("\n### ⚠️ Invalid Config\n\n**Error:** %s\n\n*Deploys fail until this is fixed.*\n", SanitizeEcho(err.Error())))
+	} else if cmd.Service != "" {
+		content.WriteString(fmt.Sprintf("\n### 🎯 %s\n\n", cmd.Service))
+		className := "none (the manifest's own resources)"
+		if class, err := resolveServiceClass(cmd.Args["class"], cmd.Service, repoConfig); err != nil {
+			className = "⚠️ " + SanitizeEcho(err.Error())
+		} else if class != nil {
+			className = class.Name
+		}
+		ttlValue := fmt.Sprintf("%s (PREVIEW_GC_MAX_AGE)", formatAge(cs.config.Preview.GCMaxAge))
+		if ttl, source, err := resolvePreviewTTL(cmd.Args["ttl"], prSettings, nil, repoConfig, cs.config.Preview.MaxTTL); err != nil {
+			ttlValue = "⚠️ " + SanitizeEcho(err.Error())
+		} else if ttl > 0 {
+			ttlValue = fmt.Sprintf("%s (from %s)", formatAge(ttl), source)
+		}
+		content.WriteString(fmt.Sprintf("**Class:** %s\n**TTL:** %s\n**Namespace:** `%s`\n\n*A `pr-previews.io/ttl` annotation in the service's manifest outranks the repo config's ttl.*\n",
+			className, ttlValue, previewNamespace(cmd.PRNumber, strings.ReplaceAll(cmd.Service, "/", "-"), repoConfig.SharedNamespace())))
+	}
+	content.WriteString(fmt.Sprintf("\n*Requested by: @%s*", cmd.User))
+
+	return &types.CommandResponse{
+		Success: err == nil,
+		Message: "Effective preview config",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"pr_number": cmd.PRNumber,
+			"layers":    layers,
+			"settings":  effective,
+		},
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// inspectEventLimit is how many recent namespace events /inspect shows
+const inspectEventLimit = 15
+
+// HandleInspectK8s is /inspect <service>: kubectl describe distilled into
+// folded sections for reviewers without cluster access. It is read-only and
+// leaves out environment variables and secrets.
+func (cs *CommandServiceK8s) HandleInspectK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	namespace := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err == nil && !exists {
+		err = fmt.Errorf("preview %s does not exist; run `/preview %s` first", namespace, cmd.Service)
+	}
+	if err != nil {
+		return failedResponse("Inspect failed", "Inspect Failed", err)
+	}
+
+	workloads, err := cs.k8s.DescribeWorkloads(ctx, namespace)
+	if err != nil {
+		return failedResponse("Inspect failed", "Inspect Failed", err)
+	}
+	workloads = serviceWorkloads(workloads, cleanServiceName)
+
+	result := &types.Result{
+		Status:  types.StatusInfo,
+		Icon:    "🔍",
+		Title:   fmt.Sprintf("Inspect: %s", cmd.Service),
+		Summary: fmt.Sprintf("**📦 Namespace:** `%s` · %d workload(s)", namespace, len(workloads)),
+		Footer:  fmt.Sprintf("*Inspected by: @%s*", cmd.User),
+	}
+
+	var notReady int
+	for _, workload := range workloads {
+		result.Sections = append(result.Sections, workloadSections(workload)...)
+		if workload.Ready < workload.Desired {
+			notReady++
+		}
+	}
+	if len(workloads) == 0 {
+		result.Sections = append(result.Sections, types.Section{Text: "No Deployments or StatefulSets found in this preview."})
+	}
+
+	// Events and endpoints are best effort; a describe with part of the
+	// picture still helps
+	if events, err := cs.k8s.ListEvents(ctx, namespace, inspectEventLimit); err != nil {
+		result.Sections = append(result.Sections, types.Section{Title: "Events", Text: fmt.Sprintf("⚠️ %s", err.Error())})
+	} else {
+		result.Sections = append(result.Sections, eventsSection(events))
+	}
+	if endpoints, err := cs.k8s.DescribeEndpoints(ctx, namespace); err != nil {
+		result.Sections = append(result.Sections, types.Section{Title: "Endpoints", Text: fmt.Sprintf("⚠️ %s", err.Error())})
+	} else {
+		result.Sections = append(result.Sections, endpointsSection(endpoints))
+	}
+
+	if notReady > 0 {
+		result.Status = types.StatusWarning
+	}
+	return resultResponse(true, "Preview inspected", result, map[string]interface{}{
+		"namespace": namespace,
+		"workloads": workloads,
+	})
+}
+
+// serviceWorkloads keeps the workloads named after the service, which only
+// matters in a shared namespace; otherwise everything belongs to it
+func serviceWorkloads(workloads []WorkloadDescription, cleanServiceName string) []WorkloadDescription {
+	base := cleanServiceName[strings.LastIndex(cleanServiceName, "-")+1:]
+	var matched []WorkloadDescription
+	for _, workload := range workloads {
+		if workload.Name == cleanServiceName || workload.Name == base || strings.HasPrefix(workload.Name, cleanServiceName+"-") {
+			matched = append(matched, workload)
+		}
+	}
+	if len(matched) == 0 {
+		return workloads
+	}
+	return matched
+}
+
+// workloadSections are the spec summary of a workload and its pods, each
+// folded; a section holds one table
+func workloadSections(workload WorkloadDescription) []types.Section {
+	icon := "🟢"
+	if workload.Ready < workload.Desired {
+		icon = "🟡"
+	}
+
+	spec := types.Section{
+		Title:     fmt.Sprintf("%s %s %s", icon, workload.Kind, workload.Name),
+		Collapsed: true,
+		Fields: []types.Field{
+			{Name: "Replicas", Value: fmt.Sprintf("%d desired · %d ready · %d up-to-date · %d available", workload.Desired, workload.Ready, workload.Updated, workload.Available)},
+			{Name: "Strategy", Value: orDash(workload.Strategy)},
+			{Name: "Selector", Value: fmt.Sprintf("`%s`", orDash(workload.Selector))},
+			{Name: "Conditions", Value: orDash(strings.Join(workload.Conditions, " · "))},
+		},
+		Table: &types.Table{Columns: []string{"Container", "Image", "Ports", "Requests", "Limits", "Probes"}},
+	}
+	for _, container := range workload.Containers {
+		spec.Table.Rows = append(spec.Table.Rows, []string{container.Name, fmt.Sprintf("`%s`", container.Image), orDash(container.Ports), orDash(container.Requests), orDash(container.Limits), orDash(container.Probes)})
+	}
+
+	pods := types.Section{Title: fmt.Sprintf("Pods of %s (%d)", workload.Name, len(workload.Pods)), Collapsed: true}
+	if len(workload.Pods) == 0 {
+		pods.Text = "No pods match the selector."
+	} else {
+		pods.Table = podTable(workload.Pods)
+	}
+	return []types.Section{spec, pods}
+}
+
+func podTable(pods []PodDescription) *types.Table {
+	table := &types.Table{Columns: []string{"Pod", "Phase", "Ready", "Restarts", "Node", "Problems"}}
+	for _, pod := range pods {
+		problems := append(append([]string{}, pod.Waiting...), pod.Conditions...)
+		table.Rows = append(table.Rows, []string{pod.Name, pod.Phase, pod.Ready, fmt.Sprint(pod.Restarts), orDash(pod.Node), orDash(strings.Join(problems, "; "))})
+	}
+	return table
+}
+
+func eventsSection(events []map[string]interface{}) types.Section {
+	section := types.Section{Title: fmt.Sprintf("Recent Events (%d)", len(events)), Collapsed: true}
+	if len(events) == 0 {
+		section.Text = "No recent events."
+		return section
+	}
+
+	section.Table = &types.Table{Columns: []string{"Last Seen", "Type", "Reason", "Object", "Message"}}
+	for _, event := range events {
+		message := strings.Join(strings.Fields(fmt.Sprint(event["message"])), " ")
+		if runes := []rune(message); len(runes) > 160 {
+			message = string(runes[:159]) + "…"
+		}
+		section.Table.Rows = append(section.Table.Rows, []string{
+			fmt.Sprint(event["last_seen"]), fmt.Sprint(event["type"]), fmt.Sprint(event["reason"]), fmt.Sprint(event["object"]), message,
+		})
+	}
+	return section
+}
+
+func endpointsSection(endpoints []EndpointDescription) types.Section {
+	section := types.Section{Title: "Endpoints", Collapsed: true}
+	if len(endpoints) == 0 {
+		section.Text = "No Service endpoints."
+		return section
+	}
+
+	section.Table = &types.Table{Columns: []string{"Service", "Ready", "Not Ready"}}
+	for _, endpoint := range endpoints {
+		section.Table.Rows = append(section.Table.Rows, []string{endpoint.Service, orDash(strings.Join(endpoint.Ready, ", ")), orDash(strings.Join(endpoint.NotReady, ", "))})
+	}
+	return section
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "—"
+	}
+	return value
+}
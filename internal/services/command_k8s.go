@@ -2,30 +2,124 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"pr-previews/internal/config"
 	"pr-previews/internal/types"
 )
 
 // Enhanced CommandService with K8s integration
 type CommandServiceK8s struct {
-	k8s *K8sService
+	config    *config.Config
+	k8s       *K8sService
+	mutator   *ManifestMutator
+	security  *PodSecurityMutator
+	images    *ImagePolicy
+	policy    *PolicyEngine
+	decryptor *SopsDecryptor
+	metrics   *MonitoringLinks
+	vault     *VaultClient
+	lang      *LanguagePack
+	terraform *TerraformDeployer
+	helm      *HelmRenderer
+	vcluster  *VClusterProvisioner
+	operators *OperatorBundles
+	tenants   *TenantLimits
+	github    *GitHubClient
+	comments  PullRequestCommenter // where follow-ups go; github unless WithCommenter
+	artifacts ArtifactStore
+	timeline  *PreviewTimeline
 }
 
-func NewCommandServiceK8s() (*CommandServiceK8s, error) {
+func NewCommandServiceK8s(cfg *config.Config) (*CommandServiceK8s, error) {
 	k8sService, err := NewK8sService()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create K8s service: %v", err)
 	}
 
+	// Same fallback as handlers.New, which reports the error at startup
+	lang, err := LoadLanguagePack(cfg.Locale.Language, cfg.Locale.Dir)
+	if err != nil {
+		lang = DefaultLanguagePack()
+	}
+
+	// handlers.New reports a bad backend at startup and disables the
+	// artifact API; here only the steps that store artifacts fail
+	var timelineStore ArtifactStore
+	artifacts, err := NewArtifactStore(cfg)
+	if err != nil {
+		artifacts = unavailableArtifactStore{err: err}
+	} else {
+		timelineStore = artifacts
+	}
+
+	if err := validateImageGC(cfg.ImageGC.Methods, cfg.ImageGC.TagPattern); err != nil {
+		return nil, err
+	}
+
+	ipFamilies, err := NewIPFamilyConfig(cfg.Network.IPFamilyPolicy, cfg.Network.IPFamilies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service IP families: %v", err)
+	}
+	k8sService.SetIPFamilies(ipFamilies)
+
+	baseline, err := NewNamespaceBaseline(cfg.Namespaces.QuotaCPU, cfg.Namespaces.QuotaMemory, cfg.Namespaces.Isolation, cfg.Namespaces.PullSecret, cfg.Namespaces.Labels, cfg.Namespaces.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace baseline: %v", err)
+	}
+	k8sService.SetNamespaceBaseline(baseline)
+
+	tenants, err := NewTenantLimits(cfg.Tenants.NamespacePrefixes, cfg.Tenants.QuotaCPU, cfg.Tenants.QuotaMemory, cfg.Tenants.Quotas)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenant limits: %v", err)
+	}
+
+	timeline := NewPreviewTimeline(timelineStore, cfg.Timeline.Retention, cfg.Timeline.MaxEntries)
+	github := NewGitHubClient(cfg.GitHub.Token, cfg.GitHub.CacheTTL).WithTimeline(timeline)
+
 	return &CommandServiceK8s{
-		k8s: k8sService,
+		config:    cfg,
+		k8s:       k8sService,
+		mutator:   NewManifestMutator(cfg.Preview.MaxReplicas, cfg.Preview.ScalingOptOut),
+		security:  NewPodSecurityMutator(cfg.PodSecurity.Level),
+		images:    SharedImagePolicy(),
+		policy:    SharedPolicyEngine(),
+		decryptor: NewSopsDecryptor(cfg.Secrets.SopsBinary, cfg.Secrets.AgeKeyFile, cfg.Secrets.KMSKeyARN),
+		metrics:   NewMonitoringLinks(cfg.Monitoring.GrafanaURLTemplate, cfg.Monitoring.PrometheusURLTemplate),
+		vault:     NewVaultClient(cfg.Vault.Address, cfg.Vault.Token),
+		lang:      lang,
+		terraform: NewTerraformDeployer(cfg.Terraform.Binary, cfg.Terraform.ModuleDir, cfg.Preview.Domain),
+		helm:      NewHelmRenderer(cfg.Helm.Binary, cfg.Helm.Credentials, cfg.Helm.Timeout),
+		vcluster:  NewVClusterProvisioner(cfg.VCluster.Binary, cfg.VCluster.ChartVersion, cfg.VCluster.Timeout),
+		operators: SharedOperatorBundles(),
+		tenants:   tenants,
+		github:    github,
+		comments:  github,
+		artifacts: artifacts,
+		timeline:  timeline,
 	}, nil
 }
 
+// WithCommenter sends the comments commands post after replying, such as
+// cleanup and readiness reports, to another provider's pull request
+func (cs *CommandServiceK8s) WithCommenter(comments PullRequestCommenter) *CommandServiceK8s {
+	cs.comments = comments
+	return cs
+}
+
+// Commenter is where this service posts on the pull request
+func (cs *CommandServiceK8s) Commenter() PullRequestCommenter {
+	return cs.comments
+}
+
 // TestK8sConnection tests Kubernetes connectivity
 func (cs *CommandServiceK8s) TestK8sConnection(ctx context.Context) *types.CommandResponse {
 	err := cs.k8s.TestConnection(ctx)
@@ -56,81 +150,117 @@ func (cs *CommandServiceK8s) TestK8sConnection(ctx context.Context) *types.Comma
 	}
 }
 
+// statusPreview is a preview in the /status data, with what the command
+// looked up about it
+type statusPreview struct {
+	PreviewNamespace
+	Remaining        string                   `json:"remaining,omitempty"`
+	Access           *PreviewAccess           `json:"access,omitempty"`
+	DeploymentStatus *DeploymentStatus        `json:"deployment_status,omitempty"`
+	StatefulSets     []map[string]interface{} `json:"statefulsets,omitempty"`
+}
+
 // Enhanced status command with real K8s data including deployments
 func (cs *CommandServiceK8s) HandleStatusK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	switch format := cmd.Args["format"]; format {
+	case "", "markdown":
+	case "json":
+		return cs.handleStatusJSON(ctx, cmd)
+	default:
+		return failedResponse("Invalid status format", "Invalid Status Format",
+			fmt.Errorf("unknown format %s\n\n**Usage:** `/status [--format=json]`", SanitizeEcho(format)))
+	}
+
 	// Get preview namespaces for this PR
-	previewNamespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.PRNumber)
+	previewNamespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
 	if err != nil {
-		return &types.CommandResponse{
-			Success: false,
-			Message: "Failed to get preview status",
-			Content: fmt.Sprintf("❌ Error getting preview environments: %s", err.Error()),
-		}
+		return failedResponse("Failed to get preview status", "Preview Status Failed", err)
 	}
 
-	if len(previewNamespaces) == 0 {
-		return &types.CommandResponse{
-			Success: true,
-			Message: "No preview environments found",
-			Content: fmt.Sprintf("## 📊 Preview Environment Status\n\n**PR:** #%d\n\n### ℹ️ No Preview Environments Found\n\nNo preview environments are currently active for this PR.\n\n**To create preview environments:**\n- Run `/preview` to deploy all changed services\n- Run `/preview <service>` to deploy a specific service\n\n*Status checked by: @%s*", cmd.PRNumber, cmd.User),
-			Data: map[string]interface{}{
-				"pr_number":       cmd.PRNumber,
-				"active_previews": []string{},
-				"total_previews":  0,
-			},
-		}
+	// The localized title is a markdown heading; results carry plain titles
+	result := &types.Result{
+		Status:  types.StatusInfo,
+		Title:   strings.TrimLeft(cs.lang.T("status.title"), "# "),
+		Summary: fmt.Sprintf("**PR:** #%d", cmd.PRNumber),
+		Footer:  cs.lang.T("status.checked_by", cmd.User),
 	}
 
-	// Build status content with real deployment info
-	var contentBuilder strings.Builder
-	contentBuilder.WriteString(fmt.Sprintf("## 📊 Preview Environment Status\n\n**PR:** #%d\n\n### 🟢 Active Preview Environments\n\n", cmd.PRNumber))
+	if len(previewNamespaces) == 0 {
+		result.Sections = []types.Section{{Text: cs.lang.T("status.none") + "\n\n" + cs.lang.T("status.create_hint")}}
+		return resultResponse(true, "No preview environments found", result, map[string]interface{}{
+			"pr_number":       cmd.PRNumber,
+			"active_previews": []string{},
+			"total_previews":  0,
+		})
+	}
 
-	var enrichedPreviews []map[string]interface{}
+	var activePreviews []statusPreview
 
 	for _, ns := range previewNamespaces {
-		namespaceName := ns["name"].(string)
-		serviceName := ns["service"].(string)
-
-		contentBuilder.WriteString(fmt.Sprintf("#### %s\n- **Namespace:** `%s`\n- **Service:** %s\n- **Created:** %s\n\n", serviceName, namespaceName, serviceName, ns["created_at"]))
+		namespaceName := ns.Name
+		serviceName := ns.Service
+		preview := statusPreview{PreviewNamespace: ns}
+
+		section := types.Section{
+			Title: fmt.Sprintf("🟢 %s", serviceName),
+			Fields: []types.Field{
+				{Name: "Namespace", Value: fmt.Sprintf("`%s`", namespaceName)},
+				{Name: "Service", Value: serviceName},
+				{Name: "Created", Value: ns.CreatedAt.Format(time.RFC3339)},
+			},
+		}
+		if expiresAt, ok := cs.previewExpiry(ns); ok {
+			section.Fields = append(section.Fields, types.Field{Name: "Expires", Value: fmt.Sprintf("%s (%s)", expiresAt.UTC().Format("2006-01-02 15:04 UTC"), previewRemaining(expiresAt))})
+			preview.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+			preview.Remaining = previewRemaining(expiresAt)
+		}
+		if access, ok := cs.previewAccess(ctx, ns); ok {
+			section.Fields = append(section.Fields, types.Field{Name: "Visits", Value: formatPreviewAccess(access)})
+			preview.Access = &access
+		}
+		var details strings.Builder
 
 		// Get deployment status if exists
 		deploymentStatus, err := cs.k8s.GetDeploymentStatus(ctx, namespaceName, serviceName)
 		if err == nil {
-			contentBuilder.WriteString(fmt.Sprintf("- **Deployment Status:** %d/%d pods ready\n- **Pods:** %d total\n", deploymentStatus["ready_replicas"], deploymentStatus["replicas"], len(deploymentStatus["pods"].([]map[string]interface{}))))
-
-			// Add deployment info to preview data
-			enrichedPreview := make(map[string]interface{})
-			for k, v := range ns {
-				enrichedPreview[k] = v
-			}
-			enrichedPreview["deployment_status"] = deploymentStatus
-			enrichedPreviews = append(enrichedPreviews, enrichedPreview)
+			section.Fields = append(section.Fields,
+				types.Field{Name: "Deployment Status", Value: fmt.Sprintf("%d/%d pods ready", deploymentStatus.ReadyReplicas, deploymentStatus.Replicas)},
+				types.Field{Name: "Pods", Value: fmt.Sprintf("%d total", len(deploymentStatus.Pods))})
+			preview.DeploymentStatus = deploymentStatus
 		} else {
-			contentBuilder.WriteString("- **Deployment Status:** No deployment found\n")
-			enrichedPreviews = append(enrichedPreviews, ns)
+			section.Fields = append(section.Fields, types.Field{Name: "Deployment Status", Value: "No deployment found"})
+		}
+
+		// StatefulSets report each ordinal, since they start in order
+		statefulSetStatuses, err := cs.k8s.GetStatefulSetStatuses(ctx, namespaceName)
+		if err == nil && len(statefulSetStatuses) > 0 {
+			details.WriteString(formatStatefulSetStatuses(statefulSetStatuses))
+			preview.StatefulSets = statefulSetStatuses
 		}
 
 		// Get service info if exists
 		serviceInfo, err := cs.k8s.GetServiceInfo(ctx, namespaceName, serviceName)
 		if err == nil {
-			contentBuilder.WriteString(fmt.Sprintf("- **Service IP:** %s\n- **Service Ports:** %v\n\n", serviceInfo["cluster_ip"], serviceInfo["ports"]))
+			section.Fields = append(section.Fields, clusterIPFields(serviceInfo.ClusterIPs)...)
+			section.Fields = append(section.Fields, types.Field{Name: "Service Ports", Value: fmt.Sprint(serviceInfo.Ports)})
 		} else {
-			contentBuilder.WriteString("- **Service:** Not found\n\n")
+			section.Fields = append(section.Fields, types.Field{Name: "Service", Value: "Not found"})
 		}
-	}
-
-	contentBuilder.WriteString(fmt.Sprintf("*Status checked by: @%s*", cmd.User))
 
-	return &types.CommandResponse{
-		Success: true,
-		Message: "Preview environment status",
-		Content: contentBuilder.String(),
-		Data: map[string]interface{}{
-			"pr_number":       cmd.PRNumber,
-			"active_previews": enrichedPreviews,
-			"total_previews":  len(previewNamespaces),
-		},
+		// Link to preview metrics if monitoring is configured
+		details.WriteString(cs.metrics.Markdown(namespaceName, serviceName, cmd.PRNumber))
+		section.Text = details.String()
+		result.Sections = append(result.Sections, section)
+		activePreviews = append(activePreviews, preview)
 	}
+
+	result.Status = types.StatusSuccess
+	return resultResponse(true, "Preview environment status", result, map[string]interface{}{
+		"pr_number":       cmd.PRNumber,
+		"active_previews": activePreviews,
+		"total_previews":  len(previewNamespaces),
+		"monitoring":      cs.metrics.Enabled(),
+	})
 }
 
 // Enhanced preview command with real K8s deployment including pods
@@ -147,7 +277,7 @@ func (cs *CommandServiceK8s) HandlePreviewK8s(ctx context.Context, cmd *types.Co
 	namespaceName := fmt.Sprintf("preview-pr-%d-%s", cmd.PRNumber, cleanServiceName)
 
 	// Step 1: Create namespace
-	err := cs.k8s.CreateNamespace(ctx, namespaceName, cmd.PRNumber, serviceName)
+	err := cs.k8s.CreateNamespace(ctx, namespaceName, cmd.PRNumber, serviceName, cmd.User)
 	if err != nil {
 		return &types.CommandResponse{
 			Success: false,
@@ -155,9 +285,10 @@ func (cs *CommandServiceK8s) HandlePreviewK8s(ctx context.Context, cmd *types.Co
 			Content: fmt.Sprintf("## ❌ Preview Deployment Failed\n\n**Error:** %s\n\n**Service:** %s\n**Namespace:** %s\n\n*Please check cluster permissions and try again.*", err.Error(), serviceName, namespaceName),
 		}
 	}
+	cs.recordPreviewRepo(ctx, namespaceName, cmd.Repo)
 
 	// Step 2: Deploy pod
-	err = cs.k8s.DeployTestPod(ctx, namespaceName, cleanServiceName)
+	err = cs.k8s.DeployTestPod(ctx, namespaceName, cleanServiceName, nil)
 	if err != nil {
 		return &types.CommandResponse{
 			Success: false,
@@ -198,9 +329,9 @@ func (cs *CommandServiceK8s) HandlePreviewK8s(ctx context.Context, cmd *types.Co
 }
 
 // Enhanced cleanup command with real K8s cleanup
-func (cs *CommandServiceK8s) HandleCleanupK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+func (cs *CommandServiceK8s) HandleCleanupK8s(ctx context.Context, cmd *types.Command, repoPath string) *types.CommandResponse {
 	// Get existing namespaces first
-	previewNamespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.PRNumber)
+	previewNamespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
 	if err != nil {
 		return &types.CommandResponse{
 			Success: false,
@@ -217,8 +348,27 @@ func (cs *CommandServiceK8s) HandleCleanupK8s(ctx context.Context, cmd *types.Co
 		}
 	}
 
+	// Build cleanup summary
+	namespaceNames := uniqueNamespaces(previewNamespaces)
+
+	// Revoke dynamic credentials before their namespaces disappear
+	cs.revokeVaultLeases(ctx, namespaceNames)
+
+	// Destroy cloud resources provisioned for the preview
+	err = cs.destroyTerraform(ctx, cmd.PRNumber, repoPath)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Cleanup failed",
+			Content: fmt.Sprintf("## ❌ Infrastructure Destroy Failed\n\n**Error:** %s\n\n**PR:** #%d\n\n*Preview namespaces were left in place so the destroy can be retried.*", err.Error(), cmd.PRNumber),
+		}
+	}
+
+	// Images have to be read off the workloads before they're deleted
+	gcImages, gcErr := cs.prImages(ctx, cmd.PRNumber, namespaceNames)
+
 	// Perform cleanup
-	err = cs.k8s.CleanupPreviewNamespaces(ctx, cmd.PRNumber)
+	namespaceNames, err = cs.k8s.CleanupPreviewNamespaces(ctx, cmd.Repo, cmd.PRNumber)
 	if err != nil {
 		return &types.CommandResponse{
 			Success: false,
@@ -227,18 +377,24 @@ func (cs *CommandServiceK8s) HandleCleanupK8s(ctx context.Context, cmd *types.Co
 		}
 	}
 
-	// Build cleanup summary
-	var namespaceNames []string
-	for _, ns := range previewNamespaces {
-		if name, ok := ns["name"].(string); ok {
-			namespaceNames = append(namespaceNames, name)
-		}
+	imageGCNote := cs.startImageGC(cmd, namespaceNames, gcImages, gcErr)
+
+	dependencyNote := ""
+	if released := cs.releaseSharedDependencies(ctx, cmd.Repo, cmd.PRNumber, namespaceNames); len(released) > 0 {
+		dependencyNote = fmt.Sprintf("### 🔗 Shared Dependencies Removed\nNo other preview uses them anymore:\n\n%s\n", formatNamespaceList(released))
+	}
+
+	// Namespaces terminate in the background; report when they're really gone
+	followUp := ""
+	if cs.config.Preview.CleanupWait > 0 && cmd.Repo != "" {
+		go cs.reportCleanupProgress(cmd, namespaceNames)
+		followUp = "*A follow-up comment will confirm once every namespace has finished terminating.*\n\n"
 	}
 
 	return &types.CommandResponse{
 		Success: true,
 		Message: "Cleanup completed",
-		Content: fmt.Sprintf("## 🧹 Manual Cleanup Completed\n\nSuccessfully cleaned up preview environments for PR #%d:\n\n%s\n### 📋 Resources Cleaned Up\n- ✅ Namespaces deleted (%d total)\n- ✅ Deployments and pods removed\n- ✅ Services and endpoints cleaned up\n- ✅ Labels and annotations removed\n\n*Cleanup triggered by: @%s*", cmd.PRNumber, formatNamespaceList(namespaceNames), len(namespaceNames), cmd.User),
+		Content: fmt.Sprintf("## 🧹 Manual Cleanup Completed\n\nSuccessfully cleaned up preview environments for PR #%d:\n\n%s\n### 📋 Resources Cleaned Up\n- ✅ Namespaces deleted (%d total)\n- ✅ Deployments and pods removed\n- ✅ Services and endpoints cleaned up\n- ✅ Labels and annotations removed\n\n%s%s%s*Cleanup triggered by: @%s*", cmd.PRNumber, formatNamespaceList(namespaceNames), len(namespaceNames), dependencyNote, imageGCNote, followUp, cmd.User),
 		Data: map[string]interface{}{
 			"pr_number":          cmd.PRNumber,
 			"cleaned_namespaces": namespaceNames,
@@ -247,6 +403,77 @@ func (cs *CommandServiceK8s) HandleCleanupK8s(ctx context.Context, cmd *types.Co
 	}
 }
 
+// reportCleanupProgress waits for the deleted namespaces to disappear and
+// posts whether they did, listing blocking finalizers for any that didn't
+func (cs *CommandServiceK8s) reportCleanupProgress(cmd *types.Command, namespaces []string) {
+	ctx := context.Background()
+	started := time.Now()
+
+	var summary string
+	blockers, err := cs.k8s.WaitForNamespacesDeleted(ctx, namespaces, cs.config.Preview.CleanupWait)
+	switch {
+	case err != nil:
+		summary = fmt.Sprintf("## ❌ Cleanup Progress Unknown\n\n**PR:** #%d\n**Error:** %s", cmd.PRNumber, err.Error())
+	case len(blockers) == 0:
+		summary = fmt.Sprintf("## ✅ Cleanup Finished\n\nAll %d preview namespaces for PR #%d are gone (took %s).",
+			len(namespaces), cmd.PRNumber, time.Since(started).Round(time.Second))
+	default:
+		summary = fmt.Sprintf("## ⚠️ Cleanup Stuck\n\n%d of %d preview namespaces for PR #%d are still Terminating after %s.\n\n%s\n*An admin may need to remove the blocking finalizers or fix the unavailable API services.*",
+			len(blockers), len(namespaces), cmd.PRNumber, cs.config.Preview.CleanupWait, formatNamespaceBlockers(blockers))
+	}
+
+	if err := cs.comments.PostComment(ctx, cmd.Repo, cmd.PRNumber, summary); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+}
+
+func formatNamespaceBlockers(blockers []NamespaceBlocker) string {
+	var result strings.Builder
+	for _, blocker := range blockers {
+		result.WriteString(fmt.Sprintf("### `%s`\n", blocker.Namespace))
+		if len(blocker.Finalizers) > 0 {
+			result.WriteString(fmt.Sprintf("- **Finalizers:** `%s`\n", strings.Join(blocker.Finalizers, "`, `")))
+		}
+		for _, condition := range blocker.Conditions {
+			result.WriteString(fmt.Sprintf("- %s\n", condition))
+		}
+		for _, apiService := range blocker.APIServices {
+			result.WriteString(fmt.Sprintf("- **Unavailable API service:** %s\n", apiService))
+		}
+		if len(blocker.Finalizers) == 0 && len(blocker.Conditions) == 0 {
+			result.WriteString("- No blocking finalizers reported yet\n")
+		}
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+// recordPreviewRepo notes which repository the preview belongs to, so
+// cluster-wide reports can group previews by repo
+func (cs *CommandServiceK8s) recordPreviewRepo(ctx context.Context, namespace, repo string) {
+	if repo == "" {
+		return
+	}
+	if err := cs.k8s.AnnotateNamespace(ctx, namespace, nil, map[string]string{previewRepoAnnotation: repo}); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// uniqueNamespaces lists each preview namespace once; GetPreviewNamespacesByPR
+// repeats a shared namespace for every service in it
+func uniqueNamespaces(previews []PreviewNamespace) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, ns := range previews {
+		if !seen[ns.Name] {
+			seen[ns.Name] = true
+			names = append(names, ns.Name)
+		}
+	}
+	return names
+}
+
 func formatNamespaceList(names []string) string {
 	var result strings.Builder
 	for _, name := range names {
@@ -262,6 +489,10 @@ func (cs *CommandServiceK8s) GetAvailableServicesWithManifest(repoPath string) [
 	manifestServices := cs.scanForManifestServices(repoPath)
 	services = append(services, manifestServices...)
 
+	if composePath := findComposeFile(repoPath); composePath != "" {
+		services = append(services, fmt.Sprintf("%s (converted from %s)", composeServiceName, filepath.Base(composePath)))
+	}
+
 	return services
 }
 
@@ -380,38 +611,92 @@ func (cs *CommandServiceK8s) HandlePreviewK8sEnhanced(ctx context.Context, cmd *
 		deploymentMethod = "manifest-deployment"
 	}
 
+	// Repos with only a compose file deploy it whole as the "compose" service
+	isCompose := false
+	if !isManifest && serviceName == composeServiceName {
+		if composePath := findComposeFile(repoPath); composePath != "" {
+			isManifest, isCompose = true, true
+			manifestPath = composePath
+			deploymentMethod = "docker-compose"
+		}
+	}
+
+	// Load repo config over the org-wide one (secrets may be SOPS-encrypted)
+	repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Repo config loading failed",
+			Content: fmt.Sprintf("## ❌ Repo Config Loading Failed\n\n**Error:** %s\n\n*Check that `%s`, and the org-wide config if there is one, is valid and that the server has the SOPS key configured.*", err.Error(), repoConfigFile),
+		}
+	}
+
+	// A pinned remote chart in the repo config wins over files in the repo
+	chartSource, isChart := repoConfig.ChartFor(serviceName)
+	if isChart {
+		isManifest, isCompose = true, false
+		manifestPath = chartSource.Ref()
+		deploymentMethod = "helm-chart"
+	}
+
 	// Show available services if service not found (except default nginx)
 	if serviceName != "nginx" && !isManifest {
 		availableServices := cs.GetAvailableServicesWithManifest(repoPath)
 		return &types.CommandResponse{
 			Success: false,
 			Message: "Service not found",
-			Content: fmt.Sprintf("## ❌ Service Not Found\n\n**Service:** `%s`\n\n**Available services:**\n%s\n\n**Usage Examples:**\n- `/preview` - Deploy nginx (default)\n- `/preview myapp` - Deploy from k8s/myapp.yaml\n- `/preview frontend` - Deploy from k8s/frontend.yaml\n\n**To add new services:**\nCreate YAML manifest files in `k8s/`, `kubernetes/`, `manifests/`, or `deploy/` folders.",
+			Content: fmt.Sprintf("## ❌ Service Not Found\n\n**Service:** `%s`\n\n**Available services:**\n%s\n\n**Usage Examples:**\n- `/preview` - Deploy nginx (default)\n- `/preview myapp` - Deploy from k8s/myapp.yaml\n- `/preview frontend` - Deploy from k8s/frontend.yaml\n- `/preview compose` - Deploy docker-compose.yaml\n\n**To add new services:**\nCreate YAML manifest files in `k8s/`, `kubernetes/`, `manifests/`, or `deploy/` folders.",
 				serviceName, formatAvailableServicesList(availableServices)),
 		}
 	}
 
-	// Create namespace
-	cleanServiceName := strings.ReplaceAll(serviceName, "/", "-")
-	namespaceName := fmt.Sprintf("preview-pr-%d-%s", cmd.PRNumber, cleanServiceName)
-
-	// Step 1: Create namespace
-	err := cs.k8s.CreateNamespace(ctx, namespaceName, cmd.PRNumber, serviceName)
+	// Settings from the PR description apply to every deploy of this PR
+	prSettings, err := cs.LoadPRSettings(ctx, cmd)
 	if err != nil {
 		return &types.CommandResponse{
 			Success: false,
-			Message: "Preview deployment failed",
-			Content: fmt.Sprintf("## ❌ Preview Deployment Failed\n\n**Error:** %s", err.Error()),
+			Message: "PR settings loading failed",
+			Content: fmt.Sprintf("## ❌ Invalid Preview Settings\n\n**Error:** %s\n\n*Fix the `pr-previews` block in the PR description and try again.*", err.Error()),
 		}
 	}
 
-	// Step 2: Deploy based on method
-	var deployedResources []string
+	// Size workloads by the requested or configured service class
+	class, err := resolveServiceClass(cmd.Args["class"], serviceName, repoConfig)
+	if err != nil {
+		return failedResponse("Invalid service class", "Invalid Service Class", err)
+	}
+
+	// Render the manifest before touching the cluster so policy violations
+	// block the deploy without leaving an empty namespace behind
+	var mutations, injected []string
+	var parsed *ParsedManifest
+	var securityChanges, securityViolations []string
+	var policyReport *PolicyReport
+	var composeConversion *ComposeConversion
+	var archReport *ArchReport
 
 	if isManifest {
-		// Parse and deploy from manifest
-		parser := NewManifestParser()
-		parsed, err := parser.ParseManifestFile(manifestPath)
+		if isChart {
+			var rendered []byte
+			rendered, err = cs.helm.Render(ctx, strings.ReplaceAll(serviceName, "/", "-"), chartSource, repoPath, injectionData{
+				PR:      cmd.PRNumber,
+				Service: strings.ReplaceAll(serviceName, "/", "-"),
+				Branch:  SlugifyBranch(cmd.Branch),
+				Repo:    cmd.Repo,
+				Domain:  cs.config.Preview.Domain,
+			})
+			if err == nil {
+				parsed, err = NewManifestParser(cs.decryptor).ParseManifestContent(rendered, manifestPath)
+			}
+		} else if isCompose {
+			composeConversion, err = ConvertComposeFile(manifestPath)
+			if err == nil {
+				parsed = composeConversion.Manifest
+			}
+		} else {
+			parser := NewManifestParser(cs.decryptor)
+			parsed, err = parser.ParseManifestFile(manifestPath)
+		}
 		if err != nil {
 			return &types.CommandResponse{
 				Success: false,
@@ -420,8 +705,308 @@ func (cs *CommandServiceK8s) HandlePreviewK8sEnhanced(ctx context.Context, cmd *
 			}
 		}
 
-		// Deploy from parsed manifest
-		err = cs.k8s.DeployFromParsedManifest(ctx, namespaceName, parsed)
+		// Size to the service class, then adapt for preview (HPA stripping,
+		// replica clamping) so the cluster-wide limits still apply
+		if class != nil {
+			mutations = class.Apply(parsed)
+		}
+		mutations = append(mutations, cs.mutator.Mutate(serviceName, parsed)...)
+		if capabilityError(CapabilityAutoscaling) != nil {
+			// Services that keep their scaling would fail to deploy
+			for _, hpa := range parsed.HorizontalPodAutoscalers {
+				mutations = append(mutations, fmt.Sprintf("Removed HorizontalPodAutoscaler/%s: the cluster doesn't serve autoscaling/v2", hpa.Name))
+			}
+			parsed.HorizontalPodAutoscalers = nil
+		}
+
+		// Sidecars and init containers the service opted in to, before the
+		// security pass so they meet the same standard
+		injected, err = injectContainers(repoConfig.InjectionsFor(serviceName), injectionData{
+			PR:      cmd.PRNumber,
+			Service: strings.ReplaceAll(serviceName, "/", "-"),
+			Branch:  SlugifyBranch(cmd.Branch),
+			Repo:    cmd.Repo,
+			Domain:  cs.config.Preview.Domain,
+		}, parsed)
+		if err != nil {
+			return failedResponse("Container injection failed", "Container Injection Failed", err)
+		}
+
+		// Only images from the registries the operators allow, injected
+		// ones included, may run in a preview
+		if violations := cs.images.Check(parsed); len(violations) > 0 {
+			return imagePolicyResponse(serviceName, manifestPath, violations)
+		}
+
+		// Enforce Pod Security Standards up front and report what changed,
+		// rather than letting admission reject the pods without a trace
+		securityChanges, securityViolations = cs.security.Mutate(parsed)
+
+		// Keep pods off nodes their images weren't built for
+		archReport = cs.pinArchitectures(ctx, parsed)
+
+		// Chargeback and mesh labels the operator and repo ask for
+		cs.applyPreviewMetadata(parsed, repoConfig)
+
+		// Load PR description env vars, now or after a later description edit
+		addPREnv(parsed)
+		if previewAllRun(cmd) {
+			addServiceEndpointsEnv(parsed)
+		}
+
+		// Deploy-time guardrails run against exactly what would be applied
+		policyReport, err = cs.policy.Evaluate(parsed)
+		if err != nil {
+			return failedResponse("Policy evaluation failed", "Policy Evaluation Failed", err)
+		}
+		if policyReport.Violated() && policyReport.Mode == PolicyEnforce {
+			return policyBlockedResponse(serviceName, manifestPath, policyReport)
+		}
+
+		// The repo's previews share one budget across namespaces. A
+		// redeploy replaces its own workloads, unless blue/green runs both
+		// revisions until the swap.
+		var replaced *ReplacedWorkloads
+		cleanService := strings.ReplaceAll(serviceName, "/", "-")
+		if repoConfig.SharedNamespace() || previewAllRun(cmd) {
+			replaced = replacedBy(previewNamespace(cmd.PRNumber, cleanService, true), parsed)
+		} else if !cs.config.Preview.BlueGreen || len(parsed.Deployments) == 0 || repoConfig.VirtualCluster() {
+			replaced = replacedBy(cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanService), parsed)
+		}
+		requested, _ := manifestRequests(parsed, cs.requestDefaults())
+		release, err := cs.checkRepoQuota(ctx, cmd.Repo, requested, replaced)
+		if err != nil {
+			var quotaErr *RepoQuotaError
+			if !errors.As(err, &quotaErr) {
+				return failedResponse("Repository quota check failed", "Repository Quota Check Failed", err)
+			}
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Repository quota exceeded",
+				Content: formatRepoQuotaError(serviceName, quotaErr),
+				Data: map[string]interface{}{
+					"service":    serviceName,
+					"repo":       quotaErr.Repo,
+					"in_use":     quotaErr.InUse,
+					"requested":  quotaErr.Requested,
+					"namespaces": quotaErr.Namespaces,
+				},
+			}
+		}
+		defer release()
+	} else {
+		// The built-in image is sized by the service class, so it meets
+		// the same guardrails
+		policyReport, err = cs.policy.Evaluate(&ParsedManifest{Deployments: []appsv1.Deployment{
+			*testPodDeployment("", strings.ReplaceAll(serviceName, "/", "-"), class),
+		}})
+		if err != nil {
+			return failedResponse("Policy evaluation failed", "Policy Evaluation Failed", err)
+		}
+		if policyReport.Violated() && policyReport.Mode == PolicyEnforce {
+			return policyBlockedResponse(serviceName, deploymentMethod, policyReport)
+		}
+	}
+
+	// How long this deploy lives, checked before anything is created
+	ttl, ttlSource, err := resolvePreviewTTL(cmd.Args["ttl"], prSettings, parsed, repoConfig, cs.config.Preview.MaxTTL)
+	if err != nil {
+		return failedResponse("Invalid TTL", "Invalid TTL", err)
+	}
+
+	// Create namespace
+	cleanServiceName := strings.ReplaceAll(serviceName, "/", "-")
+	shared := repoConfig.SharedNamespace() || previewAllRun(cmd)
+	namespaceName := previewNamespace(cmd.PRNumber, cleanServiceName, shared)
+
+	// Step 1: Create namespace (or claim a prepared one), or join the PR's
+	// shared one. A running per-service preview is redeployed in place,
+	// blue/green.
+	// With the admission preflight, a namespace the cluster's policies
+	// refuse is never created, and one created for workloads they refuse
+	// is removed again.
+	preflight := isManifest && cs.config.Preview.AdmissionPreflight && !repoConfig.VirtualCluster()
+	warm, redeploy, joined := false, false, false
+	if shared {
+		var exists bool
+		if exists, err = cs.k8s.NamespaceExists(ctx, namespaceName); err == nil {
+			joined = exists
+			err = cs.k8s.EnsureSharedNamespace(ctx, namespaceName, cmd.PRNumber, cleanServiceName, cmd.User)
+		}
+	} else if running, ok, swapErr := cs.runningPreview(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName, parsed, repoConfig); swapErr != nil {
+		err = swapErr
+	} else if ok {
+		namespaceName, redeploy = running, true
+	} else {
+		if preflight {
+			labels, _, _ := cs.namespaceMetadata(repoConfig)
+			denials := cs.k8s.SimulateNamespaceAdmission(ctx, cs.tenants.Namespace(cmd.Repo, namespaceName), cmd.PRNumber, serviceName, cmd.User, labels)
+			if len(denials) > 0 {
+				return admissionDeniedResponse(serviceName, manifestPath, denials)
+			}
+		}
+		namespaceName, warm, err = cs.createPreviewNamespace(ctx, namespaceName, cmd.Repo, cmd.PRNumber, serviceName, cmd.User)
+	}
+	if err != nil {
+		return failedResponse("Preview deployment failed", "Preview Deployment Failed", err)
+	}
+	cs.recordPreviewRepo(ctx, namespaceName, cmd.Repo)
+	if err := cs.labelPreviewNamespace(ctx, namespaceName, repoConfig); err != nil {
+		return failedResponse("Preview deployment failed", "Preview Deployment Failed", err)
+	}
+	if preflight {
+		var denials []AdmissionDenial
+		if redeploy {
+			if denials, err = cs.k8s.SimulateRevisionSwap(ctx, namespaceName, parsed); err != nil {
+				return failedResponse("Preview deployment failed", "Preview Deployment Failed", err)
+			}
+		} else {
+			denials = cs.k8s.SimulateAdmission(ctx, namespaceName, parsed)
+		}
+		if len(denials) > 0 {
+			var undo error
+			switch {
+			case redeploy:
+				// The live revision keeps serving
+			case joined:
+				undo = cs.k8s.LeaveSharedNamespace(ctx, namespaceName, cleanServiceName)
+			default:
+				undo = cs.k8s.DeleteNamespace(ctx, namespaceName)
+			}
+			if undo != nil {
+				fmt.Printf("Warning: failed to undo %s after its admission preflight: %v\n", namespaceName, undo)
+			}
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Admission preflight refused %d resources of %s; nothing was deployed", len(denials), serviceName)
+			return admissionDeniedResponse(serviceName, manifestPath, denials)
+		}
+	}
+	expiresAt, err := cs.setPreviewExpiry(ctx, namespaceName, ttl, shared)
+	if err != nil {
+		return failedResponse("Preview deployment failed", "Preview Deployment Failed", err)
+	}
+
+	// A vcluster preview deploys into the virtual cluster in the PR's
+	// namespace; settings and secrets are still injected on the host, where
+	// TTLs and Vault leases are tracked, then copied in
+	target, deployNamespace := cs, namespaceName
+	if repoConfig.VirtualCluster() {
+		virtual, err := cs.vcluster.Ensure(ctx, cs.k8s, namespaceName)
+		if err != nil {
+			return failedResponse("Virtual cluster provisioning failed", "Virtual Cluster Provisioning Failed", err)
+		}
+		inVCluster := *cs
+		inVCluster.k8s = virtual
+		target, deployNamespace = &inVCluster, vclusterNamespace
+	}
+
+	// Step 2: Deploy based on method
+	var deployedResources []string
+	var prePull []string
+	var swap *RevisionSwap
+
+	if prSettings != nil {
+		if err := cs.applyPRSettings(ctx, namespaceName, prSettings); err != nil {
+			return failedResponse("PR settings injection failed", "PR Settings Injection Failed", err)
+		}
+		deployedResources = append(deployedResources, fmt.Sprintf("ConfigMap/%s", prEnvConfigMap))
+	}
+
+	// Wire in the URLs of every service of a /preview all run
+	if previewAllRun(cmd) {
+		if err := cs.k8s.UpsertConfigMap(ctx, namespaceName, previewServicesConfigMap, cs.previewAllEndpoints(cmd, repoPath, repoConfig)); err != nil {
+			return failedResponse("Service wiring failed", "Service Wiring Failed", err)
+		}
+		deployedResources = append(deployedResources, fmt.Sprintf("ConfigMap/%s", previewServicesConfigMap))
+	}
+
+	// Inject repo-config secrets before workloads start
+	if len(repoConfig.Secrets) > 0 {
+		err = cs.k8s.CreatePreviewSecret(ctx, namespaceName, "preview-secrets", repoConfig.Secrets)
+		if err != nil {
+			return failedResponse("Secret injection failed", "Secret Injection Failed", err)
+		}
+		deployedResources = append(deployedResources, "Secret/preview-secrets")
+	}
+
+	// Fetch dynamic credentials from Vault
+	vaultSecrets, err := cs.injectVaultSecrets(ctx, namespaceName, repoConfig)
+	if err != nil {
+		return failedResponse("Vault secret injection failed", "Vault Secret Injection Failed", err)
+	}
+	deployedResources = append(deployedResources, vaultSecrets...)
+
+	// Provision cloud resources from the repo's Terraform/OpenTofu module
+	infraResources, err := cs.applyTerraform(ctx, namespaceName, cmd.PRNumber, repoPath)
+	if err != nil {
+		return failedResponse("Infrastructure provisioning failed", "Infrastructure Provisioning Failed", err)
+	}
+	deployedResources = append(deployedResources, infraResources...)
+
+	if target != cs {
+		if err := cs.k8s.MirrorIntoVCluster(ctx, target.k8s, namespaceName, deployedResources); err != nil {
+			return failedResponse("Virtual cluster secret injection failed", "Virtual Cluster Secret Injection Failed", err)
+		}
+	}
+
+	if isManifest {
+		// Warm large images on the preview nodes before the pods are scheduled
+		prePull = cs.prePullImages(cmd, namespaceName, parsed)
+
+		// Kinds the typed deploy doesn't handle are only decoded when
+		// something here applies them
+		var other []unstructured.Unstructured
+		skipped := parsed.OtherCount()
+		if skipped > 0 && (len(repoConfig.Operators) > 0 || target != cs) {
+			other = parsed.OtherObjects()
+		}
+
+		// Custom resources of the operators the repo references go in the
+		// preview namespace, whatever namespace the manifest gave them
+		if len(repoConfig.Operators) > 0 {
+			kinds, installed, err := cs.operators.Ensure(ctx, cs.k8s, repoConfig.Operators)
+			for _, name := range installed {
+				cs.logTimeline(cmd.Repo, cmd.PRNumber, "Installed operator bundle %s in %s", name, cs.config.Operators.Namespace)
+			}
+			if err != nil {
+				return failedResponse("Operator bootstrap failed", "Operator Bootstrap Failed", err)
+			}
+			served, rest := splitOperatorResources(other, kinds)
+			other, skipped = rest, len(rest)
+			for i := range served {
+				served[i].SetNamespace(namespaceName)
+			}
+			applied, err := cs.k8s.ApplyObjects(ctx, namespaceName, served)
+			if err != nil {
+				return failedResponse("Manifest deployment failed", "Manifest Deployment Failed", err)
+			}
+			deployedResources = append(deployedResources, applied...)
+		}
+
+		// CRDs and cluster-scoped resources need a cluster of their own
+		if len(other) > 0 && target != cs {
+			applied, err := target.k8s.ApplyObjects(ctx, deployNamespace, other)
+			if err != nil {
+				return failedResponse("Manifest deployment failed", "Manifest Deployment Failed", err)
+			}
+			deployedResources = append(deployedResources, applied...)
+		} else if skipped > 0 {
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Skipped %d resources of kinds namespace previews don't deploy; set isolation: %s or reference an operator bundle to apply them", skipped, IsolationVCluster)
+		}
+
+		// Dependencies shared by the repo's previews run once; the preview
+		// reaches them through ExternalName Services and env
+		dependencyResources, err := cs.wireSharedDependencies(ctx, cmd, repoPath, repoConfig, serviceName, namespaceName, parsed)
+		if err != nil {
+			return failedResponse("Shared dependency provisioning failed", "Shared Dependency Provisioning Failed", err)
+		}
+		deployedResources = append(deployedResources, dependencyResources...)
+
+		// Deploy from parsed manifest, or beside the live revision
+		if redeploy {
+			swap, err = cs.k8s.StartRevisionSwap(ctx, namespaceName, parsed)
+		} else {
+			err = target.k8s.DeployFromParsedManifest(ctx, deployNamespace, parsed)
+		}
 		if err != nil {
 			return &types.CommandResponse{
 				Success: false,
@@ -431,8 +1016,18 @@ func (cs *CommandServiceK8s) HandlePreviewK8sEnhanced(ctx context.Context, cmd *
 		}
 
 		// Build deployed resources list
+		for _, pvc := range parsed.PersistentVolumeClaims {
+			deployedResources = append(deployedResources, fmt.Sprintf("PersistentVolumeClaim/%s", pvc.Name))
+		}
 		for _, dep := range parsed.Deployments {
-			deployedResources = append(deployedResources, fmt.Sprintf("Deployment/%s", dep.Name))
+			name := dep.Name
+			if swap != nil {
+				name = slotName(dep.Name, swap.To)
+			}
+			deployedResources = append(deployedResources, fmt.Sprintf("Deployment/%s", name))
+		}
+		for _, sts := range parsed.StatefulSets {
+			deployedResources = append(deployedResources, fmt.Sprintf("StatefulSet/%s", sts.Name))
 		}
 		for _, svc := range parsed.Services {
 			deployedResources = append(deployedResources, fmt.Sprintf("Service/%s", svc.Name))
@@ -440,44 +1035,190 @@ func (cs *CommandServiceK8s) HandlePreviewK8sEnhanced(ctx context.Context, cmd *
 		for _, cm := range parsed.ConfigMaps {
 			deployedResources = append(deployedResources, fmt.Sprintf("ConfigMap/%s", cm.Name))
 		}
+		for _, secret := range parsed.Secrets {
+			deployedResources = append(deployedResources, fmt.Sprintf("Secret/%s", secret.Name))
+		}
+		for _, hpa := range parsed.HorizontalPodAutoscalers {
+			deployedResources = append(deployedResources, fmt.Sprintf("HorizontalPodAutoscaler/%s", hpa.Name))
+		}
 
 	} else {
 		// Regular nginx deployment
-		err = cs.k8s.DeployTestPod(ctx, namespaceName, cleanServiceName)
+		err = target.k8s.DeployTestPod(ctx, deployNamespace, cleanServiceName, class)
 		if err != nil {
-			return &types.CommandResponse{
-				Success: false,
-				Message: "Pod deployment failed",
-				Content: fmt.Sprintf("## ❌ Pod Deployment Failed\n\n**Error:** %s", err.Error()),
-			}
+			return failedResponse("Pod deployment failed", "Pod Deployment Failed", err)
 		}
 
-		err = cs.k8s.CreateService(ctx, namespaceName, cleanServiceName)
+		err = target.k8s.CreateService(ctx, deployNamespace, cleanServiceName)
 		if err != nil {
-			return &types.CommandResponse{
-				Success: false,
-				Message: "Service creation failed",
-				Content: fmt.Sprintf("## ❌ Service Creation Failed\n\n**Error:** %s", err.Error()),
-			}
+			return failedResponse("Service creation failed", "Service Creation Failed", err)
 		}
 
-		deployedResources = []string{
+		deployedResources = append(deployedResources,
 			fmt.Sprintf("Deployment/%s", cleanServiceName),
 			fmt.Sprintf("Service/%s", cleanServiceName),
+		)
+	}
+
+	// Expose the preview on its own subdomain
+	previewURL := ""
+	var previewRoutes []string
+	targetService, targetPort := cleanServiceName, int32(80)
+	if parsed != nil {
+		targetService, targetPort = "", 0
+		// Prefer a regular Service over a StatefulSet's headless one
+		for _, svc := range parsed.Services {
+			if len(svc.Spec.Ports) == 0 || (targetService != "" && svc.Spec.ClusterIP == corev1.ClusterIPNone) {
+				continue
+			}
+			targetService, targetPort = svc.Name, svc.Spec.Ports[0].Port
+			if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+				break
+			}
+		}
+	}
+	var networkWarnings []string
+	var unexposed error
+	if targetService != "" {
+		var domain *ServiceDomain
+		if d, ok := repoConfig.Domains[serviceName]; ok {
+			domain = &d
+		}
+		// The ingress stays on the host, in front of the Service vcluster syncs
+		ingressService := targetService
+		if target != cs {
+			ingressService = vclusterHostName(targetService, vclusterNamespace)
+		}
+		previewURL, previewRoutes, err = cs.exposePreview(ctx, cmd, namespaceName, cleanServiceName, ingressService, targetPort, domain, shared)
+		if err != nil {
+			fmt.Printf("Warning: failed to expose preview %s: %v\n", namespaceName, err)
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Failed to expose preview %s: %v", namespaceName, err)
+			unexposed = capabilityError(CapabilityIngress)
+		} else {
+			if shared {
+				deployedResources = append(deployedResources, "Ingress/preview-"+cleanServiceName)
+			} else {
+				deployedResources = append(deployedResources, "Ingress/preview")
+			}
+			// Catch IPv6-only previews behind an IPv4-only controller
+			networkWarnings, err = cs.k8s.CheckIngressFamilies(ctx, cs.config.Network.IngressService)
+			if err != nil {
+				fmt.Printf("Warning: failed to check ingress IP families: %v\n", err)
+			}
+		}
+	}
+
+	// Ready pods aren't enough when the repo names a URL the preview must
+	// answer on
+	if check, ok := repoConfig.Readiness[serviceName]; ok {
+		checkURL, err := check.resolve(previewURL, previewHostData{
+			PR:      cmd.PRNumber,
+			Service: cleanServiceName,
+			Branch:  SlugifyBranch(cmd.Branch),
+			Domain:  cs.config.Preview.Domain,
+		})
+		if err == nil && checkURL == "" {
+			err = fmt.Errorf("the preview isn't exposed, so there is no URL to check")
+		}
+		if err == nil {
+			err = cs.startReadinessCheck(ctx, namespaceName, cleanServiceName, shared, check, checkURL)
+		}
+		if err != nil {
+			fmt.Printf("Warning: readiness check of %s skipped: %v\n", namespaceName, err)
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Readiness check of %s skipped: %v", namespaceName, err)
 		}
 	}
 
+	// Surface image pull failures early (non-blocking)
+	go target.watchPreviewReadiness(ctx, cmd, deployNamespace)
+	if parsed != nil && len(parsed.StatefulSets) > 0 {
+		go target.watchStatefulSetRollout(cmd, deployNamespace, parsed.StatefulSets)
+	}
+	go cs.triggerE2E(cmd, cleanServiceName)
+	if swap != nil {
+		go cs.completeRevisionSwap(cmd, serviceName, swap)
+	}
+
+	// Keep exactly what was deployed so it can be reproduced
+	artifactPrefix, err := cs.saveDeploymentArtifacts(ctx, cmd, namespaceName, cleanServiceName, deploymentMethod, manifestPath, parsed, deployedResources)
+	if err != nil {
+		fmt.Printf("Warning: failed to store deployment artifacts: %v\n", err)
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "Failed to store deployment artifacts for %s: %v", namespaceName, err)
+	}
+
 	// Build success response
 	var manifestNote string
 	var resourcesList string
 
 	if isManifest {
 		manifestNote = fmt.Sprintf("\n\n🎯 **Manifest Deployed:** Successfully deployed from `%s`\n📋 **Real Deployment:** Resources deployed directly from your manifest!", manifestPath)
+		if composeConversion != nil {
+			manifestNote += "\n\n" + formatComposeReport(manifestPath, composeConversion)
+		}
+		if len(mutations) > 0 {
+			manifestNote += fmt.Sprintf("\n\n### ⚖️ Preview Scaling Adjustments\n%s", cs.formatResourcesList(mutations))
+		}
+		if len(injected) > 0 {
+			manifestNote += fmt.Sprintf("\n\n### 🧩 Injected Containers\n%s", cs.formatResourcesList(injected))
+		}
+		if len(securityChanges) > 0 || len(securityViolations) > 0 {
+			manifestNote += "\n\n" + formatPodSecurityReport(cs.security.Level(), securityChanges, securityViolations)
+		}
+		if archReport != nil {
+			manifestNote += "\n\n" + formatArchReport(archReport)
+		}
+		if len(prePull) > 0 {
+			manifestNote += fmt.Sprintf("\n\n### 🚚 Image Pre-pull\nWarming %d image(s) on the preview nodes in the background; the pull times go to the timeline.", len(prePull))
+		}
+		if policyReport.Violated() {
+			manifestNote += "\n\n" + formatPolicyReport(policyReport)
+		}
 		resourcesList = strings.Join(deployedResources, ", ")
 	} else {
 		resourcesList = strings.Join(deployedResources, ", ")
 	}
 
+	if class != nil {
+		manifestNote += fmt.Sprintf("\n\n📐 **Service Class:** %s", class)
+	}
+	if prSettings != nil {
+		manifestNote += "\n\n### 🔧 PR Description Settings\n" + formatPRSettings(prSettings)
+	}
+	if !expiresAt.IsZero() {
+		manifestNote += fmt.Sprintf("\n\n⏳ **Expires:** %s (TTL %s from %s)", expiresAt.Format("2006-01-02 15:04 UTC"), formatAge(ttl), ttlSource)
+	}
+	if previewURL != "" {
+		manifestNote += fmt.Sprintf("\n\n🌐 **Preview URL:** %s", previewURL)
+		if len(previewRoutes) > 0 {
+			manifestNote += fmt.Sprintf("\n\n### 🔀 Routes\n%s", cs.formatResourcesList(previewRoutes))
+		}
+	}
+	if len(networkWarnings) > 0 {
+		manifestNote += fmt.Sprintf("\n\n### ⚠️ IP Family Mismatch\n%s", cs.formatResourcesList(networkWarnings))
+	}
+	if unexposed != nil {
+		manifestNote += fmt.Sprintf("\n\n### ⚠️ Not Exposed\nThe preview has no URL: %s. Reach it with `kubectl port-forward` instead.", unexposed.Error())
+	}
+	if swap != nil {
+		from := "the running revision"
+		if swap.From != "" {
+			from = "the live " + swap.From + " revision"
+		}
+		manifestNote += fmt.Sprintf("\n\n🔵🟢 **Blue/Green Redeploy:** the %s revision starts beside %s, which keeps serving until it's ready; traffic then switches over and the old revision is removed. If it isn't ready within %s it's abandoned instead.", swap.To, from, formatAge(cs.config.Preview.SwapTimeout))
+	}
+	if warm {
+		manifestNote += fmt.Sprintf("\n\n♨️ **Warm Namespace:** claimed the prepared namespace `%s` from the pool for `%s`.", namespaceName, previewNamespace(cmd.PRNumber, cleanServiceName, false))
+	}
+	if target != cs {
+		manifestNote += fmt.Sprintf("\n\n🧊 **Virtual Cluster:** every service of this PR deploys into the vcluster `%s` in `%s`, with its own CRDs and cluster-scoped resources, and can reach the others by Service name (e.g. `http://%s`).", previewVClusterName, namespaceName, targetService)
+	} else if shared {
+		manifestNote += fmt.Sprintf("\n\n🔗 **Shared Namespace:** every service of this PR deploys into `%s` and can reach the others by Service name (e.g. `http://%s`).", namespaceName, targetService)
+	}
+
+	if metricsLinks := cs.metrics.Markdown(namespaceName, serviceName, cmd.PRNumber); metricsLinks != "" {
+		manifestNote += "\n\n### 📈 Monitoring\n" + metricsLinks
+	}
+
 	return &types.CommandResponse{
 		Success: true,
 		Message: "Preview deployment started",
@@ -488,12 +1229,30 @@ func (cs *CommandServiceK8s) HandlePreviewK8sEnhanced(ctx context.Context, cmd *
 			"service":            serviceName,
 			"clean_service_name": cleanServiceName,
 			"namespace":          namespaceName,
+			"warm_namespace":     warm,
+			"revision_swap":      swap,
+			"virtual_cluster":    target != cs,
+			"expires_at":         expiresAt,
 			"deployment_method":  deploymentMethod,
 			"manifest_detected":  isManifest,
 			"manifest_path":      manifestPath,
 			"deployed_resources": deployedResources,
-			"pr_number":          cmd.PRNumber,
-			"status":             "deploying",
+			"manifest_mutations": mutations,
+			"injected":           injected,
+			"pod_security": map[string]interface{}{
+				"level":      cs.security.Level(),
+				"changes":    securityChanges,
+				"violations": securityViolations,
+			},
+			"monitoring_links": cs.metrics.Links(namespaceName, serviceName, cmd.PRNumber),
+			"artifacts":        artifactPrefix,
+			"preview_url":      previewURL,
+			"preview_routes":   previewRoutes,
+			"image_prepull":    prePull,
+			"architecture":     archReport,
+			"policy":           policyReport,
+			"pr_number":        cmd.PRNumber,
+			"status":           "deploying",
 		},
 	}
 }
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"pr-previews/internal/types"
+)
+
+const debugServiceAccount = "preview-debug"
+
+// PreviewKubeconfig issues a kubeconfig that can only reach the given preview
+// namespace and stops working after the configured TTL
+func (cs *CommandServiceK8s) PreviewKubeconfig(ctx context.Context, prNumber int, service string) ([]byte, time.Time, error) {
+	if err := ValidateServiceName(service); err != nil {
+		return nil, time.Time{}, err
+	}
+	namespace := cs.k8s.ResolvePreviewNamespace(ctx, "", prNumber, strings.ReplaceAll(service, "/", "-"))
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !exists {
+		return nil, time.Time{}, fmt.Errorf("preview %s does not exist", namespace)
+	}
+
+	token, expiry, err := cs.k8s.IssueDebugToken(ctx, namespace, debugServiceAccount, cs.config.Preview.KubeconfigTTL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	server, caData, err := cs.k8s.ClusterEndpoint()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	// In-cluster the API server is only reachable as kubernetes.default.svc
+	if cs.config.Preview.APIServerURL != "" {
+		server = cs.config.Preview.APIServerURL
+	}
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[namespace] = &clientcmdapi.Cluster{
+		Server:                   server,
+		CertificateAuthorityData: caData,
+	}
+	kubeconfig.AuthInfos[namespace] = &clientcmdapi.AuthInfo{Token: token}
+	kubeconfig.Contexts[namespace] = &clientcmdapi.Context{
+		Cluster:   namespace,
+		AuthInfo:  namespace,
+		Namespace: namespace,
+	}
+	kubeconfig.CurrentContext = namespace
+
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to render kubeconfig: %v", err)
+	}
+	return content, expiry, nil
+}
+
+// HandleKubeconfigK8s explains how to download a debug kubeconfig. The
+// credentials themselves never go into a PR comment.
+func (cs *CommandServiceK8s) HandleKubeconfigK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	namespace := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, strings.ReplaceAll(cmd.Service, "/", "-"))
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err != nil || !exists {
+		if err == nil {
+			err = fmt.Errorf("preview %s does not exist", namespace)
+		}
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Kubeconfig unavailable",
+			Content: fmt.Sprintf("## ❌ Kubeconfig Unavailable\n\n**Error:** %s\n\n*Run `/preview %s` first.*", err.Error(), cmd.Service),
+		}
+	}
+
+	baseURL := cs.config.Server.PublicURL
+	if baseURL == "" {
+		baseURL = "https://<pr-previews-host>"
+	}
+	downloadURL := fmt.Sprintf("%s/api/v1/previews/%d/kubeconfig?service=%s", baseURL, cmd.PRNumber, cmd.Service)
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Kubeconfig instructions",
+		Content: fmt.Sprintf("## 🔑 Preview Kubeconfig\n\n**📦 Namespace:** `%s`\n**⏱️ Valid for:** %s\n\nDownload a kubeconfig scoped to this namespace with your GitHub token:\n\n```bash\ncurl -fsS -H \"Authorization: Bearer $(gh auth token)\" \\\n  \"%s\" -o preview.kubeconfig\nKUBECONFIG=preview.kubeconfig kubectl get pods\n```\n\nThe token can read everything in the namespace, exec into and port-forward pods, and scale workloads. It has no access outside the namespace.\n\n*Requested by: @%s*",
+			namespace, cs.config.Preview.KubeconfigTTL, downloadURL, cmd.User),
+		Data: map[string]interface{}{
+			"namespace":    namespace,
+			"download_url": downloadURL,
+			"ttl":          cs.config.Preview.KubeconfigTTL.String(),
+		},
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// HandleLoadTestK8s deploys a scaled-up preview and fires a vegeta Job at it
+func (cs *CommandServiceK8s) HandleLoadTestK8s(ctx context.Context, cmd *types.Command, repoPath string) *types.CommandResponse {
+	replicas, duration, err := cs.parseLoadTestArgs(cmd.Args)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Invalid load test arguments",
+			Content: fmt.Sprintf("## ❌ Invalid Load Test Arguments\n\n**Error:** %s\n\n**Limits:** up to %d replicas for %s\n\n**Usage:** `/loadtest <service> --replicas=N --duration=30m`",
+				err.Error(), cs.config.LoadTest.MaxReplicas, cs.config.LoadTest.MaxDuration),
+		}
+	}
+
+	serviceName := cmd.Service
+	isManifest := cs.isManifestBasedService(serviceName, repoPath)
+	if serviceName != "nginx" && !isManifest {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Service not found",
+			Content: fmt.Sprintf("## ❌ Service Not Found\n\n**Service:** `%s`\n\n**Available services:**\n%s",
+				serviceName, formatAvailableServicesList(cs.GetAvailableServicesWithManifest(repoPath))),
+		}
+	}
+
+	cleanServiceName := strings.ReplaceAll(serviceName, "/", "-")
+	namespaceName := fmt.Sprintf("preview-pr-%d-%s-loadtest", cmd.PRNumber, cleanServiceName)
+
+	err = cs.k8s.CreateNamespace(ctx, namespaceName, cmd.PRNumber, serviceName, cmd.User)
+	if err != nil {
+		return failedResponse("Load test deployment failed", "Load Test Deployment Failed", err)
+	}
+
+	// Deploy the service, then scale every deployment to the requested replicas
+	var deploymentNames []string
+	target := fmt.Sprintf("http://%s.%s.svc.cluster.local:80/", cleanServiceName, namespaceName)
+
+	if isManifest {
+		manifestPath := cs.getManifestPath(serviceName, repoPath)
+		parsed, err := NewManifestParser(cs.decryptor).ParseManifestFile(manifestPath)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Manifest parsing failed",
+				Content: fmt.Sprintf("## ❌ Manifest Parsing Failed\n\n**Error:** %s\n\n**Manifest File:** %s", err.Error(), manifestPath),
+			}
+		}
+
+		if violations := cs.images.Check(parsed); len(violations) > 0 {
+			return imagePolicyResponse(serviceName, manifestPath, violations)
+		}
+
+		// HPAs would fight the fixed replica count
+		cs.mutator.Mutate(serviceName, parsed)
+		cs.security.Mutate(parsed)
+		parsed.HorizontalPodAutoscalers = nil
+
+		report, err := cs.policy.Evaluate(parsed)
+		if err != nil {
+			return failedResponse("Policy evaluation failed", "Policy Evaluation Failed", err)
+		}
+		if report.Violated() && report.Mode == PolicyEnforce {
+			return policyBlockedResponse(serviceName, manifestPath, report)
+		}
+
+		err = cs.k8s.DeployFromParsedManifest(ctx, namespaceName, parsed)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Manifest deployment failed",
+				Content: fmt.Sprintf("## ❌ Manifest Deployment Failed\n\n**Error:** %s\n\n**Manifest File:** %s", err.Error(), manifestPath),
+			}
+		}
+
+		for _, dep := range parsed.Deployments {
+			deploymentNames = append(deploymentNames, dep.Name)
+		}
+		if len(parsed.Services) > 0 && len(parsed.Services[0].Spec.Ports) > 0 {
+			svc := parsed.Services[0]
+			target = fmt.Sprintf("http://%s.%s.svc.cluster.local:%d/", svc.Name, namespaceName, svc.Spec.Ports[0].Port)
+		}
+	} else {
+		err = cs.k8s.DeployTestPod(ctx, namespaceName, cleanServiceName, nil)
+		if err == nil {
+			err = cs.k8s.CreateService(ctx, namespaceName, cleanServiceName)
+		}
+		if err != nil {
+			return failedResponse("Load test deployment failed", "Load Test Deployment Failed", err)
+		}
+		deploymentNames = []string{cleanServiceName}
+	}
+
+	for _, name := range deploymentNames {
+		err = cs.k8s.ScaleDeployment(ctx, namespaceName, name, replicas)
+		if err != nil {
+			return failedResponse("Scaling failed", "Scaling Failed", err)
+		}
+	}
+
+	jobName := fmt.Sprintf("%s-loadtest", cleanServiceName)
+	err = cs.k8s.CreateLoadTestJob(ctx, namespaceName, jobName, cs.config.LoadTest.Image, target, cs.config.LoadTest.Rate, duration)
+	if err != nil {
+		return failedResponse("Load test job failed", "Load Test Job Failed", err)
+	}
+
+	// Collect the vegeta report once the attack finishes (non-blocking)
+	go cs.reportLoadTest(cmd, namespaceName, jobName, duration)
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Load test started",
+		Content: fmt.Sprintf("## 🔥 Load Test Started\n\n**👤 Triggered by:** @%s\n**🎯 Service:** %s\n**🔗 PR:** #%d\n**📦 Namespace:** `%s`\n\n### ⚙️ Parameters\n- **Replicas:** %d\n- **Duration:** %s\n- **Rate:** %d req/s\n- **Target:** `%s`\n\n*A latency/throughput summary will follow once the load test completes.*",
+			cmd.User, serviceName, cmd.PRNumber, namespaceName, replicas, duration, cs.config.LoadTest.Rate, target),
+		Data: map[string]interface{}{
+			"service":   serviceName,
+			"namespace": namespaceName,
+			"job":       jobName,
+			"replicas":  replicas,
+			"duration":  duration.String(),
+			"rate":      cs.config.LoadTest.Rate,
+			"target":    target,
+			"pr_number": cmd.PRNumber,
+			"status":    "running",
+		},
+	}
+}
+
+// parseLoadTestArgs validates --replicas and --duration against the configured quotas
+func (cs *CommandServiceK8s) parseLoadTestArgs(args map[string]string) (int32, time.Duration, error) {
+	replicas := int32(2)
+	if value, ok := args["replicas"]; ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("invalid --replicas value: %s", SanitizeEcho(value))
+		}
+		replicas = int32(parsed)
+	}
+	if replicas > cs.config.LoadTest.MaxReplicas {
+		return 0, 0, fmt.Errorf("--replicas=%d exceeds the quota of %d", replicas, cs.config.LoadTest.MaxReplicas)
+	}
+
+	duration := 5 * time.Minute
+	if value, ok := args["duration"]; ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return 0, 0, fmt.Errorf("invalid --duration value: %s", SanitizeEcho(value))
+		}
+		duration = parsed
+	}
+	if duration > cs.config.LoadTest.MaxDuration {
+		return 0, 0, fmt.Errorf("--duration=%s exceeds the quota of %s", duration, cs.config.LoadTest.MaxDuration)
+	}
+
+	return replicas, duration, nil
+}
+
+func (cs *CommandServiceK8s) reportLoadTest(cmd *types.Command, namespace, jobName string, duration time.Duration) {
+	ctx := context.Background()
+
+	var summary string
+	if err := cs.k8s.WaitForJob(ctx, namespace, jobName, duration+5*time.Minute); err != nil {
+		summary = fmt.Sprintf("## ❌ Load Test Did Not Complete\n\n**📦 Namespace:** `%s`\n**Error:** %s", namespace, err.Error())
+	} else if report, err := cs.k8s.GetJobLogs(ctx, namespace, jobName); err != nil {
+		summary = fmt.Sprintf("## ❌ Load Test Report Unavailable\n\n**📦 Namespace:** `%s`\n**Error:** %s", namespace, err.Error())
+	} else {
+		summary = fmt.Sprintf("## 📈 Load Test Summary\n\n**🎯 Service:** %s\n**📦 Namespace:** `%s`\n\n```\n%s\n```", cmd.Service, namespace, strings.TrimSpace(report))
+	}
+
+	if err := cs.comments.PostComment(ctx, cmd.Repo, cmd.PRNumber, summary); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// HandleListPreviewsK8s lists every preview namespace in the cluster
+func (cs *CommandServiceK8s) HandleListPreviewsK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return failedResponse("Listing previews failed", "Listing Previews Failed", err)
+	}
+
+	if len(namespaces) == 0 {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "No previews",
+			Content: fmt.Sprintf("## 📋 Active Previews\n\nNo preview environments are running.\n\n*Requested by: @%s*", cmd.User),
+			Data: map[string]interface{}{
+				"previews": namespaces,
+			},
+		}
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].CreatedAt.Before(namespaces[j].CreatedAt)
+	})
+
+	var content strings.Builder
+	content.WriteString("## 📋 Active Previews\n\n")
+	content.WriteString("| Namespace | PR | Service | Age | Status |\n")
+	content.WriteString("|-----------|----|---------|-----|--------|\n")
+	for _, ns := range namespaces {
+		content.WriteString(fmt.Sprintf("| `%s` | #%d | %s | %s | %s |\n",
+			ns.Name, ns.PRNumber, ns.Service, namespaceAge(ns), ns.Status))
+	}
+	content.WriteString(fmt.Sprintf("\n**Total:** %d\n\n*Requested by: @%s*", len(namespaces), cmd.User))
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Active previews",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"previews": namespaces,
+		},
+	}
+}
+
+// HandleClusterInfoK8s reports cluster-wide capacity and preview counts
+func (cs *CommandServiceK8s) HandleClusterInfoK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	info, err := cs.k8s.GetClusterInfo(ctx)
+	if err != nil {
+		return failedResponse("Cluster info failed", "Cluster Info Failed", err)
+	}
+
+	warmPool := ""
+	if cs.config.WarmPool.Size > 0 {
+		pool, err := cs.k8s.GetWarmPoolStatus(ctx)
+		if err != nil {
+			return failedResponse("Cluster info failed", "Cluster Info Failed", err)
+		}
+		info["warm_pool"] = pool
+		warmPool = fmt.Sprintf("\n- **Warm pool:** %d ready, %d preparing (target %d)", len(pool.Ready), len(pool.Pending), cs.config.WarmPool.Size)
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Cluster info",
+		Content: fmt.Sprintf("## ☸️ Cluster Info\n\n- **Nodes:** %v\n- **Namespaces:** %v\n- **Preview namespaces:** %v%s\n- **Connection:** %v\n\n*Requested by: @%s*",
+			info["nodes_count"], info["namespaces_count"], info["preview_namespaces"], warmPool, info["connection_status"], cmd.User),
+		Data: info,
+	}
+}
+
+// HandleGarbageCollectK8s deletes preview namespaces older than --older-than
+// (default: past each preview's expiry) across all PRs. With access tracking,
+// previews nobody visited for --idle-for (default ACCESS_IDLE_AFTER) go
+// first, least recently visited first. --dry-run=true only reports.
+func (cs *CommandServiceK8s) HandleGarbageCollectK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	maxAge := cs.config.Preview.GCMaxAge
+	explicitAge := false
+	if value, ok := cmd.Args["older-than"]; ok {
+		explicitAge = true
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Invalid arguments",
+				Content: fmt.Sprintf("## ❌ Invalid GC Arguments\n\n**Error:** invalid --older-than value: %s\n\n**Usage:** `/gc --older-than=72h --dry-run=true`", SanitizeEcho(value)),
+			}
+		}
+		maxAge = parsed
+	}
+	idleFor := cs.config.Access.IdleAfter
+	if value, ok := cmd.Args["idle-for"]; ok {
+		parsed, err := ParseTTL(value)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Invalid arguments",
+				Content: fmt.Sprintf("## ❌ Invalid GC Arguments\n\n**Error:** invalid --idle-for value: %s\n\n**Usage:** `/gc --idle-for=3d --dry-run=true`", SanitizeEcho(value)),
+			}
+		}
+		idleFor = parsed
+	}
+	if !cs.config.Access.Tracking {
+		idleFor = 0
+	}
+	dryRun := cmd.Args["dry-run"] == "true"
+	criterion := "past their TTL"
+	if explicitAge {
+		criterion = fmt.Sprintf("older than %s", maxAge)
+	}
+	if idleFor > 0 {
+		criterion += fmt.Sprintf(" or unvisited for %s", formatAge(idleFor))
+	}
+
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return failedResponse("GC failed", "Garbage Collection Failed", err)
+	}
+
+	type idlePreview struct {
+		name       string
+		lastActive time.Time
+	}
+	var idle []idlePreview
+	var stale []string
+	for _, ns := range namespaces {
+		name := ns.Name
+		if lastActive, ok := cs.previewLastActive(ctx, ns); ok && idleFor > 0 && time.Since(lastActive) >= idleFor && name != "" {
+			idle = append(idle, idlePreview{name: name, lastActive: lastActive})
+			continue
+		}
+		if !explicitAge {
+			if expiresAt, ok := cs.previewExpiry(ns); !ok || time.Now().Before(expiresAt) {
+				continue
+			}
+		} else if ns.CreatedAt.IsZero() || time.Since(ns.CreatedAt) < maxAge {
+			continue
+		}
+		if name != "" {
+			stale = append(stale, name)
+		}
+	}
+
+	// Nobody is waiting on previews nobody visits, so they go first
+	sort.Slice(idle, func(i, j int) bool {
+		return idle[i].lastActive.Before(idle[j].lastActive)
+	})
+	var idleNames []string
+	for _, preview := range idle {
+		idleNames = append(idleNames, preview.name)
+	}
+	stale = append(idleNames, stale...)
+
+	if len(stale) == 0 {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "Nothing to collect",
+			Content: fmt.Sprintf("## 🗑️ Garbage Collection\n\nNo preview environments are %s.\n\n*Triggered by: @%s*", criterion, cmd.User),
+		}
+	}
+
+	if dryRun {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "GC dry run",
+			Content: fmt.Sprintf("## 🗑️ Garbage Collection (Dry Run)\n\nThese preview environments are %s and would be deleted:\n\n%s\n*Triggered by: @%s*", criterion, formatNamespaceList(stale), cmd.User),
+			Data: map[string]interface{}{
+				"stale_namespaces": stale,
+				"idle_namespaces":  idleNames,
+				"dry_run":          true,
+			},
+		}
+	}
+
+	cs.revokeVaultLeases(ctx, stale)
+
+	var deleted, failed []string
+	for _, name := range stale {
+		if err := cs.k8s.DeleteNamespace(ctx, name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		deleted = append(deleted, name)
+	}
+	released := cs.releaseSharedDependencies(ctx, cmd.Repo, cmd.PRNumber, deleted)
+
+	notes := ""
+	if len(released) > 0 {
+		notes = fmt.Sprintf("\n### 🔗 Shared Dependencies Removed\n%s", formatNamespaceList(released))
+	}
+	if len(failed) > 0 {
+		notes += fmt.Sprintf("\n### ⚠️ Failed\n%s", cs.formatResourcesList(failed))
+	}
+
+	return &types.CommandResponse{
+		Success: len(failed) == 0,
+		Message: "GC completed",
+		Content: fmt.Sprintf("## 🗑️ Garbage Collection Completed\n\nDeleted %d preview environments %s:\n\n%s%s\n*Terraform workspaces are left for each PR's `/cleanup`.*\n\n*Triggered by: @%s*",
+			len(deleted), criterion, formatNamespaceList(deleted), notes, cmd.User),
+		Data: map[string]interface{}{
+			"deleted_namespaces":   deleted,
+			"deleted_dependencies": released,
+			"idle_namespaces":      idleNames,
+			"failed":               failed,
+		},
+	}
+}
+
+func namespaceAge(ns PreviewNamespace) string {
+	if ns.CreatedAt.IsZero() {
+		return "unknown"
+	}
+	return time.Since(ns.CreatedAt).Round(time.Minute).String()
+}
+
+// PreviewCount returns the number of preview namespaces in the cluster
+func (cs *CommandServiceK8s) PreviewCount(ctx context.Context) (int, error) {
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(namespaces), nil
+}
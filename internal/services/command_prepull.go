@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"pr-previews/internal/types"
+)
+
+const (
+	prePullDaemonSetName = "image-prepull"
+	prePullPollInterval  = 3 * time.Second
+)
+
+// PrePullResult records how the image warm-up went
+type PrePullResult struct {
+	Images    []string
+	Durations map[string][]string // image -> per-node pull duration
+	Failed    []string
+	Elapsed   time.Duration
+	TimedOut  bool
+}
+
+// prePullImages warms the manifest's images on the preview node pool in the
+// background, alongside the deployment, so nodes the pods later move to
+// already have them. It returns the images being warmed; how it went is
+// logged to the timeline, and failures never block the deployment.
+func (cs *CommandServiceK8s) prePullImages(cmd *types.Command, namespace string, parsed *ParsedManifest) []string {
+	if !cs.config.PrePull.Enabled || parsed == nil {
+		return nil
+	}
+
+	images, pullSecrets := manifestImages(parsed)
+	if len(images) == 0 {
+		return nil
+	}
+
+	nodeSelector := make(map[string]string)
+	for _, pair := range cs.config.PrePull.NodeSelector {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			nodeSelector[key] = value
+		}
+	}
+
+	go func() {
+		result := cs.runPrePull(namespace, images, pullSecrets, nodeSelector)
+		if len(result.Failed) > 0 {
+			fmt.Printf("Warning: image pre-pull for PR #%d: %s\n", cmd.PRNumber, strings.Join(result.Failed, "; "))
+		}
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "%s", formatPrePullResult(result))
+	}()
+	return images
+}
+
+// runPrePull runs the pre-pull DaemonSet until every image is on every node,
+// one fails to pull or the timeout passes
+func (cs *CommandServiceK8s) runPrePull(namespace string, images []string, pullSecrets []corev1.LocalObjectReference, nodeSelector map[string]string) *PrePullResult {
+	ctx, cancel := context.WithTimeout(context.Background(), cs.config.PrePull.Timeout)
+	defer cancel()
+
+	result := &PrePullResult{Images: images}
+	start := time.Now()
+
+	err := cs.k8s.CreatePrePullDaemonSet(ctx, namespace, prePullDaemonSetName, cs.config.PrePull.PauseImage, images, pullSecrets, nodeSelector)
+	if err != nil {
+		result.Failed = append(result.Failed, err.Error())
+		return result
+	}
+	defer func() {
+		if err := cs.k8s.DeleteDaemonSet(context.Background(), namespace, prePullDaemonSetName); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}()
+
+	ticker := time.NewTicker(prePullPollInterval)
+	defer ticker.Stop()
+
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			result.TimedOut = true
+			break wait
+		case <-ticker.C:
+			done, failed, err := cs.k8s.PrePullProgress(ctx, namespace, prePullDaemonSetName)
+			if err != nil {
+				continue
+			}
+			if len(failed) > 0 {
+				result.Failed = failed
+				break wait
+			}
+			if done {
+				break wait
+			}
+		}
+	}
+	result.Elapsed = time.Since(start).Round(time.Second)
+
+	durations, err := cs.k8s.GetImagePullDurations(context.Background(), namespace, prePullDaemonSetName)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	result.Durations = durations
+
+	return result
+}
+
+// manifestImages returns the unique images and pull secrets of every workload
+func manifestImages(parsed *ParsedManifest) ([]string, []corev1.LocalObjectReference) {
+	seen := make(map[string]bool)
+	seenSecrets := make(map[string]bool)
+	var images []string
+	var pullSecrets []corev1.LocalObjectReference
+
+	collect := func(spec corev1.PodSpec) {
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			if container.Image != "" && !seen[container.Image] {
+				seen[container.Image] = true
+				images = append(images, container.Image)
+			}
+		}
+		for _, secret := range spec.ImagePullSecrets {
+			if !seenSecrets[secret.Name] {
+				seenSecrets[secret.Name] = true
+				pullSecrets = append(pullSecrets, secret)
+			}
+		}
+	}
+
+	for _, dep := range parsed.Deployments {
+		collect(dep.Spec.Template.Spec)
+	}
+	for _, sts := range parsed.StatefulSets {
+		collect(sts.Spec.Template.Spec)
+	}
+
+	sort.Strings(images)
+	return images, pullSecrets
+}
+
+// formatPrePullResult summarizes a finished pre-pull on one line
+func formatPrePullResult(result *PrePullResult) string {
+	var summary string
+	switch {
+	case len(result.Failed) > 0:
+		summary = fmt.Sprintf("Image pre-pull failed: %s", strings.Join(result.Failed, "; "))
+	case result.TimedOut:
+		summary = fmt.Sprintf("Image pre-pull not finished after %s", result.Elapsed)
+	default:
+		summary = fmt.Sprintf("Image pre-pull warmed %d image(s) in %s", len(result.Images), result.Elapsed)
+	}
+
+	var pulls []string
+	for _, image := range result.Images {
+		if durations := result.Durations[image]; len(durations) > 0 {
+			pulls = append(pulls, fmt.Sprintf("%s: %s", image, strings.Join(durations, ", ")))
+		}
+	}
+	if len(pulls) > 0 {
+		summary += " (" + strings.Join(pulls, "; ") + ")"
+	}
+	return summary
+}
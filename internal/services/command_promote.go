@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// HandlePromoteK8s re-applies the preview's last rendered manifest into the
+// shared staging namespace, recording where it came from
+func (cs *CommandServiceK8s) HandlePromoteK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	sourceNamespace := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+	stagingNamespace := cs.config.Preview.StagingNS
+
+	// Artifacts are keyed by the per-service name in either layout
+	artifactKey, err := cs.latestManifestArtifact(ctx, cmd.PRNumber, previewNamespace(cmd.PRNumber, cleanServiceName, false))
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Promotion failed",
+			Content: fmt.Sprintf("## ❌ Promotion Failed\n\n**Service:** `%s`\n**Error:** %s\n\n*Run `/preview %s` first so there is a rendered manifest to promote.*", cmd.Service, err.Error(), cmd.Service),
+		}
+	}
+
+	content, err := cs.artifacts.Get(ctx, artifactKey)
+	if err != nil {
+		return failedResponse("Promotion failed", "Promotion Failed", err)
+	}
+
+	parsed, err := NewManifestParser(nil).ParseManifestContent(content, artifactKey)
+	if err != nil {
+		return failedResponse("Promotion failed", "Promotion Failed", err)
+	}
+
+	// Secret values are redacted in artifacts and HPAs are a staging concern
+	parsed.Secrets = nil
+	parsed.HorizontalPodAutoscalers = nil
+	stripPreviewLabels(parsed)
+
+	err = cs.k8s.EnsureNamespace(ctx, stagingNamespace, map[string]string{
+		"environment": "staging",
+		"managed-by":  "pr-previews",
+	})
+	if err != nil {
+		return failedResponse("Promotion failed", "Promotion Failed", err)
+	}
+
+	labels := map[string]string{
+		"managed-by":         "pr-previews",
+		"environment":        "staging",
+		"promoted-from-pr":   fmt.Sprintf("%d", cmd.PRNumber),
+		"promoted-service":   cleanServiceName,
+		"preview-deployment": "false",
+	}
+	annotations := map[string]string{
+		"pr-previews.io/promoted-by":       cmd.User,
+		"pr-previews.io/promoted-at":       time.Now().UTC().Format(time.RFC3339),
+		"pr-previews.io/promoted-from":     sourceNamespace,
+		"pr-previews.io/promoted-artifact": artifactKey,
+		"pr-previews.io/promoted-repo":     cmd.Repo,
+	}
+
+	err = cs.k8s.ApplyParsedManifest(ctx, stagingNamespace, parsed, labels, annotations)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Promotion failed",
+			Content: fmt.Sprintf("## ❌ Promotion Failed\n\n**Error:** %s\n\n**Staging Namespace:** `%s`", err.Error(), stagingNamespace),
+		}
+	}
+
+	var promoted []string
+	for _, cm := range parsed.ConfigMaps {
+		promoted = append(promoted, fmt.Sprintf("ConfigMap/%s", cm.Name))
+	}
+	for _, dep := range parsed.Deployments {
+		promoted = append(promoted, fmt.Sprintf("Deployment/%s", dep.Name))
+	}
+	for _, sts := range parsed.StatefulSets {
+		promoted = append(promoted, fmt.Sprintf("StatefulSet/%s", sts.Name))
+	}
+	for _, svc := range parsed.Services {
+		promoted = append(promoted, fmt.Sprintf("Service/%s", svc.Name))
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Preview promoted to staging",
+		Content: fmt.Sprintf("## 🚢 Preview Promoted to Staging\n\n**👤 Promoted by:** @%s\n**🎯 Service:** %s\n**🔗 PR:** #%d\n**📦 From:** `%s`\n**🏁 To:** `%s`\n\n### 📊 Resources Applied\n%s\n**Provenance:** `%s`\n\n*Secrets are not promoted; make sure staging already has them.*",
+			cmd.User, cmd.Service, cmd.PRNumber, sourceNamespace, stagingNamespace, cs.formatResourcesList(promoted), artifactKey),
+		Data: map[string]interface{}{
+			"service":           cmd.Service,
+			"pr_number":         cmd.PRNumber,
+			"source_namespace":  sourceNamespace,
+			"staging_namespace": stagingNamespace,
+			"artifact":          artifactKey,
+			"promoted":          promoted,
+		},
+	}
+}
+
+// latestManifestArtifact finds the newest rendered manifest for a preview namespace
+func (cs *CommandServiceK8s) latestManifestArtifact(ctx context.Context, prNumber int, namespace string) (string, error) {
+	keys, err := cs.artifacts.List(ctx, fmt.Sprintf("pr-%d/", prNumber))
+	if err != nil {
+		return "", err
+	}
+
+	// Keys start with a UTC timestamp, so the last match is the newest
+	latest := ""
+	suffix := fmt.Sprintf("-%s/manifest.yaml", namespace)
+	for _, key := range keys {
+		if strings.HasSuffix(key, suffix) && key > latest {
+			latest = key
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no rendered manifest found for %s", namespace)
+	}
+	return latest, nil
+}
+
+// stripPreviewLabels drops the preview label so promoted objects aren't
+// mistaken for (and cleaned up with) preview resources
+func stripPreviewLabels(parsed *ParsedManifest) {
+	for i := range parsed.ConfigMaps {
+		delete(parsed.ConfigMaps[i].Labels, "preview")
+	}
+	for i := range parsed.Deployments {
+		delete(parsed.Deployments[i].Labels, "preview")
+		delete(parsed.Deployments[i].Spec.Template.Labels, "preview")
+	}
+	for i := range parsed.StatefulSets {
+		delete(parsed.StatefulSets[i].Labels, "preview")
+		delete(parsed.StatefulSets[i].Spec.Template.Labels, "preview")
+	}
+	for i := range parsed.Services {
+		delete(parsed.Services[i].Labels, "preview")
+	}
+	for i := range parsed.PersistentVolumeClaims {
+		delete(parsed.PersistentVolumeClaims[i].Labels, "preview")
+	}
+}
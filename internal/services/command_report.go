@@ -0,0 +1,282 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreviewReportEntry is one active preview in the stale-preview report
+type PreviewReportEntry struct {
+	Namespace  string        `json:"namespace"`
+	Repo       string        `json:"repo,omitempty"`
+	PRNumber   int           `json:"pr_number"`
+	Service    string        `json:"service"`
+	Owner      string        `json:"owner"`
+	Status     string        `json:"status"`
+	CreatedAt  string        `json:"created_at"`
+	Age        time.Duration `json:"-"`
+	AgeHours   float64       `json:"age_hours"`
+	CPU        float64       `json:"cpu_cores"`
+	MemoryGB   float64       `json:"memory_gb"`
+	CostSoFar  float64       `json:"cost_so_far"`
+	WeeklyCost float64       `json:"weekly_cost"`
+	CleanupURL string        `json:"cleanup_url,omitempty"`
+}
+
+// BuildPreviewReport lists active previews, oldest first, with their owner
+// and an estimated cost based on resource requests
+func (cs *CommandServiceK8s) BuildPreviewReport(ctx context.Context) ([]PreviewReportEntry, error) {
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []PreviewReportEntry
+	for _, ns := range namespaces {
+		name, service, prNumber, createdAt := ns.Name, ns.Service, ns.PRNumber, ns.CreatedAt
+		if createdAt.IsZero() {
+			continue
+		}
+
+		cpu, memory, err := cs.k8s.GetNamespaceRequests(ctx, name)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		hourlyCost := cs.hourlyCost(cpu, memory)
+		age := time.Since(createdAt)
+
+		entry := PreviewReportEntry{
+			Namespace:  name,
+			Repo:       ns.Repo,
+			PRNumber:   prNumber,
+			Service:    service,
+			Owner:      ns.Owner,
+			Status:     ns.Status,
+			CreatedAt:  createdAt.Format(time.RFC3339),
+			Age:        age,
+			AgeHours:   age.Hours(),
+			CPU:        cpu,
+			MemoryGB:   memory,
+			CostSoFar:  hourlyCost * age.Hours(),
+			WeeklyCost: hourlyCost * 24 * 7,
+		}
+		if cs.config.Report.CleanupURLTemplate != "" {
+			entry.CleanupURL = renderURLTemplate(cs.config.Report.CleanupURLTemplate, name, service, prNumber)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Age > entries[j].Age
+	})
+	return entries, nil
+}
+
+// InventoryFilter narrows the preview inventory down to one repository and
+// to previews created within [From, To); zero times leave that end open
+type InventoryFilter struct {
+	Repo string
+	From time.Time
+	To   time.Time
+}
+
+// PreviewInventory is the preview report for export, filtered, in the
+// order of BuildPreviewReport
+func (cs *CommandServiceK8s) PreviewInventory(ctx context.Context, filter InventoryFilter) ([]PreviewReportEntry, error) {
+	entries, err := cs.BuildPreviewReport(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := []PreviewReportEntry{}
+	for _, entry := range entries {
+		if filter.Repo != "" && !strings.EqualFold(entry.Repo, filter.Repo) {
+			continue
+		}
+		createdAt, _ := time.Parse(time.RFC3339, entry.CreatedAt)
+		if !filter.From.IsZero() && createdAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !createdAt.Before(filter.To) {
+			continue
+		}
+		inventory = append(inventory, entry)
+	}
+	return inventory, nil
+}
+
+// previewInventoryColumns are the CSV columns of the preview inventory
+var previewInventoryColumns = []string{
+	"namespace", "repo", "pr_number", "service", "owner", "status", "created_at",
+	"age_hours", "cpu_cores", "memory_gb", "cost_so_far", "weekly_cost",
+}
+
+// WritePreviewInventoryCSV writes the inventory with a header row, for
+// spreadsheets and FinOps tooling
+func WritePreviewInventoryCSV(w io.Writer, entries []PreviewReportEntry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(previewInventoryColumns); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		record := []string{
+			entry.Namespace,
+			entry.Repo,
+			strconv.Itoa(entry.PRNumber),
+			entry.Service,
+			entry.Owner,
+			entry.Status,
+			entry.CreatedAt,
+			strconv.FormatFloat(entry.AgeHours, 'f', 2, 64),
+			strconv.FormatFloat(entry.CPU, 'f', 3, 64),
+			strconv.FormatFloat(entry.MemoryGB, 'f', 3, 64),
+			strconv.FormatFloat(entry.CostSoFar, 'f', 2, 64),
+			strconv.FormatFloat(entry.WeeklyCost, 'f', 2, 64),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// hourlyCost estimates what the requested CPU cores and memory GiB cost
+func (cs *CommandServiceK8s) hourlyCost(cpu, memory float64) float64 {
+	return cpu*cs.config.Report.CPUHourCost + memory*cs.config.Report.MemoryGBHourCost
+}
+
+// FormatPreviewReport renders the report as a markdown comment
+func FormatPreviewReport(entries []PreviewReportEntry) string {
+	var content strings.Builder
+	content.WriteString("## 🗓️ Active Preview Report\n\n")
+
+	if len(entries) == 0 {
+		content.WriteString("No preview environments are running. 🎉\n")
+		return content.String()
+	}
+
+	var totalSoFar, totalWeekly float64
+	content.WriteString("| Namespace | PR | Owner | Age | Requests | Cost so far | Weekly | Cleanup |\n")
+	content.WriteString("|-----------|----|-------|-----|----------|-------------|--------|---------|\n")
+	for _, entry := range entries {
+		owner := "unknown"
+		if entry.Owner != "" {
+			owner = "@" + entry.Owner
+		}
+		cleanup := "—"
+		if entry.CleanupURL != "" {
+			cleanup = fmt.Sprintf("[Clean up](%s)", entry.CleanupURL)
+		}
+		content.WriteString(fmt.Sprintf("| `%s` | #%d | %s | %s | %.2f CPU / %.1f GiB | $%.2f | $%.2f | %s |\n",
+			entry.Namespace, entry.PRNumber, owner, formatAge(entry.Age), entry.CPU, entry.MemoryGB, entry.CostSoFar, entry.WeeklyCost, cleanup))
+		totalSoFar += entry.CostSoFar
+		totalWeekly += entry.WeeklyCost
+	}
+
+	content.WriteString(fmt.Sprintf("\n**Total:** %d previews · $%.2f so far · $%.2f per week at current size\n\n", len(entries), totalSoFar, totalWeekly))
+	content.WriteString("*Costs are estimates from pod resource requests.*")
+	return content.String()
+}
+
+// PostPreviewReport builds the report and sends it to the configured issue
+// and/or Slack channel
+func (cs *CommandServiceK8s) PostPreviewReport(ctx context.Context) (string, error) {
+	entries, err := cs.BuildPreviewReport(ctx)
+	if err != nil {
+		return "", err
+	}
+	report := FormatPreviewReport(entries)
+
+	if issue := cs.config.Report.GitHubIssue; issue != "" {
+		repo, number, ok := strings.Cut(issue, "#")
+		issueNumber, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return report, fmt.Errorf("invalid REPORT_GITHUB_ISSUE %q: expected owner/repo#number", issue)
+		}
+		if err := cs.github.PostComment(ctx, repo, issueNumber, report); err != nil {
+			return report, err
+		}
+	}
+
+	if webhookURL := cs.config.Report.SlackWebhookURL; webhookURL != "" {
+		if err := postSlackMessage(ctx, webhookURL, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// StartPreviewReporter posts the report on REPORT_SCHEDULE until ctx is cancelled
+func (cs *CommandServiceK8s) StartPreviewReporter(ctx context.Context) {
+	if cs.config.Report.GitHubIssue == "" && cs.config.Report.SlackWebhookURL == "" {
+		return
+	}
+
+	schedule, err := ParseCron(cs.config.Report.Schedule)
+	if err != nil {
+		fmt.Printf("⚠️  Preview report disabled: %v\n", err)
+		return
+	}
+
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			if _, err := cs.PostPreviewReport(ctx); err != nil {
+				fmt.Printf("Warning: failed to post preview report: %v\n", err)
+			}
+		}
+	}
+}
+
+func postSlackMessage(ctx context.Context, webhookURL, text string) error {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to Slack: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post to Slack: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func formatAge(age time.Duration) string {
+	days := int(age.Hours()) / 24
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, int(age.Hours())%24)
+	}
+	return age.Round(time.Minute).String()
+}
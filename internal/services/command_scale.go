@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// ScaleChange is one Deployment /scale resized
+type ScaleChange struct {
+	Deployment string `json:"deployment"`
+	Before     int32  `json:"before"`
+	After      int32  `json:"after"`
+}
+
+// HandleScaleK8s sets the replica count of a preview's Deployments, clamped
+// to SCALE_MAX_REPLICAS, so reviewers can test behavior under concurrency
+func (cs *CommandServiceK8s) HandleScaleK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	requested, err := strconv.Atoi(cmd.Args["replicas"])
+	if err != nil || requested < 0 {
+		return failedResponse("Invalid scale arguments", "Invalid Scale Arguments",
+			fmt.Errorf("invalid replica count: %s\n\n**Usage:** `/scale <service> <replicas>`", SanitizeEcho(cmd.Args["replicas"])))
+	}
+	replicas, clamped := int32(requested), false
+	if limit := cs.config.Preview.ScaleMaxReplicas; requested > int(limit) {
+		replicas, clamped = limit, true
+	}
+
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	namespace := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err == nil && !exists {
+		err = fmt.Errorf("preview %s does not exist; run `/preview %s` first", namespace, cmd.Service)
+	}
+	if err != nil {
+		return failedResponse("Scaling failed", "Scaling Failed", err)
+	}
+
+	shared := namespace == previewNamespace(cmd.PRNumber, cleanServiceName, true)
+	changes, err := cs.k8s.ScaleServiceDeployments(ctx, namespace, cleanServiceName, shared, replicas)
+	if err != nil {
+		return failedResponse("Scaling failed", "Scaling Failed", err)
+	}
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "%s scaled to %d replicas by @%s", cmd.Service, replicas, cmd.User)
+
+	rows := make([][]string, 0, len(changes))
+	for _, change := range changes {
+		rows = append(rows, []string{"`" + change.Deployment + "`", strconv.Itoa(int(change.Before)), strconv.Itoa(int(change.After))})
+	}
+	summary := fmt.Sprintf("**%s** now runs **%d** replicas.", cmd.Service, replicas)
+	if clamped {
+		summary += fmt.Sprintf(" %d were requested, but previews are limited to %d.", requested, replicas)
+	}
+
+	return resultResponse(true, "Preview scaled", &types.Result{
+		Status:  types.StatusSuccess,
+		Icon:    "📏",
+		Title:   "Preview Scaled",
+		Summary: summary,
+		Sections: []types.Section{
+			{
+				Fields: []types.Field{
+					{Name: "👤 Triggered by", Value: "@" + cmd.User},
+					{Name: "🔗 PR", Value: fmt.Sprintf("#%d", cmd.PRNumber)},
+					{Name: "📦 Namespace", Value: fmt.Sprintf("`%s`", namespace)},
+				},
+			},
+			{
+				Table: &types.Table{Columns: []string{"Deployment", "Before", "After"}, Rows: rows},
+			},
+		},
+		Footer: "*Redeploying the preview resets the replica count.*",
+	}, map[string]interface{}{
+		"service":   cmd.Service,
+		"namespace": namespace,
+		"requested": requested,
+		"replicas":  replicas,
+		"clamped":   clamped,
+		"changes":   changes,
+		"pr_number": cmd.PRNumber,
+	})
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// Preview backends a service can be deployed with
+const (
+	BackendManifest  = "manifest"
+	BackendHelm      = "helm"
+	BackendKustomize = "kustomize"
+	BackendChart     = "helm-chart" // pinned remote chart from the repo config
+	BackendDefault   = "default"
+)
+
+// DiscoveredService is a service found in the repo and how it would deploy
+type DiscoveredService struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	Path    string `json:"path"`              // manifest file or chart/kustomization directory
+	Changed *bool  `json:"changed,omitempty"` // nil when the PR's files are unknown
+}
+
+// Directories searched for each kind of service definition
+var (
+	manifestDirs  = []string{"k8s", "kubernetes", "manifests", "deploy"}
+	chartDirs     = []string{"charts", "helm", "deploy", "k8s"}
+	kustomizeDirs = []string{"kustomize", "k8s", "deploy", "overlays"}
+)
+
+// DiscoverServices finds manifests, Helm charts and kustomizations in the repo
+func (cs *CommandServiceK8s) DiscoverServices(repoPath string) []DiscoveredService {
+	var paths []string
+	for _, dir := range append(append(append([]string{}, manifestDirs...), chartDirs...), kustomizeDirs...) {
+		for _, pattern := range []string{"*", "*/*"} {
+			files, _ := filepath.Glob(filepath.Join(repoPath, dir, pattern))
+			for _, file := range files {
+				if rel, err := filepath.Rel(repoPath, file); err == nil {
+					paths = append(paths, filepath.ToSlash(rel))
+				}
+			}
+		}
+	}
+	if composePath := findComposeFile(repoPath); composePath != "" {
+		paths = append(paths, filepath.Base(composePath))
+	}
+	return cs.DiscoverServicesInPaths(paths)
+}
+
+// DiscoverServicesInPaths finds services among repo-relative, slash-separated
+// file paths, e.g. a GitHub tree listing
+func (cs *CommandServiceK8s) DiscoverServicesInPaths(paths []string) []DiscoveredService {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	matching := func(pattern string) []string {
+		var matches []string
+		for _, p := range sorted {
+			if ok, _ := path.Match(pattern, p); ok {
+				matches = append(matches, p)
+			}
+		}
+		return matches
+	}
+
+	var discovered []DiscoveredService
+	seen := make(map[string]bool)
+	add := func(name, backend, location string) {
+		key := backend + "/" + name
+		if name == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		discovered = append(discovered, DiscoveredService{Name: name, Backend: backend, Path: location})
+	}
+
+	for _, scanPath := range manifestDirs {
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			for _, file := range matching(scanPath + "/" + pattern) {
+				// A kustomization file describes its directory, not a service
+				if strings.HasPrefix(path.Base(file), "kustomization.") {
+					continue
+				}
+				add(cs.extractServiceNameFromPath(file), BackendManifest, file)
+			}
+		}
+	}
+
+	for _, scanPath := range chartDirs {
+		for _, chart := range matching(scanPath + "/*/Chart.yaml") {
+			dir := path.Dir(chart)
+			add(path.Base(dir), BackendHelm, dir)
+		}
+	}
+
+	for _, scanPath := range kustomizeDirs {
+		for _, name := range []string{"kustomization.yaml", "kustomization.yml"} {
+			for _, kustomization := range matching(scanPath + "/*/" + name) {
+				dir := path.Dir(kustomization)
+				add(path.Base(dir), BackendKustomize, dir)
+			}
+		}
+	}
+
+	// The first compose file in lookup order wins, like findComposeFile
+	present := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		present[p] = true
+	}
+	for _, name := range composeFileNames {
+		if present[name] {
+			add(composeServiceName, BackendCompose, name)
+			break
+		}
+	}
+
+	sort.Slice(discovered, func(i, j int) bool {
+		if discovered[i].Name != discovered[j].Name {
+			return discovered[i].Name < discovered[j].Name
+		}
+		return discovered[i].Backend < discovered[j].Backend
+	})
+	return discovered
+}
+
+// serviceChanged reports whether any changed file belongs to the service:
+// its manifest or chart directory, or a source directory named after it
+func serviceChanged(service DiscoveredService, changedFiles []string) bool {
+	prefixes := []string{
+		service.Name + "/",
+		"services/" + service.Name + "/",
+		"apps/" + service.Name + "/",
+		"cmd/" + service.Name + "/",
+	}
+	if service.Backend == BackendChart {
+		// The chart lives elsewhere; its pin and values live in the repo config
+		for _, file := range changedFiles {
+			if file == repoConfigFile {
+				return true
+			}
+		}
+	} else if service.Backend == BackendManifest || service.Backend == BackendCompose {
+		for _, file := range changedFiles {
+			if file == service.Path {
+				return true
+			}
+		}
+	} else {
+		prefixes = append(prefixes, service.Path+"/")
+	}
+
+	for _, file := range changedFiles {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(file, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HandleServicesK8s lists every deployable service, its backend and whether
+// the PR touches it
+func (cs *CommandServiceK8s) HandleServicesK8s(ctx context.Context, cmd *types.Command, repoPath string) *types.CommandResponse {
+	discovered := cs.DiscoverServices(repoPath)
+	var rules ChangeRules
+	if repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath); err == nil {
+		rules = repoConfig.Changes
+		names := make([]string, 0, len(repoConfig.Charts))
+		for name := range repoConfig.Charts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			discovered = append(discovered, DiscoveredService{Name: name, Backend: BackendChart, Path: repoConfig.Charts[name].Ref()})
+		}
+	}
+
+	changedFiles, err := cs.github.ListPullRequestFiles(ctx, cmd.Repo, cmd.PRNumber)
+	changeNote := ""
+	if err != nil {
+		changeNote = fmt.Sprintf("\n\n*Change detection unavailable: %s*", err.Error())
+	} else {
+		for i := range discovered {
+			changed := rules.ServiceChanged(discovered[i], changedFiles)
+			discovered[i].Changed = &changed
+		}
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## 📦 Deployable Services\n\n**🔗 PR:** #%d\n\n", cmd.PRNumber))
+	content.WriteString("| Service | Backend | Source | Changed in PR |\n")
+	content.WriteString("|---------|---------|--------|---------------|\n")
+	for _, service := range discovered {
+		backend := service.Backend
+		if backend == BackendHelm || backend == BackendKustomize {
+			backend += " (not yet deployable)"
+		}
+		content.WriteString(fmt.Sprintf("| `%s` | %s | `%s` | %s |\n", service.Name, backend, service.Path, formatChanged(service.Changed)))
+	}
+	content.WriteString(fmt.Sprintf("| `nginx` | %s | built-in `nginx:alpine` | — |\n", BackendDefault))
+
+	if _, err := os.Stat(filepath.Join(repoPath, repoConfigFile)); err == nil {
+		content.WriteString(fmt.Sprintf("\n*Sizing and secrets also come from `%s`.*", repoConfigFile))
+	}
+	content.WriteString(changeNote)
+	content.WriteString(fmt.Sprintf("\n\n*Deploy one with `/preview <service>`. Requested by: @%s*", cmd.User))
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Deployable services",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"pr_number": cmd.PRNumber,
+			"services":  discovered,
+		},
+	}
+}
+
+func formatChanged(changed *bool) string {
+	switch {
+	case changed == nil:
+		return "❔"
+	case *changed:
+		return "✏️ yes"
+	default:
+		return "no"
+	}
+}
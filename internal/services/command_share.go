@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// HandleShareK8s mints a share link to a preview, or with --revoke, ends the
+// PR's links: one with --id, a service's, or all of them
+func (cs *CommandServiceK8s) HandleShareK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	if !cs.config.Share.Enabled {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Share links are disabled",
+			Content: "## ❌ Share Links Disabled\n\nShare links are not enabled on this installation (`SHARE_ENABLED=true`).",
+		}
+	}
+	if cmd.Args["revoke"] == "true" {
+		return cs.revokeShareLinks(ctx, cmd)
+	}
+	if cmd.Service == "" {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Invalid share arguments",
+			Content: "## ❌ Invalid Share Arguments\n\n**Error:** name the service to share\n\n**Usage:** `/share <service> --expires=24h`",
+		}
+	}
+	if cs.config.Share.Host == "" {
+		return failedResponse("Share failed", "Share Failed", fmt.Errorf("SHARE_HOST must be set for share links to point at this server"))
+	}
+
+	expiry := cs.config.Share.DefaultExpiry
+	if value := cmd.Args["expires"]; value != "" {
+		var err error
+		if expiry, err = ParseTTL(value); err != nil || expiry > cs.config.Share.MaxExpiry {
+			if err == nil {
+				err = fmt.Errorf("share links last at most %s", formatAge(cs.config.Share.MaxExpiry))
+			}
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Invalid share arguments",
+				Content: fmt.Sprintf("## ❌ Invalid Share Arguments\n\n**Error:** %s\n\n**Usage:** `/share <service> --expires=24h`", err.Error()),
+			}
+		}
+	}
+
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	namespaceName := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespaceName)
+	if err != nil {
+		return failedResponse("Share failed", "Share Failed", err)
+	}
+	if !exists {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Preview not found",
+			Content: fmt.Sprintf("## ❌ Preview Not Found\n\n**Namespace:** `%s`\n\n*Run `/preview %s` first.*", namespaceName, cmd.Service),
+		}
+	}
+
+	link, token, err := cs.k8s.CreateShareLink(ctx, namespaceName, cleanServiceName, cmd.User, expiry)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Share failed",
+			Content: fmt.Sprintf("## ❌ Share Failed\n\n**Error:** %s\n\n**Namespace:** `%s`", err.Error(), namespaceName),
+		}
+	}
+	shareURL := "https://" + cs.config.Share.Host + SharePath(namespaceName, token)
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "Shared %s until %s (link %s)", cmd.Service, link.ExpiresAt.Format(time.RFC3339), link.ID)
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Share link created",
+		Content: fmt.Sprintf("## 🔗 Share Link Created\n\n**👤 Created by:** @%s\n**🎯 Service:** %s\n**🔗 PR:** #%d\n**🆔 Link:** `%s`\n**⏰ Expires:** %s (in %s)\n\n**URL:** %s\n\nThe link opens the preview without a GitHub account until it expires, once given its password. The password is never posted here: get it, privately, with\n\n```\ncurl -X POST -H \"Authorization: Bearer $GITHUB_TOKEN\" \"%s/api/v1/previews/%d/shares/%s/password?repo=%s\"\n```\n\nand pass it on only to the people who need it. Each call sets a new password.\n\n*Run `/share revoke %s --id=%s` to end it early.*",
+			cmd.User, cmd.Service, cmd.PRNumber, link.ID, link.ExpiresAt.Format("2006-01-02 15:04 UTC"), formatAge(expiry), shareURL,
+			cs.config.Server.PublicURL, cmd.PRNumber, link.ID, cmd.Repo, cmd.Service, link.ID),
+		Data: map[string]interface{}{
+			"service":    cmd.Service,
+			"namespace":  namespaceName,
+			"link_id":    link.ID,
+			"url":        shareURL,
+			"expires_at": link.ExpiresAt.Format(time.RFC3339),
+			"pr_number":  cmd.PRNumber,
+		},
+	}
+}
+
+func (cs *CommandServiceK8s) revokeShareLinks(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+
+	var namespaces []string
+	if cmd.Service != "" {
+		namespaces = []string{cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)}
+	} else {
+		previews, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
+		if err != nil {
+			return failedResponse("Revoke failed", "Revoke Failed", err)
+		}
+		seen := map[string]bool{}
+		for _, preview := range previews {
+			if !seen[preview.Name] {
+				seen[preview.Name] = true
+				namespaces = append(namespaces, preview.Name)
+			}
+		}
+	}
+
+	var lines []string
+	for _, namespace := range namespaces {
+		revoked, err := cs.k8s.RevokeShareLinks(ctx, namespace, cleanServiceName, cmd.Args["id"], cmd.User)
+		if err != nil {
+			return failedResponse("Revoke failed", "Revoke Failed", err)
+		}
+		for _, link := range revoked {
+			lines = append(lines, fmt.Sprintf("- `%s` to %s, created by @%s", link.ID, link.Service, link.CreatedBy))
+		}
+	}
+
+	if len(lines) == 0 {
+		return &types.CommandResponse{
+			Success: true,
+			Message: "No share links to revoke",
+			Content: "## 🔗 No Active Share Links\n\nNothing matched; links that expired or were revoked already stay closed.",
+		}
+	}
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "Revoked %d share link(s)", len(lines))
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Share links revoked",
+		Content: fmt.Sprintf("## 🔒 Share Links Revoked\n\n**👤 Revoked by:** @%s\n**🔗 PR:** #%d\n\n%s\n\n*These URLs stop working within seconds.*", cmd.User, cmd.PRNumber, strings.Join(lines, "\n")),
+		Data: map[string]interface{}{
+			"revoked":   len(lines),
+			"pr_number": cmd.PRNumber,
+		},
+	}
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"pr-previews/internal/types"
+)
+
+// SnapshotMetadata describes a captured preview environment. It is stored as
+// pr-<n>/snapshots/<id>/metadata.json next to one manifest per namespace.
+type SnapshotMetadata struct {
+	ID         string              `json:"id"`
+	PRNumber   int                 `json:"pr_number"`
+	CreatedBy  string              `json:"created_by"`
+	CreatedAt  string              `json:"created_at"`
+	Namespaces []SnapshotNamespace `json:"namespaces"`
+}
+
+// SnapshotNamespace is one namespace inside a snapshot
+type SnapshotNamespace struct {
+	Name     string            `json:"name"`
+	Service  string            `json:"service"`
+	Manifest string            `json:"manifest"`
+	Secrets  []string          `json:"secrets"`           // names only; values are never stored
+	Volumes  map[string]string `json:"volumes,omitempty"` // claim -> VolumeSnapshot
+}
+
+func snapshotPrefix(prNumber int, id string) string {
+	return fmt.Sprintf("pr-%d/snapshots/%s", prNumber, id)
+}
+
+// ListSnapshots returns a PR's snapshots, newest first
+func ListSnapshots(ctx context.Context, store ArtifactStore, prNumber int) ([]SnapshotMetadata, error) {
+	keys, err := store.List(ctx, fmt.Sprintf("pr-%d/snapshots/", prNumber))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []SnapshotMetadata{}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/metadata.json") {
+			continue
+		}
+		content, err := store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		var metadata SnapshotMetadata
+		if err := json.Unmarshal(content, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot %s: %v", key, err)
+		}
+		snapshots = append(snapshots, metadata)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ID > snapshots[j].ID
+	})
+	return snapshots, nil
+}
+
+// HandleSnapshotK8s captures the PR's preview namespaces (or one service's)
+// into the artifact store, optionally snapshotting volume data too
+func (cs *CommandServiceK8s) HandleSnapshotK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	includeVolumes := cmd.Args["volumes"] == "true"
+	if includeVolumes {
+		if err := capabilityError(CapabilityVolumeSnapshots); err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Volume snapshots unavailable",
+				Content: fmt.Sprintf("## ❌ Volume Snapshots Unavailable\n\n**Reason:** %s\n\n*Run `/snapshot` without `--volumes=true` to capture manifests only, or install the CSI snapshot CRDs and controller.*", err.Error()),
+			}
+		}
+	}
+
+	namespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		return failedResponse("Snapshot failed", "Snapshot Failed", err)
+	}
+
+	// A shared namespace is captured whole, once, even for one service
+	var targets []PreviewNamespace
+	seen := map[string]bool{}
+	for _, ns := range namespaces {
+		name := ns.Name
+		if strings.HasSuffix(name, "-loadtest") || seen[name] {
+			continue
+		}
+		if cmd.Service != "" && ns.Service != strings.ReplaceAll(cmd.Service, "/", "-") && name != fmt.Sprintf("preview-pr-%d-%s", cmd.PRNumber, strings.ReplaceAll(cmd.Service, "/", "-")) {
+			continue
+		}
+		seen[name] = true
+		targets = append(targets, ns)
+	}
+
+	if len(targets) == 0 {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Nothing to snapshot",
+			Content: fmt.Sprintf("## ℹ️ Nothing to Snapshot\n\nNo preview environments were found for PR #%d.", cmd.PRNumber),
+		}
+	}
+
+	// The random suffix keeps snapshots taken in the same second apart
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return failedResponse("Snapshot failed", "Snapshot Failed", err)
+	}
+	now := time.Now().UTC()
+	metadata := SnapshotMetadata{
+		ID:        "snap-" + now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		PRNumber:  cmd.PRNumber,
+		CreatedBy: cmd.User,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	prefix := snapshotPrefix(cmd.PRNumber, metadata.ID)
+
+	var captured []string
+	for _, ns := range targets {
+		name, service := ns.Name, ns.Service
+
+		parsed, err := cs.k8s.CaptureNamespace(ctx, name)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Snapshot failed",
+				Content: fmt.Sprintf("## ❌ Snapshot Failed\n\n**Namespace:** `%s`\n**Error:** %s", name, err.Error()),
+			}
+		}
+
+		// A claimed pool namespace is restored under the preview's own name
+		restoreAs := name
+		if ns.Alias != "" {
+			restoreAs = ns.Alias
+		}
+		entry := SnapshotNamespace{
+			Name:     restoreAs,
+			Service:  service,
+			Manifest: fmt.Sprintf("%s/%s.yaml", prefix, restoreAs),
+			Secrets:  []string{},
+		}
+		for _, secret := range parsed.Secrets {
+			entry.Secrets = append(entry.Secrets, secret.Name)
+		}
+		parsed.Secrets = nil
+
+		if includeVolumes && len(parsed.PersistentVolumeClaims) > 0 {
+			entry.Volumes = make(map[string]string)
+			for _, pvc := range parsed.PersistentVolumeClaims {
+				snapshotName := fmt.Sprintf("%s-%s", pvc.Name, metadata.ID)
+				err := cs.k8s.CreateVolumeSnapshot(ctx, name, snapshotName, pvc.Name, cs.config.Snapshots.VolumeSnapshotClass)
+				if err != nil {
+					return &types.CommandResponse{
+						Success: false,
+						Message: "Snapshot failed",
+						Content: fmt.Sprintf("## ❌ Volume Snapshot Failed\n\n**Namespace:** `%s`\n**Error:** %s\n\n*Check that the cluster has a CSI driver with VolumeSnapshot support.*", name, err.Error()),
+					}
+				}
+				entry.Volumes[pvc.Name] = snapshotName
+				captured = append(captured, fmt.Sprintf("VolumeSnapshot/%s (%s)", snapshotName, name))
+			}
+		}
+
+		rendered, err := RenderManifest(parsed)
+		if err != nil {
+			return failedResponse("Snapshot failed", "Snapshot Failed", err)
+		}
+		if err := cs.artifacts.Save(ctx, entry.Manifest, rendered); err != nil {
+			return failedResponse("Snapshot failed", "Snapshot Failed", err)
+		}
+
+		metadata.Namespaces = append(metadata.Namespaces, entry)
+		captured = append(captured, fmt.Sprintf("%s: %d deployments, %d statefulsets, %d services, %d configmaps, %d volumes",
+			name, len(parsed.Deployments), len(parsed.StatefulSets), len(parsed.Services), len(parsed.ConfigMaps), len(parsed.PersistentVolumeClaims)))
+	}
+
+	encoded, err := json.MarshalIndent(metadata, "", "  ")
+	if err == nil {
+		err = cs.artifacts.Save(ctx, prefix+"/metadata.json", encoded)
+	}
+	if err != nil {
+		return failedResponse("Snapshot failed", "Snapshot Failed", err)
+	}
+
+	volumeNote := "Volume data was not captured; add `--volumes=true` to snapshot PVCs."
+	if includeVolumes {
+		volumeNote = "Volume snapshots live in the preview namespace, so their data can only be restored until `/cleanup` runs."
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Snapshot created",
+		Content: fmt.Sprintf("## 📸 Preview Snapshot Created\n\n**👤 Taken by:** @%s\n**🔗 PR:** #%d\n**🆔 Snapshot:** `%s`\n\n### 📦 Captured\n%s\n*Secret values are not stored. %s*\n\n**To restore:** `/restore %s`",
+			cmd.User, cmd.PRNumber, metadata.ID, cs.formatResourcesList(captured), volumeNote, metadata.ID),
+		Data: map[string]interface{}{
+			"snapshot": metadata,
+		},
+	}
+}
+
+// HandleRestoreK8s recreates the environment recorded in a snapshot. Missing
+// namespaces are recreated with fresh secrets; existing objects are updated.
+func (cs *CommandServiceK8s) HandleRestoreK8s(ctx context.Context, cmd *types.Command, repoPath string) *types.CommandResponse {
+	snapshotID := cmd.Args["snapshot"]
+
+	content, err := cs.artifacts.Get(ctx, snapshotPrefix(cmd.PRNumber, snapshotID)+"/metadata.json")
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Restore failed",
+			Content: fmt.Sprintf("## ❌ Restore Failed\n\n**Snapshot:** `%s`\n**Error:** snapshot not found for PR #%d", snapshotID, cmd.PRNumber),
+		}
+	}
+
+	var metadata SnapshotMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return failedResponse("Restore failed", "Restore Failed", err)
+	}
+
+	var restored, warnings []string
+	for _, entry := range metadata.Namespaces {
+		resources, notes, err := cs.restoreNamespace(ctx, cmd, repoPath, metadata.ID, entry)
+		if err != nil {
+			return &types.CommandResponse{
+				Success: false,
+				Message: "Restore failed",
+				Content: fmt.Sprintf("## ❌ Restore Failed\n\n**Namespace:** `%s`\n**Error:** %s", entry.Name, err.Error()),
+			}
+		}
+		restored = append(restored, resources...)
+		warnings = append(warnings, notes...)
+	}
+
+	warningNote := ""
+	if len(warnings) > 0 {
+		warningNote = fmt.Sprintf("\n### ⚠️ Notes\n%s", cs.formatResourcesList(warnings))
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Snapshot restored",
+		Content: fmt.Sprintf("## ⏪ Preview Snapshot Restored\n\n**👤 Restored by:** @%s\n**🔗 PR:** #%d\n**🆔 Snapshot:** `%s` (taken %s by @%s)\n\n### 📦 Restored Resources\n%s%s",
+			cmd.User, cmd.PRNumber, metadata.ID, metadata.CreatedAt, metadata.CreatedBy, cs.formatResourcesList(restored), warningNote),
+		Data: map[string]interface{}{
+			"snapshot": metadata,
+			"restored": restored,
+			"warnings": warnings,
+		},
+	}
+}
+
+func (cs *CommandServiceK8s) restoreNamespace(ctx context.Context, cmd *types.Command, repoPath, snapshotID string, entry SnapshotNamespace) ([]string, []string, error) {
+	var restored, warnings []string
+
+	content, err := cs.artifacts.Get(ctx, entry.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := NewManifestParser(nil).ParseManifestContent(content, entry.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The preview may live in a claimed warm pool namespace
+	namespace := cs.k8s.ResolveNamespaceAlias(ctx, cmd.Repo, entry.Name)
+	exists, err := cs.k8s.NamespaceExists(ctx, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !exists {
+		if err := cs.k8s.CreateNamespace(ctx, namespace, cmd.PRNumber, entry.Service, cmd.User); err != nil {
+			return nil, nil, err
+		}
+		restored = append(restored, fmt.Sprintf("Namespace/%s", namespace))
+
+		// Secret values were never captured, so provision them again the
+		// same way /preview does
+		repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(repoConfig.Secrets) > 0 {
+			if err := cs.k8s.CreatePreviewSecret(ctx, namespace, "preview-secrets", repoConfig.Secrets); err != nil {
+				return nil, nil, err
+			}
+			restored = append(restored, "Secret/preview-secrets")
+		}
+		vaultSecrets, err := cs.injectVaultSecrets(ctx, namespace, repoConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		restored = append(restored, vaultSecrets...)
+		infraResources, err := cs.applyTerraform(ctx, namespace, cmd.PRNumber, repoPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		restored = append(restored, infraResources...)
+
+		provisioned := map[string]bool{"preview-secrets": true, "terraform-outputs": true}
+		for _, name := range restored {
+			provisioned[strings.TrimPrefix(name, "Secret/")] = true
+		}
+		for _, name := range entry.Secrets {
+			if !provisioned[name] {
+				warnings = append(warnings, fmt.Sprintf("Secret `%s` in `%s` was not restored; re-run `/preview %s` to recreate it", name, namespace, entry.Service))
+			}
+		}
+	}
+
+	// Claims can only be seeded from a VolumeSnapshot when they're recreated
+	for i := range parsed.PersistentVolumeClaims {
+		pvc := &parsed.PersistentVolumeClaims[i]
+		snapshotName, ok := entry.Volumes[pvc.Name]
+		if !ok {
+			continue
+		}
+		if err := capabilityError(CapabilityVolumeSnapshots); err != nil {
+			warnings = append(warnings, fmt.Sprintf("`%s` starts empty if it has to be recreated: %s", pvc.Name, err.Error()))
+			continue
+		}
+		available, err := cs.k8s.VolumeSnapshotExists(ctx, namespace, snapshotName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !available {
+			warnings = append(warnings, fmt.Sprintf("VolumeSnapshot `%s` no longer exists; `%s` starts empty if it has to be recreated", snapshotName, pvc.Name))
+			continue
+		}
+		apiGroup := "snapshot.storage.k8s.io"
+		pvc.Spec.DataSource = &corev1.TypedLocalObjectReference{
+			APIGroup: &apiGroup,
+			Kind:     "VolumeSnapshot",
+			Name:     snapshotName,
+		}
+		pvc.Spec.DataSourceRef = nil
+	}
+
+	labels := map[string]string{
+		"preview":    "true",
+		"managed-by": "pr-previews",
+	}
+	annotations := map[string]string{
+		"pr-previews.io/restored-from": snapshotID,
+		"pr-previews.io/restored-by":   cmd.User,
+	}
+	if err := cs.k8s.ApplyParsedManifest(ctx, namespace, parsed, labels, annotations); err != nil {
+		return nil, nil, err
+	}
+
+	for _, pvc := range parsed.PersistentVolumeClaims {
+		restored = append(restored, fmt.Sprintf("PersistentVolumeClaim/%s", pvc.Name))
+	}
+	for _, cm := range parsed.ConfigMaps {
+		restored = append(restored, fmt.Sprintf("ConfigMap/%s", cm.Name))
+	}
+	for _, dep := range parsed.Deployments {
+		restored = append(restored, fmt.Sprintf("Deployment/%s", dep.Name))
+	}
+	for _, sts := range parsed.StatefulSets {
+		restored = append(restored, fmt.Sprintf("StatefulSet/%s", sts.Name))
+	}
+	for _, svc := range parsed.Services {
+		restored = append(restored, fmt.Sprintf("Service/%s", svc.Name))
+	}
+	for _, hpa := range parsed.HorizontalPodAutoscalers {
+		restored = append(restored, fmt.Sprintf("HorizontalPodAutoscaler/%s", hpa.Name))
+	}
+
+	return restored, warnings, nil
+}
@@ -0,0 +1,752 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"pr-previews/internal/types"
+)
+
+const (
+	// tapAnnotation on the preview Ingress records what a running tap
+	// rerouted, so it can be put back even after a restart
+	tapAnnotation = "pr-previews.io/tap"
+
+	// tapProxyLabel marks the objects of a tap proxy, so a user's own
+	// tap-<service> objects are never taken over or deleted
+	tapProxyLabel = "pr-previews.io/tap-proxy"
+
+	tapProxyPort       = 8080
+	defaultTapDuration = 30 * time.Second
+	tapProxyTimeout    = 2 * time.Minute
+
+	// tapReapInterval is how often expired taps, e.g. ones a restart cut
+	// short, are undone; tapReapGrace leaves the replica that started one
+	// time to stop it and post the summary itself
+	tapReapInterval = time.Minute
+	tapReapGrace    = time.Minute
+
+	// tapMaxRoutes bounds the route table of the summary
+	tapMaxRoutes = 15
+)
+
+// TapSession is a running tap: the preview Ingress sends the service's
+// traffic through an envoy proxy that logs each request. Until the proxy is
+// ready the Ingress isn't rerouted yet, and Until allows for the wait.
+type TapSession struct {
+	Ingress string    `json:"ingress"`
+	Proxy   string    `json:"proxy"`   // Deployment, Service and ConfigMap of the proxy
+	Service string    `json:"service"` // the backend the Ingress pointed at
+	Port    int32     `json:"port"`
+	Routed  bool      `json:"routed"`
+	Until   time.Time `json:"until"`
+}
+
+// HandleTapK8s captures the requests reaching a preview for a while and
+// posts an anonymized summary of them to the PR
+func (cs *CommandServiceK8s) HandleTapK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	if !cs.config.Tap.Enabled {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Tap is disabled",
+			Content: "## ❌ Tap Disabled\n\nRequest capture is not enabled on this installation (`TAP_ENABLED=true`).",
+		}
+	}
+
+	duration, err := parseTapDuration(cmd.Args, cs.config.Tap.MaxDuration)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Invalid tap arguments",
+			Content: fmt.Sprintf("## ❌ Invalid Tap Arguments\n\n**Error:** %s\n\n**Usage:** `/tap <service> --duration=30s`", err.Error()),
+		}
+	}
+
+	cleanServiceName := strings.ReplaceAll(cmd.Service, "/", "-")
+	namespaceName := cs.k8s.ResolvePreviewNamespace(ctx, cmd.Repo, cmd.PRNumber, cleanServiceName)
+
+	exists, err := cs.k8s.NamespaceExists(ctx, namespaceName)
+	if err != nil {
+		return failedResponse("Tap failed", "Tap Failed", err)
+	}
+	if !exists {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Preview not found",
+			Content: fmt.Sprintf("## ❌ Preview Not Found\n\n**Namespace:** `%s`\n\n*Run `/preview %s` first.*", namespaceName, cmd.Service),
+		}
+	}
+
+	session, err := cs.k8s.StartTap(ctx, namespaceName, cleanServiceName, cs.config.Tap.ProxyImage, duration)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Tap failed",
+			Content: fmt.Sprintf("## ❌ Tap Failed\n\n**Error:** %s\n\n**Namespace:** `%s`", err.Error(), namespaceName),
+		}
+	}
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "Tapping %s for %s", cmd.Service, duration)
+	go cs.runTap(cmd, namespaceName, session, duration)
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Tap started",
+		Content: fmt.Sprintf("## 🎧 Tap Started\n\n**👤 Triggered by:** @%s\n**🎯 Service:** %s\n**🔗 PR:** #%d\n**📦 Namespace:** `%s`\n**⏱️ Duration:** %s\n\nOnce the capturing proxy is ready, requests to the preview go through it for %s; a summary of routes, status codes and latencies is posted here afterwards.\n\n*Only methods, routes with IDs masked, status codes and latencies are recorded; query strings, headers, bodies and client addresses are not.*",
+			cmd.User, cmd.Service, cmd.PRNumber, namespaceName, duration, duration),
+		Data: map[string]interface{}{
+			"service":   cmd.Service,
+			"namespace": namespaceName,
+			"duration":  duration.String(),
+			"session":   session,
+			"pr_number": cmd.PRNumber,
+		},
+	}
+}
+
+// runTap routes the preview through the proxy once it's ready, waits out
+// the tap, puts the Ingress back and posts the summary. If this replica
+// stops first, the tap reaper puts the Ingress back instead.
+func (cs *CommandServiceK8s) runTap(cmd *types.Command, namespace string, session *TapSession, duration time.Duration) {
+	ctx := context.Background()
+	if err := cs.k8s.RouteTap(ctx, namespace, session, duration); err != nil {
+		if _, stopErr := cs.k8s.StopTap(ctx, namespace, session.Ingress); stopErr != nil {
+			fmt.Printf("Warning: %v\n", stopErr)
+		}
+		summary := fmt.Sprintf("## ❌ Tap Did Not Start\n\n**🎯 Service:** %s\n**📦 Namespace:** `%s`\n**Error:** %s", cmd.Service, namespace, err.Error())
+		if err := cs.comments.PostComment(ctx, cmd.Repo, cmd.PRNumber, summary); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		return
+	}
+	time.Sleep(time.Until(session.Until))
+
+	var summary string
+	logs, err := cs.k8s.StopTap(ctx, namespace, session.Ingress)
+	if err != nil {
+		summary = fmt.Sprintf("## ❌ Tap Did Not Complete\n\n**🎯 Service:** %s\n**📦 Namespace:** `%s`\n**Error:** %s\n\n*Run `/tap %s` again to put the Ingress back.*", cmd.Service, namespace, err.Error(), cmd.Service)
+	} else {
+		summary = formatTapSummary(cmd.Service, namespace, duration, summarizeTap(logs))
+	}
+
+	if err := cs.comments.PostComment(ctx, cmd.Repo, cmd.PRNumber, summary); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// parseTapDuration reads --duration, 30s unless given
+func parseTapDuration(args map[string]string, maxDuration time.Duration) (time.Duration, error) {
+	value, ok := args["duration"]
+	if !ok {
+		return min(defaultTapDuration, maxDuration), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid --duration value: %s", SanitizeEcho(value))
+	}
+	if duration > maxDuration {
+		return 0, fmt.Errorf("--duration=%s exceeds the limit of %s", duration, maxDuration)
+	}
+	return duration, nil
+}
+
+// TapSummary is what a tap saw, without anything identifying users
+type TapSummary struct {
+	Requests int
+	Statuses map[int]int
+	Routes   []TapRoute
+}
+
+// TapRoute is the requests to one method and route
+type TapRoute struct {
+	Method    string
+	Route     string
+	Requests  int
+	Errors    int // 5xx or no response
+	latencies []time.Duration
+}
+
+func (r TapRoute) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p*float64(len(r.latencies)))) - 1
+	return r.latencies[max(index, 0)]
+}
+
+// summarizeTap reads the proxy's JSON access log lines, skipping envoy's
+// own messages
+func summarizeTap(logs string) TapSummary {
+	summary := TapSummary{Statuses: map[int]int{}}
+	routes := map[string]*TapRoute{}
+
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		method, _ := entry["method"].(string)
+		path, _ := entry["path"].(string)
+		if method == "" || path == "" {
+			continue
+		}
+		// Numbers or strings, depending on the envoy version
+		status, _ := strconv.Atoi(fmt.Sprint(entry["status"]))
+		durationMs, _ := strconv.ParseFloat(fmt.Sprint(entry["duration_ms"]), 64)
+
+		summary.Requests++
+		summary.Statuses[status]++
+
+		route := anonymizeRoute(path)
+		key := method + " " + route
+		if routes[key] == nil {
+			routes[key] = &TapRoute{Method: method, Route: route}
+		}
+		routes[key].Requests++
+		if status >= 500 || status == 0 {
+			routes[key].Errors++
+		}
+		routes[key].latencies = append(routes[key].latencies, time.Duration(durationMs*float64(time.Millisecond)))
+	}
+
+	for _, route := range routes {
+		sort.Slice(route.latencies, func(i, j int) bool { return route.latencies[i] < route.latencies[j] })
+		summary.Routes = append(summary.Routes, *route)
+	}
+	sort.Slice(summary.Routes, func(i, j int) bool {
+		if summary.Routes[i].Requests != summary.Routes[j].Requests {
+			return summary.Routes[i].Requests > summary.Routes[j].Requests
+		}
+		return summary.Routes[i].Method+summary.Routes[i].Route < summary.Routes[j].Method+summary.Routes[j].Route
+	})
+	return summary
+}
+
+var (
+	tapUUIDPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	tapHexPattern   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	tapTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+)
+
+// anonymizeRoute drops the query string and masks path segments that look
+// like IDs, emails or tokens, so routes group and nobody's data is posted
+func anonymizeRoute(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	path, _, _ = strings.Cut(path, "#")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		_, numErr := strconv.ParseInt(segment, 10, 64)
+		if numErr == nil || strings.Contains(segment, "@") || tapUUIDPattern.MatchString(segment) ||
+			tapHexPattern.MatchString(segment) || (tapTokenPattern.MatchString(segment) && strings.ContainsAny(segment, "0123456789")) {
+			segments[i] = ":id"
+		}
+	}
+	if route := strings.Join(segments, "/"); route != "" {
+		return route
+	}
+	return "/"
+}
+
+func formatTapSummary(service, namespace string, duration time.Duration, summary TapSummary) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## 🎧 Tap Summary\n\n**🎯 Service:** %s\n**📦 Namespace:** `%s`\n**⏱️ Captured:** %s, %d requests\n\n", service, namespace, duration, summary.Requests))
+	if summary.Requests == 0 {
+		content.WriteString("No requests reached the preview while it was tapped.\n")
+		return content.String()
+	}
+
+	statuses := make([]int, 0, len(summary.Statuses))
+	for status := range summary.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	content.WriteString("### 🚦 Status Codes\n\n| Status | Requests |\n|--------|----------|\n")
+	for _, status := range statuses {
+		label := strconv.Itoa(status)
+		if status == 0 {
+			label = "no response"
+		}
+		content.WriteString(fmt.Sprintf("| %s | %d |\n", label, summary.Statuses[status]))
+	}
+
+	content.WriteString("\n### 🛣️ Routes\n\n| Route | Requests | Errors | p50 | p95 | Max |\n|-------|----------|--------|-----|-----|-----|\n")
+	for i, route := range summary.Routes {
+		if i == tapMaxRoutes {
+			content.WriteString(fmt.Sprintf("\n*…and %d more routes*\n", len(summary.Routes)-tapMaxRoutes))
+			break
+		}
+		content.WriteString(fmt.Sprintf("| `%s %s` | %d | %d | %s | %s | %s |\n",
+			route.Method, route.Route, route.Requests, route.Errors,
+			route.percentile(0.5), route.percentile(0.95), route.percentile(1)))
+	}
+	content.WriteString("\n*Query strings, headers, bodies and client addresses were not recorded; IDs in paths are shown as `:id`.*")
+	return content.String()
+}
+
+// tapEnvoyConfig proxies everything to the upstream Service, logging one
+// JSON line per request
+func tapEnvoyConfig(upstream string, port int32) string {
+	return fmt.Sprintf(`static_resources:
+  listeners:
+  - name: tap
+    address:
+      socket_address: {address: 0.0.0.0, port_value: %d}
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.http_connection_manager
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+          stat_prefix: tap
+          access_log:
+          - name: envoy.access_loggers.stdout
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.access_loggers.stream.v3.StdoutAccessLog
+              log_format:
+                json_format:
+                  method: "%%REQ(:METHOD)%%"
+                  path: "%%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%%"
+                  status: "%%RESPONSE_CODE%%"
+                  duration_ms: "%%DURATION%%"
+          route_config:
+            virtual_hosts:
+            - name: upstream
+              domains: ["*"]
+              routes:
+              - match: {prefix: "/"}
+                route: {cluster: upstream, timeout: 0s}
+          http_filters:
+          - name: envoy.filters.http.router
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.router.v3.Router
+  clusters:
+  - name: upstream
+    type: STRICT_DNS
+    load_assignment:
+      cluster_name: upstream
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address: {address: %s, port_value: %d}
+`, tapProxyPort, upstream, port)
+}
+
+// previewIngress finds the Ingress exposing the service's preview: its own in
+// a shared namespace, otherwise the namespace's
+func (k *K8sService) previewIngress(ctx context.Context, namespace, cleanServiceName string) (*networkingv1.Ingress, error) {
+	if err := capabilityError(CapabilityIngress); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"preview-" + cleanServiceName, "preview"} {
+		ingress, err := k.client.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ingress %s: %v", name, err)
+		}
+		return ingress, nil
+	}
+	return nil, fmt.Errorf("the preview isn't exposed by an Ingress")
+}
+
+// StartTap deploys a logging envoy proxy in front of the service's preview
+// and records the tap on the preview Ingress; RouteTap points the Ingress at
+// the proxy once it's ready, until StopTap. A tap left behind by a restart
+// is stopped first; one still running is an error.
+func (k *K8sService) StartTap(ctx context.Context, namespace, cleanServiceName, image string, duration time.Duration) (*TapSession, error) {
+	ingress, err := k.previewIngress(ctx, namespace, cleanServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if raw, ok := ingress.Annotations[tapAnnotation]; ok {
+		var running TapSession
+		if err := json.Unmarshal([]byte(raw), &running); err == nil && time.Now().Before(running.Until) {
+			return nil, fmt.Errorf("a tap is already running until %s", running.Until.UTC().Format("15:04:05 UTC"))
+		}
+		if _, err := k.StopTap(ctx, namespace, ingress.Name); err != nil {
+			return nil, err
+		}
+		if ingress, err = k.previewIngress(ctx, namespace, cleanServiceName); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ingress.Spec.Rules) == 0 || ingress.Spec.Rules[0].HTTP == nil || len(ingress.Spec.Rules[0].HTTP.Paths) == 0 {
+		return nil, fmt.Errorf("ingress %s has no paths", ingress.Name)
+	}
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if backend == nil || backend.Port.Number == 0 {
+		return nil, fmt.Errorf("ingress %s doesn't route to a Service port number", ingress.Name)
+	}
+
+	session := &TapSession{
+		Ingress: ingress.Name,
+		Proxy:   "tap-" + cleanServiceName,
+		Service: backend.Name,
+		Port:    backend.Port.Number,
+		Until:   time.Now().Add(tapProxyTimeout + duration),
+	}
+	if err := k.tapProxyConflict(ctx, namespace, session.Proxy); err != nil {
+		return nil, err
+	}
+	upstream := fmt.Sprintf("%s.%s.svc", backend.Name, namespace)
+	if err := k.createTapProxy(ctx, namespace, session.Proxy, image, tapEnvoyConfig(upstream, backend.Port.Number)); err != nil {
+		k.deleteTapProxy(ctx, namespace, session.Proxy)
+		return nil, err
+	}
+
+	// Recorded before the proxy is used, so a restart can't leave it behind
+	state, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if ingress.Annotations == nil {
+		ingress.Annotations = make(map[string]string)
+	}
+	ingress.Annotations[tapAnnotation] = string(state)
+	if _, err := k.client.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{}); err != nil {
+		k.deleteTapProxy(ctx, namespace, session.Proxy)
+		return nil, fmt.Errorf("failed to record the tap on ingress %s: %v", ingress.Name, err)
+	}
+
+	return session, nil
+}
+
+// RouteTap waits for the session's proxy to answer, so the preview never
+// goes dark, then points the Ingress at it for duration
+func (k *K8sService) RouteTap(ctx context.Context, namespace string, session *TapSession, duration time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, tapProxyTimeout, true, func(ctx context.Context) (bool, error) {
+		dep, err := k.client.AppsV1().Deployments(namespace).Get(ctx, session.Proxy, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return dep.Status.ReadyReplicas > 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("tap proxy did not become ready: %v", err)
+	}
+
+	session.Routed, session.Until = true, time.Now().Add(duration)
+	state, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ingress, err := k.client.NetworkingV1().Ingresses(namespace).Get(ctx, session.Ingress, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get ingress %s: %v", session.Ingress, err)
+		}
+		if _, ok := ingress.Annotations[tapAnnotation]; !ok {
+			return fmt.Errorf("the tap of ingress %s was stopped", session.Ingress)
+		}
+		k.routeTapPaths(ingress, session)
+		ingress.Annotations[tapAnnotation] = string(state)
+		_, err = k.client.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+		if err != nil && !apierrors.IsConflict(err) {
+			return fmt.Errorf("failed to route ingress %s through the tap proxy: %v", session.Ingress, err)
+		}
+		return err
+	})
+}
+
+// routeTapPaths points the paths that reach the session's backend at its
+// proxy
+func (k *K8sService) routeTapPaths(ingress *networkingv1.Ingress, session *TapSession) {
+	for i := range ingress.Spec.Rules {
+		if ingress.Spec.Rules[i].HTTP == nil {
+			continue
+		}
+		for j, path := range ingress.Spec.Rules[i].HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == session.Service && path.Backend.Service.Port.Number == session.Port {
+				ingress.Spec.Rules[i].HTTP.Paths[j].Backend.Service = &networkingv1.IngressServiceBackend{
+					Name: session.Proxy,
+					Port: networkingv1.ServiceBackendPort{Number: tapProxyPort},
+				}
+			}
+		}
+	}
+}
+
+// StartTapReaper undoes expired taps at startup and then every minute, on
+// the leader, so a tap whose replica stopped doesn't leave the preview
+// behind its proxy until ctx is cancelled
+func (cs *CommandServiceK8s) StartTapReaper(ctx context.Context) {
+	if !cs.config.Tap.Enabled {
+		return
+	}
+	ticker := time.NewTicker(tapReapInterval)
+	defer ticker.Stop()
+
+	for {
+		if SharedLeader().IsLeader() {
+			stopped, err := cs.k8s.StopExpiredTaps(ctx, time.Now().Add(-tapReapGrace))
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+			for _, ingress := range stopped {
+				fmt.Printf("Stopped expired tap of ingress %s\n", ingress)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// StopExpiredTaps stops every tap that should have ended before cutoff and
+// returns the namespace/name of their Ingresses
+func (k *K8sService) StopExpiredTaps(ctx context.Context, cutoff time.Time) ([]string, error) {
+	if err := capabilityError(CapabilityIngress); err != nil {
+		return nil, nil
+	}
+	ingresses, err := k.client.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses for expired taps: %v", err)
+	}
+
+	var stopped []string
+	for _, ingress := range ingresses.Items {
+		raw, ok := ingress.Annotations[tapAnnotation]
+		if !ok {
+			continue
+		}
+		var session TapSession
+		if err := json.Unmarshal([]byte(raw), &session); err == nil && session.Until.After(cutoff) {
+			continue
+		}
+		if _, err := k.StopTap(ctx, ingress.Namespace, ingress.Name); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		stopped = append(stopped, ingress.Namespace+"/"+ingress.Name)
+	}
+	return stopped, nil
+}
+
+// StopTap points the Ingress back at the service, then removes the proxy.
+// Returns the proxy's logs.
+func (k *K8sService) StopTap(ctx context.Context, namespace, ingressName string) (string, error) {
+	ingress, err := k.client.NetworkingV1().Ingresses(namespace).Get(ctx, ingressName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get ingress %s: %v", ingressName, err)
+	}
+	raw, ok := ingress.Annotations[tapAnnotation]
+	if !ok {
+		return "", nil
+	}
+	var session TapSession
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return "", fmt.Errorf("failed to read the tap of ingress %s: %v", ingressName, err)
+	}
+
+	for i := range ingress.Spec.Rules {
+		if ingress.Spec.Rules[i].HTTP == nil {
+			continue
+		}
+		for j, path := range ingress.Spec.Rules[i].HTTP.Paths {
+			if path.Backend.Service != nil && path.Backend.Service.Name == session.Proxy {
+				ingress.Spec.Rules[i].HTTP.Paths[j].Backend.Service = &networkingv1.IngressServiceBackend{
+					Name: session.Service,
+					Port: networkingv1.ServiceBackendPort{Number: session.Port},
+				}
+			}
+		}
+	}
+	delete(ingress.Annotations, tapAnnotation)
+	if _, err := k.client.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to restore ingress %s: %v", ingressName, err)
+	}
+
+	var logs strings.Builder
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + session.Proxy})
+	if err == nil {
+		for _, pod := range pods.Items {
+			content, err := k.client.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+			if err != nil {
+				fmt.Printf("Warning: failed to read tap logs of %s: %v\n", pod.Name, err)
+				continue
+			}
+			logs.Write(content)
+		}
+	}
+
+	k.deleteTapProxy(ctx, namespace, session.Proxy)
+	return logs.String(), nil
+}
+
+// tapProxyObjects gets the labels of the proxy's Deployment, Service and
+// ConfigMap named name, nil for the ones that don't exist
+func (k *K8sService) tapProxyObjects(ctx context.Context, namespace, name string) (map[string]map[string]string, error) {
+	objects := make(map[string]map[string]string)
+	get := map[string]func() (metav1.Object, error){
+		"deployment": func() (metav1.Object, error) {
+			return k.client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		"service": func() (metav1.Object, error) {
+			return k.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+		"configmap": func() (metav1.Object, error) {
+			return k.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		},
+	}
+	for kind, fn := range get {
+		obj, err := fn()
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s %s: %v", kind, name, err)
+		}
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		objects[kind] = labels
+	}
+	return objects, nil
+}
+
+// tapProxyConflict errors if something other than a tap proxy already uses
+// the proxy's name
+func (k *K8sService) tapProxyConflict(ctx context.Context, namespace, name string) error {
+	objects, err := k.tapProxyObjects(ctx, namespace, name)
+	if err != nil {
+		return err
+	}
+	for kind, labels := range objects {
+		if labels[tapProxyLabel] != "true" {
+			return fmt.Errorf("%s %s already exists and isn't a tap proxy; rename it to tap the service", kind, name)
+		}
+	}
+	return nil
+}
+
+func (k *K8sService) createTapProxy(ctx context.Context, namespace, name, image, envoyConfig string) error {
+	labels := map[string]string{
+		"app":         name,
+		"managed-by":  "pr-previews",
+		tapProxyLabel: "true",
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Data:       map[string]string{"envoy.yaml": envoyConfig},
+	}
+	if _, err := k.client.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create tap proxy config: %v", err)
+		}
+		if _, err := k.client.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update tap proxy config: %v", err)
+		}
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(1),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:    "envoy",
+							Image:   image,
+							Command: []string{"envoy", "-c", "/etc/envoy/envoy.yaml", "--log-level", "warn"},
+							Ports:   []corev1.ContainerPort{{Name: "http", ContainerPort: tapProxyPort}},
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(tapProxyPort)},
+								},
+								PeriodSeconds: 2,
+							},
+							VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/envoy"}},
+							SecurityContext: &corev1.SecurityContext{
+								RunAsNonRoot:             boolPtr(true),
+								RunAsUser:                int64Ptr(65534),
+								AllowPrivilegeEscalation: boolPtr(false),
+								Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+								SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "config",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if _, err := k.client.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create tap proxy: %v", err)
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": name},
+			Ports:    []corev1.ServicePort{{Name: "http", Port: tapProxyPort, TargetPort: intstr.FromInt32(tapProxyPort)}},
+		},
+	}
+	if _, err := k.client.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create tap proxy service: %v", err)
+	}
+	return nil
+}
+
+// deleteTapProxy removes the proxy's objects, leaving anything not labelled
+// as a tap proxy alone and logging what it can't remove
+func (k *K8sService) deleteTapProxy(ctx context.Context, namespace, name string) {
+	objects, err := k.tapProxyObjects(ctx, namespace, name)
+	if err != nil {
+		fmt.Printf("Warning: failed to remove tap proxy %s: %v\n", name, err)
+		return
+	}
+	for kind, labels := range objects {
+		if labels[tapProxyLabel] != "true" {
+			continue
+		}
+		switch kind {
+		case "deployment":
+			err = k.client.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		case "service":
+			err = k.client.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		case "configmap":
+			err = k.client.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			fmt.Printf("Warning: failed to remove tap proxy %s %s: %v\n", kind, name, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const terraformTimeout = 15 * time.Minute
+
+// TerraformPlanSection renders the infra plan for /plan, or "" when the repo
+// has no preview module
+func (cs *CommandServiceK8s) TerraformPlanSection(ctx context.Context, prNumber int, repoPath string) string {
+	if !cs.terraform.Detect(repoPath) {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, terraformTimeout)
+	defer cancel()
+
+	plan, err := cs.terraform.Plan(ctx, repoPath, prNumber)
+	if err != nil {
+		return fmt.Sprintf("\n\n### 🏗️ Infrastructure Plan\n\n❌ **Error:** %s", err.Error())
+	}
+
+	return fmt.Sprintf("\n\n### 🏗️ Infrastructure Plan\n\n<details><summary>%s plan for workspace `pr-%d`</summary>\n\n```\n%s\n```\n</details>",
+		cs.config.Terraform.Binary, prNumber, strings.TrimSpace(plan))
+}
+
+// applyTerraform provisions the PR's infra and exposes outputs to workloads
+// as the terraform-outputs Secret
+func (cs *CommandServiceK8s) applyTerraform(ctx context.Context, namespace string, prNumber int, repoPath string) ([]string, error) {
+	var created []string
+	if !cs.terraform.Detect(repoPath) {
+		return created, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, terraformTimeout)
+	defer cancel()
+
+	outputs, err := cs.terraform.Apply(ctx, repoPath, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	created = append(created, fmt.Sprintf("Workspace/pr-%d", prNumber))
+
+	if len(outputs) > 0 {
+		if err := cs.k8s.CreatePreviewSecret(ctx, namespace, "terraform-outputs", outputs); err != nil {
+			return nil, err
+		}
+		created = append(created, "Secret/terraform-outputs")
+	}
+
+	return created, nil
+}
+
+// destroyTerraform tears down the PR's infra if the repo has a preview module
+func (cs *CommandServiceK8s) destroyTerraform(ctx context.Context, prNumber int, repoPath string) error {
+	if !cs.terraform.Detect(repoPath) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, terraformTimeout)
+	defer cancel()
+
+	return cs.terraform.Destroy(ctx, repoPath, prNumber)
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// injectVaultSecrets fetches the repo's dynamic credentials into the namespace
+func (cs *CommandServiceK8s) injectVaultSecrets(ctx context.Context, namespace string, repoConfig *RepoConfig) ([]string, error) {
+	var created []string
+	if len(repoConfig.Vault) == 0 {
+		return created, nil
+	}
+
+	if !cs.vault.Configured() {
+		return nil, fmt.Errorf("repo config requests Vault secrets but VAULT_ADDR/VAULT_TOKEN are not set")
+	}
+
+	// In a shared namespace the first service's deploy already fetched them
+	existing := map[string]bool{}
+	leases, err := cs.k8s.ListVaultLeases(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		existing[fmt.Sprint(lease["name"])] = true
+	}
+
+	// Don't leave orphaned credentials behind: a failure revokes every lease
+	// issued here and removes their Secrets, so a retry fetches them again
+	var issued []*VaultLease
+	var written []string
+	undo := func() {
+		for _, lease := range issued {
+			if err := cs.vault.RevokeLease(ctx, lease.LeaseID); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+		for _, name := range written {
+			if err := cs.k8s.DeleteVaultSecret(ctx, namespace, name); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+
+	for _, spec := range repoConfig.Vault {
+		if existing[spec.Secret] {
+			continue
+		}
+		lease, err := cs.vault.Read(ctx, spec.Path)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		issued = append(issued, lease)
+
+		err = cs.k8s.CreateVaultSecret(ctx, namespace, spec.Secret, spec.Path, lease)
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		written = append(written, spec.Secret)
+
+		created = append(created, fmt.Sprintf("Secret/%s", spec.Secret))
+	}
+
+	return created, nil
+}
+
+// revokeVaultLeases revokes the leases of every Vault secret in the namespaces
+func (cs *CommandServiceK8s) revokeVaultLeases(ctx context.Context, namespaces []string) {
+	if !cs.vault.Configured() {
+		return
+	}
+
+	for _, namespace := range namespaces {
+		leases, err := cs.k8s.ListVaultLeases(ctx, namespace)
+		if err != nil {
+			fmt.Printf("Warning: failed to list Vault leases in %s: %v\n", namespace, err)
+			continue
+		}
+
+		for _, lease := range leases {
+			leaseID, _ := lease["lease_id"].(string)
+			if leaseID == "" {
+				continue
+			}
+			if err := cs.vault.RevokeLease(ctx, leaseID); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+	}
+}
+
+// RenewVaultLeases renews every renewable lease held by a live preview
+func (cs *CommandServiceK8s) RenewVaultLeases(ctx context.Context) {
+	leases, err := cs.k8s.ListVaultLeases(ctx, "")
+	if err != nil {
+		fmt.Printf("Warning: failed to list Vault leases: %v\n", err)
+		return
+	}
+
+	for _, lease := range leases {
+		leaseID, _ := lease["lease_id"].(string)
+		if renewable, _ := lease["renewable"].(bool); !renewable || leaseID == "" {
+			continue
+		}
+		if err := cs.vault.RenewLease(ctx, leaseID, 2*cs.config.Vault.RenewInterval); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
+
+// StartVaultRenewer renews leases on an interval until ctx is cancelled
+func (cs *CommandServiceK8s) StartVaultRenewer(ctx context.Context) {
+	if !cs.vault.Configured() {
+		return
+	}
+
+	ticker := time.NewTicker(cs.config.Vault.RenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cs.RenewVaultLeases(ctx)
+		}
+	}
+}
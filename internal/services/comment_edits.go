@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// editTrackerRetention bounds how long executed edits are remembered
+const editTrackerRetention = time.Hour
+
+// CommentEditTracker debounces edited command comments and remembers what
+// each comment last ran, so repeated edits or redeliveries don't re-run the
+// same command
+type CommentEditTracker struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	timers   map[string]*time.Timer // by comment key, e.g. issue_comment/123
+	executed map[string]executedCommand
+}
+
+type executedCommand struct {
+	key string
+	at  time.Time
+}
+
+func NewCommentEditTracker(delay time.Duration) *CommentEditTracker {
+	return &CommentEditTracker{
+		delay:    delay,
+		timers:   make(map[string]*time.Timer),
+		executed: make(map[string]executedCommand),
+	}
+}
+
+// Debounce runs fn once the comment has gone delay without another edit;
+// an edit inside the window replaces the pending run. Comments are keyed by
+// event type and ID, since each type numbers its own.
+func (ct *CommentEditTracker) Debounce(commentKey string, fn func()) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if timer, ok := ct.timers[commentKey]; ok {
+		timer.Stop()
+	}
+	ct.timers[commentKey] = time.AfterFunc(ct.delay, func() {
+		ct.mu.Lock()
+		delete(ct.timers, commentKey)
+		ct.mu.Unlock()
+		fn()
+	})
+}
+
+// MarkExecuted records that a comment ran cmd, returning false when it
+// already ran exactly this command
+func (ct *CommentEditTracker) MarkExecuted(commentKey string, cmd *types.Command) bool {
+	key := CommandKey(cmd)
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for id, entry := range ct.executed {
+		if time.Since(entry.at) > editTrackerRetention {
+			delete(ct.executed, id)
+		}
+	}
+
+	if entry, ok := ct.executed[commentKey]; ok && entry.key == key {
+		return false
+	}
+	ct.executed[commentKey] = executedCommand{key: key, at: time.Now()}
+	return true
+}
+
+// CommandKey identifies a command by what it does, ignoring who sent it
+func CommandKey(cmd *types.Command) string {
+	return fmt.Sprintf("%s %s %v", cmd.Type, cmd.Service, cmd.Args)
+}
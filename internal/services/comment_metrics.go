@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// commentLatencyWindow is how many recent replies each command's latency
+// percentiles are computed over
+const commentLatencyWindow = 500
+
+// commentLatencyQuantiles are the quantiles reported for reply latency
+var commentLatencyQuantiles = []float64{0.5, 0.95, 0.99}
+
+// latencyWindow keeps the latest latencies of one command, oldest
+// overwritten first
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	count   int64
+	sum     time.Duration
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.samples) < commentLatencyWindow {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % commentLatencyWindow
+	}
+	w.count++
+	w.sum += latency
+}
+
+// quantile is the q-th quantile of the window, by nearest rank
+func (w *latencyWindow) quantile(q float64) time.Duration {
+	if len(w.samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// CommentMetrics tracks how responsive the bot is on GitHub: the time from
+// a command's webhook arriving to its reply being posted, how many posts
+// GitHub refuses, and how many in-place edits find their comment changed
+// or gone
+type CommentMetrics struct {
+	mu       sync.Mutex
+	latency  map[string]*latencyWindow // by command type
+	all      latencyWindow
+	posted   int64
+	failed   int64
+	edited   int64
+	conflict int64
+}
+
+// sharedCommentMetrics is fed by every GitHubClient and the webhook
+// handler, like the comment outbox
+var sharedCommentMetrics = &CommentMetrics{latency: map[string]*latencyWindow{}}
+
+// SharedCommentMetrics is the tracker comment posts report to
+func SharedCommentMetrics() *CommentMetrics {
+	return sharedCommentMetrics
+}
+
+// ObserveReply records a command's end-to-end latency, from its webhook
+// being received to its reply being posted
+func (m *CommentMetrics) ObserveReply(command string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	window, ok := m.latency[command]
+	if !ok {
+		window = &latencyWindow{}
+		m.latency[command] = window
+	}
+	window.add(latency)
+	m.all.add(latency)
+}
+
+// RecordPost counts one attempt to post a comment, retries included
+func (m *CommentMetrics) RecordPost(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.posted++
+	if err != nil {
+		m.failed++
+	}
+}
+
+// RecordEdit counts one in-place comment edit; conflict is when the comment
+// was deleted or changed between finding and editing it
+func (m *CommentMetrics) RecordEdit(conflict bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.edited++
+	if conflict {
+		m.conflict++
+	}
+}
+
+// P95 is the 95th percentile reply latency over the recent window of all
+// commands
+func (m *CommentMetrics) P95() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.all.quantile(0.95)
+}
+
+// Snapshot returns the counters, failure rate and latency percentiles
+// overall and per command, for the stats endpoint
+func (m *CommentMetrics) Snapshot() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failureRate := 0.0
+	if m.posted > 0 {
+		failureRate = float64(m.failed) / float64(m.posted)
+	}
+	commands := make(map[string]interface{}, len(m.latency))
+	for command, window := range m.latency {
+		commands[command] = latencySummary(window)
+	}
+	return map[string]interface{}{
+		"posted":         m.posted,
+		"post_failures":  m.failed,
+		"failure_rate":   failureRate,
+		"edits":          m.edited,
+		"edit_conflicts": m.conflict,
+		"latency":        latencySummary(&m.all),
+		"by_command":     commands,
+	}
+}
+
+func latencySummary(window *latencyWindow) map[string]interface{} {
+	return map[string]interface{}{
+		"replies":     window.count,
+		"p50_seconds": window.quantile(0.5).Seconds(),
+		"p95_seconds": window.quantile(0.95).Seconds(),
+		"p99_seconds": window.quantile(0.99).Seconds(),
+	}
+}
+
+// WritePrometheus writes the latencies as a summary per command and the
+// counters in the Prometheus text format
+func (m *CommentMetrics) WritePrometheus(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pr_previews_command_reply_seconds Time from a command's webhook arriving to its reply being posted, over recent replies.")
+	fmt.Fprintln(w, "# TYPE pr_previews_command_reply_seconds summary")
+	commands := make([]string, 0, len(m.latency))
+	for command := range m.latency {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	for _, command := range commands {
+		window := m.latency[command]
+		for _, q := range commentLatencyQuantiles {
+			fmt.Fprintf(w, "pr_previews_command_reply_seconds{command=\"%s\",quantile=\"%g\"} %g\n", escapeLabelValue(command), q, window.quantile(q).Seconds())
+		}
+		fmt.Fprintf(w, "pr_previews_command_reply_seconds_sum{command=\"%s\"} %g\n", escapeLabelValue(command), window.sum.Seconds())
+		fmt.Fprintf(w, "pr_previews_command_reply_seconds_count{command=\"%s\"} %d\n", escapeLabelValue(command), window.count)
+	}
+
+	fmt.Fprintln(w, "# HELP pr_previews_comment_posts_total Attempts to post a comment on GitHub, retries included.")
+	fmt.Fprintln(w, "# TYPE pr_previews_comment_posts_total counter")
+	fmt.Fprintf(w, "pr_previews_comment_posts_total %d\n", m.posted)
+	fmt.Fprintln(w, "# HELP pr_previews_comment_post_failures_total Comment posts GitHub refused or that never reached it.")
+	fmt.Fprintln(w, "# TYPE pr_previews_comment_post_failures_total counter")
+	fmt.Fprintf(w, "pr_previews_comment_post_failures_total %d\n", m.failed)
+	fmt.Fprintln(w, "# HELP pr_previews_comment_edits_total In-place edits of the bot's comments.")
+	fmt.Fprintln(w, "# TYPE pr_previews_comment_edits_total counter")
+	fmt.Fprintf(w, "pr_previews_comment_edits_total %d\n", m.edited)
+	fmt.Fprintln(w, "# HELP pr_previews_comment_edit_conflicts_total Edits that found their comment deleted or changed since it was looked up.")
+	fmt.Fprintln(w, "# TYPE pr_previews_comment_edit_conflicts_total counter")
+	fmt.Fprintf(w, "pr_previews_comment_edit_conflicts_total %d\n", m.conflict)
+}
@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pending comments live in the artifact store one key each, so replicas
+// queueing and posting them at once don't overwrite each other.
+// commentOutboxLegacyKey is the single file they used to share, moved over
+// on first use.
+const (
+	commentOutboxPrefix    = "github/pending-comments/"
+	commentOutboxLegacyKey = "github/pending-comments.json"
+
+	// commentClaimTTL is how long a replica posting a comment holds it
+	// before another may try
+	commentClaimTTL = 2 * time.Minute
+)
+
+// ErrCommentClaimed is returned for a retry of a comment another replica is
+// posting right now
+var ErrCommentClaimed = errors.New("another replica is posting this comment")
+
+// PendingComment is a comment GitHub refused, kept until a retry posts it
+type PendingComment struct {
+	ID           string    `json:"id"`
+	Repo         string    `json:"repo"`
+	PRNumber     int       `json:"pr_number"`
+	Body         string    `json:"body"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"last_error"`
+	CreatedAt    time.Time `json:"created_at"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	Stuck        bool      `json:"stuck"` // out of attempts, or refused for good; only an admin retry posts it now
+	ClaimedBy    string    `json:"claimed_by,omitempty"`
+	ClaimedUntil time.Time `json:"claimed_until"`
+}
+
+// CommentOutbox keeps comments that failed to post and retries them with
+// exponential backoff, so a rate limit or an outage at GitHub delays a
+// result instead of losing it. Comments GitHub refused for good, e.g. for a
+// token missing a scope, are kept stuck for an admin to retry. Pending
+// comments are persisted in the artifact store so they survive restarts and
+// every replica sees them; only the leader retries them, and whoever posts
+// one claims it first.
+type CommentOutbox struct {
+	mu       sync.Mutex
+	store    ArtifactStore
+	replica  string
+	backoff  time.Duration
+	max      time.Duration
+	attempts int
+	pending  map[string]*PendingComment // without a store
+	migrated bool
+
+	failures  atomic.Int64 // posts that failed, retries included
+	delivered atomic.Int64 // pending comments a retry got posted
+}
+
+// sharedCommentOutbox collects failed posts from every GitHubClient, like
+// the API cache they share
+var sharedCommentOutbox = &CommentOutbox{backoff: 30 * time.Second, max: 30 * time.Minute, attempts: 8, pending: map[string]*PendingComment{}}
+
+// SharedCommentOutbox is the outbox GitHubClient hands failed comments to
+func SharedCommentOutbox() *CommentOutbox {
+	return sharedCommentOutbox
+}
+
+// Configure sets where pending comments are persisted, the replica claiming
+// them and how often they are retried. Without a store they are only kept
+// in memory.
+func (o *CommentOutbox) Configure(store ArtifactStore, replica string, backoff, max time.Duration, attempts int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.store = store
+	o.replica = replica
+	if backoff > 0 {
+		o.backoff = backoff
+	}
+	if max >= o.backoff {
+		o.max = max
+	}
+	if attempts > 0 {
+		o.attempts = attempts
+	}
+	o.migrated = false
+}
+
+func commentOutboxKey(id string) string {
+	return commentOutboxPrefix + id + ".json"
+}
+
+// retryableCommentError reports whether a failed post may succeed later on
+// its own: rate limits, GitHub's own errors and network failures. Other
+// refusals, such as 403 for a missing scope or 422, fail the same way every
+// time.
+func retryableCommentError(err error) bool {
+	var limited *githubRateLimitError
+	if errors.As(err, &limited) {
+		return true
+	}
+	var statusErr *githubStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500
+	}
+	return true
+}
+
+// Add queues a comment that failed to post. A rate limit's reset time, when
+// GitHub sent one, delays the first retry past it. One GitHub refused for
+// good is queued stuck.
+func (o *CommentOutbox) Add(ctx context.Context, repo string, prNumber int, body string, postErr error) *PendingComment {
+	o.failures.Add(1)
+	now := time.Now().UTC()
+	comment := &PendingComment{
+		ID:        fmt.Sprintf("%d", now.UnixNano()),
+		Repo:      repo,
+		PRNumber:  prNumber,
+		Body:      body,
+		Attempts:  1,
+		LastError: postErr.Error(),
+		CreatedAt: now,
+		Stuck:     !retryableCommentError(postErr),
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	comment.NextAttempt = o.nextAttemptLocked(comment.Attempts, postErr)
+	if err := o.saveLocked(ctx, comment); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if comment.Stuck {
+		fmt.Printf("⚠️  Comment on %s#%d was refused and is stuck until an admin retries it: %v\n", repo, prNumber, postErr)
+	} else {
+		fmt.Printf("⚠️  Comment on %s#%d queued for retry at %s: %v\n", repo, prNumber, comment.NextAttempt.Format(time.RFC3339), postErr)
+	}
+	return comment
+}
+
+// Run retries due comments through client until ctx is cancelled, while
+// this replica is the leader
+func (o *CommentOutbox) Run(ctx context.Context, client *GitHubClient) {
+	o.mu.Lock()
+	interval := o.backoff
+	o.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if SharedLeader().IsLeader() {
+			o.RetryDue(ctx, client)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RetryDue posts every comment whose backoff has passed. Comments that fail
+// again wait longer, until they run out of attempts and are left stuck.
+func (o *CommentOutbox) RetryDue(ctx context.Context, client *GitHubClient) {
+	o.mu.Lock()
+	comments, err := o.loadLocked(ctx)
+	o.mu.Unlock()
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, comment := range comments {
+		if comment.Stuck || now.Before(comment.NextAttempt) {
+			continue
+		}
+		o.mu.Lock()
+		claimed, err := o.claimLocked(ctx, comment.ID)
+		o.mu.Unlock()
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		if claimed == nil {
+			continue
+		}
+		// Posting happens unlocked; the outcome is applied after
+		err = client.postComment(ctx, claimed.Repo, claimed.PRNumber, claimed.Body)
+		o.mu.Lock()
+		o.recordAttemptLocked(ctx, claimed.ID, err, false)
+		o.mu.Unlock()
+	}
+}
+
+// Retry posts one pending comment now, even a stuck one
+func (o *CommentOutbox) Retry(ctx context.Context, client *GitHubClient, id string) (*PendingComment, error) {
+	o.mu.Lock()
+	if _, err := o.getLocked(ctx, id); err != nil {
+		o.mu.Unlock()
+		return nil, err
+	}
+	snapshot, err := o.claimLocked(ctx, id)
+	o.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, ErrCommentClaimed
+	}
+
+	err = client.postComment(ctx, snapshot.Repo, snapshot.PRNumber, snapshot.Body)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	updated := o.recordAttemptLocked(ctx, id, err, true)
+	if err != nil {
+		return updated, err
+	}
+	return snapshot, nil
+}
+
+// Discard drops a pending comment without posting it
+func (o *CommentOutbox) Discard(ctx context.Context, id string) (*PendingComment, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	comment, err := o.getLocked(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.deleteLocked(ctx, id); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// List returns the pending comments, oldest first
+func (o *CommentOutbox) List(ctx context.Context) ([]PendingComment, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.loadLocked(ctx)
+}
+
+// Stats counts pending and stuck comments for /metrics
+func (o *CommentOutbox) Stats() map[string]int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	comments, err := o.loadLocked(context.Background())
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	var pending, stuck int64
+	for _, comment := range comments {
+		if comment.Stuck {
+			stuck++
+		} else {
+			pending++
+		}
+	}
+	return map[string]int64{
+		"pending":   pending,
+		"stuck":     stuck,
+		"failures":  o.failures.Load(),
+		"delivered": o.delivered.Load(),
+	}
+}
+
+// claimLocked marks a comment as being posted by this replica and returns
+// it, or nil when another replica holds it. The artifact store has no
+// compare-and-set, so the claim is read back to see whose write won.
+func (o *CommentOutbox) claimLocked(ctx context.Context, id string) (*PendingComment, error) {
+	comment, err := o.getLocked(ctx, id)
+	if err != nil {
+		// Posted or discarded since it was listed
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	if comment.ClaimedBy != "" && comment.ClaimedBy != o.replica && now.Before(comment.ClaimedUntil) {
+		return nil, nil
+	}
+	comment.ClaimedBy = o.replica
+	comment.ClaimedUntil = now.Add(commentClaimTTL)
+	if err := o.saveLocked(ctx, comment); err != nil {
+		return nil, err
+	}
+	if o.store == nil {
+		return comment, nil
+	}
+	comment, err = o.getLocked(ctx, id)
+	if err != nil || comment.ClaimedBy != o.replica {
+		return nil, nil
+	}
+	return comment, nil
+}
+
+// recordAttemptLocked removes a comment a retry posted, or pushes its next
+// attempt back and releases it. A manual retry that fails, or a refusal
+// that won't change, leaves the comment stuck.
+func (o *CommentOutbox) recordAttemptLocked(ctx context.Context, id string, postErr error, manual bool) *PendingComment {
+	comment, err := o.getLocked(ctx, id)
+	if err != nil {
+		// Discarded while it was being posted
+		return nil
+	}
+
+	if postErr == nil {
+		o.delivered.Add(1)
+		if err := o.deleteLocked(ctx, id); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		fmt.Printf("✅ Pending comment on %s#%d posted after %d failed attempts\n", comment.Repo, comment.PRNumber, comment.Attempts)
+		return comment
+	}
+
+	o.failures.Add(1)
+	comment.Attempts++
+	comment.LastError = postErr.Error()
+	comment.NextAttempt = o.nextAttemptLocked(comment.Attempts, postErr)
+	comment.ClaimedBy = ""
+	comment.ClaimedUntil = time.Time{}
+	if manual || comment.Attempts >= o.attempts || !retryableCommentError(postErr) {
+		if !comment.Stuck {
+			fmt.Printf("⚠️  Comment on %s#%d is stuck after %d attempts: %v\n", comment.Repo, comment.PRNumber, comment.Attempts, postErr)
+		}
+		comment.Stuck = true
+	}
+	if err := o.saveLocked(ctx, comment); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return comment
+}
+
+// nextAttemptLocked doubles the backoff for each failed attempt, up to the
+// maximum, and never retries before a rate limit resets
+func (o *CommentOutbox) nextAttemptLocked(attempts int, postErr error) time.Time {
+	wait := o.backoff
+	for i := 1; i < attempts && wait < o.max; i++ {
+		wait *= 2
+	}
+	if wait > o.max {
+		wait = o.max
+	}
+	next := time.Now().Add(wait).UTC()
+
+	var limited *githubRateLimitError
+	if errors.As(postErr, &limited) && limited.ResetAt.After(next) {
+		next = limited.ResetAt.UTC()
+	}
+	return next
+}
+
+// loadLocked returns every pending comment, oldest first
+func (o *CommentOutbox) loadLocked(ctx context.Context) ([]PendingComment, error) {
+	var comments []PendingComment
+	if o.store == nil {
+		for _, comment := range o.pending {
+			comments = append(comments, *comment)
+		}
+	} else {
+		if err := o.migrateLocked(ctx); err != nil {
+			return nil, err
+		}
+		keys, err := o.store.List(ctx, commentOutboxPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load pending comments: %v", err)
+		}
+		for _, key := range keys {
+			id, ok := strings.CutSuffix(path.Base(key), ".json")
+			if !ok || !strings.HasPrefix(key, commentOutboxPrefix) {
+				continue
+			}
+			comment, err := o.getLocked(ctx, id)
+			if err != nil {
+				// Posted by another replica since it was listed
+				continue
+			}
+			comments = append(comments, *comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+	return comments, nil
+}
+
+func (o *CommentOutbox) getLocked(ctx context.Context, id string) (*PendingComment, error) {
+	if o.store == nil {
+		comment, ok := o.pending[id]
+		if !ok {
+			return nil, fmt.Errorf("no pending comment %s", id)
+		}
+		snapshot := *comment
+		return &snapshot, nil
+	}
+	if strings.ContainsAny(id, "/.") {
+		return nil, fmt.Errorf("no pending comment %s", id)
+	}
+	content, err := o.store.Get(ctx, commentOutboxKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("no pending comment %s", id)
+	}
+	var comment PendingComment
+	if err := json.Unmarshal(content, &comment); err != nil {
+		return nil, fmt.Errorf("failed to parse pending comment %s: %v", id, err)
+	}
+	return &comment, nil
+}
+
+func (o *CommentOutbox) saveLocked(ctx context.Context, comment *PendingComment) error {
+	if o.store == nil {
+		snapshot := *comment
+		o.pending[comment.ID] = &snapshot
+		return nil
+	}
+	content, err := json.MarshalIndent(comment, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := o.store.Save(ctx, commentOutboxKey(comment.ID), content); err != nil {
+		return fmt.Errorf("failed to save pending comment %s: %v", comment.ID, err)
+	}
+	return nil
+}
+
+func (o *CommentOutbox) deleteLocked(ctx context.Context, id string) error {
+	if o.store == nil {
+		delete(o.pending, id)
+		return nil
+	}
+	if err := o.store.Delete(ctx, commentOutboxKey(id)); err != nil {
+		return fmt.Errorf("failed to remove pending comment %s: %v", id, err)
+	}
+	return nil
+}
+
+// migrateLocked moves the comments of the shared file to keys of their own.
+// It's retried until it succeeds.
+func (o *CommentOutbox) migrateLocked(ctx context.Context) error {
+	if o.migrated {
+		return nil
+	}
+	keys, err := o.store.List(ctx, commentOutboxLegacyKey)
+	if err != nil {
+		return fmt.Errorf("failed to load pending comments: %v", err)
+	}
+	for _, key := range keys {
+		if key != commentOutboxLegacyKey {
+			continue
+		}
+		content, err := o.store.Get(ctx, commentOutboxLegacyKey)
+		if err != nil {
+			return fmt.Errorf("failed to load pending comments: %v", err)
+		}
+		var comments []PendingComment
+		if err := json.Unmarshal(content, &comments); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", commentOutboxLegacyKey, err)
+		}
+		for i := range comments {
+			if err := o.saveLocked(ctx, &comments[i]); err != nil {
+				return err
+			}
+		}
+		if err := o.store.Delete(ctx, commentOutboxLegacyKey); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", commentOutboxLegacyKey, err)
+		}
+	}
+	o.migrated = true
+	return nil
+}
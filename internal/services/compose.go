@@ -0,0 +1,626 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// composeServiceName is the preview service that deploys the whole compose file
+const composeServiceName = "compose"
+
+// BackendCompose deploys a docker-compose file converted to Deployments and Services
+const BackendCompose = "compose"
+
+// composeVolumeSize is the claim size for named compose volumes
+const composeVolumeSize = "1Gi"
+
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// findComposeFile returns the repo's compose file, or "" when there is none
+func findComposeFile(repoPath string) string {
+	for _, name := range composeFileNames {
+		path := filepath.Join(repoPath, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Volumes  map[string]yaml.Node      `yaml:"volumes"`
+	Networks yaml.Node                 `yaml:"networks"`
+	Secrets  yaml.Node                 `yaml:"secrets"`
+	Configs  yaml.Node                 `yaml:"configs"`
+}
+
+type composeService struct {
+	Image       string        `yaml:"image"`
+	Build       yaml.Node     `yaml:"build"`
+	Command     yaml.Node     `yaml:"command"`
+	Entrypoint  yaml.Node     `yaml:"entrypoint"`
+	Environment yaml.Node     `yaml:"environment"`
+	EnvFile     yaml.Node     `yaml:"env_file"`
+	Ports       []yaml.Node   `yaml:"ports"`
+	Expose      []yaml.Node   `yaml:"expose"`
+	Volumes     []yaml.Node   `yaml:"volumes"`
+	WorkingDir  string        `yaml:"working_dir"`
+	Healthcheck *composeCheck `yaml:"healthcheck"`
+	Deploy      composeDeploy `yaml:"deploy"`
+	MemLimit    string        `yaml:"mem_limit"`
+	CPUs        yaml.Node     `yaml:"cpus"`
+	DependsOn   yaml.Node     `yaml:"depends_on"`
+	Networks    yaml.Node     `yaml:"networks"`
+	NetworkMode string        `yaml:"network_mode"`
+	Privileged  bool          `yaml:"privileged"`
+	Secrets     yaml.Node     `yaml:"secrets"`
+	Configs     yaml.Node     `yaml:"configs"`
+	Restart     string        `yaml:"restart"`
+}
+
+type composeCheck struct {
+	Test     yaml.Node `yaml:"test"`
+	Interval string    `yaml:"interval"`
+	Timeout  string    `yaml:"timeout"`
+	Retries  int32     `yaml:"retries"`
+	Disable  bool      `yaml:"disable"`
+}
+
+type composeDeploy struct {
+	Replicas  *int32 `yaml:"replicas"`
+	Resources struct {
+		Limits       composeResources `yaml:"limits"`
+		Reservations composeResources `yaml:"reservations"`
+	} `yaml:"resources"`
+}
+
+type composeResources struct {
+	CPUs   string `yaml:"cpus"`
+	Memory string `yaml:"memory"`
+}
+
+// ComposeConversion is a converted compose file and what could not be carried over
+type ComposeConversion struct {
+	Manifest    *ParsedManifest
+	Unsupported []string
+}
+
+// ConvertComposeFile turns a docker-compose file into Deployments, Services and
+// PersistentVolumeClaims for a preview namespace, kompose-style. Compose
+// features with no preview equivalent are listed rather than failing the deploy.
+func ConvertComposeFile(path string) (*ComposeConversion, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %v", err)
+	}
+	return ConvertCompose(content)
+}
+
+// ConvertCompose converts compose file content; see ConvertComposeFile
+func ConvertCompose(content []byte) (*ComposeConversion, error) {
+	var file composeFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse compose file: %v", err)
+	}
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("compose file defines no services")
+	}
+
+	conversion := &ComposeConversion{
+		Manifest: &ParsedManifest{
+			Deployments:  []appsv1.Deployment{},
+			StatefulSets: []appsv1.StatefulSet{},
+			Services:     []corev1.Service{},
+			ConfigMaps:   []corev1.ConfigMap{},
+			Secrets:      []corev1.Secret{},
+
+			PersistentVolumeClaims:   []corev1.PersistentVolumeClaim{},
+			HorizontalPodAutoscalers: []autoscalingv2.HorizontalPodAutoscaler{},
+		},
+	}
+	unsupported := func(format string, args ...interface{}) {
+		conversion.Unsupported = append(conversion.Unsupported, fmt.Sprintf(format, args...))
+	}
+
+	if !file.Networks.IsZero() {
+		unsupported("top-level `networks` are ignored; every service shares the preview namespace")
+	}
+	if !file.Secrets.IsZero() || !file.Configs.IsZero() {
+		unsupported("top-level `secrets` and `configs` are ignored; use `.pr-previews.yaml` secrets instead")
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	claims := map[string]bool{}
+	for _, name := range names {
+		svc := file.Services[name]
+		k8sName := composeResourceName(name)
+
+		if svc.Image == "" {
+			unsupported("`%s`: no `image` and `build` is not supported; the service was skipped", name)
+			continue
+		}
+		if !svc.Build.IsZero() {
+			unsupported("`%s`: `build` is ignored; `%s` is pulled instead", name, svc.Image)
+		}
+
+		container := corev1.Container{
+			Name:       k8sName,
+			Image:      svc.Image,
+			WorkingDir: svc.WorkingDir,
+			Command:    composeCommand(svc.Entrypoint),
+			Args:       composeCommand(svc.Command),
+		}
+
+		env, interpolated := composeEnvironment(svc.Environment)
+		container.Env = env
+		if interpolated {
+			unsupported("`%s`: `${VAR}` interpolation is not resolved; defaults are used where given", name)
+		}
+		if !svc.EnvFile.IsZero() {
+			unsupported("`%s`: `env_file` is ignored", name)
+		}
+
+		var servicePorts []corev1.ServicePort
+		seenPorts := map[int32]bool{}
+		addPort := func(published, target int32, protocol corev1.Protocol) {
+			if seenPorts[published] {
+				return
+			}
+			seenPorts[published] = true
+			container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: target, Protocol: protocol})
+			servicePorts = append(servicePorts, corev1.ServicePort{
+				Name:       fmt.Sprintf("port-%d", published),
+				Port:       published,
+				TargetPort: intstr.FromInt32(target),
+				Protocol:   protocol,
+			})
+		}
+		for _, port := range svc.Ports {
+			published, target, protocol, err := parseComposePort(port)
+			if err != nil {
+				unsupported("`%s`: port %s", name, err.Error())
+				continue
+			}
+			addPort(published, target, protocol)
+		}
+		for _, port := range svc.Expose {
+			published, target, protocol, err := parseComposePort(port)
+			if err != nil {
+				unsupported("`%s`: expose %s", name, err.Error())
+				continue
+			}
+			addPort(published, target, protocol)
+		}
+
+		var volumes []corev1.Volume
+		for i, node := range svc.Volumes {
+			source, target, readOnly := parseComposeVolume(node)
+			if target == "" {
+				unsupported("`%s`: could not read volume %d", name, i+1)
+				continue
+			}
+			volumeName := fmt.Sprintf("%s-%d", k8sName, i)
+			var volumeSource corev1.VolumeSource
+			switch {
+			case source == "":
+				volumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			case strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~"):
+				unsupported("`%s`: bind mount `%s` is replaced by an empty directory", name, source)
+				volumeSource.EmptyDir = &corev1.EmptyDirVolumeSource{}
+			default:
+				claimName := composeResourceName(source)
+				volumeName = claimName
+				volumeSource.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}
+				if !claims[claimName] {
+					claims[claimName] = true
+					conversion.Manifest.PersistentVolumeClaims = append(conversion.Manifest.PersistentVolumeClaims, composeClaim(claimName))
+				}
+			}
+			volumes = append(volumes, corev1.Volume{Name: volumeName, VolumeSource: volumeSource})
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: volumeName, MountPath: target, ReadOnly: readOnly})
+		}
+
+		resources, err := composeContainerResources(svc)
+		if err != nil {
+			unsupported("`%s`: %s", name, err.Error())
+		}
+		container.Resources = resources
+
+		if svc.Healthcheck != nil && !svc.Healthcheck.Disable {
+			probe, err := composeProbe(svc.Healthcheck)
+			if err != nil {
+				unsupported("`%s`: healthcheck %s", name, err.Error())
+			} else {
+				container.ReadinessProbe = probe
+			}
+		}
+
+		if !svc.DependsOn.IsZero() {
+			unsupported("`%s`: `depends_on` start order is not enforced; services start together", name)
+		}
+		if !svc.Networks.IsZero() || svc.NetworkMode != "" {
+			unsupported("`%s`: networks are ignored; services reach each other by name", name)
+		}
+		if svc.Privileged {
+			unsupported("`%s`: `privileged` is not carried over", name)
+		}
+		if !svc.Secrets.IsZero() || !svc.Configs.IsZero() {
+			unsupported("`%s`: `secrets` and `configs` are ignored", name)
+		}
+		if svc.Restart == "no" {
+			unsupported("`%s`: `restart: no` is ignored; Deployments always restart their pods", name)
+		}
+
+		labels := map[string]string{"app": k8sName}
+		replicas := int32(1)
+		if svc.Deploy.Replicas != nil {
+			replicas = *svc.Deploy.Replicas
+		}
+		conversion.Manifest.Deployments = append(conversion.Manifest.Deployments, appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: k8sName, Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{container},
+						Volumes:    volumes,
+					},
+				},
+			},
+		})
+
+		// Only services with ports are reachable by name, as in compose
+		if len(servicePorts) > 0 {
+			conversion.Manifest.Services = append(conversion.Manifest.Services, corev1.Service{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+				ObjectMeta: metav1.ObjectMeta{Name: k8sName, Labels: labels},
+				Spec: corev1.ServiceSpec{
+					Selector: labels,
+					Ports:    servicePorts,
+				},
+			})
+		}
+	}
+
+	if len(conversion.Manifest.Deployments) == 0 {
+		return nil, fmt.Errorf("no compose service could be converted:\n%s", strings.Join(conversion.Unsupported, "\n"))
+	}
+	return conversion, nil
+}
+
+var invalidResourceNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// composeResourceName makes a compose name a valid Kubernetes name
+func composeResourceName(name string) string {
+	name = invalidResourceNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+func composeClaim(name string) corev1.PersistentVolumeClaim {
+	return corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(composeVolumeSize)},
+			},
+		},
+	}
+}
+
+// composeCommand reads a command or entrypoint given as a list or a string
+func composeCommand(node yaml.Node) []string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var parts []string
+		if err := node.Decode(&parts); err == nil {
+			return parts
+		}
+	case yaml.ScalarNode:
+		return splitShellWords(node.Value)
+	}
+	return nil
+}
+
+// splitShellWords splits on whitespace, honouring single and double quotes
+func splitShellWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+var composeVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::?-([^}]*))?\}`)
+
+// composeEnvironment reads environment given as a map or a KEY=value list.
+// ${VAR:-default} resolves to its default; it reports whether any variable
+// was left unresolved.
+func composeEnvironment(node yaml.Node) ([]corev1.EnvVar, bool) {
+	values := map[string]string{}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			values[node.Content[i].Value] = node.Content[i+1].Value
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			key, value, _ := strings.Cut(item.Value, "=")
+			values[key] = value
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	interpolated := false
+	env := make([]corev1.EnvVar, 0, len(keys))
+	for _, key := range keys {
+		value := composeVariable.ReplaceAllStringFunc(values[key], func(match string) string {
+			groups := composeVariable.FindStringSubmatch(match)
+			if strings.Contains(match, "-") {
+				return groups[2]
+			}
+			interpolated = true
+			return ""
+		})
+		env = append(env, corev1.EnvVar{Name: key, Value: value})
+	}
+	return env, interpolated
+}
+
+// parseComposePort reads "8080:80", "127.0.0.1:8080:80/udp", "80" or the long
+// {target, published, protocol} syntax
+func parseComposePort(node yaml.Node) (published, target int32, protocol corev1.Protocol, err error) {
+	protocol = corev1.ProtocolTCP
+
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Target    int32  `yaml:"target"`
+			Published string `yaml:"published"`
+			Protocol  string `yaml:"protocol"`
+		}
+		if err := node.Decode(&long); err != nil || long.Target == 0 {
+			return 0, 0, protocol, fmt.Errorf("mapping without a target could not be read")
+		}
+		target, published = long.Target, long.Target
+		if long.Published != "" {
+			port, err := strconv.ParseInt(long.Published, 10, 32)
+			if err != nil {
+				return 0, 0, protocol, fmt.Errorf("range %q is not supported", long.Published)
+			}
+			published = int32(port)
+		}
+		if strings.EqualFold(long.Protocol, "udp") {
+			protocol = corev1.ProtocolUDP
+		}
+		return published, target, protocol, nil
+	}
+
+	spec := node.Value
+	if base, proto, ok := strings.Cut(spec, "/"); ok {
+		spec = base
+		if strings.EqualFold(proto, "udp") {
+			protocol = corev1.ProtocolUDP
+		}
+	}
+	parts := strings.Split(spec, ":")
+	targetPort, err := strconv.ParseInt(parts[len(parts)-1], 10, 32)
+	if err != nil {
+		return 0, 0, protocol, fmt.Errorf("%q is not supported", node.Value)
+	}
+	publishedPort := targetPort
+	if len(parts) > 1 && parts[len(parts)-2] != "" {
+		publishedPort, err = strconv.ParseInt(parts[len(parts)-2], 10, 32)
+		if err != nil {
+			return 0, 0, protocol, fmt.Errorf("%q is not supported", node.Value)
+		}
+	}
+	return int32(publishedPort), int32(targetPort), protocol, nil
+}
+
+// parseComposeVolume reads "source:target[:ro]", "target" or the long syntax
+func parseComposeVolume(node yaml.Node) (source, target string, readOnly bool) {
+	if node.Kind == yaml.MappingNode {
+		var long struct {
+			Source   string `yaml:"source"`
+			Target   string `yaml:"target"`
+			ReadOnly bool   `yaml:"read_only"`
+		}
+		if err := node.Decode(&long); err != nil {
+			return "", "", false
+		}
+		return long.Source, long.Target, long.ReadOnly
+	}
+
+	parts := strings.Split(node.Value, ":")
+	switch len(parts) {
+	case 1:
+		return "", parts[0], false
+	case 2:
+		return parts[0], parts[1], false
+	default:
+		return parts[0], parts[1], strings.Contains(parts[2], "ro")
+	}
+}
+
+// composeContainerResources maps deploy.resources (or mem_limit/cpus) to
+// limits and requests
+func composeContainerResources(svc composeService) (corev1.ResourceRequirements, error) {
+	var requirements corev1.ResourceRequirements
+	limits := svc.Deploy.Resources.Limits
+	if limits.Memory == "" {
+		limits.Memory = svc.MemLimit
+	}
+	if limits.CPUs == "" {
+		limits.CPUs = svc.CPUs.Value
+	}
+
+	toList := func(res composeResources) (corev1.ResourceList, error) {
+		list := corev1.ResourceList{}
+		if res.CPUs != "" {
+			cpu, err := resource.ParseQuantity(res.CPUs)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpus %q", res.CPUs)
+			}
+			list[corev1.ResourceCPU] = cpu
+		}
+		if res.Memory != "" {
+			memory, err := parseComposeMemory(res.Memory)
+			if err != nil {
+				return nil, err
+			}
+			list[corev1.ResourceMemory] = memory
+		}
+		if len(list) == 0 {
+			return nil, nil
+		}
+		return list, nil
+	}
+
+	var err error
+	if requirements.Limits, err = toList(limits); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	if requirements.Requests, err = toList(svc.Deploy.Resources.Reservations); err != nil {
+		return corev1.ResourceRequirements{}, err
+	}
+	return requirements, nil
+}
+
+// parseComposeMemory reads compose byte values, where k, m and g are binary
+// units ("512m" is 512Mi)
+func parseComposeMemory(value string) (resource.Quantity, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	v = strings.TrimSuffix(v, "b")
+	suffixes := map[byte]string{'k': "Ki", 'm': "Mi", 'g': "Gi"}
+	if len(v) > 0 {
+		if suffix, ok := suffixes[v[len(v)-1]]; ok {
+			v = v[:len(v)-1] + suffix
+		}
+	}
+	quantity, err := resource.ParseQuantity(v)
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("invalid memory %q", value)
+	}
+	return quantity, nil
+}
+
+// composeProbe turns a CMD or CMD-SHELL healthcheck into a readiness probe
+func composeProbe(check *composeCheck) (*corev1.Probe, error) {
+	var command []string
+	switch check.Test.Kind {
+	case yaml.SequenceNode:
+		var test []string
+		if err := check.Test.Decode(&test); err != nil || len(test) < 2 {
+			return nil, fmt.Errorf("test could not be read")
+		}
+		switch test[0] {
+		case "CMD":
+			command = test[1:]
+		case "CMD-SHELL":
+			command = []string{"/bin/sh", "-c", strings.Join(test[1:], " ")}
+		default:
+			return nil, fmt.Errorf("test type %q is not supported", test[0])
+		}
+	case yaml.ScalarNode:
+		command = []string{"/bin/sh", "-c", check.Test.Value}
+	default:
+		return nil, fmt.Errorf("has no test")
+	}
+
+	probe := &corev1.Probe{
+		ProbeHandler:     corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: command}},
+		FailureThreshold: check.Retries,
+	}
+	if seconds, ok := composeSeconds(check.Interval); ok {
+		probe.PeriodSeconds = seconds
+	}
+	if seconds, ok := composeSeconds(check.Timeout); ok {
+		probe.TimeoutSeconds = seconds
+	}
+	return probe, nil
+}
+
+func composeSeconds(value string) (int32, bool) {
+	if value == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < time.Second {
+		return 0, false
+	}
+	return int32(d.Seconds()), true
+}
+
+// formatComposeReport lists what the conversion dropped or approximated
+func formatComposeReport(path string, conversion *ComposeConversion) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("### 🐳 Converted from `%s`\n", filepath.Base(path)))
+	content.WriteString(fmt.Sprintf("%d compose service(s) became Deployments.\n", len(conversion.Manifest.Deployments)))
+	if len(conversion.Unsupported) == 0 {
+		content.WriteString("\nEvery compose feature in use was carried over.\n")
+		return content.String()
+	}
+	content.WriteString("\n**Unsupported compose features:**\n")
+	for _, item := range conversion.Unsupported {
+		content.WriteString(fmt.Sprintf("- ⚠️ %s\n", item))
+	}
+	return content.String()
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Kinds of container .pr-previews.yaml can inject
+const (
+	InjectionSidecar = "sidecar"
+	InjectionInit    = "init"
+)
+
+var containerNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ContainerInjection is a sidecar (e.g. cloud-sql-proxy, a debug toolbox) or
+// init container added to the preview Deployments of the services that opt
+// in by listing it
+type ContainerInjection struct {
+	Name    string   `yaml:"name"`
+	Kind    string   `yaml:"kind"` // sidecar (default) or init
+	Image   string   `yaml:"image"`
+	Command []string `yaml:"command"`
+	Args    []string `yaml:"args"`
+
+	// Env values are templates rendered with .PR, .Service, .Branch
+	// (slugified), .Repo and .Domain (PREVIEW_DOMAIN), e.g.
+	// "acme:us-east1:db-pr-{{ .PR }}"
+	Env map[string]string `yaml:"env"`
+
+	Ports  []int32 `yaml:"ports"`
+	CPU    string  `yaml:"cpu"`    // request; empty leaves it to the namespace defaults
+	Memory string  `yaml:"memory"` // request
+
+	// Services opt in to the container; it is injected nowhere else
+	Services []string `yaml:"services"`
+}
+
+// injectionData is what an injected container's env templates can use
+type injectionData struct {
+	PR      int
+	Service string
+	Branch  string
+	Repo    string
+	Domain  string
+}
+
+func (ci ContainerInjection) validate() error {
+	if !containerNamePattern.MatchString(ci.Name) {
+		return fmt.Errorf("name %q must be a DNS label", ci.Name)
+	}
+	if ci.Kind != "" && ci.Kind != InjectionSidecar && ci.Kind != InjectionInit {
+		return fmt.Errorf("kind must be %s or %s, got %q", InjectionSidecar, InjectionInit, ci.Kind)
+	}
+	if ci.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if len(ci.Services) == 0 {
+		return fmt.Errorf("services must list the services that get the container")
+	}
+	for name, value := range ci.Env {
+		if _, err := template.New(name).Option("missingkey=error").Parse(value); err != nil {
+			return fmt.Errorf("invalid env template %s: %v", name, err)
+		}
+	}
+	for _, port := range ci.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	for _, quantity := range []string{ci.CPU, ci.Memory} {
+		if quantity == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(quantity); err != nil {
+			return fmt.Errorf("invalid resource request %q: %v", quantity, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the service opted in, by its name or its
+// namespace-safe form
+func (ci ContainerInjection) appliesTo(service string) bool {
+	cleanServiceName := strings.ReplaceAll(service, "/", "-")
+	for _, optedIn := range ci.Services {
+		if optedIn == service || optedIn == cleanServiceName {
+			return true
+		}
+	}
+	return false
+}
+
+// container renders the injection for one preview
+func (ci ContainerInjection) container(data injectionData) (corev1.Container, error) {
+	container := corev1.Container{
+		Name:    ci.Name,
+		Image:   ci.Image,
+		Command: ci.Command,
+		Args:    ci.Args,
+	}
+
+	names := make([]string, 0, len(ci.Env))
+	for name := range ci.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(ci.Env[name])
+		if err != nil {
+			return container, fmt.Errorf("invalid env template %s: %v", name, err)
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return container, fmt.Errorf("failed to render env %s of %s: %v", name, ci.Name, err)
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value.String()})
+	}
+
+	for _, port := range ci.Ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{ContainerPort: port})
+	}
+	if ci.CPU != "" || ci.Memory != "" {
+		container.Resources.Requests = corev1.ResourceList{}
+		if ci.CPU != "" {
+			container.Resources.Requests[corev1.ResourceCPU] = resource.MustParse(ci.CPU)
+		}
+		if ci.Memory != "" {
+			container.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(ci.Memory)
+		}
+	}
+	return container, nil
+}
+
+// InjectionsFor lists the containers the service opted in to
+func (c *RepoConfig) InjectionsFor(service string) []ContainerInjection {
+	var injections []ContainerInjection
+	for _, injection := range c.Containers {
+		if injection.appliesTo(service) {
+			injections = append(injections, injection)
+		}
+	}
+	return injections
+}
+
+// injectContainers adds the injections to every Deployment of the manifest,
+// returning a note per container added. Sidecars run next to the app; init
+// containers run before the manifest's own. A container the manifest
+// already declares under the same name is left as it is.
+func injectContainers(injections []ContainerInjection, data injectionData, parsed *ParsedManifest) ([]string, error) {
+	var changes []string
+	for _, injection := range injections {
+		container, err := injection.container(data)
+		if err != nil {
+			return nil, err
+		}
+		kind := injection.Kind
+		if kind == "" {
+			kind = InjectionSidecar
+		}
+
+		for i := range parsed.Deployments {
+			dep := &parsed.Deployments[i]
+			spec := &dep.Spec.Template.Spec
+			if hasContainer(spec, injection.Name) {
+				changes = append(changes, fmt.Sprintf("Kept Deployment/%s's own container %s instead of injecting one", dep.Name, injection.Name))
+				continue
+			}
+			if kind == InjectionInit {
+				spec.InitContainers = append([]corev1.Container{container}, spec.InitContainers...)
+			} else {
+				spec.Containers = append(spec.Containers, container)
+			}
+			changes = append(changes, fmt.Sprintf("Injected %s container %s (%s) into Deployment/%s", kind, injection.Name, injection.Image, dep.Name))
+		}
+	}
+	return changes, nil
+}
+
+func hasContainer(spec *corev1.PodSpec, name string) bool {
+	for _, container := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
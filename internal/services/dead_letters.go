@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deliveries that failed processing live in the artifact store one key each,
+// so replicas filing and removing them at once don't overwrite each other.
+// deadLetterLegacyKey is the single file they used to share, moved over on
+// first use.
+const (
+	deadLetterPrefix    = "webhooks/dead-letters/"
+	deadLetterLegacyKey = "webhooks/dead-letters.json"
+
+	redactedPayloadValue = "[redacted]"
+)
+
+// Payload fields whose names contain one of these are redacted before a
+// delivery is kept
+var sensitivePayloadFields = []string{"token", "secret", "password", "credential", "authorization"}
+
+// Where a dead-lettered delivery came from, which decides how it's replayed
+const (
+	DeadLetterGitHub      = "github"
+	DeadLetterAzureDevOps = "azure-devops"
+)
+
+// DeadLetter is a webhook delivery that still failed after its retries,
+// kept with its payload so it can be looked at and processed again
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Source   string          `json:"source"`
+	Event    string          `json:"event,omitempty"`
+	Action   string          `json:"action,omitempty"`
+	Delivery string          `json:"delivery,omitempty"` // X-GitHub-Delivery, to match GitHub's delivery log
+	Repo     string          `json:"repo,omitempty"`
+	PRNumber int             `json:"pr_number,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Error    string          `json:"error"`
+	Trace    string          `json:"trace,omitempty"` // stack of a panic
+	Attempts int             `json:"attempts"`
+	Replays  int             `json:"replays,omitempty"` // times an admin sent it back through
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// DeadLetterQueue keeps failed deliveries, oldest dropped first past max,
+// persisted in the artifact store so they survive restarts and every replica
+// sees them. Without a store they are only kept in memory.
+type DeadLetterQueue struct {
+	mu       sync.Mutex
+	store    ArtifactStore
+	max      int
+	letters  map[string]*DeadLetter // without a store
+	migrated bool
+}
+
+func NewDeadLetterQueue(store ArtifactStore, max int) *DeadLetterQueue {
+	if max < 1 {
+		max = 1
+	}
+	return &DeadLetterQueue{store: store, max: max, letters: map[string]*DeadLetter{}}
+}
+
+func deadLetterKey(id string) string {
+	return deadLetterPrefix + id + ".json"
+}
+
+// Add stores a failed delivery, its payload redacted, and returns it with its
+// ID
+func (q *DeadLetterQueue) Add(ctx context.Context, letter DeadLetter) *DeadLetter {
+	now := time.Now().UTC()
+	letter.ID = fmt.Sprintf("%d", now.UnixNano())
+	letter.FailedAt = now
+	letter.Payload = redactPayload(letter.Payload)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.saveLocked(ctx, &letter); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if err := q.trimLocked(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	fmt.Printf("☠️  %s %s delivery for %s#%d dead-lettered after %d attempts: %s\n", letter.Source, letter.Event, letter.Repo, letter.PRNumber, letter.Attempts, letter.Error)
+	return &letter
+}
+
+// Get returns one dead letter with its payload
+func (q *DeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.getLocked(ctx, id)
+}
+
+// Remove drops a dead letter, once it's replayed or not worth keeping
+func (q *DeadLetterQueue) Remove(ctx context.Context, id string) (*DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	letter, err := q.getLocked(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.deleteLocked(ctx, id); err != nil {
+		return nil, err
+	}
+	return letter, nil
+}
+
+// List returns the dead letters oldest first, without their payloads
+func (q *DeadLetterQueue) List(ctx context.Context) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	letters, err := q.loadLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range letters {
+		letters[i].Payload = nil
+	}
+	return letters, nil
+}
+
+// Count is how many deliveries are dead-lettered, for /metrics
+func (q *DeadLetterQueue) Count() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids, err := q.idsLocked(context.Background())
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return len(ids)
+}
+
+// idsLocked lists the dead letters' IDs, which sort oldest first
+func (q *DeadLetterQueue) idsLocked(ctx context.Context) ([]string, error) {
+	var ids []string
+	if q.store == nil {
+		for id := range q.letters {
+			ids = append(ids, id)
+		}
+	} else {
+		if err := q.migrateLocked(ctx); err != nil {
+			return nil, err
+		}
+		keys, err := q.store.List(ctx, deadLetterPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list dead letters: %v", err)
+		}
+		for _, key := range keys {
+			if id, ok := strings.CutSuffix(path.Base(key), ".json"); ok && strings.HasPrefix(key, deadLetterPrefix) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	// IDs are nanosecond timestamps of the same width for centuries
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (q *DeadLetterQueue) getLocked(ctx context.Context, id string) (*DeadLetter, error) {
+	if q.store == nil {
+		letter, ok := q.letters[id]
+		if !ok {
+			return nil, fmt.Errorf("no dead letter %s", id)
+		}
+		snapshot := *letter
+		return &snapshot, nil
+	}
+	if strings.ContainsAny(id, "/.") {
+		return nil, fmt.Errorf("no dead letter %s", id)
+	}
+	content, err := q.store.Get(ctx, deadLetterKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("no dead letter %s", id)
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal(content, &letter); err != nil {
+		return nil, fmt.Errorf("failed to parse dead letter %s: %v", id, err)
+	}
+	return &letter, nil
+}
+
+// loadLocked returns every dead letter, oldest first
+func (q *DeadLetterQueue) loadLocked(ctx context.Context) ([]DeadLetter, error) {
+	ids, err := q.idsLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		letter, err := q.getLocked(ctx, id)
+		if err != nil {
+			// Removed by another replica since it was listed
+			continue
+		}
+		letters = append(letters, *letter)
+	}
+	return letters, nil
+}
+
+func (q *DeadLetterQueue) saveLocked(ctx context.Context, letter *DeadLetter) error {
+	if q.store == nil {
+		q.letters[letter.ID] = letter
+		return nil
+	}
+	content, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := q.store.Save(ctx, deadLetterKey(letter.ID), content); err != nil {
+		return fmt.Errorf("failed to save dead letter %s: %v", letter.ID, err)
+	}
+	return nil
+}
+
+func (q *DeadLetterQueue) deleteLocked(ctx context.Context, id string) error {
+	if q.store == nil {
+		delete(q.letters, id)
+		return nil
+	}
+	if err := q.store.Delete(ctx, deadLetterKey(id)); err != nil {
+		return fmt.Errorf("failed to remove dead letter %s: %v", id, err)
+	}
+	return nil
+}
+
+// trimLocked drops the oldest dead letters past max
+func (q *DeadLetterQueue) trimLocked(ctx context.Context) error {
+	ids, err := q.idsLocked(ctx)
+	if err != nil {
+		return err
+	}
+	for len(ids) > q.max {
+		if err := q.deleteLocked(ctx, ids[0]); err != nil {
+			return err
+		}
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// migrateLocked moves the dead letters of the shared file to keys of their
+// own. It's retried until it succeeds.
+func (q *DeadLetterQueue) migrateLocked(ctx context.Context) error {
+	if q.migrated {
+		return nil
+	}
+	keys, err := q.store.List(ctx, deadLetterLegacyKey)
+	if err != nil {
+		return fmt.Errorf("failed to load dead letters: %v", err)
+	}
+	for _, key := range keys {
+		if key != deadLetterLegacyKey {
+			continue
+		}
+		content, err := q.store.Get(ctx, deadLetterLegacyKey)
+		if err != nil {
+			return fmt.Errorf("failed to load dead letters: %v", err)
+		}
+		var letters []DeadLetter
+		if err := json.Unmarshal(content, &letters); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", deadLetterLegacyKey, err)
+		}
+		for i := range letters {
+			letters[i].Payload = redactPayload(letters[i].Payload)
+			if err := q.saveLocked(ctx, &letters[i]); err != nil {
+				return err
+			}
+		}
+		if err := q.store.Delete(ctx, deadLetterLegacyKey); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", deadLetterLegacyKey, err)
+		}
+	}
+	q.migrated = true
+	return nil
+}
+
+// redactPayload blanks the values of fields that look like credentials, at
+// any depth. A payload that isn't JSON is kept as is.
+func redactPayload(payload json.RawMessage) json.RawMessage {
+	if len(payload) == 0 {
+		return payload
+	}
+	var value interface{}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return payload
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return payload
+	}
+	return redacted
+}
+
+func redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if sensitivePayloadField(key) {
+				value[key] = redactedPayloadValue
+			} else {
+				value[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactValue(value[i])
+		}
+	}
+	return value
+}
+
+func sensitivePayloadField(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitivePayloadFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
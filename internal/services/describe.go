@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkloadDescription is the part of `kubectl describe` for a Deployment or
+// StatefulSet that matters to someone reviewing a preview
+type WorkloadDescription struct {
+	Kind       string
+	Name       string
+	Desired    int32
+	Ready      int32
+	Updated    int32
+	Available  int32
+	Strategy   string
+	Selector   string
+	Containers []ContainerDescription
+	Conditions []string // "Available=True (MinimumReplicasAvailable)"
+	Pods       []PodDescription
+}
+
+type ContainerDescription struct {
+	Name     string
+	Image    string
+	Ports    string
+	Requests string
+	Limits   string
+	Probes   string
+}
+
+type PodDescription struct {
+	Name       string
+	Phase      string
+	Node       string
+	Ready      string // ready containers out of all, e.g. "1/2"
+	Restarts   int32
+	Conditions []string // conditions that aren't True
+	Waiting    []string // "app: CrashLoopBackOff"
+}
+
+// EndpointDescription lists the addresses behind one Service
+type EndpointDescription struct {
+	Service  string
+	Ready    []string
+	NotReady []string
+}
+
+// DescribeWorkloads summarizes every Deployment and StatefulSet in the
+// namespace with its pods
+func (k *K8sService) DescribeWorkloads(ctx context.Context, namespace string) ([]WorkloadDescription, error) {
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+
+	var result []WorkloadDescription
+	for _, deployment := range deployments.Items {
+		workload := WorkloadDescription{
+			Kind:      "Deployment",
+			Name:      deployment.Name,
+			Desired:   replicasOrOne(deployment.Spec.Replicas),
+			Ready:     deployment.Status.ReadyReplicas,
+			Updated:   deployment.Status.UpdatedReplicas,
+			Available: deployment.Status.AvailableReplicas,
+			Strategy:  string(deployment.Spec.Strategy.Type),
+		}
+		for _, condition := range deployment.Status.Conditions {
+			workload.Conditions = append(workload.Conditions, describeCondition(string(condition.Type), string(condition.Status), condition.Reason))
+		}
+		if err := k.describeTemplate(ctx, namespace, deployment.Spec.Selector, deployment.Spec.Template, &workload); err != nil {
+			return nil, err
+		}
+		result = append(result, workload)
+	}
+
+	for _, sts := range statefulSets.Items {
+		workload := WorkloadDescription{
+			Kind:      "StatefulSet",
+			Name:      sts.Name,
+			Desired:   replicasOrOne(sts.Spec.Replicas),
+			Ready:     sts.Status.ReadyReplicas,
+			Updated:   sts.Status.UpdatedReplicas,
+			Available: sts.Status.AvailableReplicas,
+			Strategy:  string(sts.Spec.UpdateStrategy.Type),
+		}
+		for _, condition := range sts.Status.Conditions {
+			workload.Conditions = append(workload.Conditions, describeCondition(string(condition.Type), string(condition.Status), condition.Reason))
+		}
+		if err := k.describeTemplate(ctx, namespace, sts.Spec.Selector, sts.Spec.Template, &workload); err != nil {
+			return nil, err
+		}
+		result = append(result, workload)
+	}
+
+	return result, nil
+}
+
+func replicasOrOne(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func describeCondition(conditionType, status, reason string) string {
+	if reason == "" {
+		return fmt.Sprintf("%s=%s", conditionType, status)
+	}
+	return fmt.Sprintf("%s=%s (%s)", conditionType, status, reason)
+}
+
+// describeTemplate fills in the containers of a pod template and the pods
+// its selector matches
+func (k *K8sService) describeTemplate(ctx context.Context, namespace string, selector *metav1.LabelSelector, template corev1.PodTemplateSpec, workload *WorkloadDescription) error {
+	for _, container := range template.Spec.Containers {
+		var ports []string
+		for _, port := range container.Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.ContainerPort, port.Protocol))
+		}
+		var probes []string
+		for name, probe := range map[string]*corev1.Probe{"liveness": container.LivenessProbe, "readiness": container.ReadinessProbe, "startup": container.StartupProbe} {
+			if probe != nil {
+				probes = append(probes, name)
+			}
+		}
+		sort.Strings(probes)
+
+		workload.Containers = append(workload.Containers, ContainerDescription{
+			Name:     container.Name,
+			Image:    container.Image,
+			Ports:    strings.Join(ports, ", "),
+			Requests: describeResources(container.Resources.Requests),
+			Limits:   describeResources(container.Resources.Limits),
+			Probes:   strings.Join(probes, ", "),
+		})
+	}
+
+	if selector == nil {
+		return nil
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector on %s: %v", workload.Name, err)
+	}
+	workload.Selector = labelSelector.String()
+
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: workload.Selector})
+	if err != nil {
+		return fmt.Errorf("failed to list pods of %s: %v", workload.Name, err)
+	}
+	for _, pod := range pods.Items {
+		workload.Pods = append(workload.Pods, describePod(&pod))
+	}
+	sort.Slice(workload.Pods, func(i, j int) bool {
+		return workload.Pods[i].Name < workload.Pods[j].Name
+	})
+	return nil
+}
+
+func describeResources(resources corev1.ResourceList) string {
+	var parts []string
+	if cpu, ok := resources[corev1.ResourceCPU]; ok {
+		parts = append(parts, "cpu "+cpu.String())
+	}
+	if memory, ok := resources[corev1.ResourceMemory]; ok {
+		parts = append(parts, "memory "+memory.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describePod(pod *corev1.Pod) PodDescription {
+	description := PodDescription{
+		Name:  pod.Name,
+		Phase: string(pod.Status.Phase),
+		Node:  pod.Spec.NodeName,
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			description.Conditions = append(description.Conditions, describeCondition(string(condition.Type), string(condition.Status), condition.Reason))
+		}
+	}
+
+	ready := 0
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		description.Restarts += status.RestartCount
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			description.Waiting = append(description.Waiting, fmt.Sprintf("%s: %s", status.Name, status.State.Waiting.Reason))
+		}
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+			description.Waiting = append(description.Waiting, fmt.Sprintf("%s: %s (exit %d)", status.Name, status.State.Terminated.Reason, status.State.Terminated.ExitCode))
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			ready++
+		}
+	}
+	description.Ready = fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers))
+	return description
+}
+
+// DescribeEndpoints lists the ready and not-ready addresses of each Service
+// in the namespace from its EndpointSlices
+func (k *K8sService) DescribeEndpoints(ctx context.Context, namespace string) ([]EndpointDescription, error) {
+	slices, err := k.client.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints in %s: %v", namespace, err)
+	}
+
+	byService := map[string]*EndpointDescription{}
+	var services []string
+	for _, slice := range slices.Items {
+		service := slice.Labels[discoveryv1.LabelServiceName]
+		if service == "" {
+			continue
+		}
+		description, ok := byService[service]
+		if !ok {
+			description = &EndpointDescription{Service: service}
+			byService[service] = description
+			services = append(services, service)
+		}
+
+		var ports []string
+		for _, port := range slice.Ports {
+			if port.Port != nil {
+				ports = append(ports, fmt.Sprint(*port.Port))
+			}
+		}
+		for _, endpoint := range slice.Endpoints {
+			for _, address := range endpoint.Addresses {
+				if slice.AddressType == discoveryv1.AddressTypeIPv6 {
+					address = "[" + address + "]"
+				}
+				target := address
+				if len(ports) > 0 {
+					target = address + ":" + strings.Join(ports, ",")
+				}
+				if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+					description.Ready = append(description.Ready, target)
+				} else {
+					description.NotReady = append(description.NotReady, target)
+				}
+			}
+		}
+	}
+
+	sort.Strings(services)
+	result := make([]EndpointDescription, 0, len(services))
+	for _, service := range services {
+		result = append(result, *byService[service])
+	}
+	return result, nil
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// E2E dispatch modes
+const (
+	E2ERepositoryDispatch = "repository_dispatch"
+	E2EWorkflowDispatch   = "workflow_dispatch"
+)
+
+// e2eRunRetention is how long a dispatched run waits for its workflow_run
+// before it's forgotten
+const e2eRunRetention = 24 * time.Hour
+
+// E2ERun is an E2E suite dispatched against a ready preview, waiting for
+// the workflow's result to complete its check run
+type E2ERun struct {
+	CheckRunID   int64     `json:"check_run_id"`
+	Repo         string    `json:"repo"`
+	PRNumber     int       `json:"pr_number"`
+	Service      string    `json:"service"`
+	URL          string    `json:"url"`
+	HeadSHA      string    `json:"head_sha"`
+	Event        string    `json:"event"` // how it was dispatched
+	DispatchedAt time.Time `json:"dispatched_at"`
+}
+
+// WorkflowRunResult is the part of a completed workflow_run delivery that
+// finishes an E2E run
+type WorkflowRunResult struct {
+	Repo         string
+	HeadSHA      string
+	Event        string // repository_dispatch or workflow_dispatch
+	Path         string // .github/workflows/<file>
+	DisplayTitle string
+	Conclusion   string
+	HTMLURL      string
+}
+
+// E2ERunTracker pairs workflow results with the runs the bot dispatched.
+// Runs are kept in memory; one dispatched before a restart leaves its check
+// run in progress.
+type E2ERunTracker struct {
+	mu   sync.Mutex
+	runs []E2ERun
+}
+
+var sharedE2ERuns = &E2ERunTracker{}
+
+// SharedE2ERuns is the tracker dispatches are recorded in
+func SharedE2ERuns() *E2ERunTracker {
+	return sharedE2ERuns
+}
+
+func (t *E2ERunTracker) add(run E2ERun) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked()
+	t.runs = append(t.runs, run)
+}
+
+// Match removes and returns the run a workflow result belongs to. A
+// check_run_id in the run's title (e.g. run-name: "E2E ${{ inputs.check_run_id }}")
+// identifies it exactly. Only when the result is known to come from the E2E
+// workflow, byWorkflow, is a title without one matched to the oldest run
+// dispatched the same way, on the same commit for workflow_dispatch;
+// otherwise any repository_dispatch workflow could complete the check run.
+// repository_dispatch runs on the default branch, so its commit can't be
+// matched.
+func (t *E2ERunTracker) Match(result WorkflowRunResult, byWorkflow bool) (*E2ERun, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked()
+
+	match := -1
+	for _, field := range strings.FieldsFunc(result.DisplayTitle, func(r rune) bool { return r < '0' || r > '9' }) {
+		id, _ := strconv.ParseInt(field, 10, 64)
+		for i, run := range t.runs {
+			if match < 0 && run.CheckRunID == id && strings.EqualFold(run.Repo, result.Repo) {
+				match = i
+			}
+		}
+	}
+	if match < 0 && byWorkflow {
+		for i, run := range t.runs {
+			if !strings.EqualFold(run.Repo, result.Repo) || run.Event != result.Event {
+				continue
+			}
+			if run.Event == E2EWorkflowDispatch && run.HeadSHA != result.HeadSHA {
+				continue
+			}
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		return nil, false
+	}
+
+	run := t.runs[match]
+	t.runs = append(t.runs[:match], t.runs[match+1:]...)
+	return &run, true
+}
+
+// Pending lists the runs still waiting on their workflow
+func (t *E2ERunTracker) Pending() []E2ERun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked()
+	return append([]E2ERun(nil), t.runs...)
+}
+
+func (t *E2ERunTracker) pruneLocked() {
+	kept := t.runs[:0]
+	for _, run := range t.runs {
+		if time.Since(run.DispatchedAt) < e2eRunRetention {
+			kept = append(kept, run)
+		}
+	}
+	t.runs = kept
+}
+
+// triggerE2E waits for the preview to become ready, then starts a check run
+// on the PR's head commit and dispatches the E2E workflow with the preview
+// URL. The workflow's result completes the check run; see CompleteE2ERun.
+func (cs *CommandServiceK8s) triggerE2E(cmd *types.Command, service string) {
+	mode := cs.config.E2E.Dispatch
+	if mode == "" || cs.config.GitHub.Token == "" || cmd.Repo == "" {
+		return
+	}
+	if mode != E2ERepositoryDispatch && mode != E2EWorkflowDispatch {
+		fmt.Printf("Warning: E2E_DISPATCH must be %s or %s, got %q\n", E2ERepositoryDispatch, E2EWorkflowDispatch, mode)
+		return
+	}
+	if mode == E2EWorkflowDispatch && cs.config.E2E.Workflow == "" {
+		fmt.Printf("Warning: E2E_WORKFLOW is required with E2E_DISPATCH=%s\n", mode)
+		return
+	}
+
+	ctx := context.Background()
+	preview, err := cs.WaitForPreview(ctx, cmd.Repo, cmd.PRNumber, service, cs.config.E2E.ReadyTimeout)
+	if err != nil {
+		fmt.Printf("Warning: E2E for %s on PR #%d not triggered: %v\n", service, cmd.PRNumber, err)
+		return
+	}
+	if preview.State != PreviewWaitReady {
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "E2E for %s not triggered: preview %s (%s)", service, preview.State, preview.Reason)
+		return
+	}
+	if preview.URL == "" {
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "E2E for %s not triggered: the preview has no URL", service)
+		return
+	}
+
+	sha, ref, err := cs.github.GetPullRequestHead(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		fmt.Printf("Warning: E2E for %s on PR #%d not triggered: %v\n", service, cmd.PRNumber, err)
+		return
+	}
+
+	checkName := fmt.Sprintf("%s (%s)", cs.config.E2E.CheckName, service)
+	checkRunID, err := cs.github.CreateCheckRun(ctx, cmd.Repo, CheckRun{
+		Name:       checkName,
+		HeadSHA:    sha,
+		Status:     "in_progress",
+		DetailsURL: preview.URL,
+		Output: CheckRunOutput{
+			Title:   "E2E tests running",
+			Summary: fmt.Sprintf("Running against %s", preview.URL),
+		},
+	})
+	if err != nil {
+		fmt.Printf("Warning: E2E for %s on PR #%d not triggered: %v\n", service, cmd.PRNumber, err)
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "E2E for %s not triggered: %v", service, err)
+		return
+	}
+
+	if mode == E2ERepositoryDispatch {
+		err = cs.github.DispatchRepositoryEvent(ctx, cmd.Repo, cs.config.E2E.EventType, map[string]interface{}{
+			"pr_number":    cmd.PRNumber,
+			"service":      service,
+			"preview_url":  preview.URL,
+			"namespace":    preview.Namespace,
+			"sha":          sha,
+			"ref":          ref,
+			"check_run_id": checkRunID,
+		})
+	} else {
+		err = cs.github.DispatchWorkflow(ctx, cmd.Repo, cs.config.E2E.Workflow, ref, map[string]string{
+			"preview_url":  preview.URL,
+			"pr_number":    strconv.Itoa(cmd.PRNumber),
+			"service":      service,
+			"check_run_id": strconv.FormatInt(checkRunID, 10),
+		})
+	}
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "E2E dispatch for %s failed: %v", service, err)
+		if updateErr := cs.github.UpdateCheckRun(ctx, cmd.Repo, checkRunID, CheckRun{
+			Status:     "completed",
+			Conclusion: "failure",
+			Output: CheckRunOutput{
+				Title:   "E2E tests could not be started",
+				Summary: err.Error(),
+			},
+		}); updateErr != nil {
+			fmt.Printf("Warning: %v\n", updateErr)
+		}
+		return
+	}
+
+	sharedE2ERuns.add(E2ERun{
+		CheckRunID:   checkRunID,
+		Repo:         cmd.Repo,
+		PRNumber:     cmd.PRNumber,
+		Service:      service,
+		URL:          preview.URL,
+		HeadSHA:      sha,
+		Event:        mode,
+		DispatchedAt: time.Now().UTC(),
+	})
+	cs.logTimeline(cmd.Repo, cmd.PRNumber, "Dispatched E2E (%s) for %s against %s", mode, service, preview.URL)
+}
+
+// CompleteE2ERun finishes the check run of the E2E run a completed workflow
+// belongs to. With workflow set, other workflows' results are ignored, and
+// the workflow's runs needn't name their check run. It returns nil when the
+// workflow isn't one the bot dispatched.
+func CompleteE2ERun(ctx context.Context, github *GitHubClient, workflow string, result WorkflowRunResult) (*E2ERun, error) {
+	if result.Event != E2ERepositoryDispatch && result.Event != E2EWorkflowDispatch {
+		return nil, nil
+	}
+	if workflow != "" && !strings.HasSuffix(result.Path, "/"+workflow) {
+		return nil, nil
+	}
+	run, ok := sharedE2ERuns.Match(result, workflow != "")
+	if !ok {
+		return nil, nil
+	}
+
+	// Workflow conclusions are check conclusions, apart from startup_failure
+	conclusion := result.Conclusion
+	switch conclusion {
+	case "":
+		conclusion = "neutral"
+	case "startup_failure":
+		conclusion = "failure"
+	}
+	title := "E2E tests passed"
+	if conclusion != "success" {
+		title = fmt.Sprintf("E2E tests: %s", strings.ReplaceAll(conclusion, "_", " "))
+	}
+
+	err := github.UpdateCheckRun(ctx, run.Repo, run.CheckRunID, CheckRun{
+		Status:     "completed",
+		Conclusion: conclusion,
+		DetailsURL: result.HTMLURL,
+		Output: CheckRunOutput{
+			Title:   title,
+			Summary: fmt.Sprintf("[Workflow run](%s) against %s finished: **%s**", result.HTMLURL, run.URL, conclusion),
+		},
+	})
+	return run, err
+}
@@ -0,0 +1,556 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const githubAPIURL = "https://api.github.com"
+
+// PullRequestCommenter posts bot output on a pull request of one provider.
+// GitHubClient and AzureDevOpsClient implement it.
+type PullRequestCommenter interface {
+	PostComment(ctx context.Context, repo string, prNumber int, body string) error
+}
+
+// GitHubClient posts bot output back to pull requests and reads PR data
+// through a shared conditional-request cache
+type GitHubClient struct {
+	token      string
+	cacheTTL   time.Duration
+	cache      *githubCache
+	httpClient *http.Client
+	timeline   *PreviewTimeline // records every comment posted or edited
+	outbox     *CommentOutbox   // keeps comments that failed to post for retry
+	budget     *GitHubRateBudget
+	chain      *GitHubCredentials // App installation token, then token; nil for user clients
+}
+
+func NewGitHubClient(token string, cacheTTL time.Duration) *GitHubClient {
+	return &GitHubClient{
+		token:      token,
+		cacheTTL:   cacheTTL,
+		cache:      sharedGitHubCache,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		outbox:     sharedCommentOutbox,
+		budget:     sharedGitHubRateBudget,
+		chain:      sharedGitHubCredentials,
+	}
+}
+
+// authenticated reports whether the client has any credential to send
+func (gc *GitHubClient) authenticated() bool {
+	return gc.token != "" || (gc.chain != nil && gc.chain.Configured())
+}
+
+// bearer is the token a request goes out with: the first working link of
+// the credential chain, or the client's own token
+func (gc *GitHubClient) bearer(ctx context.Context) string {
+	if gc.chain != nil && gc.chain.Configured() {
+		if token := gc.chain.Token(ctx); token != "" {
+			return token
+		}
+	}
+	return gc.token
+}
+
+// WithTimeline records the comments this client posts on the PR timeline
+func (gc *GitHubClient) WithTimeline(timeline *PreviewTimeline) *GitHubClient {
+	gc.timeline = timeline
+	return gc
+}
+
+func (gc *GitHubClient) recordComment(ctx context.Context, repo string, prNumber int, body string, details map[string]interface{}) {
+	gc.timeline.Record(ctx, TimelineEntry{
+		Kind:     TimelineComment,
+		Repo:     repo,
+		PRNumber: prNumber,
+		Message:  body,
+		Details:  details,
+	})
+}
+
+// githubRateLimitError is a refusal that says when GitHub will take
+// requests again
+type githubRateLimitError struct {
+	message string
+	ResetAt time.Time
+}
+
+func (e *githubRateLimitError) Error() string {
+	return e.message
+}
+
+// PostComment adds a comment to a PR. Without a token or repo the comment is
+// written to stdout so local testing still shows the output. A comment
+// GitHub refuses is queued in the outbox and retried with backoff.
+func (gc *GitHubClient) PostComment(ctx context.Context, repo string, prNumber int, body string) error {
+	err := gc.postComment(ctx, repo, prNumber, body)
+	if err != nil && gc.outbox != nil {
+		gc.outbox.Add(ctx, repo, prNumber, body, err)
+		return fmt.Errorf("%v (queued for retry)", err)
+	}
+	return err
+}
+
+func (gc *GitHubClient) postComment(ctx context.Context, repo string, prNumber int, body string) (err error) {
+	if !gc.authenticated() || repo == "" {
+		fmt.Printf("💬 Comment for PR #%d:\n%s\n", prNumber, body)
+		gc.recordComment(ctx, repo, prNumber, body, nil)
+		return nil
+	}
+	defer func() { SharedCommentMetrics().RecordPost(err) }()
+
+	payload, err := json.Marshal(map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/issues/%d/comments", githubAPIURL, repo, prNumber)
+	send := func(token string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Content-Type", "application/json")
+		return gc.httpClient.Do(req)
+	}
+
+	token := gc.bearer(ctx)
+	resp, err := send(token)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && gc.chain != nil && gc.chain.Rejected(token) {
+		// The App's token lapsed; post with the static one instead
+		resp.Body.Close()
+		resp, err = send(gc.chain.StaticToken())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to post comment on %s#%d: %v", repo, prNumber, err)
+	}
+	defer resp.Body.Close()
+	gc.budget.observe(resp)
+
+	if resp.StatusCode >= 300 {
+		message := fmt.Sprintf("failed to post comment on %s#%d: GitHub returned status %d", repo, prNumber, resp.StatusCode)
+		if resetAt, ok := rateLimitReset(resp); ok {
+			return &githubRateLimitError{message: message + " (rate limited)", ResetAt: resetAt}
+		}
+		return fmt.Errorf("failed to post comment on %s#%d: %w", repo, prNumber, &githubStatusError{status: resp.StatusCode})
+	}
+
+	gc.recordComment(ctx, repo, prNumber, body, nil)
+	return nil
+}
+
+// rateLimitReset reads when a rate-limited response may be retried, from
+// Retry-After or an exhausted X-RateLimit-Remaining
+func rateLimitReset(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Unix(reset, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// UpsertComment keeps a single bot comment per PR, identified by a hidden
+// marker, editing it in place instead of posting a new one each time. It's
+// skipped while the rate limit budget is low; the next update catches up.
+func (gc *GitHubClient) UpsertComment(ctx context.Context, repo string, prNumber int, marker, body string) error {
+	body = marker + "\n" + body
+	if !gc.authenticated() || repo == "" {
+		return gc.PostComment(ctx, repo, prNumber, body)
+	}
+	if !gc.budget.allowNonEssential() {
+		return errGitHubBudgetLow
+	}
+
+	commentID, err := gc.findComment(ctx, repo, prNumber, marker)
+	if err != nil {
+		return err
+	}
+	if commentID == 0 {
+		return gc.PostComment(ctx, repo, prNumber, body)
+	}
+
+	payload, err := json.Marshal(map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/issues/comments/%d", githubAPIURL, repo, commentID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+gc.bearer(ctx))
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update comment on %s#%d: %v", repo, prNumber, err)
+	}
+	defer resp.Body.Close()
+	gc.budget.observe(resp)
+
+	// A comment deleted since findComment saw it is a conflict, not an outage
+	SharedCommentMetrics().RecordEdit(resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to update comment on %s#%d: GitHub returned status %d", repo, prNumber, resp.StatusCode)
+	}
+
+	gc.recordComment(ctx, repo, prNumber, body, map[string]interface{}{"edited_comment_id": commentID})
+	return nil
+}
+
+// findComment returns the ID of the first PR comment containing marker, or 0.
+// It reads live rather than through the cache so a comment created moments
+// ago is never missed and duplicated.
+func (gc *GitHubClient) findComment(ctx context.Context, repo string, prNumber int, marker string) (int64, error) {
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100&page=%d", githubAPIURL, repo, prNumber, page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+gc.bearer(ctx))
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := gc.httpClient.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list comments on %s#%d: %v", repo, prNumber, err)
+		}
+		gc.budget.observe(resp)
+
+		var comments []struct {
+			ID   int64  `json:"id"`
+			Body string `json:"body"`
+		}
+		if resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return 0, fmt.Errorf("failed to list comments on %s#%d: GitHub returned status %d", repo, prNumber, resp.StatusCode)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&comments)
+		resp.Body.Close()
+		if err != nil {
+			return 0, err
+		}
+
+		for _, comment := range comments {
+			if strings.Contains(comment.Body, marker) {
+				return comment.ID, nil
+			}
+		}
+		if len(comments) < 100 {
+			return 0, nil
+		}
+	}
+}
+
+// GetPullRequestBranch returns the head branch name of a PR
+func (gc *GitHubClient) GetPullRequestBranch(ctx context.Context, repo string, prNumber int) (string, error) {
+	if !gc.authenticated() || repo == "" {
+		return "", fmt.Errorf("GitHub token and repo are required to look up PR branches")
+	}
+
+	var pr struct {
+		Head struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber), &pr); err != nil {
+		return "", fmt.Errorf("failed to get %s#%d: %v", repo, prNumber, err)
+	}
+
+	return pr.Head.Ref, nil
+}
+
+// GetPullRequestLabels returns the label names on a PR
+func (gc *GitHubClient) GetPullRequestLabels(ctx context.Context, repo string, prNumber int) ([]string, error) {
+	if !gc.authenticated() || repo == "" {
+		return nil, fmt.Errorf("GitHub token and repo are required to read PR labels")
+	}
+
+	var pr struct {
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber), &pr); err != nil {
+		return nil, fmt.Errorf("failed to get %s#%d: %v", repo, prNumber, err)
+	}
+
+	var labels []string
+	for _, label := range pr.Labels {
+		labels = append(labels, label.Name)
+	}
+	return labels, nil
+}
+
+// GetPullRequestBody returns the description of a PR
+func (gc *GitHubClient) GetPullRequestBody(ctx context.Context, repo string, prNumber int) (string, error) {
+	if !gc.authenticated() || repo == "" {
+		return "", fmt.Errorf("GitHub token and repo are required to read PR descriptions")
+	}
+
+	var pr struct {
+		Body string `json:"body"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber), &pr); err != nil {
+		return "", fmt.Errorf("failed to get %s#%d: %v", repo, prNumber, err)
+	}
+
+	return pr.Body, nil
+}
+
+// UpdatePullRequestBody replaces a PR's description
+func (gc *GitHubClient) UpdatePullRequestBody(ctx context.Context, repo string, prNumber int, body string) error {
+	if !gc.authenticated() || repo == "" {
+		return fmt.Errorf("GitHub token and repo are required to edit PR descriptions")
+	}
+
+	payload, err := json.Marshal(map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+gc.bearer(ctx))
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update description of %s#%d: %v", repo, prNumber, err)
+	}
+	defer resp.Body.Close()
+	gc.budget.observe(resp)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to update description of %s#%d: GitHub returned status %d", repo, prNumber, resp.StatusCode)
+	}
+
+	gc.InvalidatePullRequest(repo, prNumber)
+	return nil
+}
+
+// InvalidatePullRequest drops the cached PR so the next read sees an edit
+// GitHub just told us about
+func (gc *GitHubClient) InvalidatePullRequest(repo string, prNumber int) {
+	gc.cache.invalidate(fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber))
+}
+
+// GetCollaboratorPermission returns a user's role on a repo (admin, maintain,
+// write, triage, read or none)
+func (gc *GitHubClient) GetCollaboratorPermission(ctx context.Context, repo, user string) (string, error) {
+	if !gc.authenticated() || repo == "" {
+		return "", fmt.Errorf("GitHub token and repo are required to check permissions")
+	}
+
+	var permission struct {
+		Permission string `json:"permission"`
+	}
+	err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/collaborators/%s/permission", githubAPIURL, repo, url.PathEscape(user)), &permission)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s permission on %s: %v", user, repo, err)
+	}
+
+	return permission.Permission, nil
+}
+
+// ListPullRequestFiles returns the paths changed by a PR
+func (gc *GitHubClient) ListPullRequestFiles(ctx context.Context, repo string, prNumber int) ([]string, error) {
+	if !gc.authenticated() || repo == "" {
+		return nil, fmt.Errorf("GitHub token and repo are required to list PR files")
+	}
+
+	var paths []string
+	for page := 1; ; page++ {
+		var files []struct {
+			Filename string `json:"filename"`
+		}
+		err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d/files?per_page=100&page=%d", githubAPIURL, repo, prNumber, page), &files)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of %s#%d: %v", repo, prNumber, err)
+		}
+		for _, file := range files {
+			paths = append(paths, file.Filename)
+		}
+		// GitHub caps this endpoint at 3000 files
+		if len(files) < 100 || page >= 30 {
+			return paths, nil
+		}
+	}
+}
+
+// GetRepoFile fetches a file at a ref, e.g. a PR's .pr-previews.yaml
+func (gc *GitHubClient) GetRepoFile(ctx context.Context, repo, path, ref string) ([]byte, error) {
+	if !gc.authenticated() || repo == "" {
+		return nil, fmt.Errorf("GitHub token and repo are required to fetch files")
+	}
+
+	var file struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	requestURL := fmt.Sprintf("%s/repos/%s/contents/%s?ref=%s", githubAPIURL, repo, path, url.QueryEscape(ref))
+	if err := gc.getJSON(ctx, requestURL, &file); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s from %s@%s: %w", path, repo, ref, err)
+	}
+	if file.Encoding != "base64" {
+		return nil, fmt.Errorf("unsupported encoding %q for %s", file.Encoding, path)
+	}
+
+	return base64.StdEncoding.DecodeString(file.Content)
+}
+
+// GetAuthenticatedUser resolves a caller-supplied token to its GitHub login.
+// It bypasses the shared cache, which is keyed by URL and not by token.
+func (gc *GitHubClient) GetAuthenticatedUser(ctx context.Context, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubAPIURL+"/user", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get authenticated user: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", err
+	}
+	return user.Login, nil
+}
+
+// ResolveUser checks that login is a GitHub user account and returns it with
+// GitHub's casing. Without a token the login is only checked for syntax.
+func (gc *GitHubClient) ResolveUser(ctx context.Context, login string) (string, error) {
+	if err := ValidateGitHubLogin(login); err != nil {
+		return "", err
+	}
+	if !gc.authenticated() {
+		return login, nil
+	}
+
+	var user struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/users/%s", githubAPIURL, url.PathEscape(login)), &user); err != nil {
+		return "", fmt.Errorf("GitHub user @%s not found: %v", login, err)
+	}
+	if user.Type != "User" {
+		return "", fmt.Errorf("@%s is a GitHub %s, not a user account", user.Login, user.Type)
+	}
+	return user.Login, nil
+}
+
+// githubStatusError is a GitHub API read answered outside 2xx
+type githubStatusError struct {
+	status int
+}
+
+func (e *githubStatusError) Error() string {
+	return fmt.Sprintf("GitHub returned status %d", e.status)
+}
+
+// isGitHubNotFound reports whether a read failed because GitHub answered 404
+func isGitHubNotFound(err error) bool {
+	var statusErr *githubStatusError
+	return errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound
+}
+
+// isGitHubUnavailable reports whether a read failed for reasons that pass
+// on their own: rate limits, including secondary ones answered with 403,
+// and server errors
+func isGitHubUnavailable(err error) bool {
+	var limited *githubRateLimitError
+	if errors.As(err, &limited) || errors.Is(err, errGitHubBudgetLow) {
+		return true
+	}
+	var statusErr *githubStatusError
+	return errors.As(err, &statusErr) && (statusErr.status == http.StatusForbidden || statusErr.status == http.StatusTooManyRequests || statusErr.status >= 500)
+}
+
+// getJSON performs a cached GET. Fresh entries skip the network entirely;
+// stale ones are revalidated with their ETag.
+func (gc *GitHubClient) getJSON(ctx context.Context, requestURL string, out interface{}) error {
+	cached, ok := gc.cache.get(requestURL)
+	if ok && time.Since(cached.fetchedAt) < gc.cacheTTL {
+		gc.cache.hits.Add(1)
+		return json.Unmarshal(cached.body, out)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	token := gc.bearer(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if ok && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	gc.budget.observe(resp)
+
+	if resp.StatusCode == http.StatusNotModified && ok {
+		gc.cache.revalidated.Add(1)
+		gc.cache.touch(requestURL)
+		return json.Unmarshal(cached.body, out)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && gc.chain != nil {
+		gc.chain.Rejected(token)
+	}
+	if resp.StatusCode >= 300 {
+		gc.cache.invalidate(requestURL)
+		return &githubStatusError{status: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	gc.cache.misses.Add(1)
+	gc.cache.put(requestURL, resp.Header.Get("ETag"), body)
+
+	return json.Unmarshal(body, out)
+}
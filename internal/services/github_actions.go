@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CheckRun is the part of a GitHub check run the bot writes. Creating check
+// runs needs a GitHub App installation token; personal tokens are refused.
+type CheckRun struct {
+	Name       string         `json:"name,omitempty"`
+	HeadSHA    string         `json:"head_sha,omitempty"`
+	Status     string         `json:"status,omitempty"`     // queued, in_progress or completed
+	Conclusion string         `json:"conclusion,omitempty"` // set with status completed
+	DetailsURL string         `json:"details_url,omitempty"`
+	Output     CheckRunOutput `json:"output"`
+}
+
+type CheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+}
+
+// GetPullRequestHead returns the commit and branch at the head of a PR
+func (gc *GitHubClient) GetPullRequestHead(ctx context.Context, repo string, prNumber int) (string, string, error) {
+	if !gc.authenticated() || repo == "" {
+		return "", "", fmt.Errorf("GitHub token and repo are required to look up PR heads")
+	}
+
+	var pr struct {
+		Head struct {
+			SHA string `json:"sha"`
+			Ref string `json:"ref"`
+		} `json:"head"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls/%d", githubAPIURL, repo, prNumber), &pr); err != nil {
+		return "", "", fmt.Errorf("failed to get %s#%d: %v", repo, prNumber, err)
+	}
+	return pr.Head.SHA, pr.Head.Ref, nil
+}
+
+// CreateCheckRun starts a check run and returns its ID
+func (gc *GitHubClient) CreateCheckRun(ctx context.Context, repo string, check CheckRun) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := gc.sendJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/check-runs", githubAPIURL, repo), check, &created); err != nil {
+		return 0, fmt.Errorf("failed to create check run %s on %s: %v", check.Name, repo, err)
+	}
+	return created.ID, nil
+}
+
+// UpdateCheckRun changes a check run's status, conclusion or output
+func (gc *GitHubClient) UpdateCheckRun(ctx context.Context, repo string, id int64, check CheckRun) error {
+	if err := gc.sendJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s/check-runs/%d", githubAPIURL, repo, id), check, nil); err != nil {
+		return fmt.Errorf("failed to update check run %d on %s: %v", id, repo, err)
+	}
+	return nil
+}
+
+// DispatchRepositoryEvent fires a repository_dispatch event. GitHub allows
+// at most ten top-level client_payload keys.
+func (gc *GitHubClient) DispatchRepositoryEvent(ctx context.Context, repo, eventType string, payload map[string]interface{}) error {
+	err := gc.sendJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/dispatches", githubAPIURL, repo), map[string]interface{}{
+		"event_type":     eventType,
+		"client_payload": payload,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to dispatch %s to %s: %v", eventType, repo, err)
+	}
+	return nil
+}
+
+// DispatchWorkflow runs a workflow_dispatch workflow on ref. Every input
+// must be declared by the workflow, or GitHub rejects the run.
+func (gc *GitHubClient) DispatchWorkflow(ctx context.Context, repo, workflow, ref string, inputs map[string]string) error {
+	err := gc.sendJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/actions/workflows/%s/dispatches", githubAPIURL, repo, url.PathEscape(workflow)), map[string]interface{}{
+		"ref":    ref,
+		"inputs": inputs,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to run workflow %s on %s@%s: %v", workflow, repo, ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxGitHubCacheEntries bounds memory when many PRs are active
+const maxGitHubCacheEntries = 1000
+
+// githubCache remembers GitHub GET responses by URL. Fresh entries (younger
+// than the TTL) are served without a request; stale ones are revalidated with
+// If-None-Match, and a 304 doesn't count against the rate limit.
+type githubCache struct {
+	mu      sync.Mutex
+	entries map[string]*githubCacheEntry
+
+	hits        atomic.Int64
+	revalidated atomic.Int64
+	misses      atomic.Int64
+}
+
+type githubCacheEntry struct {
+	etag      string
+	body      []byte
+	fetchedAt time.Time
+}
+
+// GitHub clients are created per request, so they share one cache
+var sharedGitHubCache = &githubCache{entries: make(map[string]*githubCacheEntry)}
+
+// get returns a copy of the entry, taken under the lock, since touch and
+// put update entries while callers read theirs
+func (c *githubCache) get(url string) (githubCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[url]
+	if !ok {
+		return githubCacheEntry{}, false
+	}
+	return *entry, true
+}
+
+func (c *githubCache) put(url, etag string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[url]; !ok && len(c.entries) >= maxGitHubCacheEntries {
+		c.evictOldest()
+	}
+	c.entries[url] = &githubCacheEntry{etag: etag, body: body, fetchedAt: time.Now()}
+}
+
+// touch marks an entry fresh again after a 304
+func (c *githubCache) touch(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[url]; ok {
+		entry.fetchedAt = time.Now()
+	}
+}
+
+// invalidate drops entries, e.g. after a write that changes them
+func (c *githubCache) invalidate(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, url)
+}
+
+// evictOldest drops the least recently fetched entry; c.mu must be held
+func (c *githubCache) evictOldest() {
+	var oldestURL string
+	var oldest time.Time
+	for url, entry := range c.entries {
+		if oldestURL == "" || entry.fetchedAt.Before(oldest) {
+			oldestURL, oldest = url, entry.fetchedAt
+		}
+	}
+	delete(c.entries, oldestURL)
+}
+
+// GitHubCacheCounters are the GitHub response cache's size and hit counters
+type GitHubCacheCounters struct {
+	Entries     int   `json:"entries"`
+	Hits        int64 `json:"hits"`
+	Revalidated int64 `json:"revalidated"`
+	Misses      int64 `json:"misses"`
+}
+
+// GitHubCacheStats returns cache size and hit counters
+func GitHubCacheStats() GitHubCacheCounters {
+	sharedGitHubCache.mu.Lock()
+	size := len(sharedGitHubCache.entries)
+	sharedGitHubCache.mu.Unlock()
+
+	return GitHubCacheCounters{
+		Entries:     size,
+		Hits:        sharedGitHubCache.hits.Load(),
+		Revalidated: sharedGitHubCache.revalidated.Load(),
+		Misses:      sharedGitHubCache.misses.Load(),
+	}
+}
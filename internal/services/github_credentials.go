@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Installation tokens live an hour; one is replaced this long before it
+// expires so a request never goes out with a token about to lapse
+const installationTokenRefresh = 5 * time.Minute
+
+// Permissions the App installation needs to comment, read PRs and fetch
+// repo config
+var requiredAppPermissions = map[string]string{
+	"issues":        "write",
+	"pull_requests": "write",
+	"contents":      "read",
+}
+
+// GitHub credential kinds, in the order they're tried
+const (
+	CredentialApp   = "app"
+	CredentialToken = "token"
+)
+
+// CredentialHealth is how one GitHub credential looked at its last check
+type CredentialHealth struct {
+	Kind       string    `json:"kind"`
+	Configured bool      `json:"configured"`
+	Healthy    bool      `json:"healthy"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	Scopes     []string  `json:"scopes,omitempty"`
+	Problems   []string  `json:"problems,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// GitHubCredentials chains the bot's GitHub credentials: the App
+// installation token while the App can mint one, then GITHUB_TOKEN. A
+// rejected or unmintable installation token falls through to the static
+// token, so comments keep posting when the App's credentials lapse.
+type GitHubCredentials struct {
+	mu             sync.Mutex
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+	token          string
+	expiryWarning  time.Duration
+	httpClient     *http.Client
+
+	installationToken string
+	installationUntil time.Time
+	permissions       map[string]string
+	appError          string    // why the last mint failed, cleared by the next success
+	appRetryAt        time.Time // no mint is tried before then after one failed
+
+	health []CredentialHealth
+}
+
+// GitHub clients are created per request, so they share one credential
+// chain like they share the API cache
+var sharedGitHubCredentials = &GitHubCredentials{
+	expiryWarning: 7 * 24 * time.Hour,
+	httpClient:    &http.Client{Timeout: 30 * time.Second},
+}
+
+// SharedGitHubCredentials is the credential chain every GitHubClient uses
+func SharedGitHubCredentials() *GitHubCredentials {
+	return sharedGitHubCredentials
+}
+
+// Configure sets the App and static token. privateKey is the App's PEM key
+// or a path to it; an App ID of 0 leaves only the static token.
+func (c *GitHubCredentials) Configure(appID, installationID int64, privateKey, token string, expiryWarning time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	if expiryWarning > 0 {
+		c.expiryWarning = expiryWarning
+	}
+	if appID == 0 {
+		return nil
+	}
+	if installationID == 0 || privateKey == "" {
+		return fmt.Errorf("GITHUB_APP_ID needs GITHUB_APP_INSTALLATION_ID and GITHUB_APP_PRIVATE_KEY")
+	}
+	key, err := parseAppPrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	c.appID, c.installationID, c.key = appID, installationID, key
+	return nil
+}
+
+func parseAppPrivateKey(value string) (*rsa.PrivateKey, error) {
+	content := []byte(value)
+	if !strings.Contains(value, "-----BEGIN") {
+		var err error
+		if content, err = os.ReadFile(value); err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key: %v", err)
+		}
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key is not PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key must be RSA")
+	}
+	return key, nil
+}
+
+// Configured reports whether any credential is set
+func (c *GitHubCredentials) Configured() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.key != nil || c.token != ""
+}
+
+// StaticToken is GITHUB_TOKEN, the last link of the chain
+func (c *GitHubCredentials) StaticToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// Token returns the first credential that works: a current installation
+// token, minting one when needed, else the static token
+func (c *GitHubCredentials) Token(ctx context.Context) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key == nil {
+		return c.token
+	}
+	if c.installationToken != "" && time.Until(c.installationUntil) > installationTokenRefresh {
+		return c.installationToken
+	}
+	if time.Now().Before(c.appRetryAt) {
+		return c.token
+	}
+	if err := c.mintInstallationToken(ctx); err != nil {
+		if c.appError == "" {
+			fmt.Printf("⚠️  GitHub App token unavailable, falling back to GITHUB_TOKEN: %v\n", err)
+		}
+		c.appError = err.Error()
+		c.installationToken = ""
+		c.appRetryAt = time.Now().Add(time.Minute)
+		return c.token
+	}
+	c.appError = ""
+	return c.installationToken
+}
+
+// Rejected drops an installation token GitHub answered 401 to, so the next
+// call mints a new one or falls back to the static token. Returns whether
+// there's another credential to retry with.
+func (c *GitHubCredentials) Rejected(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if token == "" || token != c.installationToken {
+		return false
+	}
+	c.installationToken = ""
+	c.appError = "GitHub rejected the installation token"
+	return c.token != ""
+}
+
+// mintInstallationToken exchanges an App JWT for an installation token;
+// the caller holds mu
+func (c *GitHubCredentials) mintInstallationToken(ctx context.Context) error {
+	jwt, err := c.appJWT()
+	if err != nil {
+		return err
+	}
+	requestURL := fmt.Sprintf("%s/app/installations/%d/access_tokens", githubAPIURL, c.installationID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to mint installation token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to mint installation token: GitHub returned status %d", resp.StatusCode)
+	}
+
+	var minted struct {
+		Token       string            `json:"token"`
+		ExpiresAt   time.Time         `json:"expires_at"`
+		Permissions map[string]string `json:"permissions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&minted); err != nil {
+		return fmt.Errorf("failed to decode installation token: %v", err)
+	}
+	c.installationToken, c.installationUntil, c.permissions = minted.Token, minted.ExpiresAt, minted.Permissions
+	return nil
+}
+
+// appJWT signs the short-lived RS256 JWT that authenticates as the App
+func (c *GitHubCredentials) appJWT() (string, error) {
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": c.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Check probes each configured credential for expiry and missing scopes,
+// logging an alert for every problem found
+func (c *GitHubCredentials) Check(ctx context.Context) []CredentialHealth {
+	var health []CredentialHealth
+
+	c.mu.Lock()
+	hasApp := c.key != nil
+	c.mu.Unlock()
+	if hasApp {
+		c.Token(ctx)
+		health = append(health, c.appHealth())
+	}
+	if token := c.StaticToken(); token != "" {
+		health = append(health, c.tokenHealth(ctx, token))
+	}
+
+	for _, credential := range health {
+		for _, problem := range credential.Problems {
+			fmt.Printf("⚠️  GitHub %s credential: %s\n", credential.Kind, problem)
+		}
+	}
+
+	c.mu.Lock()
+	c.health = health
+	c.mu.Unlock()
+	return health
+}
+
+func (c *GitHubCredentials) appHealth() CredentialHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := CredentialHealth{Kind: CredentialApp, Configured: true, CheckedAt: time.Now()}
+	if c.appError != "" {
+		health.Problems = append(health.Problems, c.appError)
+		return health
+	}
+	health.ExpiresAt = c.installationUntil
+	for name, access := range c.permissions {
+		health.Scopes = append(health.Scopes, name+":"+access)
+	}
+	sort.Strings(health.Scopes)
+	for _, name := range sortedKeys(requiredAppPermissions) {
+		want, have := requiredAppPermissions[name], c.permissions[name]
+		if have != want && have != "write" {
+			health.Problems = append(health.Problems, fmt.Sprintf("installation lacks %s:%s permission", name, want))
+		}
+	}
+	health.Healthy = len(health.Problems) == 0
+	return health
+}
+
+// tokenHealth asks GitHub about the static token. Classic tokens report
+// their scopes; tokens with an expiry report when they lapse.
+func (c *GitHubCredentials) tokenHealth(ctx context.Context, token string) CredentialHealth {
+	health := CredentialHealth{Kind: CredentialToken, Configured: true, CheckedAt: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubAPIURL+"/rate_limit", nil)
+	if err != nil {
+		health.Problems = append(health.Problems, err.Error())
+		return health
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		health.Problems = append(health.Problems, fmt.Sprintf("failed to reach GitHub: %v", err))
+		return health
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		health.Problems = append(health.Problems, "GitHub rejected the token; it expired or was revoked")
+		return health
+	}
+
+	if header := resp.Header.Get("X-OAuth-Scopes"); resp.Header.Values("X-OAuth-Scopes") != nil {
+		for _, scope := range strings.Split(header, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				health.Scopes = append(health.Scopes, scope)
+			}
+		}
+		if !containsString(health.Scopes, "repo") && !containsString(health.Scopes, "public_repo") {
+			health.Problems = append(health.Problems, "token lacks the repo scope")
+		}
+	}
+	// e.g. "2026-11-01 10:00:00 UTC"
+	if expiry := resp.Header.Get("GitHub-Authentication-Token-Expiration"); expiry != "" {
+		if expiresAt, err := time.Parse("2006-01-02 15:04:05 MST", expiry); err == nil {
+			health.ExpiresAt = expiresAt
+			if left := time.Until(expiresAt); left < c.expiryWarning {
+				health.Problems = append(health.Problems, fmt.Sprintf("token expires in %s", left.Round(time.Hour)))
+			}
+		}
+	}
+	health.Healthy = len(health.Problems) == 0
+	return health
+}
+
+// Health is the result of the last Check
+func (c *GitHubCredentials) Health() []CredentialHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]CredentialHealth(nil), c.health...)
+}
+
+// Run checks the credentials now and every interval until ctx is done
+func (c *GitHubCredentials) Run(ctx context.Context, interval time.Duration) {
+	if !c.Configured() {
+		return
+	}
+	c.Check(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check(ctx)
+		}
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errGitHubBudgetLow is returned by calls skipped to save the rate limit for
+// comments that matter
+var errGitHubBudgetLow = errors.New("GitHub rate limit budget is low, skipped a non-essential call")
+
+// GitHubRateBudget follows the core REST rate limit from the X-RateLimit-*
+// headers of the bot token's responses. When fewer requests than the reserve
+// remain, non-essential calls such as summary comment edits are skipped so
+// command replies still get through.
+type GitHubRateBudget struct {
+	mu         sync.Mutex
+	reserve    int
+	limit      int
+	remaining  int
+	used       int
+	resetAt    time.Time
+	observedAt time.Time
+
+	throttled atomic.Int64
+}
+
+// GitHub clients are created per request, so they share one budget like
+// they share the API cache
+var sharedGitHubRateBudget = &GitHubRateBudget{reserve: 500}
+
+// SharedGitHubRateBudget is the budget every GitHubClient reports to
+func SharedGitHubRateBudget() *GitHubRateBudget {
+	return sharedGitHubRateBudget
+}
+
+// Configure sets how many requests are kept for essential calls
+func (b *GitHubRateBudget) Configure(reserve int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if reserve >= 0 {
+		b.reserve = reserve
+	}
+}
+
+// observe records the rate limit headers of a response. Search and GraphQL
+// have budgets of their own and are ignored.
+func (b *GitHubRateBudget) observe(resp *http.Response) {
+	if resource := resp.Header.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	used, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Used"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit, b.remaining, b.used = limit, remaining, used
+	b.resetAt = time.Unix(reset, 0)
+	b.observedAt = time.Now()
+}
+
+// Low reports whether the remaining requests have dropped under the reserve
+// in the current window
+func (b *GitHubRateBudget) Low() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.observedAt.IsZero() || time.Now().After(b.resetAt) {
+		return false
+	}
+	return b.remaining < b.reserve
+}
+
+// allowNonEssential is checked before calls that can be skipped, counting
+// the ones that are
+func (b *GitHubRateBudget) allowNonEssential() bool {
+	if b.Low() {
+		b.throttled.Add(1)
+		return false
+	}
+	return true
+}
+
+// GitHubRateLimit is the last observed budget; Known is false until the bot
+// has made a request
+type GitHubRateLimit struct {
+	Known      bool   `json:"known"`
+	Limit      int    `json:"limit"`
+	Remaining  int    `json:"remaining"`
+	Used       int    `json:"used"`
+	Reserve    int    `json:"reserve"`
+	Low        bool   `json:"low"`
+	Throttled  int64  `json:"throttled"`
+	ResetAt    string `json:"reset_at,omitempty"`
+	ObservedAt string `json:"observed_at,omitempty"`
+}
+
+// GitHubRateLimitStats returns the last observed budget
+func GitHubRateLimitStats() GitHubRateLimit {
+	b := sharedGitHubRateBudget
+	low := b.Low()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := GitHubRateLimit{
+		Known:     !b.observedAt.IsZero(),
+		Limit:     b.limit,
+		Remaining: b.remaining,
+		Used:      b.used,
+		Reserve:   b.reserve,
+		Low:       low,
+		Throttled: b.throttled.Load(),
+	}
+	if stats.Known {
+		stats.ResetAt = b.resetAt.UTC().Format(time.RFC3339)
+		stats.ObservedAt = b.observedAt.UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// ResetIn is how long until the current window ends, 0 when unknown or past
+func (b *GitHubRateBudget) ResetIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.observedAt.IsZero() {
+		return 0
+	}
+	if wait := time.Until(b.resetAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
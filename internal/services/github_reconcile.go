@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// deploymentStartedHeading opens the comment a preview deployment posts
+// while its pods are still starting
+const deploymentStartedHeading = "## 🚀 Preview Deployment Started"
+
+var commentNamespacePattern = regexp.MustCompile("\\*\\*📦 Namespace:\\*\\* `([a-z0-9-]+)`")
+
+// OpenPullRequest is an open PR and its head commit
+type OpenPullRequest struct {
+	Number  int
+	HeadSHA string
+}
+
+// GitHubComment is a PR conversation comment
+type GitHubComment struct {
+	ID        int64     `json:"id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	User      struct {
+		Login string `json:"login"`
+		Type  string `json:"type"` // User or Bot
+	} `json:"user"`
+	App *struct {
+		ID int64 `json:"id"`
+	} `json:"performed_via_github_app"` // nil unless a GitHub App posted it
+}
+
+// CheckRunState is a check run as listed on a commit
+type CheckRunState struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	App    struct {
+		ID int64 `json:"id"`
+	} `json:"app"`
+}
+
+// ListOpenPullRequests returns every open PR of the repository
+func (gc *GitHubClient) ListOpenPullRequests(ctx context.Context, repo string) ([]OpenPullRequest, error) {
+	var prs []OpenPullRequest
+	for page := 1; ; page++ {
+		var batch []struct {
+			Number int `json:"number"`
+			Head   struct {
+				SHA string `json:"sha"`
+			} `json:"head"`
+		}
+		if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/pulls?state=open&per_page=100&page=%d", githubAPIURL, repo, page), &batch); err != nil {
+			return nil, fmt.Errorf("failed to list open PRs of %s: %v", repo, err)
+		}
+		for _, pr := range batch {
+			prs = append(prs, OpenPullRequest{Number: pr.Number, HeadSHA: pr.Head.SHA})
+		}
+		if len(batch) < 100 {
+			return prs, nil
+		}
+	}
+}
+
+// ListComments returns the PR's conversation comments, oldest first
+func (gc *GitHubClient) ListComments(ctx context.Context, repo string, prNumber int) ([]GitHubComment, error) {
+	var comments []GitHubComment
+	for page := 1; ; page++ {
+		var batch []GitHubComment
+		if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/issues/%d/comments?per_page=100&page=%d", githubAPIURL, repo, prNumber, page), &batch); err != nil {
+			return nil, fmt.Errorf("failed to list comments on %s#%d: %v", repo, prNumber, err)
+		}
+		comments = append(comments, batch...)
+		if len(batch) < 100 {
+			return comments, nil
+		}
+	}
+}
+
+// EditComment replaces the body of a comment
+func (gc *GitHubClient) EditComment(ctx context.Context, repo string, commentID int64, body string) error {
+	err := gc.sendJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/repos/%s/issues/comments/%d", githubAPIURL, repo, commentID), map[string]string{"body": body}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to edit comment %d on %s: %v", commentID, repo, err)
+	}
+	return nil
+}
+
+// ListCheckRuns returns the latest check runs named name on a commit
+func (gc *GitHubClient) ListCheckRuns(ctx context.Context, repo, sha, name string) ([]CheckRunState, error) {
+	var result struct {
+		CheckRuns []CheckRunState `json:"check_runs"`
+	}
+	requestURL := fmt.Sprintf("%s/repos/%s/commits/%s/check-runs?check_name=%s&filter=latest&per_page=100", githubAPIURL, repo, sha, url.QueryEscape(name))
+	if err := gc.getJSON(ctx, requestURL, &result); err != nil {
+		return nil, fmt.Errorf("failed to list check runs of %s@%s: %v", repo, shortSHA(sha), err)
+	}
+	return result.CheckRuns, nil
+}
+
+// ReconcileReport is what ReconcileGitHubState corrected
+type ReconcileReport struct {
+	PullRequests int      `json:"pull_requests"`
+	Comments     []string `json:"comments"`   // repo#pr/comment-id
+	CheckRuns    []string `json:"check_runs"` // repo#pr/check-run-id
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// ReconcileGitHubState keeps open PRs honest after a restart: deployment
+// comments still claiming a queued or starting deployment the cluster has
+// no trace of are rewritten to say it stopped, and E2E check runs left in
+// progress are completed as cancelled, since their results can no longer be
+// matched. GitHub doesn't delete check runs, so completing them is what
+// clears them from the PR. Comments older than maxAge are left alone.
+func (cs *CommandServiceK8s) ReconcileGitHubState(ctx context.Context, repos []string, maxAge time.Duration) ReconcileReport {
+	report := ReconcileReport{Comments: []string{}, CheckRuns: []string{}}
+	if !cs.github.authenticated() {
+		report.Errors = append(report.Errors, "no GitHub credential to read PRs with")
+		return report
+	}
+
+	// App installation tokens can't read /user; their comments carry the
+	// App's ID instead
+	self := ""
+	if cs.config.GitHub.AppID == 0 {
+		self, _ = cs.github.GetAuthenticatedUser(ctx, cs.github.bearer(ctx))
+		if self == "" {
+			report.Errors = append(report.Errors, "could not tell which comments are the bot's own; left them alone")
+		}
+	}
+
+	for _, repo := range repos {
+		prs, err := cs.github.ListOpenPullRequests(ctx, repo)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		for _, pr := range prs {
+			report.PullRequests++
+			cs.reconcileComments(ctx, repo, pr.Number, self, maxAge, &report)
+			cs.reconcileCheckRuns(ctx, repo, pr, &report)
+		}
+	}
+	return report
+}
+
+func (cs *CommandServiceK8s) reconcileComments(ctx context.Context, repo string, prNumber int, self string, maxAge time.Duration, report *ReconcileReport) {
+	comments, err := cs.github.ListComments(ctx, repo, prNumber)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	// Only the bot's own comments: other Apps' are Bots too
+	byBot := func(comment GitHubComment) bool {
+		if appID := cs.config.GitHub.AppID; appID != 0 {
+			return comment.App != nil && comment.App.ID == appID
+		}
+		return self != "" && strings.EqualFold(comment.User.Login, self)
+	}
+	queuedHeading, _, _ := strings.Cut(cs.lang.T("queue.queued"), "\n")
+
+	for i, comment := range comments {
+		if !byBot(comment) || time.Since(comment.CreatedAt) > maxAge {
+			continue
+		}
+
+		var reason string
+		switch {
+		case strings.HasPrefix(comment.Body, queuedHeading):
+			// A job that ran posted its result after the queued comment;
+			// the queue itself doesn't survive a restart
+			ran := false
+			for _, later := range comments[i+1:] {
+				ran = ran || byBot(later)
+			}
+			if ran {
+				continue
+			}
+			reason = "The server restarted before this queued deployment started, so it was dropped."
+		case strings.HasPrefix(comment.Body, deploymentStartedHeading):
+			match := commentNamespacePattern.FindStringSubmatch(comment.Body)
+			if match == nil {
+				continue
+			}
+			exists, err := cs.k8s.NamespaceExists(ctx, match[1])
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			if exists {
+				continue
+			}
+			reason = fmt.Sprintf("Namespace `%s` no longer exists: the deployment was interrupted by a server restart, or the preview has been cleaned up since.", match[1])
+		default:
+			continue
+		}
+
+		body := fmt.Sprintf("## ⏹️ Preview Deployment Not Running\n\n%s\n\n*Run `/preview` to deploy again; `/status` shows what is running now.*\n\n<details><summary>Original comment</summary>\n\n%s\n\n</details>",
+			reason, comment.Body)
+		if err := cs.github.EditComment(ctx, repo, comment.ID, body); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		report.Comments = append(report.Comments, fmt.Sprintf("%s#%d/%d", repo, prNumber, comment.ID))
+		cs.logTimeline(repo, prNumber, "Marked a stale deployment comment as not running after a restart")
+	}
+}
+
+func (cs *CommandServiceK8s) reconcileCheckRuns(ctx context.Context, repo string, pr OpenPullRequest, report *ReconcileReport) {
+	if cs.config.E2E.CheckName == "" || pr.HeadSHA == "" {
+		return
+	}
+	runs, err := cs.github.ListCheckRuns(ctx, repo, pr.HeadSHA, cs.config.E2E.CheckName)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	pending := map[int64]bool{}
+	for _, run := range SharedE2ERuns().Pending() {
+		pending[run.CheckRunID] = true
+	}
+
+	for _, run := range runs {
+		if run.Status == "completed" || pending[run.ID] {
+			continue
+		}
+		// Another App's check of the same name isn't ours to finish
+		if cs.config.GitHub.AppID != 0 && run.App.ID != cs.config.GitHub.AppID {
+			continue
+		}
+		err := cs.github.UpdateCheckRun(ctx, repo, run.ID, CheckRun{
+			Status:     "completed",
+			Conclusion: "cancelled",
+			Output: CheckRunOutput{
+				Title:   "Interrupted",
+				Summary: "pr-previews restarted while this check was waiting for the E2E workflow, so its result can no longer be recorded. Run `/preview` again to re-run the suite.",
+			},
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		report.CheckRuns = append(report.CheckRuns, fmt.Sprintf("%s#%d/%d", repo, pr.Number, run.ID))
+		cs.logTimeline(repo, pr.Number, "Cancelled E2E check run %d left in progress by a restart", run.ID)
+	}
+}
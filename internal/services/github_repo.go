@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NewUserGitHubClient acts with a caller's own token. It gets a cache of its
+// own, since the shared one is keyed by URL and not by token.
+func NewUserGitHubClient(token string) *GitHubClient {
+	return &GitHubClient{
+		token:      token,
+		cache:      &githubCache{entries: make(map[string]*githubCacheEntry)},
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GitHubRepository is the part of a repository onboarding looks at
+type GitHubRepository struct {
+	FullName      string `json:"full_name"`
+	DefaultBranch string `json:"default_branch"`
+	Private       bool   `json:"private"`
+	Archived      bool   `json:"archived"`
+	Permissions   struct {
+		Admin bool `json:"admin"`
+		Push  bool `json:"push"`
+	} `json:"permissions"`
+}
+
+// GitHubHook is a repository webhook. GitHub masks a configured secret as
+// "********".
+type GitHubHook struct {
+	ID     int64    `json:"id"`
+	Active bool     `json:"active"`
+	Events []string `json:"events"`
+	Config struct {
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+		Secret      string `json:"secret"`
+		InsecureSSL string `json:"insecure_ssl"`
+	} `json:"config"`
+	LastResponse struct {
+		Code   *int   `json:"code"`
+		Status string `json:"status"`
+	} `json:"last_response"`
+}
+
+func (gc *GitHubClient) GetRepository(ctx context.Context, repo string) (*GitHubRepository, error) {
+	var repository GitHubRepository
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s", githubAPIURL, repo), &repository); err != nil {
+		return nil, fmt.Errorf("failed to get repository %s: %v", repo, err)
+	}
+	return &repository, nil
+}
+
+// ListRepoHooks needs admin access to the repository
+func (gc *GitHubClient) ListRepoHooks(ctx context.Context, repo string) ([]GitHubHook, error) {
+	var hooks []GitHubHook
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/hooks?per_page=100", githubAPIURL, repo), &hooks); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks of %s: %v", repo, err)
+	}
+	return hooks, nil
+}
+
+// ListRepoTree returns every file path at a ref. GitHub truncates very large
+// trees, which is reported rather than treated as an error.
+func (gc *GitHubClient) ListRepoTree(ctx context.Context, repo, ref string) ([]string, bool, error) {
+	var tree struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+		} `json:"tree"`
+		Truncated bool `json:"truncated"`
+	}
+	requestURL := fmt.Sprintf("%s/repos/%s/git/trees/%s?recursive=1", githubAPIURL, repo, ref)
+	if err := gc.getJSON(ctx, requestURL, &tree); err != nil {
+		return nil, false, fmt.Errorf("failed to list files of %s@%s: %v", repo, ref, err)
+	}
+
+	var paths []string
+	for _, entry := range tree.Tree {
+		if entry.Type == "blob" {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths, tree.Truncated, nil
+}
+
+// CompareCommits returns the paths changed between two commits. GitHub
+// lists at most 300 files, which is reported rather than treated as an error.
+func (gc *GitHubClient) CompareCommits(ctx context.Context, repo, base, head string) ([]string, bool, error) {
+	var comparison struct {
+		Files []struct {
+			Filename         string `json:"filename"`
+			PreviousFilename string `json:"previous_filename"`
+		} `json:"files"`
+	}
+	requestURL := fmt.Sprintf("%s/repos/%s/compare/%s...%s?per_page=300", githubAPIURL, repo, base, head)
+	if err := gc.getJSON(ctx, requestURL, &comparison); err != nil {
+		return nil, false, fmt.Errorf("failed to compare %s@%s...%s: %v", repo, shortSHA(base), shortSHA(head), err)
+	}
+
+	var paths []string
+	for _, file := range comparison.Files {
+		paths = append(paths, file.Filename)
+		if file.PreviousFilename != "" {
+			paths = append(paths, file.PreviousFilename)
+		}
+	}
+	return paths, len(comparison.Files) >= 300, nil
+}
+
+// CreatePullRequestWithFile commits one file to a new branch off base and
+// opens a pull request for it, returning the PR's URL
+func (gc *GitHubClient) CreatePullRequestWithFile(ctx context.Context, repo, base, branch, filePath string, content []byte, title, body string) (string, error) {
+	var head struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := gc.getJSON(ctx, fmt.Sprintf("%s/repos/%s/git/ref/heads/%s", githubAPIURL, repo, base), &head); err != nil {
+		return "", fmt.Errorf("failed to resolve %s of %s: %v", base, repo, err)
+	}
+
+	err := gc.sendJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/git/refs", githubAPIURL, repo), map[string]string{
+		"ref": "refs/heads/" + branch,
+		"sha": head.Object.SHA,
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create branch %s on %s: %v", branch, repo, err)
+	}
+
+	// A branch left behind would make the next attempt fail to create it
+	removeBranch := func() {
+		ref := fmt.Sprintf("%s/repos/%s/git/refs/heads/%s", githubAPIURL, repo, url.PathEscape(branch))
+		if err := gc.sendJSON(ctx, http.MethodDelete, ref, struct{}{}, nil); err != nil {
+			fmt.Printf("Warning: failed to delete branch %s of %s: %v\n", branch, repo, err)
+		}
+	}
+
+	escaped := strings.Split(filePath, "/")
+	for i, part := range escaped {
+		escaped[i] = url.PathEscape(part)
+	}
+	err = gc.sendJSON(ctx, http.MethodPut, fmt.Sprintf("%s/repos/%s/contents/%s", githubAPIURL, repo, strings.Join(escaped, "/")), map[string]string{
+		"message": title,
+		"content": base64.StdEncoding.EncodeToString(content),
+		"branch":  branch,
+	}, nil)
+	if err != nil {
+		removeBranch()
+		return "", fmt.Errorf("failed to commit %s to %s: %v", filePath, branch, err)
+	}
+
+	var pr struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = gc.sendJSON(ctx, http.MethodPost, fmt.Sprintf("%s/repos/%s/pulls", githubAPIURL, repo), map[string]string{
+		"title": title,
+		"head":  branch,
+		"base":  base,
+		"body":  body,
+	}, &pr)
+	if err != nil {
+		removeBranch()
+		return "", fmt.Errorf("failed to open pull request on %s: %v", repo, err)
+	}
+	return pr.HTMLURL, nil
+}
+
+// sendJSON performs an uncached write and decodes the reply into out when
+// it's non-nil
+func (gc *GitHubClient) sendJSON(ctx context.Context, method, requestURL string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+gc.bearer(ctx))
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	gc.budget.observe(resp)
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Message != "" {
+			return fmt.Errorf("GitHub returned status %d: %s", resp.StatusCode, failure.Message)
+		}
+		return fmt.Errorf("GitHub returned status %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
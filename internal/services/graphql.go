@@ -0,0 +1,970 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A small GraphQL executor for the dashboard API. It covers the query subset
+// dashboards send - fields, aliases, arguments, variables, fragments and
+// __typename - without mutations, subscriptions, directives or
+// introspection. Depth and complexity are checked before anything resolves.
+
+const (
+	// gqlListEstimate is the assumed length of a list without a limit
+	// argument
+	gqlListEstimate = 10
+
+	// gqlMaxNesting bounds how deeply selections, values and types may nest
+	// in a document, so a crafted one can't exhaust the stack while it's
+	// parsed, before the depth limit is checked
+	gqlMaxNesting = 64
+)
+
+// GraphQLRequest is the standard POST body
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// GraphQLError is one entry of the response's errors list
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResponse has data when execution started, and errors for anything
+// that failed; a failed field is null in data
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLSchema is a query type and the limits queries must stay within
+type GraphQLSchema struct {
+	query         *gqlObject
+	maxDepth      int
+	maxComplexity int
+}
+
+// gqlObject is an object type. Scalars have no type of their own: a field
+// with no object type returns its resolved value as JSON.
+type gqlObject struct {
+	name   string
+	fields map[string]*gqlField
+}
+
+type gqlField struct {
+	object *gqlObject // nil for scalars
+	list   bool
+	args   map[string]gqlArg
+	key    string // map key the default resolver reads, if not the field name
+	// resolve gets the parent value; nil reads the key from a map
+	resolve func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// gqlArg kinds
+const (
+	gqlInt     = "Int"
+	gqlString  = "String"
+	gqlBoolean = "Boolean"
+)
+
+type gqlArg struct {
+	kind     string
+	required bool
+	def      interface{}
+	max      int // Int arguments with a max are clamped to 1..max
+}
+
+// gqlMap is an object result; fields keep their query order
+type gqlMap []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (m gqlMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, entry := range m {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(entry.key)
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Execute parses, checks and runs a query
+func (s *GraphQLSchema) Execute(ctx context.Context, request GraphQLRequest) *GraphQLResponse {
+	fail := func(err error) *GraphQLResponse {
+		return &GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}
+	}
+
+	doc, err := parseGraphQL(request.Query)
+	if err != nil {
+		return fail(err)
+	}
+	op, err := doc.operation(request.OperationName)
+	if err != nil {
+		return fail(err)
+	}
+	vars, err := op.bindVariables(request.Variables)
+	if err != nil {
+		return fail(err)
+	}
+
+	ex := &gqlExecution{fragments: doc.fragments, vars: vars}
+	complexity, err := s.measure(ex, s.query, op.selections, 1, map[string]bool{})
+	if err != nil {
+		return fail(err)
+	}
+	if complexity > s.maxComplexity {
+		return fail(fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, s.maxComplexity))
+	}
+
+	data := s.executeObject(ctx, ex, s.query, nil, op.selections, nil)
+	return &GraphQLResponse{Data: data, Errors: ex.errors}
+}
+
+type gqlExecution struct {
+	fragments map[string]*gqlFragment
+	vars      map[string]interface{}
+	errors    []GraphQLError
+}
+
+// measure validates selections against the schema and returns their
+// complexity: one per field, with list fields multiplying their children by
+// the list's limit
+func (s *GraphQLSchema) measure(ex *gqlExecution, object *gqlObject, selections []*gqlSelection, depth int, visiting map[string]bool) (int, error) {
+	if depth > s.maxDepth {
+		return 0, fmt.Errorf("query depth exceeds the limit of %d", s.maxDepth)
+	}
+
+	total := 0
+	for _, sel := range selections {
+		if sel.fragment != nil || sel.spread != "" {
+			fragment := sel.fragment
+			if sel.spread != "" {
+				if visiting[sel.spread] {
+					return 0, fmt.Errorf("fragment %q spreads itself", sel.spread)
+				}
+				if fragment = ex.fragments[sel.spread]; fragment == nil {
+					return 0, fmt.Errorf("unknown fragment %q", sel.spread)
+				}
+				visiting[sel.spread] = true
+			}
+			if fragment.on != "" && fragment.on != object.name {
+				return 0, fmt.Errorf("fragment on %s can't be spread on type %s", fragment.on, object.name)
+			}
+			cost, err := s.measure(ex, object, fragment.selections, depth, visiting)
+			delete(visiting, sel.spread)
+			if err != nil {
+				return 0, err
+			}
+			total += cost
+			continue
+		}
+
+		if sel.name == "__typename" {
+			if len(sel.selections) > 0 {
+				return 0, fmt.Errorf("field \"__typename\" has no subfields")
+			}
+			continue
+		}
+		field, ok := object.fields[sel.name]
+		if !ok {
+			return 0, fmt.Errorf("cannot query field %q on type %s", sel.name, object.name)
+		}
+		args, err := ex.args(field, sel)
+		if err != nil {
+			return 0, err
+		}
+
+		cost := 1
+		switch {
+		case field.object == nil && len(sel.selections) > 0:
+			return 0, fmt.Errorf("field %q is a scalar and has no subfields", sel.name)
+		case field.object != nil && len(sel.selections) == 0:
+			return 0, fmt.Errorf("field %q of type %s needs a selection of subfields", sel.name, field.object.name)
+		case field.object != nil:
+			children, err := s.measure(ex, field.object, sel.selections, depth+1, visiting)
+			if err != nil {
+				return 0, err
+			}
+			if field.list {
+				children *= listSize(field, args)
+			}
+			cost += children
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+func listSize(field *gqlField, args map[string]interface{}) int {
+	if _, ok := field.args["limit"]; ok {
+		if limit, ok := args["limit"].(int); ok && limit > 0 {
+			return limit
+		}
+	}
+	return gqlListEstimate
+}
+
+// args resolves variables and defaults and checks argument types
+func (ex *gqlExecution) args(field *gqlField, sel *gqlSelection) (map[string]interface{}, error) {
+	for name := range sel.args {
+		if _, ok := field.args[name]; !ok {
+			return nil, fmt.Errorf("unknown argument %q on field %q", name, sel.name)
+		}
+	}
+
+	args := make(map[string]interface{}, len(field.args))
+	for name, arg := range field.args {
+		value, ok := sel.args[name]
+		if variable, isVar := value.(gqlVariable); ok && isVar {
+			if value, ok = ex.vars[string(variable)]; !ok {
+				return nil, fmt.Errorf("variable $%s is not defined", variable)
+			}
+		}
+		if value == nil {
+			if arg.required && arg.def == nil {
+				return nil, fmt.Errorf("argument %q of field %q is required", name, sel.name)
+			}
+			if arg.def != nil {
+				args[name] = arg.def
+			}
+			continue
+		}
+
+		coerced, err := coerceGraphQLValue(arg.kind, value)
+		if err != nil {
+			return nil, fmt.Errorf("argument %q of field %q: %v", name, sel.name, err)
+		}
+		if n, ok := coerced.(int); ok && arg.max > 0 {
+			coerced = min(max(n, 1), arg.max)
+		}
+		args[name] = coerced
+	}
+	return args, nil
+}
+
+func coerceGraphQLValue(kind string, value interface{}) (interface{}, error) {
+	switch kind {
+	case gqlInt:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case float64: // JSON variables
+			if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case gqlString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case gqlBoolean:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %v", kind, value)
+}
+
+func (s *GraphQLSchema) executeObject(ctx context.Context, ex *gqlExecution, object *gqlObject, source interface{}, selections []*gqlSelection, path []interface{}) gqlMap {
+	var result gqlMap
+	for _, sel := range collectFields(ex, selections) {
+		key := sel.alias
+		if key == "" {
+			key = sel.name
+		}
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		if sel.name == "__typename" {
+			result = append(result, gqlEntry{key, object.name})
+			continue
+		}
+		field := object.fields[sel.name]
+		args, _ := ex.args(field, sel) // checked by measure
+
+		var value interface{}
+		var err error
+		if field.resolve != nil {
+			value, err = field.resolve(ctx, source, args)
+		} else if m, ok := source.(map[string]interface{}); ok {
+			key := field.key
+			if key == "" {
+				key = sel.name
+			}
+			value = m[key]
+		}
+		if err != nil {
+			ex.errors = append(ex.errors, GraphQLError{Message: err.Error(), Path: fieldPath})
+			value = nil
+		}
+		result = append(result, gqlEntry{key, s.complete(ctx, ex, field, value, sel, fieldPath)})
+	}
+	return result
+}
+
+// complete runs the sub-selection over object values
+func (s *GraphQLSchema) complete(ctx context.Context, ex *gqlExecution, field *gqlField, value interface{}, sel *gqlSelection, path []interface{}) interface{} {
+	if field.object == nil || value == nil {
+		return value
+	}
+	if !field.list {
+		return s.executeObject(ctx, ex, field.object, value, sel.selections, path)
+	}
+
+	items := reflect.ValueOf(value)
+	if items.Kind() != reflect.Slice {
+		return nil
+	}
+	list := make([]interface{}, items.Len())
+	for i := range list {
+		list[i] = s.executeObject(ctx, ex, field.object, items.Index(i).Interface(), sel.selections, append(append([]interface{}{}, path...), i))
+	}
+	return list
+}
+
+// collectFields flattens fragments and merges fields selected twice under
+// the same response key
+func collectFields(ex *gqlExecution, selections []*gqlSelection) []*gqlSelection {
+	var fields []*gqlSelection
+	byKey := map[string]*gqlSelection{}
+
+	var collect func([]*gqlSelection)
+	collect = func(selections []*gqlSelection) {
+		for _, sel := range selections {
+			switch {
+			case sel.fragment != nil:
+				collect(sel.fragment.selections)
+			case sel.spread != "":
+				collect(ex.fragments[sel.spread].selections)
+			default:
+				key := sel.alias
+				if key == "" {
+					key = sel.name
+				}
+				if existing, ok := byKey[key]; ok {
+					merged := *existing
+					merged.selections = append(append([]*gqlSelection{}, existing.selections...), sel.selections...)
+					*existing = merged
+					continue
+				}
+				copied := *sel
+				byKey[key] = &copied
+				fields = append(fields, &copied)
+			}
+		}
+	}
+	collect(selections)
+	return fields
+}
+
+// Query documents
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	name       string
+	variables  []gqlVariableDef
+	selections []*gqlSelection
+}
+
+type gqlVariableDef struct {
+	name     string
+	required bool
+	def      interface{}
+}
+
+type gqlFragment struct {
+	on         string // type condition; empty for an untyped inline fragment
+	selections []*gqlSelection
+}
+
+// gqlSelection is a field, a named fragment spread or an inline fragment
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []*gqlSelection
+	spread     string
+	fragment   *gqlFragment
+}
+
+// gqlVariable is a $variable reference in an argument
+type gqlVariable string
+
+func (doc *gqlDocument) operation(name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(doc.operations) != 1 {
+			return nil, fmt.Errorf("operationName is required when the document has %d operations", len(doc.operations))
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+// bindVariables applies defaults to the request variables; every declared
+// variable gets an entry so undeclared ones can be told apart
+func (op *gqlOperation) bindVariables(values map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{}, len(op.variables))
+	for _, def := range op.variables {
+		value, ok := values[def.name]
+		if !ok || value == nil {
+			value = def.def
+		}
+		if value == nil && def.required {
+			return nil, fmt.Errorf("variable $%s is required", def.name)
+		}
+		vars[def.name] = value
+	}
+	return vars, nil
+}
+
+// Parsing
+
+const (
+	gqlTokenEOF = iota
+	gqlTokenPunct
+	gqlTokenName
+	gqlTokenInt
+	gqlTokenFloat
+	gqlTokenString
+)
+
+type gqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type gqlParser struct {
+	src     string
+	pos     int
+	tok     gqlToken
+	nesting int
+}
+
+// enter descends into a nested selection set, value or type; every enter
+// that succeeds is paired with a leave
+func (p *gqlParser) enter() error {
+	if p.nesting >= gqlMaxNesting {
+		return fmt.Errorf("syntax error at %d: nested more than %d levels deep", p.tok.pos, gqlMaxNesting)
+	}
+	p.nesting++
+	return nil
+}
+
+func (p *gqlParser) leave() {
+	p.nesting--
+}
+
+func parseGraphQL(src string) (*gqlDocument, error) {
+	p := &gqlParser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	doc := &gqlDocument{fragments: map[string]*gqlFragment{}}
+	for p.tok.kind != gqlTokenEOF {
+		switch {
+		case p.is(gqlTokenPunct, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &gqlOperation{selections: selections})
+		case p.is(gqlTokenName, "query"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.is(gqlTokenName, "fragment"):
+			name, fragment, err := p.fragmentDefinition()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("fragment %q is defined twice", name)
+			}
+			doc.fragments[name] = fragment
+		case p.is(gqlTokenName, "mutation"), p.is(gqlTokenName, "subscription"):
+			return nil, fmt.Errorf("%s operations are not supported", p.tok.text)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document has no query")
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	if err := p.next(); err != nil { // "query"
+		return nil, err
+	}
+	op := &gqlOperation{}
+	if p.tok.kind == gqlTokenName {
+		op.name = p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is(gqlTokenPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.is(gqlTokenPunct, ")") {
+			def, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = selections
+	return op, nil
+}
+
+func (p *gqlParser) variableDefinition() (gqlVariableDef, error) {
+	if err := p.expect(gqlTokenPunct, "$"); err != nil {
+		return gqlVariableDef{}, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return gqlVariableDef{}, err
+	}
+	if err := p.expect(gqlTokenPunct, ":"); err != nil {
+		return gqlVariableDef{}, err
+	}
+	required, err := p.typeRef()
+	if err != nil {
+		return gqlVariableDef{}, err
+	}
+
+	def := gqlVariableDef{name: name, required: required}
+	if p.is(gqlTokenPunct, "=") {
+		if err := p.next(); err != nil {
+			return gqlVariableDef{}, err
+		}
+		if def.def, err = p.value(true); err != nil {
+			return gqlVariableDef{}, err
+		}
+	}
+	return def, nil
+}
+
+// typeRef skips a type such as [String!]! and reports whether it is non-null
+func (p *gqlParser) typeRef() (bool, error) {
+	if err := p.enter(); err != nil {
+		return false, err
+	}
+	defer p.leave()
+
+	if p.is(gqlTokenPunct, "[") {
+		if err := p.next(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect(gqlTokenPunct, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+
+	if p.is(gqlTokenPunct, "!") {
+		return true, p.next()
+	}
+	return false, nil
+}
+
+func (p *gqlParser) fragmentDefinition() (string, *gqlFragment, error) {
+	if err := p.next(); err != nil { // "fragment"
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, fmt.Errorf("syntax error at %d: a fragment can't be named \"on\"", p.tok.pos)
+	}
+	if err := p.expect(gqlTokenName, "on"); err != nil {
+		return "", nil, err
+	}
+	on, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	selections, err := p.selectionSet()
+	if err != nil {
+		return "", nil, err
+	}
+	return name, &gqlFragment{on: on, selections: selections}, nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	if err := p.expect(gqlTokenPunct, "{"); err != nil {
+		return nil, err
+	}
+	var selections []*gqlSelection
+	for !p.is(gqlTokenPunct, "}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("syntax error at %d: empty selection set", p.tok.pos)
+	}
+	return selections, p.next()
+}
+
+func (p *gqlParser) selection() (*gqlSelection, error) {
+	if p.is(gqlTokenPunct, "...") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		fragment := &gqlFragment{}
+		switch {
+		case p.is(gqlTokenName, "on"):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			on, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			fragment.on = on
+		case p.tok.kind == gqlTokenName:
+			name := p.tok.text
+			return &gqlSelection{spread: name}, p.next()
+		}
+		selections, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		fragment.selections = selections
+		return &gqlSelection{fragment: fragment}, nil
+	}
+
+	sel := &gqlSelection{}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	sel.name = name
+	if p.is(gqlTokenPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if sel.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		sel.alias = name
+	}
+
+	if p.is(gqlTokenPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel.args = map[string]interface{}{}
+		for !p.is(gqlTokenPunct, ")") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(gqlTokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			if sel.args[name], err = p.value(false); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(gqlTokenPunct, "@") {
+		return nil, fmt.Errorf("directives are not supported")
+	}
+
+	if p.is(gqlTokenPunct, "{") {
+		if sel.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// value parses an argument or default value; constant values can't
+// reference variables
+func (p *gqlParser) value(constant bool) (interface{}, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	tok := p.tok
+	switch {
+	case p.is(gqlTokenPunct, "$") && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return gqlVariable(name), err
+	case p.is(gqlTokenPunct, "["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.is(gqlTokenPunct, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.next()
+	case p.is(gqlTokenPunct, "{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		object := map[string]interface{}{}
+		for !p.is(gqlTokenPunct, "}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(gqlTokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			if object[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return object, p.next()
+	case tok.kind == gqlTokenInt:
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at %d: %v", tok.pos, err)
+		}
+		return n, p.next()
+	case tok.kind == gqlTokenFloat:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at %d: %v", tok.pos, err)
+		}
+		return f, p.next()
+	case tok.kind == gqlTokenString:
+		return tok.text, p.next()
+	case tok.kind == gqlTokenName:
+		var value interface{}
+		switch tok.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = tok.text // enum values travel as strings
+		}
+		return value, p.next()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *gqlParser) is(kind int, text string) bool {
+	return p.tok.kind == kind && p.tok.text == text
+}
+
+func (p *gqlParser) expect(kind int, text string) error {
+	if !p.is(kind, text) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != gqlTokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.text
+	return name, p.next()
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.tok.kind == gqlTokenEOF {
+		return fmt.Errorf("syntax error: unexpected end of query")
+	}
+	return fmt.Errorf("syntax error at %d: unexpected %q", p.tok.pos, p.tok.text)
+}
+
+// next reads the following token, skipping whitespace, commas and comments
+func (p *gqlParser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+
+	start := p.pos
+	if start >= len(p.src) {
+		p.tok = gqlToken{kind: gqlTokenEOF, pos: start}
+		return nil
+	}
+
+	c := p.src[start]
+	switch {
+	case strings.HasPrefix(p.src[start:], "..."):
+		p.pos += 3
+		p.tok = gqlToken{kind: gqlTokenPunct, text: "...", pos: start}
+	case strings.IndexByte("!$()=:@[]{}|&", c) >= 0:
+		p.pos++
+		p.tok = gqlToken{kind: gqlTokenPunct, text: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = gqlToken{kind: gqlTokenName, text: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	default:
+		return fmt.Errorf("syntax error at %d: unexpected character %q", start, c)
+	}
+	return nil
+}
+
+func (p *gqlParser) number() error {
+	start := p.pos
+	digits := func() int {
+		from := p.pos
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		return p.pos - from
+	}
+
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+	kind := gqlTokenInt
+	if digits() == 0 {
+		return fmt.Errorf("syntax error at %d: invalid number", start)
+	}
+	if p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos++
+		kind = gqlTokenFloat
+		if digits() == 0 {
+			return fmt.Errorf("syntax error at %d: invalid number", start)
+		}
+	}
+	if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos++
+		kind = gqlTokenFloat
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return fmt.Errorf("syntax error at %d: invalid number", start)
+		}
+	}
+	p.tok = gqlToken{kind: kind, text: p.src[start:p.pos], pos: start}
+	return nil
+}
+
+// string reads a quoted string; GraphQL escapes are JSON's. Block strings
+// aren't supported.
+func (p *gqlParser) string() error {
+	start := p.pos
+	if strings.HasPrefix(p.src[start:], `"""`) {
+		return fmt.Errorf("syntax error at %d: block strings are not supported", start)
+	}
+	p.pos++
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\\':
+			p.pos += 2
+			continue
+		case '\n':
+			return fmt.Errorf("syntax error at %d: unterminated string", start)
+		case '"':
+			p.pos++
+			var text string
+			if err := json.Unmarshal([]byte(p.src[start:p.pos]), &text); err != nil {
+				return fmt.Errorf("syntax error at %d: invalid string: %v", start, err)
+			}
+			p.tok = gqlToken{kind: gqlTokenString, text: text, pos: start}
+			return nil
+		}
+		p.pos++
+	}
+	return fmt.Errorf("syntax error at %d: unterminated string", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
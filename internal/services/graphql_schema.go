@@ -0,0 +1,390 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Largest limits the dashboard's list fields take; larger ones are clamped
+const (
+	gqlMaxPreviews    = 200
+	gqlMaxEvents      = 100
+	gqlMaxAuditEvents = 500
+)
+
+// DashboardSchema is the GraphQL schema behind the dashboard: previews with
+// their pods, events and estimated cost, deployment jobs, audit events and a
+// cluster summary. Nested fields cost a cluster call per preview, which is
+// what GRAPHQL_MAX_COMPLEXITY bounds.
+//
+//	type Query {
+//	  previews(pr: Int, service: String, limit: Int = 50): [Preview]
+//	  preview(namespace: String!): Preview
+//	  jobs(pr: Int): [Job]
+//	  auditEvents(action: String, user: String, repo: String, pr: Int, limit: Int = 50): [AuditEvent]
+//	  cluster: ClusterSummary
+//	}
+//	type Preview {
+//	  namespace, service, owner, ttl, createdAt, expiresAt, remaining, status: String
+//	  prNumber, requests: Int
+//	  lastAccessedAt: String
+//	  pods: [Pod]
+//	  events(limit: Int = 20): [Event]
+//	  cost: Cost
+//	  jobs: [Job]
+//	  shareLinks: [ShareLink]
+//	}
+//	type Pod { name, phase, node, createdAt: String; ready: Boolean; restarts: Int; images: [String] }
+//	type Event { type, reason, message, object, lastSeen: String; count: Int }
+//	type Cost { cpuCores, memoryGB, hourly, soFar, weekly: Float }
+//	type Job { id, service, user, status, enqueuedAt, startedAt, eta: String; prNumber, priority, position: Int }
+//	type ShareLink { id, service, createdBy, createdAt, expiresAt, revokedBy, revokedAt: String; active: Boolean }
+//	type AuditEvent { time, action, user, repo, details: String; prNumber: Int }
+//	type ClusterSummary {
+//	  nodes, namespaces, previewNamespaces, stuckNamespaces: Int
+//	  runningJobs, queuedJobs, maxConcurrent: Int
+//	  hourlyCost, weeklyCost: Float
+//	}
+func (cs *CommandServiceK8s) DashboardSchema(queue *DeploymentQueue, audit *AuditLog, stuck *StuckNamespaceMonitor) *GraphQLSchema {
+	intArg := gqlArg{kind: gqlInt}
+	stringArg := gqlArg{kind: gqlString}
+
+	pod := &gqlObject{name: "Pod", fields: map[string]*gqlField{
+		"name":      {},
+		"phase":     {},
+		"ready":     {},
+		"restarts":  {},
+		"node":      {},
+		"images":    {list: true},
+		"createdAt": {key: "created_at"},
+	}}
+	event := &gqlObject{name: "Event", fields: map[string]*gqlField{
+		"type":     {},
+		"reason":   {},
+		"message":  {},
+		"object":   {},
+		"count":    {},
+		"lastSeen": {key: "last_seen"},
+	}}
+	cost := &gqlObject{name: "Cost", fields: map[string]*gqlField{
+		"cpuCores": {key: "cpu_cores"},
+		"memoryGB": {key: "memory_gb"},
+		"hourly":   {},
+		"soFar":    {key: "so_far"},
+		"weekly":   {},
+	}}
+	job := &gqlObject{name: "Job", fields: map[string]*gqlField{
+		"id":         {},
+		"repo":       {},
+		"prNumber":   {key: "pr_number"},
+		"service":    {},
+		"user":       {},
+		"priority":   {},
+		"status":     {},
+		"enqueuedAt": {key: "enqueued_at"},
+		"startedAt":  {key: "started_at"},
+		"position":   {},
+		"eta":        {},
+	}}
+	auditEvent := &gqlObject{name: "AuditEvent", fields: map[string]*gqlField{
+		"time":     {},
+		"action":   {},
+		"user":     {},
+		"repo":     {},
+		"prNumber": {key: "pr_number"},
+		"details":  {},
+	}}
+	shareLink := &gqlObject{name: "ShareLink", fields: map[string]*gqlField{
+		"id":        {},
+		"service":   {},
+		"createdBy": {key: "created_by"},
+		"createdAt": {key: "created_at"},
+		"expiresAt": {key: "expires_at"},
+		"revokedBy": {key: "revoked_by"},
+		"revokedAt": {key: "revoked_at"},
+		"active":    {},
+	}}
+
+	preview := &gqlObject{name: "Preview", fields: map[string]*gqlField{
+		"namespace": previewField(func(ns PreviewNamespace) interface{} { return ns.Name }),
+		"prNumber":  previewField(func(ns PreviewNamespace) interface{} { return ns.PRNumber }),
+		"service":   previewField(func(ns PreviewNamespace) interface{} { return ns.Service }),
+		"owner":     previewField(func(ns PreviewNamespace) interface{} { return ns.Owner }),
+		"ttl":       previewField(func(ns PreviewNamespace) interface{} { return ns.TTL }),
+		"createdAt": previewField(func(ns PreviewNamespace) interface{} { return ns.CreatedAt.Format(time.RFC3339) }),
+		"expiresAt": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			if expiresAt, ok := cs.previewExpiry(source.(PreviewNamespace)); ok {
+				return expiresAt.UTC().Format(time.RFC3339), nil
+			}
+			return nil, nil
+		}},
+		"remaining": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			if expiresAt, ok := cs.previewExpiry(source.(PreviewNamespace)); ok {
+				return previewRemaining(expiresAt), nil
+			}
+			return nil, nil
+		}},
+		"status": previewField(func(ns PreviewNamespace) interface{} { return ns.Status }),
+		"requests": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			if access, ok := cs.previewAccess(ctx, source.(PreviewNamespace)); ok {
+				return access.Requests, nil
+			}
+			return nil, nil
+		}},
+		"lastAccessedAt": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			if access, ok := cs.previewAccess(ctx, source.(PreviewNamespace)); ok && !access.LastAccessed.IsZero() {
+				return access.LastAccessed.Format(time.RFC3339), nil
+			}
+			return nil, nil
+		}},
+		"pods": {object: pod, list: true, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return cs.k8s.ListPods(ctx, source.(PreviewNamespace).Name)
+		}},
+		"events": {object: event, list: true, args: map[string]gqlArg{"limit": {kind: gqlInt, def: 20, max: gqlMaxEvents}}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return cs.k8s.ListEvents(ctx, source.(PreviewNamespace).Name, args["limit"].(int))
+		}},
+		"cost": {object: cost, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return cs.previewCost(ctx, source.(PreviewNamespace))
+		}},
+		"jobs": {object: job, list: true, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			preview := source.(PreviewNamespace)
+			return queueJobs(queue, preview.Repo, preview.PRNumber), nil
+		}},
+		"shareLinks": {object: shareLink, list: true, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			links, err := cs.k8s.ShareLinks(ctx, source.(PreviewNamespace).Name)
+			if err != nil {
+				return nil, err
+			}
+			return shareLinkFields(links), nil
+		}},
+	}}
+
+	cluster := &gqlObject{name: "ClusterSummary", fields: map[string]*gqlField{
+		"nodes":             {key: "nodes_count"},
+		"namespaces":        {key: "namespaces_count"},
+		"previewNamespaces": {key: "preview_namespaces"},
+		"stuckNamespaces": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return stuck.Count(), nil
+		}},
+		"runningJobs": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			running, _ := queue.Utilization()
+			return running, nil
+		}},
+		"queuedJobs": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return queue.Length(), nil
+		}},
+		"maxConcurrent": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			_, maxConcurrent := queue.Utilization()
+			return maxConcurrent, nil
+		}},
+		"hourlyCost": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			_, weekly, err := cs.totalCost(ctx)
+			return weekly / (24 * 7), err
+		}},
+		"weeklyCost": {resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			_, weekly, err := cs.totalCost(ctx)
+			return weekly, err
+		}},
+	}}
+
+	query := &gqlObject{name: "Query", fields: map[string]*gqlField{
+		"previews": {object: preview, list: true, args: map[string]gqlArg{
+			"pr":      intArg,
+			"service": stringArg,
+			"limit":   {kind: gqlInt, def: 50, max: gqlMaxPreviews},
+		}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+			if err != nil {
+				return nil, err
+			}
+			var previews []PreviewNamespace
+			for _, ns := range namespaces {
+				if pr, ok := args["pr"].(int); ok && ns.PRNumber != pr {
+					continue
+				}
+				if service, ok := args["service"].(string); ok && ns.Service != service {
+					continue
+				}
+				if len(previews) == args["limit"].(int) {
+					break
+				}
+				previews = append(previews, ns)
+			}
+			return previews, nil
+		}},
+		"preview": {object: preview, args: map[string]gqlArg{
+			"namespace": {kind: gqlString, required: true},
+		}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, ns := range namespaces {
+				if ns.Name == args["namespace"] {
+					return ns, nil
+				}
+			}
+			return nil, nil
+		}},
+		"jobs": {object: job, list: true, args: map[string]gqlArg{"repo": stringArg, "pr": intArg}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			repo, _ := args["repo"].(string)
+			pr, _ := args["pr"].(int)
+			return queueJobs(queue, repo, pr), nil
+		}},
+		"auditEvents": {object: auditEvent, list: true, args: map[string]gqlArg{
+			"action": stringArg,
+			"user":   stringArg,
+			"repo":   stringArg,
+			"pr":     intArg,
+			"limit":  {kind: gqlInt, def: 50, max: gqlMaxAuditEvents},
+		}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			filter := AuditFilter{}
+			filter.Action, _ = args["action"].(string)
+			filter.User, _ = args["user"].(string)
+			filter.Repo, _ = args["repo"].(string)
+			filter.PRNumber, _ = args["pr"].(int)
+
+			entries, err := audit.Entries(filter, args["limit"].(int))
+			if err != nil {
+				return nil, err
+			}
+			events := make([]map[string]interface{}, 0, len(entries))
+			for _, entry := range entries {
+				details := ""
+				if len(entry.Details) > 0 {
+					encoded, _ := json.Marshal(entry.Details)
+					details = string(encoded)
+				}
+				events = append(events, map[string]interface{}{
+					"time":      entry.Time.Format(time.RFC3339),
+					"action":    entry.Action,
+					"user":      entry.User,
+					"repo":      entry.Repo,
+					"pr_number": entry.PRNumber,
+					"details":   details,
+				})
+			}
+			return events, nil
+		}},
+		"cluster": {object: cluster, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return cs.k8s.GetClusterInfo(ctx)
+		}},
+	}}
+
+	return &GraphQLSchema{
+		query:         query,
+		maxDepth:      cs.config.GraphQL.MaxDepth,
+		maxComplexity: cs.config.GraphQL.MaxComplexity,
+	}
+}
+
+// previewField resolves a field of a Preview from its namespace
+func previewField(get func(PreviewNamespace) interface{}) *gqlField {
+	return &gqlField{resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+		return get(source.(PreviewNamespace)), nil
+	}}
+}
+
+// previewCost estimates a preview's cost from its pods' resource requests,
+// the same way the preview report does
+func (cs *CommandServiceK8s) previewCost(ctx context.Context, ns PreviewNamespace) (map[string]interface{}, error) {
+	cpu, memory, err := cs.k8s.GetNamespaceRequests(ctx, ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	hourly := cs.hourlyCost(cpu, memory)
+
+	soFar := 0.0
+	if !ns.CreatedAt.IsZero() {
+		soFar = hourly * time.Since(ns.CreatedAt).Hours()
+	}
+	return map[string]interface{}{
+		"cpu_cores": cpu,
+		"memory_gb": memory,
+		"hourly":    hourly,
+		"so_far":    soFar,
+		"weekly":    hourly * 24 * 7,
+	}, nil
+}
+
+// totalCost sums the cost so far and the weekly cost of every preview
+func (cs *CommandServiceK8s) totalCost(ctx context.Context) (float64, float64, error) {
+	entries, err := cs.BuildPreviewReport(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	var soFar, weekly float64
+	for _, entry := range entries {
+		soFar += entry.CostSoFar
+		weekly += entry.WeeklyCost
+	}
+	return soFar, weekly, nil
+}
+
+// queueJobs lists running then queued jobs, optionally for one repo's PR
+func queueJobs(queue *DeploymentQueue, repo string, prNumber int) []map[string]interface{} {
+	snapshot := queue.Snapshot()
+
+	jobFields := func(job QueueJob) map[string]interface{} {
+		fields := map[string]interface{}{
+			"id":          job.ID,
+			"repo":        job.Repo,
+			"pr_number":   job.PRNumber,
+			"service":     job.Service,
+			"user":        job.User,
+			"priority":    job.Priority,
+			"status":      job.Status,
+			"enqueued_at": job.EnqueuedAt.Format(time.RFC3339),
+		}
+		if !job.StartedAt.IsZero() {
+			fields["started_at"] = job.StartedAt.Format(time.RFC3339)
+		}
+		return fields
+	}
+
+	// Snapshot is untyped; a shape it no longer has lists nothing rather
+	// than panicking
+	running, _ := snapshot["running"].([]QueueJob)
+	queued, _ := snapshot["queued"].([]map[string]interface{})
+
+	jobs := []map[string]interface{}{}
+	for _, job := range running {
+		if prNumber == 0 || job.ForPR(repo, prNumber) {
+			jobs = append(jobs, jobFields(job))
+		}
+	}
+	for _, queued := range queued {
+		job, ok := queued["job"].(QueueJob)
+		if !ok || (prNumber != 0 && !job.ForPR(repo, prNumber)) {
+			continue
+		}
+		fields := jobFields(job)
+		fields["position"] = queued["position"]
+		fields["eta"] = queued["eta"]
+		jobs = append(jobs, fields)
+	}
+	return jobs
+}
+
+// shareLinkFields lists share links without their token hashes
+func shareLinkFields(links []ShareLink) []map[string]interface{} {
+	now := time.Now()
+	fields := []map[string]interface{}{}
+	for _, link := range links {
+		entry := map[string]interface{}{
+			"id":         link.ID,
+			"service":    link.Service,
+			"created_by": link.CreatedBy,
+			"created_at": link.CreatedAt.Format(time.RFC3339),
+			"expires_at": link.ExpiresAt.Format(time.RFC3339),
+			"active":     link.Active(now),
+		}
+		if link.RevokedAt != nil {
+			entry["revoked_by"] = link.RevokedBy
+			entry["revoked_at"] = link.RevokedAt.Format(time.RFC3339)
+		}
+		fields = append(fields, entry)
+	}
+	return fields
+}
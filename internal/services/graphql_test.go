@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		operations int
+		fragments  int
+		err        string
+	}{
+		{name: "shorthand", query: `{ previews { namespace } }`, operations: 1},
+		{name: "named with variables", query: `query Q($pr: Int!, $s: [String!] = ["a"]) { previews(pr: $pr) { namespace } }`, operations: 1},
+		{name: "alias and arguments", query: `{ recent: previews(limit: 5, service: "api") { namespace } }`, operations: 1},
+		{name: "fragments", query: `query { previews { ...F ... on Preview { service } } } fragment F on Preview { namespace }`, operations: 1, fragments: 1},
+		{name: "comments and commas", query: "# dashboard\n{ previews, { namespace, prNumber } }", operations: 1},
+		{name: "values", query: `{ f(a: -1.5e3, b: true, c: null, d: ENUM, e: {x: [1, 2]}, g: "q\"uote") { x } }`, operations: 1},
+		{name: "empty document", query: ``, err: "the document has no query"},
+		{name: "mutation", query: `mutation { x }`, err: "mutation operations are not supported"},
+		{name: "empty selection", query: `{ }`, err: "empty selection set"},
+		{name: "unterminated selection", query: `{ previews { namespace }`, err: "unexpected end of query"},
+		{name: "unterminated string", query: `{ f(a: "abc) { x } }`, err: "unterminated string"},
+		{name: "block string", query: `{ f(a: """abc""") { x } }`, err: "block strings are not supported"},
+		{name: "invalid number", query: `{ f(a: 1.) { x } }`, err: "invalid number"},
+		{name: "directive", query: `{ previews @skip(if: true) { namespace } }`, err: "directives are not supported"},
+		{name: "duplicate fragment", query: `{ x } fragment F on A { x } fragment F on A { y }`, err: `fragment "F" is defined twice`},
+		{name: "fragment named on", query: `{ x } fragment on on A { x }`, err: `can't be named "on"`},
+		{name: "unexpected character", query: `{ previews % }`, err: "unexpected character"},
+		{name: "nested selections", query: strings.Repeat("{ a ", gqlMaxNesting+1) + strings.Repeat("}", gqlMaxNesting+1), err: "nested more than"},
+		{name: "nested values", query: "{ a(b: " + strings.Repeat("[", gqlMaxNesting+1) + strings.Repeat("]", gqlMaxNesting+1) + ") }", err: "nested more than"},
+		{name: "nested types", query: "query ($a: " + strings.Repeat("[", gqlMaxNesting+1) + "Int" + strings.Repeat("]", gqlMaxNesting+1) + ") { a }", err: "nested more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseGraphQL(tt.query)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseGraphQL() error = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGraphQL() error = %v", err)
+			}
+			if len(doc.operations) != tt.operations || len(doc.fragments) != tt.fragments {
+				t.Errorf("parseGraphQL() = %d operations, %d fragments, want %d and %d", len(doc.operations), len(doc.fragments), tt.operations, tt.fragments)
+			}
+		})
+	}
+}
+
+func TestGraphQLExecute(t *testing.T) {
+	item := &gqlObject{name: "Item", fields: map[string]*gqlField{
+		"id": {},
+	}}
+	schema := &GraphQLSchema{
+		query: &gqlObject{name: "Query", fields: map[string]*gqlField{
+			"items": {object: item, list: true, args: map[string]gqlArg{"limit": {kind: gqlInt, def: 2, max: 3}}, resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				var items []map[string]interface{}
+				for i := 0; i < args["limit"].(int); i++ {
+					items = append(items, map[string]interface{}{"id": i})
+				}
+				return items, nil
+			}},
+		}},
+		maxDepth:      3,
+		maxComplexity: 100,
+	}
+
+	tests := []struct {
+		name      string
+		request   GraphQLRequest
+		data      string
+		errorText string
+	}{
+		{name: "default limit", request: GraphQLRequest{Query: `{ items { id } }`}, data: `{"items":[{"id":0},{"id":1}]}`},
+		{name: "limit clamped to max", request: GraphQLRequest{Query: `{ items(limit: 1000000) { id } }`}, data: `{"items":[{"id":0},{"id":1},{"id":2}]}`},
+		{name: "limit clamped to one", request: GraphQLRequest{Query: `{ items(limit: -5) { id } }`}, data: `{"items":[{"id":0}]}`},
+		{name: "limit from a variable", request: GraphQLRequest{Query: `query ($n: Int) { items(limit: $n) { id } }`, Variables: map[string]interface{}{"n": float64(1)}}, data: `{"items":[{"id":0}]}`},
+		{name: "alias and typename", request: GraphQLRequest{Query: `{ first: items(limit: 1) { __typename id } }`}, data: `{"first":[{"__typename":"Item","id":0}]}`},
+		{name: "unknown field", request: GraphQLRequest{Query: `{ items { name } }`}, errorText: `cannot query field "name" on type Item`},
+		{name: "unknown argument", request: GraphQLRequest{Query: `{ items(first: 1) { id } }`}, errorText: `unknown argument "first"`},
+		{name: "wrong argument type", request: GraphQLRequest{Query: `{ items(limit: "1") { id } }`}, errorText: "expected Int"},
+		{name: "self spread", request: GraphQLRequest{Query: `{ items { ...F } } fragment F on Item { ...F }`}, errorText: `fragment "F" spreads itself`},
+		{name: "missing subfields", request: GraphQLRequest{Query: `{ items }`}, errorText: "needs a selection of subfields"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := schema.Execute(context.Background(), tt.request)
+			if tt.errorText != "" {
+				if len(response.Errors) == 0 || !strings.Contains(response.Errors[0].Message, tt.errorText) {
+					t.Fatalf("Execute() errors = %v, want %q", response.Errors, tt.errorText)
+				}
+				return
+			}
+			if len(response.Errors) > 0 {
+				t.Fatalf("Execute() errors = %v", response.Errors)
+			}
+			data, err := json.Marshal(response.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.data {
+				t.Errorf("Execute() data = %s, want %s", data, tt.data)
+			}
+		})
+	}
+}
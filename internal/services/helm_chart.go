@@ -0,0 +1,225 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Exact chart versions only, e.g. 1.4.2 or v2.0.0-rc.1; ranges would let a
+// preview change without the PR changing
+var chartVersionPattern = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// ChartSource is a chart a service deploys from a chart repository or an
+// OCI registry instead of from the repo, such as a platform team's shared
+// service chart
+type ChartSource struct {
+	// Repository is a chart repository URL (https://charts.acme.dev) or an
+	// OCI registry path (oci://ghcr.io/acme/charts)
+	Repository string `yaml:"repository"`
+	Chart      string `yaml:"chart"`
+	Version    string `yaml:"version"` // exact version the preview is pinned to
+
+	// Values are files in the repo, applied in order
+	Values []string `yaml:"values"`
+
+	// Set values are templates rendered with .PR, .Service, .Branch
+	// (slugified), .Repo and .Domain, e.g. "pr-{{ .PR }}.{{ .Domain }}"
+	Set map[string]string `yaml:"set"`
+
+	// Credentials names a HELM_CREDENTIALS entry for private sources; it's
+	// only sent to the host the entry is bound to
+	Credentials string `yaml:"credentials"`
+}
+
+func (cs ChartSource) validate() error {
+	parsed, err := url.Parse(cs.Repository)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "oci") {
+		return fmt.Errorf("repository must be an https:// chart repository or an oci:// registry, got %q", cs.Repository)
+	}
+	if cs.Chart == "" || strings.ContainsAny(cs.Chart, "/ ") {
+		return fmt.Errorf("chart must be the chart's name, got %q", cs.Chart)
+	}
+	if !chartVersionPattern.MatchString(cs.Version) {
+		return fmt.Errorf("version must pin an exact chart version, got %q", cs.Version)
+	}
+	for _, file := range cs.Values {
+		if filepath.IsAbs(file) || strings.HasPrefix(path.Clean(filepath.ToSlash(file)), "../") {
+			return fmt.Errorf("values file %q must be inside the repo", file)
+		}
+	}
+	for key, value := range cs.Set {
+		if _, err := template.New(key).Option("missingkey=error").Parse(value); err != nil {
+			return fmt.Errorf("invalid set template %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// Ref is the chart's reference with its version, for reports
+func (cs ChartSource) Ref() string {
+	return fmt.Sprintf("%s/%s@%s", strings.TrimSuffix(cs.Repository, "/"), cs.Chart, cs.Version)
+}
+
+// ChartFor returns the remote chart the service deploys from, if any
+func (c *RepoConfig) ChartFor(service string) (ChartSource, bool) {
+	if chart, ok := c.Charts[service]; ok {
+		return chart, true
+	}
+	chart, ok := c.Charts[strings.ReplaceAll(service, "/", "-")]
+	return chart, ok
+}
+
+// HelmRenderer renders remote charts with `helm template`, so the result
+// goes through the same mutation, policy and apply steps as a manifest
+type HelmRenderer struct {
+	binary      string
+	credentials []string // name@host=username:password
+	timeout     time.Duration
+}
+
+func NewHelmRenderer(binary string, credentials []string, timeout time.Duration) *HelmRenderer {
+	return &HelmRenderer{
+		binary:      binary,
+		credentials: credentials,
+		timeout:     timeout,
+	}
+}
+
+// Render templates the chart as release, with values files read from the
+// repo and set values rendered from data
+func (hr *HelmRenderer) Render(ctx context.Context, release string, source ChartSource, repoPath string, data injectionData) ([]byte, error) {
+	if err := source.validate(); err != nil {
+		return nil, err
+	}
+	if hr.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hr.timeout)
+		defer cancel()
+	}
+
+	args := []string{"template", release}
+	chartArgs := []string{source.Chart, "--repo", source.Repository}
+	if strings.HasPrefix(source.Repository, "oci://") {
+		chartArgs = []string{strings.TrimSuffix(source.Repository, "/") + "/" + source.Chart}
+	}
+	if source.Credentials != "" {
+		dir, err := os.MkdirTemp("", "helm-credentials-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create helm credentials dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		if chartArgs, err = hr.login(ctx, source, dir); err != nil {
+			return nil, err
+		}
+	}
+	args = append(args, chartArgs...)
+	args = append(args, "--version", source.Version)
+
+	for _, file := range source.Values {
+		args = append(args, "--values", filepath.Join(repoPath, file))
+	}
+
+	keys := make([]string, 0, len(source.Set))
+	for key := range source.Set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(source.Set[key])
+		if err != nil {
+			return nil, fmt.Errorf("invalid set template %s: %v", key, err)
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("failed to render set %s: %v", key, err)
+		}
+		args = append(args, "--set-string", key+"="+value.String())
+	}
+
+	cmd := exec.CommandContext(ctx, hr.binary, args...)
+	cmd.Dir = repoPath
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s template %s failed: %v: %s", hr.binary, source.Ref(), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// login stores the source's credential in config files under dir, passing
+// the password on stdin so it never shows up in a process listing, and
+// returns the chart arguments that use them
+func (hr *HelmRenderer) login(ctx context.Context, source ChartSource, dir string) ([]string, error) {
+	parsed, err := url.Parse(source.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chart repository %q: %v", source.Repository, err)
+	}
+	username, password, err := hr.credential(source.Credentials, parsed.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if parsed.Scheme == "oci" {
+		registryConfig := filepath.Join(dir, "registry.json")
+		if err := hr.run(ctx, password, "registry", "login", parsed.Host, "--username", username, "--password-stdin", "--registry-config", registryConfig); err != nil {
+			return nil, err
+		}
+		return []string{strings.TrimSuffix(source.Repository, "/") + "/" + source.Chart, "--registry-config", registryConfig}, nil
+	}
+
+	repositoryConfig := filepath.Join(dir, "repositories.yaml")
+	repositoryCache := filepath.Join(dir, "cache")
+	if err := hr.run(ctx, password, "repo", "add", "preview", source.Repository, "--username", username, "--password-stdin",
+		"--repository-config", repositoryConfig, "--repository-cache", repositoryCache); err != nil {
+		return nil, err
+	}
+	return []string{"preview/" + source.Chart, "--repository-config", repositoryConfig, "--repository-cache", repositoryCache}, nil
+}
+
+// run runs a helm command with stdin, for logins
+func (hr *HelmRenderer) run(ctx context.Context, stdin string, args ...string) error {
+	cmd := exec.CommandContext(ctx, hr.binary, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", hr.binary, strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// credential looks up a HELM_CREDENTIALS entry, so registry passwords stay
+// on the server rather than in the repo. Each entry is bound to a host, and
+// only given out for a chart source on that host, so a PR can't send it to
+// a host of its own.
+func (hr *HelmRenderer) credential(name, host string) (string, string, error) {
+	for _, entry := range hr.credentials {
+		entryName, secret, ok := strings.Cut(entry, "=")
+		entryName, entryHost, bound := strings.Cut(strings.TrimSpace(entryName), "@")
+		if !ok || entryName != name {
+			continue
+		}
+		username, password, ok := strings.Cut(secret, ":")
+		if !bound || entryHost == "" || !ok || username == "" {
+			return "", "", fmt.Errorf("HELM_CREDENTIALS entry %s must be name@host=username:password", name)
+		}
+		if !strings.EqualFold(entryHost, host) {
+			return "", "", fmt.Errorf("HELM_CREDENTIALS entry %s is for %s, not %s", name, entryHost, host)
+		}
+		return username, password, nil
+	}
+	return "", "", fmt.Errorf("no HELM_CREDENTIALS entry named %s", name)
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultLocale = "en"
+
+// LanguagePack holds the bot's response templates and command synonyms for a locale
+type LanguagePack struct {
+	Locale   string            `yaml:"locale"`
+	Messages map[string]string `yaml:"messages"`
+	Synonyms map[string]string `yaml:"synonyms"` // localized command -> canonical command
+}
+
+var builtinLanguagePacks = map[string]*LanguagePack{
+	"en": {
+		Locale: "en",
+		Messages: map[string]string{
+			"help.title":          "## 🤖 Available Commands",
+			"help.read_only":      "**📖 Read-Only Commands (Available to Everyone):**",
+			"help.deploy":         "**🚀 Deployment Commands (Core Team Only):**",
+			"help.examples":       "**Examples:**",
+			"help.cmd.help":       "Show this help message",
+			"help.cmd.status":     "Show current preview environments",
+			"help.cmd.status_js":  "Show them as JSON for scripts and CI",
+			"help.cmd.plan":       "Show what would be deployed, including the Terraform plan",
+			"help.cmd.plan_svc":   "Show plan for specific service",
+			"help.cmd.queue":      "Show queued deployments and their ETA",
+			"help.cmd.services":   "List deployable services and what this PR changed",
+			"help.cmd.inspect":    "Describe a preview's workloads, pods, events and endpoints",
+			"help.cmd.config":     "Show the effective preview config and where each setting comes from",
+			"help.cmd.preview":    "Deploy all changed services to preview",
+			"help.cmd.preview_sv": "Deploy specific service",
+			"help.cmd.preview_al": "Deploy every service together in one namespace, wired by URL",
+			"help.cmd.preview_cl": "Deploy sized as tiny, small or medium",
+			"help.cmd.preview_pr": "Jump the deployment queue (also via the preview-priority label)",
+			"help.cmd.preview_tt": "Keep the preview for a set time before the reaper deletes it",
+			"help.cmd.cleanup":    "Cleanup preview environments",
+			"help.cmd.loadtest":   "Load test a scaled-up preview",
+			"help.cmd.promote":    "Copy the preview's exact manifests to staging",
+			"help.cmd.snapshot":   "Capture preview state for a later restore",
+			"help.cmd.restore":    "Recreate previews from a snapshot",
+			"help.cmd.kubeconfig": "Get a kubectl config scoped to the preview",
+			"help.cmd.chaos":      "Inject latency and connection resets into a preview",
+			"help.cmd.chaos_off":  "Remove injected faults from this PR's previews",
+			"help.cmd.canary":     "Send a share of the base environment's traffic to a preview",
+			"help.cmd.canary_off": "Stop sending base environment traffic to this PR's previews",
+			"help.cmd.scale":      "Change the replica count of a preview, up to the configured limit",
+			"help.cmd.tap":        "Capture a sample of a preview's requests and summarize routes, status codes and latencies",
+			"help.cmd.share":      "Create an expiring link that opens a preview without a GitHub account",
+			"help.cmd.share_rev":  "Revoke this PR's share links: one, a service's, or all of them",
+			"help.ops":            "**🛠️ Ops Commands (Ops Repository, Admins Only):**",
+			"help.cmd.list_prev":  "List every preview in the cluster",
+			"help.cmd.cluster":    "Show cluster capacity and preview counts",
+			"help.cmd.cl_status":  "Report node pressure, preview resource use and cleanup backlog (works on PRs too)",
+			"help.cmd.gc":         "Delete previews older than the given age",
+			"help.cmd.force_cl":   "Clear the finalizers of a preview namespace stuck in Terminating",
+			"help.cmd.grant":      "Let a GitHub user run deployment commands",
+			"help.cmd.revoke":     "Remove a user's deployment access",
+			"help.cmd.maint":      "Pause or resume new deployments for a maintenance window",
+			"triggered_by":        "*Triggered by: @%s*",
+			"status.title":        "## 📊 Preview Environment Status",
+			"status.none":         "### ℹ️ No Preview Environments Found\n\nNo preview environments are currently active for this PR.",
+			"status.create_hint":  "**To create preview environments:**\n- Run `/preview` to deploy all changed services\n- Run `/preview <service>` to deploy a specific service",
+			"status.checked_by":   "*Status checked by: @%s*",
+			"queue.title":         "## ⏳ Deployment Queue",
+			"queue.none":          "No deployments are queued for this PR.",
+			"queue.total":         "**Total queued:** %d",
+			"queue.table":         "| Service | Requested by | Position | ETA |",
+			"queue.checked_by":    "*Queue checked by: @%s*",
+			"queue.queued":        "## ⏳ Preview Deployment Queued\n\nThe deployment concurrency limit has been reached, so this preview will start automatically when a slot frees up.",
+			"denied.deploy":       "🔒 Access denied. Only core team can deploy.",
+			"denied.cleanup":      "🔒 Access denied. Only core team can cleanup.",
+			"denied.loadtest":     "🔒 Access denied. Only core team can run load tests.",
+			"denied.promote":      "🔒 Access denied. Only core team can promote to staging.",
+			"denied.snapshot":     "🔒 Access denied. Only core team can snapshot or restore previews.",
+			"denied.kubeconfig":   "🔒 Access denied. Only core team can get preview credentials.",
+			"denied.chaos":        "🔒 Access denied. Only core team can inject faults into previews.",
+			"denied.canary":       "🔒 Access denied. Only core team can route real traffic to previews.",
+			"denied.scale":        "🔒 Access denied. Only core team can scale previews.",
+			"denied.tap":          "🔒 Access denied. Only core team can capture preview traffic.",
+			"denied.plan":         "🔒 Access denied. Only core team can run plans.",
+			"denied.share":        "🔒 Access denied. Only core team can share previews outside GitHub.",
+			"denied.ops":          "🔒 Access denied. Only admins can run ops commands.",
+			"cluster.held":        "## 🔌 Cluster Unavailable\n\nThe Kubernetes API can't be reached right now. Your `/%s` is queued and runs automatically once the cluster is back.",
+			"cluster.down":        "## 🔌 Cluster Unavailable\n\nThe Kubernetes API can't be reached right now, so `/%s` was rejected. Try again once the cluster is back.",
+			"denied.cl_status":    "🔒 Access denied. Only admins can view cluster status.",
+			"ops.wrong_repo":      "ℹ️ Ops commands are only available in the ops repository.",
+			"maintenance.paused":  "## 🚧 Previews Are Temporarily Paused\n\nNew deployments are paused while the preview cluster is under maintenance. `/status` and `/cleanup` still work; try again once maintenance is over.",
+		},
+		Synonyms: map[string]string{},
+	},
+	"id": {
+		Locale: "id",
+		Messages: map[string]string{
+			"help.title":          "## 🤖 Perintah yang Tersedia",
+			"help.read_only":      "**📖 Perintah Baca Saja (Untuk Semua Orang):**",
+			"help.deploy":         "**🚀 Perintah Deployment (Hanya Tim Inti):**",
+			"help.examples":       "**Contoh:**",
+			"help.cmd.help":       "Tampilkan pesan bantuan ini",
+			"help.cmd.status":     "Tampilkan environment preview saat ini",
+			"help.cmd.status_js":  "Tampilkan dalam JSON untuk skrip dan CI",
+			"help.cmd.plan":       "Tampilkan apa yang akan di-deploy, termasuk plan Terraform",
+			"help.cmd.plan_svc":   "Tampilkan rencana untuk service tertentu",
+			"help.cmd.queue":      "Tampilkan antrean deployment dan perkiraan waktunya",
+			"help.cmd.services":   "Tampilkan service yang bisa di-deploy dan yang diubah PR ini",
+			"help.cmd.inspect":    "Jelaskan workload, pod, event dan endpoint sebuah preview",
+			"help.cmd.config":     "Tampilkan konfigurasi preview yang berlaku dan asal tiap pengaturan",
+			"help.cmd.preview":    "Deploy semua service yang berubah ke preview",
+			"help.cmd.preview_sv": "Deploy service tertentu",
+			"help.cmd.preview_al": "Deploy semua service bersama dalam satu namespace, saling terhubung lewat URL",
+			"help.cmd.preview_cl": "Deploy dengan ukuran tiny, small atau medium",
+			"help.cmd.preview_pr": "Lewati antrean deployment (juga lewat label preview-priority)",
+			"help.cmd.preview_tt": "Simpan preview selama waktu tertentu sebelum dihapus reaper",
+			"help.cmd.cleanup":    "Bersihkan environment preview",
+			"help.cmd.loadtest":   "Uji beban preview yang di-scale",
+			"help.cmd.promote":    "Salin manifest preview apa adanya ke staging",
+			"help.cmd.snapshot":   "Simpan state preview untuk dipulihkan nanti",
+			"help.cmd.restore":    "Buat ulang preview dari snapshot",
+			"help.cmd.kubeconfig": "Dapatkan konfigurasi kubectl khusus untuk preview",
+			"help.cmd.chaos":      "Sisipkan latensi dan reset koneksi ke preview",
+			"help.cmd.chaos_off":  "Hapus gangguan yang disisipkan dari preview PR ini",
+			"help.cmd.canary":     "Alihkan sebagian trafik environment dasar ke preview",
+			"help.cmd.canary_off": "Hentikan pengalihan trafik environment dasar ke preview PR ini",
+			"help.cmd.scale":      "Ubah jumlah replika preview, hingga batas yang dikonfigurasi",
+			"help.cmd.tap":        "Rekam sampel request ke preview dan ringkas rute, kode status, dan latensinya",
+			"help.cmd.share":      "Buat tautan berbatas waktu untuk membuka preview tanpa akun GitHub",
+			"help.cmd.share_rev":  "Cabut tautan berbagi PR ini: satu, milik satu layanan, atau semuanya",
+			"help.ops":            "**🛠️ Perintah Ops (Repositori Ops, Hanya Admin):**",
+			"help.cmd.list_prev":  "Tampilkan semua preview di cluster",
+			"help.cmd.cluster":    "Tampilkan kapasitas cluster dan jumlah preview",
+			"help.cmd.cl_status":  "Laporkan tekanan node, pemakaian resource preview dan antrean pembersihan (bisa juga di PR)",
+			"help.cmd.gc":         "Hapus preview yang lebih tua dari umur tertentu",
+			"help.cmd.force_cl":   "Hapus finalizer namespace preview yang macet di Terminating",
+			"help.cmd.grant":      "Izinkan pengguna GitHub menjalankan perintah deployment",
+			"help.cmd.revoke":     "Cabut akses deployment pengguna",
+			"help.cmd.maint":      "Jeda atau lanjutkan deployment baru selama masa pemeliharaan",
+			"triggered_by":        "*Dipicu oleh: @%s*",
+			"status.title":        "## 📊 Status Environment Preview",
+			"status.none":         "### ℹ️ Tidak Ada Environment Preview\n\nTidak ada environment preview yang aktif untuk PR ini.",
+			"status.create_hint":  "**Untuk membuat environment preview:**\n- Jalankan `/preview` untuk deploy semua service yang berubah\n- Jalankan `/preview <service>` untuk deploy service tertentu",
+			"status.checked_by":   "*Status dicek oleh: @%s*",
+			"queue.title":         "## ⏳ Antrean Deployment",
+			"queue.none":          "Tidak ada deployment yang mengantre untuk PR ini.",
+			"queue.total":         "**Total antrean:** %d",
+			"queue.table":         "| Service | Diminta oleh | Posisi | Perkiraan |",
+			"queue.checked_by":    "*Antrean dicek oleh: @%s*",
+			"queue.queued":        "## ⏳ Deployment Preview Mengantre\n\nBatas deployment bersamaan telah tercapai, preview ini akan dimulai otomatis saat slot tersedia.",
+			"denied.deploy":       "🔒 Akses ditolak. Hanya tim inti yang dapat melakukan deploy.",
+			"denied.cleanup":      "🔒 Akses ditolak. Hanya tim inti yang dapat melakukan cleanup.",
+			"denied.loadtest":     "🔒 Akses ditolak. Hanya tim inti yang dapat menjalankan uji beban.",
+			"denied.promote":      "🔒 Akses ditolak. Hanya tim inti yang dapat mempromosikan ke staging.",
+			"denied.snapshot":     "🔒 Akses ditolak. Hanya tim inti yang dapat membuat snapshot atau memulihkan preview.",
+			"denied.kubeconfig":   "🔒 Akses ditolak. Hanya tim inti yang dapat mengambil kredensial preview.",
+			"denied.chaos":        "🔒 Akses ditolak. Hanya tim inti yang dapat menyisipkan gangguan ke preview.",
+			"denied.canary":       "🔒 Akses ditolak. Hanya tim inti yang dapat mengalihkan trafik nyata ke preview.",
+			"denied.scale":        "🔒 Akses ditolak. Hanya tim inti yang dapat mengubah skala preview.",
+			"denied.tap":          "🔒 Akses ditolak. Hanya tim inti yang dapat merekam lalu lintas preview.",
+			"denied.plan":         "🔒 Akses ditolak. Hanya tim inti yang dapat menjalankan plan.",
+			"denied.share":        "🔒 Akses ditolak. Hanya tim inti yang dapat membagikan preview di luar GitHub.",
+			"denied.ops":          "🔒 Akses ditolak. Hanya admin yang dapat menjalankan perintah ops.",
+			"cluster.held":        "## 🔌 Cluster Tidak Tersedia\n\nKubernetes API sedang tidak dapat dijangkau. `/%s` Anda masuk antrean dan berjalan otomatis saat cluster kembali.",
+			"cluster.down":        "## 🔌 Cluster Tidak Tersedia\n\nKubernetes API sedang tidak dapat dijangkau, jadi `/%s` ditolak. Coba lagi saat cluster kembali.",
+			"denied.cl_status":    "🔒 Akses ditolak. Hanya admin yang dapat melihat status cluster.",
+			"ops.wrong_repo":      "ℹ️ Perintah ops hanya tersedia di repositori ops.",
+			"maintenance.paused":  "## 🚧 Preview Dijeda Sementara\n\nDeployment baru dijeda selama cluster preview dalam pemeliharaan. `/status` dan `/cleanup` tetap berfungsi; coba lagi setelah pemeliharaan selesai.",
+		},
+		Synonyms: map[string]string{
+			"bantuan":   "help",
+			"rencana":   "plan",
+			"antrean":   "queue",
+			"pratinjau": "preview",
+			"bersihkan": "cleanup",
+			"ujibeban":  "loadtest",
+			"promosi":   "promote",
+			"pulihkan":  "restore",
+			"periksa":   "inspect",
+		},
+	},
+}
+
+// LoadLanguagePack returns the pack for locale, overlaying <dir>/<locale>.yaml
+// on the built-in bundle when present. Missing keys fall back to English.
+func LoadLanguagePack(locale, dir string) (*LanguagePack, error) {
+	pack := &LanguagePack{
+		Locale:   locale,
+		Messages: map[string]string{},
+		Synonyms: map[string]string{},
+	}
+
+	if builtin, ok := builtinLanguagePacks[locale]; ok {
+		pack.merge(builtin)
+	}
+
+	if dir != "" {
+		content, err := os.ReadFile(filepath.Join(dir, locale+".yaml"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read language pack %s: %v", locale, err)
+		}
+		if err == nil {
+			var custom LanguagePack
+			if err := yaml.Unmarshal(content, &custom); err != nil {
+				return nil, fmt.Errorf("failed to parse language pack %s: %v", locale, err)
+			}
+			pack.merge(&custom)
+		}
+	}
+
+	if len(pack.Messages) == 0 && locale != defaultLocale {
+		return nil, fmt.Errorf("unknown locale: %s", locale)
+	}
+
+	return pack, nil
+}
+
+// DefaultLanguagePack returns the built-in English pack
+func DefaultLanguagePack() *LanguagePack {
+	return builtinLanguagePacks[defaultLocale]
+}
+
+// T renders a message, falling back to English for untranslated keys
+func (lp *LanguagePack) T(key string, args ...interface{}) string {
+	message, ok := lp.Messages[key]
+	if !ok {
+		message, ok = builtinLanguagePacks[defaultLocale].Messages[key]
+	}
+	if !ok {
+		return key
+	}
+
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Canonicalize rewrites a localized command word (e.g. /bantuan) to its canonical form
+func (lp *LanguagePack) Canonicalize(comment string) string {
+	fields := strings.SplitN(comment, " ", 2)
+	word := strings.TrimPrefix(fields[0], "/")
+	if !strings.HasPrefix(fields[0], "/") {
+		return comment
+	}
+
+	if canonical, ok := lp.Synonyms[strings.ToLower(word)]; ok {
+		fields[0] = "/" + canonical
+	}
+	return strings.Join(fields, " ")
+}
+
+func (lp *LanguagePack) merge(other *LanguagePack) {
+	for key, value := range other.Messages {
+		lp.Messages[key] = value
+	}
+	for key, value := range other.Synonyms {
+		lp.Synonyms[key] = value
+	}
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// archLabel is the well-known node label the kubelet sets to its GOARCH
+const archLabel = "kubernetes.io/arch"
+
+// Manifest media types a registry may answer a tag with
+var manifestAccept = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+// ArchSelection is the CPU architecture a workload was pinned to
+type ArchSelection struct {
+	Workload string   `json:"workload"`
+	Images   []string `json:"images,omitempty"` // architectures every image of the workload supports
+	Chosen   []string `json:"chosen,omitempty"` // node architectures the pods may land on
+	Pinned   bool     `json:"pinned"`           // whether a nodeAffinity was added
+	Warning  string   `json:"warning,omitempty"`
+}
+
+// ArchReport is what pinArchitectures found on a mixed-arch cluster
+type ArchReport struct {
+	Nodes     []string        `json:"nodes"` // architectures of the cluster's nodes
+	Workloads []ArchSelection `json:"workloads"`
+}
+
+// NodeArchitectures lists the architectures of the cluster's nodes
+func (k *K8sService) NodeArchitectures(ctx context.Context) ([]string, error) {
+	nodes, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	seen := make(map[string]bool)
+	var archs []string
+	for _, node := range nodes.Items {
+		if arch := node.Labels[archLabel]; arch != "" && !seen[arch] {
+			seen[arch] = true
+			archs = append(archs, arch)
+		}
+	}
+	sort.Strings(archs)
+	return archs, nil
+}
+
+// pinArchitectures adds a nodeAffinity on kubernetes.io/arch to workloads
+// whose images don't run on every node architecture of the cluster, so their
+// pods aren't scheduled where they crash with exec format errors. It does
+// nothing on single-arch clusters, and never blocks the deployment: images
+// it can't inspect are left to the scheduler.
+func (cs *CommandServiceK8s) pinArchitectures(ctx context.Context, parsed *ParsedManifest) *ArchReport {
+	if !cs.config.Preview.ArchAffinity || parsed == nil {
+		return nil
+	}
+	nodeArchs, err := cs.k8s.NodeArchitectures(ctx)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return nil
+	}
+	if len(nodeArchs) < 2 {
+		return nil
+	}
+
+	report := &ArchReport{Nodes: nodeArchs}
+	resolver := &imageArchResolver{
+		client:   &http.Client{Timeout: 15 * time.Second},
+		registry: cs.config.ImageGC.Registry,
+		token:    cs.config.ImageGC.RegistryToken,
+		cache:    make(map[string]imageArchs),
+	}
+	pin := func(workload string, spec *corev1.PodSpec) {
+		if selection := resolver.pin(ctx, workload, spec, nodeArchs); selection != nil {
+			report.Workloads = append(report.Workloads, *selection)
+		}
+	}
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		pin(fmt.Sprintf("Deployment/%s", dep.Name), &dep.Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		pin(fmt.Sprintf("StatefulSet/%s", sts.Name), &sts.Spec.Template.Spec)
+	}
+
+	if len(report.Workloads) == 0 {
+		return nil
+	}
+	return report
+}
+
+// imageArchs is an image's architectures, or why they're unknown
+type imageArchs struct {
+	archs []string
+	err   error
+}
+
+// imageArchResolver reads images' architectures from their registries,
+// once per image for a deploy
+type imageArchResolver struct {
+	client   *http.Client
+	registry string // registry the token is for
+	token    string
+	cache    map[string]imageArchs
+}
+
+// pin works out where the workload's pods can run and adds the affinity.
+// It returns nil for workloads that pin themselves or run anywhere.
+func (r *imageArchResolver) pin(ctx context.Context, workload string, spec *corev1.PodSpec, nodeArchs []string) *ArchSelection {
+	if spec.NodeSelector[archLabel] != "" || hasArchAffinity(spec) {
+		return nil
+	}
+
+	var supported []string
+	for i, container := range append(spec.InitContainers, spec.Containers...) {
+		resolved, ok := r.cache[container.Image]
+		if !ok {
+			resolved.archs, resolved.err = r.resolve(ctx, container.Image)
+			r.cache[container.Image] = resolved
+		}
+		if resolved.err != nil {
+			return &ArchSelection{Workload: workload, Warning: fmt.Sprintf("`%s`: %v", container.Image, resolved.err)}
+		}
+		if i == 0 {
+			supported = resolved.archs
+		} else {
+			supported = intersectArchs(supported, resolved.archs)
+		}
+	}
+
+	selection := &ArchSelection{Workload: workload, Images: supported, Chosen: intersectArchs(supported, nodeArchs)}
+	switch {
+	case len(selection.Chosen) == 0:
+		selection.Warning = fmt.Sprintf("no node runs %s; the pods will stay Pending or crash", strings.Join(supported, "/"))
+	case len(selection.Chosen) == len(nodeArchs):
+		return nil
+	default:
+		addArchAffinity(spec, selection.Chosen)
+		selection.Pinned = true
+	}
+	return selection
+}
+
+// resolve returns the architectures an image is built for: the platforms of
+// a manifest list, or the architecture of a single-platform image's config
+func (r *imageArchResolver) resolve(ctx context.Context, image string) ([]string, error) {
+	name, reference, isDigest := strings.Cut(image, "@")
+	host, repository, tag := splitImageRef(name)
+	if !isDigest {
+		reference = tag
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+
+	body, mediaType, err := r.get(ctx, host, repository, fmt.Sprintf("manifests/%s", reference), manifestAccept)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest struct {
+		Manifests []struct {
+			Platform struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+			} `json:"platform"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+
+	if strings.Contains(mediaType, "index") || strings.Contains(mediaType, "manifest.list") || len(manifest.Manifests) > 0 {
+		var archs []string
+		for _, entry := range manifest.Manifests {
+			// Attestation manifests are listed with an unknown platform
+			if entry.Platform.OS == "linux" && entry.Platform.Architecture != "" {
+				archs = append(archs, entry.Platform.Architecture)
+			}
+		}
+		if len(archs) == 0 {
+			return nil, fmt.Errorf("manifest list has no linux platforms")
+		}
+		return intersectArchs(archs, archs), nil
+	}
+
+	if manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("manifest has no config")
+	}
+	body, _, err = r.get(ctx, host, repository, fmt.Sprintf("blobs/%s", manifest.Config.Digest), "")
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Architecture string `json:"architecture"`
+	}
+	if err := json.Unmarshal(body, &config); err != nil || config.Architecture == "" {
+		return nil, fmt.Errorf("image config has no architecture")
+	}
+	return []string{config.Architecture}, nil
+}
+
+// get fetches a registry v2 path, answering a bearer challenge with an
+// anonymous pull token when the registry has no configured one
+func (r *imageArchResolver) get(ctx context.Context, host, repository, path, accept string) ([]byte, string, error) {
+	endpoint := fmt.Sprintf("https://%s/v2/%s/%s", host, repository, path)
+	token := ""
+	if host == r.registry {
+		token = r.token
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return body, resp.Header.Get("Content-Type"), nil
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0 && token == "":
+			token, err = r.anonymousToken(ctx, resp.Header.Get("WWW-Authenticate"))
+			if err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", fmt.Errorf("registry returned status %d", resp.StatusCode)
+		}
+	}
+	return nil, "", fmt.Errorf("registry refused the pull token")
+}
+
+// anonymousToken follows a Bearer realm="...",service="...",scope="..."
+// challenge, as Docker Hub and GHCR send for public images
+func (r *imageArchResolver) anonymousToken(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("registry needs credentials")
+	}
+
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid registry auth challenge")
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if values[key] != "" {
+			query.Set(key, values[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry needs credentials (token status %d)", resp.StatusCode)
+	}
+
+	var answer struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("invalid registry token: %v", err)
+	}
+	if answer.Token == "" {
+		answer.Token = answer.AccessToken
+	}
+	return answer.Token, nil
+}
+
+// intersectArchs returns the sorted architectures in both a and b
+func intersectArchs(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, arch := range b {
+		in[arch] = true
+	}
+	seen := make(map[string]bool)
+	var both []string
+	for _, arch := range a {
+		if in[arch] && !seen[arch] {
+			seen[arch] = true
+			both = append(both, arch)
+		}
+	}
+	sort.Strings(both)
+	return both
+}
+
+func hasArchAffinity(spec *corev1.PodSpec) bool {
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expression := range term.MatchExpressions {
+			if expression.Key == archLabel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addArchAffinity requires one of archs on top of the pod's own node
+// affinity; terms are ORed, so every term gets the requirement
+func addArchAffinity(spec *corev1.PodSpec, archs []string) {
+	requirement := corev1.NodeSelectorRequirement{Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: archs}
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	required := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{requirement}}},
+		}
+		return
+	}
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchExpressions = append(required.NodeSelectorTerms[i].MatchExpressions, requirement)
+	}
+}
+
+func formatArchReport(report *ArchReport) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("### 🖥️ Node Architecture\nCluster nodes: %s\n", strings.Join(report.Nodes, ", ")))
+	for _, selection := range report.Workloads {
+		switch {
+		case selection.Pinned:
+			content.WriteString(fmt.Sprintf("- `%s`: scheduled on **%s** (images built for %s)\n", selection.Workload, strings.Join(selection.Chosen, "/"), strings.Join(selection.Images, ", ")))
+		default:
+			content.WriteString(fmt.Sprintf("- ⚠️ `%s`: %s\n", selection.Workload, selection.Warning))
+		}
+	}
+	return content.String()
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// Image GC methods
+const (
+	ImageGCNode     = "node"     // crictl rmi on every node through a DaemonSet
+	ImageGCRegistry = "registry" // untag in the registry
+)
+
+const imageGCPollInterval = 5 * time.Second
+
+// ImageGCResult records what happened to a PR's images after cleanup
+type ImageGCResult struct {
+	Images   []string // PR-specific images that were collected
+	InUse    []string // PR-specific images still used elsewhere, left alone
+	Nodes    int32    // nodes that finished removing the images
+	Untagged []string
+	Failed   []string
+}
+
+// validateImageGC checks IMAGE_GC_METHODS and IMAGE_GC_TAG_PATTERN up front
+func validateImageGC(methods []string, tagPattern string) error {
+	for _, method := range methods {
+		if method != ImageGCNode && method != ImageGCRegistry {
+			return fmt.Errorf("unknown image GC method %q (use %s or %s)", method, ImageGCNode, ImageGCRegistry)
+		}
+	}
+	if len(methods) > 0 {
+		if _, err := imageTagPattern(tagPattern, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func imageTagPattern(pattern string, prNumber int) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(strings.ReplaceAll(pattern, "{pr}", strconv.Itoa(prNumber)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image GC tag pattern: %v", err)
+	}
+	return re, nil
+}
+
+// splitImageRef splits "host/repo:tag" into its registry host, repository
+// and tag. Digest references and images without a tag return an empty tag;
+// images without a registry host are on Docker Hub.
+func splitImageRef(image string) (string, string, string) {
+	if strings.Contains(image, "@") {
+		return "", "", ""
+	}
+	host, repository := "docker.io", image
+	if first, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, repository = first, rest
+	}
+
+	slash := strings.LastIndex(repository, "/")
+	colon := strings.LastIndex(repository, ":")
+	if colon <= slash {
+		return host, repository, ""
+	}
+	return host, repository[:colon], repository[colon+1:]
+}
+
+func (cs *CommandServiceK8s) imageGCEnabled(method string) bool {
+	for _, m := range cs.config.ImageGC.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// startImageGC removes the PR-specific images read off the deleted
+// namespaces (see prImages) in the background once the namespaces are gone,
+// since runtimes refuse to remove images that are still in use. It returns a
+// note for the cleanup comment, or "" when image GC is off.
+func (cs *CommandServiceK8s) startImageGC(cmd *types.Command, namespaces, images []string, err error) string {
+	if len(cs.config.ImageGC.Methods) == 0 || len(namespaces) == 0 {
+		return ""
+	}
+	if err != nil {
+		fmt.Printf("Warning: image GC skipped for PR #%d: %v\n", cmd.PRNumber, err)
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "Image GC skipped: %v", err)
+		return fmt.Sprintf("⚠️ Image GC skipped: %s\n\n", err.Error())
+	}
+	if len(images) == 0 {
+		return ""
+	}
+
+	go cs.runImageGC(cmd, namespaces, images)
+	return fmt.Sprintf("🗑️ %d PR-specific image(s) will be removed (%s) once the namespaces are gone.\n\n",
+		len(images), strings.Join(cs.config.ImageGC.Methods, ", "))
+}
+
+// prImages returns the images of the namespaces whose tag matches the PR tag
+// pattern. It has to run before the namespaces are deleted.
+func (cs *CommandServiceK8s) prImages(ctx context.Context, prNumber int, namespaces []string) ([]string, error) {
+	if len(cs.config.ImageGC.Methods) == 0 {
+		return nil, nil
+	}
+	pattern, err := imageTagPattern(cs.config.ImageGC.TagPattern, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	all, err := cs.k8s.WorkloadImages(ctx, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, image := range all {
+		if _, _, tag := splitImageRef(image); tag != "" && pattern.MatchString(tag) {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+func (cs *CommandServiceK8s) runImageGC(cmd *types.Command, namespaces, images []string) {
+	result := &ImageGCResult{}
+	blockers, err := cs.k8s.WaitForNamespacesDeleted(context.Background(), namespaces, cs.config.ImageGC.Timeout)
+	switch {
+	case err != nil:
+		result.Failed = append(result.Failed, err.Error())
+	case len(blockers) > 0:
+		result.Failed = append(result.Failed, fmt.Sprintf("%d namespace(s) still terminating; images left in place", len(blockers)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cs.config.ImageGC.Timeout)
+	defer cancel()
+
+	// Another PR or a retried preview may share a tag; never pull an image
+	// out from under a running pod
+	if len(result.Failed) == 0 {
+		inUse, err := cs.k8s.ImagesInUse(ctx, namespaces)
+		if err != nil {
+			result.Failed = append(result.Failed, err.Error())
+		}
+		for _, image := range images {
+			if inUse[image] {
+				result.InUse = append(result.InUse, image)
+			} else {
+				result.Images = append(result.Images, image)
+			}
+		}
+	}
+
+	if len(result.Images) > 0 && cs.imageGCEnabled(ImageGCNode) {
+		nodes, err := cs.gcNodeImages(ctx, cmd.PRNumber, result.Images)
+		result.Nodes = nodes
+		if err != nil {
+			result.Failed = append(result.Failed, err.Error())
+		}
+	}
+	if len(result.Images) > 0 && cs.imageGCEnabled(ImageGCRegistry) {
+		cs.untagRegistryImages(ctx, result)
+	}
+
+	if len(result.Failed) > 0 {
+		fmt.Printf("Warning: image GC for PR #%d: %s\n", cmd.PRNumber, strings.Join(result.Failed, "; "))
+		cs.logTimeline(cmd.Repo, cmd.PRNumber, "Image GC problems: %s", strings.Join(result.Failed, "; "))
+	}
+	if cmd.Repo == "" || (len(result.Images) == 0 && len(result.Failed) == 0) {
+		return
+	}
+	if err := cs.comments.PostComment(context.Background(), cmd.Repo, cmd.PRNumber, formatImageGCResult(cmd.PRNumber, result)); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// gcNodeImages runs the GC DaemonSet until every scheduled pod reports done
+// and returns how many nodes were cleaned
+func (cs *CommandServiceK8s) gcNodeImages(ctx context.Context, prNumber int, images []string) (int32, error) {
+	namespace := cs.config.ImageGC.Namespace
+	name := fmt.Sprintf("preview-image-gc-pr-%d", prNumber)
+
+	nodeSelector := make(map[string]string)
+	for _, pair := range cs.config.ImageGC.NodeSelector {
+		if key, value, ok := strings.Cut(pair, "="); ok {
+			nodeSelector[key] = value
+		}
+	}
+
+	// A leftover from an earlier cleanup of the same PR would block the create
+	if err := cs.k8s.DeleteDaemonSet(ctx, namespace, name); err != nil {
+		return 0, err
+	}
+	if err := cs.k8s.WaitForDaemonSetDeleted(ctx, namespace, name, time.Minute); err != nil {
+		return 0, err
+	}
+	if err := cs.k8s.CreateImageGCDaemonSet(ctx, namespace, name, cs.config.ImageGC.Image, images, nodeSelector); err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := cs.k8s.DeleteDaemonSet(context.Background(), namespace, name); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}()
+
+	ticker := time.NewTicker(imageGCPollInterval)
+	defer ticker.Stop()
+
+	var ready, desired int32
+	for {
+		select {
+		case <-ctx.Done():
+			return ready, fmt.Errorf("node image GC finished on %d of %d nodes before timing out", ready, desired)
+		case <-ticker.C:
+			var err error
+			ready, desired, err = cs.k8s.DaemonSetReady(ctx, namespace, name)
+			if err != nil {
+				continue
+			}
+			if desired > 0 && ready >= desired {
+				return ready, nil
+			}
+		}
+	}
+}
+
+// untagRegistryImages deletes the PR tags of images hosted on the configured
+// registry. Tags are deleted by name (OCI distribution 1.1), never by digest,
+// since a digest may also be tagged for other PRs or releases.
+func (cs *CommandServiceK8s) untagRegistryImages(ctx context.Context, result *ImageGCResult) {
+	registry := cs.config.ImageGC.Registry
+	if registry == "" {
+		result.Failed = append(result.Failed, "registry untag needs IMAGE_GC_REGISTRY")
+		return
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	for _, image := range result.Images {
+		host, repository, tag := splitImageRef(image)
+		if host != registry {
+			continue
+		}
+
+		url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repository, tag)
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
+		if err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %v", image, err))
+			continue
+		}
+		if token := cs.config.ImageGC.RegistryToken; token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: %v", image, err))
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound:
+			result.Untagged = append(result.Untagged, image)
+		case resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusBadRequest:
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: registry does not support deleting tags (status %d)", image, resp.StatusCode))
+		default:
+			result.Failed = append(result.Failed, fmt.Sprintf("%s: untag failed with status %d", image, resp.StatusCode))
+		}
+	}
+}
+
+func formatImageGCResult(prNumber int, result *ImageGCResult) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## 🗑️ Image Cleanup\n\n**PR:** #%d\n\n", prNumber))
+
+	if len(result.Images) > 0 {
+		content.WriteString("### PR Images\n")
+		for _, image := range result.Images {
+			content.WriteString(fmt.Sprintf("- `%s`\n", image))
+		}
+		if result.Nodes > 0 {
+			content.WriteString(fmt.Sprintf("\n✅ Removed from node caches on %d node(s)\n", result.Nodes))
+		}
+		if len(result.Untagged) > 0 {
+			content.WriteString(fmt.Sprintf("\n✅ Untagged %d image(s) in the registry\n", len(result.Untagged)))
+		}
+		content.WriteString("\n")
+	}
+	if len(result.InUse) > 0 {
+		content.WriteString("### Still In Use\n")
+		for _, image := range result.InUse {
+			content.WriteString(fmt.Sprintf("- `%s`\n", image))
+		}
+		content.WriteString("\n")
+	}
+	if len(result.Failed) > 0 {
+		content.WriteString("### ⚠️ Problems\n")
+		for _, failure := range result.Failed {
+			content.WriteString(fmt.Sprintf("- %s\n", failure))
+		}
+	}
+	return strings.TrimRight(content.String(), "\n")
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"pr-previews/internal/types"
+)
+
+// ImagePolicy restricts the registries and repositories preview workloads
+// may pull from. Patterns match an image's registry and repository, without
+// its tag or digest, with Docker Hub images written out in full
+// (docker.io/library/nginx). A pattern ending in /* matches everything
+// below it at any depth, a pattern without a / matches a whole registry, and
+// anything else is matched with path.Match. Deny wins over allow, and an
+// empty allow list allows every image not denied.
+type ImagePolicy struct {
+	allow []string
+	deny  []string
+}
+
+// ImageViolation is one container whose image the policy refuses
+type ImageViolation struct {
+	Object    string `json:"object"` // Kind/name
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	Reason    string `json:"reason"`
+}
+
+func NewImagePolicy(allow, deny []string) (*ImagePolicy, error) {
+	for _, pattern := range append(append([]string(nil), allow...), deny...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/*"), ""); err != nil {
+			return nil, fmt.Errorf("invalid image pattern %q: %v", pattern, err)
+		}
+	}
+	return &ImagePolicy{allow: allow, deny: deny}, nil
+}
+
+// sharedImagePolicy is configured once at startup, before any command runs
+var sharedImagePolicy = &ImagePolicy{}
+
+// SharedImagePolicy is the process's image policy
+func SharedImagePolicy() *ImagePolicy {
+	return sharedImagePolicy
+}
+
+// Configure validates the PREVIEW_IMAGE_ALLOW and PREVIEW_IMAGE_DENY
+// patterns and applies them; on error the policy is left unchanged
+func (ip *ImagePolicy) Configure(allow, deny []string) error {
+	policy, err := NewImagePolicy(allow, deny)
+	if err != nil {
+		return err
+	}
+	ip.allow, ip.deny = policy.allow, policy.deny
+	return nil
+}
+
+// Enabled reports whether any pattern is configured
+func (ip *ImagePolicy) Enabled() bool {
+	return len(ip.allow) > 0 || len(ip.deny) > 0
+}
+
+// imageRepository is the registry and repository of an image reference,
+// e.g. "docker.io/library/nginx" for "nginx:1.27"
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	host, repository := "docker.io", image
+	if first, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		host, repository = first, rest
+	}
+	if colon := strings.LastIndex(repository, ":"); colon > strings.LastIndex(repository, "/") {
+		repository = repository[:colon]
+	}
+	if host == "docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return host + "/" + repository
+}
+
+// imagePatternMatches matches one pattern against a normalized repository
+func imagePatternMatches(pattern, repository string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		parts := strings.Count(prefix, "/") + 1
+		segments := strings.SplitN(repository, "/", parts+1)
+		if len(segments) <= parts {
+			return false
+		}
+		matched, _ := path.Match(prefix, strings.Join(segments[:parts], "/"))
+		return matched
+	}
+	if !strings.Contains(pattern, "/") {
+		registry, _, _ := strings.Cut(repository, "/")
+		matched, _ := path.Match(pattern, registry)
+		return matched
+	}
+	matched, _ := path.Match(pattern, repository)
+	return matched
+}
+
+// Allowed returns why image is refused, or "" when it may be deployed
+func (ip *ImagePolicy) Allowed(image string) string {
+	repository := imageRepository(image)
+	for _, pattern := range ip.deny {
+		if imagePatternMatches(pattern, repository) {
+			return fmt.Sprintf("matches denied pattern `%s`", pattern)
+		}
+	}
+	if len(ip.allow) == 0 {
+		return ""
+	}
+	for _, pattern := range ip.allow {
+		if imagePatternMatches(pattern, repository) {
+			return ""
+		}
+	}
+	return "not in an allowed registry or repository"
+}
+
+// Check returns every container of the manifest, injected sidecars and
+// init containers included, whose image the policy refuses. Pod templates
+// of other kinds, which virtual cluster previews deploy, are checked too.
+func (ip *ImagePolicy) Check(parsed *ParsedManifest) []ImageViolation {
+	if !ip.Enabled() {
+		return nil
+	}
+	var violations []ImageViolation
+	check := func(object, container, image string) {
+		if reason := ip.Allowed(image); reason != "" {
+			violations = append(violations, ImageViolation{Object: object, Container: container, Image: image, Reason: reason})
+		}
+	}
+	checkPodSpec := func(object string, spec *corev1.PodSpec) {
+		for _, container := range spec.InitContainers {
+			check(object, container.Name, container.Image)
+		}
+		for _, container := range spec.Containers {
+			check(object, container.Name, container.Image)
+		}
+	}
+
+	for i := range parsed.Deployments {
+		checkPodSpec("Deployment/"+parsed.Deployments[i].Name, &parsed.Deployments[i].Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		checkPodSpec("StatefulSet/"+parsed.StatefulSets[i].Name, &parsed.StatefulSets[i].Spec.Template.Spec)
+	}
+	for _, object := range parsed.OtherObjects() {
+		name := object.GetKind() + "/" + object.GetName()
+		// Pods, then DaemonSets, Jobs and ReplicaSets, then CronJobs
+		for _, podSpec := range [][]string{
+			{"spec"},
+			{"spec", "template", "spec"},
+			{"spec", "jobTemplate", "spec", "template", "spec"},
+		} {
+			for _, field := range []string{"initContainers", "containers"} {
+				containers, _, _ := unstructured.NestedSlice(object.Object, append(podSpec, field)...)
+				for _, item := range containers {
+					container, _ := item.(map[string]interface{})
+					image, _ := container["image"].(string)
+					containerName, _ := container["name"].(string)
+					if image != "" {
+						check(name, containerName, image)
+					}
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// imagePolicyResponse explains a deploy blocked for its images
+func imagePolicyResponse(serviceName, manifestPath string, violations []ImageViolation) *types.CommandResponse {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## 🚫 Preview Blocked by Image Policy\n\n**Service:** `%s`\n**Manifest File:** %s\n\nThese containers use images from registries or repositories previews may not pull from:\n\n", serviceName, manifestPath))
+	content.WriteString("| Workload | Container | Image | Reason |\n|----------|-----------|-------|--------|\n")
+	for _, violation := range violations {
+		content.WriteString(fmt.Sprintf("| %s | `%s` | `%s` | %s |\n", violation.Object, violation.Container,
+			SanitizeEcho(violation.Image), violation.Reason))
+	}
+	content.WriteString(fmt.Sprintf("\n*Use images from an allowed registry, or ask the operators to allow these, and run `/preview %s` again.*", serviceName))
+	return &types.CommandResponse{
+		Success: false,
+		Message: "Image policy violations",
+		Content: content.String(),
+		Data: map[string]interface{}{
+			"service": serviceName,
+			"images":  violations,
+		},
+	}
+}
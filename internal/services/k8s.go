@@ -2,16 +2,34 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -19,7 +37,29 @@ import (
 )
 
 type K8sService struct {
-	client kubernetes.Interface
+	client     kubernetes.Interface
+	dynamic    dynamic.Interface  // for CRDs such as VolumeSnapshot
+	restConfig *rest.Config       // endpoint and CA handed out in debug kubeconfigs
+	ipFamilies *IPFamilyConfig    // applied to every Service previews create
+	baseline   *NamespaceBaseline // applied to every namespace previews create
+	dryRun     bool               // see DryRun
+}
+
+var (
+	pulledEventPattern = regexp.MustCompile(`Successfully pulled image "([^"]+)" in ([0-9.]+[a-zµ]+)`)
+	cachedEventPattern = regexp.MustCompile(`Container image "([^"]+)" already present on machine`)
+)
+
+var volumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+var apiServiceResource = schema.GroupVersionResource{
+	Group:    "apiregistration.k8s.io",
+	Version:  "v1",
+	Resource: "apiservices",
 }
 
 func NewK8sService() (*K8sService, error) {
@@ -27,14 +67,22 @@ func NewK8sService() (*K8sService, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get K8s config: %v", err)
 	}
+	config.Wrap(SharedClusterBreaker().wrap)
 
 	client, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create K8s client: %v", err)
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8s dynamic client: %v", err)
+	}
+
 	return &K8sService{
-		client: client,
+		client:     client,
+		dynamic:    dynamicClient,
+		restConfig: config,
 	}, nil
 }
 
@@ -94,33 +142,139 @@ func (k *K8sService) GetClusterInfo(ctx context.Context) (map[string]interface{}
 }
 
 // CreateNamespace creates a preview namespace with proper labels
-func (k *K8sService) CreateNamespace(ctx context.Context, name string, prNumber int, service string) error {
-	namespace := &corev1.Namespace{
+func (k *K8sService) CreateNamespace(ctx context.Context, name string, prNumber int, service, owner string) error {
+	_, err := k.client.CoreV1().Namespaces().Create(ctx, previewNamespaceObject(name, prNumber, service, owner), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+
+	return k.applyNamespaceBaseline(ctx, name)
+}
+
+// previewNamespaceObject is the per-service preview namespace CreateNamespace
+// creates
+func previewNamespaceObject(name string, prNumber int, service, owner string) *corev1.Namespace {
+	labels, annotations := previewNamespaceMeta(prNumber, service, owner)
+	return &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-			Labels: map[string]string{
-				"preview":     "true",
-				"pr-number":   fmt.Sprintf("%d", prNumber),
-				"service":     service,
-				"created-by":  "pr-previews",
-				"environment": "preview",
-			},
-			Annotations: map[string]string{
-				"pr-previews.io/created-at": time.Now().Format(time.RFC3339),
-				"pr-previews.io/pr-number":  fmt.Sprintf("%d", prNumber),
-				"pr-previews.io/service":    service,
-			},
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
 		},
 	}
+}
+
+// previewNamespaceMeta is the labels and annotations of a per-service
+// preview namespace, whether created or claimed from the warm pool
+func previewNamespaceMeta(prNumber int, service, owner string) (map[string]string, map[string]string) {
+	labels := map[string]string{
+		"preview":     "true",
+		"pr-number":   fmt.Sprintf("%d", prNumber),
+		"service":     service,
+		"created-by":  "pr-previews",
+		"environment": "preview",
+	}
+	annotations := map[string]string{
+		"pr-previews.io/created-at": time.Now().Format(time.RFC3339),
+		"pr-previews.io/pr-number":  fmt.Sprintf("%d", prNumber),
+		"pr-previews.io/service":    service,
+		"pr-previews.io/created-by": owner,
+	}
+	return labels, annotations
+}
+
+// Shared namespaces are labelled namespace-mode=shared and list their
+// services in an annotation, since a label holds only one
+const (
+	sharedNamespaceLabel     = "namespace-mode"
+	sharedServicesAnnotation = "pr-previews.io/services"
+)
 
-	_, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+// EnsureSharedNamespace creates the PR's shared namespace on first use and
+// adds the service to it. Deploying a service that is already there fails,
+// like creating an existing per-service namespace does.
+func (k *K8sService) EnsureSharedNamespace(ctx context.Context, name string, prNumber int, service, owner string) error {
+	namespace, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		namespace = &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					"preview":            "true",
+					"pr-number":          fmt.Sprintf("%d", prNumber),
+					sharedNamespaceLabel: NamespaceShared,
+					"created-by":         "pr-previews",
+					"environment":        "preview",
+				},
+				Annotations: map[string]string{
+					"pr-previews.io/created-at": time.Now().Format(time.RFC3339),
+					"pr-previews.io/pr-number":  fmt.Sprintf("%d", prNumber),
+					sharedServicesAnnotation:    service,
+					"pr-previews.io/created-by": owner,
+				},
+			},
+		}
+		if _, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create namespace %s: %v", name, err)
+		}
+		return k.applyNamespaceBaseline(ctx, name)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+
+	if namespace.Labels[sharedNamespaceLabel] != NamespaceShared {
+		return fmt.Errorf("namespace %s exists but is not a shared preview namespace", name)
+	}
+	if namespace.Status.Phase == corev1.NamespaceTerminating {
+		return fmt.Errorf("namespace %s is still terminating from a cleanup; try again shortly", name)
+	}
+	services := sharedNamespaceServices(namespace)
+	for _, existing := range services {
+		if existing == service {
+			return fmt.Errorf("service %s is already deployed in %s; run /cleanup first", service, name)
+		}
 	}
 
+	if namespace.Annotations == nil {
+		namespace.Annotations = make(map[string]string)
+	}
+	namespace.Annotations[sharedServicesAnnotation] = strings.Join(append(services, service), ",")
+	if _, err := k.client.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to add %s to namespace %s: %v", service, name, err)
+	}
 	return nil
 }
 
+func sharedNamespaceServices(namespace *corev1.Namespace) []string {
+	var services []string
+	for _, service := range strings.Split(namespace.Annotations[sharedServicesAnnotation], ",") {
+		if service != "" {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// ResolvePreviewNamespace finds where a PR's service runs: its own namespace,
+// a warm pool namespace claimed for it, or the PR's shared namespace when that
+// lists the service. Namespaces deployed from another repo are skipped. Falls
+// back to the per-service name so callers report a familiar namespace when
+// none exists.
+func (k *K8sService) ResolvePreviewNamespace(ctx context.Context, repo string, prNumber int, cleanServiceName string) string {
+	perService := previewNamespace(prNumber, cleanServiceName, false)
+	shared, err := k.client.CoreV1().Namespaces().Get(ctx, previewNamespace(prNumber, cleanServiceName, true), metav1.GetOptions{})
+	if err != nil || shared.Labels[sharedNamespaceLabel] != NamespaceShared || !previewOfRepo(shared, repo) {
+		return k.ResolveNamespaceAlias(ctx, repo, perService)
+	}
+	for _, service := range sharedNamespaceServices(shared) {
+		if service == cleanServiceName {
+			return shared.Name
+		}
+	}
+	return k.ResolveNamespaceAlias(ctx, repo, perService)
+}
+
 // DeleteNamespace deletes a preview namespace
 func (k *K8sService) DeleteNamespace(ctx context.Context, name string) error {
 	err := k.client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
@@ -130,8 +284,68 @@ func (k *K8sService) DeleteNamespace(ctx context.Context, name string) error {
 	return nil
 }
 
-// ListPreviewNamespaces lists all preview namespaces
-func (k *K8sService) ListPreviewNamespaces(ctx context.Context) ([]map[string]interface{}, error) {
+// PreviewNamespace is a preview as its namespace records it
+type PreviewNamespace struct {
+	Name      string    `json:"name"`
+	PRNumber  int       `json:"pr_number"`
+	Service   string    `json:"service"` // ListPreviewNamespaces joins a shared namespace's services with commas
+	Shared    bool      `json:"shared"`
+	Owner     string    `json:"owner,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Readiness string    `json:"readiness,omitempty"` // readiness URL check state
+	TTL       string    `json:"ttl,omitempty"`
+	ExpiresAt string    `json:"expires_at,omitempty"` // as annotated; see previewExpiry for the effective one
+	Alias     string    `json:"alias,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`           // namespace phase
+	Asleep    bool      `json:"asleep,omitempty"` // scaled to zero until its next visit
+}
+
+// URL is where the preview is served, or "" when it isn't exposed
+func (p PreviewNamespace) URL() string {
+	if p.Host == "" {
+		return ""
+	}
+	url := "https://" + p.Host
+	if p.Path != "" && p.Path != "/" {
+		url += p.Path
+	}
+	return url
+}
+
+// Terminating reports whether the preview's namespace is being deleted
+func (p PreviewNamespace) Terminating() bool {
+	return p.Status == string(corev1.NamespaceTerminating)
+}
+
+// previewNamespaceOf reads a preview namespace's labels and annotations;
+// service picks one service of a shared namespace
+func previewNamespaceOf(ns *corev1.Namespace, service string) PreviewNamespace {
+	shared := ns.Labels[sharedNamespaceLabel] == NamespaceShared
+	prNumber, _ := strconv.Atoi(ns.Labels["pr-number"])
+	return PreviewNamespace{
+		Name:      ns.Name,
+		PRNumber:  prNumber,
+		Service:   service,
+		Shared:    shared,
+		Owner:     ns.Annotations["pr-previews.io/created-by"],
+		Repo:      ns.Annotations[previewRepoAnnotation],
+		Host:      ns.Annotations[serviceAnnotation("pr-previews.io/host", service, shared)],
+		Path:      ns.Annotations[serviceAnnotation("pr-previews.io/path", service, shared)],
+		Readiness: ns.Annotations[serviceAnnotation(readinessStateAnnotation, service, shared)],
+		TTL:       ns.Annotations[ttlAnnotation],
+		ExpiresAt: ns.Annotations[expiresAtAnnotation],
+		Alias:     ns.Labels[previewAliasLabel],
+		CreatedAt: namespaceCreatedAt(ns),
+		Status:    string(ns.Status.Phase),
+		Asleep:    ns.Annotations[sleepAnnotation] != "",
+	}
+}
+
+// ListPreviewNamespaces lists all preview namespaces, a shared one once
+func (k *K8sService) ListPreviewNamespaces(ctx context.Context) ([]PreviewNamespace, error) {
 	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
 		LabelSelector: "preview=true",
 	})
@@ -139,23 +353,40 @@ func (k *K8sService) ListPreviewNamespaces(ctx context.Context) ([]map[string]in
 		return nil, fmt.Errorf("failed to list preview namespaces: %v", err)
 	}
 
-	var result []map[string]interface{}
+	var result []PreviewNamespace
 	for _, ns := range namespaces.Items {
-		info := map[string]interface{}{
-			"name":       ns.Name,
-			"pr_number":  ns.Labels["pr-number"],
-			"service":    ns.Labels["service"],
-			"created_at": ns.CreationTimestamp.Format(time.RFC3339),
-			"status":     string(ns.Status.Phase),
+		service := ns.Labels["service"]
+		if ns.Labels[sharedNamespaceLabel] == NamespaceShared {
+			service = strings.Join(sharedNamespaceServices(&ns), ",")
 		}
-		result = append(result, info)
+		result = append(result, previewNamespaceOf(&ns, service))
 	}
 
 	return result, nil
 }
 
-// GetPreviewNamespacesByPR gets preview namespaces for specific PR
-func (k *K8sService) GetPreviewNamespacesByPR(ctx context.Context, prNumber int) ([]map[string]interface{}, error) {
+// previewRepoAnnotation records the repository a preview was deployed from
+const previewRepoAnnotation = "pr-previews.io/repo"
+
+// namespaceCreatedAt is when the preview started: the created-at annotation,
+// which a warm pool claim resets, or else the namespace's creation
+func namespaceCreatedAt(ns *corev1.Namespace) time.Time {
+	if createdAt, err := time.Parse(time.RFC3339, ns.Annotations["pr-previews.io/created-at"]); err == nil {
+		return createdAt.UTC()
+	}
+	return ns.CreationTimestamp.UTC()
+}
+
+// previewOfRepo reports whether the preview namespace was deployed from
+// repo; an empty repo matches every namespace. PR numbers repeat across
+// repositories, so anything acting on a PR's previews checks this.
+func previewOfRepo(ns *corev1.Namespace, repo string) bool {
+	return repo == "" || strings.EqualFold(ns.Annotations[previewRepoAnnotation], repo)
+}
+
+// GetPreviewNamespacesByPR gets the preview namespaces of a PR of repo, or
+// of every repository when repo is empty
+func (k *K8sService) GetPreviewNamespacesByPR(ctx context.Context, repo string, prNumber int) ([]PreviewNamespace, error) {
 	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("preview=true,pr-number=%d", prNumber),
 	})
@@ -163,42 +394,265 @@ func (k *K8sService) GetPreviewNamespacesByPR(ctx context.Context, prNumber int)
 		return nil, fmt.Errorf("failed to list PR %d preview namespaces: %v", prNumber, err)
 	}
 
-	var result []map[string]interface{}
+	// A shared namespace is listed once per service, so callers see the
+	// same shape in both layouts
+	var result []PreviewNamespace
 	for _, ns := range namespaces.Items {
-		info := map[string]interface{}{
-			"name":       ns.Name,
-			"service":    ns.Labels["service"],
-			"created_at": ns.CreationTimestamp.Format(time.RFC3339),
-			"status":     string(ns.Status.Phase),
+		if !previewOfRepo(&ns, repo) {
+			continue
+		}
+		services := []string{ns.Labels["service"]}
+		if ns.Labels[sharedNamespaceLabel] == NamespaceShared {
+			services = sharedNamespaceServices(&ns)
+		}
+		for _, service := range services {
+			result = append(result, previewNamespaceOf(&ns, service))
 		}
-		result = append(result, info)
 	}
 
 	return result, nil
 }
 
-// CleanupPreviewNamespaces deletes all preview namespaces for a PR
-func (k *K8sService) CleanupPreviewNamespaces(ctx context.Context, prNumber int) error {
+// CleanupPreviewNamespaces deletes all preview namespaces of a PR of repo
+// concurrently and returns their names. Deletion continues in the
+// background; see WaitForNamespacesDeleted.
+func (k *K8sService) CleanupPreviewNamespaces(ctx context.Context, repo string, prNumber int) ([]string, error) {
 	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
 		LabelSelector: fmt.Sprintf("preview=true,pr-number=%d", prNumber),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list PR %d namespaces for cleanup: %v", prNumber, err)
+		return nil, fmt.Errorf("failed to list PR %d namespaces for cleanup: %v", prNumber, err)
 	}
 
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		deleted  []string
+		failures []string
+	)
 	for _, ns := range namespaces.Items {
-		err := k.client.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{})
+		if !previewOfRepo(&ns, repo) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			err := k.client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && !apierrors.IsNotFound(err) {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				return
+			}
+			deleted = append(deleted, name)
+		}(ns.Name)
+	}
+	wg.Wait()
+
+	sort.Strings(deleted)
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return deleted, fmt.Errorf("failed to delete namespaces: %s", strings.Join(failures, "; "))
+	}
+	return deleted, nil
+}
+
+// NamespaceBlocker explains why a namespace is still Terminating
+type NamespaceBlocker struct {
+	Namespace        string    `json:"namespace"`
+	TerminatingSince time.Time `json:"terminating_since"`
+	Finalizers       []string  `json:"finalizers"`             // finalizers left on the namespace or its contents
+	Conditions       []string  `json:"conditions"`             // deletion conditions reported by the namespace controller
+	APIServices      []string  `json:"api_services,omitempty"` // unavailable aggregated APIs that stall discovery
+}
+
+// WaitForNamespacesDeleted polls until every namespace is gone or timeout
+// passes, returning what blocks the ones that remain
+func (k *K8sService) WaitForNamespacesDeleted(ctx context.Context, names []string, timeout time.Duration) ([]NamespaceBlocker, error) {
+	remaining := names
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		var still []string
+		for _, name := range remaining {
+			_, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return false, err
+			}
+			still = append(still, name)
+		}
+		remaining = still
+		return len(remaining) == 0, nil
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !wait.Interrupted(err) {
+		return nil, err
+	}
+
+	var blockers []NamespaceBlocker
+	for _, name := range remaining {
+		blocker, err := k.GetNamespaceBlocker(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if blocker != nil {
+			blockers = append(blockers, *blocker)
+		}
+	}
+	return blockers, nil
+}
+
+// GetNamespaceBlocker reads the finalizers and deletion conditions holding a
+// namespace in Terminating, or nil when it is already gone
+func (k *K8sService) GetNamespaceBlocker(ctx context.Context, name string) (*NamespaceBlocker, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	return namespaceBlocker(ns), nil
+}
+
+// ListStuckPreviewNamespaces returns preview namespaces that have been
+// Terminating for longer than threshold. When discovery is what blocks them,
+// the unavailable APIServices are attached.
+func (k *K8sService) ListStuckPreviewNamespaces(ctx context.Context, threshold time.Duration) ([]NamespaceBlocker, error) {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: "preview=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list preview namespaces: %v", err)
+	}
+
+	var stuck []NamespaceBlocker
+	discoveryFailed := false
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if ns.DeletionTimestamp == nil || time.Since(ns.DeletionTimestamp.Time) < threshold {
+			continue
+		}
+		stuck = append(stuck, *namespaceBlocker(ns))
+		for _, condition := range ns.Status.Conditions {
+			if condition.Type == corev1.NamespaceDeletionDiscoveryFailure && condition.Status == corev1.ConditionTrue {
+				discoveryFailed = true
+			}
+		}
+	}
+
+	if discoveryFailed {
+		apiServices, err := k.UnavailableAPIServices(ctx)
+		if err != nil {
+			return stuck, err
+		}
+		for i := range stuck {
+			stuck[i].APIServices = apiServices
+		}
+	}
+
+	sort.Slice(stuck, func(i, j int) bool {
+		return stuck[i].TerminatingSince.Before(stuck[j].TerminatingSince)
+	})
+	return stuck, nil
+}
+
+// UnavailableAPIServices lists aggregated APIs whose Available condition
+// isn't True; namespace deletion can't enumerate their resources
+func (k *K8sService) UnavailableAPIServices(ctx context.Context) ([]string, error) {
+	list, err := k.dynamic.Resource(apiServiceResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API services: %v", err)
+	}
+
+	var unavailable []string
+	for _, item := range list.Items {
+		conditions, _, _ := unstructured.NestedSlice(item.Object, "status", "conditions")
+		for _, raw := range conditions {
+			condition, ok := raw.(map[string]interface{})
+			if !ok || condition["type"] != "Available" || condition["status"] == "True" {
+				continue
+			}
+			unavailable = append(unavailable, fmt.Sprintf("%s: %v", item.GetName(), condition["message"]))
+		}
+	}
+
+	sort.Strings(unavailable)
+	return unavailable, nil
+}
+
+// ForceFinalizeNamespace clears the finalizers of a Terminating preview
+// namespace so the API server can drop it. Objects still inside are
+// orphaned in etcd, so this is a last resort.
+func (k *K8sService) ForceFinalizeNamespace(ctx context.Context, name string) error {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	if ns.Labels["preview"] != "true" {
+		return fmt.Errorf("namespace %s is not a preview namespace", name)
+	}
+	if ns.DeletionTimestamp == nil {
+		return fmt.Errorf("namespace %s is not being deleted; run /cleanup first", name)
+	}
+
+	if len(ns.Finalizers) > 0 {
+		ns.Finalizers = nil
+		ns, err = k.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
 		if err != nil {
-			return fmt.Errorf("failed to delete namespace %s: %v", ns.Name, err)
+			return fmt.Errorf("failed to remove finalizers from namespace %s: %v", name, err)
+		}
+	}
+
+	ns.Spec.Finalizers = nil
+	if _, err := k.client.CoreV1().Namespaces().Finalize(ctx, ns, metav1.UpdateOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to finalize namespace %s: %v", name, err)
+	}
+
+	return nil
+}
+
+func namespaceBlocker(ns *corev1.Namespace) *NamespaceBlocker {
+	blocker := &NamespaceBlocker{Namespace: ns.Name}
+	if ns.DeletionTimestamp != nil {
+		blocker.TerminatingSince = ns.DeletionTimestamp.Time
+	}
+
+	for _, finalizer := range ns.Spec.Finalizers {
+		blocker.Finalizers = append(blocker.Finalizers, string(finalizer))
+	}
+	blocker.Finalizers = append(blocker.Finalizers, ns.Finalizers...)
+
+	for _, condition := range ns.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
 		}
+		// FinalizersRemaining lists the finalizers on the namespace's contents
+		// and DiscoveryFailed names unavailable API services
+		blocker.Conditions = append(blocker.Conditions, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+	}
+
+	return blocker
+}
+
+// DeployTestPod deploys a simple nginx pod for testing, sized by class when
+// one is given
+func (k *K8sService) DeployTestPod(ctx context.Context, namespace, serviceName string, class *ServiceClass) error {
+	deployment := testPodDeployment(namespace, serviceName, class)
+	_, err := k.client.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %v", err)
 	}
 
 	return nil
 }
 
-// DeployTestPod deploys a simple nginx pod for testing
-func (k *K8sService) DeployTestPod(ctx context.Context, namespace, serviceName string) error {
-	// Create deployment
+// testPodDeployment is the nginx Deployment DeployTestPod creates
+func testPodDeployment(namespace, serviceName string, class *ServiceClass) *appsv1.Deployment {
 	deployment := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      serviceName,
@@ -220,14 +674,16 @@ func (k *K8sService) DeployTestPod(ctx context.Context, namespace, serviceName s
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: map[string]string{
 						"app":                serviceName,
+						"preview":            "true",
 						"preview-deployment": "true",
 					},
 				},
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
-							Name:  serviceName,
-							Image: "nginx:alpine",
+							Name:    serviceName,
+							Image:   "nginx:alpine",
+							EnvFrom: []corev1.EnvFromSource{PREnvSource()},
 							Ports: []corev1.ContainerPort{
 								{
 									ContainerPort: 80,
@@ -271,12 +727,11 @@ func (k *K8sService) DeployTestPod(ctx context.Context, namespace, serviceName s
 		},
 	}
 
-	_, err := k.client.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to create deployment: %v", err)
+	if class != nil {
+		deployment.Spec.Replicas = int32Ptr(class.Replicas)
+		deployment.Spec.Template.Spec.Containers[0].Resources = class.Resources()
 	}
-
-	return nil
+	return deployment
 }
 
 // CreateService creates a Kubernetes service for the deployment
@@ -305,6 +760,7 @@ func (k *K8sService) CreateService(ctx context.Context, namespace, serviceName s
 			Type: corev1.ServiceTypeClusterIP,
 		},
 	}
+	k.ipFamilies.apply(&service.Spec)
 
 	_, err := k.client.CoreV1().Services(namespace).Create(ctx, service, metav1.CreateOptions{})
 	if err != nil {
@@ -332,8 +788,21 @@ func (k *K8sService) WaitForDeployment(ctx context.Context, namespace, deploymen
 	})
 }
 
+// DeploymentStatus is a Deployment's replica counts and the pods its app
+// label selects
+type DeploymentStatus struct {
+	Name              string                       `json:"name"`
+	Namespace         string                       `json:"namespace"`
+	Replicas          int32                        `json:"replicas"`
+	ReadyReplicas     int32                        `json:"ready_replicas"`
+	AvailableReplicas int32                        `json:"available_replicas"`
+	Conditions        []appsv1.DeploymentCondition `json:"conditions"`
+	Pods              []PodStatus                  `json:"pods"`
+	CreatedAt         time.Time                    `json:"created_at"`
+}
+
 // GetDeploymentStatus gets current status of deployment
-func (k *K8sService) GetDeploymentStatus(ctx context.Context, namespace, deploymentName string) (map[string]interface{}, error) {
+func (k *K8sService) GetDeploymentStatus(ctx context.Context, namespace, deploymentName string) (*DeploymentStatus, error) {
 	deployment, err := k.client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get deployment: %v", err)
@@ -347,120 +816,2125 @@ func (k *K8sService) GetDeploymentStatus(ctx context.Context, namespace, deploym
 		return nil, fmt.Errorf("failed to get pods: %v", err)
 	}
 
-	var podStatuses []map[string]interface{}
+	status := &DeploymentStatus{
+		Name:              deployment.Name,
+		Namespace:         deployment.Namespace,
+		Replicas:          deployment.Status.Replicas,
+		ReadyReplicas:     deployment.Status.ReadyReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+		Conditions:        deployment.Status.Conditions,
+		Pods:              []PodStatus{},
+		CreatedAt:         deployment.CreationTimestamp.UTC(),
+	}
 	for _, pod := range pods.Items {
-		podStatus := map[string]interface{}{
-			"name":   pod.Name,
-			"status": string(pod.Status.Phase),
-			"ready":  false,
-		}
+		status.Pods = append(status.Pods, podStatus(&pod))
+	}
 
-		// Check if pod is ready
-		for _, condition := range pod.Status.Conditions {
-			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
-				podStatus["ready"] = true
-				break
-			}
+	return status, nil
+}
+
+// WaitForStatefulSet waits until a StatefulSet has finished rolling out
+func (k *K8sService) WaitForStatefulSet(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	return wait.PollUntilContextTimeout(ctx, 10*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		sts, err := k.client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
 		}
+		done, _ := statefulSetRolledOut(sts)
+		return done, nil
+	})
+}
 
-		podStatuses = append(podStatuses, podStatus)
+// statefulSetRolledOut mirrors `kubectl rollout status` for StatefulSets:
+// ordinals must be ready, and with a partition only ordinals at or above it
+// have to be on the update revision
+func statefulSetRolledOut(sts *appsv1.StatefulSet) (bool, string) {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return false, "waiting for the controller to observe the update"
 	}
 
-	status := map[string]interface{}{
-		"name":               deployment.Name,
-		"namespace":          deployment.Namespace,
-		"replicas":           deployment.Status.Replicas,
-		"ready_replicas":     deployment.Status.ReadyReplicas,
-		"available_replicas": deployment.Status.AvailableReplicas,
-		"conditions":         deployment.Status.Conditions,
-		"pods":               podStatuses,
-		"created_at":         deployment.CreationTimestamp.Format(time.RFC3339),
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	if sts.Status.ReadyReplicas < replicas {
+		return false, fmt.Sprintf("%d/%d pods ready", sts.Status.ReadyReplicas, replicas)
 	}
 
-	return status, nil
-}
+	if sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return true, "OnDelete strategy; pods update when deleted"
+	}
 
-// GetServiceInfo gets service information
-func (k *K8sService) GetServiceInfo(ctx context.Context, namespace, serviceName string) (map[string]interface{}, error) {
-	service, err := k.client.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to get service: %v", err)
+	if rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil && *rollingUpdate.Partition > 0 {
+		partition := *rollingUpdate.Partition
+		if expected := replicas - partition; sts.Status.UpdatedReplicas < expected {
+			return false, fmt.Sprintf("%d/%d pods updated (partition %d)", sts.Status.UpdatedReplicas, expected, partition)
+		}
+		return true, fmt.Sprintf("partitioned rollout complete for ordinals >= %d", partition)
 	}
 
-	info := map[string]interface{}{
-		"name":       service.Name,
-		"namespace":  service.Namespace,
-		"cluster_ip": service.Spec.ClusterIP,
-		"ports":      service.Spec.Ports,
-		"type":       string(service.Spec.Type),
-		"created_at": service.CreationTimestamp.Format(time.RFC3339),
+	if sts.Status.UpdateRevision != sts.Status.CurrentRevision {
+		return false, fmt.Sprintf("%d/%d pods updated", sts.Status.UpdatedReplicas, replicas)
 	}
 
-	return info, nil
+	return true, "rolled out"
 }
 
-// Helper function for int32 pointer
-func int32Ptr(i int32) *int32 { return &i }
-
-func (k *K8sService) DeployFromParsedManifest(ctx context.Context, namespace string, parsed *ParsedManifest) error {
-	// Deploy ConfigMaps first (they might be needed by deployments)
-	for _, configMap := range parsed.ConfigMaps {
-		err := k.deployConfigMap(ctx, namespace, &configMap)
-		if err != nil {
-			return fmt.Errorf("failed to deploy configmap %s: %v", configMap.Name, err)
-		}
+// GetStatefulSetStatuses reports every StatefulSet in the namespace with the
+// state of each ordinal, in order
+func (k *K8sService) GetStatefulSetStatuses(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
 	}
 
-	// Deploy Deployments
-	for _, deployment := range parsed.Deployments {
-		err := k.deployManifestDeployment(ctx, namespace, &deployment)
-		if err != nil {
-			return fmt.Errorf("failed to deploy deployment %s: %v", deployment.Name, err)
+	var result []map[string]interface{}
+	for _, sts := range statefulSets.Items {
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
 		}
-	}
 
-	// Deploy Services
-	for _, service := range parsed.Services {
-		err := k.deployManifestService(ctx, namespace, &service)
-		if err != nil {
-			return fmt.Errorf("failed to deploy service %s: %v", service.Name, err)
+		var ordinals []map[string]interface{}
+		for ordinal := int32(0); ordinal < replicas; ordinal++ {
+			podName := fmt.Sprintf("%s-%d", sts.Name, ordinal)
+			podStatus := map[string]interface{}{
+				"ordinal": ordinal,
+				"name":    podName,
+				"status":  "Missing",
+				"ready":   false,
+				"updated": false,
+			}
+
+			pod, err := k.client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+			if err == nil {
+				revision := pod.Labels[appsv1.ControllerRevisionHashLabelKey]
+				podStatus["status"] = string(pod.Status.Phase)
+				podStatus["revision"] = revision
+				podStatus["updated"] = revision == sts.Status.UpdateRevision
+				for _, condition := range pod.Status.Conditions {
+					if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+						podStatus["ready"] = true
+						break
+					}
+				}
+			} else if !apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("failed to get pod %s: %v", podName, err)
+			}
+
+			ordinals = append(ordinals, podStatus)
 		}
+
+		rolledOut, progress := statefulSetRolledOut(&sts)
+		result = append(result, map[string]interface{}{
+			"name":             sts.Name,
+			"replicas":         replicas,
+			"ready_replicas":   sts.Status.ReadyReplicas,
+			"updated_replicas": sts.Status.UpdatedReplicas,
+			"service_name":     sts.Spec.ServiceName,
+			"rolled_out":       rolledOut,
+			"progress":         progress,
+			"pods":             ordinals,
+		})
 	}
 
-	return nil
+	return result, nil
 }
 
-func (k *K8sService) deployManifestDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) error {
-	// Clone deployment to avoid modifying original
-	dep := deployment.DeepCopy()
-
-	// Override namespace
-	dep.Namespace = namespace
+// CreatePrePullDaemonSet runs one container per image on every matching node
+// so the kubelet pulls them ahead of the real workload. The containers never
+// run the image's own code: an init container copies the static busybox of
+// pauseImage into a shared volume as "sleep", which every container then
+// runs, so images without a shell or coreutils idle too. Images where even
+// that can't start still count as pulled (see PrePullProgress).
+func (k *K8sService) CreatePrePullDaemonSet(ctx context.Context, namespace, name, pauseImage string, images []string, pullSecrets []corev1.LocalObjectReference, nodeSelector map[string]string) error {
+	labels := map[string]string{
+		"app":        name,
+		"preview":    "true",
+		"managed-by": "pr-previews",
+	}
+	mount := []corev1.VolumeMount{{Name: "pause", MountPath: "/prepull"}}
+	requests := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1m"),
+		corev1.ResourceMemory: resource.MustParse("8Mi"),
+	}
 
-	// Add preview labels
-	if dep.Labels == nil {
-		dep.Labels = make(map[string]string)
+	var containers []corev1.Container
+	for i, image := range images {
+		containers = append(containers, corev1.Container{
+			Name:            fmt.Sprintf("pull-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         []string{"/prepull/sleep", "2147483647"},
+			VolumeMounts:    mount,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1m"),
+					corev1.ResourceMemory: resource.MustParse("8Mi"),
+				},
+			},
+		})
 	}
-	dep.Labels["preview"] = "true"
-	dep.Labels["managed-by"] = "pr-previews"
 
-	// Add labels to pod template
-	if dep.Spec.Template.Labels == nil {
-		dep.Spec.Template.Labels = make(map[string]string)
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector:     nodeSelector,
+					ImagePullSecrets: pullSecrets,
+					InitContainers: []corev1.Container{{
+						Name:         "pause",
+						Image:        pauseImage,
+						Command:      []string{"cp", "/bin/busybox", "/prepull/sleep"},
+						VolumeMounts: mount,
+						Resources:    corev1.ResourceRequirements{Requests: requests},
+					}},
+					Containers: containers,
+					Volumes: []corev1.Volume{{
+						Name:         "pause",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+					TerminationGracePeriodSeconds: int64Ptr(0),
+				},
+			},
+		},
 	}
-	dep.Spec.Template.Labels["preview"] = "true"
 
-	_, err := k.client.AppsV1().Deployments(namespace).Create(ctx, dep, metav1.CreateOptions{})
+	_, err := k.client.AppsV1().DaemonSets(namespace).Create(ctx, daemonSet, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("a pre-pull is already running in %s", namespace)
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create pre-pull daemonset: %v", err)
 	}
-
 	return nil
 }
 
-func (k *K8sService) deployManifestService(ctx context.Context, namespace string, service *corev1.Service) error {
-	// Clone service to avoid modifying original
-	svc := service.DeepCopy()
+// PrePullProgress reports whether every scheduled pre-pull pod has pulled
+// all of its images, plus any images that failed to pull
+func (k *K8sService) PrePullProgress(ctx context.Context, namespace, name string) (bool, []string, error) {
+	daemonSet, err := k.client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get pre-pull daemonset: %v", err)
+	}
+
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s", name),
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get pre-pull pods: %v", err)
+	}
+
+	done := daemonSet.Status.DesiredNumberScheduled > 0 && int32(len(pods.Items)) >= daemonSet.Status.DesiredNumberScheduled
+	var failed []string
+	for _, pod := range pods.Items {
+		if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+			done = false
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Waiting != nil {
+				switch status.State.Waiting.Reason {
+				case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+					failed = append(failed, fmt.Sprintf("%s on %s: %s", status.Image, pod.Spec.NodeName, status.State.Waiting.Reason))
+					continue
+				case "CreateContainerError", "RunContainerError", "CrashLoopBackOff":
+					// The runtime only gets this far with the image on the node
+					continue
+				}
+			}
+			if status.State.Terminated != nil || status.LastTerminationState.Terminated != nil {
+				continue
+			}
+			// ImageID is only set once the image is on the node
+			if status.ImageID == "" {
+				done = false
+			}
+		}
+	}
+
+	return done, failed, nil
+}
+
+// GetImagePullDurations reads kubelet "Pulled" events for pods whose name starts
+// with podPrefix, returning how long each image took per node ("cached" when the
+// image was already present)
+func (k *K8sService) GetImagePullDurations(ctx context.Context, namespace, podPrefix string) (map[string][]string, error) {
+	events, err := k.client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "reason=Pulled",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events in %s: %v", namespace, err)
+	}
+
+	durations := make(map[string][]string)
+	for _, event := range events.Items {
+		if !strings.HasPrefix(event.InvolvedObject.Name, podPrefix) {
+			continue
+		}
+		if matches := pulledEventPattern.FindStringSubmatch(event.Message); matches != nil {
+			durations[matches[1]] = append(durations[matches[1]], matches[2])
+		} else if matches := cachedEventPattern.FindStringSubmatch(event.Message); matches != nil {
+			durations[matches[1]] = append(durations[matches[1]], "cached")
+		}
+	}
+
+	return durations, nil
+}
+
+// WaitForDaemonSetDeleted waits until a DaemonSet and its pods are gone, so
+// one with the same name can be created without the old pods being counted
+func (k *K8sService) WaitForDaemonSetDeleted(ctx context.Context, namespace, name string, timeout time.Duration) error {
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := k.client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			return false, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("app=%s", name),
+		})
+		if err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("daemonset %s still being deleted: %v", name, err)
+	}
+	return nil
+}
+
+// DeleteDaemonSet removes a DaemonSet and its pods
+func (k *K8sService) DeleteDaemonSet(ctx context.Context, namespace, name string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := k.client.AppsV1().DaemonSets(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete daemonset %s: %v", name, err)
+	}
+	return nil
+}
+
+// WorkloadImages returns the unique images of the Deployments, StatefulSets
+// and Pods in the given namespaces
+func (k *K8sService) WorkloadImages(ctx context.Context, namespaces []string) ([]string, error) {
+	seen := make(map[string]bool)
+	collect := func(spec corev1.PodSpec) {
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			if container.Image != "" {
+				seen[container.Image] = true
+			}
+		}
+	}
+
+	for _, namespace := range namespaces {
+		deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+		}
+		for _, dep := range deployments.Items {
+			collect(dep.Spec.Template.Spec)
+		}
+		statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+		}
+		for _, sts := range statefulSets.Items {
+			collect(sts.Spec.Template.Spec)
+		}
+		pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+		}
+		for _, pod := range pods.Items {
+			collect(pod.Spec)
+		}
+	}
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images, nil
+}
+
+// ImagesInUse returns the images of every pod in the cluster outside the
+// excluded namespaces
+func (k *K8sService) ImagesInUse(ctx context.Context, exclude []string) (map[string]bool, error) {
+	pods, err := k.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	excluded := make(map[string]bool)
+	for _, namespace := range exclude {
+		excluded[namespace] = true
+	}
+	inUse := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if excluded[pod.Namespace] {
+			continue
+		}
+		for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+			inUse[container.Image] = true
+		}
+	}
+	return inUse, nil
+}
+
+// CreateImageGCDaemonSet removes images from every matching node's container
+// runtime. Each pod chroots into the host to run its crictl, marks itself
+// ready once done and then idles until the DaemonSet is deleted. Images are
+// passed as arguments so they never pass through shell parsing.
+func (k *K8sService) CreateImageGCDaemonSet(ctx context.Context, namespace, name, gcImage string, images []string, nodeSelector map[string]string) error {
+	labels := map[string]string{
+		"app":        name,
+		"managed-by": "pr-previews",
+	}
+	script := `for image in "$@"; do chroot /host crictl rmi "$image" || echo "skipped $image"; done; touch /tmp/done; while :; do sleep 3600; done`
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector: nodeSelector,
+					Tolerations:  []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					Containers: []corev1.Container{{
+						Name:    "gc",
+						Image:   gcImage,
+						Command: append([]string{"sh", "-c", script, "gc"}, images...),
+						SecurityContext: &corev1.SecurityContext{
+							Privileged: boolPtr(true),
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "host", MountPath: "/host"}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								Exec: &corev1.ExecAction{Command: []string{"cat", "/tmp/done"}},
+							},
+							PeriodSeconds: 2,
+						},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("10m"),
+								corev1.ResourceMemory: resource.MustParse("16Mi"),
+							},
+						},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "host",
+						VolumeSource: corev1.VolumeSource{
+							HostPath: &corev1.HostPathVolumeSource{Path: "/"},
+						},
+					}},
+					TerminationGracePeriodSeconds: int64Ptr(0),
+				},
+			},
+		},
+	}
+
+	_, err := k.client.AppsV1().DaemonSets(namespace).Create(ctx, daemonSet, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create image gc daemonset: %v", err)
+	}
+	return nil
+}
+
+// DaemonSetReady reports how many of a DaemonSet's scheduled pods are ready
+func (k *K8sService) DaemonSetReady(ctx context.Context, namespace, name string) (int32, int32, error) {
+	daemonSet, err := k.client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get daemonset %s: %v", name, err)
+	}
+	return daemonSet.Status.NumberReady, daemonSet.Status.DesiredNumberScheduled, nil
+}
+
+// RolloutProgress is how far a preview's pods are from all being ready
+type RolloutProgress struct {
+	Ready   int
+	Total   int
+	Waiting string // what the slowest pods are waiting on, empty when done
+}
+
+// Percent is the share of ready pods, 0 while no pods exist
+func (p RolloutProgress) Percent() int {
+	if p.Total == 0 {
+		return 0
+	}
+	return p.Ready * 100 / p.Total
+}
+
+// Complete reports whether every pod is ready
+func (p RolloutProgress) Complete() bool {
+	return p.Total > 0 && p.Ready == p.Total
+}
+
+func (p RolloutProgress) String() string {
+	if p.Total == 0 {
+		return "waiting on pods"
+	}
+	progress := fmt.Sprintf("%d%% (%d/%d pods ready", p.Percent(), p.Ready, p.Total)
+	if p.Waiting != "" {
+		progress += ", waiting on " + p.Waiting
+	}
+	return progress + ")"
+}
+
+// GetRolloutProgress counts ready pods, ignoring finished Job pods, and names
+// the most common thing the others are waiting on
+func (k *K8sService) GetRolloutProgress(ctx context.Context, namespace string) (*RolloutProgress, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+	}
+
+	progress := &RolloutProgress{}
+	waiting := map[string]int{}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		progress.Total++
+		if reason := podWaitReason(&pod); reason != "" {
+			waiting[reason]++
+		} else {
+			progress.Ready++
+		}
+	}
+
+	most := 0
+	for reason, count := range waiting {
+		if count > most || (count == most && reason < progress.Waiting) {
+			progress.Waiting, most = reason, count
+		}
+	}
+	return progress, nil
+}
+
+// podWaitReason describes why a pod isn't ready yet, or "" when it is
+func podWaitReason(pod *corev1.Pod) string {
+	conditions := map[corev1.PodConditionType]corev1.ConditionStatus{}
+	for _, condition := range pod.Status.Conditions {
+		conditions[condition.Type] = condition.Status
+	}
+	if conditions[corev1.PodReady] == corev1.ConditionTrue {
+		return ""
+	}
+	if conditions[corev1.PodScheduled] != corev1.ConditionTrue {
+		return "scheduling"
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting == nil {
+			continue
+		}
+		switch status.State.Waiting.Reason {
+		case "ImagePullBackOff", "ErrImagePull":
+			return "image pull (retrying)"
+		case "CrashLoopBackOff":
+			return "a crashing container"
+		case "ContainerCreating", "PodInitializing":
+			if conditions[corev1.PodInitialized] != corev1.ConditionTrue && len(pod.Spec.InitContainers) > 0 {
+				return "init containers"
+			}
+			return "image pull"
+		}
+	}
+	if conditions[corev1.PodInitialized] != corev1.ConditionTrue {
+		return "init containers"
+	}
+	return "readiness probe"
+}
+
+// GetImagePullErrors lists containers in the namespace stuck pulling their image
+func (k *K8sService) GetImagePullErrors(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods: %v", err)
+	}
+
+	var result []map[string]interface{}
+	for _, pod := range pods.Items {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting == nil {
+				continue
+			}
+
+			switch status.State.Waiting.Reason {
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				result = append(result, map[string]interface{}{
+					"pod":       pod.Name,
+					"container": status.Name,
+					"image":     status.Image,
+					"reason":    status.State.Waiting.Reason,
+					"message":   status.State.Waiting.Message,
+				})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// ListPods summarizes the pods of a namespace
+func (k *K8sService) ListPods(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+	}
+
+	var result []map[string]interface{}
+	for _, pod := range pods.Items {
+		ready := false
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready = true
+			}
+		}
+		var restarts int32
+		for _, status := range pod.Status.ContainerStatuses {
+			restarts += status.RestartCount
+		}
+		var images []string
+		for _, container := range pod.Spec.Containers {
+			images = append(images, container.Image)
+		}
+
+		result = append(result, map[string]interface{}{
+			"name":       pod.Name,
+			"phase":      string(pod.Status.Phase),
+			"ready":      ready,
+			"restarts":   restarts,
+			"node":       pod.Spec.NodeName,
+			"images":     images,
+			"created_at": pod.CreationTimestamp.Format(time.RFC3339),
+		})
+	}
+	return result, nil
+}
+
+// ListEvents returns up to limit events of a namespace, newest first
+func (k *K8sService) ListEvents(ctx context.Context, namespace string, limit int) ([]map[string]interface{}, error) {
+	events, err := k.client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events in %s: %v", namespace, err)
+	}
+
+	lastSeen := func(event corev1.Event) time.Time {
+		switch {
+		case !event.LastTimestamp.IsZero():
+			return event.LastTimestamp.Time
+		case !event.EventTime.IsZero():
+			return event.EventTime.Time
+		}
+		return event.CreationTimestamp.Time
+	}
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return lastSeen(items[i]).After(lastSeen(items[j]))
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	var result []map[string]interface{}
+	for _, event := range items {
+		result = append(result, map[string]interface{}{
+			"type":      event.Type,
+			"reason":    event.Reason,
+			"message":   event.Message,
+			"object":    fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			"count":     event.Count,
+			"last_seen": lastSeen(event).Format(time.RFC3339),
+		})
+	}
+	return result, nil
+}
+
+// ServiceInfo is a preview's Service
+type ServiceInfo struct {
+	Name       string               `json:"name"`
+	Namespace  string               `json:"namespace"`
+	ClusterIP  string               `json:"cluster_ip"`
+	ClusterIPs map[string][]string  `json:"cluster_ips"` // by IP family
+	Ports      []corev1.ServicePort `json:"ports"`
+	Type       string               `json:"type"`
+	CreatedAt  time.Time            `json:"created_at"`
+}
+
+// GetServiceInfo gets service information
+func (k *K8sService) GetServiceInfo(ctx context.Context, namespace, serviceName string) (*ServiceInfo, error) {
+	service, err := k.client.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service: %v", err)
+	}
+
+	info := &ServiceInfo{
+		Name:       service.Name,
+		Namespace:  service.Namespace,
+		ClusterIP:  service.Spec.ClusterIP,
+		ClusterIPs: clusterIPsByFamily(service.Spec),
+		Ports:      service.Spec.Ports,
+		Type:       string(service.Spec.Type),
+		CreatedAt:  service.CreationTimestamp.UTC(),
+	}
+
+	return info, nil
+}
+
+// CreatePreviewSecret injects repo-config secrets into the preview namespace
+func (k *K8sService) CreatePreviewSecret(ctx context.Context, namespace, name string, data map[string]string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"preview":    "true",
+				"managed-by": "pr-previews",
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	// A shared namespace gets the same secrets from each service's deploy
+	_, err := k.client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = k.client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create secret %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// UpsertConfigMap creates or replaces a preview-managed ConfigMap
+func (k *K8sService) UpsertConfigMap(ctx context.Context, namespace, name string, data map[string]string) error {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"preview":    "true",
+				"managed-by": "pr-previews",
+			},
+		},
+		Data: data,
+	}
+
+	_, err := k.client.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = k.client.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply configmap %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// RestartWorkloads triggers a rolling restart of every Deployment and
+// StatefulSet in the namespace, like kubectl rollout restart
+func (k *K8sService) RestartWorkloads(ctx context.Context, namespace string) ([]string, error) {
+	patch := []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{"pr-previews.io/restarted-at":%q}}}}}`, time.Now().UTC().Format(time.RFC3339)))
+
+	var restarted []string
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, dep := range deployments.Items {
+		if _, err := k.client.AppsV1().Deployments(namespace).Patch(ctx, dep.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return restarted, fmt.Errorf("failed to restart deployment %s: %v", dep.Name, err)
+		}
+		restarted = append(restarted, fmt.Sprintf("Deployment/%s", dep.Name))
+	}
+
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return restarted, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	for _, sts := range statefulSets.Items {
+		if _, err := k.client.AppsV1().StatefulSets(namespace).Patch(ctx, sts.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return restarted, fmt.Errorf("failed to restart statefulset %s: %v", sts.Name, err)
+		}
+		restarted = append(restarted, fmt.Sprintf("StatefulSet/%s", sts.Name))
+	}
+
+	return restarted, nil
+}
+
+// ScaleDeployment sets the replica count of a deployment
+func (k *K8sService) ScaleDeployment(ctx context.Context, namespace, deploymentName string, replicas int32) error {
+	scale, err := k.client.AppsV1().Deployments(namespace).GetScale(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get scale for deployment %s: %v", deploymentName, err)
+	}
+
+	scale.Spec.Replicas = replicas
+	_, err = k.client.AppsV1().Deployments(namespace).UpdateScale(ctx, deploymentName, scale, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to scale deployment %s: %v", deploymentName, err)
+	}
+
+	return nil
+}
+
+// ScaleStatefulSet sets the replica count of a statefulset
+func (k *K8sService) ScaleStatefulSet(ctx context.Context, namespace, name string, replicas int32) error {
+	scale, err := k.client.AppsV1().StatefulSets(namespace).GetScale(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get scale for statefulset %s: %v", name, err)
+	}
+
+	scale.Spec.Replicas = replicas
+	_, err = k.client.AppsV1().StatefulSets(namespace).UpdateScale(ctx, name, scale, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to scale statefulset %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// ScaleServiceDeployments scales the service's Deployments in its preview
+// namespace: all of them in a per-service namespace, in a shared one only
+// those labelled app=<service>, since names like <service>-gateway may
+// belong to another service
+func (k *K8sService) ScaleServiceDeployments(ctx context.Context, namespace, service string, shared bool, replicas int32) ([]ScaleChange, error) {
+	options := metav1.ListOptions{}
+	if shared {
+		options.LabelSelector = labels.SelectorFromSet(labels.Set{"app": service}).String()
+	}
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	var changes []ScaleChange
+	for _, dep := range deployments.Items {
+		before := int32(1)
+		if dep.Spec.Replicas != nil {
+			before = *dep.Spec.Replicas
+		}
+		if _, err := k.client.AppsV1().Deployments(namespace).Patch(ctx, dep.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return changes, fmt.Errorf("failed to scale deployment %s: %v", dep.Name, err)
+		}
+		changes = append(changes, ScaleChange{Deployment: dep.Name, Before: before, After: replicas})
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no deployments of %s found in %s", service, namespace)
+	}
+	return changes, nil
+}
+
+// CreateLoadTestJob runs a vegeta attack against target and prints its report
+func (k *K8sService) CreateLoadTestJob(ctx context.Context, namespace, name, image, target string, rate int, duration time.Duration) error {
+	script := fmt.Sprintf("echo 'GET %s' | vegeta attack -rate=%d -duration=%s | vegeta report", target, rate, duration)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app":        name,
+				"preview":    "true",
+				"managed-by": "pr-previews",
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: int32Ptr(0),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app":     name,
+						"preview": "true",
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:    "vegeta",
+							Image:   image,
+							Command: []string{"/bin/sh", "-c", script},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, err := k.client.BatchV1().Jobs(namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create load test job: %v", err)
+	}
+
+	return nil
+}
+
+// WaitForJob waits for a job to succeed or fail
+func (k *K8sService) WaitForJob(ctx context.Context, namespace, jobName string, timeout time.Duration) error {
+	return wait.PollImmediate(10*time.Second, timeout, func() (bool, error) {
+		job, err := k.client.BatchV1().Jobs(namespace).Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if job.Status.Failed > 0 {
+			return false, fmt.Errorf("job %s failed", jobName)
+		}
+
+		return job.Status.Succeeded > 0, nil
+	})
+}
+
+// GetJobLogs returns the logs of the first pod created by a job
+func (k *K8sService) GetJobLogs(ctx context.Context, namespace, jobName string) (string, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get job pods: %v", err)
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pods found for job %s", jobName)
+	}
+
+	logs, err := k.client.CoreV1().Pods(namespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{}).DoRaw(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get job logs: %v", err)
+	}
+
+	return string(logs), nil
+}
+
+// CreateVaultSecret writes Vault credentials to a Secret annotated with its lease
+func (k *K8sService) CreateVaultSecret(ctx context.Context, namespace, name, vaultPath string, lease *VaultLease) error {
+	data := make(map[string]string)
+	for key, value := range lease.Data {
+		data[key] = fmt.Sprint(value)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"preview":     "true",
+				"managed-by":  "pr-previews",
+				"vault-lease": "true",
+			},
+			Annotations: map[string]string{
+				"pr-previews.io/vault-path":      vaultPath,
+				"pr-previews.io/vault-lease-id":  lease.LeaseID,
+				"pr-previews.io/vault-renewable": fmt.Sprintf("%t", lease.Renewable),
+			},
+		},
+		Type:       corev1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	_, err := k.client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create secret %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// DeleteVaultSecret removes a Secret CreateVaultSecret wrote
+func (k *K8sService) DeleteVaultSecret(ctx context.Context, namespace, name string) error {
+	err := k.client.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %v", name, err)
+	}
+	return nil
+}
+
+// ListVaultLeases lists Vault-backed secrets; an empty namespace means all namespaces
+func (k *K8sService) ListVaultLeases(ctx context.Context, namespace string) ([]map[string]interface{}, error) {
+	secrets, err := k.client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "managed-by=pr-previews,vault-lease=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vault secrets: %v", err)
+	}
+
+	var result []map[string]interface{}
+	for _, secret := range secrets.Items {
+		info := map[string]interface{}{
+			"name":      secret.Name,
+			"namespace": secret.Namespace,
+			"path":      secret.Annotations["pr-previews.io/vault-path"],
+			"lease_id":  secret.Annotations["pr-previews.io/vault-lease-id"],
+			"renewable": secret.Annotations["pr-previews.io/vault-renewable"] == "true",
+		}
+		result = append(result, info)
+	}
+
+	return result, nil
+}
+
+// IngressPath routes a path prefix on the preview host to a Service
+type IngressPath struct {
+	Path    string
+	Service string
+	Port    int32
+}
+
+// CreatePreviewIngress exposes services on the preview host
+func (k *K8sService) CreatePreviewIngress(ctx context.Context, namespace, name, host, ingressClass string, paths []IngressPath, annotations map[string]string) error {
+	if err := capabilityError(CapabilityIngress); err != nil {
+		return err
+	}
+	pathType := networkingv1.PathTypePrefix
+	var httpPaths []networkingv1.HTTPIngressPath
+	for _, path := range paths {
+		httpPaths = append(httpPaths, networkingv1.HTTPIngressPath{
+			Path:     path.Path,
+			PathType: &pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: path.Service,
+					Port: networkingv1.ServiceBackendPort{Number: path.Port},
+				},
+			},
+		})
+	}
+
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"preview":    "true",
+				"managed-by": "pr-previews",
+			},
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{Paths: httpPaths},
+					},
+				},
+			},
+		},
+	}
+	if ingressClass != "" {
+		ingress.Spec.IngressClassName = &ingressClass
+	}
+
+	// A redeploy replaces the running preview's Ingress
+	client := k.client.NetworkingV1().Ingresses(namespace)
+	_, err := client.Create(ctx, ingress, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *networkingv1.Ingress
+		if existing, err = client.Get(ctx, name, metav1.GetOptions{}); err == nil {
+			ingress.ResourceVersion = existing.ResourceVersion
+			_, err = client.Update(ctx, ingress, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create ingress: %v", err)
+	}
+
+	return nil
+}
+
+// Canary Ingresses are ingress-nginx canaries living in the preview
+// namespace, so they go away with it
+const (
+	canaryLabel                 = "pr-previews.io/canary"
+	canaryHostAnnotation        = "pr-previews.io/canary-host"
+	nginxCanaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	nginxCanaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+)
+
+// CanaryIngress is one preview's share of a base environment host
+type CanaryIngress struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	Weight    string `json:"weight"`
+}
+
+// PreviewIngressBackend returns the Service and port a service's preview
+// Ingress serves its main path from
+func (k *K8sService) PreviewIngressBackend(ctx context.Context, namespace, cleanServiceName string) (IngressPath, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return IngressPath{}, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	name := "preview"
+	if ns.Labels[sharedNamespaceLabel] == NamespaceShared {
+		name = "preview-" + cleanServiceName
+	}
+
+	ingress, err := k.client.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return IngressPath{}, fmt.Errorf("failed to get preview ingress %s/%s: %v", namespace, name, err)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				return IngressPath{Path: path.Path, Service: path.Backend.Service.Name, Port: path.Backend.Service.Port.Number}, nil
+			}
+		}
+	}
+	return IngressPath{}, fmt.Errorf("preview ingress %s/%s has no Service backend", namespace, name)
+}
+
+// SetCanaryIngress creates or reweights a canary sending weight percent of
+// host's traffic to backend. ingress-nginx honours a single canary per host,
+// so a host another preview already takes traffic from is refused.
+func (k *K8sService) SetCanaryIngress(ctx context.Context, namespace, name, host, ingressClass string, backend IngressPath, weight int) error {
+	if err := capabilityError(CapabilityIngress); err != nil {
+		return err
+	}
+	existing, err := k.ListCanaryIngresses(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, canary := range existing {
+		if canary.Host == host && (canary.Namespace != namespace || canary.Name != name) {
+			return fmt.Errorf("%s already sends %s%% of its traffic to %s; run `/canary off` there first", host, canary.Weight, canary.Namespace)
+		}
+	}
+
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"preview":    "true",
+				"managed-by": "pr-previews",
+				canaryLabel:  "true",
+			},
+			Annotations: map[string]string{
+				canaryHostAnnotation:        host,
+				nginxCanaryAnnotation:       "true",
+				nginxCanaryWeightAnnotation: strconv.Itoa(weight),
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{
+							Path:     "/",
+							PathType: &pathType,
+							Backend: networkingv1.IngressBackend{
+								Service: &networkingv1.IngressServiceBackend{
+									Name: backend.Service,
+									Port: networkingv1.ServiceBackendPort{Number: backend.Port},
+								},
+							},
+						}}},
+					},
+				},
+			},
+		},
+	}
+	if ingressClass != "" {
+		ingress.Spec.IngressClassName = &ingressClass
+	}
+
+	_, err = k.client.NetworkingV1().Ingresses(namespace).Create(ctx, ingress, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = k.client.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply canary ingress: %v", err)
+	}
+	return nil
+}
+
+// ListCanaryIngresses lists the canaries in a namespace, or in every
+// namespace when namespace is empty
+func (k *K8sService) ListCanaryIngresses(ctx context.Context, namespace string) ([]CanaryIngress, error) {
+	ingresses, err := k.client.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: canaryLabel + "=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list canary ingresses: %v", err)
+	}
+
+	var canaries []CanaryIngress
+	for _, ingress := range ingresses.Items {
+		canaries = append(canaries, CanaryIngress{
+			Namespace: ingress.Namespace,
+			Name:      ingress.Name,
+			Host:      ingress.Annotations[canaryHostAnnotation],
+			Weight:    ingress.Annotations[nginxCanaryWeightAnnotation],
+		})
+	}
+	return canaries, nil
+}
+
+// RemoveCanaryIngresses deletes a namespace's canaries and returns them
+func (k *K8sService) RemoveCanaryIngresses(ctx context.Context, namespace string) ([]CanaryIngress, error) {
+	canaries, err := k.ListCanaryIngresses(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, canary := range canaries {
+		err := k.client.NetworkingV1().Ingresses(namespace).Delete(ctx, canary.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to delete canary ingress %s/%s: %v", namespace, canary.Name, err)
+		}
+	}
+	return canaries, nil
+}
+
+// AnnotateNamespace merges labels and annotations into an existing namespace
+func (k *K8sService) AnnotateNamespace(ctx context.Context, name string, labels, annotations map[string]string) error {
+	namespace, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+
+	if namespace.Labels == nil {
+		namespace.Labels = make(map[string]string)
+	}
+	for key, value := range labels {
+		namespace.Labels[key] = value
+	}
+	if namespace.Annotations == nil {
+		namespace.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		namespace.Annotations[key] = value
+	}
+
+	_, err = k.client.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update namespace %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// GetNamespaceAnnotation reads one annotation of a namespace
+func (k *K8sService) GetNamespaceAnnotation(ctx context.Context, name, key string) (string, error) {
+	namespace, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	return namespace.Annotations[key], nil
+}
+
+// IngressHostHolders lists the namespaces with an Ingress serving host, in
+// any namespace, previews or not
+func (k *K8sService) IngressHostHolders(ctx context.Context, host string) ([]string, error) {
+	ingresses, err := k.client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses for host %s: %v", host, err)
+	}
+	var holders []string
+	seen := map[string]bool{}
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if strings.EqualFold(rule.Host, host) && !seen[ingress.Namespace] {
+				seen[ingress.Namespace] = true
+				holders = append(holders, ingress.Namespace)
+			}
+		}
+	}
+	return holders, nil
+}
+
+// GetNamespacesByHost lists preview namespaces holding a host slug
+func (k *K8sService) GetNamespacesByHost(ctx context.Context, slug string) ([]map[string]interface{}, error) {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("preview=true,preview-host=%s", slug),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces for host %s: %v", slug, err)
+	}
+
+	var result []map[string]interface{}
+	for _, ns := range namespaces.Items {
+		result = append(result, map[string]interface{}{
+			"name":      ns.Name,
+			"pr_number": ns.Labels["pr-number"],
+			"branch":    ns.Annotations["pr-previews.io/branch"],
+		})
+	}
+
+	return result, nil
+}
+
+// EnsureNamespace creates a long-lived (non-preview) namespace if it's missing
+func (k *K8sService) EnsureNamespace(ctx context.Context, name string, labels map[string]string) error {
+	_, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+	_, err = k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+
+	return nil
+}
+
+// ApplyParsedManifest creates or updates every object in a shared namespace,
+// stamping the given labels and annotations on each
+func (k *K8sService) ApplyParsedManifest(ctx context.Context, namespace string, parsed *ParsedManifest, labels, annotations map[string]string) error {
+	stamp := func(meta *metav1.ObjectMeta) {
+		meta.Namespace = namespace
+		meta.ResourceVersion = ""
+		meta.UID = ""
+		if meta.Labels == nil {
+			meta.Labels = make(map[string]string)
+		}
+		for key, value := range labels {
+			meta.Labels[key] = value
+		}
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]string)
+		}
+		for key, value := range annotations {
+			meta.Annotations[key] = value
+		}
+	}
+
+	for _, configMap := range parsed.ConfigMaps {
+		cm := configMap.DeepCopy()
+		stamp(&cm.ObjectMeta)
+		client := k.client.CoreV1().ConfigMaps(namespace)
+		_, err := client.Create(ctx, cm, k.createOptions())
+		if apierrors.IsAlreadyExists(err) {
+			var existing *corev1.ConfigMap
+			if existing, err = client.Get(ctx, cm.Name, metav1.GetOptions{}); err == nil {
+				cm.ResourceVersion = existing.ResourceVersion
+				_, err = client.Update(ctx, cm, k.updateOptions())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply configmap %s: %v", cm.Name, err)
+		}
+	}
+
+	// Claims are immutable once bound, so existing ones are left alone
+	for _, claim := range parsed.PersistentVolumeClaims {
+		pvc := claim.DeepCopy()
+		stamp(&pvc.ObjectMeta)
+		_, err := k.client.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, k.createOptions())
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to apply persistentvolumeclaim %s: %v", pvc.Name, err)
+		}
+	}
+
+	for _, deployment := range parsed.Deployments {
+		dep := deployment.DeepCopy()
+		stamp(&dep.ObjectMeta)
+		client := k.client.AppsV1().Deployments(namespace)
+		_, err := client.Create(ctx, dep, k.createOptions())
+		if apierrors.IsAlreadyExists(err) {
+			var existing *appsv1.Deployment
+			if existing, err = client.Get(ctx, dep.Name, metav1.GetOptions{}); err == nil {
+				dep.ResourceVersion = existing.ResourceVersion
+				_, err = client.Update(ctx, dep, k.updateOptions())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply deployment %s: %v", dep.Name, err)
+		}
+	}
+
+	for _, statefulSet := range parsed.StatefulSets {
+		sts := statefulSet.DeepCopy()
+		stamp(&sts.ObjectMeta)
+		client := k.client.AppsV1().StatefulSets(namespace)
+		_, err := client.Create(ctx, sts, k.createOptions())
+		if apierrors.IsAlreadyExists(err) {
+			var existing *appsv1.StatefulSet
+			if existing, err = client.Get(ctx, sts.Name, metav1.GetOptions{}); err == nil {
+				sts.ResourceVersion = existing.ResourceVersion
+				_, err = client.Update(ctx, sts, k.updateOptions())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply statefulset %s: %v", sts.Name, err)
+		}
+	}
+
+	for _, service := range parsed.Services {
+		svc := service.DeepCopy()
+		stamp(&svc.ObjectMeta)
+		client := k.client.CoreV1().Services(namespace)
+		_, err := client.Create(ctx, svc, k.createOptions())
+		if apierrors.IsAlreadyExists(err) {
+			var existing *corev1.Service
+			if existing, err = client.Get(ctx, svc.Name, metav1.GetOptions{}); err == nil {
+				// ClusterIP is immutable once allocated
+				svc.ResourceVersion = existing.ResourceVersion
+				svc.Spec.ClusterIP = existing.Spec.ClusterIP
+				svc.Spec.ClusterIPs = existing.Spec.ClusterIPs
+				_, err = client.Update(ctx, svc, k.updateOptions())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply service %s: %v", svc.Name, err)
+		}
+	}
+
+	for _, autoscaler := range parsed.HorizontalPodAutoscalers {
+		hpa := autoscaler.DeepCopy()
+		stamp(&hpa.ObjectMeta)
+		client := k.client.AutoscalingV2().HorizontalPodAutoscalers(namespace)
+		_, err := client.Create(ctx, hpa, k.createOptions())
+		if apierrors.IsAlreadyExists(err) {
+			var existing *autoscalingv2.HorizontalPodAutoscaler
+			if existing, err = client.Get(ctx, hpa.Name, metav1.GetOptions{}); err == nil {
+				hpa.ResourceVersion = existing.ResourceVersion
+				_, err = client.Update(ctx, hpa, k.updateOptions())
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply horizontalpodautoscaler %s: %v", hpa.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// GetNamespaceRequests sums the CPU (cores) and memory (GiB) requested by
+// the namespace's running pods
+func (k *K8sService) GetNamespaceRequests(ctx context.Context, namespace string) (float64, float64, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase=Running",
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+	}
+
+	var cpu, memory float64
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			if request, ok := container.Resources.Requests[corev1.ResourceCPU]; ok {
+				cpu += request.AsApproximateFloat64()
+			}
+			if request, ok := container.Resources.Requests[corev1.ResourceMemory]; ok {
+				memory += request.AsApproximateFloat64() / (1 << 30)
+			}
+		}
+	}
+
+	return cpu, memory, nil
+}
+
+// NamespaceExists reports whether a namespace is present
+func (k *K8sService) NamespaceExists(ctx context.Context, name string) (bool, error) {
+	_, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// CaptureNamespace reads the namespace's workloads back into a ParsedManifest,
+// stripping server-populated fields so the result can be re-applied
+func (k *K8sService) CaptureNamespace(ctx context.Context, namespace string) (*ParsedManifest, error) {
+	parsed := &ParsedManifest{}
+	clean := func(meta *metav1.ObjectMeta) {
+		meta.Namespace = ""
+		meta.ResourceVersion = ""
+		meta.UID = ""
+		meta.Generation = 0
+		meta.CreationTimestamp = metav1.Time{}
+		meta.ManagedFields = nil
+		meta.OwnerReferences = nil
+	}
+
+	configMaps, err := k.client.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configmaps in %s: %v", namespace, err)
+	}
+	for _, cm := range configMaps.Items {
+		if cm.Name == "kube-root-ca.crt" {
+			continue
+		}
+		clean(&cm.ObjectMeta)
+		cm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		parsed.ConfigMaps = append(parsed.ConfigMaps, cm)
+	}
+
+	secrets, err := k.client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets in %s: %v", namespace, err)
+	}
+	for _, secret := range secrets.Items {
+		if secret.Type == corev1.SecretTypeServiceAccountToken {
+			continue
+		}
+		clean(&secret.ObjectMeta)
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		parsed.Secrets = append(parsed.Secrets, secret)
+	}
+
+	claims, err := k.client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistentvolumeclaims in %s: %v", namespace, err)
+	}
+	for _, pvc := range claims.Items {
+		clean(&pvc.ObjectMeta)
+		pvc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"}
+		pvc.Spec.VolumeName = ""
+		pvc.Status = corev1.PersistentVolumeClaimStatus{}
+		parsed.PersistentVolumeClaims = append(parsed.PersistentVolumeClaims, pvc)
+	}
+
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, dep := range deployments.Items {
+		clean(&dep.ObjectMeta)
+		delete(dep.Annotations, "deployment.kubernetes.io/revision")
+		dep.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+		dep.Status = appsv1.DeploymentStatus{}
+		parsed.Deployments = append(parsed.Deployments, dep)
+	}
+
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	for _, sts := range statefulSets.Items {
+		clean(&sts.ObjectMeta)
+		sts.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"}
+		sts.Status = appsv1.StatefulSetStatus{}
+		parsed.StatefulSets = append(parsed.StatefulSets, sts)
+	}
+
+	services, err := k.client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services in %s: %v", namespace, err)
+	}
+	for _, svc := range services.Items {
+		clean(&svc.ObjectMeta)
+		svc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+		// Headless services must stay headless; other IPs are reallocated
+		if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+			svc.Spec.ClusterIP = ""
+			svc.Spec.ClusterIPs = nil
+		}
+		svc.Status = corev1.ServiceStatus{}
+		parsed.Services = append(parsed.Services, svc)
+	}
+
+	autoscalers, err := k.client.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list horizontalpodautoscalers in %s: %v", namespace, err)
+	}
+	for _, hpa := range autoscalers.Items {
+		clean(&hpa.ObjectMeta)
+		hpa.TypeMeta = metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"}
+		hpa.Status = autoscalingv2.HorizontalPodAutoscalerStatus{}
+		parsed.HorizontalPodAutoscalers = append(parsed.HorizontalPodAutoscalers, hpa)
+	}
+
+	return parsed, nil
+}
+
+// CreateVolumeSnapshot snapshots a claim's data through the CSI snapshot API
+func (k *K8sService) CreateVolumeSnapshot(ctx context.Context, namespace, name, claimName, snapshotClass string) error {
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"persistentVolumeClaimName": claimName,
+		},
+	}
+	if snapshotClass != "" {
+		spec["volumeSnapshotClassName"] = snapshotClass
+	}
+
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					"managed-by": "pr-previews",
+				},
+			},
+			"spec": spec,
+		},
+	}
+
+	_, err := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Create(ctx, snapshot, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to snapshot volume %s: %v", claimName, err)
+	}
+	return nil
+}
+
+// VolumeSnapshotExists reports whether a VolumeSnapshot is still available
+func (k *K8sService) VolumeSnapshotExists(ctx context.Context, namespace, name string) (bool, error) {
+	_, err := k.dynamic.Resource(volumeSnapshotResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get volume snapshot %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// minTokenTTL is the shortest expiry the TokenRequest API accepts
+const minTokenTTL = 10 * time.Minute
+
+// IssueDebugToken grants a ServiceAccount edit rights inside a single preview
+// namespace and returns a token for it that expires after ttl, or after ten
+// minutes when ttl is shorter than the API allows
+func (k *K8sService) IssueDebugToken(ctx context.Context, namespace, name string, ttl time.Duration) (string, time.Time, error) {
+	labels := map[string]string{
+		"preview":    "true",
+		"managed-by": "pr-previews",
+	}
+
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+	}
+	_, err := k.client.CoreV1().ServiceAccounts(namespace).Create(ctx, serviceAccount, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", time.Time{}, fmt.Errorf("failed to create service account %s: %v", name, err)
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{"", "apps", "batch", "autoscaling", "networking.k8s.io"},
+				Resources: []string{"*"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups: []string{""},
+				Resources: []string{"pods", "pods/exec", "pods/portforward"},
+				Verbs:     []string{"create", "delete"},
+			},
+			{
+				APIGroups: []string{"apps"},
+				Resources: []string{"deployments", "deployments/scale", "statefulsets", "statefulsets/scale"},
+				Verbs:     []string{"patch", "update"},
+			},
+		},
+	}
+	_, err = k.client.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", time.Time{}, fmt.Errorf("failed to create role %s: %v", name, err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+	}
+	_, err = k.client.RbacV1().RoleBindings(namespace).Create(ctx, binding, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", time.Time{}, fmt.Errorf("failed to create role binding %s: %v", name, err)
+	}
+
+	if ttl < minTokenTTL {
+		ttl = minTokenTTL
+	}
+	expirationSeconds := int64(ttl.Seconds())
+	request := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+	}
+	token, err := k.client.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, request, metav1.CreateOptions{})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to issue token for %s: %v", name, err)
+	}
+
+	return token.Status.Token, token.Status.ExpirationTimestamp.Time, nil
+}
+
+// ClusterEndpoint returns the API server address and CA bundle this service
+// connects with
+func (k *K8sService) ClusterEndpoint() (string, []byte, error) {
+	caData := k.restConfig.CAData
+	if len(caData) == 0 && k.restConfig.CAFile != "" {
+		data, err := os.ReadFile(k.restConfig.CAFile)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read cluster CA: %v", err)
+		}
+		caData = data
+	}
+	return k.restConfig.Host, caData, nil
+}
+
+// InjectChaosProxy puts a toxiproxy sidecar in front of every Deployment
+// port a Service targets and repoints the Service at it. The toxics are
+// created by a second sidecar once the proxy is up. Returns the proxied
+// Service ports.
+func (k *K8sService) InjectChaosProxy(ctx context.Context, namespace string, spec ChaosSpec, proxyImage, clientImage string) ([]string, error) {
+	// Start from the app's own wiring so a second /chaos replaces the first
+	if _, err := k.RemoveChaosProxy(ctx, namespace); err != nil {
+		return nil, err
+	}
+
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	services, err := k.client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services in %s: %v", namespace, err)
+	}
+
+	// Work out the rewiring before touching anything
+	upstreams := map[string][]int32{} // deployment -> app ports to proxy
+	var rewired []corev1.Service
+	var proxied []string
+	for _, svc := range services.Items {
+		if len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		selector := labels.SelectorFromSet(svc.Spec.Selector)
+		var targets []appsv1.Deployment
+		for _, dep := range deployments.Items {
+			if selector.Matches(labels.Set(dep.Spec.Template.Labels)) {
+				targets = append(targets, dep)
+			}
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		original, err := json.Marshal(svc.Spec.Ports)
+		if err != nil {
+			return nil, err
+		}
+
+		changed := false
+		for i, port := range svc.Spec.Ports {
+			appPort := resolveTargetPort(port, &targets[0])
+			if appPort == 0 || appPort+chaosProxyPortOffset > 65535 {
+				continue
+			}
+			svc.Spec.Ports[i].TargetPort = intstr.FromInt32(appPort + chaosProxyPortOffset)
+			for _, dep := range targets {
+				if !containsInt32(upstreams[dep.Name], appPort) {
+					upstreams[dep.Name] = append(upstreams[dep.Name], appPort)
+				}
+			}
+			proxied = append(proxied, fmt.Sprintf("Service/%s:%d", svc.Name, port.Port))
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+
+		if svc.Annotations == nil {
+			svc.Annotations = make(map[string]string)
+		}
+		svc.Annotations[chaosOriginalPortsAnnotation] = string(original)
+		rewired = append(rewired, svc)
+	}
+
+	if len(rewired) == 0 {
+		return nil, nil
+	}
+
+	configData := map[string]string{}
+	for depName, ports := range upstreams {
+		var proxies []map[string]interface{}
+		for _, port := range ports {
+			proxies = append(proxies, map[string]interface{}{
+				"name":     fmt.Sprintf("port-%d", port),
+				"listen":   fmt.Sprintf("0.0.0.0:%d", port+chaosProxyPortOffset),
+				"upstream": fmt.Sprintf("127.0.0.1:%d", port),
+				"enabled":  true,
+			})
+		}
+		content, err := json.Marshal(proxies)
+		if err != nil {
+			return nil, err
+		}
+		configData[depName+".json"] = string(content)
+	}
+	if err := k.UpsertConfigMap(ctx, namespace, chaosProxyName, configData); err != nil {
+		return nil, err
+	}
+
+	// Sidecars first, so the Services never point at a port nobody listens on
+	// for longer than the rollout
+	for _, dep := range deployments.Items {
+		ports, ok := upstreams[dep.Name]
+		if !ok {
+			continue
+		}
+		addChaosSidecars(&dep, ports, spec, proxyImage, clientImage)
+		if _, err := k.client.AppsV1().Deployments(namespace).Update(ctx, &dep, metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to inject chaos proxy into deployment %s: %v", dep.Name, err)
+		}
+	}
+
+	for i := range rewired {
+		if _, err := k.client.CoreV1().Services(namespace).Update(ctx, &rewired[i], metav1.UpdateOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to route service %s through chaos proxy: %v", rewired[i].Name, err)
+		}
+	}
+
+	return proxied, nil
+}
+
+// RemoveChaosProxy restores Services and Deployments changed by
+// InjectChaosProxy. Returns the restored resources.
+func (k *K8sService) RemoveChaosProxy(ctx context.Context, namespace string) ([]string, error) {
+	var restored []string
+
+	services, err := k.client.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services in %s: %v", namespace, err)
+	}
+	for _, svc := range services.Items {
+		original, ok := svc.Annotations[chaosOriginalPortsAnnotation]
+		if !ok {
+			continue
+		}
+		var ports []corev1.ServicePort
+		if err := json.Unmarshal([]byte(original), &ports); err != nil {
+			return restored, fmt.Errorf("failed to read original ports of service %s: %v", svc.Name, err)
+		}
+		svc.Spec.Ports = ports
+		delete(svc.Annotations, chaosOriginalPortsAnnotation)
+		if _, err := k.client.CoreV1().Services(namespace).Update(ctx, &svc, metav1.UpdateOptions{}); err != nil {
+			return restored, fmt.Errorf("failed to restore service %s: %v", svc.Name, err)
+		}
+		restored = append(restored, fmt.Sprintf("Service/%s", svc.Name))
+	}
+
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return restored, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, dep := range deployments.Items {
+		if _, ok := dep.Annotations[chaosAnnotation]; !ok {
+			continue
+		}
+		removeChaosSidecars(&dep)
+		if _, err := k.client.AppsV1().Deployments(namespace).Update(ctx, &dep, metav1.UpdateOptions{}); err != nil {
+			return restored, fmt.Errorf("failed to remove chaos proxy from deployment %s: %v", dep.Name, err)
+		}
+		restored = append(restored, fmt.Sprintf("Deployment/%s", dep.Name))
+	}
+
+	err = k.client.CoreV1().ConfigMaps(namespace).Delete(ctx, chaosProxyName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return restored, fmt.Errorf("failed to delete chaos proxy config: %v", err)
+	}
+
+	return restored, nil
+}
+
+// resolveTargetPort returns the container port a Service port forwards to,
+// or 0 when a named port can't be found
+func resolveTargetPort(port corev1.ServicePort, dep *appsv1.Deployment) int32 {
+	if port.TargetPort.Type == intstr.Int {
+		if port.TargetPort.IntVal == 0 {
+			return port.Port
+		}
+		return port.TargetPort.IntVal
+	}
+	for _, container := range dep.Spec.Template.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == port.TargetPort.StrVal {
+				return containerPort.ContainerPort
+			}
+		}
+	}
+	return 0
+}
+
+func addChaosSidecars(dep *appsv1.Deployment, ports []int32, spec ChaosSpec, proxyImage, clientImage string) {
+	securityContext := &corev1.SecurityContext{
+		RunAsNonRoot:             boolPtr(true),
+		RunAsUser:                int64Ptr(65534),
+		AllowPrivilegeEscalation: boolPtr(false),
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+
+	var containerPorts []corev1.ContainerPort
+	for _, port := range ports {
+		containerPorts = append(containerPorts, corev1.ContainerPort{
+			Name:          fmt.Sprintf("chaos-%d", port),
+			ContainerPort: port + chaosProxyPortOffset,
+		})
+	}
+
+	podSpec := &dep.Spec.Template.Spec
+	podSpec.Containers = append(podSpec.Containers,
+		corev1.Container{
+			Name:            chaosProxyName,
+			Image:           proxyImage,
+			Args:            []string{"-host=0.0.0.0", fmt.Sprintf("-config=/etc/toxiproxy/%s.json", dep.Name)},
+			Ports:           containerPorts,
+			SecurityContext: securityContext,
+			VolumeMounts: []corev1.VolumeMount{
+				{Name: chaosProxyName, MountPath: "/etc/toxiproxy", ReadOnly: true},
+			},
+		},
+		corev1.Container{
+			Name:            chaosConfigName,
+			Image:           clientImage,
+			Command:         []string{"/bin/sh", "-c", chaosToxicsScript(ports, spec)},
+			SecurityContext: securityContext,
+		},
+	)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: chaosProxyName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: chaosProxyName},
+			},
+		},
+	})
+
+	if dep.Annotations == nil {
+		dep.Annotations = make(map[string]string)
+	}
+	dep.Annotations[chaosAnnotation] = spec.String()
+}
+
+func removeChaosSidecars(dep *appsv1.Deployment) {
+	podSpec := &dep.Spec.Template.Spec
+
+	var containers []corev1.Container
+	for _, container := range podSpec.Containers {
+		if container.Name != chaosProxyName && container.Name != chaosConfigName {
+			containers = append(containers, container)
+		}
+	}
+	podSpec.Containers = containers
+
+	var volumes []corev1.Volume
+	for _, volume := range podSpec.Volumes {
+		if volume.Name != chaosProxyName {
+			volumes = append(volumes, volume)
+		}
+	}
+	podSpec.Volumes = volumes
+
+	delete(dep.Annotations, chaosAnnotation)
+}
+
+func containsInt32(values []int32, value int32) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function for int32 pointer
+func int32Ptr(i int32) *int32 { return &i }
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func boolPtr(b bool) *bool { return &b }
+
+// DryRun is a copy of the service whose manifest deploys are sent with
+// dryRun=All: they pass through admission, webhooks included, and nothing
+// is persisted
+func (k *K8sService) DryRun() *K8sService {
+	dry := *k
+	dry.dryRun = true
+	return &dry
+}
+
+// createOptions are the options of the creates DryRun covers
+func (k *K8sService) createOptions() metav1.CreateOptions {
+	if k.dryRun {
+		return metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.CreateOptions{}
+}
+
+// updateOptions are the options of the updates DryRun covers
+func (k *K8sService) updateOptions() metav1.UpdateOptions {
+	if k.dryRun {
+		return metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.UpdateOptions{}
+}
+
+func (k *K8sService) DeployFromParsedManifest(ctx context.Context, namespace string, parsed *ParsedManifest) error {
+	// Deploy ConfigMaps first (they might be needed by deployments)
+	for _, configMap := range parsed.ConfigMaps {
+		err := k.deployConfigMap(ctx, namespace, &configMap)
+		if err != nil {
+			return fmt.Errorf("failed to deploy configmap %s: %v", configMap.Name, err)
+		}
+	}
+
+	// Deploy Secrets alongside ConfigMaps
+	for _, secret := range parsed.Secrets {
+		err := k.deploySecret(ctx, namespace, &secret)
+		if err != nil {
+			return fmt.Errorf("failed to deploy secret %s: %v", secret.Name, err)
+		}
+	}
+
+	// Deploy PersistentVolumeClaims before the workloads that mount them
+	for _, claim := range parsed.PersistentVolumeClaims {
+		err := k.deployPersistentVolumeClaim(ctx, namespace, &claim)
+		if err != nil {
+			return fmt.Errorf("failed to deploy persistentvolumeclaim %s: %v", claim.Name, err)
+		}
+	}
+
+	// Deploy Deployments
+	for _, deployment := range parsed.Deployments {
+		err := k.deployManifestDeployment(ctx, namespace, &deployment)
+		if err != nil {
+			return fmt.Errorf("failed to deploy deployment %s: %v", deployment.Name, err)
+		}
+	}
+
+	// Deploy StatefulSets; the controller brings ordinals up one at a time
+	for _, statefulSet := range parsed.StatefulSets {
+		err := k.deployStatefulSet(ctx, namespace, &statefulSet)
+		if err != nil {
+			return fmt.Errorf("failed to deploy statefulset %s: %v", statefulSet.Name, err)
+		}
+	}
+
+	// Deploy Services
+	for _, service := range parsed.Services {
+		err := k.deployManifestService(ctx, namespace, &service)
+		if err != nil {
+			return fmt.Errorf("failed to deploy service %s: %v", service.Name, err)
+		}
+	}
+
+	// Deploy HPAs that survived the preview mutation stage
+	for _, hpa := range parsed.HorizontalPodAutoscalers {
+		err := k.deployHorizontalPodAutoscaler(ctx, namespace, &hpa)
+		if err != nil {
+			return fmt.Errorf("failed to deploy horizontalpodautoscaler %s: %v", hpa.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (k *K8sService) deployManifestDeployment(ctx context.Context, namespace string, deployment *appsv1.Deployment) error {
+	// Clone deployment to avoid modifying original
+	dep := deployment.DeepCopy()
+
+	// Override namespace
+	dep.Namespace = namespace
+
+	// Add preview labels
+	if dep.Labels == nil {
+		dep.Labels = make(map[string]string)
+	}
+	dep.Labels["preview"] = "true"
+	dep.Labels["managed-by"] = "pr-previews"
+
+	// Add labels to pod template
+	if dep.Spec.Template.Labels == nil {
+		dep.Spec.Template.Labels = make(map[string]string)
+	}
+	dep.Spec.Template.Labels["preview"] = "true"
+
+	_, err := k.client.AppsV1().Deployments(namespace).Create(ctx, dep, k.createOptions())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (k *K8sService) deployStatefulSet(ctx context.Context, namespace string, statefulSet *appsv1.StatefulSet) error {
+	// Clone statefulset to avoid modifying original
+	sts := statefulSet.DeepCopy()
+
+	// Override namespace
+	sts.Namespace = namespace
+
+	// Add preview labels
+	if sts.Labels == nil {
+		sts.Labels = make(map[string]string)
+	}
+	sts.Labels["preview"] = "true"
+	sts.Labels["managed-by"] = "pr-previews"
+
+	// Add labels to pod template
+	if sts.Spec.Template.Labels == nil {
+		sts.Spec.Template.Labels = make(map[string]string)
+	}
+	sts.Spec.Template.Labels["preview"] = "true"
+
+	_, err := k.client.AppsV1().StatefulSets(namespace).Create(ctx, sts, k.createOptions())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (k *K8sService) deployManifestService(ctx context.Context, namespace string, service *corev1.Service) error {
+	// Clone service to avoid modifying original
+	svc := service.DeepCopy()
 
 	// Override namespace
 	svc.Namespace = namespace
@@ -471,8 +2945,9 @@ func (k *K8sService) deployManifestService(ctx context.Context, namespace string
 	}
 	svc.Labels["preview"] = "true"
 	svc.Labels["managed-by"] = "pr-previews"
+	k.ipFamilies.apply(&svc.Spec)
 
-	_, err := k.client.CoreV1().Services(namespace).Create(ctx, svc, metav1.CreateOptions{})
+	_, err := k.client.CoreV1().Services(namespace).Create(ctx, svc, k.createOptions())
 	if err != nil {
 		return err
 	}
@@ -494,7 +2969,73 @@ func (k *K8sService) deployConfigMap(ctx context.Context, namespace string, conf
 	cm.Labels["preview"] = "true"
 	cm.Labels["managed-by"] = "pr-previews"
 
-	_, err := k.client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+	_, err := k.client.CoreV1().ConfigMaps(namespace).Create(ctx, cm, k.createOptions())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (k *K8sService) deploySecret(ctx context.Context, namespace string, secret *corev1.Secret) error {
+	// Clone secret to avoid modifying original
+	sec := secret.DeepCopy()
+
+	// Override namespace
+	sec.Namespace = namespace
+
+	// Add preview labels
+	if sec.Labels == nil {
+		sec.Labels = make(map[string]string)
+	}
+	sec.Labels["preview"] = "true"
+	sec.Labels["managed-by"] = "pr-previews"
+
+	_, err := k.client.CoreV1().Secrets(namespace).Create(ctx, sec, k.createOptions())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (k *K8sService) deployPersistentVolumeClaim(ctx context.Context, namespace string, claim *corev1.PersistentVolumeClaim) error {
+	// Clone claim to avoid modifying original
+	pvc := claim.DeepCopy()
+
+	// Override namespace
+	pvc.Namespace = namespace
+
+	// Add preview labels
+	if pvc.Labels == nil {
+		pvc.Labels = make(map[string]string)
+	}
+	pvc.Labels["preview"] = "true"
+	pvc.Labels["managed-by"] = "pr-previews"
+
+	_, err := k.client.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, k.createOptions())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (k *K8sService) deployHorizontalPodAutoscaler(ctx context.Context, namespace string, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	// Clone HPA to avoid modifying original
+	autoscaler := hpa.DeepCopy()
+
+	// Override namespace
+	autoscaler.Namespace = namespace
+
+	// Add preview labels
+	if autoscaler.Labels == nil {
+		autoscaler.Labels = make(map[string]string)
+	}
+	autoscaler.Labels["preview"] = "true"
+	autoscaler.Labels["managed-by"] = "pr-previews"
+
+	_, err := k.client.AutoscalingV2().HorizontalPodAutoscalers(namespace).Create(ctx, autoscaler, k.createOptions())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"pr-previews/internal/config"
+)
+
+// LeaderElection picks the replica that runs the background work every
+// replica would otherwise repeat: scaling previews to zero, watching pod
+// health, reconciling GitHub. A new replica takes over only once the old
+// leader released the Lease or it expired, so during a rolling restart the
+// work never runs twice.
+type LeaderElection struct {
+	leading atomic.Bool
+
+	mu      sync.Mutex
+	onLead  []func(ctx context.Context)
+	started bool
+}
+
+// sharedLeader is campaigned for once per process and read by every command
+// service, like the comment outbox
+var sharedLeader = &LeaderElection{}
+
+// SharedLeader is the process's leader election
+func SharedLeader() *LeaderElection {
+	return sharedLeader
+}
+
+// IsLeader reports whether this replica holds the Lease
+func (l *LeaderElection) IsLeader() bool {
+	return l.leading.Load()
+}
+
+// OnStartedLeading runs fn, in its own goroutine, each time this replica
+// becomes the leader, with a context cancelled when it stops leading.
+// Register before Run.
+func (l *LeaderElection) OnStartedLeading(fn func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onLead = append(l.onLead, fn)
+}
+
+// Run campaigns for the Lease until ctx is cancelled, releasing it on the
+// way out
+func (l *LeaderElection) Run(ctx context.Context, cfg *config.Config) {
+	l.mu.Lock()
+	if l.started {
+		l.mu.Unlock()
+		return
+	}
+	l.started = true
+	l.mu.Unlock()
+
+	if cfg.Leader.Namespace == "" {
+		fmt.Println("👑 Leading alone: LEADER_NAMESPACE (or POD_NAMESPACE) isn't set")
+		l.lead(ctx)
+		return
+	}
+	k8s, err := NewK8sService()
+	if err != nil {
+		fmt.Printf("⚠️  Leader election disabled, leading alone: %v\n", err)
+		l.lead(ctx)
+		return
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: cfg.Leader.Lease, Namespace: cfg.Leader.Namespace},
+		Client:     k8s.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Server.Replica},
+	}
+	duration := cfg.Leader.LeaseDuration
+	if duration <= 0 {
+		duration = 15 * time.Second
+	}
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   duration,
+			RenewDeadline:   duration * 2 / 3,
+			RetryPeriod:     duration / 5,
+			ReleaseOnCancel: true,
+			Name:            cfg.Leader.Lease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					fmt.Printf("👑 %s is the leader\n", cfg.Server.Replica)
+					l.lead(ctx)
+				},
+				OnStoppedLeading: func() {
+					if l.leading.Swap(false) {
+						fmt.Printf("%s stopped leading\n", cfg.Server.Replica)
+					}
+				},
+			},
+		})
+		// Lost the Lease; campaign again
+		select {
+		case <-ctx.Done():
+		case <-time.After(duration / 5):
+		}
+	}
+}
+
+// lead marks this replica the leader and starts the leader's work
+func (l *LeaderElection) lead(ctx context.Context) {
+	l.leading.Store(true)
+	l.mu.Lock()
+	onLead := append([]func(context.Context){}, l.onLead...)
+	l.mu.Unlock()
+	for _, fn := range onLead {
+		go fn(ctx)
+	}
+}
+
+// rolloutPollInterval is how often WaitForOldReplicas looks at the pods
+const rolloutPollInterval = 5 * time.Second
+
+// WaitForOldReplicas waits until no pod of an older revision of the pod's
+// Deployment is left running in namespace, so work those replicas may still
+// be doing isn't mistaken for work a restart dropped. False means some were
+// still running after timeout. Outside a cluster, or for a pod that doesn't
+// belong to a Deployment, there is nothing to wait for.
+func WaitForOldReplicas(ctx context.Context, namespace, pod string, timeout time.Duration) (bool, error) {
+	if namespace == "" || pod == "" {
+		return true, nil
+	}
+	k8s, err := NewK8sService()
+	if err != nil {
+		return false, err
+	}
+	self, err := k8s.client.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to read pod %s: %v", pod, err)
+	}
+	revision := self.Labels["pod-template-hash"]
+	if revision == "" {
+		return true, nil
+	}
+	selector := labels.Set{}
+	for key, value := range self.Labels {
+		if key != "pod-template-hash" {
+			selector[key] = value
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := k8s.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return false, fmt.Errorf("failed to list replicas: %v", err)
+		}
+		old := 0
+		for _, p := range pods.Items {
+			if p.Labels["pod-template-hash"] != revision && p.Status.Phase != corev1.PodSucceeded && p.Status.Phase != corev1.PodFailed {
+				old++
+			}
+		}
+		if old == 0 {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// maintenanceKey is where a runtime maintenance window lives in the artifact
+// store
+const maintenanceKey = "ops/maintenance.json"
+
+// maintenanceRefresh is how long a replica trusts the state it last read,
+// so a switch flipped on another replica reaches it within that time
+const maintenanceRefresh = 10 * time.Second
+
+// Commands that start new deployments, which maintenance mode turns away.
+// Reading state and cleaning up keep working.
+var maintenancePausedCommands = map[string]bool{
+	"preview":  true,
+	"loadtest": true,
+	"restore":  true,
+	"canary":   true,
+}
+
+// MaintenancePauses reports whether maintenance mode turns the command away
+func MaintenancePauses(commandType string) bool {
+	return maintenancePausedCommands[commandType]
+}
+
+// Maintenance is whether new deployments are paused, and why
+type Maintenance struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	By      string    `json:"by,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+	Forced  bool      `json:"forced,omitempty"` // MAINTENANCE_MODE is on, so it can't be switched off at runtime
+}
+
+// MaintenanceSwitch pauses new deployments for cluster maintenance windows.
+// Admins flip it with /maintenance or the admin API; it's persisted in the
+// artifact store so a restart mid-window doesn't reopen deploys, and without
+// one it lasts until the restart. MAINTENANCE_MODE keeps it on regardless.
+// Replicas share it through the store and re-read it every
+// maintenanceRefresh.
+type MaintenanceSwitch struct {
+	mu       sync.Mutex
+	store    ArtifactStore
+	forced   bool
+	message  string
+	state    Maintenance
+	loadedAt time.Time
+}
+
+func NewMaintenanceSwitch(store ArtifactStore, forced bool, message string) *MaintenanceSwitch {
+	return &MaintenanceSwitch{store: store, forced: forced, message: message}
+}
+
+// Status is the current maintenance state. A store that can't be read
+// leaves deploys open rather than blocking them on a storage outage.
+func (m *MaintenanceSwitch) Status(ctx context.Context) Maintenance {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.loadLocked(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	return m.currentLocked()
+}
+
+// Set turns maintenance mode on or off
+func (m *MaintenanceSwitch) Set(ctx context.Context, enabled bool, reason, by string) (Maintenance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loadedAt = time.Time{}
+	if err := m.loadLocked(ctx); err != nil {
+		return Maintenance{}, err
+	}
+	if !enabled && m.forced {
+		return m.currentLocked(), fmt.Errorf("maintenance mode is set by MAINTENANCE_MODE and can only be switched off in the deployment's config")
+	}
+
+	previous := m.state
+	m.state = Maintenance{Enabled: enabled, By: by, Since: time.Now().UTC()}
+	if enabled {
+		m.state.Reason = reason
+	}
+	if err := m.saveLocked(ctx); err != nil {
+		m.state = previous
+		return Maintenance{}, err
+	}
+	return m.currentLocked(), nil
+}
+
+func (m *MaintenanceSwitch) currentLocked() Maintenance {
+	state := m.state
+	if m.forced {
+		state.Enabled, state.Forced = true, true
+		if state.Reason == "" {
+			state.Reason = m.message
+		}
+	}
+	return state
+}
+
+// loadLocked re-reads the state once the last read is older than
+// maintenanceRefresh; a failed read keeps the last state
+func (m *MaintenanceSwitch) loadLocked(ctx context.Context) error {
+	if m.store == nil || time.Since(m.loadedAt) < maintenanceRefresh {
+		return nil
+	}
+
+	keys, err := m.store.List(ctx, maintenanceKey)
+	if err != nil {
+		return fmt.Errorf("failed to load maintenance state: %v", err)
+	}
+	var state Maintenance
+	for _, key := range keys {
+		if key != maintenanceKey {
+			continue
+		}
+		content, err := m.store.Get(ctx, maintenanceKey)
+		if err != nil {
+			return fmt.Errorf("failed to load maintenance state: %v", err)
+		}
+		if err := json.Unmarshal(content, &state); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", maintenanceKey, err)
+		}
+	}
+
+	m.state, m.loadedAt = state, time.Now()
+	return nil
+}
+
+func (m *MaintenanceSwitch) saveLocked(ctx context.Context) error {
+	if m.store == nil {
+		return nil
+	}
+	content, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := m.store.Save(ctx, maintenanceKey, content); err != nil {
+		return fmt.Errorf("failed to save maintenance state: %v", err)
+	}
+	return nil
+}
+
+// PausedResponse is the reply to a deployment command during maintenance
+func (m *MaintenanceSwitch) PausedResponse(ctx context.Context, lang *LanguagePack, cmd *types.Command) *types.CommandResponse {
+	state := m.Status(ctx)
+	content := lang.T("maintenance.paused")
+	if state.Reason != "" {
+		content += "\n\n**Reason:** " + state.Reason
+	}
+	if !state.Since.IsZero() {
+		content += fmt.Sprintf("\n**Paused since:** %s", state.Since.Format(time.RFC3339))
+	}
+	content += "\n\n" + lang.T("triggered_by", cmd.User)
+
+	return &types.CommandResponse{
+		Success: false,
+		Message: "Previews paused for maintenance",
+		Content: content,
+		Data: map[string]interface{}{
+			"command":     cmd.Type,
+			"maintenance": state,
+		},
+	}
+}
+
+// HandleMaintenance is the /maintenance [on [reason]|off] ops command
+func (m *MaintenanceSwitch) HandleMaintenance(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	var state Maintenance
+	switch cmd.Args["mode"] {
+	case "on", "off":
+		var err error
+		state, err = m.Set(ctx, cmd.Args["mode"] == "on", cmd.Args["reason"], cmd.User)
+		if err != nil {
+			return failedResponse("Maintenance switch failed", "Maintenance Switch Failed", err)
+		}
+	default:
+		state = m.Status(ctx)
+	}
+
+	result := &types.Result{
+		Status:  types.StatusSuccess,
+		Icon:    "✅",
+		Title:   "Previews Open",
+		Summary: "New deployments are accepted.",
+		Footer:  fmt.Sprintf("*Requested by: @%s. Pause them with `/maintenance on <reason>`.*", cmd.User),
+	}
+	if state.Enabled {
+		result.Icon = "🚧"
+		result.Title = "Maintenance Mode On"
+		result.Summary = "New deployments are paused; `/status` and `/cleanup` still work."
+		if state.Reason != "" {
+			result.Summary += "\n\n**Reason:** " + state.Reason
+		}
+		result.Footer = fmt.Sprintf("*Requested by: @%s. Resume with `/maintenance off`.*", cmd.User)
+		if state.Forced {
+			result.Footer = fmt.Sprintf("*Requested by: @%s. MAINTENANCE_MODE is set, so it stays on until the config changes.*", cmd.User)
+		}
+	}
+	return resultResponse(true, result.Title, result, map[string]interface{}{
+		"maintenance": state,
+	})
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// ManifestMutator adapts parsed manifests for preview environments
+type ManifestMutator struct {
+	maxReplicas   int32
+	scalingOptOut map[string]bool
+}
+
+func NewManifestMutator(maxReplicas int32, scalingOptOut []string) *ManifestMutator {
+	if maxReplicas < 1 {
+		maxReplicas = 1
+	}
+
+	optOut := make(map[string]bool)
+	for _, service := range scalingOptOut {
+		optOut[service] = true
+	}
+
+	return &ManifestMutator{
+		maxReplicas:   maxReplicas,
+		scalingOptOut: optOut,
+	}
+}
+
+// Mutate applies preview-specific changes to a parsed manifest and returns a
+// human-readable note for every change made
+func (mm *ManifestMutator) Mutate(serviceName string, parsed *ParsedManifest) []string {
+	var changes []string
+
+	// Services that opted out keep their production scaling
+	if mm.scalingOptOut[serviceName] {
+		return changes
+	}
+
+	// Previews don't need production autoscaling
+	for _, hpa := range parsed.HorizontalPodAutoscalers {
+		changes = append(changes, fmt.Sprintf("Removed HorizontalPodAutoscaler/%s", hpa.Name))
+	}
+	parsed.HorizontalPodAutoscalers = []autoscalingv2.HorizontalPodAutoscaler{}
+
+	// Clamp replica counts (unset replicas default to 1, which never exceeds the clamp)
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		if dep.Spec.Replicas != nil && *dep.Spec.Replicas > mm.maxReplicas {
+			changes = append(changes, fmt.Sprintf("Clamped Deployment/%s replicas from %d to %d", dep.Name, *dep.Spec.Replicas, mm.maxReplicas))
+			dep.Spec.Replicas = int32Ptr(mm.maxReplicas)
+		}
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		if sts.Spec.Replicas != nil && *sts.Spec.Replicas > mm.maxReplicas {
+			changes = append(changes, fmt.Sprintf("Clamped StatefulSet/%s replicas from %d to %d", sts.Name, *sts.Spec.Replicas, mm.maxReplicas))
+			sts.Spec.Replicas = int32Ptr(mm.maxReplicas)
+		}
+	}
+
+	return changes
+}
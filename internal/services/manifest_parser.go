@@ -1,62 +1,160 @@
 package services
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
-	"strings"
+	"io"
+	"os"
 
 	"gopkg.in/yaml.v3"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/client-go/kubernetes/scheme"
+	sigsyaml "sigs.k8s.io/yaml"
 )
 
 type ManifestParser struct {
-	decoder runtime.Decoder
+	decoder   runtime.Decoder
+	decryptor *SopsDecryptor
 }
 
-func NewManifestParser() *ManifestParser {
+func NewManifestParser(decryptor *SopsDecryptor) *ManifestParser {
 	codecFactory := serializer.NewCodecFactory(scheme.Scheme)
 	decoder := codecFactory.UniversalDeserializer()
 
 	return &ManifestParser{
-		decoder: decoder,
+		decoder:   decoder,
+		decryptor: decryptor,
 	}
 }
 
 type ParsedManifest struct {
-	Deployments []appsv1.Deployment `json:"deployments"`
-	Services    []corev1.Service    `json:"services"`
-	ConfigMaps  []corev1.ConfigMap  `json:"configmaps"`
+	Deployments  []appsv1.Deployment  `json:"deployments"`
+	StatefulSets []appsv1.StatefulSet `json:"statefulsets"`
+	Services     []corev1.Service     `json:"services"`
+	ConfigMaps   []corev1.ConfigMap   `json:"configmaps"`
+	Secrets      []corev1.Secret      `json:"secrets"`
+
+	PersistentVolumeClaims   []corev1.PersistentVolumeClaim          `json:"persistent_volume_claims"`
+	HorizontalPodAutoscalers []autoscalingv2.HorizontalPodAutoscaler `json:"horizontal_pod_autoscalers"`
+
+	// otherDocuments holds every other kind, such as CRDs and cluster-scoped
+	// RBAC, still as YAML; see OtherObjects
+	otherDocuments []string
+	other          []unstructured.Unstructured
+}
+
+// OtherCount is how many documents are of kinds the typed deploy doesn't
+// handle
+func (pm *ParsedManifest) OtherCount() int {
+	return len(pm.otherDocuments)
+}
+
+// OtherObjects decodes the documents of other kinds on first use. Most
+// previews skip them, so parsing leaves them as YAML. Like the parser, it
+// warns about and skips documents that don't decode.
+func (pm *ParsedManifest) OtherObjects() []unstructured.Unstructured {
+	if pm.other != nil || len(pm.otherDocuments) == 0 {
+		return pm.other
+	}
+	pm.other = make([]unstructured.Unstructured, 0, len(pm.otherDocuments))
+	for _, document := range pm.otherDocuments {
+		var object unstructured.Unstructured
+		raw, err := sigsyaml.YAMLToJSON([]byte(document))
+		if err == nil {
+			err = object.UnmarshalJSON(raw)
+		}
+		if err != nil {
+			fmt.Printf("Warning: failed to decode document: %v\n", err)
+			continue
+		}
+		pm.other = append(pm.other, object)
+	}
+	return pm.other
 }
 
+// maxManifestDocumentSize bounds a single document of a manifest. The API
+// server stores objects of up to about 1.5MiB, so a larger document couldn't
+// be applied anyway, and parsing stops before buffering all of it.
+const maxManifestDocumentSize = 3 << 20
+
 func (mp *ManifestParser) ParseManifestFile(filePath string) (*ParsedManifest, error) {
-	content, err := ioutil.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read manifest file: %v", err)
 	}
+	defer file.Close()
+
+	return mp.ParseManifestReader(file, filePath)
+}
 
+// ParseManifestContent parses multi-document YAML; filePath only labels messages
+func (mp *ManifestParser) ParseManifestContent(content []byte, filePath string) (*ParsedManifest, error) {
+	return mp.ParseManifestReader(bytes.NewReader(content), filePath)
+}
+
+// ParseManifestReader parses multi-document YAML one document at a time, so
+// a large file is never held in memory whole. Documents are split by the YAML
+// parser itself, so a "---" inside a string or block scalar doesn't end one.
+// A document that parses but doesn't decode is skipped with a warning. A
+// YAML syntax error fails the whole file, since the parser can't find where
+// the next document starts after one, and so does a document that can't be
+// decrypted.
+func (mp *ManifestParser) ParseManifestReader(r io.Reader, filePath string) (*ParsedManifest, error) {
 	parsed := &ParsedManifest{
-		Deployments: []appsv1.Deployment{},
-		Services:    []corev1.Service{},
-		ConfigMaps:  []corev1.ConfigMap{},
+		Deployments:  []appsv1.Deployment{},
+		StatefulSets: []appsv1.StatefulSet{},
+		Services:     []corev1.Service{},
+		ConfigMaps:   []corev1.ConfigMap{},
+		Secrets:      []corev1.Secret{},
+
+		PersistentVolumeClaims:   []corev1.PersistentVolumeClaim{},
+		HorizontalPodAutoscalers: []autoscalingv2.HorizontalPodAutoscaler{},
 	}
 
-	// Split by --- for multi-document YAML
-	documents := strings.Split(string(content), "---")
+	limiter := &documentLimitReader{reader: r, limit: maxManifestDocumentSize}
+	decoder := yaml.NewDecoder(limiter)
+	for index := 1; ; index++ {
+		limiter.read = 0
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			break
+		}
+		if limiter.exceeded {
+			return nil, fmt.Errorf("document %d in %s is larger than %d bytes", index, filePath, maxManifestDocumentSize)
+		}
+		if err != nil {
+			// A syntax error leaves the parser lost, so the rest can't be read
+			return nil, fmt.Errorf("failed to parse %s: %v", filePath, err)
+		}
+		if len(node.Content) == 0 || node.Content[0].Tag == "!!null" {
+			continue // empty or comment-only document
+		}
+
+		line := node.Content[0].Line
+		doc, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document at line %d in %s: %v", line, filePath, err)
+		}
 
-	for _, doc := range documents {
-		doc = strings.TrimSpace(doc)
-		if doc == "" {
-			continue
+		// Decrypt SOPS-encrypted documents before decoding
+		if mp.decryptor != nil && mp.decryptor.IsEncrypted(doc) {
+			plaintext, err := mp.decryptor.Decrypt(doc)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt document at line %d in %s: %v", line, filePath, err)
+			}
+			doc = plaintext
 		}
 
-		err := mp.parseDocument(doc, parsed)
+		err = mp.parseDocument(string(doc), parsed)
 		if err != nil {
 			// Log warning but continue parsing other documents
-			fmt.Printf("Warning: failed to parse document in %s: %v\n", filePath, err)
+			fmt.Printf("Warning: failed to parse document at line %d in %s: %v\n", line, filePath, err)
 			continue
 		}
 	}
@@ -64,6 +162,29 @@ func (mp *ManifestParser) ParseManifestFile(filePath string) (*ParsedManifest, e
 	return parsed, nil
 }
 
+// documentLimitReader fails a read once more than limit bytes were read since
+// read was last reset. The YAML decoder reads ahead by at most a small buffer,
+// so counting per Decode bounds each document's size closely enough.
+type documentLimitReader struct {
+	reader   io.Reader
+	limit    int
+	read     int
+	exceeded bool
+}
+
+func (lr *documentLimitReader) Read(p []byte) (int, error) {
+	if lr.read >= lr.limit {
+		lr.exceeded = true
+		return 0, fmt.Errorf("document larger than %d bytes", lr.limit)
+	}
+	if remaining := lr.limit - lr.read; len(p) > remaining {
+		p = p[:remaining]
+	}
+	n, err := lr.reader.Read(p)
+	lr.read += n
+	return n, err
+}
+
 func (mp *ManifestParser) parseDocument(content string, parsed *ParsedManifest) error {
 	// First parse as generic to check kind
 	var obj map[string]interface{}
@@ -87,6 +208,14 @@ func (mp *ManifestParser) parseDocument(content string, parsed *ParsedManifest)
 		}
 		parsed.Deployments = append(parsed.Deployments, *objRuntime.(*appsv1.Deployment))
 
+	case "StatefulSet":
+		var statefulSet appsv1.StatefulSet
+		objRuntime, _, err := mp.decoder.Decode([]byte(content), nil, &statefulSet)
+		if err != nil {
+			return fmt.Errorf("failed to decode statefulset: %v", err)
+		}
+		parsed.StatefulSets = append(parsed.StatefulSets, *objRuntime.(*appsv1.StatefulSet))
+
 	case "Service":
 		var service corev1.Service
 		objRuntime, _, err := mp.decoder.Decode([]byte(content), nil, &service)
@@ -103,9 +232,38 @@ func (mp *ManifestParser) parseDocument(content string, parsed *ParsedManifest)
 		}
 		parsed.ConfigMaps = append(parsed.ConfigMaps, *objRuntime.(*corev1.ConfigMap))
 
+	case "Secret":
+		var secret corev1.Secret
+		objRuntime, _, err := mp.decoder.Decode([]byte(content), nil, &secret)
+		if err != nil {
+			return fmt.Errorf("failed to decode secret: %v", err)
+		}
+		parsed.Secrets = append(parsed.Secrets, *objRuntime.(*corev1.Secret))
+
+	case "PersistentVolumeClaim":
+		var pvc corev1.PersistentVolumeClaim
+		objRuntime, _, err := mp.decoder.Decode([]byte(content), nil, &pvc)
+		if err != nil {
+			return fmt.Errorf("failed to decode persistentvolumeclaim: %v", err)
+		}
+		parsed.PersistentVolumeClaims = append(parsed.PersistentVolumeClaims, *objRuntime.(*corev1.PersistentVolumeClaim))
+
+	case "HorizontalPodAutoscaler":
+		var hpa autoscalingv2.HorizontalPodAutoscaler
+		objRuntime, _, err := mp.decoder.Decode([]byte(content), nil, &hpa)
+		if err != nil {
+			return fmt.Errorf("failed to decode horizontalpodautoscaler: %v", err)
+		}
+		decoded, ok := objRuntime.(*autoscalingv2.HorizontalPodAutoscaler)
+		if !ok {
+			return fmt.Errorf("unsupported horizontalpodautoscaler version: %v", obj["apiVersion"])
+		}
+		parsed.HorizontalPodAutoscalers = append(parsed.HorizontalPodAutoscalers, *decoded)
+
 	default:
-		// Skip unsupported resource types
-		fmt.Printf("Skipping unsupported resource type: %s\n", kind)
+		// Keep other kinds for operator bundles and virtual clusters;
+		// namespace previews skip them
+		parsed.otherDocuments = append(parsed.otherDocuments, content)
 	}
 
 	return nil
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseManifestReader(t *testing.T) {
+	// Stands in for sops: replaces encrypted values and drops the metadata
+	fakeSops := filepath.Join(t.TempDir(), "sops")
+	script := "#!/bin/sh\nsed -e 's/ENC\\[[^]]*\\]/decrypted/' -e '/^sops:/,$d'\n"
+	if err := os.WriteFile(fakeSops, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := "apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\nstringData:\n  password: ENC[AES256_GCM,data:abc,type:str]\nsops:\n  version: 3.8.1\n"
+
+	tests := []struct {
+		name       string
+		manifest   string
+		decryptor  *SopsDecryptor
+		configMaps int
+		secrets    int
+		services   int
+		other      int
+		check      func(t *testing.T, parsed *ParsedManifest)
+		err        string
+	}{
+		{
+			name:       "documents",
+			manifest:   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: Service\nmetadata:\n  name: b\n",
+			configMaps: 1,
+			services:   1,
+		},
+		{
+			name:       "separator inside a block scalar",
+			manifest:   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: script\ndata:\n  run.sh: |\n    echo start\n    ---\n    echo end\n",
+			configMaps: 1,
+			check: func(t *testing.T, parsed *ParsedManifest) {
+				if script := parsed.ConfigMaps[0].Data["run.sh"]; !strings.Contains(script, "---\necho end") {
+					t.Errorf("run.sh = %q, want the separator kept", script)
+				}
+			},
+		},
+		{
+			name:       "separator inside a quoted string",
+			manifest:   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: quoted\ndata:\n  text: \"a\n\n  ---\n\n  b\"\n",
+			configMaps: 1,
+		},
+		{
+			name:       "empty and comment-only documents",
+			manifest:   "---\n# nothing here\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\n",
+			configMaps: 1,
+		},
+		{
+			name:     "other kinds kept undecoded",
+			manifest: "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\n",
+			other:    1,
+			check: func(t *testing.T, parsed *ParsedManifest) {
+				if objects := parsed.OtherObjects(); len(objects) != 1 || objects[0].GetName() != "widgets.example.com" {
+					t.Errorf("OtherObjects() = %v, want the CRD", objects)
+				}
+			},
+		},
+		{
+			name:       "document that doesn't decode is skipped",
+			manifest:   "apiVersion: v1\nkind: Service\nmetadata:\n  name: bad\nspec:\n  ports: 80\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+			configMaps: 1,
+		},
+		{
+			name:     "syntax error fails the file",
+			manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\nkind: [unclosed\n",
+			err:      "failed to parse",
+		},
+		{
+			name:     "document over the size limit",
+			manifest: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: big\ndata:\n  blob: " + strings.Repeat("a", maxManifestDocumentSize) + "\n",
+			err:      "is larger than",
+		},
+		{
+			name:      "sops round trip",
+			manifest:  encrypted,
+			decryptor: NewSopsDecryptor(fakeSops, "/dev/null", ""),
+			secrets:   1,
+			check: func(t *testing.T, parsed *ParsedManifest) {
+				if password := parsed.Secrets[0].StringData["password"]; password != "decrypted" {
+					t.Errorf("password = %q, want the decrypted value", password)
+				}
+			},
+		},
+		{
+			name:      "sops without a key fails the file",
+			manifest:  encrypted,
+			decryptor: NewSopsDecryptor(fakeSops, "", ""),
+			err:       "no SOPS key configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.decryptor != nil && runtime.GOOS == "windows" {
+				t.Skip("the fake sops is a shell script")
+			}
+			parsed, err := NewManifestParser(tt.decryptor).ParseManifestContent([]byte(tt.manifest), "test.yaml")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseManifestContent() error = %v, want it to contain %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseManifestContent() error = %v", err)
+			}
+			if len(parsed.ConfigMaps) != tt.configMaps || len(parsed.Secrets) != tt.secrets || len(parsed.Services) != tt.services || parsed.OtherCount() != tt.other {
+				t.Fatalf("ParseManifestContent() = %d configmaps, %d secrets, %d services, %d other, want %d, %d, %d and %d",
+					len(parsed.ConfigMaps), len(parsed.Secrets), len(parsed.Services), parsed.OtherCount(), tt.configMaps, tt.secrets, tt.services, tt.other)
+			}
+			if tt.check != nil {
+				tt.check(t, parsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pr-previews/internal/config"
+)
+
+// statsdMaxPacket keeps UDP datagrams under a typical MTU
+const statsdMaxPacket = 1432
+
+// MetricsSink is a push-based metrics backend for operators who don't scrape
+// /metrics
+type MetricsSink interface {
+	Name() string
+	Push(ctx context.Context, gauges map[string]float64) error
+}
+
+// NewMetricsSinks returns the sinks configured via METRICS_* env vars
+func NewMetricsSinks(cfg *config.Config) []MetricsSink {
+	var sinks []MetricsSink
+	if cfg.Metrics.PushgatewayURL != "" {
+		sinks = append(sinks, NewPushgatewaySink(cfg.Metrics.PushgatewayURL, cfg.Metrics.PushJob))
+	}
+	if cfg.Metrics.StatsDAddr != "" {
+		sinks = append(sinks, &StatsDSink{
+			addr:   cfg.Metrics.StatsDAddr,
+			prefix: cfg.Metrics.StatsDPrefix,
+			tags:   cfg.Metrics.StatsDTags,
+		})
+	}
+	return sinks
+}
+
+// StartMetricsPusher pushes collect's gauges to every sink each interval
+// until ctx is cancelled
+func StartMetricsPusher(ctx context.Context, sinks []MetricsSink, interval time.Duration, collect func(context.Context) map[string]float64) {
+	if len(sinks) == 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			gauges := collect(ctx)
+			for _, sink := range sinks {
+				if err := sink.Push(ctx, gauges); err != nil {
+					fmt.Printf("Warning: failed to push metrics to %s: %v\n", sink.Name(), err)
+				}
+			}
+		}
+	}
+}
+
+// PushgatewaySink replaces this instance's group on a Prometheus pushgateway
+type PushgatewaySink struct {
+	url    string
+	client *http.Client
+}
+
+func NewPushgatewaySink(baseURL, job string) *PushgatewaySink {
+	instance, err := os.Hostname()
+	if err != nil || instance == "" {
+		instance = "unknown"
+	}
+	return &PushgatewaySink{
+		url:    fmt.Sprintf("%s/metrics/job/%s/instance/%s", baseURL, url.PathEscape(job), url.PathEscape(instance)),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *PushgatewaySink) Name() string { return "pushgateway" }
+
+func (s *PushgatewaySink) Push(ctx context.Context, gauges map[string]float64) error {
+	var body bytes.Buffer
+	WritePrometheusGauges(&body, gauges)
+
+	// PUT replaces every metric in the group, so stale ones don't linger
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// StatsDSink sends gauges over UDP, with DogStatsD tags when configured
+type StatsDSink struct {
+	addr   string
+	prefix string
+	tags   []string
+}
+
+func (s *StatsDSink) Name() string { return "statsd" }
+
+func (s *StatsDSink) Push(ctx context.Context, gauges map[string]float64) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	suffix := "|g"
+	if len(s.tags) > 0 {
+		suffix += "|#" + strings.Join(s.tags, ",")
+	}
+
+	var packet bytes.Buffer
+	for _, name := range sortedMetricNames(gauges) {
+		line := fmt.Sprintf("%s%s:%g%s", s.prefix, name, gauges[name], suffix)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if _, err := conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		_, err = conn.Write(packet.Bytes())
+	}
+	return err
+}
+
+// WritePrometheusGauges writes gauges in the Prometheus text format, prefixed
+// with pr_previews_
+func WritePrometheusGauges(w io.Writer, gauges map[string]float64) {
+	for _, name := range sortedMetricNames(gauges) {
+		metric := "pr_previews_" + name
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", metric, metric, gauges[name])
+	}
+}
+
+func sortedMetricNames(gauges map[string]float64) []string {
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
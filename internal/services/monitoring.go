@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MonitoringLinks renders per-preview dashboard links from URL templates
+type MonitoringLinks struct {
+	grafanaTemplate    string
+	prometheusTemplate string
+}
+
+func NewMonitoringLinks(grafanaTemplate, prometheusTemplate string) *MonitoringLinks {
+	return &MonitoringLinks{
+		grafanaTemplate:    grafanaTemplate,
+		prometheusTemplate: prometheusTemplate,
+	}
+}
+
+// Enabled reports whether a monitoring stack is configured
+func (ml *MonitoringLinks) Enabled() bool {
+	return ml.grafanaTemplate != "" || ml.prometheusTemplate != ""
+}
+
+// Links returns the rendered URLs keyed by tool name
+func (ml *MonitoringLinks) Links(namespace, service string, prNumber int) map[string]string {
+	links := make(map[string]string)
+	if ml.grafanaTemplate != "" {
+		links["grafana"] = ml.render(ml.grafanaTemplate, namespace, service, prNumber)
+	}
+	if ml.prometheusTemplate != "" {
+		links["prometheus"] = ml.render(ml.prometheusTemplate, namespace, service, prNumber)
+	}
+	return links
+}
+
+// Markdown returns the links as a comment line, or "" when not configured
+func (ml *MonitoringLinks) Markdown(namespace, service string, prNumber int) string {
+	links := ml.Links(namespace, service, prNumber)
+
+	var parts []string
+	if link, ok := links["grafana"]; ok {
+		parts = append(parts, fmt.Sprintf("[Grafana dashboard](%s)", link))
+	}
+	if link, ok := links["prometheus"]; ok {
+		parts = append(parts, fmt.Sprintf("[Prometheus](%s)", link))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("- **📈 Metrics:** %s\n", strings.Join(parts, " · "))
+}
+
+func (ml *MonitoringLinks) render(template, namespace, service string, prNumber int) string {
+	return renderURLTemplate(template, namespace, service, prNumber)
+}
+
+// renderURLTemplate substitutes {namespace}, {service} and {pr} in a link template
+func renderURLTemplate(template, namespace, service string, prNumber int) string {
+	replacer := strings.NewReplacer(
+		"{namespace}", url.QueryEscape(namespace),
+		"{service}", url.QueryEscape(service),
+		"{pr}", fmt.Sprintf("%d", prNumber),
+	)
+	return replacer.Replace(template)
+}
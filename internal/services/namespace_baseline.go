@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Objects the baseline puts into every preview namespace
+const (
+	baselineQuotaName      = "preview-quota"
+	baselineLimitRangeName = "preview-defaults"
+	baselinePolicyName     = "preview-isolation"
+)
+
+// Requests given to containers that set none, since a quota on requests
+// makes the API server reject pods without them
+var (
+	baselineDefaultCPU    = resource.MustParse("100m")
+	baselineDefaultMemory = resource.MustParse("128Mi")
+)
+
+// NamespaceBaseline is what every preview namespace gets before anything is
+// deployed into it: a quota, isolation from other previews and the registry
+// pull secret, plus the operator's labels and annotations. Fresh and warm
+// pool namespaces get the same baseline. The zero value applies nothing.
+type NamespaceBaseline struct {
+	QuotaCPU    *resource.Quantity
+	QuotaMemory *resource.Quantity
+	Isolation   bool
+
+	PullSecretNamespace string
+	PullSecretName      string
+
+	// Labels and Annotations go on every preview namespace and workload,
+	// e.g. cost-center or istio-injection
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// NewNamespaceBaseline validates NAMESPACE_QUOTA_CPU, NAMESPACE_QUOTA_MEMORY,
+// NAMESPACE_PULL_SECRET, NAMESPACE_LABELS and NAMESPACE_ANNOTATIONS
+func NewNamespaceBaseline(quotaCPU, quotaMemory string, isolation bool, pullSecret string, labels, annotations []string) (*NamespaceBaseline, error) {
+	baseline := &NamespaceBaseline{Isolation: isolation}
+
+	if quotaCPU != "" {
+		quantity, err := resource.ParseQuantity(quotaCPU)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace CPU quota %q: %v", quotaCPU, err)
+		}
+		baseline.QuotaCPU = &quantity
+	}
+	if quotaMemory != "" {
+		quantity, err := resource.ParseQuantity(quotaMemory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace memory quota %q: %v", quotaMemory, err)
+		}
+		baseline.QuotaMemory = &quantity
+	}
+
+	if pullSecret != "" {
+		namespace, name, ok := strings.Cut(pullSecret, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("pull secret %q must be namespace/name", pullSecret)
+		}
+		baseline.PullSecretNamespace, baseline.PullSecretName = namespace, name
+	}
+
+	var err error
+	if baseline.Labels, err = parseMetadataPairs(labels); err != nil {
+		return nil, fmt.Errorf("invalid namespace labels: %v", err)
+	}
+	if baseline.Annotations, err = parseMetadataPairs(annotations); err != nil {
+		return nil, fmt.Errorf("invalid namespace annotations: %v", err)
+	}
+	if err := validateMetadata(baseline.Labels, baseline.Annotations); err != nil {
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// SetNamespaceBaseline makes CreateNamespace, EnsureSharedNamespace and the
+// warm pool prepare namespaces with baseline
+func (k *K8sService) SetNamespaceBaseline(baseline *NamespaceBaseline) {
+	k.baseline = baseline
+}
+
+// applyNamespaceBaseline creates or refreshes the baseline objects, so it is
+// safe to run on a namespace that already has them
+func (k *K8sService) applyNamespaceBaseline(ctx context.Context, namespace string) error {
+	return k.applyBaseline(ctx, namespace, nil)
+}
+
+// applyBaseline applies the baseline, letting the peers through the
+// isolation policy besides the namespace's own pods and anything outside a
+// preview
+func (k *K8sService) applyBaseline(ctx context.Context, namespace string, peers []networkingv1.NetworkPolicyPeer) error {
+	baseline := k.baseline
+	if baseline == nil {
+		return nil
+	}
+	// Labels such as istio-injection must be there before the first pod
+	if len(baseline.Labels) > 0 || len(baseline.Annotations) > 0 {
+		if err := k.AnnotateNamespace(ctx, namespace, baseline.Labels, baseline.Annotations); err != nil {
+			return err
+		}
+	}
+
+	labels := map[string]string{"managed-by": "pr-previews"}
+
+	if baseline.QuotaCPU != nil || baseline.QuotaMemory != nil {
+		quota := &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: baselineQuotaName, Namespace: namespace, Labels: labels},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{}},
+		}
+		if baseline.QuotaCPU != nil {
+			quota.Spec.Hard[corev1.ResourceRequestsCPU] = *baseline.QuotaCPU
+		}
+		if baseline.QuotaMemory != nil {
+			quota.Spec.Hard[corev1.ResourceRequestsMemory] = *baseline.QuotaMemory
+		}
+		_, err := k.client.CoreV1().ResourceQuotas(namespace).Create(ctx, quota, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = k.client.CoreV1().ResourceQuotas(namespace).Update(ctx, quota, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply quota in %s: %v", namespace, err)
+		}
+
+		limits := &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: baselineLimitRangeName, Namespace: namespace, Labels: labels},
+			Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type: corev1.LimitTypeContainer,
+				DefaultRequest: corev1.ResourceList{
+					corev1.ResourceCPU:    baselineDefaultCPU,
+					corev1.ResourceMemory: baselineDefaultMemory,
+				},
+			}}},
+		}
+		_, err = k.client.CoreV1().LimitRanges(namespace).Create(ctx, limits, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = k.client.CoreV1().LimitRanges(namespace).Update(ctx, limits, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply default requests in %s: %v", namespace, err)
+		}
+	}
+
+	if baseline.Isolation {
+		// Pods in the namespace and anything outside a preview (the ingress
+		// controller, monitoring) may connect; other previews may not
+		from := append([]networkingv1.NetworkPolicyPeer{
+			{PodSelector: &metav1.LabelSelector{}},
+			{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+				Key: "preview", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"true"},
+			}}}},
+		}, peers...)
+		policy := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: baselinePolicyName, Namespace: namespace, Labels: labels},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				Ingress:     []networkingv1.NetworkPolicyIngressRule{{From: from}},
+			},
+		}
+		_, err := k.client.NetworkingV1().NetworkPolicies(namespace).Create(ctx, policy, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			_, err = k.client.NetworkingV1().NetworkPolicies(namespace).Update(ctx, policy, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply network policy in %s: %v", namespace, err)
+		}
+	}
+
+	if baseline.PullSecretName != "" {
+		if err := k.copyPullSecret(ctx, namespace, baseline.PullSecretNamespace, baseline.PullSecretName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPullSecret copies the registry secret into the namespace and adds it to
+// the default ServiceAccount, so manifests pull private images without
+// naming it
+func (k *K8sService) copyPullSecret(ctx context.Context, namespace, sourceNamespace, name string) error {
+	source, err := k.client.CoreV1().Secrets(sourceNamespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read pull secret %s/%s: %v", sourceNamespace, name, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"managed-by": "pr-previews"}},
+		Type:       source.Type,
+		Data:       source.Data,
+	}
+	_, err = k.client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = k.client.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy pull secret into %s: %v", namespace, err)
+	}
+
+	// The ServiceAccount controller may not have created "default" yet; it
+	// leaves one that already exists alone
+	reference := corev1.LocalObjectReference{Name: name}
+	account, err := k.client.CoreV1().ServiceAccounts(namespace).Get(ctx, "default", metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		account = &corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: namespace},
+			ImagePullSecrets: []corev1.LocalObjectReference{reference},
+		}
+		_, err = k.client.CoreV1().ServiceAccounts(namespace).Create(ctx, account, metav1.CreateOptions{})
+		if !apierrors.IsAlreadyExists(err) {
+			if err != nil {
+				return fmt.Errorf("failed to create default service account in %s: %v", namespace, err)
+			}
+			return nil
+		}
+		account, err = k.client.CoreV1().ServiceAccounts(namespace).Get(ctx, "default", metav1.GetOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get default service account in %s: %v", namespace, err)
+	}
+
+	for _, existing := range account.ImagePullSecrets {
+		if existing.Name == name {
+			return nil
+		}
+	}
+	account.ImagePullSecrets = append(account.ImagePullSecrets, reference)
+	if _, err := k.client.CoreV1().ServiceAccounts(namespace).Update(ctx, account, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to add pull secret to the default service account in %s: %v", namespace, err)
+	}
+	return nil
+}
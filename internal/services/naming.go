@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"pr-previews/internal/types"
+)
+
+const maxDNSLabelLength = 63
+
+// Namespace layouts, see RepoConfig.Namespace
+const (
+	NamespacePerService = "per-service"
+	NamespaceShared     = "shared"
+)
+
+// previewNamespace names the namespace a PR's service deploys into
+func previewNamespace(prNumber int, cleanServiceName string, shared bool) string {
+	if shared {
+		return fmt.Sprintf("preview-pr-%d", prNumber)
+	}
+	return fmt.Sprintf("preview-pr-%d-%s", prNumber, cleanServiceName)
+}
+
+// serviceAnnotation keys a namespace annotation to one service, since a
+// shared namespace holds the host and path of several
+func serviceAnnotation(key, service string, shared bool) string {
+	if shared {
+		return key + "." + service
+	}
+	return key
+}
+
+var nonDNSLabelChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// SlugifyBranch turns a branch name into a DNS label, e.g.
+// "Feature/Login_Page" -> "feature-login-page"
+func SlugifyBranch(branch string) string {
+	slug := strings.ToLower(branch)
+	slug = nonDNSLabelChars.ReplaceAllString(slug, "-")
+	slug = strings.Trim(slug, "-")
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+
+	// Keep long names unique by replacing the tail with a short hash
+	if len(slug) > maxDNSLabelLength {
+		sum := sha256.Sum256([]byte(branch))
+		slug = strings.TrimRight(slug[:maxDNSLabelLength-7], "-") + "-" + hex.EncodeToString(sum[:])[:6]
+	}
+
+	return slug
+}
+
+// previewHostLabel picks the preview subdomain. In branch mode the label is
+// sticky per branch; another branch that slugifies or truncates to the same
+// label gets a short hash of its full name appended instead.
+func (cs *CommandServiceK8s) previewHostLabel(ctx context.Context, cmd *types.Command, cleanServiceName string) (string, error) {
+	prLabel := fmt.Sprintf("pr-%d-%s", cmd.PRNumber, cleanServiceName)
+	if cs.config.Preview.NamingMode != "branch" || cmd.Branch == "" {
+		return prLabel, nil
+	}
+
+	slug := SlugifyBranch(cmd.Branch)
+	if slug == "" {
+		return prLabel, nil
+	}
+
+	holders, err := cs.k8s.GetNamespacesByHost(ctx, slug)
+	if err != nil {
+		return "", err
+	}
+	for _, holder := range holders {
+		if holder["branch"] != cmd.Branch {
+			sum := sha256.Sum256([]byte(cmd.Branch))
+			suffix := "-" + hex.EncodeToString(sum[:])[:6]
+			if len(slug)+len(suffix) > maxDNSLabelLength {
+				slug = strings.TrimRight(slug[:maxDNSLabelLength-len(suffix)], "-")
+			}
+			return slug + suffix, nil
+		}
+	}
+
+	return slug, nil
+}
+
+// previewHostData is what custom host templates can reference
+type previewHostData struct {
+	PR      int
+	Service string
+	Branch  string
+	Label   string
+	Domain  string
+}
+
+var validHostname = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+func (d ServiceDomain) validate() error {
+	if d.Host != "" {
+		if _, err := template.New("host").Option("missingkey=error").Parse(d.Host); err != nil {
+			return fmt.Errorf("invalid host template: %v", err)
+		}
+	}
+	if d.Path != "" && !strings.HasPrefix(d.Path, "/") {
+		return fmt.Errorf("path %q must start with /", d.Path)
+	}
+	for _, route := range d.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("route path %q must start with /", route.Path)
+		}
+		if route.Service == "" || route.Port <= 0 {
+			return fmt.Errorf("route %s needs a service and a port", route.Path)
+		}
+	}
+	return nil
+}
+
+// renderHost fills in the host template
+func (d ServiceDomain) renderHost(data previewHostData) (string, error) {
+	tmpl, err := template.New("host").Option("missingkey=error").Parse(d.Host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host template: %v", err)
+	}
+	var host strings.Builder
+	if err := tmpl.Execute(&host, data); err != nil {
+		return "", fmt.Errorf("failed to render host template: %v", err)
+	}
+	rendered := strings.ToLower(strings.TrimSpace(host.String()))
+	if !validHostname.MatchString(rendered) {
+		return "", fmt.Errorf("host template rendered %q, which is not a valid hostname", rendered)
+	}
+	return rendered, nil
+}
+
+// checkCustomHost refuses a host rendered from a repo's host template unless
+// it's under the preview domain or one of PREVIEW_HOST_DOMAINS, since the
+// template comes from the PR, and refuses hosts another namespace's Ingress
+// already serves, such as another preview's or a production name
+func (cs *CommandServiceK8s) checkCustomHost(ctx context.Context, namespace, host string) error {
+	allowed := false
+	for _, domain := range append([]string{cs.config.Preview.Domain}, cs.config.Preview.HostDomains...) {
+		domain = strings.ToLower(strings.Trim(domain, ". "))
+		if domain != "" && strings.HasSuffix(host, "."+domain) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("host %s is not under %s; ask the operators to add its domain to PREVIEW_HOST_DOMAINS", host, cs.config.Preview.Domain)
+	}
+
+	holders, err := cs.k8s.IngressHostHolders(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, holder := range holders {
+		if holder != namespace {
+			return fmt.Errorf("host %s is already served by an Ingress in namespace %s", host, holder)
+		}
+	}
+	return nil
+}
+
+// exposePreview creates the preview Ingress and records the host on the
+// namespace. A domains entry in .pr-previews.yaml can replace the host and
+// serve the preview under a path, with extra routes to other Services; the
+// final URLs of those routes are returned alongside the preview URL. In a
+// shared namespace each service gets its own Ingress and annotations.
+func (cs *CommandServiceK8s) exposePreview(ctx context.Context, cmd *types.Command, namespace, cleanServiceName, targetService string, port int32, domain *ServiceDomain, shared bool) (string, []string, error) {
+	label, err := cs.previewHostLabel(ctx, cmd, cleanServiceName)
+	if err != nil {
+		return "", nil, err
+	}
+	host := fmt.Sprintf("%s.%s", label, cs.config.Preview.Domain)
+	labels := map[string]string{"preview-host": label}
+	path := "/"
+	var extra []IngressPath
+
+	if domain != nil {
+		if domain.Host != "" {
+			host, err = domain.renderHost(previewHostData{
+				PR:      cmd.PRNumber,
+				Service: cleanServiceName,
+				Branch:  SlugifyBranch(cmd.Branch),
+				Label:   label,
+				Domain:  cs.config.Preview.Domain,
+			})
+			if err != nil {
+				return "", nil, err
+			}
+			if err := cs.checkCustomHost(ctx, namespace, host); err != nil {
+				return "", nil, err
+			}
+		}
+		if domain.Path != "" {
+			path = domain.Path
+		}
+		for _, route := range domain.Routes {
+			extra = append(extra, IngressPath{Path: route.Path, Service: route.Service, Port: route.Port})
+		}
+	}
+
+	ingressName := "preview"
+	if shared {
+		// The namespace-wide host label can only describe one service
+		ingressName, labels = "preview-"+cleanServiceName, nil
+	}
+
+	paths := append([]IngressPath{{Path: path, Service: targetService, Port: port}}, extra...)
+	err = cs.k8s.CreatePreviewIngress(ctx, namespace, ingressName, host, cs.config.Preview.IngressClass, paths, cs.accessMirrorAnnotations(namespace))
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = cs.k8s.AnnotateNamespace(ctx, namespace, labels, map[string]string{
+		"pr-previews.io/branch": cmd.Branch,
+		serviceAnnotation("pr-previews.io/host", cleanServiceName, shared): host,
+		serviceAnnotation("pr-previews.io/path", cleanServiceName, shared): path,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var routes []string
+	for _, route := range extra {
+		routes = append(routes, fmt.Sprintf("https://%s%s → `%s:%d`", host, route.Path, route.Service, route.Port))
+	}
+	return "https://" + host + strings.TrimSuffix(path, "/"), routes, nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/types"
+)
+
+// IPFamilyConfig is the IP family setup applied to every Service previews
+// create. The zero value leaves Services at the cluster default.
+type IPFamilyConfig struct {
+	Policy   corev1.IPFamilyPolicy
+	Families []corev1.IPFamily
+}
+
+// NewIPFamilyConfig validates SERVICE_IP_FAMILY_POLICY and
+// SERVICE_IP_FAMILIES. Two families without a policy mean PreferDualStack,
+// since Kubernetes rejects them on a SingleStack Service.
+func NewIPFamilyConfig(policy string, families []string) (*IPFamilyConfig, error) {
+	cfg := &IPFamilyConfig{}
+
+	switch strings.ToLower(policy) {
+	case "":
+	case "singlestack":
+		cfg.Policy = corev1.IPFamilyPolicySingleStack
+	case "preferdualstack":
+		cfg.Policy = corev1.IPFamilyPolicyPreferDualStack
+	case "requiredualstack":
+		cfg.Policy = corev1.IPFamilyPolicyRequireDualStack
+	default:
+		return nil, fmt.Errorf("unknown IP family policy %q (use SingleStack, PreferDualStack or RequireDualStack)", policy)
+	}
+
+	for _, family := range families {
+		var f corev1.IPFamily
+		switch strings.ToLower(family) {
+		case "ipv4":
+			f = corev1.IPv4Protocol
+		case "ipv6":
+			f = corev1.IPv6Protocol
+		default:
+			return nil, fmt.Errorf("unknown IP family %q (use IPv4 or IPv6)", family)
+		}
+		for _, existing := range cfg.Families {
+			if existing == f {
+				return nil, fmt.Errorf("IP family %s is listed twice", f)
+			}
+		}
+		cfg.Families = append(cfg.Families, f)
+	}
+
+	if len(cfg.Families) == 2 && cfg.Policy == "" {
+		cfg.Policy = corev1.IPFamilyPolicyPreferDualStack
+	}
+	if cfg.Policy == corev1.IPFamilyPolicySingleStack && len(cfg.Families) > 1 {
+		return nil, fmt.Errorf("SingleStack Services take one IP family, got %d", len(cfg.Families))
+	}
+	if cfg.Policy == corev1.IPFamilyPolicyRequireDualStack && len(cfg.Families) == 1 {
+		return nil, fmt.Errorf("RequireDualStack needs both IP families or none")
+	}
+	return cfg, nil
+}
+
+// String describes the setup for comments, e.g. "PreferDualStack (IPv6, IPv4)"
+func (c *IPFamilyConfig) String() string {
+	if c == nil || (c.Policy == "" && len(c.Families) == 0) {
+		return "cluster default"
+	}
+	families := make([]string, len(c.Families))
+	for i, f := range c.Families {
+		families[i] = string(f)
+	}
+	if c.Policy == "" {
+		return strings.Join(families, ", ")
+	}
+	if len(families) == 0 {
+		return string(c.Policy)
+	}
+	return fmt.Sprintf("%s (%s)", c.Policy, strings.Join(families, ", "))
+}
+
+// apply sets the families on a Service unless its manifest already chose
+// them. ExternalName Services have no IPs and are left alone.
+func (c *IPFamilyConfig) apply(spec *corev1.ServiceSpec) {
+	if c == nil || spec.Type == corev1.ServiceTypeExternalName {
+		return
+	}
+	if spec.IPFamilyPolicy == nil && c.Policy != "" {
+		policy := c.Policy
+		spec.IPFamilyPolicy = &policy
+	}
+	if len(spec.IPFamilies) == 0 && len(c.Families) > 0 {
+		spec.IPFamilies = append([]corev1.IPFamily(nil), c.Families...)
+	}
+}
+
+// required lists the families every preview Service gets, which the
+// ingress controller must be able to reach
+func (c *IPFamilyConfig) required() []corev1.IPFamily {
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Policy == corev1.IPFamilyPolicyRequireDualStack:
+		return []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol}
+	case len(c.Families) > 0 && c.Policy != corev1.IPFamilyPolicyPreferDualStack:
+		return c.Families
+	case len(c.Families) > 0:
+		// PreferDualStack only guarantees the primary family
+		return c.Families[:1]
+	}
+	return nil
+}
+
+// SetIPFamilies makes CreateService and manifest deploys use cfg
+func (k *K8sService) SetIPFamilies(cfg *IPFamilyConfig) {
+	k.ipFamilies = cfg
+}
+
+// CheckIngressFamilies compares the preview IP families with the ingress
+// controller Service ("namespace/name"). It returns a warning for each
+// family previews need that the controller can't serve; an empty result
+// means compatible or nothing to check.
+func (k *K8sService) CheckIngressFamilies(ctx context.Context, ingressService string) ([]string, error) {
+	required := k.ipFamilies.required()
+	if ingressService == "" || len(required) == 0 {
+		return nil, nil
+	}
+
+	namespace, name, ok := strings.Cut(ingressService, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("ingress service %q must be namespace/name", ingressService)
+	}
+	service, err := k.client.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingress service %s: %v", ingressService, err)
+	}
+
+	var warnings []string
+	for _, family := range required {
+		found := false
+		for _, served := range service.Spec.IPFamilies {
+			found = found || served == family
+		}
+		if !found {
+			warnings = append(warnings, fmt.Sprintf("Ingress controller `%s` has no %s address, but previews use %s; preview URLs may not reach their Services", ingressService, family, k.ipFamilies))
+		}
+	}
+	return warnings, nil
+}
+
+// ipFamilyOf classifies an address, returning "" for anything unparseable
+// such as "None" on headless Services
+func ipFamilyOf(ip string) corev1.IPFamily {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return ""
+	case parsed.To4() != nil:
+		return corev1.IPv4Protocol
+	default:
+		return corev1.IPv6Protocol
+	}
+}
+
+// clusterIPsByFamily groups a Service's assigned IPs by family
+func clusterIPsByFamily(spec corev1.ServiceSpec) map[string][]string {
+	ips := spec.ClusterIPs
+	if len(ips) == 0 && spec.ClusterIP != "" {
+		ips = []string{spec.ClusterIP}
+	}
+	byFamily := map[string][]string{}
+	for _, ip := range ips {
+		if family := ipFamilyOf(ip); family != "" {
+			byFamily[string(family)] = append(byFamily[string(family)], ip)
+		}
+	}
+	return byFamily
+}
+
+// clusterIPFields are the /status fields for a Service's IPs, one per family
+func clusterIPFields(byFamily map[string][]string) []types.Field {
+	if len(byFamily) == 0 {
+		return []types.Field{{Name: "Service IP", Value: "none"}}
+	}
+	var fields []types.Field
+	for _, family := range []corev1.IPFamily{corev1.IPv4Protocol, corev1.IPv6Protocol} {
+		if ips, ok := byFamily[string(family)]; ok {
+			fields = append(fields, types.Field{Name: fmt.Sprintf("Service IP (%s)", family), Value: strings.Join(ips, ", ")})
+		}
+	}
+	return fields
+}
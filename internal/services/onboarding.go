@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// onboardingBranch is where the suggested config is proposed
+const onboardingBranch = "pr-previews/onboarding"
+
+// Outcomes of an onboarding check. Only failures keep a repo from being
+// ready; warnings are worth fixing but previews work without.
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// OnboardingCheck is one readiness check of a repository
+type OnboardingCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// OnboardingReport is what adopting pr-previews in a repository still takes
+type OnboardingReport struct {
+	Repo            string              `json:"repo"`
+	DefaultBranch   string              `json:"default_branch"`
+	Ready           bool                `json:"ready"`
+	Checks          []OnboardingCheck   `json:"checks"`
+	Gaps            []string            `json:"gaps"` // details of failed and warned checks
+	Services        []DiscoveredService `json:"services"`
+	ExistingConfig  bool                `json:"existing_config"`
+	SuggestedConfig string              `json:"suggested_config,omitempty"`
+	PullRequest     string              `json:"pull_request,omitempty"`
+}
+
+func (r *OnboardingReport) check(name, status, detail string) {
+	r.Checks = append(r.Checks, OnboardingCheck{Name: name, Status: status, Detail: detail})
+	if status != CheckOK {
+		r.Gaps = append(r.Gaps, detail)
+	}
+}
+
+// Onboard inspects a repository with the caller's token: the webhook, the
+// bot's access, deployable services and .pr-previews.yaml. Without a config
+// it suggests one and, with openPR, proposes it as a pull request. An error
+// means the repository itself couldn't be read.
+func (cs *CommandServiceK8s) Onboard(ctx context.Context, user, bot *GitHubClient, repo string, openPR bool) (*OnboardingReport, error) {
+	repository, err := user.GetRepository(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OnboardingReport{
+		Repo:          repository.FullName,
+		DefaultBranch: repository.DefaultBranch,
+		Checks:        []OnboardingCheck{},
+		Gaps:          []string{},
+		Services:      []DiscoveredService{},
+	}
+	if repository.Archived {
+		report.check("repository", CheckFail, fmt.Sprintf("%s is archived; pull requests can't be opened on it", repo))
+	} else {
+		report.check("repository", CheckOK, fmt.Sprintf("%s is readable with your token (default branch %s)", repository.FullName, repository.DefaultBranch))
+	}
+
+	cs.checkBotAccess(ctx, bot, repo, report)
+	cs.checkWebhook(ctx, user, repo, repository.Permissions.Admin, report)
+	if cs.config.Preview.Domain == "preview.example.com" {
+		report.check("preview_domain", CheckWarn, "PREVIEW_DOMAIN is still preview.example.com; preview URLs won't resolve")
+	}
+
+	paths, truncated, err := user.ListRepoTree(ctx, repo, repository.DefaultBranch)
+	if err != nil {
+		return nil, err
+	}
+	report.Services = cs.DiscoverServicesInPaths(paths)
+	switch {
+	case len(report.Services) == 0:
+		report.check("services", CheckWarn, fmt.Sprintf("No manifests, Helm charts, kustomizations or compose file found in %s or the repo root; `/preview` would deploy a placeholder nginx", strings.Join(discoveryDirs(), ", ")))
+	case truncated:
+		report.check("services", CheckWarn, fmt.Sprintf("Found %d service(s), but the repository is too large for GitHub to list completely; some may be missing", len(report.Services)))
+	default:
+		report.check("services", CheckOK, fmt.Sprintf("Found %d service(s): %s", len(report.Services), describeServices(report.Services)))
+	}
+
+	for _, p := range paths {
+		if p == repoConfigFile {
+			report.ExistingConfig = true
+		}
+	}
+	if report.ExistingConfig {
+		cs.checkRepoConfig(ctx, user, repo, repository.DefaultBranch, report)
+	} else {
+		report.SuggestedConfig = suggestRepoConfig(repository.FullName, report.Services)
+		report.check("config", CheckWarn, fmt.Sprintf("No %s; previews use the defaults until the suggested config is committed", repoConfigFile))
+	}
+
+	if openPR && !report.ExistingConfig {
+		if !repository.Permissions.Push {
+			report.check("pull_request", CheckFail, fmt.Sprintf("Your token can't push to %s, so the suggested config wasn't proposed", repo))
+		} else {
+			url, err := user.CreatePullRequestWithFile(ctx, repo, repository.DefaultBranch, onboardingBranch, repoConfigFile, []byte(report.SuggestedConfig),
+				"Add pr-previews configuration",
+				"Adds the `"+repoConfigFile+"` suggested by the pr-previews onboarding wizard. Review the service classes and namespace layout, then merge to enable previews with these settings.")
+			if err != nil {
+				report.check("pull_request", CheckFail, err.Error())
+			} else {
+				report.PullRequest = url
+				report.check("pull_request", CheckOK, "Opened "+url)
+			}
+		}
+	}
+
+	report.Ready = true
+	for _, check := range report.Checks {
+		if check.Status == CheckFail {
+			report.Ready = false
+		}
+	}
+	return report, nil
+}
+
+// checkBotAccess makes sure the server's own token can read the repository,
+// since that's what comments, PR files and descriptions are fetched with
+func (cs *CommandServiceK8s) checkBotAccess(ctx context.Context, bot *GitHubClient, repo string, report *OnboardingReport) {
+	if cs.config.GitHub.Token == "" {
+		report.check("bot_access", CheckFail, "GITHUB_TOKEN isn't set on the server, so the bot can't comment")
+		return
+	}
+	access, err := bot.GetRepository(ctx, repo)
+	if err != nil {
+		report.check("bot_access", CheckFail, fmt.Sprintf("The bot's token can't read %s; add the bot account as a collaborator or install its app: %v", repo, err))
+		return
+	}
+	if !access.Permissions.Push {
+		report.check("bot_access", CheckWarn, "The bot only has read access; editing PR descriptions needs write access")
+		return
+	}
+	report.check("bot_access", CheckOK, "The bot can read and write the repository")
+}
+
+// checkWebhook looks for an active hook delivering comments to this server
+func (cs *CommandServiceK8s) checkWebhook(ctx context.Context, user *GitHubClient, repo string, admin bool, report *OnboardingReport) {
+	if !admin {
+		report.check("webhook", CheckWarn, "Webhooks can only be verified with a token of a repository admin")
+		return
+	}
+	hooks, err := user.ListRepoHooks(ctx, repo)
+	if err != nil {
+		report.check("webhook", CheckWarn, err.Error())
+		return
+	}
+
+	endpoint := "/webhook/github"
+	if cs.config.Server.PublicURL != "" {
+		endpoint = cs.config.Server.PublicURL + endpoint
+	}
+	var hook *GitHubHook
+	for i := range hooks {
+		if strings.HasSuffix(strings.TrimRight(hooks[i].Config.URL, "/"), endpoint) {
+			hook = &hooks[i]
+			break
+		}
+	}
+	if hook == nil {
+		report.check("webhook", CheckFail, fmt.Sprintf("No webhook delivers to %s; add one with content type application/json and the issue comment, pull request, pull request review and pull request review comment events", endpoint))
+		return
+	}
+
+	events := map[string]bool{}
+	for _, event := range hook.Events {
+		events[event] = true
+	}
+	switch {
+	case !hook.Active:
+		report.check("webhook", CheckFail, fmt.Sprintf("The webhook to %s is disabled", hook.Config.URL))
+	case hook.Config.ContentType != "json":
+		report.check("webhook", CheckFail, "The webhook sends form-encoded payloads; set its content type to application/json")
+	case !events["*"] && !events["issue_comment"]:
+		report.check("webhook", CheckFail, "The webhook doesn't send issue comment events, so commands are never seen")
+	case !events["*"] && !events["pull_request"]:
+		report.check("webhook", CheckWarn, "The webhook doesn't send pull request events; edited PR descriptions won't be picked up")
+	case !events["*"] && (!events["pull_request_review"] || !events["pull_request_review_comment"]):
+		report.check("webhook", CheckWarn, "The webhook doesn't send pull request review and review comment events; commands in reviews are never seen")
+	case hook.Config.Secret == "":
+		report.check("webhook", CheckWarn, "The webhook has no secret; anyone who finds the URL can send it events")
+	case hook.LastResponse.Code != nil && (*hook.LastResponse.Code < 200 || *hook.LastResponse.Code >= 300):
+		report.check("webhook", CheckWarn, fmt.Sprintf("The last delivery got status %d (%s)", *hook.LastResponse.Code, hook.LastResponse.Status))
+	default:
+		report.check("webhook", CheckOK, fmt.Sprintf("Webhook %d delivers to %s", hook.ID, hook.Config.URL))
+	}
+}
+
+// checkRepoConfig validates a committed .pr-previews.yaml
+func (cs *CommandServiceK8s) checkRepoConfig(ctx context.Context, user *GitHubClient, repo, ref string, report *OnboardingReport) {
+	content, err := user.GetRepoFile(ctx, repo, repoConfigFile, ref)
+	if err != nil {
+		report.check("config", CheckWarn, err.Error())
+		return
+	}
+	if cs.decryptor != nil && cs.decryptor.IsEncrypted(content) {
+		report.check("config", CheckOK, fmt.Sprintf("%s is SOPS-encrypted and is checked when a preview deploys", repoConfigFile))
+		return
+	}
+
+	repoConfig, err := ParseRepoConfig(content)
+	if err != nil {
+		report.check("config", CheckFail, err.Error())
+		return
+	}
+	if _, err := resolveServiceClass("", "", repoConfig); err != nil {
+		report.check("config", CheckFail, fmt.Sprintf("%s: class: %v", repoConfigFile, err))
+		return
+	}
+	for service := range repoConfig.Classes {
+		if _, err := resolveServiceClass("", service, repoConfig); err != nil {
+			report.check("config", CheckFail, fmt.Sprintf("%s: classes.%s: %v", repoConfigFile, service, err))
+			return
+		}
+	}
+	report.check("config", CheckOK, fmt.Sprintf("%s is valid", repoConfigFile))
+}
+
+// discoveryDirs lists the directories DiscoverServicesInPaths looks in
+func discoveryDirs() []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, dir := range append(append(append([]string{}, manifestDirs...), chartDirs...), kustomizeDirs...) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir+"/")
+		}
+	}
+	return dirs
+}
+
+func describeServices(services []DiscoveredService) string {
+	var names []string
+	for _, service := range services {
+		names = append(names, fmt.Sprintf("%s (%s)", service.Name, service.Backend))
+	}
+	return strings.Join(names, ", ")
+}
+
+// suggestRepoConfig writes a starting .pr-previews.yaml: small previews, and
+// one shared namespace when the repo has several services that likely talk
+// to each other. The rest is left commented out as a guide.
+func suggestRepoConfig(repo string, services []DiscoveredService) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# pr-previews settings for %s, suggested by the onboarding wizard\n", repo)
+	if len(services) > 0 {
+		b.WriteString("# Detected services:\n")
+		for _, service := range services {
+			fmt.Fprintf(&b, "#   %s: %s (%s)\n", service.Name, service.Backend, service.Path)
+		}
+	}
+
+	b.WriteString("\n# Default size of every preview: tiny, small or medium\nclass: small\n")
+
+	b.WriteString("\n# per-service gives each service its own namespace; shared deploys a PR's\n# services together so they reach each other by Service name\n")
+	if len(services) > 1 {
+		b.WriteString("namespace: shared\n")
+	} else {
+		b.WriteString("namespace: per-service\n")
+	}
+
+	example := "api"
+	if len(services) > 0 {
+		example = services[0].Name
+	}
+	fmt.Fprintf(&b, "\n# Size overrides per service\n# classes:\n#   %s: medium\n", example)
+	fmt.Fprintf(&b, "\n# Custom hosts and path routing per service\n# domains:\n#   %s:\n#     host: \"pr-{{ .PR }}.{{ .Domain }}\"\n#     path: /\n", example)
+	b.WriteString("\n# Credentials fetched from Vault for every preview\n# vault:\n#   - path: database/creds/preview\n#     secret: db-credentials\n")
+	return b.String()
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// operatorBundleMarker names the ConfigMap in the operator namespace that
+// records which version of a bundle the cluster has
+const operatorBundleMarker = "operator-bundle-"
+
+// OperatorBundle is a set of CRDs and the operator serving them, such as
+// Strimzi or CloudNativePG, installed once per cluster and shared by every
+// preview that references it
+type OperatorBundle struct {
+	Name    string
+	Objects []unstructured.Unstructured
+	Kinds   []schema.GroupKind // custom resources previews may create
+	Digest  string             // changes whenever the bundle's files do
+}
+
+// OperatorBundles installs the bundles of OPERATOR_BUNDLES on first use.
+// Installs are serialized, so concurrent deploys referencing a bundle
+// install it once.
+type OperatorBundles struct {
+	mu        sync.Mutex
+	namespace string
+	bundles   map[string]*OperatorBundle
+	broken    map[string]error  // bundles that failed to load, by name
+	installed map[string]string // bundle name to the digest this process installed or found
+}
+
+// sharedOperatorBundles is loaded once, in handlers.New, and used by every
+// command service, like the comment outbox
+var sharedOperatorBundles = &OperatorBundles{bundles: map[string]*OperatorBundle{}, broken: map[string]error{}, installed: map[string]string{}}
+
+// SharedOperatorBundles is the process's operator bundles
+func SharedOperatorBundles() *OperatorBundles {
+	return sharedOperatorBundles
+}
+
+// Configure loads the name=path entries of OPERATOR_BUNDLES, where path is a
+// YAML file or a directory of them. Bundle objects without a namespace are
+// installed in namespace. A bundle that fails to load is left out, and
+// previews referencing it are told why; the others still load.
+func (ob *OperatorBundles) Configure(specs []string, namespace string) error {
+	bundles := map[string]*OperatorBundle{}
+	broken := map[string]error{}
+	var failures []string
+	for _, spec := range specs {
+		name, path, ok := strings.Cut(spec, "=")
+		name, path = strings.TrimSpace(name), strings.TrimSpace(path)
+		if !ok || name == "" || path == "" {
+			failures = append(failures, fmt.Sprintf("%q must be name=path", spec))
+			continue
+		}
+		if _, ok := bundles[name]; ok {
+			failures = append(failures, fmt.Sprintf("operator bundle %s is declared twice", name))
+			continue
+		}
+		bundle, err := loadOperatorBundle(name, path)
+		if err != nil {
+			broken[name] = err
+			failures = append(failures, err.Error())
+			continue
+		}
+		bundles[name] = bundle
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.namespace = namespace
+	ob.bundles = bundles
+	ob.broken = broken
+	ob.installed = map[string]string{}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// loadOperatorBundle reads the bundle's YAML and checks its CRDs only add
+// namespaced kinds, so a preview's custom resources stay in its namespace
+func loadOperatorBundle(name, path string) (*OperatorBundle, error) {
+	files := []string{path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("operator bundle %s: %v", name, err)
+	}
+	if info.IsDir() {
+		files = nil
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+	}
+
+	bundle := &OperatorBundle{Name: name}
+	digest := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("operator bundle %s: %v", name, err)
+		}
+		digest.Write(content)
+
+		for _, doc := range strings.Split(string(content), "\n---") {
+			doc = strings.TrimSpace(doc)
+			if doc == "" || doc == "---" {
+				continue
+			}
+			raw, err := sigsyaml.YAMLToJSON([]byte(doc))
+			if err != nil {
+				return nil, fmt.Errorf("operator bundle %s: failed to parse %s: %v", name, file, err)
+			}
+			var obj unstructured.Unstructured
+			if err := obj.UnmarshalJSON(raw); err != nil {
+				return nil, fmt.Errorf("operator bundle %s: failed to decode %s: %v", name, file, err)
+			}
+			bundle.Objects = append(bundle.Objects, obj)
+		}
+	}
+
+	for _, obj := range bundle.Objects {
+		if obj.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		scope, _, _ := unstructured.NestedString(obj.Object, "spec", "scope")
+		if scope != "Namespaced" {
+			return nil, fmt.Errorf("operator bundle %s: CRD %s is %s-scoped; previews may only consume namespaced custom resources", name, obj.GetName(), strings.ToLower(scope))
+		}
+		group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+		kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+		bundle.Kinds = append(bundle.Kinds, schema.GroupKind{Group: group, Kind: kind})
+	}
+	if len(bundle.Kinds) == 0 {
+		return nil, fmt.Errorf("operator bundle %s has no CustomResourceDefinitions", name)
+	}
+	bundle.Digest = hex.EncodeToString(digest.Sum(nil))[:16]
+	return bundle, nil
+}
+
+// Ensure installs the named bundles the cluster doesn't have at their
+// current version yet. Returns the custom resource kinds they serve, and
+// the bundles it installed.
+func (ob *OperatorBundles) Ensure(ctx context.Context, k *K8sService, names []string) (map[schema.GroupKind]string, []string, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	kinds := map[schema.GroupKind]string{}
+	var installed []string
+	for _, name := range names {
+		bundle, ok := ob.bundles[name]
+		if err, broken := ob.broken[name]; broken {
+			return nil, installed, fmt.Errorf("operator bundle %s is unavailable: %v", name, err)
+		}
+		if !ok {
+			return nil, installed, fmt.Errorf("unknown operator bundle %q; the server has %s", name, ob.available())
+		}
+		for _, kind := range bundle.Kinds {
+			kinds[kind] = name
+		}
+		if ob.installed[name] == bundle.Digest {
+			continue
+		}
+
+		marker := operatorBundleMarker + name
+		current, err := k.ConfigMapValue(ctx, ob.namespace, marker, "digest")
+		if err != nil {
+			return nil, installed, err
+		}
+		if current != bundle.Digest {
+			if err := k.EnsureOperatorNamespace(ctx, ob.namespace); err != nil {
+				return nil, installed, err
+			}
+			objects := make([]unstructured.Unstructured, len(bundle.Objects))
+			for i := range bundle.Objects {
+				objects[i] = *bundle.Objects[i].DeepCopy()
+			}
+			if _, err := k.ApplyObjects(ctx, ob.namespace, objects); err != nil {
+				return nil, installed, fmt.Errorf("failed to install operator bundle %s: %v", name, err)
+			}
+			if err := k.UpsertConfigMap(ctx, ob.namespace, marker, map[string]string{"bundle": name, "digest": bundle.Digest}); err != nil {
+				return nil, installed, err
+			}
+			installed = append(installed, name)
+		}
+		ob.installed[name] = bundle.Digest
+	}
+	return kinds, installed, nil
+}
+
+func (ob *OperatorBundles) available() string {
+	if len(ob.bundles) == 0 {
+		return "none; set OPERATOR_BUNDLES"
+	}
+	names := make([]string, 0, len(ob.bundles))
+	for name := range ob.bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// splitOperatorResources separates the manifest objects of a kind served by
+// an operator bundle from the rest
+func splitOperatorResources(objects []unstructured.Unstructured, kinds map[schema.GroupKind]string) ([]unstructured.Unstructured, []unstructured.Unstructured) {
+	var served, rest []unstructured.Unstructured
+	for _, obj := range objects {
+		if _, ok := kinds[obj.GroupVersionKind().GroupKind()]; ok {
+			served = append(served, obj)
+		} else {
+			rest = append(rest, obj)
+		}
+	}
+	return served, rest
+}
+
+// EnsureOperatorNamespace creates the namespace operator bundles are
+// installed in, if missing
+func (k *K8sService) EnsureOperatorNamespace(ctx context.Context, name string) error {
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"managed-by": "pr-previews"},
+		},
+	}
+	_, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+	return nil
+}
+
+// ConfigMapValue reads one key of a ConfigMap, "" when the ConfigMap or key
+// doesn't exist
+func (k *K8sService) ConfigMapValue(ctx context.Context, namespace, name, key string) (string, error) {
+	configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get configmap %s: %v", name, err)
+	}
+	return configMap.Data[key], nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"pr-previews/internal/types"
+)
+
+// orgConfigCache remembers each owner's org-wide .pr-previews.yaml, and that
+// an owner has none, for GITHUB_CACHE_TTL; the GitHub client doesn't cache
+// reads that fail, so without it every deploy of an org without one would
+// ask again
+type orgConfigCache struct {
+	mu      sync.Mutex
+	entries map[string]orgConfigEntry
+}
+
+type orgConfigEntry struct {
+	content   []byte // decrypted; nil when the owner has no org config
+	fetchedAt time.Time
+}
+
+var sharedOrgConfigs = &orgConfigCache{entries: make(map[string]orgConfigEntry)}
+
+// orgConfigRepo is the repository holding the org-wide config of repo's
+// owner, e.g. acme/.github. It's false when org configs are disabled, there
+// are no GitHub credentials, token or App, or the command has no repo to
+// take an owner from.
+func (cs *CommandServiceK8s) orgConfigRepo(repo string) (string, bool) {
+	owner, _, found := strings.Cut(repo, "/")
+	if !found || owner == "" || cs.config.GitHub.OrgConfigRepo == "" || !cs.github.authenticated() {
+		return "", false
+	}
+	return owner + "/" + cs.config.GitHub.OrgConfigRepo, true
+}
+
+// orgConfigContent returns the decrypted org-wide config of repo's owner from
+// the default branch of its org config repository, or nil when there's none.
+// While GitHub is rate limiting or failing, the last copy read is used
+// however old it is.
+func (cs *CommandServiceK8s) orgConfigContent(ctx context.Context, repo string) ([]byte, error) {
+	source, ok := cs.orgConfigRepo(repo)
+	if !ok {
+		return nil, nil
+	}
+
+	sharedOrgConfigs.mu.Lock()
+	entry, cached := sharedOrgConfigs.entries[source]
+	sharedOrgConfigs.mu.Unlock()
+	if cached && time.Since(entry.fetchedAt) < cs.config.GitHub.CacheTTL {
+		return entry.content, nil
+	}
+
+	content, err := cs.github.GetRepoFile(ctx, source, cs.config.GitHub.OrgConfigPath, "")
+	if isGitHubNotFound(err) {
+		content, err = nil, nil
+	}
+	if err != nil && cached && isGitHubUnavailable(err) {
+		fmt.Printf("Warning: using the org config of %s read %s ago: %v\n", source, time.Since(entry.fetchedAt).Round(time.Second), err)
+		return entry.content, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read org config from %s: %v", source, err)
+	}
+	if content != nil && cs.decryptor != nil && cs.decryptor.IsEncrypted(content) {
+		if content, err = cs.decryptor.Decrypt(content); err != nil {
+			return nil, fmt.Errorf("failed to decrypt org config from %s: %v", source, err)
+		}
+	}
+
+	sharedOrgConfigs.mu.Lock()
+	sharedOrgConfigs.entries[source] = orgConfigEntry{content: content, fetchedAt: time.Now()}
+	sharedOrgConfigs.mu.Unlock()
+	return content, nil
+}
+
+// loadRepoConfig reads the .pr-previews.yaml of the command's PR head
+// layered over its owner's org-wide config, through the repo config cache
+// so repeated commands on one head don't fetch it again. Without GitHub
+// access, or outside a PR, the file in repoPath is read instead.
+func (cs *CommandServiceK8s) loadRepoConfig(ctx context.Context, cmd *types.Command, repoPath string) (*RepoConfig, error) {
+	if cmd.PRNumber > 0 && cs.github.authenticated() {
+		if sha, _, err := cs.github.GetPullRequestHead(ctx, cmd.Repo, cmd.PRNumber); err == nil && sha != "" {
+			return cs.repoConfigAt(ctx, cmd.Repo, sha)
+		}
+	}
+
+	orgContent, err := cs.orgConfigContent(ctx, cmd.Repo)
+	if err != nil {
+		return nil, err
+	}
+	content, err := readRepoConfigFile(repoPath, cs.decryptor)
+	if err != nil {
+		return nil, err
+	}
+	return layerRepoConfig(orgContent, content)
+}
+
+// layerRepoConfig parses a repo's config over its org's. The org config must
+// be valid on its own, and the two must be valid together.
+func layerRepoConfig(orgContent, repoContent []byte) (*RepoConfig, error) {
+	if orgContent == nil {
+		return ParseRepoConfig(repoContent)
+	}
+	if _, err := ParseRepoConfig(orgContent); err != nil {
+		return nil, fmt.Errorf("org config: %v", err)
+	}
+
+	org, err := configValues(orgContent)
+	if err != nil {
+		return nil, fmt.Errorf("org config: %v", err)
+	}
+	repo, err := configValues(repoContent)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := yaml.Marshal(mergeConfigValues(org, repo))
+	if err != nil {
+		return nil, err
+	}
+	return ParseRepoConfig(merged)
+}
+
+// configValues decodes a config document generically, so layers can be
+// merged key by key
+func configValues(content []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", repoConfigFile, err)
+	}
+	return values, nil
+}
+
+// mergeConfigValues layers overlay over base. Mappings merge key by key, so
+// an org's labels and a repo's combine and a repo can override one entry of
+// classes; lists and scalars are replaced whole, and a null drops the
+// base's value.
+func mergeConfigValues(base, overlay map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(overlay))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overlay {
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overlayMap, overlayIsMap := value.(map[string]interface{})
+		if baseIsMap && overlayIsMap {
+			merged[key] = mergeConfigValues(baseMap, overlayMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
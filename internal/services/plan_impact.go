@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/types"
+)
+
+// ResourceTotals is what a set of workloads requests from the cluster
+type ResourceTotals struct {
+	CPU     resource.Quantity `json:"cpu"`
+	Memory  resource.Quantity `json:"memory"`
+	Storage resource.Quantity `json:"storage"`
+}
+
+// Add sums other into rt
+func (rt *ResourceTotals) Add(other ResourceTotals) {
+	rt.CPU.Add(other.CPU)
+	rt.Memory.Add(other.Memory)
+	rt.Storage.Add(other.Storage)
+}
+
+// Sub takes other out of rt
+func (rt *ResourceTotals) Sub(other ResourceTotals) {
+	rt.CPU.Sub(other.CPU)
+	rt.Memory.Sub(other.Memory)
+	rt.Storage.Sub(other.Storage)
+}
+
+// ServiceImpact is one service's requests once its manifest is rendered the
+// way /preview would render it
+type ServiceImpact struct {
+	Service         string         `json:"service"`
+	Manifest        string         `json:"manifest,omitempty"`
+	Requests        ResourceTotals `json:"requests"`
+	MissingRequests []string       `json:"missing_requests,omitempty"` // containers without CPU or memory requests
+	Error           string         `json:"error,omitempty"`
+}
+
+// ResourceImpact totals the requests of everything /plan would deploy and
+// weighs them against the namespace quota and the cluster's free capacity
+type ResourceImpact struct {
+	Services  []ServiceImpact  `json:"services"`
+	Total     ResourceTotals   `json:"total"`
+	Shared    bool             `json:"shared"` // every service lands in one namespace, so the total meets the quota
+	Headroom  *ClusterHeadroom `json:"headroom,omitempty"`
+	Warnings  []string         `json:"warnings"`
+	Defaulted bool             `json:"defaulted"` // missing requests were counted at the baseline's defaults
+}
+
+// ClusterHeadroom is the requests the cluster's schedulable nodes can still
+// take
+type ClusterHeadroom struct {
+	Nodes       int               `json:"nodes"`
+	Allocatable ResourceTotals    `json:"allocatable"`
+	Requested   ResourceTotals    `json:"requested"`
+	FreeCPU     resource.Quantity `json:"free_cpu"`
+	FreeMemory  resource.Quantity `json:"free_memory"`
+}
+
+// headroomTTL is how long a computed headroom is reused; /plan is an
+// estimate, and listing every pod per call is heavy on large clusters
+const headroomTTL = 30 * time.Second
+
+type cachedHeadroom struct {
+	headroom *ClusterHeadroom
+	at       time.Time
+}
+
+// headroomCache is keyed by API server, so a vcluster's headroom is kept
+// apart from the host cluster's. Cached values are read-only.
+var headroomCache = struct {
+	mu      sync.Mutex
+	servers map[string]cachedHeadroom
+}{servers: map[string]cachedHeadroom{}}
+
+// GetClusterHeadroom sums the allocatable CPU and memory of ready,
+// schedulable nodes and subtracts the requests of pods still running on
+// them. Results are shared for headroomTTL.
+func (k *K8sService) GetClusterHeadroom(ctx context.Context) (*ClusterHeadroom, error) {
+	var server string
+	if k.restConfig != nil {
+		server = k.restConfig.Host
+	}
+	headroomCache.mu.Lock()
+	defer headroomCache.mu.Unlock()
+	if cached, ok := headroomCache.servers[server]; ok && time.Since(cached.at) < headroomTTL {
+		return cached.headroom, nil
+	}
+
+	// ResourceVersion "0" is served from the API server's cache rather
+	// than etcd; finished pods are filtered out server-side
+	nodes, err := k.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	pods, err := k.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		ResourceVersion: "0",
+		FieldSelector:   "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+
+	headroom := &ClusterHeadroom{}
+	schedulable := make(map[string]bool)
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable || !nodeReady(&node) {
+			continue
+		}
+		schedulable[node.Name] = true
+		headroom.Nodes++
+		headroom.Allocatable.CPU.Add(node.Status.Allocatable[corev1.ResourceCPU])
+		headroom.Allocatable.Memory.Add(node.Status.Allocatable[corev1.ResourceMemory])
+	}
+	for _, pod := range pods.Items {
+		if !schedulable[pod.Spec.NodeName] || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		requests, _ := effectivePodRequests(&pod.Spec, nil)
+		headroom.Requested.CPU.Add(requests.CPU)
+		headroom.Requested.Memory.Add(requests.Memory)
+	}
+
+	headroom.FreeCPU = headroom.Allocatable.CPU.DeepCopy()
+	headroom.FreeCPU.Sub(headroom.Requested.CPU)
+	headroom.FreeMemory = headroom.Allocatable.Memory.DeepCopy()
+	headroom.FreeMemory.Sub(headroom.Requested.Memory)
+
+	headroomCache.servers[server] = cachedHeadroom{headroom: headroom, at: time.Now()}
+	return headroom, nil
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// effectivePodRequests is what the scheduler reserves for a pod: its
+// containers' requests, or its largest init container's when that is more.
+// Containers missing a request are returned by name and counted at defaults,
+// if given.
+func effectivePodRequests(spec *corev1.PodSpec, defaults corev1.ResourceList) (ResourceTotals, []string) {
+	var totals ResourceTotals
+	var missing []string
+	request := func(container *corev1.Container, name corev1.ResourceName) resource.Quantity {
+		if quantity, ok := container.Resources.Requests[name]; ok {
+			return quantity
+		}
+		return defaults[name]
+	}
+	isMissing := func(container *corev1.Container) bool {
+		_, cpu := container.Resources.Requests[corev1.ResourceCPU]
+		_, memory := container.Resources.Requests[corev1.ResourceMemory]
+		return !cpu || !memory
+	}
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+		if isMissing(container) {
+			missing = append(missing, container.Name)
+		}
+		totals.CPU.Add(request(container, corev1.ResourceCPU))
+		totals.Memory.Add(request(container, corev1.ResourceMemory))
+	}
+	for i := range spec.InitContainers {
+		container := &spec.InitContainers[i]
+		if isMissing(container) {
+			missing = append(missing, container.Name)
+		}
+		if cpu := request(container, corev1.ResourceCPU); cpu.Cmp(totals.CPU) > 0 {
+			totals.CPU = cpu
+		}
+		if memory := request(container, corev1.ResourceMemory); memory.Cmp(totals.Memory) > 0 {
+			totals.Memory = memory
+		}
+	}
+	return totals, missing
+}
+
+// manifestRequests totals a rendered manifest's requests across replicas,
+// with the storage its volume claims ask for
+func manifestRequests(parsed *ParsedManifest, defaults corev1.ResourceList) (ResourceTotals, []string) {
+	var totals ResourceTotals
+	var missing []string
+	addWorkload := func(kind, name string, replicas *int32, spec *corev1.PodSpec) {
+		count := int64(1)
+		if replicas != nil {
+			count = int64(*replicas)
+		}
+		requests, containers := effectivePodRequests(spec, defaults)
+		for _, container := range containers {
+			missing = append(missing, fmt.Sprintf("%s/%s (container `%s`)", kind, name, container))
+		}
+		for i := int64(0); i < count; i++ {
+			totals.CPU.Add(requests.CPU)
+			totals.Memory.Add(requests.Memory)
+		}
+	}
+
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		addWorkload("Deployment", dep.Name, dep.Spec.Replicas, &dep.Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		addWorkload("StatefulSet", sts.Name, sts.Spec.Replicas, &sts.Spec.Template.Spec)
+
+		// Every replica gets its own claim from each template
+		replicas := int64(1)
+		if sts.Spec.Replicas != nil {
+			replicas = int64(*sts.Spec.Replicas)
+		}
+		for _, template := range sts.Spec.VolumeClaimTemplates {
+			storage := template.Spec.Resources.Requests[corev1.ResourceStorage]
+			for j := int64(0); j < replicas; j++ {
+				totals.Storage.Add(storage)
+			}
+		}
+	}
+	for _, pvc := range parsed.PersistentVolumeClaims {
+		totals.Storage.Add(pvc.Spec.Resources.Requests[corev1.ResourceStorage])
+	}
+	return totals, missing
+}
+
+// PlanResourceImpact renders the manifests /plan covers, the named service
+// or else every deployable service the PR changes, and totals their requests
+func (cs *CommandServiceK8s) PlanResourceImpact(ctx context.Context, cmd *types.Command, repoPath string) *ResourceImpact {
+	impact := &ResourceImpact{Warnings: []string{}}
+
+	repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath)
+	if err != nil {
+		impact.Warnings = append(impact.Warnings, fmt.Sprintf("Repo config could not be loaded, so service classes were ignored: %v", err))
+		repoConfig = &RepoConfig{}
+	}
+	impact.Shared = repoConfig.SharedNamespace()
+
+	defaults := cs.requestDefaults()
+	impact.Defaulted = defaults != nil
+
+	for _, service := range cs.planServices(ctx, cmd, repoPath, repoConfig.Changes) {
+		serviceImpact := ServiceImpact{Service: service.Name, Manifest: service.Path}
+		parsed, err := cs.renderPlanManifest(cmd, service, repoPath, repoConfig)
+		if err != nil {
+			serviceImpact.Error = err.Error()
+			impact.Warnings = append(impact.Warnings, fmt.Sprintf("`%s` could not be rendered: %v", service.Name, err))
+		} else {
+			serviceImpact.Requests, serviceImpact.MissingRequests = manifestRequests(parsed, defaults)
+			impact.Total.Add(serviceImpact.Requests)
+		}
+		impact.Services = append(impact.Services, serviceImpact)
+	}
+	if len(impact.Services) == 0 {
+		return impact
+	}
+
+	impact.Warnings = append(impact.Warnings, cs.quotaWarnings(impact)...)
+
+	headroom, err := cs.k8s.GetClusterHeadroom(ctx)
+	if err != nil {
+		impact.Warnings = append(impact.Warnings, fmt.Sprintf("Cluster headroom unavailable: %v", err))
+		return impact
+	}
+	impact.Headroom = headroom
+	if impact.Total.CPU.Cmp(headroom.FreeCPU) > 0 {
+		impact.Warnings = append(impact.Warnings, fmt.Sprintf("Requests %s CPU but the cluster has %s free", impact.Total.CPU.String(), headroom.FreeCPU.String()))
+	}
+	if impact.Total.Memory.Cmp(headroom.FreeMemory) > 0 {
+		impact.Warnings = append(impact.Warnings, fmt.Sprintf("Requests %s memory but the cluster has %s free", impact.Total.Memory.String(), headroom.FreeMemory.String()))
+	}
+	return impact
+}
+
+// requestDefaults is what containers without requests will really reserve:
+// the baseline's defaults once a namespace quota is set, otherwise nothing
+func (cs *CommandServiceK8s) requestDefaults() corev1.ResourceList {
+	baseline := cs.k8s.baseline
+	if baseline == nil || (baseline.QuotaCPU == nil && baseline.QuotaMemory == nil) {
+		return nil
+	}
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    baselineDefaultCPU,
+		corev1.ResourceMemory: baselineDefaultMemory,
+	}
+}
+
+// planServices is the named service, or the deployable services the PR
+// changes under the repo's change rules; all of them when the PR's files are
+// unknown or touch none
+func (cs *CommandServiceK8s) planServices(ctx context.Context, cmd *types.Command, repoPath string, rules ChangeRules) []DiscoveredService {
+	var deployable []DiscoveredService
+	for _, service := range cs.DiscoverServices(repoPath) {
+		if service.Backend == BackendManifest || service.Backend == BackendCompose {
+			deployable = append(deployable, service)
+		}
+	}
+
+	if cmd.Service != "" {
+		for _, service := range deployable {
+			if service.Name == cmd.Service {
+				return []DiscoveredService{service}
+			}
+		}
+		return nil
+	}
+
+	changedFiles, err := cs.github.ListPullRequestFiles(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		return deployable
+	}
+	var changed []DiscoveredService
+	for _, service := range deployable {
+		if rules.ServiceChanged(service, changedFiles) {
+			changed = append(changed, service)
+		}
+	}
+	if len(changed) == 0 {
+		return deployable
+	}
+	return changed
+}
+
+// renderPlanManifest parses and sizes a service the way /preview does
+// before applying it
+func (cs *CommandServiceK8s) renderPlanManifest(cmd *types.Command, service DiscoveredService, repoPath string, repoConfig *RepoConfig) (*ParsedManifest, error) {
+	var parsed *ParsedManifest
+	if service.Backend == BackendCompose {
+		conversion, err := ConvertComposeFile(filepath.Join(repoPath, service.Path))
+		if err != nil {
+			return nil, err
+		}
+		parsed = conversion.Manifest
+	} else {
+		var err error
+		parsed, err = NewManifestParser(cs.decryptor).ParseManifestFile(filepath.Join(repoPath, service.Path))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	class, err := resolveServiceClass("", service.Name, repoConfig)
+	if err != nil {
+		return nil, err
+	}
+	if class != nil {
+		class.Apply(parsed)
+	}
+	cs.mutator.Mutate(service.Name, parsed)
+	_, err = injectContainers(repoConfig.InjectionsFor(service.Name), injectionData{
+		PR:      cmd.PRNumber,
+		Service: strings.ReplaceAll(service.Name, "/", "-"),
+		Branch:  SlugifyBranch(cmd.Branch),
+		Repo:    cmd.Repo,
+		Domain:  cs.config.Preview.Domain,
+	}, parsed)
+	if err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// quotaWarnings flags requests the namespace quota would refuse: the total
+// in a shared namespace, each service in its own otherwise
+func (cs *CommandServiceK8s) quotaWarnings(impact *ResourceImpact) []string {
+	baseline := cs.k8s.baseline
+	if baseline == nil {
+		return nil
+	}
+
+	var warnings []string
+	check := func(label string, requests ResourceTotals) {
+		if baseline.QuotaCPU != nil && requests.CPU.Cmp(*baseline.QuotaCPU) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s requests %s CPU, over the namespace quota of %s", label, requests.CPU.String(), baseline.QuotaCPU.String()))
+		}
+		if baseline.QuotaMemory != nil && requests.Memory.Cmp(*baseline.QuotaMemory) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s requests %s memory, over the namespace quota of %s", label, requests.Memory.String(), baseline.QuotaMemory.String()))
+		}
+	}
+
+	if impact.Shared {
+		check("The shared namespace", impact.Total)
+		return warnings
+	}
+	for _, service := range impact.Services {
+		check(fmt.Sprintf("`%s`", service.Service), service.Requests)
+	}
+	return warnings
+}
+
+// ResourceImpactSection renders the resource impact for /plan, or "" when
+// there's nothing to deploy from manifests
+func (cs *CommandServiceK8s) ResourceImpactSection(ctx context.Context, cmd *types.Command, repoPath string) (string, *ResourceImpact) {
+	impact := cs.PlanResourceImpact(ctx, cmd, repoPath)
+	if len(impact.Services) == 0 {
+		return "", impact
+	}
+
+	var content strings.Builder
+	content.WriteString("\n\n### 📊 Resource Impact\n\n")
+	content.WriteString("| Service | CPU | Memory | Storage | Requests set |\n")
+	content.WriteString("|---------|-----|--------|---------|--------------|\n")
+	var missing []string
+	for _, service := range impact.Services {
+		if service.Error != "" {
+			content.WriteString(fmt.Sprintf("| `%s` | — | — | — | ❌ not rendered |\n", service.Service))
+			continue
+		}
+		requestsSet := "✅"
+		if len(service.MissingRequests) > 0 {
+			requestsSet = fmt.Sprintf("⚠️ %d missing", len(service.MissingRequests))
+			for _, workload := range service.MissingRequests {
+				missing = append(missing, fmt.Sprintf("`%s`: %s", service.Service, workload))
+			}
+		}
+		content.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s | %s |\n", service.Service,
+			service.Requests.CPU.String(), service.Requests.Memory.String(), service.Requests.Storage.String(), requestsSet))
+	}
+	content.WriteString(fmt.Sprintf("| **Total** | **%s** | **%s** | **%s** | |\n",
+		impact.Total.CPU.String(), impact.Total.Memory.String(), impact.Total.Storage.String()))
+
+	if headroom := impact.Headroom; headroom != nil {
+		content.WriteString(fmt.Sprintf("\n**Cluster headroom:** %s CPU, %s memory free on %d schedulable nodes\n",
+			headroom.FreeCPU.String(), headroom.FreeMemory.String(), headroom.Nodes))
+	}
+
+	if len(missing) > 0 {
+		content.WriteString("\n#### ⚠️ Containers Without Resource Requests\n")
+		for _, workload := range missing {
+			content.WriteString("- " + workload + "\n")
+		}
+		if impact.Defaulted {
+			content.WriteString(fmt.Sprintf("\n*Counted at the namespace defaults (%s CPU, %s memory); set requests so the plan matches reality.*\n",
+				baselineDefaultCPU.String(), baselineDefaultMemory.String()))
+		} else {
+			content.WriteString("\n*These are counted as zero, and the scheduler will place them as if they need nothing.*\n")
+		}
+	}
+
+	if len(impact.Warnings) > 0 {
+		content.WriteString("\n#### 🚨 Warnings\n")
+		for _, warning := range impact.Warnings {
+			content.WriteString("- " + warning + "\n")
+		}
+	}
+
+	return content.String(), impact
+}
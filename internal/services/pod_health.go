@@ -0,0 +1,311 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// Why a running preview pod was flagged
+const (
+	PodAlertOOMKilled = "OOMKilled"
+	PodAlertEvicted   = "Evicted"
+	PodAlertCrashLoop = "CrashLoopBackOff"
+)
+
+// podWatchRetry is how long to wait before reconnecting a dropped watch
+const podWatchRetry = 5 * time.Second
+
+// PodAlert is a preview pod that failed after it had started: OOMKilled,
+// evicted, or crash-looping after having been ready
+type PodAlert struct {
+	Reason      string `json:"reason"`
+	Namespace   string `json:"namespace"`
+	Pod         string `json:"pod"`
+	Container   string `json:"container,omitempty"`
+	Restarts    int32  `json:"restarts,omitempty"`
+	Message     string `json:"message,omitempty"`
+	MemoryLimit string `json:"memory_limit,omitempty"`
+}
+
+// podHealth is what the watcher remembers of a pod between events
+type podHealth struct {
+	everReady    bool
+	evicted      bool
+	terminations map[string]time.Time // last termination seen per container
+	crashLooping map[string]bool      // containers in CrashLoopBackOff at the last event
+}
+
+// PodHealthWatcher watches preview pods (those labelled preview=true) and
+// comments on the PR when one is OOMKilled, evicted, or crash-loops after
+// having been ready, so authors hear about regressions without polling
+// /status. Only the leader watches, so each alert is posted once.
+type PodHealthWatcher struct {
+	cs       *CommandServiceK8s
+	restarts int32
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	pods     map[string]*podHealth // by pod UID
+	reported map[string]time.Time  // namespace/container/reason -> last comment
+}
+
+// StartPodHealthWatcher runs the watcher until ctx is cancelled, when
+// PREVIEW_POD_ALERTS is on
+func (cs *CommandServiceK8s) StartPodHealthWatcher(ctx context.Context) {
+	if !cs.config.Preview.PodAlerts {
+		return
+	}
+	watcher := &PodHealthWatcher{
+		cs:       cs,
+		restarts: int32(cs.config.Preview.CrashLoopRestarts),
+		cooldown: cs.config.Preview.PodAlertCooldown,
+		pods:     make(map[string]*podHealth),
+		reported: make(map[string]time.Time),
+	}
+	watcher.Run(ctx)
+}
+
+// podHealthSelector matches the pods of preview workloads
+const podHealthSelector = "preview=true"
+
+// Run lists the pods once to learn their current state without reporting
+// it, then follows changes from there, listing again when the watch falls
+// too far behind or after this replica regains leadership
+func (w *PodHealthWatcher) Run(ctx context.Context) {
+	resourceVersion, listed := "", false
+	for {
+		if !SharedLeader().IsLeader() {
+			listed = false
+		} else if !listed {
+			pods, err := w.cs.k8s.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: podHealthSelector})
+			if err != nil {
+				fmt.Printf("Warning: failed to list pods for health alerts: %v\n", err)
+			} else {
+				w.mu.Lock()
+				w.pods = make(map[string]*podHealth, len(pods.Items))
+				w.mu.Unlock()
+				for i := range pods.Items {
+					w.observe(&pods.Items[i])
+				}
+				resourceVersion, listed = pods.ResourceVersion, true
+			}
+		}
+
+		if listed && SharedLeader().IsLeader() {
+			resourceVersion, listed = w.follow(ctx, resourceVersion)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(podWatchRetry):
+		}
+	}
+}
+
+// follow handles pod events until the watch closes, returning the version
+// to resume from, or false when the pods must be listed again
+func (w *PodHealthWatcher) follow(ctx context.Context, resourceVersion string) (string, bool) {
+	events, err := w.cs.k8s.client.CoreV1().Pods("").Watch(ctx, metav1.ListOptions{LabelSelector: podHealthSelector, ResourceVersion: resourceVersion})
+	if err != nil {
+		fmt.Printf("Warning: failed to watch pods for health alerts: %v\n", err)
+		return resourceVersion, true
+	}
+	defer events.Stop()
+
+	for event := range events.ResultChan() {
+		switch event.Type {
+		case watch.Error:
+			if status, ok := event.Object.(*metav1.Status); ok && status.Code == 410 {
+				return "", false
+			}
+			fmt.Printf("Warning: pod watch error: %v\n", apierrors.FromObject(event.Object))
+			return resourceVersion, true
+		case watch.Deleted:
+			if pod, ok := event.Object.(*corev1.Pod); ok {
+				w.forget(pod)
+				resourceVersion = pod.ResourceVersion
+			}
+		case watch.Added, watch.Modified:
+			pod, ok := event.Object.(*corev1.Pod)
+			if !ok {
+				continue
+			}
+			resourceVersion = pod.ResourceVersion
+			if !SharedLeader().IsLeader() {
+				// The new leader reports from here on
+				return "", false
+			}
+			for _, alert := range w.observe(pod) {
+				w.report(ctx, alert)
+			}
+		}
+	}
+	return resourceVersion, true
+}
+
+// observe updates what's known of the pod and returns its new failures
+func (w *PodHealthWatcher) observe(pod *corev1.Pod) []PodAlert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	health, known := w.pods[string(pod.UID)]
+	if !known {
+		health = &podHealth{terminations: make(map[string]time.Time), crashLooping: make(map[string]bool)}
+		w.pods[string(pod.UID)] = health
+	}
+
+	var alerts []PodAlert
+	if pod.Status.Reason == PodAlertEvicted && !health.evicted {
+		health.evicted = true
+		if known {
+			alerts = append(alerts, PodAlert{Reason: PodAlertEvicted, Namespace: pod.Namespace, Pod: pod.Name, Message: pod.Status.Message})
+		}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		terminated := status.LastTerminationState.Terminated
+		if status.State.Terminated != nil {
+			terminated = status.State.Terminated
+		}
+		if terminated != nil && terminated.FinishedAt.Time.After(health.terminations[status.Name]) {
+			health.terminations[status.Name] = terminated.FinishedAt.Time
+			if known && terminated.Reason == PodAlertOOMKilled {
+				alerts = append(alerts, PodAlert{
+					Reason:      PodAlertOOMKilled,
+					Namespace:   pod.Namespace,
+					Pod:         pod.Name,
+					Container:   status.Name,
+					Restarts:    status.RestartCount,
+					MemoryLimit: containerMemoryLimit(pod, status.Name),
+				})
+			}
+		}
+
+		// An OOMKilled container loops too, but it's already been reported
+		looping := status.State.Waiting != nil && status.State.Waiting.Reason == PodAlertCrashLoop && status.RestartCount >= w.restarts
+		oomKilled := terminated != nil && terminated.Reason == PodAlertOOMKilled
+		if looping && !oomKilled && health.everReady && !health.crashLooping[status.Name] {
+			alerts = append(alerts, PodAlert{
+				Reason:    PodAlertCrashLoop,
+				Namespace: pod.Namespace,
+				Pod:       pod.Name,
+				Container: status.Name,
+				Restarts:  status.RestartCount,
+				Message:   status.State.Waiting.Message,
+			})
+		}
+		health.crashLooping[status.Name] = looping
+	}
+
+	if podReady(pod) {
+		health.everReady = true
+	}
+	return alerts
+}
+
+func (w *PodHealthWatcher) forget(pod *corev1.Pod) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pods, string(pod.UID))
+}
+
+// report comments on the PR that owns the pod's namespace, at most once per
+// PREVIEW_POD_ALERT_COOLDOWN for the same container and failure
+func (w *PodHealthWatcher) report(ctx context.Context, alert PodAlert) {
+	namespace, err := w.cs.k8s.client.CoreV1().Namespaces().Get(ctx, alert.Namespace, metav1.GetOptions{})
+	if err != nil || namespace.Labels["preview"] != "true" {
+		return
+	}
+	prNumber, err := strconv.Atoi(namespace.Labels["pr-number"])
+	repo := namespace.Annotations[previewRepoAnnotation]
+	if err != nil || repo == "" {
+		return
+	}
+
+	key := strings.Join([]string{alert.Namespace, alert.Container, alert.Reason}, "/")
+	w.mu.Lock()
+	if last, ok := w.reported[key]; ok && time.Since(last) < w.cooldown {
+		w.mu.Unlock()
+		return
+	}
+	for other, last := range w.reported {
+		if time.Since(last) >= w.cooldown {
+			delete(w.reported, other)
+		}
+	}
+	w.reported[key] = time.Now()
+	w.mu.Unlock()
+
+	w.cs.logTimeline(repo, prNumber, "Pod %s in %s: %s", alert.Pod, alert.Namespace, alert.Reason)
+	if err := w.cs.comments.PostComment(context.Background(), repo, prNumber, formatPodAlert(alert)); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	w.cs.RefreshPreviewSummary(repo, prNumber)
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func containerMemoryLimit(pod *corev1.Pod, name string) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			if limit, ok := container.Resources.Limits[corev1.ResourceMemory]; ok {
+				return limit.String()
+			}
+		}
+	}
+	return ""
+}
+
+func formatPodAlert(alert PodAlert) string {
+	var content strings.Builder
+	switch alert.Reason {
+	case PodAlertOOMKilled:
+		content.WriteString("## 💥 Preview Pod OOMKilled\n\n")
+	case PodAlertEvicted:
+		content.WriteString("## 🚚 Preview Pod Evicted\n\n")
+	default:
+		content.WriteString("## 🔁 Preview Pod Crash-Looping\n\n")
+	}
+
+	content.WriteString(fmt.Sprintf("**Namespace:** `%s`\n**Pod:** `%s`\n", alert.Namespace, alert.Pod))
+	if alert.Container != "" {
+		content.WriteString(fmt.Sprintf("**Container:** `%s`\n", alert.Container))
+	}
+	if alert.Restarts > 0 {
+		content.WriteString(fmt.Sprintf("**Restarts:** %d\n", alert.Restarts))
+	}
+	if alert.Message != "" {
+		content.WriteString(fmt.Sprintf("**Message:** %s\n", alert.Message))
+	}
+
+	switch alert.Reason {
+	case PodAlertOOMKilled:
+		limit := "its memory limit"
+		if alert.MemoryLimit != "" {
+			limit = fmt.Sprintf("its memory limit of %s", alert.MemoryLimit)
+		}
+		content.WriteString(fmt.Sprintf("\n*The container used more than %s. Raise `resources.limits.memory` or look for a leak in this PR.*", limit))
+	case PodAlertEvicted:
+		content.WriteString("\n*The node ran short of resources and evicted the pod. Its controller starts a replacement; lower the preview's requests or use a smaller `--class` if it keeps happening.*")
+	default:
+		content.WriteString("\n*The container was ready before and now keeps restarting. Get a `/kubeconfig` and check its `kubectl logs --previous`.*")
+	}
+	return content.String()
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod Security Standards levels, from least to most strict
+const (
+	PodSecurityPrivileged = "privileged"
+	PodSecurityBaseline   = "baseline"
+	PodSecurityRestricted = "restricted"
+)
+
+// Capabilities the baseline standard allows containers to add
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true,
+	"FSETID": true, "KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// PodSecurityMutator rewrites workload security contexts to satisfy a Pod
+// Security Standard, so previews aren't silently rejected by admission
+type PodSecurityMutator struct {
+	level string
+}
+
+func NewPodSecurityMutator(level string) *PodSecurityMutator {
+	switch level {
+	case PodSecurityBaseline, PodSecurityRestricted:
+	default:
+		level = PodSecurityPrivileged
+	}
+	return &PodSecurityMutator{level: level}
+}
+
+// Level returns the enforced standard
+func (pm *PodSecurityMutator) Level() string {
+	return pm.level
+}
+
+// Mutate fixes what it can and returns a note per change, plus violations it
+// cannot fix without breaking the workload (e.g. hostPath volumes)
+func (pm *PodSecurityMutator) Mutate(parsed *ParsedManifest) ([]string, []string) {
+	var changes, violations []string
+	if pm.level == PodSecurityPrivileged {
+		return changes, violations
+	}
+
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		c, v := pm.mutatePodSpec(fmt.Sprintf("Deployment/%s", dep.Name), &dep.Spec.Template.Spec)
+		changes, violations = append(changes, c...), append(violations, v...)
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		c, v := pm.mutatePodSpec(fmt.Sprintf("StatefulSet/%s", sts.Name), &sts.Spec.Template.Spec)
+		changes, violations = append(changes, c...), append(violations, v...)
+	}
+
+	return changes, violations
+}
+
+func (pm *PodSecurityMutator) mutatePodSpec(workload string, spec *corev1.PodSpec) ([]string, []string) {
+	var changes, violations []string
+
+	// Baseline: no host namespaces or host filesystem
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		spec.HostNetwork, spec.HostPID, spec.HostIPC = false, false, false
+		changes = append(changes, fmt.Sprintf("Disabled host namespaces on %s", workload))
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			violations = append(violations, fmt.Sprintf("%s mounts hostPath volume `%s` (%s)", workload, volume.Name, volume.HostPath.Path))
+		}
+	}
+
+	if pm.level == PodSecurityRestricted {
+		if spec.SecurityContext == nil {
+			spec.SecurityContext = &corev1.PodSecurityContext{}
+		}
+		if spec.SecurityContext.RunAsNonRoot == nil || !*spec.SecurityContext.RunAsNonRoot {
+			spec.SecurityContext.RunAsNonRoot = boolPtr(true)
+			changes = append(changes, fmt.Sprintf("Set runAsNonRoot on %s (images that run as root will fail to start)", workload))
+		}
+		if spec.SecurityContext.SeccompProfile == nil || spec.SecurityContext.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			spec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+			changes = append(changes, fmt.Sprintf("Set RuntimeDefault seccomp profile on %s", workload))
+		}
+		if spec.SecurityContext.RunAsUser != nil && *spec.SecurityContext.RunAsUser == 0 {
+			violations = append(violations, fmt.Sprintf("%s sets runAsUser: 0", workload))
+		}
+	}
+
+	for i := range spec.InitContainers {
+		c, v := pm.mutateContainer(workload, &spec.InitContainers[i])
+		changes, violations = append(changes, c...), append(violations, v...)
+	}
+	for i := range spec.Containers {
+		c, v := pm.mutateContainer(workload, &spec.Containers[i])
+		changes, violations = append(changes, c...), append(violations, v...)
+	}
+
+	return changes, violations
+}
+
+func (pm *PodSecurityMutator) mutateContainer(workload string, container *corev1.Container) ([]string, []string) {
+	var changes, violations []string
+	name := fmt.Sprintf("%s container `%s`", workload, container.Name)
+
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	sc := container.SecurityContext
+
+	if sc.Privileged != nil && *sc.Privileged {
+		sc.Privileged = boolPtr(false)
+		changes = append(changes, fmt.Sprintf("Disabled privileged mode on %s", name))
+	}
+	for _, port := range container.Ports {
+		if port.HostPort != 0 {
+			violations = append(violations, fmt.Sprintf("%s uses hostPort %d", name, port.HostPort))
+		}
+	}
+
+	// Baseline only allows a default set of added capabilities; restricted
+	// drops everything except NET_BIND_SERVICE
+	if sc.Capabilities != nil && len(sc.Capabilities.Add) > 0 {
+		var kept []corev1.Capability
+		for _, capability := range sc.Capabilities.Add {
+			allowed := baselineCapabilities[capability]
+			if pm.level == PodSecurityRestricted {
+				allowed = capability == "NET_BIND_SERVICE"
+			}
+			if allowed {
+				kept = append(kept, capability)
+			} else {
+				changes = append(changes, fmt.Sprintf("Removed capability %s from %s", capability, name))
+			}
+		}
+		sc.Capabilities.Add = kept
+	}
+
+	if pm.level == PodSecurityRestricted {
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			sc.AllowPrivilegeEscalation = boolPtr(false)
+			changes = append(changes, fmt.Sprintf("Disabled privilege escalation on %s", name))
+		}
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+		if !dropsAllCapabilities(sc.Capabilities) {
+			sc.Capabilities.Drop = append(sc.Capabilities.Drop, "ALL")
+			changes = append(changes, fmt.Sprintf("Dropped all capabilities on %s", name))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			violations = append(violations, fmt.Sprintf("%s sets runAsUser: 0", name))
+		}
+	}
+
+	return changes, violations
+}
+
+func dropsAllCapabilities(capabilities *corev1.Capabilities) bool {
+	for _, capability := range capabilities.Drop {
+		if capability == "ALL" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatPodSecurityReport(level string, changes, violations []string) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("### 🛡️ Pod Security (`%s`)\n", level))
+	for _, change := range changes {
+		content.WriteString(fmt.Sprintf("- %s\n", change))
+	}
+	if len(violations) > 0 {
+		content.WriteString("\n**⚠️ Violations that could not be fixed automatically** (the cluster may reject these pods):\n")
+		for _, violation := range violations {
+			content.WriteString(fmt.Sprintf("- %s\n", violation))
+		}
+	}
+	return content.String()
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"pr-previews/internal/types"
+)
+
+// Policy modes
+const (
+	PolicyEnforce = "enforce" // block the deploy on any violation
+	PolicyWarn    = "warn"    // deploy, but list the violations
+	PolicyOff     = "off"
+)
+
+// PolicyRule is a CEL expression that must hold for every object of the
+// listed kinds. Expressions see `object` (the rendered object),
+// `podRequests` and `limits` ({cpu: millicores, memory: bytes}).
+type PolicyRule struct {
+	Name       string   `yaml:"name"`
+	Kinds      []string `yaml:"kinds"` // Deployment, StatefulSet, Service...; empty matches all
+	Expression string   `yaml:"expression"`
+	Message    string   `yaml:"message"`
+}
+
+// policyFile is the operator's POLICY_FILE
+type policyFile struct {
+	Rules   []PolicyRule `yaml:"rules"`
+	Disable []string     `yaml:"disable"` // built-in rule names to skip
+}
+
+var workloadKinds = []string{"Deployment", "StatefulSet"}
+
+// builtinPolicyRules are the default guardrails; the request limit message
+// is filled in from config
+func builtinPolicyRules(maxCPU, maxMemory string) []PolicyRule {
+	return []PolicyRule{
+		{
+			Name:  "no-privileged-containers",
+			Kinds: workloadKinds,
+			Expression: `[has(object.spec.template.spec.containers) ? object.spec.template.spec.containers : [],
+				has(object.spec.template.spec.initContainers) ? object.spec.template.spec.initContainers : []].all(list,
+				list.all(c, !has(c.securityContext) || !has(c.securityContext.privileged) || !c.securityContext.privileged))`,
+			Message: "Containers must not run privileged",
+		},
+		{
+			Name:       "no-host-network",
+			Kinds:      workloadKinds,
+			Expression: `!has(object.spec.template.spec.hostNetwork) || !object.spec.template.spec.hostNetwork`,
+			Message:    "Pods must not use the host network",
+		},
+		{
+			Name:       "no-load-balancer",
+			Kinds:      []string{"Service"},
+			Expression: `!has(object.spec.type) || object.spec.type != 'LoadBalancer'`,
+			Message:    "Services must not be LoadBalancers; previews are exposed through the preview ingress",
+		},
+		{
+			Name:       "request-limits",
+			Kinds:      workloadKinds,
+			Expression: `podRequests.cpu <= limits.cpu && podRequests.memory <= limits.memory`,
+			Message:    fmt.Sprintf("Pod requests must stay within %s CPU and %s memory", maxCPU, maxMemory),
+		},
+	}
+}
+
+type compiledPolicyRule struct {
+	PolicyRule
+	program cel.Program
+}
+
+// PolicyEngine evaluates guardrail rules against rendered manifests before
+// they are deployed
+type PolicyEngine struct {
+	mu     sync.RWMutex
+	mode   string
+	rules  []compiledPolicyRule
+	limits map[string]int64
+}
+
+// sharedPolicyEngine is compiled once, in handlers.New, and used by every
+// command service
+var sharedPolicyEngine = &PolicyEngine{mode: PolicyOff}
+
+// SharedPolicyEngine is the process's policy engine
+func SharedPolicyEngine() *PolicyEngine {
+	return sharedPolicyEngine
+}
+
+// Configure compiles the policies. When POLICY_FILE can't be loaded the
+// built-in rules still apply, and when those can't be compiled either
+// (an unknown mode or limit) policies are off; either way the error is
+// returned for the startup log rather than failing every command.
+func (pe *PolicyEngine) Configure(mode, policyPath, maxCPU, maxMemory string) error {
+	engine, err := NewPolicyEngine(mode, policyPath, maxCPU, maxMemory)
+	if err != nil && policyPath != "" {
+		if builtin, builtinErr := NewPolicyEngine(mode, "", maxCPU, maxMemory); builtinErr == nil {
+			engine = builtin
+			err = fmt.Errorf("%v; only the built-in rules apply", err)
+		}
+	}
+	if engine == nil {
+		engine = &PolicyEngine{mode: PolicyOff}
+		err = fmt.Errorf("%v; policies are off", err)
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.mode, pe.rules, pe.limits = engine.mode, engine.rules, engine.limits
+	return err
+}
+
+// PolicyResult is one rule's outcome; Violations name the offending objects
+type PolicyResult struct {
+	Rule       string   `json:"rule"`
+	Message    string   `json:"message"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+// PolicyReport holds every rule's result for one manifest
+type PolicyReport struct {
+	Mode    string         `json:"mode"`
+	Results []PolicyResult `json:"results"`
+}
+
+// NewPolicyEngine compiles the built-in rules plus any from policyPath
+func NewPolicyEngine(mode, policyPath, maxCPU, maxMemory string) (*PolicyEngine, error) {
+	switch mode {
+	case PolicyEnforce, PolicyWarn, PolicyOff:
+	default:
+		return nil, fmt.Errorf("unknown policy mode %q", mode)
+	}
+
+	cpu, err := resource.ParseQuantity(maxCPU)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy CPU limit %q: %v", maxCPU, err)
+	}
+	memory, err := resource.ParseQuantity(maxMemory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy memory limit %q: %v", maxMemory, err)
+	}
+
+	var file policyFile
+	if policyPath != "" {
+		content, err := os.ReadFile(policyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy file: %v", err)
+		}
+		if err := yaml.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse policy file: %v", err)
+		}
+	}
+
+	disabled := map[string]bool{}
+	for _, name := range file.Disable {
+		disabled[name] = true
+	}
+	var rules []PolicyRule
+	for _, rule := range builtinPolicyRules(maxCPU, maxMemory) {
+		if !disabled[rule.Name] {
+			rules = append(rules, rule)
+		}
+	}
+	rules = append(rules, file.Rules...)
+
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("podRequests", cel.MapType(cel.StringType, cel.IntType)),
+		cel.Variable("limits", cel.MapType(cel.StringType, cel.IntType)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &PolicyEngine{
+		mode: mode,
+		limits: map[string]int64{
+			"cpu":    cpu.MilliValue(),
+			"memory": memory.Value(),
+		},
+	}
+	for _, rule := range rules {
+		if rule.Name == "" || rule.Expression == "" {
+			return nil, fmt.Errorf("policy rules need a name and an expression")
+		}
+		ast, issues := env.Compile(rule.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("policy rule %s: %v", rule.Name, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %s: %v", rule.Name, err)
+		}
+		if rule.Message == "" {
+			rule.Message = rule.Name
+		}
+		engine.rules = append(engine.rules, compiledPolicyRule{PolicyRule: rule, program: program})
+	}
+
+	return engine, nil
+}
+
+// Mode returns enforce, warn or off
+func (pe *PolicyEngine) Mode() string {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	return pe.mode
+}
+
+// Evaluate checks every rule against the rendered manifest. It returns nil
+// when policies are off.
+func (pe *PolicyEngine) Evaluate(parsed *ParsedManifest) (*PolicyReport, error) {
+	pe.mu.RLock()
+	mode, rules, limits := pe.mode, pe.rules, pe.limits
+	pe.mu.RUnlock()
+	if mode == PolicyOff {
+		return nil, nil
+	}
+
+	objects, err := policyObjects(parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PolicyReport{Mode: mode}
+	for _, rule := range rules {
+		result := PolicyResult{Rule: rule.Name, Message: rule.Message}
+		for _, obj := range objects {
+			if len(rule.Kinds) > 0 && !slices.Contains(rule.Kinds, obj.kind) {
+				continue
+			}
+
+			out, _, err := rule.program.Eval(map[string]interface{}{
+				"object":      obj.object,
+				"podRequests": obj.podRequests,
+				"limits":      limits,
+			})
+			switch {
+			case err != nil:
+				result.Violations = append(result.Violations, fmt.Sprintf("%s (could not evaluate: %v)", obj.ref, err))
+			case out.Value() != true:
+				result.Violations = append(result.Violations, obj.ref)
+			}
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+// Violated reports whether any rule failed
+func (r *PolicyReport) Violated() bool {
+	if r == nil {
+		return false
+	}
+	for _, result := range r.Results {
+		if len(result.Violations) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type policyObject struct {
+	kind        string
+	ref         string // Kind/name
+	object      map[string]interface{}
+	podRequests map[string]int64
+}
+
+func policyObjects(parsed *ParsedManifest) ([]policyObject, error) {
+	var objects []policyObject
+	add := func(kind, name string, obj interface{}, podSpec *corev1.PodSpec) error {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s/%s for policy checks: %v", kind, name, err)
+		}
+		objects = append(objects, policyObject{
+			kind:        kind,
+			ref:         fmt.Sprintf("%s/%s", kind, name),
+			object:      content,
+			podRequests: podSpecRequests(podSpec),
+		})
+		return nil
+	}
+
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		if err := add("Deployment", dep.Name, dep, &dep.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		if err := add("StatefulSet", sts.Name, sts, &sts.Spec.Template.Spec); err != nil {
+			return nil, err
+		}
+	}
+	for i := range parsed.Services {
+		svc := &parsed.Services[i]
+		if err := add("Service", svc.Name, svc, nil); err != nil {
+			return nil, err
+		}
+	}
+	for i := range parsed.ConfigMaps {
+		cm := &parsed.ConfigMaps[i]
+		if err := add("ConfigMap", cm.Name, cm, nil); err != nil {
+			return nil, err
+		}
+	}
+	for i := range parsed.PersistentVolumeClaims {
+		pvc := &parsed.PersistentVolumeClaims[i]
+		if err := add("PersistentVolumeClaim", pvc.Name, pvc, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+// podSpecRequests is the pod's effective request in millicores and bytes,
+// the way the scheduler counts it: the containers plus sidecar init
+// containers, or the largest regular init container if that is more
+func podSpecRequests(spec *corev1.PodSpec) map[string]int64 {
+	requests := map[string]int64{"cpu": 0, "memory": 0}
+	if spec == nil {
+		return requests
+	}
+	for _, container := range spec.Containers {
+		requests["cpu"] += container.Resources.Requests.Cpu().MilliValue()
+		requests["memory"] += container.Resources.Requests.Memory().Value()
+	}
+
+	// Sidecars run beside everything started after them; a regular init
+	// container runs alone, beside only the sidecars started before it
+	var sidecarCPU, sidecarMemory, initCPU, initMemory int64
+	for _, container := range spec.InitContainers {
+		cpu := container.Resources.Requests.Cpu().MilliValue()
+		memory := container.Resources.Requests.Memory().Value()
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			sidecarCPU += cpu
+			sidecarMemory += memory
+			continue
+		}
+		initCPU = max(initCPU, sidecarCPU+cpu)
+		initMemory = max(initMemory, sidecarMemory+memory)
+	}
+	requests["cpu"] = max(requests["cpu"]+sidecarCPU, initCPU)
+	requests["memory"] = max(requests["memory"]+sidecarMemory, initMemory)
+	return requests
+}
+
+// policyBlockedResponse refuses a deploy an enforced rule failed on
+func policyBlockedResponse(serviceName, manifestPath string, report *PolicyReport) *types.CommandResponse {
+	return &types.CommandResponse{
+		Success: false,
+		Message: "Policy violations",
+		Content: fmt.Sprintf("## 🛡️ Preview Blocked by Policy\n\n**Service:** `%s`\n**Manifest File:** %s\n\n%s\n*Fix the unchecked items and run `/preview %s` again.*",
+			serviceName, manifestPath, formatPolicyReport(report), serviceName),
+		Data: map[string]interface{}{
+			"service": serviceName,
+			"policy":  report,
+		},
+	}
+}
+
+// formatPolicyReport renders the results as a checklist
+func formatPolicyReport(report *PolicyReport) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("### 🛡️ Policy Checks (%s)\n", report.Mode))
+	for _, result := range report.Results {
+		if len(result.Violations) == 0 {
+			content.WriteString(fmt.Sprintf("- [x] `%s` — %s\n", result.Rule, result.Message))
+			continue
+		}
+		content.WriteString(fmt.Sprintf("- [ ] `%s` — %s\n", result.Rule, result.Message))
+		for _, violation := range result.Violations {
+			content.WriteString(fmt.Sprintf("  - %s\n", violation))
+		}
+	}
+	return content.String()
+}
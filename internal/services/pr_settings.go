@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"pr-previews/internal/types"
+)
+
+// prEnvConfigMap holds the PR description's env vars in every preview
+// namespace; workloads reference it optionally so it can appear later
+const prEnvConfigMap = "pr-env"
+
+// Limits on the settings block's env, which lands in a ConfigMap
+const (
+	maxPREnvVars       = 100
+	maxPREnvValueBytes = 4096
+)
+
+var (
+	prSettingsBlock = regexp.MustCompile("(?s)```pr-previews[ \\t]*\\r?\\n(.*?)```")
+	envVarName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// PRSettings is the fenced pr-previews YAML block in a PR description, e.g.
+//
+//	```pr-previews
+//	env:
+//	  FEATURE_FLAG: "on"
+//	services: [api, web]
+//	ttl: 72h
+//	```
+type PRSettings struct {
+	Env      map[string]string `yaml:"env"`
+	Services []string          `yaml:"services"` // deployed by a bare /preview
+	TTL      string            `yaml:"ttl"`      // overrides the repo config's ttl and PREVIEW_GC_MAX_AGE for this PR
+
+	ttl time.Duration
+}
+
+// ParsePRSettings extracts and validates the settings block. A description
+// without one yields nil.
+func ParsePRSettings(description string) (*PRSettings, error) {
+	matches := prSettingsBlock.FindStringSubmatch(description)
+	if matches == nil {
+		return nil, nil
+	}
+
+	settings := &PRSettings{}
+	if err := yaml.Unmarshal([]byte(matches[1]), settings); err != nil {
+		return nil, fmt.Errorf("invalid pr-previews block: %v", err)
+	}
+
+	if len(settings.Env) > maxPREnvVars {
+		return nil, fmt.Errorf("pr-previews block sets %d env vars; at most %d are allowed", len(settings.Env), maxPREnvVars)
+	}
+	for name, value := range settings.Env {
+		if !envVarName.MatchString(name) {
+			return nil, fmt.Errorf("invalid env var name in pr-previews block: %s", SanitizeEcho(name))
+		}
+		if len(value) > maxPREnvValueBytes {
+			return nil, fmt.Errorf("env var %s in pr-previews block is longer than %d bytes", name, maxPREnvValueBytes)
+		}
+		if strings.ContainsFunc(value, func(r rune) bool { return r == 0 || (r < ' ' && r != '\t' && r != '\n') }) {
+			return nil, fmt.Errorf("env var %s in pr-previews block has control characters", name)
+		}
+	}
+	for _, service := range settings.Services {
+		if err := ValidateServiceName(service); err != nil {
+			return nil, err
+		}
+	}
+	if settings.TTL != "" {
+		ttl, err := ParseTTL(settings.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl in pr-previews block: %s", SanitizeEcho(settings.TTL))
+		}
+		settings.ttl = ttl
+	}
+
+	return settings, nil
+}
+
+// TTLDuration returns the PR's preview lifetime override, or 0
+func (s *PRSettings) TTLDuration() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return s.ttl
+}
+
+// Equal reports whether two settings blocks would configure previews the same
+func (s *PRSettings) Equal(other *PRSettings) bool {
+	return reflect.DeepEqual(s, other)
+}
+
+// PREnvSource is the optional envFrom entry added to every preview container
+func PREnvSource() corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: prEnvConfigMap},
+			Optional:             boolPtr(true),
+		},
+	}
+}
+
+// LoadPRSettings reads the settings block from the PR description. Commands
+// without a repo (local testing) have no settings.
+func (cs *CommandServiceK8s) LoadPRSettings(ctx context.Context, cmd *types.Command) (*PRSettings, error) {
+	if cmd.Repo == "" || cs.config.GitHub.Token == "" {
+		return nil, nil
+	}
+
+	description, err := cs.github.GetPullRequestBody(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePRSettings(description)
+}
+
+// applyPRSettings writes the env ConfigMap and TTL for one preview namespace
+func (cs *CommandServiceK8s) applyPRSettings(ctx context.Context, namespace string, settings *PRSettings) error {
+	env := map[string]string{}
+	ttl := ""
+	if settings != nil {
+		for name, value := range settings.Env {
+			env[name] = value
+		}
+		if settings.TTLDuration() > 0 {
+			ttl = settings.TTLDuration().String()
+		}
+	}
+
+	if err := cs.k8s.UpsertConfigMap(ctx, namespace, prEnvConfigMap, env); err != nil {
+		return err
+	}
+	return cs.k8s.AnnotateNamespace(ctx, namespace, nil, map[string]string{ttlAnnotation: ttl})
+}
+
+// addPREnv makes every container in the manifest load the PR env ConfigMap
+func addPREnv(parsed *ParsedManifest) {
+	addTo := func(spec *corev1.PodSpec) {
+		for i := range spec.Containers {
+			spec.Containers[i].EnvFrom = append(spec.Containers[i].EnvFrom, PREnvSource())
+		}
+	}
+	for i := range parsed.Deployments {
+		addTo(&parsed.Deployments[i].Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		addTo(&parsed.StatefulSets[i].Spec.Template.Spec)
+	}
+}
+
+// HandleDescriptionEdit re-applies the settings block to the PR's running
+// previews after a deployer edits the description. A ttl past
+// PREVIEW_MAX_TTL is clamped to it.
+func (cs *CommandServiceK8s) HandleDescriptionEdit(ctx context.Context, repo string, prNumber int, previous, current string) *types.CommandResponse {
+	before, _ := ParsePRSettings(previous)
+	after, err := ParsePRSettings(current)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Invalid PR settings",
+			Content: fmt.Sprintf("## ❌ Invalid Preview Settings\n\n**Error:** %s\n\n*Running previews keep their previous settings.*", err.Error()),
+		}
+	}
+	clampNote := ""
+	if max := cs.config.Preview.MaxTTL; max > 0 {
+		if before.TTLDuration() > max {
+			before.ttl = max
+		}
+		if after.TTLDuration() > max {
+			clampNote = fmt.Sprintf("\n*The ttl of %s is longer than the %s allowed, so %s applies.*\n", formatAge(after.TTLDuration()), formatAge(max), formatAge(max))
+			after.ttl = max
+		}
+	}
+	if before.Equal(after) {
+		return &types.CommandResponse{Success: true, Message: "PR settings unchanged"}
+	}
+
+	namespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil || len(namespaces) == 0 {
+		return &types.CommandResponse{Success: true, Message: "No previews to update"}
+	}
+
+	var updated, failed []string
+	for _, name := range uniqueNamespaces(namespaces) {
+		if err := cs.applyPRSettings(ctx, name, after); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		// An edited ttl replaces the expiry the deploy recorded, even in a
+		// shared namespace; a removed one leaves the default until the next
+		// deploy resolves the TTL again
+		if ttl := after.TTLDuration(); ttl != before.TTLDuration() {
+			if _, err := cs.setPreviewExpiry(ctx, name, ttl, false); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+		}
+		// envFrom is only read at container start
+		if _, err := cs.k8s.RestartWorkloads(ctx, name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		updated = append(updated, name)
+	}
+
+	failureNote := ""
+	if len(failed) > 0 {
+		failureNote = fmt.Sprintf("\n### ⚠️ Failed\n%s", cs.formatResourcesList(failed))
+	}
+	return &types.CommandResponse{
+		Success: len(failed) == 0,
+		Message: "PR settings applied",
+		Content: fmt.Sprintf("## 🔧 Preview Settings Updated\n\nThe `pr-previews` block in the description changed, so these previews were updated and restarted:\n\n%s%s\n%s%s",
+			formatNamespaceList(updated), failureNote, formatPRSettings(after), clampNote),
+		Data: map[string]interface{}{
+			"updated": updated,
+			"failed":  failed,
+			"repo":    repo,
+		},
+	}
+}
+
+func formatPRSettings(settings *PRSettings) string {
+	if settings == nil {
+		return "*No settings block: env vars and TTL override were removed.*"
+	}
+
+	var content strings.Builder
+	if len(settings.Env) > 0 {
+		var names []string
+		for name := range settings.Env {
+			names = append(names, "`"+name+"`")
+		}
+		sort.Strings(names)
+		content.WriteString(fmt.Sprintf("**Env:** %s\n", strings.Join(names, ", ")))
+	}
+	if settings.TTLDuration() > 0 {
+		content.WriteString(fmt.Sprintf("**TTL:** %s\n", settings.TTLDuration()))
+	}
+	if len(settings.Services) > 0 {
+		content.WriteString(fmt.Sprintf("**Services:** %s\n", strings.Join(settings.Services, ", ")))
+	}
+	return content.String()
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"pr-previews/internal/types"
+)
+
+const (
+	// PreviewAllService is the /preview target that deploys every service
+	PreviewAllService = "all"
+
+	// previewAllArg marks the services of one /preview all run; flags can't
+	// set it since their names have no underscore
+	previewAllArg = "preview_all"
+
+	// previewServicesConfigMap holds a <SERVICE>_URL entry for every Service
+	// of a /preview all run, loaded into each container
+	previewServicesConfigMap = "preview-services"
+)
+
+// PreviewAllPlan is what /preview all will deploy, checked against the
+// quotas before anything is applied
+type PreviewAllPlan struct {
+	Services  []string          `json:"services"`
+	Requests  ResourceTotals    `json:"requests"`
+	Endpoints map[string]string `json:"endpoints"`
+	Warnings  []string          `json:"warnings"`
+}
+
+// PreviewAllCommand is the deploy of one service within a /preview all run
+func PreviewAllCommand(cmd *types.Command, service string) *types.Command {
+	serviceCmd := *cmd
+	serviceCmd.Service = service
+	serviceCmd.Args = map[string]string{previewAllArg: "true"}
+	for key, value := range cmd.Args {
+		serviceCmd.Args[key] = value
+	}
+	return &serviceCmd
+}
+
+// previewAllRun reports whether the deploy is part of a /preview all run
+func previewAllRun(cmd *types.Command) bool {
+	return cmd.Args[previewAllArg] == "true"
+}
+
+// PlanPreviewAll discovers every deployable service in the repo, changed or
+// not, and totals what they request together. It returns a response instead
+// of a plan when the repo's quota or the shared namespace's quota has no
+// room for all of them, so a run never stops halfway.
+func (cs *CommandServiceK8s) PlanPreviewAll(ctx context.Context, cmd *types.Command, repoPath string) (*PreviewAllPlan, *types.CommandResponse) {
+	repoConfig, err := cs.loadRepoConfig(ctx, cmd, repoPath)
+	if err != nil {
+		return nil, failedResponse("Repo config error", "Repo Config Error", err)
+	}
+
+	plan := &PreviewAllPlan{Endpoints: map[string]string{}, Warnings: []string{}}
+	defaults := cs.requestDefaults()
+	for _, service := range cs.previewAllServices(repoPath, repoConfig) {
+		plan.Services = append(plan.Services, service.Name)
+		if service.Backend == BackendChart {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("`%s` deploys from a remote chart, so its requests and Services aren't known until it renders", service.Name))
+			continue
+		}
+		parsed, err := cs.renderPlanManifest(cmd, service, repoPath, repoConfig)
+		if err != nil {
+			return nil, failedResponse("Preview all failed", "Preview All Failed", fmt.Errorf("%s could not be rendered: %v", service.Name, err))
+		}
+		requests, _ := manifestRequests(parsed, defaults)
+		plan.Requests.Add(requests)
+		for name, url := range serviceEndpoints(parsed) {
+			plan.Endpoints[name] = url
+		}
+	}
+	if len(plan.Services) == 0 {
+		return nil, &types.CommandResponse{
+			Success: false,
+			Message: "No deployable services",
+			Content: "## ❌ No Deployable Services\n\nNo manifests, compose file or remote charts were found.\n\n**To add services:** Create YAML manifests in `k8s/`, `kubernetes/`, `manifests/`, or `deploy/` folders.",
+		}
+	}
+
+	// Everything lands in one namespace, so its quota must fit the total
+	if over := cs.quotaWarnings(&ResourceImpact{Shared: true, Total: plan.Requests}); len(over) > 0 {
+		return nil, &types.CommandResponse{
+			Success: false,
+			Message: "Shared namespace quota exceeded",
+			Content: fmt.Sprintf("## 🚫 Preview All Over Namespace Quota\n\n%s\n*Deploy the services you need with `/preview <service>` instead.*", cs.formatResourcesList(over)),
+			Data: map[string]interface{}{
+				"plan": plan,
+			},
+		}
+	}
+
+	// Every service lands in the shared namespace, replacing what runs there;
+	// each deploy reserves its own share when it runs
+	release, err := cs.checkRepoQuota(ctx, cmd.Repo, plan.Requests, &ReplacedWorkloads{Namespace: previewNamespace(cmd.PRNumber, "", true)})
+	if err != nil {
+		var quotaErr *RepoQuotaError
+		if !errors.As(err, &quotaErr) {
+			return nil, failedResponse("Repository quota check failed", "Repository Quota Check Failed", err)
+		}
+		return nil, &types.CommandResponse{
+			Success: false,
+			Message: "Repository quota exceeded",
+			Content: formatRepoQuotaError(PreviewAllService, quotaErr),
+			Data: map[string]interface{}{
+				"plan":       plan,
+				"in_use":     quotaErr.InUse,
+				"namespaces": quotaErr.Namespaces,
+			},
+		}
+	}
+	release()
+	return plan, nil
+}
+
+// previewAllServices is every service /preview can deploy: manifests, the
+// compose file and remote charts
+func (cs *CommandServiceK8s) previewAllServices(repoPath string, repoConfig *RepoConfig) []DiscoveredService {
+	var deployable []DiscoveredService
+	seen := make(map[string]bool)
+	for _, service := range cs.DiscoverServices(repoPath) {
+		if (service.Backend == BackendManifest || service.Backend == BackendCompose) && !seen[service.Name] {
+			seen[service.Name] = true
+			deployable = append(deployable, service)
+		}
+	}
+
+	charts := make([]string, 0, len(repoConfig.Charts))
+	for name := range repoConfig.Charts {
+		charts = append(charts, name)
+	}
+	sort.Strings(charts)
+	for _, name := range charts {
+		if !seen[name] {
+			seen[name] = true
+			deployable = append(deployable, DiscoveredService{Name: name, Backend: BackendChart, Path: repoConfigFile})
+		}
+	}
+	return deployable
+}
+
+// serviceEndpoints names a URL for each of the manifest's Services, e.g.
+// USER_API_URL=http://user-api:8080, reachable by name in the shared
+// namespace
+func serviceEndpoints(parsed *ParsedManifest) map[string]string {
+	endpoints := make(map[string]string)
+	for _, svc := range parsed.Services {
+		if len(svc.Spec.Ports) == 0 {
+			continue
+		}
+		name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(svc.Name)) + "_URL"
+		if !envVarName.MatchString(name) {
+			continue
+		}
+		endpoints[name] = fmt.Sprintf("http://%s:%d", svc.Name, svc.Spec.Ports[0].Port)
+	}
+	return endpoints
+}
+
+// previewAllEndpoints renders the run's other services to learn the URLs
+// each deploy wires in. Every deploy of the run writes the full set, so the
+// first one already sees the services that come after it.
+func (cs *CommandServiceK8s) previewAllEndpoints(cmd *types.Command, repoPath string, repoConfig *RepoConfig) map[string]string {
+	endpoints := make(map[string]string)
+	for _, service := range cs.previewAllServices(repoPath, repoConfig) {
+		if service.Backend == BackendChart {
+			continue
+		}
+		parsed, err := cs.renderPlanManifest(cmd, service, repoPath, repoConfig)
+		if err != nil {
+			continue
+		}
+		for name, url := range serviceEndpoints(parsed) {
+			endpoints[name] = url
+		}
+	}
+	return endpoints
+}
+
+// PreviewServicesSource is the optional envFrom entry that loads the run's
+// service URLs
+func PreviewServicesSource() corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: previewServicesConfigMap},
+			Optional:             boolPtr(true),
+		},
+	}
+}
+
+// addServiceEndpointsEnv makes every container load the run's service URLs
+func addServiceEndpointsEnv(parsed *ParsedManifest) {
+	addTo := func(spec *corev1.PodSpec) {
+		for i := range spec.Containers {
+			spec.Containers[i].EnvFrom = append(spec.Containers[i].EnvFrom, PreviewServicesSource())
+		}
+	}
+	for i := range parsed.Deployments {
+		addTo(&parsed.Deployments[i].Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		addTo(&parsed.StatefulSets[i].Spec.Template.Spec)
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ListPreviews returns the running previews for the REST API, optionally of
+// one repository or PR, with the expiry the reaper goes by
+func (cs *CommandServiceK8s) ListPreviews(ctx context.Context, repo string, prNumber int) ([]PreviewNamespace, error) {
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := []PreviewNamespace{}
+	for _, ns := range namespaces {
+		if prNumber > 0 && ns.PRNumber != prNumber {
+			continue
+		}
+		if repo != "" && !strings.EqualFold(ns.Repo, repo) {
+			continue
+		}
+		if expiresAt, ok := cs.previewExpiry(ns); ok {
+			ns.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		}
+		previews = append(previews, ns)
+	}
+	sort.Slice(previews, func(i, j int) bool {
+		return previews[i].Name < previews[j].Name
+	})
+	return previews, nil
+}
+
+// PreviewLogOptions selects what StreamPreviewLogs reads
+type PreviewLogOptions struct {
+	Pod       string // only this pod; every pod of the preview when empty
+	Container string // only this container; every container when empty
+	Follow    bool
+	TailLines int64 // lines of history per container; 0 reads it all
+}
+
+// PreviewLogLine is one line a preview container logged
+type PreviewLogLine struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Line      string `json:"line"`
+}
+
+// StreamPreviewLogs reads the logs of the service's preview on the PR of repo,
+// calling emit for each line as containers write them. Without Follow it
+// returns once the logs so far are read; with it, when ctx is cancelled.
+func (cs *CommandServiceK8s) StreamPreviewLogs(ctx context.Context, repo string, prNumber int, service string, opts PreviewLogOptions, emit func(PreviewLogLine)) error {
+	preview, err := cs.findPreview(ctx, repo, prNumber, service)
+	if err != nil {
+		return err
+	}
+	if preview == nil {
+		return fmt.Errorf("no preview of %s on PR #%d", service, prNumber)
+	}
+	namespace := preview.Name
+
+	pods, err := cs.k8s.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	type source struct{ pod, container string }
+	var sources []source
+	for _, pod := range pods.Items {
+		if opts.Pod != "" && pod.Name != opts.Pod {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			if opts.Container == "" || container.Name == opts.Container {
+				sources = append(sources, source{pod.Name, container.Name})
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no matching containers in %s", namespace)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures []string
+	)
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src source) {
+			defer wg.Done()
+			logOptions := &corev1.PodLogOptions{Container: src.container, Follow: opts.Follow}
+			if opts.TailLines > 0 {
+				logOptions.TailLines = &opts.TailLines
+			}
+			stream, err := cs.k8s.client.CoreV1().Pods(namespace).GetLogs(src.pod, logOptions).Stream(ctx)
+			if err != nil {
+				mu.Lock()
+				failures = append(failures, fmt.Sprintf("%s/%s: %v", src.pod, src.container, err))
+				mu.Unlock()
+				return
+			}
+			defer stream.Close()
+
+			scanner := bufio.NewScanner(stream)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				mu.Lock()
+				emit(PreviewLogLine{Pod: src.pod, Container: src.container, Line: scanner.Text()})
+				mu.Unlock()
+			}
+		}(src)
+	}
+	wg.Wait()
+
+	if len(failures) == len(sources) {
+		sort.Strings(failures)
+		return fmt.Errorf("failed to read logs: %s", failures[0])
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Keys pr-previews sets itself; passthrough labels and annotations may not
+// use them, since cleanup, the warm pool and reporting select on them
+var reservedMetadataKeys = map[string]bool{
+	"preview":            true,
+	"pr-number":          true,
+	"service":            true,
+	"created-by":         true,
+	"environment":        true,
+	"managed-by":         true,
+	sharedNamespaceLabel: true,
+}
+
+const reservedMetadataPrefix = "pr-previews.io/"
+
+// parseMetadataPairs reads key=value entries of NAMESPACE_LABELS and
+// NAMESPACE_ANNOTATIONS
+func parseMetadataPairs(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q must be key=value", pair)
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// validateMetadata checks passthrough labels and annotations are valid
+// Kubernetes keys and values and stay clear of the reserved ones
+func validateMetadata(labels, annotations map[string]string) error {
+	check := func(kind, key string) error {
+		if reservedMetadataKeys[key] || strings.HasPrefix(key, reservedMetadataPrefix) {
+			return fmt.Errorf("%s %q is reserved for pr-previews", kind, key)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid %s key %q: %s", kind, key, strings.Join(errs, "; "))
+		}
+		return nil
+	}
+	for _, key := range sortedKeys(labels) {
+		if err := check("label", key); err != nil {
+			return err
+		}
+		if errs := validation.IsValidLabelValue(labels[key]); len(errs) > 0 {
+			return fmt.Errorf("invalid value for label %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+	for _, key := range sortedKeys(annotations) {
+		if err := check("annotation", key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previewMetadata is the labels and annotations passed through to the
+// repo's preview namespaces and workloads. The operator's win over the
+// repo's, so a repo can't opt out of chargeback or mesh settings.
+func (cs *CommandServiceK8s) previewMetadata(repoConfig *RepoConfig) (map[string]string, map[string]string) {
+	labels, annotations := map[string]string{}, map[string]string{}
+	if repoConfig != nil {
+		addMissingMetadata(labels, repoConfig.Labels)
+		addMissingMetadata(annotations, repoConfig.Annotations)
+	}
+	if baseline := cs.k8s.baseline; baseline != nil {
+		for key, value := range baseline.Labels {
+			labels[key] = value
+		}
+		for key, value := range baseline.Annotations {
+			annotations[key] = value
+		}
+	}
+	return labels, annotations
+}
+
+// namespaceMetadataAllowed reports whether a repo may set key on its
+// namespaces: an exact NAMESPACE_REPO_METADATA_KEYS entry, or one ending in
+// * that it starts with
+func (cs *CommandServiceK8s) namespaceMetadataAllowed(key string) bool {
+	for _, allowed := range cs.config.Namespaces.RepoMetadataKeys {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(key, prefix) || key == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceMetadata is previewMetadata with only the repo's keys the
+// operator allows on namespaces; the second result is false when the repo
+// sets none of them
+func (cs *CommandServiceK8s) namespaceMetadata(repoConfig *RepoConfig) (map[string]string, map[string]string, bool) {
+	allowed := &RepoConfig{Labels: map[string]string{}, Annotations: map[string]string{}}
+	if repoConfig != nil {
+		for key, value := range repoConfig.Labels {
+			if cs.namespaceMetadataAllowed(key) {
+				allowed.Labels[key] = value
+			}
+		}
+		for key, value := range repoConfig.Annotations {
+			if cs.namespaceMetadataAllowed(key) {
+				allowed.Annotations[key] = value
+			}
+		}
+	}
+	labels, annotations := cs.previewMetadata(allowed)
+	return labels, annotations, len(allowed.Labels) > 0 || len(allowed.Annotations) > 0
+}
+
+// labelPreviewNamespace adds the repo's passthrough labels and annotations
+// the operator allows on namespaces to its preview namespace; the namespace
+// baseline already added the operator's
+func (cs *CommandServiceK8s) labelPreviewNamespace(ctx context.Context, namespace string, repoConfig *RepoConfig) error {
+	labels, annotations, set := cs.namespaceMetadata(repoConfig)
+	if !set {
+		return nil
+	}
+	return cs.k8s.AnnotateNamespace(ctx, namespace, labels, annotations)
+}
+
+// applyPreviewMetadata adds the passthrough labels and annotations to the
+// manifest's Deployments and StatefulSets and their pod templates. Keys the
+// manifest sets itself are left alone, so selectors keep matching.
+func (cs *CommandServiceK8s) applyPreviewMetadata(parsed *ParsedManifest, repoConfig *RepoConfig) {
+	labels, annotations := cs.previewMetadata(repoConfig)
+	if parsed == nil || (len(labels) == 0 && len(annotations) == 0) {
+		return
+	}
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		dep.Labels = withMissingMetadata(dep.Labels, labels)
+		dep.Annotations = withMissingMetadata(dep.Annotations, annotations)
+		dep.Spec.Template.Labels = withMissingMetadata(dep.Spec.Template.Labels, labels)
+		dep.Spec.Template.Annotations = withMissingMetadata(dep.Spec.Template.Annotations, annotations)
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		sts.Labels = withMissingMetadata(sts.Labels, labels)
+		sts.Annotations = withMissingMetadata(sts.Annotations, annotations)
+		sts.Spec.Template.Labels = withMissingMetadata(sts.Spec.Template.Labels, labels)
+		sts.Spec.Template.Annotations = withMissingMetadata(sts.Spec.Template.Annotations, annotations)
+	}
+}
+
+// withMissingMetadata adds the keys of extra that values lacks, allocating
+// values if needed
+func withMissingMetadata(values, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return values
+	}
+	if values == nil {
+		values = make(map[string]string, len(extra))
+	}
+	addMissingMetadata(values, extra)
+	return values
+}
+
+func addMissingMetadata(values, extra map[string]string) {
+	for key, value := range extra {
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/types"
+)
+
+// PRStatus is the machine-readable /status of a PR, served by
+// /status --format=json and GET /api/v1/previews/:pr/status
+type PRStatus struct {
+	PRNumber  int             `json:"pr_number"`
+	Repo      string          `json:"repo,omitempty"`
+	Ready     bool            `json:"ready"` // every preview has all pods ready and passed its readiness URL
+	Previews  []PreviewStatus `json:"previews"`
+	CheckedAt time.Time       `json:"checked_at"`
+}
+
+// PreviewStatus is one service's preview
+type PreviewStatus struct {
+	Service      string           `json:"service"`
+	Namespace    string           `json:"namespace"`
+	Repo         string           `json:"repo,omitempty"`
+	Shared       bool             `json:"shared"`
+	Phase        string           `json:"phase"` // the namespace's, Active or Terminating
+	Ready        bool             `json:"ready"`
+	URL          string           `json:"url,omitempty"`
+	Readiness    string           `json:"readiness,omitempty"` // readiness URL check: pending, passed or why it failed
+	CreatedAt    *time.Time       `json:"created_at,omitempty"`
+	ExpiresAt    *time.Time       `json:"expires_at,omitempty"`
+	Deployments  []WorkloadStatus `json:"deployments"`
+	StatefulSets []WorkloadStatus `json:"statefulsets"`
+	Pods         []PodStatus      `json:"pods"`
+}
+
+// WorkloadStatus is a Deployment or StatefulSet of a preview
+type WorkloadStatus struct {
+	Name          string `json:"name"`
+	Replicas      int32  `json:"replicas"`
+	ReadyReplicas int32  `json:"ready_replicas"`
+}
+
+// PodStatus is a pod of a preview
+type PodStatus struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	Waiting  string `json:"waiting,omitempty"` // e.g. CrashLoopBackOff
+}
+
+// PreviewStatusOf collects the typed status of every preview of the PR,
+// optionally only of one repository
+func (cs *CommandServiceK8s) PreviewStatusOf(ctx context.Context, repo string, prNumber int) (*PRStatus, error) {
+	namespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &PRStatus{PRNumber: prNumber, Repo: repo, Ready: true, Previews: []PreviewStatus{}, CheckedAt: time.Now().UTC()}
+	for _, ns := range namespaces {
+		preview, err := cs.previewStatus(ctx, ns)
+		if err != nil {
+			return nil, err
+		}
+		status.Ready = status.Ready && preview.Ready
+		status.Previews = append(status.Previews, *preview)
+	}
+	sort.Slice(status.Previews, func(i, j int) bool {
+		return status.Previews[i].Service < status.Previews[j].Service
+	})
+	if len(status.Previews) == 0 {
+		status.Ready = false
+	}
+	return status, nil
+}
+
+func (cs *CommandServiceK8s) previewStatus(ctx context.Context, ns PreviewNamespace) (*PreviewStatus, error) {
+	preview := &PreviewStatus{
+		Service:      ns.Service,
+		Namespace:    ns.Name,
+		Repo:         ns.Repo,
+		Shared:       ns.Shared,
+		Phase:        ns.Status,
+		URL:          ns.URL(),
+		Readiness:    ns.Readiness,
+		Deployments:  []WorkloadStatus{},
+		StatefulSets: []WorkloadStatus{},
+		Pods:         []PodStatus{},
+	}
+	if !ns.CreatedAt.IsZero() {
+		createdAt := ns.CreatedAt.UTC()
+		preview.CreatedAt = &createdAt
+	}
+	if expiresAt, ok := cs.previewExpiry(ns); ok {
+		expiresAt = expiresAt.UTC()
+		preview.ExpiresAt = &expiresAt
+	}
+
+	// A shared namespace holds the PR's other services too
+	belongs := func(name string, labels map[string]string) bool {
+		return !preview.Shared || name == preview.Service || strings.HasPrefix(name, preview.Service+"-") || labels["app"] == preview.Service
+	}
+
+	client := cs.k8s.client
+	deployments, err := client.AppsV1().Deployments(preview.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", preview.Namespace, err)
+	}
+	for _, dep := range deployments.Items {
+		if belongs(dep.Name, dep.Labels) {
+			preview.Deployments = append(preview.Deployments, WorkloadStatus{Name: dep.Name, Replicas: dep.Status.Replicas, ReadyReplicas: dep.Status.ReadyReplicas})
+		}
+	}
+	statefulSets, err := client.AppsV1().StatefulSets(preview.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", preview.Namespace, err)
+	}
+	for _, sts := range statefulSets.Items {
+		if belongs(sts.Name, sts.Labels) {
+			preview.StatefulSets = append(preview.StatefulSets, WorkloadStatus{Name: sts.Name, Replicas: sts.Status.Replicas, ReadyReplicas: sts.Status.ReadyReplicas})
+		}
+	}
+	pods, err := client.CoreV1().Pods(preview.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in %s: %v", preview.Namespace, err)
+	}
+	for _, pod := range pods.Items {
+		// Finished Job pods don't count, like in the rollout progress
+		if !belongs(pod.Name, pod.Labels) || pod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		preview.Pods = append(preview.Pods, podStatus(&pod))
+	}
+	sort.Slice(preview.Pods, func(i, j int) bool { return preview.Pods[i].Name < preview.Pods[j].Name })
+
+	preview.Ready = preview.Phase == string(corev1.NamespaceActive) && len(preview.Pods) > 0 &&
+		(preview.Readiness == "" || preview.Readiness == ReadinessPassed)
+	for _, pod := range preview.Pods {
+		preview.Ready = preview.Ready && pod.Ready
+	}
+	return preview, nil
+}
+
+func podStatus(pod *corev1.Pod) PodStatus {
+	status := PodStatus{Name: pod.Name, Phase: string(pod.Status.Phase)}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			status.Ready = true
+		}
+	}
+	for _, container := range pod.Status.ContainerStatuses {
+		status.Restarts += container.RestartCount
+		if container.State.Waiting != nil && status.Waiting == "" {
+			status.Waiting = container.State.Waiting.Reason
+		}
+	}
+	return status
+}
+
+// handleStatusJSON answers /status --format=json with the PR's status as a
+// JSON code block, for bots and CI jobs that read the comment
+func (cs *CommandServiceK8s) handleStatusJSON(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	status, err := cs.PreviewStatusOf(ctx, cmd.Repo, cmd.PRNumber)
+	if err != nil {
+		return failedResponse("Failed to get preview status", "Preview Status Failed", err)
+	}
+	encoded, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return failedResponse("Failed to get preview status", "Preview Status Failed", err)
+	}
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Preview environment status",
+		Content: "```json\n" + string(encoded) + "\n```",
+		Data: map[string]interface{}{
+			"status": status,
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ttlAnnotation on a namespace holds the PR description's TTL; on a
+	// manifest's Deployment or StatefulSet it sets that service's TTL
+	ttlAnnotation = "pr-previews.io/ttl"
+
+	// expiresAtAnnotation is when the reaper may delete the namespace, set
+	// from the TTL a deploy asked for
+	expiresAtAnnotation = "pr-previews.io/expires-at"
+)
+
+// ParseTTL parses a preview lifetime: a Go duration such as 36h, or whole
+// days such as 7d
+func ParseTTL(value string) (time.Duration, error) {
+	var ttl time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		ttl, err = time.ParseDuration(value)
+	}
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q: use a duration such as 36h or 7d", SanitizeEcho(value))
+	}
+	return ttl, nil
+}
+
+// resolvePreviewTTL picks the deploy's TTL: the --ttl flag, then the PR
+// description's ttl, then a pr-previews.io/ttl annotation in the manifest,
+// then the repo config's ttl, which may come from the org-wide config. It
+// returns 0 with no source when none is set, leaving PREVIEW_GC_MAX_AGE to
+// apply. Between deploys only the description can change, and an edit of
+// its ttl replaces the expiry whatever source the deploy took it from.
+func resolvePreviewTTL(flag string, prSettings *PRSettings, parsed *ParsedManifest, repoConfig *RepoConfig, max time.Duration) (time.Duration, string, error) {
+	value, source := flag, "--ttl"
+	if value == "" && prSettings != nil {
+		value, source = prSettings.TTL, "PR description"
+	}
+	if value == "" && parsed != nil {
+		value, source = manifestTTL(parsed), "manifest annotation"
+	}
+	if value == "" && repoConfig != nil {
+		value, source = repoConfig.TTL, repoConfigFile
+	}
+	if value == "" {
+		return 0, "", nil
+	}
+
+	ttl, err := ParseTTL(value)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %v", source, err)
+	}
+	if max > 0 && ttl > max {
+		return 0, "", fmt.Errorf("%s: ttl %s is longer than the %s allowed", source, formatAge(ttl), formatAge(max))
+	}
+	return ttl, source, nil
+}
+
+// manifestTTL is the first TTL annotation on the manifest's workloads
+func manifestTTL(parsed *ParsedManifest) string {
+	for _, dep := range parsed.Deployments {
+		if value := dep.Annotations[ttlAnnotation]; value != "" {
+			return value
+		}
+	}
+	for _, sts := range parsed.StatefulSets {
+		if value := sts.Annotations[ttlAnnotation]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// setPreviewExpiry records when the namespace's preview expires; it's the
+// only writer of the expiry. A deploy into a shared namespace keeps the
+// latest expiry of its services (keepLater), so a short-lived one doesn't
+// take the others down with it. Otherwise the expiry is replaced, and
+// without a TTL the namespace goes back to the default.
+func (cs *CommandServiceK8s) setPreviewExpiry(ctx context.Context, namespace string, ttl time.Duration, keepLater bool) (time.Time, error) {
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UTC()
+	}
+	if keepLater {
+		if ttl == 0 {
+			return time.Time{}, nil
+		}
+		if current, err := cs.k8s.GetNamespaceAnnotation(ctx, namespace, expiresAtAnnotation); err == nil {
+			if previous, err := time.Parse(time.RFC3339, current); err == nil && previous.After(expiresAt) {
+				return previous, nil
+			}
+		}
+	}
+
+	value := ""
+	if !expiresAt.IsZero() {
+		value = expiresAt.Format(time.RFC3339)
+	}
+	if err := cs.k8s.AnnotateNamespace(ctx, namespace, nil, map[string]string{expiresAtAnnotation: value}); err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+// previewExpiry is when the reaper may delete the namespace: the expiry a
+// deploy recorded, otherwise its creation plus previewTTL
+func (cs *CommandServiceK8s) previewExpiry(ns PreviewNamespace) (time.Time, bool) {
+	if ns.ExpiresAt != "" {
+		if expiresAt, err := time.Parse(time.RFC3339, ns.ExpiresAt); err == nil {
+			return expiresAt, true
+		}
+	}
+	if ns.CreatedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return ns.CreatedAt.Add(cs.previewTTL(ns)), true
+}
+
+// previewRemaining describes how long the preview has left
+func previewRemaining(expiresAt time.Time) string {
+	remaining := time.Until(expiresAt)
+	if remaining <= 0 {
+		return "expired"
+	}
+	return formatAge(remaining)
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// How a preview /wait request ends
+const (
+	PreviewWaitReady   = "ready"
+	PreviewWaitFailed  = "failed"
+	PreviewWaitTimeout = "timeout" // still missing or rolling out when the wait ran out
+)
+
+const (
+	previewWaitInterval = 5 * time.Second
+
+	// A container that has restarted this often is crashing, not starting
+	crashLoopRestarts = 3
+)
+
+// PreviewWaitResult is where a preview got to while a CI job waited on it
+type PreviewWaitResult struct {
+	Repo      string `json:"repo,omitempty"`
+	PRNumber  int    `json:"pr_number"`
+	Service   string `json:"service"`
+	Namespace string `json:"namespace,omitempty"`
+	State     string `json:"state"`
+	URL       string `json:"url,omitempty"`
+	Progress  string `json:"progress,omitempty"`
+	Reason    string `json:"reason,omitempty"` // why it failed, or what it was waiting on
+	Waited    string `json:"waited"`
+}
+
+// WaitForPreview blocks until the service's preview on the repo's PR has
+// every pod ready, has failed (image pulls, crash loops, deletion), or
+// timeout passes. The preview doesn't have to exist yet, so a CI job can
+// start waiting right after asking for the deploy.
+func (cs *CommandServiceK8s) WaitForPreview(ctx context.Context, repo string, prNumber int, service string, timeout time.Duration) (*PreviewWaitResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	started := time.Now()
+	result := &PreviewWaitResult{Repo: repo, PRNumber: prNumber, Service: service}
+	ticker := time.NewTicker(previewWaitInterval)
+	defer ticker.Stop()
+
+	for {
+		done, err := cs.checkPreviewWait(ctx, result)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if done {
+			result.Waited = time.Since(started).Round(time.Second).String()
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			result.State = PreviewWaitTimeout
+			result.Waited = time.Since(started).Round(time.Second).String()
+			if result.Namespace == "" {
+				result.Reason = "no preview of this service exists yet"
+			}
+			return result, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkPreviewWait fills in the preview's current state, reporting whether
+// waiting is over
+func (cs *CommandServiceK8s) checkPreviewWait(ctx context.Context, result *PreviewWaitResult) (bool, error) {
+	preview, err := cs.findPreview(ctx, result.Repo, result.PRNumber, result.Service)
+	if err != nil || preview == nil {
+		return false, err
+	}
+
+	result.Namespace = preview.Name
+	result.URL = preview.URL()
+	if preview.Terminating() {
+		result.State = PreviewWaitFailed
+		result.Reason = "the preview is being deleted"
+		return true, nil
+	}
+
+	if reason, err := cs.k8s.GetPreviewFailure(ctx, result.Namespace); err != nil {
+		return false, err
+	} else if reason != "" {
+		result.State = PreviewWaitFailed
+		result.Reason = reason
+		return true, nil
+	}
+
+	progress, err := cs.k8s.GetRolloutProgress(ctx, result.Namespace)
+	if err != nil {
+		return false, err
+	}
+	result.Progress = progress.String()
+	result.Reason = progress.Waiting
+	if !progress.Complete() {
+		return false, nil
+	}
+	result.Progress = fmt.Sprintf("%d/%d pods ready", progress.Ready, progress.Total)
+
+	// A readiness URL check, when the repo has one, has the last word
+	switch readiness := preview.Readiness; readiness {
+	case "", ReadinessPassed:
+		result.State = PreviewWaitReady
+		result.Reason = ""
+		return true, nil
+	case ReadinessPending:
+		result.Reason = "waiting for the preview URL to return 200"
+		return false, nil
+	default:
+		result.State = PreviewWaitFailed
+		result.Reason = "the preview URL check failed: " + readiness
+		return true, nil
+	}
+}
+
+// findPreview returns the namespace info of the service's preview on the PR
+// of repo, or nil when there is none
+func (cs *CommandServiceK8s) findPreview(ctx context.Context, repo string, prNumber int, service string) (*PreviewNamespace, error) {
+	namespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+	cleanServiceName := strings.ReplaceAll(service, "/", "-")
+	for _, ns := range namespaces {
+		if ns.Service == service || ns.Service == cleanServiceName {
+			return &ns, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetPreviewFailure describes why the namespace's pods won't become ready
+// on their own - a bad image or a crash-looping container - or returns ""
+// while they still might
+func (k *K8sService) GetPreviewFailure(ctx context.Context, namespace string) (string, error) {
+	pods, err := k.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods in %s: %v", namespace, err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodFailed && pod.Spec.RestartPolicy == corev1.RestartPolicyNever {
+			return fmt.Sprintf("pod %s failed: %s", pod.Name, pod.Status.Reason), nil
+		}
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if status.State.Waiting == nil {
+				continue
+			}
+			switch status.State.Waiting.Reason {
+			case "ImagePullBackOff", "ErrImagePull", "InvalidImageName":
+				return fmt.Sprintf("container %s in pod %s can't pull %s: %s", status.Name, pod.Name, status.Image, diagnoseImagePull(status.State.Waiting.Reason, status.State.Waiting.Message)), nil
+			case "CreateContainerConfigError":
+				return fmt.Sprintf("container %s in pod %s can't start: %s", status.Name, pod.Name, status.State.Waiting.Message), nil
+			case "CrashLoopBackOff":
+				if status.RestartCount >= crashLoopRestarts {
+					return fmt.Sprintf("container %s in pod %s is crash looping (%d restarts)", status.Name, pod.Name, status.RestartCount), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
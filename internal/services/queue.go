@@ -0,0 +1,268 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDeploymentDuration = 2 * time.Minute
+
+// PriorityUrgent is the priority given to preview-priority PRs
+const PriorityUrgent = 100
+
+// QueueJob is a deployment waiting for (or holding) a concurrency slot
+type QueueJob struct {
+	ID         string    `json:"id"`
+	Repo       string    `json:"repo"`
+	PRNumber   int       `json:"pr_number"`
+	Service    string    `json:"service"`
+	User       string    `json:"user"`
+	Priority   int       `json:"priority"`
+	Status     string    `json:"status"` // queued, running
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+
+	run func()
+}
+
+// DeploymentQueue limits concurrent deployments and orders the backlog
+type DeploymentQueue struct {
+	mu            sync.Mutex
+	maxConcurrent int
+	priorityBurst int // extra slots only urgent jobs may use
+	running       map[string]*QueueJob
+	pending       []*QueueJob
+	nextID        int
+	durations     []time.Duration
+}
+
+func NewDeploymentQueue(maxConcurrent, priorityBurst int) *DeploymentQueue {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	if priorityBurst < 0 {
+		priorityBurst = 0
+	}
+
+	return &DeploymentQueue{
+		maxConcurrent: maxConcurrent,
+		priorityBurst: priorityBurst,
+		running:       make(map[string]*QueueJob),
+	}
+}
+
+// NewJob creates a job for the queue; run is invoked if the job has to wait
+func (q *DeploymentQueue) NewJob(repo string, prNumber int, service, user string, run func()) *QueueJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	return &QueueJob{
+		ID:         fmt.Sprintf("job-%d", q.nextID),
+		Repo:       repo,
+		PRNumber:   prNumber,
+		Service:    service,
+		User:       user,
+		Status:     "queued",
+		EnqueuedAt: time.Now(),
+		run:        run,
+	}
+}
+
+// TryStart claims a slot for the job, or queues it when none is free.
+// Returns true if the caller should run the job now and call Finish after.
+func (q *DeploymentQueue) TryStart(job *QueueJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.running) < q.maxConcurrent && len(q.pending) == 0 {
+		q.startLocked(job)
+		return true
+	}
+	// Urgent jobs skip the line and may use the burst slots
+	if job.Priority >= PriorityUrgent && len(q.running) < q.maxConcurrent+q.priorityBurst {
+		q.startLocked(job)
+		return true
+	}
+
+	q.pending = append(q.pending, job)
+	q.sortPendingLocked()
+	return false
+}
+
+// Finish releases the job's slot and starts the next queued job
+func (q *DeploymentQueue) Finish(jobID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if job, ok := q.running[jobID]; ok {
+		q.recordDurationLocked(time.Since(job.StartedAt))
+		delete(q.running, jobID)
+	}
+
+	for len(q.pending) > 0 && q.hasSlotLocked(q.pending[0]) {
+		next := q.pending[0]
+		q.pending = q.pending[1:]
+		q.startLocked(next)
+
+		go func(job *QueueJob) {
+			defer q.Finish(job.ID)
+			if job.run != nil {
+				job.run()
+			}
+		}(next)
+	}
+}
+
+// Cancel removes a queued job; running jobs cannot be cancelled
+func (q *DeploymentQueue) Cancel(jobID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.running[jobID]; ok {
+		return fmt.Errorf("job %s is already running", jobID)
+	}
+
+	for i, job := range q.pending {
+		if job.ID == jobID {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("job %s not found", jobID)
+}
+
+// SetPriority reprioritizes a queued job (higher runs first)
+func (q *DeploymentQueue) SetPriority(jobID string, priority int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.pending {
+		if job.ID == jobID {
+			job.Priority = priority
+			q.sortPendingLocked()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("job %s not found in queue", jobID)
+}
+
+// Snapshot returns running and queued jobs in execution order
+func (q *DeploymentQueue) Snapshot() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	running := make([]QueueJob, 0, len(q.running))
+	for _, job := range q.running {
+		running = append(running, *job)
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartedAt.Before(running[j].StartedAt)
+	})
+
+	pending := make([]map[string]interface{}, 0, len(q.pending))
+	for i, job := range q.pending {
+		pending = append(pending, map[string]interface{}{
+			"job":      *job,
+			"position": i + 1,
+			"eta":      q.etaLocked(i + 1).String(),
+		})
+	}
+
+	return map[string]interface{}{
+		"max_concurrent": q.maxConcurrent,
+		"running":        running,
+		"queued":         pending,
+	}
+}
+
+// ForPR reports whether the job deploys the repo's PR; an empty repo matches
+// the PR number in any repo
+func (j *QueueJob) ForPR(repo string, prNumber int) bool {
+	return j.PRNumber == prNumber && (repo == "" || strings.EqualFold(j.Repo, repo))
+}
+
+// PositionsForPR returns the 1-based queue position and ETA of each queued job for a PR
+func (q *DeploymentQueue) PositionsForPR(repo string, prNumber int) []map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var result []map[string]interface{}
+	for i, job := range q.pending {
+		if !job.ForPR(repo, prNumber) {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"id":       job.ID,
+			"service":  job.Service,
+			"user":     job.User,
+			"position": i + 1,
+			"eta":      q.etaLocked(i + 1),
+		})
+	}
+
+	return result
+}
+
+// Utilization returns the running jobs and the slots normal jobs may use
+func (q *DeploymentQueue) Utilization() (int, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.running), q.maxConcurrent
+}
+
+// Length returns the number of queued (not running) jobs
+func (q *DeploymentQueue) Length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *DeploymentQueue) hasSlotLocked(job *QueueJob) bool {
+	if job.Priority >= PriorityUrgent {
+		return len(q.running) < q.maxConcurrent+q.priorityBurst
+	}
+	return len(q.running) < q.maxConcurrent
+}
+
+func (q *DeploymentQueue) startLocked(job *QueueJob) {
+	job.Status = "running"
+	job.StartedAt = time.Now()
+	q.running[job.ID] = job
+}
+
+func (q *DeploymentQueue) sortPendingLocked() {
+	sort.SliceStable(q.pending, func(i, j int) bool {
+		if q.pending[i].Priority != q.pending[j].Priority {
+			return q.pending[i].Priority > q.pending[j].Priority
+		}
+		return q.pending[i].EnqueuedAt.Before(q.pending[j].EnqueuedAt)
+	})
+}
+
+func (q *DeploymentQueue) recordDurationLocked(d time.Duration) {
+	q.durations = append(q.durations, d)
+	if len(q.durations) > 20 {
+		q.durations = q.durations[1:]
+	}
+}
+
+// etaLocked estimates wait time from the average of recent deployments
+func (q *DeploymentQueue) etaLocked(position int) time.Duration {
+	avg := defaultDeploymentDuration
+	if len(q.durations) > 0 {
+		var total time.Duration
+		for _, d := range q.durations {
+			total += d
+		}
+		avg = total / time.Duration(len(q.durations))
+	}
+
+	waves := (position + q.maxConcurrent - 1) / q.maxConcurrent
+	return (time.Duration(waves) * avg).Round(time.Second)
+}
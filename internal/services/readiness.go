@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"pr-previews/internal/types"
+)
+
+const (
+	imagePullWatchWindow   = time.Minute
+	imagePullWatchInterval = 5 * time.Second
+
+	// Progress stops being reported after this; the summary still shows
+	// the final state on its next refresh
+	rolloutProgressTimeout = 10 * time.Minute
+
+	// Ordered startup brings ordinals up one by one, so allow more time
+	statefulSetRolloutTimeout = 10 * time.Minute
+)
+
+// watchPreviewReadiness reports image pull failures as soon as they appear in
+// the first minute, instead of letting reviewers wait for the full timeout.
+// Until every pod is ready it also rewrites the summary comment with the
+// rollout progress, at most once per PREVIEW_PROGRESS_INTERVAL and only when
+// the progress changed. It stops with the command's context, so shutdown
+// doesn't wait on it.
+func (cs *CommandServiceK8s) watchPreviewReadiness(ctx context.Context, cmd *types.Command, namespace string) {
+	window := imagePullWatchWindow
+	if cs.config.Preview.ProgressEvery > 0 {
+		window = rolloutProgressTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	ticker := time.NewTicker(imagePullWatchInterval)
+	defer ticker.Stop()
+
+	started := time.Now()
+	var lastUpdate time.Time
+	var lastProgress string
+
+	for {
+		select {
+		case <-ctx.Done():
+			cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+			return
+		case <-ticker.C:
+			if time.Since(started) <= imagePullWatchWindow {
+				failures, err := cs.k8s.GetImagePullErrors(ctx, namespace)
+				if err == nil && len(failures) > 0 {
+					comment := formatImagePullFailures(namespace, failures)
+					if err := cs.comments.PostComment(context.Background(), cmd.Repo, cmd.PRNumber, comment); err != nil {
+						fmt.Printf("Warning: %v\n", err)
+					}
+					cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+					return
+				}
+			}
+			if cs.config.Preview.ProgressEvery <= 0 {
+				continue
+			}
+
+			progress, err := cs.k8s.GetRolloutProgress(ctx, namespace)
+			if err != nil {
+				continue
+			}
+			if progress.Complete() {
+				cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+				return
+			}
+			if progress.String() != lastProgress && time.Since(lastUpdate) >= cs.config.Preview.ProgressEvery {
+				cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+				lastUpdate, lastProgress = time.Now(), progress.String()
+			}
+		}
+	}
+}
+
+// watchStatefulSetRollout waits for ordered StatefulSet rollouts and posts
+// the per-ordinal outcome, since they finish long after /preview returns
+func (cs *CommandServiceK8s) watchStatefulSetRollout(cmd *types.Command, namespace string, statefulSets []appsv1.StatefulSet) {
+	ctx, cancel := context.WithTimeout(context.Background(), statefulSetRolloutTimeout)
+	defer cancel()
+
+	var failed []string
+	for _, sts := range statefulSets {
+		if err := cs.k8s.WaitForStatefulSet(ctx, namespace, sts.Name, statefulSetRolloutTimeout); err != nil {
+			failed = append(failed, sts.Name)
+		}
+	}
+
+	statuses, err := cs.k8s.GetStatefulSetStatuses(context.Background(), namespace)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return
+	}
+
+	title := "## ✅ StatefulSets Ready"
+	if len(failed) > 0 {
+		title = fmt.Sprintf("## ⏳ StatefulSet Rollout Incomplete\n\nNot ready after %s: %s", statefulSetRolloutTimeout, strings.Join(failed, ", "))
+	}
+	comment := fmt.Sprintf("%s\n\n**📦 Namespace:** `%s`\n\n%s", title, namespace, formatStatefulSetStatuses(statuses))
+	if err := cs.comments.PostComment(context.Background(), cmd.Repo, cmd.PRNumber, comment); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	cs.RefreshPreviewSummary(cmd.Repo, cmd.PRNumber)
+}
+
+// formatStatefulSetStatuses renders one line per StatefulSet plus one per ordinal
+func formatStatefulSetStatuses(statuses []map[string]interface{}) string {
+	var content strings.Builder
+	for _, status := range statuses {
+		content.WriteString(fmt.Sprintf("- **StatefulSet `%s`:** %d/%d ready (%s)\n",
+			status["name"], status["ready_replicas"], status["replicas"], status["progress"]))
+
+		pods, _ := status["pods"].([]map[string]interface{})
+		for _, pod := range pods {
+			icon := "⏳"
+			if ready, _ := pod["ready"].(bool); ready {
+				icon = "✅"
+			}
+			revision := ""
+			if rev, _ := pod["revision"].(string); rev != "" {
+				revision = fmt.Sprintf(", revision `%s`", rev)
+				if updated, _ := pod["updated"].(bool); !updated {
+					revision += " (not updated)"
+				}
+			}
+			content.WriteString(fmt.Sprintf("  - %s `%s` %s%s\n", icon, pod["name"], pod["status"], revision))
+		}
+	}
+	return content.String()
+}
+
+func formatImagePullFailures(namespace string, failures []map[string]interface{}) string {
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## ❌ Preview Image Pull Failed\n\n**📦 Namespace:** `%s`\n\n", namespace))
+
+	for _, failure := range failures {
+		message, _ := failure["message"].(string)
+		content.WriteString(fmt.Sprintf("#### `%s` (%s)\n- **Image:** `%s`\n- **Reason:** %s\n- **Likely cause:** %s\n",
+			failure["container"], failure["pod"], failure["image"], failure["reason"], diagnoseImagePull(failure["reason"].(string), message)))
+		if message != "" {
+			content.WriteString(fmt.Sprintf("- **Details:** `%s`\n", message))
+		}
+		content.WriteString("\n")
+	}
+
+	content.WriteString("*Fix the image reference and run `/cleanup` then `/preview` to redeploy.*")
+	return content.String()
+}
+
+// diagnoseImagePull turns kubelet pull errors into an actionable hint
+func diagnoseImagePull(reason, message string) string {
+	lower := strings.ToLower(message)
+
+	switch {
+	case reason == "InvalidImageName":
+		return "the image reference is malformed"
+	case strings.Contains(lower, "not found") || strings.Contains(lower, "manifest unknown"):
+		return "the tag doesn't exist — was the image pushed for this commit?"
+	case strings.Contains(lower, "unauthorized") || strings.Contains(lower, "denied") || strings.Contains(lower, "authentication required"):
+		return "the registry rejected the pull — is an `imagePullSecrets` entry missing?"
+	case strings.Contains(lower, "no such host") || strings.Contains(lower, "timeout"):
+		return "the registry is unreachable from the cluster"
+	default:
+		return "check the image name, tag and registry credentials"
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Namespace annotations, keyed per service in a shared namespace, holding
+// the URL a preview must answer on and how checking it went
+const (
+	readinessURLAnnotation      = "pr-previews.io/readiness-url"
+	readinessStateAnnotation    = "pr-previews.io/readiness"
+	readinessDeadlineAnnotation = "pr-previews.io/readiness-deadline"
+)
+
+// Readiness URL check states; any other value is why the check failed
+const (
+	ReadinessPending = "pending"
+	ReadinessPassed  = "passed"
+)
+
+const (
+	defaultReadinessTimeout = 2 * time.Minute
+	readinessCheckInterval  = 5 * time.Second
+)
+
+// ReadinessCheck is an HTTP check a service's preview must pass once its
+// pods are ready, catching ingress and DNS mistakes pod readiness can't
+type ReadinessCheck struct {
+	// Path is requested on the preview URL, e.g. /healthz; defaults to /
+	Path string `yaml:"path"`
+
+	// URL is requested instead of the preview URL, for services reached
+	// some other way; .PR, .Service, .Branch and .Domain are rendered like
+	// a custom host. It must be on the preview's host or under the preview
+	// domain, and redirects off its host fail the check.
+	URL string `yaml:"url"`
+
+	// Timeout is how long the URL has to return 200 after the pods are
+	// ready, e.g. 5m; defaults to 2m
+	Timeout string `yaml:"timeout"`
+}
+
+func (r ReadinessCheck) validate() error {
+	if r.Path != "" && r.URL != "" {
+		return fmt.Errorf("set path or url, not both")
+	}
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+	if r.URL != "" {
+		if !strings.HasPrefix(r.URL, "http://") && !strings.HasPrefix(r.URL, "https://") {
+			return fmt.Errorf("url %q must be an http or https URL", r.URL)
+		}
+		if _, err := template.New("url").Option("missingkey=error").Parse(r.URL); err != nil {
+			return fmt.Errorf("invalid url template: %v", err)
+		}
+	}
+	if r.Timeout != "" {
+		if timeout, err := time.ParseDuration(r.Timeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", r.Timeout)
+		}
+	}
+	return nil
+}
+
+// timeout is how long the check may take, defaultReadinessTimeout unless
+// set
+func (r ReadinessCheck) timeout() time.Duration {
+	if timeout, err := time.ParseDuration(r.Timeout); err == nil && timeout > 0 {
+		return timeout
+	}
+	return defaultReadinessTimeout
+}
+
+// resolve is the URL to check, or "" when the check needs the preview URL
+// and the preview isn't exposed. The repo's URL comes from the PR and is
+// requested from inside the cluster, so it may only point at the preview's
+// own host or another host under the preview domain.
+func (r ReadinessCheck) resolve(previewURL string, data previewHostData) (string, error) {
+	if r.URL != "" {
+		tmpl, err := template.New("url").Option("missingkey=error").Parse(r.URL)
+		if err != nil {
+			return "", fmt.Errorf("invalid url template: %v", err)
+		}
+		var rendered strings.Builder
+		if err := tmpl.Execute(&rendered, data); err != nil {
+			return "", fmt.Errorf("failed to render url template: %v", err)
+		}
+		parsed, err := url.ParseRequestURI(rendered.String())
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.User != nil {
+			return "", fmt.Errorf("url template rendered %q, which is not a valid URL", rendered.String())
+		}
+		if !readinessHostAllowed(parsed.Hostname(), previewURL, data.Domain) {
+			return "", fmt.Errorf("url %s must be on the preview's host or under %s", parsed.Redacted(), data.Domain)
+		}
+		return parsed.String(), nil
+	}
+	if previewURL == "" {
+		return "", nil
+	}
+	path := r.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.TrimSuffix(previewURL, "/") + path, nil
+}
+
+// readinessHostAllowed reports whether a readiness URL may be requested on
+// host: the preview's own host, or one under the preview domain
+func readinessHostAllowed(host, previewURL, domain string) bool {
+	host = strings.ToLower(host)
+	if preview, err := url.Parse(previewURL); err == nil && previewURL != "" && strings.EqualFold(preview.Hostname(), host) {
+		return true
+	}
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	return domain != "" && strings.HasSuffix(host, "."+domain)
+}
+
+// startReadinessCheck records the URL the service's preview must answer on
+// and by when, so the summary and /wait only call the preview ready once it
+// does. StartReadinessChecks does the checking.
+func (cs *CommandServiceK8s) startReadinessCheck(ctx context.Context, namespace, cleanServiceName string, shared bool, check ReadinessCheck, checkURL string) error {
+	return cs.k8s.AnnotateNamespace(ctx, namespace, nil, map[string]string{
+		serviceAnnotation(readinessURLAnnotation, cleanServiceName, shared):      checkURL,
+		serviceAnnotation(readinessStateAnnotation, cleanServiceName, shared):    ReadinessPending,
+		serviceAnnotation(readinessDeadlineAnnotation, cleanServiceName, shared): time.Now().Add(rolloutProgressTimeout + check.timeout()).UTC().Format(time.RFC3339),
+	})
+}
+
+// pendingReadinessCheck is a readiness URL not yet answered with a 200
+type pendingReadinessCheck struct {
+	Namespace string
+	Service   string
+	Shared    bool
+	Repo      string
+	PRNumber  int
+	URL       string
+	Deadline  time.Time
+}
+
+// pendingReadinessChecks lists the readiness URLs still pending, across the
+// preview namespaces
+func (k *K8sService) pendingReadinessChecks(ctx context.Context) ([]pendingReadinessCheck, error) {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: "preview=true"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list preview namespaces: %v", err)
+	}
+
+	var pending []pendingReadinessCheck
+	for _, ns := range namespaces.Items {
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		shared := ns.Labels[sharedNamespaceLabel] == NamespaceShared
+		services := []string{ns.Labels["service"]}
+		if shared {
+			services = sharedNamespaceServices(&ns)
+		}
+		prNumber, _ := strconv.Atoi(ns.Labels["pr-number"])
+		for _, service := range services {
+			if ns.Annotations[serviceAnnotation(readinessStateAnnotation, service, shared)] != ReadinessPending {
+				continue
+			}
+			// Checks from before deadlines were recorded get a fresh one
+			deadline, err := time.Parse(time.RFC3339, ns.Annotations[serviceAnnotation(readinessDeadlineAnnotation, service, shared)])
+			if err != nil {
+				deadline = time.Now().Add(defaultReadinessTimeout)
+			}
+			pending = append(pending, pendingReadinessCheck{
+				Namespace: ns.Name,
+				Service:   service,
+				Shared:    shared,
+				Repo:      ns.Annotations[previewRepoAnnotation],
+				PRNumber:  prNumber,
+				URL:       ns.Annotations[serviceAnnotation(readinessURLAnnotation, service, shared)],
+				Deadline:  deadline,
+			})
+		}
+	}
+	return pending, nil
+}
+
+// settleReadinessCheck records a pending check's outcome. False means the
+// check was no longer pending, or another replica settled it first, so
+// only one of them reports it.
+func (k *K8sService) settleReadinessCheck(ctx context.Context, check pendingReadinessCheck, state string) (bool, error) {
+	namespace, err := k.client.CoreV1().Namespaces().Get(ctx, check.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", check.Namespace, err)
+	}
+	key := serviceAnnotation(readinessStateAnnotation, check.Service, check.Shared)
+	if namespace.Annotations[key] != ReadinessPending {
+		return false, nil
+	}
+	namespace.Annotations[key] = state
+	if _, err := k.client.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to update namespace %s: %v", check.Namespace, err)
+	}
+	return true, nil
+}
+
+// StartReadinessChecks requests every pending readiness URL until it
+// returns 200 or its deadline passes, from one loop rather than a goroutine
+// per deploy. Before the pods are ready the URL just fails and is retried;
+// a URL that never answers gets a comment on the PR pointing at the ingress
+// and DNS.
+func (cs *CommandServiceK8s) StartReadinessChecks(ctx context.Context) {
+	client := readinessClient()
+	ticker := time.NewTicker(readinessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		checks, err := cs.k8s.pendingReadinessChecks(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Printf("Warning: readiness checks failed: %v\n", err)
+		}
+		for _, check := range checks {
+			cs.runReadinessCheck(ctx, client, check)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runReadinessCheck requests one pending URL and settles it once it passes
+// or runs out of time
+func (cs *CommandServiceK8s) runReadinessCheck(ctx context.Context, client *http.Client, check pendingReadinessCheck) {
+	failure := probeReadinessURL(ctx, client, check.URL)
+	if failure != "" && time.Now().Before(check.Deadline) {
+		return
+	}
+
+	state := ReadinessPassed
+	if failure != "" {
+		state = failure
+	}
+	settled, err := cs.k8s.settleReadinessCheck(ctx, check, state)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	if !settled {
+		return
+	}
+
+	if failure != "" {
+		cs.logTimeline(check.Repo, check.PRNumber, "Readiness check of %s failed: %s", check.URL, failure)
+		comment := fmt.Sprintf("## ⚠️ Preview Not Reachable\n\n**🎯 Service:** %s\n**📦 Namespace:** `%s`\n**🔗 URL:** %s\n\nThe URL still didn't return 200 by %s: %s\n\n*Check the Ingress host and path, DNS for the preview domain and the ingress controller's logs.*",
+			check.Service, check.Namespace, check.URL, check.Deadline.Format("15:04 UTC"), failure)
+		if err := cs.comments.PostComment(ctx, check.Repo, check.PRNumber, comment); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	cs.RefreshPreviewSummary(check.Repo, check.PRNumber)
+}
+
+// readinessClient follows redirects only on the host it was sent to, so a
+// preview can't bounce the check to other addresses inside the cluster
+func readinessClient() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+				return fmt.Errorf("redirected off the preview's host to %s", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// probeReadinessURL requests the URL once, returning "" on a 200 and
+// otherwise what went wrong
+func probeReadinessURL(ctx context.Context, client *http.Client, checkURL string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
+	if err != nil {
+		return err.Error()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("%s returned %s", resp.Request.URL, resp.Status)
+	}
+	return ""
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// ResultRenderer turns a provider-neutral Result into what one integration
+// posts: markdown for GitHub, GitLab and Azure DevOps, a Block Kit payload
+// for Slack, or plain JSON. Only handlers that return a Result, such as
+// /status and the error replies, render natively; the rest are markdown
+// that RenderResponse passes through or wraps.
+type ResultRenderer interface {
+	Render(result *types.Result) (string, error)
+}
+
+var resultRenderers = map[string]ResultRenderer{
+	"github":       markdownRenderer{},
+	"gitlab":       markdownRenderer{gitlab: true},
+	"azure-devops": markdownRenderer{},
+	"slack":        slackRenderer{},
+	"json":         jsonRenderer{},
+}
+
+// RendererFor looks up a renderer by provider name
+func RendererFor(format string) (ResultRenderer, error) {
+	renderer, ok := resultRenderers[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q (use %s)", format, strings.Join(RendererFormats(), ", "))
+	}
+	return renderer, nil
+}
+
+// RendererFormats lists the supported provider names
+func RendererFormats() []string {
+	formats := make([]string, 0, len(resultRenderers))
+	for format := range resultRenderers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// RenderResponse renders a command response for one provider. Handlers that
+// haven't moved to structured results only have markdown Content, which is
+// passed through to markdown providers and wrapped for the others.
+func RenderResponse(format string, response *types.CommandResponse) (string, error) {
+	renderer, err := RendererFor(format)
+	if err != nil {
+		return "", err
+	}
+	if response.Result != nil {
+		return renderer.Render(response.Result)
+	}
+	if md, ok := renderer.(markdownRenderer); ok {
+		return md.escape(response.Content), nil
+	}
+
+	status := types.StatusSuccess
+	if !response.Success {
+		status = types.StatusError
+	}
+	return renderer.Render(&types.Result{Status: status, Title: response.Message, Summary: response.Content})
+}
+
+// resultResponse builds a command response around a structured result, with
+// Content pre-rendered for GitHub comments
+func resultResponse(success bool, message string, result *types.Result, data map[string]interface{}) *types.CommandResponse {
+	content, _ := markdownRenderer{}.Render(result)
+	return &types.CommandResponse{
+		Success: success,
+		Message: message,
+		Content: content,
+		Result:  result,
+		Data:    data,
+	}
+}
+
+// failedResponse is the common "## ❌ <title>" error reply
+func failedResponse(message, title string, err error) *types.CommandResponse {
+	return resultResponse(false, message, &types.Result{
+		Status:  types.StatusError,
+		Icon:    "❌",
+		Title:   title,
+		Summary: fmt.Sprintf("**Error:** %s", err.Error()),
+	}, nil)
+}
+
+// markdownRenderer writes GitHub-flavoured markdown. GitLab reads the same
+// markdown but runs lines starting with "/" as quick actions, so those are
+// escaped there.
+type markdownRenderer struct {
+	gitlab bool
+}
+
+func (r markdownRenderer) Render(result *types.Result) (string, error) {
+	var blocks []string
+
+	title := result.Title
+	if result.Icon != "" {
+		title = result.Icon + " " + title
+	}
+	blocks = append(blocks, "## "+title)
+	if result.Summary != "" {
+		blocks = append(blocks, result.Summary)
+	}
+
+	for _, section := range result.Sections {
+		var b strings.Builder
+		switch {
+		case section.Collapsed:
+			// Markdown inside <details> needs blank lines around it
+			summary := section.Title
+			if summary == "" {
+				summary = "Details"
+			}
+			b.WriteString("<details>\n<summary>" + html.EscapeString(summary) + "</summary>\n\n")
+		case section.Title != "":
+			b.WriteString("### " + section.Title + "\n")
+		}
+		for _, field := range section.Fields {
+			b.WriteString(fmt.Sprintf("- **%s:** %s\n", field.Name, field.Value))
+		}
+		if section.Text != "" {
+			b.WriteString(strings.TrimRight(section.Text, "\n") + "\n")
+		}
+		for _, item := range section.Items {
+			b.WriteString("- " + item + "\n")
+		}
+		if section.Table != nil {
+			if b.Len() > 0 {
+				b.WriteString("\n") // a table directly after a list joins the list
+			}
+			b.WriteString(markdownTable(section.Table))
+		}
+		if section.Collapsed {
+			b.WriteString("\n</details>")
+		}
+		blocks = append(blocks, strings.TrimRight(b.String(), "\n"))
+	}
+
+	if result.Footer != "" {
+		blocks = append(blocks, result.Footer)
+	}
+	return r.escape(strings.Join(blocks, "\n\n")), nil
+}
+
+func (r markdownRenderer) escape(content string) string {
+	if !r.gitlab {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "/") {
+			lines[i] = "\\" + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func markdownTable(table *types.Table) string {
+	cell := func(s string) string { return strings.ReplaceAll(s, "|", "\\|") }
+
+	var b strings.Builder
+	separators := make([]string, len(table.Columns))
+	headers := make([]string, len(table.Columns))
+	for i, column := range table.Columns {
+		headers[i] = cell(column)
+		separators[i] = "---"
+	}
+	b.WriteString("| " + strings.Join(headers, " | ") + " |\n")
+	b.WriteString("|" + strings.Join(separators, "|") + "|\n")
+	for _, row := range table.Rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = cell(value)
+		}
+		b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	return b.String()
+}
+
+// slackRenderer writes a Block Kit payload. Slack has no tables, so those
+// become aligned code blocks.
+type slackRenderer struct{}
+
+// Slack caps section text at 3000 characters, header text at 150, each
+// section field at 2000 and a message at 50 blocks
+const (
+	slackMaxText   = 3000
+	slackMaxHeader = 150
+	slackMaxField  = 2000
+	slackMaxBlocks = 50
+)
+
+var (
+	mdHeadingPattern = regexp.MustCompile(`(?m)^#{1,6}\s+(.+)$`)
+	mdItalicPattern  = regexp.MustCompile(`(^|[^*])\*([^*\n]+)\*`)
+	mdBoldPattern    = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	mdLinkPattern    = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^)\s]+)\)`)
+)
+
+// slackText converts the markdown subset results use into Slack mrkdwn
+func slackText(markdown string) string {
+	text := mdItalicPattern.ReplaceAllString(markdown, "${1}_${2}_")
+	text = mdHeadingPattern.ReplaceAllString(text, "**$1**")
+	text = mdBoldPattern.ReplaceAllString(text, "*$1*")
+	text = mdLinkPattern.ReplaceAllString(text, "<$2|$1>")
+	return slackTruncate(text, slackMaxText)
+}
+
+// slackTruncate cuts text to at most max characters, marking the cut
+func slackTruncate(text string, max int) string {
+	if runes := []rune(text); len(runes) > max {
+		return string(runes[:max-1]) + "…"
+	}
+	return text
+}
+
+// slackCode wraps text in a code block of at most slackMaxText characters
+// that stays closed when cut
+func slackCode(text string) string {
+	const fence = "```"
+	return fence + "\n" + slackTruncate(text, slackMaxText-2*len(fence)-1) + fence
+}
+
+func (slackRenderer) Render(result *types.Result) (string, error) {
+	mrkdwn := func(text string) map[string]interface{} {
+		return map[string]interface{}{"type": "mrkdwn", "text": slackText(text)}
+	}
+	section := func(text string) map[string]interface{} {
+		return map[string]interface{}{"type": "section", "text": mrkdwn(text)}
+	}
+
+	title := result.Title
+	if result.Icon != "" {
+		title = result.Icon + " " + title
+	}
+	blocks := []map[string]interface{}{{
+		"type": "header",
+		"text": map[string]interface{}{"type": "plain_text", "text": slackTruncate(title, slackMaxHeader), "emoji": true},
+	}}
+	if result.Summary != "" {
+		blocks = append(blocks, section(result.Summary))
+	}
+
+	for _, s := range result.Sections {
+		blocks = append(blocks, map[string]interface{}{"type": "divider"})
+		if s.Title != "" {
+			blocks = append(blocks, section("**"+s.Title+"**"))
+		}
+		if len(s.Fields) > 0 {
+			// Slack allows 10 fields per section
+			for start := 0; start < len(s.Fields); start += 10 {
+				end := start + 10
+				if end > len(s.Fields) {
+					end = len(s.Fields)
+				}
+				var fields []map[string]interface{}
+				for _, field := range s.Fields[start:end] {
+					fields = append(fields, map[string]interface{}{
+						"type": "mrkdwn",
+						"text": slackTruncate(slackText(fmt.Sprintf("**%s**\n%s", field.Name, field.Value)), slackMaxField),
+					})
+				}
+				blocks = append(blocks, map[string]interface{}{"type": "section", "fields": fields})
+			}
+		}
+		if s.Text != "" {
+			blocks = append(blocks, section(s.Text))
+		}
+		if len(s.Items) > 0 {
+			blocks = append(blocks, section("• "+strings.Join(s.Items, "\n• ")))
+		}
+		if s.Table != nil {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "section",
+				"text": map[string]interface{}{"type": "mrkdwn", "text": slackCode(plainTable(s.Table))},
+			})
+		}
+	}
+
+	if result.Footer != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "context",
+			"elements": []map[string]interface{}{mrkdwn(result.Footer)},
+		})
+	}
+	if len(blocks) > slackMaxBlocks {
+		blocks = append(blocks[:slackMaxBlocks-1], section(fmt.Sprintf("_%d more blocks omitted_", len(blocks)-slackMaxBlocks+1)))
+	}
+
+	return marshalPayload(map[string]interface{}{
+		"text":   title, // notification fallback
+		"blocks": blocks,
+	})
+}
+
+// plainTable aligns a table for monospace output
+func plainTable(table *types.Table) string {
+	widths := make([]int, len(table.Columns))
+	for i, column := range table.Columns {
+		widths[i] = len([]rune(column))
+	}
+	for _, row := range table.Rows {
+		for i, value := range row {
+			if i < len(widths) && len([]rune(value)) > widths[i] {
+				widths[i] = len([]rune(value))
+			}
+		}
+	}
+
+	line := func(cells []string) string {
+		padded := make([]string, len(cells))
+		for i, value := range cells {
+			if i < len(widths) {
+				value += strings.Repeat(" ", widths[i]-len([]rune(value)))
+			}
+			padded[i] = value
+		}
+		return strings.TrimRight(strings.Join(padded, "  "), " ") + "\n"
+	}
+
+	var b strings.Builder
+	b.WriteString(line(table.Columns))
+	for _, row := range table.Rows {
+		b.WriteString(line(row))
+	}
+	return b.String()
+}
+
+// jsonRenderer emits the result itself for API clients and custom bots
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(result *types.Result) (string, error) {
+	return marshalPayload(result)
+}
+
+// marshalPayload encodes without HTML escaping, which would turn Slack's
+// <url|text> links into \u003c sequences
+func marshalPayload(v interface{}) (string, error) {
+	var payload bytes.Buffer
+	encoder := json.NewEncoder(&payload)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(payload.String(), "\n"), nil
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const repoConfigFile = ".pr-previews.yaml"
+
+// RepoConfig holds per-repository preview settings from .pr-previews.yaml
+type RepoConfig struct {
+	// Secrets are injected into every preview namespace as the preview-secrets Secret
+	Secrets map[string]string `yaml:"secrets"`
+
+	// Vault lists dynamic credentials to fetch per preview
+	Vault []VaultSecretSpec `yaml:"vault"`
+
+	// Class is the default service class (tiny, small, medium); Classes
+	// overrides it per service
+	Class   string            `yaml:"class"`
+	Classes map[string]string `yaml:"classes"`
+
+	// Domains overrides how each service is exposed, keyed by service
+	Domains map[string]ServiceDomain `yaml:"domains"`
+
+	// Namespace lays out a PR's services: per-service (the default) gives
+	// each its own preview-pr-<n>-<service> namespace, shared puts them all
+	// in preview-pr-<n> so they can reach each other by Service name
+	Namespace string `yaml:"namespace"`
+
+	// Isolation is namespace (the default) or vcluster, which deploys the
+	// PR's services together into a virtual cluster of their own so CRDs and
+	// cluster-scoped resources in the manifests can be applied
+	Isolation string `yaml:"isolation"`
+
+	// Operators names operator bundles from the server's OPERATOR_BUNDLES
+	// the previews need, e.g. strimzi; they're installed once per cluster
+	// and the manifests' custom resources of their kinds are applied
+	Operators []string `yaml:"operators"`
+
+	// Containers are sidecars and init containers injected into the
+	// Deployments of the services each one lists
+	Containers []ContainerInjection `yaml:"containers"`
+
+	// Charts deploy services from a chart repository or OCI registry at a
+	// pinned version, keyed by service
+	Charts map[string]ChartSource `yaml:"charts"`
+
+	// TTL is how long each deploy of the repo's previews lives, e.g. 3d,
+	// unless /preview --ttl or a manifest annotation says otherwise
+	TTL string `yaml:"ttl"`
+
+	// Changes are path globs that never, or always, count as changing a
+	// service when redeploying on push or picking the services a PR touches
+	Changes ChangeRules `yaml:"changes"`
+
+	// Readiness is an HTTP check, keyed by service, a preview must pass
+	// after its pods are ready before it counts as ready
+	Readiness map[string]ReadinessCheck `yaml:"readiness"`
+
+	// Labels and Annotations go on the repo's preview workloads, and on
+	// its namespaces for keys in NAMESPACE_REPO_METADATA_KEYS, e.g. team or
+	// cost-center for chargeback; the operator's NAMESPACE_LABELS and
+	// NAMESPACE_ANNOTATIONS take precedence
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	// Dependencies are backing services, such as one Kafka for every
+	// preview of the repo, keyed by name. Each version of a manifest runs
+	// once in a namespace of its own while any preview using it does.
+	Dependencies map[string]SharedDependency `yaml:"dependencies"`
+}
+
+// SharedNamespace reports whether the repo deploys a PR's services together
+func (c *RepoConfig) SharedNamespace() bool {
+	return c.Namespace == NamespaceShared || c.VirtualCluster()
+}
+
+// VirtualCluster reports whether the repo's previews run in a vcluster
+func (c *RepoConfig) VirtualCluster() bool {
+	return c.Isolation == IsolationVCluster
+}
+
+// ServiceDomain is a custom hostname and path routing for one service
+type ServiceDomain struct {
+	// Host is a template rendered with .PR, .Service, .Branch (slugified),
+	// .Label (the default subdomain) and .Domain (PREVIEW_DOMAIN), e.g.
+	// "pr-{{ .PR }}.previews.acme.dev". It must be under PREVIEW_DOMAIN or
+	// PREVIEW_HOST_DOMAINS and not served by another namespace.
+	Host string `yaml:"host"`
+
+	// Path is the prefix the service is served under, e.g. /api to share
+	// the frontend's host; defaults to /
+	Path string `yaml:"path"`
+
+	// Routes send further prefixes to other Services in the same preview
+	Routes []PathRoute `yaml:"routes"`
+}
+
+// PathRoute routes a path prefix to a Service in the preview namespace
+type PathRoute struct {
+	Path    string `yaml:"path"`
+	Service string `yaml:"service"`
+	Port    int32  `yaml:"port"`
+}
+
+// VaultSecretSpec maps a Vault path to a Secret in the preview namespace
+type VaultSecretSpec struct {
+	Path   string `yaml:"path"`   // e.g. database/creds/preview
+	Secret string `yaml:"secret"` // Secret name to write the credentials to
+}
+
+// LoadRepoConfig reads .pr-previews.yaml from the repo, decrypting it when
+// it was committed with SOPS. A missing file yields an empty config.
+func LoadRepoConfig(repoPath string, decryptor *SopsDecryptor) (*RepoConfig, error) {
+	content, err := readRepoConfigFile(repoPath, decryptor)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRepoConfig(content)
+}
+
+// readRepoConfigFile returns the decrypted .pr-previews.yaml of the repo, or
+// nil when it has none
+func readRepoConfigFile(repoPath string, decryptor *SopsDecryptor) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(repoPath, repoConfigFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", repoConfigFile, err)
+	}
+
+	if decryptor != nil && decryptor.IsEncrypted(content) {
+		content, err = decryptor.Decrypt(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %v", repoConfigFile, err)
+		}
+	}
+	return content, nil
+}
+
+// ParseRepoConfig parses and validates decrypted .pr-previews.yaml content
+func ParseRepoConfig(content []byte) (*RepoConfig, error) {
+	repoConfig := &RepoConfig{
+		Secrets: map[string]string{},
+	}
+	if err := yaml.Unmarshal(content, repoConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", repoConfigFile, err)
+	}
+	if repoConfig.Secrets == nil {
+		repoConfig.Secrets = map[string]string{}
+	}
+
+	if repoConfig.Namespace != "" && repoConfig.Namespace != NamespacePerService && repoConfig.Namespace != NamespaceShared {
+		return nil, fmt.Errorf("%s: namespace must be %s or %s, got %q", repoConfigFile, NamespacePerService, NamespaceShared, repoConfig.Namespace)
+	}
+
+	if repoConfig.Isolation != "" && repoConfig.Isolation != IsolationNamespace && repoConfig.Isolation != IsolationVCluster {
+		return nil, fmt.Errorf("%s: isolation must be %s or %s, got %q", repoConfigFile, IsolationNamespace, IsolationVCluster, repoConfig.Isolation)
+	}
+	if repoConfig.VirtualCluster() && repoConfig.Namespace == NamespacePerService {
+		return nil, fmt.Errorf("%s: isolation %s deploys a PR's services together, so namespace can't be %s", repoConfigFile, IsolationVCluster, NamespacePerService)
+	}
+
+	seenOperators := make(map[string]bool, len(repoConfig.Operators))
+	for _, name := range repoConfig.Operators {
+		if name == "" || seenOperators[name] {
+			return nil, fmt.Errorf("%s: operators must name each bundle once, got %q", repoConfigFile, name)
+		}
+		seenOperators[name] = true
+	}
+	if len(repoConfig.Operators) > 0 && repoConfig.VirtualCluster() {
+		return nil, fmt.Errorf("%s: isolation %s applies the manifests' own CRDs and operators, so operators can't be set", repoConfigFile, IsolationVCluster)
+	}
+
+	if repoConfig.TTL != "" {
+		if _, err := ParseTTL(repoConfig.TTL); err != nil {
+			return nil, fmt.Errorf("%s: %v", repoConfigFile, err)
+		}
+	}
+
+	for service, domain := range repoConfig.Domains {
+		if err := domain.validate(); err != nil {
+			return nil, fmt.Errorf("%s: domains.%s: %v", repoConfigFile, service, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(repoConfig.Containers))
+	for _, injection := range repoConfig.Containers {
+		if err := injection.validate(); err != nil {
+			return nil, fmt.Errorf("%s: containers.%s: %v", repoConfigFile, injection.Name, err)
+		}
+		if seen[injection.Name] {
+			return nil, fmt.Errorf("%s: containers.%s is declared twice", repoConfigFile, injection.Name)
+		}
+		seen[injection.Name] = true
+	}
+
+	if err := repoConfig.Changes.validate(); err != nil {
+		return nil, fmt.Errorf("%s: changes: %v", repoConfigFile, err)
+	}
+
+	for service, chart := range repoConfig.Charts {
+		if err := chart.validate(); err != nil {
+			return nil, fmt.Errorf("%s: charts.%s: %v", repoConfigFile, service, err)
+		}
+	}
+
+	for service, check := range repoConfig.Readiness {
+		if err := check.validate(); err != nil {
+			return nil, fmt.Errorf("%s: readiness.%s: %v", repoConfigFile, service, err)
+		}
+	}
+
+	if err := validateMetadata(repoConfig.Labels, repoConfig.Annotations); err != nil {
+		return nil, fmt.Errorf("%s: %v", repoConfigFile, err)
+	}
+
+	for name, dependency := range repoConfig.Dependencies {
+		if !containerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: dependencies: name %q must be a DNS label", repoConfigFile, name)
+		}
+		if err := dependency.validate(); err != nil {
+			return nil, fmt.Errorf("%s: dependencies.%s: %v", repoConfigFile, name, err)
+		}
+	}
+	if len(repoConfig.Dependencies) > 0 && repoConfig.VirtualCluster() {
+		return nil, fmt.Errorf("%s: isolation %s runs the previews in a cluster of their own, so dependencies can't be shared with them", repoConfigFile, IsolationVCluster)
+	}
+
+	return repoConfig, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRepoConfigRefs bounds how many repo@sha entries are remembered
+const maxRepoConfigRefs = 500
+
+// RepoConfigCache remembers .pr-previews.yaml by content: repo@sha entries
+// point at the digest of the file, and each distinct file is kept once,
+// decrypted. A commit's file never changes, so commands on the same head
+// don't fetch (or decrypt) it again, and heads that didn't touch it share
+// one entry. Only files some repo@sha points at are kept, so decrypted
+// content is bounded like the entries are.
+type RepoConfigCache struct {
+	mu    sync.Mutex
+	refs  map[string]repoConfigRef
+	blobs map[string][]byte // digest to decrypted content
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type repoConfigRef struct {
+	digest    string
+	fetchedAt time.Time
+}
+
+var sharedRepoConfigCache = &RepoConfigCache{
+	refs:  make(map[string]repoConfigRef),
+	blobs: make(map[string][]byte),
+}
+
+// SharedRepoConfigCache is the process-wide repo config cache
+func SharedRepoConfigCache() *RepoConfigCache {
+	return sharedRepoConfigCache
+}
+
+func repoConfigDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookup returns the decrypted config of repo at sha
+func (c *RepoConfigCache) lookup(repo, sha string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref, ok := c.refs[repo+"@"+sha]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return c.blobs[ref.digest], true
+}
+
+// store records repo@sha as having the decrypted content
+func (c *RepoConfigCache) store(repo, sha string, content []byte) {
+	digest := repoConfigDigest(content)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := repo + "@" + sha
+	if _, ok := c.refs[key]; !ok && len(c.refs) >= maxRepoConfigRefs {
+		c.evictOldest()
+	}
+	c.refs[key] = repoConfigRef{digest: digest, fetchedAt: time.Now()}
+	c.blobs[digest] = content
+}
+
+func (c *RepoConfigCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for key, ref := range c.refs {
+		if oldestKey == "" || ref.fetchedAt.Before(oldest) {
+			oldestKey, oldest = key, ref.fetchedAt
+		}
+	}
+	delete(c.refs, oldestKey)
+	c.pruneBlobs()
+}
+
+// pruneBlobs drops files no repo@sha points at anymore
+func (c *RepoConfigCache) pruneBlobs() {
+	used := make(map[string]bool, len(c.refs))
+	for _, ref := range c.refs {
+		used[ref.digest] = true
+	}
+	for digest := range c.blobs {
+		if !used[digest] {
+			delete(c.blobs, digest)
+		}
+	}
+}
+
+// RepoConfigCacheStats are the cache's size and hit counters
+type RepoConfigCacheStats struct {
+	Refs   int   `json:"refs"`
+	Files  int   `json:"files"`
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Stats returns cache size and hit counters
+func (c *RepoConfigCache) Stats() RepoConfigCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return RepoConfigCacheStats{
+		Refs:   len(c.refs),
+		Files:  len(c.blobs),
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+}
+
+// repoConfigAt reads .pr-previews.yaml of repo at sha from GitHub, or from
+// the cache when a command already did, layered over the org-wide config.
+// A commit without the file yields the org config alone.
+func (cs *CommandServiceK8s) repoConfigAt(ctx context.Context, repo, sha string) (*RepoConfig, error) {
+	orgContent, err := cs.orgConfigContent(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := SharedRepoConfigCache()
+	if content, ok := cache.lookup(repo, sha); ok {
+		return layerRepoConfig(orgContent, content)
+	}
+
+	content, err := cs.github.GetRepoFile(ctx, repo, repoConfigFile, sha)
+	if isGitHubNotFound(err) {
+		content, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if content != nil && cs.decryptor != nil && cs.decryptor.IsEncrypted(content) {
+		if content, err = cs.decryptor.Decrypt(content); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %v", repoConfigFile, err)
+		}
+	}
+	cache.store(repo, sha, content)
+	return layerRepoConfig(orgContent, content)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"runtime"
+	"time"
+)
+
+// processStarted is when the server came up, for uptime
+var processStarted = time.Now()
+
+// recentGCPauses is how many of the runtime's last GC pauses are reported
+const recentGCPauses = 10
+
+// RuntimeSnapshot is the Go runtime as the runtime debug endpoint reports it
+type RuntimeSnapshot struct {
+	GoVersion  string    `json:"go_version"`
+	Uptime     string    `json:"uptime"`
+	Goroutines int       `json:"goroutines"`
+	CPUs       int       `json:"cpus"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	Heap       HeapStats `json:"heap"`
+	GC         GCStats   `json:"gc"`
+}
+
+// HeapStats is the heap's size in bytes and live objects
+type HeapStats struct {
+	AllocBytes    uint64 `json:"alloc_bytes"`
+	InuseBytes    uint64 `json:"inuse_bytes"`
+	IdleBytes     uint64 `json:"idle_bytes"`
+	ReleasedBytes uint64 `json:"released_bytes"`
+	Objects       uint64 `json:"objects"`
+	SysBytes      uint64 `json:"sys_bytes"`
+}
+
+// GCStats are the garbage collector's cycles and latest pauses
+type GCStats struct {
+	Cycles       uint32    `json:"cycles"`
+	Forced       uint32    `json:"forced"`
+	TotalPause   string    `json:"total_pause"`
+	RecentPauses []GCPause `json:"recent_pauses"`
+	MaxRecent    string    `json:"max_recent"`
+	CPUFraction  float64   `json:"cpu_fraction"`
+	NextGCBytes  uint64    `json:"next_gc_bytes"`
+	LastGCAt     string    `json:"last_gc_at,omitempty"`
+}
+
+// GCPause is one stop-the-world pause and when it ended
+type GCPause struct {
+	Duration string `json:"duration"`
+	At       string `json:"at"`
+}
+
+// RuntimeStats snapshots the Go runtime for investigating the server under
+// webhook load: goroutines, heap and the latest GC pauses
+func RuntimeStats() RuntimeSnapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	// PauseNs and PauseEnd are rings indexed by NumGC
+	pauses := make([]GCPause, 0, recentGCPauses)
+	var maxPause time.Duration
+	for i := uint32(0); i < recentGCPauses && i < mem.NumGC; i++ {
+		slot := (mem.NumGC - 1 - i) % uint32(len(mem.PauseNs))
+		pause := time.Duration(mem.PauseNs[slot])
+		if pause > maxPause {
+			maxPause = pause
+		}
+		pauses = append(pauses, GCPause{
+			Duration: pause.String(),
+			At:       time.Unix(0, int64(mem.PauseEnd[slot])).UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	gc := GCStats{
+		Cycles:       mem.NumGC,
+		Forced:       mem.NumForcedGC,
+		TotalPause:   time.Duration(mem.PauseTotalNs).String(),
+		RecentPauses: pauses,
+		MaxRecent:    maxPause.String(),
+		CPUFraction:  mem.GCCPUFraction,
+		NextGCBytes:  mem.NextGC,
+	}
+	if mem.LastGC > 0 {
+		gc.LastGCAt = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+
+	return RuntimeSnapshot{
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(processStarted).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Heap: HeapStats{
+			AllocBytes:    mem.HeapAlloc,
+			InuseBytes:    mem.HeapInuse,
+			IdleBytes:     mem.HeapIdle,
+			ReleasedBytes: mem.HeapReleased,
+			Objects:       mem.HeapObjects,
+			SysBytes:      mem.Sys,
+		},
+		GC: gc,
+	}
+}
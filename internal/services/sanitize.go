@@ -0,0 +1,88 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxServiceNameLength keeps preview-pr-<n>-<service> within the 63 character
+// namespace limit for any realistic PR number
+const maxServiceNameLength = 40
+
+// maxEchoLength bounds how much user input is repeated back in a comment
+const maxEchoLength = 100
+
+var (
+	// Lowercase DNS label segments, optionally nested with "/" (e.g. apps/api)
+	serviceNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(/[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+	// GitHub logins, plus the [bot] suffix used by apps
+	githubLoginPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,37}[a-zA-Z0-9])?(\[bot\])?$`)
+
+	linkPattern     = regexp.MustCompile(`(?i)\b(?:https?|ftp|mailto|javascript|data):\S*|\bwww\.\S+`)
+	mentionPattern  = regexp.MustCompile(`@([a-zA-Z0-9_/-]+)`)
+	markdownSpecial = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+		`(`, `\(`, `)`, `\)`, `<`, `&lt;`, `>`, `&gt;`, `#`, `\#`, `|`, `\|`,
+		`!`, `\!`, `~`, `\~`,
+	)
+)
+
+// ValidateServiceName rejects service names that would produce invalid
+// namespace names or labels, or smuggle markdown into comments
+func ValidateServiceName(name string) error {
+	if len(name) > maxServiceNameLength {
+		return fmt.Errorf("service name is longer than %d characters", maxServiceNameLength)
+	}
+	if !serviceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid service name %s: use lowercase letters, digits, '-' and '/'", SanitizeEcho(name))
+	}
+	return nil
+}
+
+// ValidateGitHubLogin checks that a user name is a plausible GitHub login
+func ValidateGitHubLogin(login string) error {
+	if !githubLoginPattern.MatchString(login) {
+		return fmt.Errorf("invalid GitHub login: %s", SanitizeEcho(login))
+	}
+	return nil
+}
+
+// ValidateBranchName applies the subset of git's ref rules that matter for
+// labels, annotations and comments
+func ValidateBranchName(branch string) error {
+	invalid := branch == "" || len(branch) > 255 ||
+		strings.HasPrefix(branch, "-") || strings.HasPrefix(branch, "/") ||
+		strings.HasSuffix(branch, "/") || strings.HasSuffix(branch, ".lock") ||
+		strings.Contains(branch, "..") || strings.Contains(branch, "@{") ||
+		strings.ContainsAny(branch, " ~^:?*[\\`<>|\"'")
+	for _, r := range branch {
+		if r < 0x20 || r == 0x7f {
+			invalid = true
+		}
+	}
+	if invalid {
+		return fmt.Errorf("invalid branch name: %s", SanitizeEcho(branch))
+	}
+	return nil
+}
+
+// EscapeMarkdown escapes characters that GitHub-flavoured markdown would
+// interpret, so the text renders literally
+func EscapeMarkdown(text string) string {
+	return markdownSpecial.Replace(text)
+}
+
+// SanitizeEcho makes arbitrary user input safe to repeat back in a comment:
+// links are dropped, @-mentions lose their ping, markdown is escaped and the
+// result is flattened to a single bounded line
+func SanitizeEcho(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	text = linkPattern.ReplaceAllString(text, "[link removed]")
+	text = mentionPattern.ReplaceAllString(text, "$1")
+	if runes := []rune(text); len(runes) > maxEchoLength {
+		text = string(runes[:maxEchoLength]) + "…"
+	}
+	return EscapeMarkdown(text)
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"pr-previews/internal/config"
+)
+
+const (
+	// sleepAnnotation holds an asleep preview's SleepState on its namespace
+	sleepAnnotation = "pr-previews.io/sleeping"
+
+	// activatorService is the ExternalName Service an asleep preview's
+	// Ingresses fall back to; it points at the bot
+	activatorService = "preview-activator"
+
+	// ingress-nginx sends the responses listed in custom-http-errors to the
+	// default backend instead, with the original request described in
+	// X-Namespace, X-Original-URI and friends. With no pods behind the
+	// Service every request gets a 503.
+	nginxDefaultBackendAnnotation = "nginx.ingress.kubernetes.io/default-backend"
+	nginxCustomErrorsAnnotation   = "nginx.ingress.kubernetes.io/custom-http-errors"
+
+	// scaleToZeroInterval is how often idle previews are looked for, and
+	// woken ones settled
+	scaleToZeroInterval = time.Minute
+)
+
+// SleepState is what an asleep preview's namespace records to wake it
+type SleepState struct {
+	Since        time.Time        `json:"since"`
+	Replicas     map[string]int32 `json:"replicas"`               // of each Deployment before sleeping
+	StatefulSets map[string]int32 `json:"statefulsets,omitempty"` // of each StatefulSet before sleeping
+	Waking       *time.Time       `json:"waking,omitempty"`
+}
+
+// ErrPreviewNotAsleep is returned for wakeups of namespaces that aren't asleep
+var ErrPreviewNotAsleep = errors.New("preview is not asleep")
+
+// sleepStateOf reads the namespace's SleepState; it's false when the
+// preview is awake
+func sleepStateOf(ns *corev1.Namespace) (*SleepState, bool) {
+	raw, ok := ns.Annotations[sleepAnnotation]
+	if !ok {
+		return nil, false
+	}
+	var state SleepState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		fmt.Printf("Warning: ignoring malformed sleep state of %s: %v\n", ns.Name, err)
+		return &SleepState{}, true
+	}
+	return &state, true
+}
+
+// activatorTarget splits PREVIEW_ACTIVATOR_HOST into the host and port the
+// activator Service points at; the port defaults to 80
+func activatorTarget(address string) (string, int32, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address, 80, nil
+	}
+	number, err := strconv.ParseInt(port, 10, 32)
+	if err != nil || number <= 0 || number > 65535 {
+		return "", 0, fmt.Errorf("invalid activator port %q", port)
+	}
+	return host, int32(number), nil
+}
+
+// SleepPreview scales the namespace's Deployments and StatefulSets to zero
+// and points its
+// Ingresses' errors at the activator at host:port, recording the replica
+// counts first so a failure half way can still be woken. It returns nil
+// when nothing was running.
+func (k *K8sService) SleepPreview(ctx context.Context, namespace, host string, port int32) (*SleepState, error) {
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	state := &SleepState{Since: time.Now().UTC(), Replicas: map[string]int32{}, StatefulSets: map[string]int32{}}
+	for _, dep := range deployments.Items {
+		replicas := int32(1)
+		if dep.Spec.Replicas != nil {
+			replicas = *dep.Spec.Replicas
+		}
+		if replicas > 0 {
+			state.Replicas[dep.Name] = replicas
+		}
+	}
+	for _, sts := range statefulSets.Items {
+		replicas := int32(1)
+		if sts.Spec.Replicas != nil {
+			replicas = *sts.Spec.Replicas
+		}
+		if replicas > 0 {
+			state.StatefulSets[sts.Name] = replicas
+		}
+	}
+	if len(state.Replicas) == 0 && len(state.StatefulSets) == 0 {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sleep state: %v", err)
+	}
+	if err := k.AnnotateNamespace(ctx, namespace, nil, map[string]string{sleepAnnotation: string(raw)}); err != nil {
+		return nil, err
+	}
+	ports := []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP, Port: port}}
+	if err := k.UpsertExternalNameService(ctx, namespace, activatorService, host, ports); err != nil {
+		return nil, err
+	}
+	if err := k.setActivatorFallback(ctx, namespace, true); err != nil {
+		return nil, err
+	}
+	for name := range state.Replicas {
+		if err := k.ScaleDeployment(ctx, namespace, name, 0); err != nil {
+			return nil, err
+		}
+	}
+	for name := range state.StatefulSets {
+		if err := k.ScaleStatefulSet(ctx, namespace, name, 0); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// setActivatorFallback adds or removes the annotations sending the
+// namespace's Ingresses' 503s to the activator. A default backend of the
+// preview's own is left alone.
+func (k *K8sService) setActivatorFallback(ctx context.Context, namespace string, enabled bool) error {
+	ingresses, err := k.client.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ingresses in %s: %v", namespace, err)
+	}
+	for _, ingress := range ingresses.Items {
+		backend, set := ingress.Annotations[nginxDefaultBackendAnnotation]
+		if (set && backend != activatorService) || (!set && !enabled) {
+			continue
+		}
+		if enabled {
+			if ingress.Annotations == nil {
+				ingress.Annotations = map[string]string{}
+			}
+			ingress.Annotations[nginxDefaultBackendAnnotation] = activatorService
+			ingress.Annotations[nginxCustomErrorsAnnotation] = "503"
+		} else {
+			delete(ingress.Annotations, nginxDefaultBackendAnnotation)
+			delete(ingress.Annotations, nginxCustomErrorsAnnotation)
+		}
+		if _, err := k.client.NetworkingV1().Ingresses(namespace).Update(ctx, &ingress, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update ingress %s: %v", ingress.Name, err)
+		}
+	}
+	return nil
+}
+
+// WakePreview scales an asleep preview's Deployments and StatefulSets back
+// up, once; later
+// calls while it starts return the same state
+func (k *K8sService) WakePreview(ctx context.Context, namespace string) (*SleepState, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, ErrPreviewNotAsleep
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	state, asleep := sleepStateOf(ns)
+	if !asleep || ns.Status.Phase == corev1.NamespaceTerminating {
+		return nil, ErrPreviewNotAsleep
+	}
+	if state.Waking != nil {
+		return state, nil
+	}
+
+	// Marked first, so concurrent requests don't scale it up again
+	now := time.Now().UTC()
+	state.Waking = &now
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sleep state: %v", err)
+	}
+	if err := k.AnnotateNamespace(ctx, namespace, nil, map[string]string{sleepAnnotation: string(raw)}); err != nil {
+		return nil, err
+	}
+	// A redeploy while asleep may have replaced some of the Deployments
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, dep := range deployments.Items {
+		replicas, slept := state.Replicas[dep.Name]
+		if !slept || dep.Spec.Replicas == nil || *dep.Spec.Replicas != 0 {
+			continue
+		}
+		if err := k.ScaleDeployment(ctx, namespace, dep.Name, replicas); err != nil {
+			return nil, err
+		}
+	}
+	if len(state.StatefulSets) == 0 {
+		return state, nil
+	}
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	for _, sts := range statefulSets.Items {
+		replicas, slept := state.StatefulSets[sts.Name]
+		if !slept || sts.Spec.Replicas == nil || *sts.Spec.Replicas != 0 {
+			continue
+		}
+		if err := k.ScaleStatefulSet(ctx, namespace, sts.Name, replicas); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// previewServing reports whether every Deployment and StatefulSet of the
+// namespace that should run has a ready pod, and at least one should
+func (k *K8sService) previewServing(ctx context.Context, namespace string) (bool, error) {
+	deployments, err := k.client.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	running := 0
+	for _, dep := range deployments.Items {
+		if dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0 {
+			continue
+		}
+		if dep.Status.AvailableReplicas == 0 {
+			return false, nil
+		}
+		running++
+	}
+	statefulSets, err := k.client.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to list statefulsets in %s: %v", namespace, err)
+	}
+	for _, sts := range statefulSets.Items {
+		if sts.Spec.Replicas != nil && *sts.Spec.Replicas == 0 {
+			continue
+		}
+		if sts.Status.ReadyReplicas == 0 {
+			return false, nil
+		}
+		running++
+	}
+	return running > 0, nil
+}
+
+// SettleWake ends an asleep preview's sleep once its Deployments serve
+// again, whether a visit, /scale or a redeploy brought them up, or once
+// waking has taken longer than timeout. It reports whether the preview is
+// awake.
+func (k *K8sService) SettleWake(ctx context.Context, namespace string, timeout time.Duration) (bool, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	state, asleep := sleepStateOf(ns)
+	if !asleep {
+		return true, nil
+	}
+	serving, err := k.previewServing(ctx, namespace)
+	if err != nil {
+		return false, err
+	}
+	if !serving && (state.Waking == nil || time.Since(*state.Waking) < timeout) {
+		return false, nil
+	}
+
+	if err := k.setActivatorFallback(ctx, namespace, false); err != nil {
+		return false, err
+	}
+	err = k.client.CoreV1().Services(namespace).Delete(ctx, activatorService, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete service %s: %v", activatorService, err)
+	}
+	if ns, err = k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{}); err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	delete(ns.Annotations, sleepAnnotation)
+	if _, err := k.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		return false, fmt.Errorf("failed to update namespace %s: %v", namespace, err)
+	}
+	return true, nil
+}
+
+// StartScaleToZero puts previews to sleep once nobody has visited them for
+// PREVIEW_SCALE_TO_ZERO_AFTER, and settles the ones woken since, until ctx
+// is cancelled. Only the leader does either, so a preview another replica
+// just served isn't put to sleep on the strength of this replica's visits.
+func (cs *CommandServiceK8s) StartScaleToZero(ctx context.Context) {
+	if cs.config.Preview.ScaleToZeroAfter <= 0 {
+		return
+	}
+	if !cs.config.Access.Tracking || cs.config.Server.PublicURL == "" || cs.config.Preview.ActivatorHost == "" || len(cs.config.Preview.ActivatorSources) == 0 {
+		fmt.Println("⚠️  Scale to zero disabled: it needs ACCESS_TRACKING, SERVER_PUBLIC_URL, PREVIEW_ACTIVATOR_HOST and PREVIEW_ACTIVATOR_SOURCES")
+		return
+	}
+	host, port, err := activatorTarget(cs.config.Preview.ActivatorHost)
+	if err != nil {
+		fmt.Printf("⚠️  Scale to zero disabled: %v\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(scaleToZeroInterval)
+	defer ticker.Stop()
+
+	for {
+		if SharedLeader().IsLeader() {
+			cs.scaleIdlePreviews(ctx, host, port)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scaleIdlePreviews makes one pass over the preview namespaces
+func (cs *CommandServiceK8s) scaleIdlePreviews(ctx context.Context, host string, port int32) {
+	namespaces, err := cs.k8s.ListPreviewNamespaces(ctx)
+	if err != nil {
+		fmt.Printf("Warning: scale to zero failed: %v\n", err)
+		return
+	}
+
+	for _, ns := range namespaces {
+		if ns.Terminating() || ns.PRNumber == 0 {
+			continue
+		}
+		if ns.Asleep {
+			awake, err := cs.k8s.SettleWake(ctx, ns.Name, cs.config.Preview.WakeTimeout)
+			if err != nil {
+				fmt.Printf("Warning: failed to settle wakeup of %s: %v\n", ns.Name, err)
+			} else if awake {
+				fmt.Printf("☀️  %s is awake\n", ns.Name)
+				cs.RefreshPreviewSummary(ns.Repo, ns.PRNumber)
+			}
+			continue
+		}
+
+		lastActive, ok := cs.previewLastActive(ctx, ns)
+		if !ok || time.Since(lastActive) < cs.config.Preview.ScaleToZeroAfter {
+			continue
+		}
+		state, err := cs.k8s.SleepPreview(ctx, ns.Name, host, port)
+		if err != nil {
+			fmt.Printf("Warning: failed to scale %s to zero: %v\n", ns.Name, err)
+			cs.logTimeline(ns.Repo, ns.PRNumber, "Failed to scale %s to zero: %v", ns.Name, err)
+			continue
+		}
+		if state == nil {
+			continue // already scaled down, by /scale or otherwise
+		}
+		fmt.Printf("💤 Scaled %s to zero after %s unvisited\n", ns.Name, formatAge(time.Since(lastActive)))
+		cs.logTimeline(ns.Repo, ns.PRNumber, "Scaled %s to zero after %s unvisited; the next visit wakes it", ns.Name, formatAge(time.Since(lastActive)))
+		cs.RefreshPreviewSummary(ns.Repo, ns.PRNumber)
+	}
+}
+
+// PreviewActivator answers the requests ingress-nginx sends the bot while a
+// preview is asleep, waking it
+type PreviewActivator struct {
+	k8s     *K8sService
+	timeout time.Duration
+	sources []*net.IPNet // the ingress controller's addresses
+}
+
+func NewPreviewActivator(cfg *config.Config) (*PreviewActivator, error) {
+	if len(cfg.Preview.ActivatorSources) == 0 {
+		return nil, fmt.Errorf("PREVIEW_ACTIVATOR_SOURCES must list the ingress controller's CIDRs")
+	}
+	var sources []*net.IPNet
+	for _, cidr := range cfg.Preview.ActivatorSources {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PREVIEW_ACTIVATOR_SOURCES entry %q: %v", cidr, err)
+		}
+		sources = append(sources, network)
+	}
+	k8s, err := NewK8sService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8s service: %v", err)
+	}
+	return &PreviewActivator{k8s: k8s, timeout: cfg.Preview.WakeTimeout, sources: sources}, nil
+}
+
+// TrustedSource reports whether a request from remoteAddr, the peer
+// address rather than anything the client claims, came from the ingress
+// controller. Only it can be trusted to name the preview in X-Namespace.
+func (a *PreviewActivator) TrustedSource(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.sources {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wake starts waking the preview in namespace and reports whether it's
+// serving yet. It returns ErrPreviewNotAsleep for namespaces that aren't
+// asleep, which is also how forged requests for other namespaces end.
+func (a *PreviewActivator) Wake(ctx context.Context, namespace string) (bool, error) {
+	if _, err := a.k8s.WakePreview(ctx, namespace); err != nil {
+		return false, err
+	}
+	return a.k8s.SettleWake(ctx, namespace, a.timeout)
+}
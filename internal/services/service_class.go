@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ServiceClass is a preset size for preview workloads
+type ServiceClass struct {
+	Name          string
+	CPURequest    string
+	MemoryRequest string
+	CPULimit      string
+	MemoryLimit   string
+	Replicas      int32
+}
+
+var serviceClasses = map[string]ServiceClass{
+	"tiny":   {Name: "tiny", CPURequest: "50m", MemoryRequest: "64Mi", CPULimit: "100m", MemoryLimit: "128Mi", Replicas: 1},
+	"small":  {Name: "small", CPURequest: "100m", MemoryRequest: "128Mi", CPULimit: "250m", MemoryLimit: "256Mi", Replicas: 1},
+	"medium": {Name: "medium", CPURequest: "250m", MemoryRequest: "256Mi", CPULimit: "500m", MemoryLimit: "512Mi", Replicas: 2},
+}
+
+// resolveServiceClass picks the class for a service: the --class flag wins,
+// then the repo config's per-service entry, then its default. No class
+// leaves the manifest untouched.
+func resolveServiceClass(flag, service string, repoConfig *RepoConfig) (*ServiceClass, error) {
+	name := flag
+	if name == "" && repoConfig != nil {
+		name = repoConfig.Classes[service]
+		if name == "" {
+			name = repoConfig.Class
+		}
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	class, ok := serviceClasses[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for known := range serviceClasses {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown service class %q (available: %s)", SanitizeEcho(name), strings.Join(names, ", "))
+	}
+	return &class, nil
+}
+
+// Resources returns the class's container requests and limits
+func (sc *ServiceClass) Resources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(sc.CPURequest),
+			corev1.ResourceMemory: resource.MustParse(sc.MemoryRequest),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(sc.CPULimit),
+			corev1.ResourceMemory: resource.MustParse(sc.MemoryLimit),
+		},
+	}
+}
+
+// Apply sizes every workload in the manifest to the class, returning a note
+// per workload changed
+func (sc *ServiceClass) Apply(parsed *ParsedManifest) []string {
+	var changes []string
+
+	for i := range parsed.Deployments {
+		dep := &parsed.Deployments[i]
+		sc.applyPodSpec(&dep.Spec.Template.Spec)
+		dep.Spec.Replicas = int32Ptr(sc.Replicas)
+		changes = append(changes, fmt.Sprintf("Sized Deployment/%s as %s", dep.Name, sc))
+	}
+	for i := range parsed.StatefulSets {
+		sts := &parsed.StatefulSets[i]
+		sc.applyPodSpec(&sts.Spec.Template.Spec)
+		sts.Spec.Replicas = int32Ptr(sc.Replicas)
+		changes = append(changes, fmt.Sprintf("Sized StatefulSet/%s as %s", sts.Name, sc))
+	}
+
+	return changes
+}
+
+func (sc *ServiceClass) applyPodSpec(spec *corev1.PodSpec) {
+	for i := range spec.Containers {
+		spec.Containers[i].Resources = sc.Resources()
+	}
+}
+
+func (sc *ServiceClass) String() string {
+	return fmt.Sprintf("%s (%s/%s CPU, %s/%s memory, %d replica(s))",
+		sc.Name, sc.CPURequest, sc.CPULimit, sc.MemoryRequest, sc.MemoryLimit, sc.Replicas)
+}
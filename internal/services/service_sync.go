@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"pr-previews/internal/types"
+)
+
+// HandlePullRequestSync deletes the previews of services a push removed from
+// the PR: ones discovered at the previous head but not at the new one, such
+// as a renamed or deleted chart directory. Other previews are left alone and
+// the removals are noted in the summary comment. A response without content
+// means there was nothing to clean up.
+func (cs *CommandServiceK8s) HandlePullRequestSync(ctx context.Context, repo string, prNumber int, before, after string) *types.CommandResponse {
+	if !cs.config.Preview.SyncCleanup || cs.config.GitHub.Token == "" || repo == "" || before == "" || after == "" {
+		return &types.CommandResponse{Success: true, Message: "Sync cleanup disabled"}
+	}
+
+	// PR numbers repeat across repositories; only this one's are touched
+	previews, err := cs.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil {
+		return &types.CommandResponse{Success: false, Message: "Sync cleanup failed", Data: map[string]interface{}{"error": err.Error()}}
+	}
+	if len(previews) == 0 {
+		return &types.CommandResponse{Success: true, Message: "No previews to check"}
+	}
+
+	if cs.onlyIgnoredChanges(ctx, repo, before, after) {
+		return &types.CommandResponse{Success: true, Message: "Only ignored paths changed"}
+	}
+
+	removed, err := cs.removedServices(ctx, repo, before, after)
+	if err != nil {
+		return &types.CommandResponse{Success: false, Message: "Sync cleanup failed", Data: map[string]interface{}{"error": err.Error()}}
+	}
+	if len(removed) == 0 {
+		return &types.CommandResponse{Success: true, Message: "No services removed"}
+	}
+	fmt.Printf("PR #%d push %s removed services: %s\n", prNumber, shortSHA(after), strings.Join(removedServiceNames(removed), ", "))
+
+	// A shared namespace holds the PR's other services too, so only
+	// per-service namespaces are deleted
+	var deleted, kept, failed, notes []string
+	for _, ns := range previews {
+		service, name := ns.Service, ns.Name
+		if !removed[service] {
+			continue
+		}
+		if ns.Shared {
+			kept = append(kept, name)
+			notes = append(notes, fmt.Sprintf("`%s` was removed from the PR; its preview shares `%s` with other services and was kept (run `/cleanup` to remove it)", service, name))
+			continue
+		}
+
+		cs.revokeVaultLeases(ctx, []string{name})
+		if err := cs.k8s.DeleteNamespace(ctx, name); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			notes = append(notes, fmt.Sprintf("`%s` was removed from the PR, but deleting `%s` failed: %v", service, name, err))
+			continue
+		}
+		deleted = append(deleted, name)
+		notes = append(notes, fmt.Sprintf("`%s` was removed from the PR, so its preview `%s` was deleted", service, name))
+		cs.logTimeline(repo, prNumber, "Deleted %s: service %s was removed at %s", name, service, shortSHA(after))
+	}
+	if len(deleted) > 0 {
+		cs.releaseSharedDependencies(ctx, repo, prNumber, deleted)
+	}
+	if len(notes) == 0 {
+		return &types.CommandResponse{Success: true, Message: "No previews of removed services"}
+	}
+
+	// The summary is the only place this is reported, and it only lives on
+	// GitHub
+	if cs.comments == PullRequestCommenter(cs.github) {
+		if err := cs.UpdatePreviewSummary(ctx, repo, prNumber, notes...); err != nil {
+			fmt.Printf("Warning: failed to update preview summary for PR #%d: %v\n", prNumber, err)
+		}
+	}
+
+	return &types.CommandResponse{
+		Success: len(failed) == 0,
+		Message: "Previews of removed services cleaned up",
+		Content: strings.Join(notes, "\n"),
+		Data: map[string]interface{}{
+			"repo":      repo,
+			"pr_number": prNumber,
+			"deleted":   deleted,
+			"kept":      kept,
+			"failed":    failed,
+		},
+	}
+}
+
+// onlyIgnoredChanges reports whether every file the push changed is ignored
+// by the change rules in the repo config at after. Without rules, or when
+// either can't be read, the push counts.
+func (cs *CommandServiceK8s) onlyIgnoredChanges(ctx context.Context, repo, before, after string) bool {
+	repoConfig, err := cs.repoConfigAt(ctx, repo, after)
+	if err != nil || repoConfig.Changes.Empty() {
+		return false
+	}
+
+	files, truncated, err := cs.github.CompareCommits(ctx, repo, before, after)
+	if err != nil || truncated || len(files) == 0 {
+		return false
+	}
+	return len(repoConfig.Changes.Relevant(files)) == 0
+}
+
+// removedServices lists, by preview service name, the services discovered at
+// before but not at after. A truncated tree could hide a service, so it
+// isn't trusted.
+func (cs *CommandServiceK8s) removedServices(ctx context.Context, repo, before, after string) (map[string]bool, error) {
+	discover := func(ref string) (map[string]bool, error) {
+		paths, truncated, err := cs.github.ListRepoTree(ctx, repo, ref)
+		if err != nil {
+			return nil, err
+		}
+		if truncated {
+			return nil, fmt.Errorf("file list of %s@%s is truncated", repo, shortSHA(ref))
+		}
+		names := make(map[string]bool)
+		for _, service := range cs.DiscoverServicesInPaths(paths) {
+			names[strings.ReplaceAll(service.Name, "/", "-")] = true
+		}
+		return names, nil
+	}
+
+	previous, err := discover(before)
+	if err != nil {
+		return nil, err
+	}
+	current, err := discover(after)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]bool)
+	for name := range previous {
+		if !current[name] {
+			removed[name] = true
+		}
+	}
+	return removed, nil
+}
+
+// removedServiceNames lists the map's keys in order, for logs
+func removedServiceNames(removed map[string]bool) []string {
+	names := make([]string, 0, len(removed))
+	for name := range removed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
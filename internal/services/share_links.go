@@ -0,0 +1,461 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// shareLinksAnnotation on a preview namespace lists its share links, so
+	// they go away with the preview
+	shareLinksAnnotation = "pr-previews.io/share-links"
+
+	// shareLinkCacheTTL is how long the proxy trusts the links it read;
+	// revoking through the bot takes effect at once
+	shareLinkCacheTTL = 15 * time.Second
+
+	// shareLinkRetention keeps expired and revoked links listed for a while
+	// so the dashboard can show who shared what
+	shareLinkRetention = 7 * 24 * time.Hour
+)
+
+// ShareLink lets someone without GitHub access open a preview through the
+// bot's proxy, with the link's password. Only hashes of the token and
+// password are kept; the password is set through the API, never in the PR.
+type ShareLink struct {
+	ID           string     `json:"id"`
+	Service      string     `json:"service"`
+	Backend      string     `json:"backend"` // Service the proxy forwards to
+	Port         int32      `json:"port"`
+	TokenHash    string     `json:"token_hash,omitempty"`
+	PasswordHash string     `json:"password_hash,omitempty"` // empty until the sharer sets one, which the link needs to open
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	RevokedBy    string     `json:"revoked_by,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the link still opens the preview
+func (l ShareLink) Active(now time.Time) bool {
+	return l.RevokedAt == nil && now.Before(l.ExpiresAt)
+}
+
+// SharePath is the proxy path a link's token opens
+func SharePath(namespace, token string) string {
+	return fmt.Sprintf("/share/%s/%s/", namespace, token)
+}
+
+// newShareToken returns a link ID and a token, which only the URL carries
+func newShareToken() (string, string, error) {
+	raw := make([]byte, 28)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate share token: %v", err)
+	}
+	return hex.EncodeToString(raw[:4]), base64.RawURLEncoding.EncodeToString(raw[4:]), nil
+}
+
+// newSharePassword returns a password for a link
+func newSharePassword() (string, error) {
+	raw := make([]byte, 15)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate share password: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashShareToken hashes a token or password; both are random, so a plain
+// hash is enough
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// shareLinkCache spares the cluster a namespace read per proxied request.
+// Every K8sService shares it, so links saved by the bot replace what the
+// proxy cached.
+type shareLinkCache struct {
+	mu      sync.Mutex
+	entries map[string]shareLinkCacheEntry
+}
+
+type shareLinkCacheEntry struct {
+	links   []ShareLink
+	fetched time.Time
+}
+
+var sharedShareLinks = &shareLinkCache{entries: map[string]shareLinkCacheEntry{}}
+
+func (c *shareLinkCache) get(namespace string) ([]ShareLink, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[namespace]
+	if !ok || time.Since(entry.fetched) > shareLinkCacheTTL {
+		return nil, false
+	}
+	return entry.links, true
+}
+
+func (c *shareLinkCache) put(namespace string, links []ShareLink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[namespace] = shareLinkCacheEntry{links: links, fetched: time.Now()}
+}
+
+// ShareLinks lists a namespace's share links, active or not. A namespace
+// that doesn't exist has none.
+func (k *K8sService) ShareLinks(ctx context.Context, namespace string) ([]ShareLink, error) {
+	ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", namespace, err)
+	}
+	return decodeShareLinks(namespace, ns.Annotations[shareLinksAnnotation])
+}
+
+func decodeShareLinks(namespace, raw string) ([]ShareLink, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var links []ShareLink
+	if err := json.Unmarshal([]byte(raw), &links); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on %s: %v", shareLinksAnnotation, namespace, err)
+	}
+	return links, nil
+}
+
+// updateShareLinks rewrites the namespace's links with update, dropping
+// those expired or revoked longer than shareLinkRetention ago. It retries on
+// conflicts, so a revoke and a new link racing each other both land.
+func (k *K8sService) updateShareLinks(ctx context.Context, namespace string, update func(links []ShareLink) ([]ShareLink, error)) error {
+	var kept []ShareLink
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ns, err := k.client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		links, err := decodeShareLinks(namespace, ns.Annotations[shareLinksAnnotation])
+		if err != nil {
+			return err
+		}
+		if links, err = update(links); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		kept = []ShareLink{}
+		for _, link := range links {
+			ended := link.ExpiresAt
+			if link.RevokedAt != nil && link.RevokedAt.Before(ended) {
+				ended = *link.RevokedAt
+			}
+			if now.Sub(ended) < shareLinkRetention {
+				kept = append(kept, link)
+			}
+		}
+		raw, err := json.Marshal(kept)
+		if err != nil {
+			return fmt.Errorf("failed to encode share links: %v", err)
+		}
+		if ns.Annotations == nil {
+			ns.Annotations = make(map[string]string)
+		}
+		ns.Annotations[shareLinksAnnotation] = string(raw)
+		_, err = k.client.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save share links of %s: %v", namespace, err)
+	}
+	sharedShareLinks.put(namespace, kept)
+	return nil
+}
+
+// previewBackend is the Service and port the preview Ingress routes the
+// service to, looking past a running tap's proxy
+func (k *K8sService) previewBackend(ctx context.Context, namespace, cleanServiceName string) (string, int32, error) {
+	ingress, err := k.previewIngress(ctx, namespace, cleanServiceName)
+	if err != nil {
+		return "", 0, err
+	}
+	if raw, ok := ingress.Annotations[tapAnnotation]; ok {
+		var session TapSession
+		if err := json.Unmarshal([]byte(raw), &session); err == nil {
+			return session.Service, session.Port, nil
+		}
+	}
+	if len(ingress.Spec.Rules) == 0 || ingress.Spec.Rules[0].HTTP == nil || len(ingress.Spec.Rules[0].HTTP.Paths) == 0 {
+		return "", 0, fmt.Errorf("ingress %s has no paths", ingress.Name)
+	}
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if backend == nil || backend.Port.Number == 0 {
+		return "", 0, fmt.Errorf("ingress %s doesn't route to a Service port number", ingress.Name)
+	}
+	return backend.Name, backend.Port.Number, nil
+}
+
+// CreateShareLink records a new link to the service's preview and returns
+// it with its token
+func (k *K8sService) CreateShareLink(ctx context.Context, namespace, cleanServiceName, user string, expiry time.Duration) (ShareLink, string, error) {
+	backend, port, err := k.previewBackend(ctx, namespace, cleanServiceName)
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	id, token, err := newShareToken()
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+
+	now := time.Now().UTC()
+	link := ShareLink{
+		ID:        id,
+		Service:   cleanServiceName,
+		Backend:   backend,
+		Port:      port,
+		TokenHash: hashShareToken(token),
+		CreatedBy: user,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiry),
+	}
+	err = k.updateShareLinks(ctx, namespace, func(links []ShareLink) ([]ShareLink, error) {
+		return append(links, link), nil
+	})
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	return link, token, nil
+}
+
+// RevokeShareLinks ends the namespace's active links: the one with id, or
+// every one of the service when id is empty, or all of them when both are.
+// It returns the links it revoked.
+func (k *K8sService) RevokeShareLinks(ctx context.Context, namespace, cleanServiceName, id, user string) ([]ShareLink, error) {
+	links, err := k.ShareLinks(ctx, namespace)
+	if err != nil || len(links) == 0 {
+		return nil, err
+	}
+
+	var revoked []ShareLink
+	err = k.updateShareLinks(ctx, namespace, func(links []ShareLink) ([]ShareLink, error) {
+		now := time.Now().UTC()
+		revoked = nil
+		for i, link := range links {
+			if !link.Active(now) || (id != "" && link.ID != id) || (id == "" && cleanServiceName != "" && link.Service != cleanServiceName) {
+				continue
+			}
+			links[i].RevokedAt = &now
+			links[i].RevokedBy = user
+			revoked = append(revoked, links[i])
+		}
+		return links, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+// SetShareLinkPassword gives the active link with id in one of the
+// namespaces a new password, replacing any it had, and returns the link and
+// the password
+func (k *K8sService) SetShareLinkPassword(ctx context.Context, namespaces []string, id string) (ShareLink, string, error) {
+	password, err := newSharePassword()
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	for _, namespace := range namespaces {
+		links, err := k.ShareLinks(ctx, namespace)
+		if err != nil {
+			return ShareLink{}, "", err
+		}
+		found := false
+		for _, link := range links {
+			found = found || link.ID == id
+		}
+		if !found {
+			continue
+		}
+
+		var updated ShareLink
+		err = k.updateShareLinks(ctx, namespace, func(links []ShareLink) ([]ShareLink, error) {
+			for i, link := range links {
+				if link.ID == id && link.Active(time.Now()) {
+					links[i].PasswordHash = hashShareToken(password)
+					updated = links[i]
+					return links, nil
+				}
+			}
+			return nil, ErrShareLinkInvalid
+		})
+		if err != nil {
+			return ShareLink{}, "", err
+		}
+		return updated, password, nil
+	}
+	return ShareLink{}, "", ErrShareLinkInvalid
+}
+
+// ShareLinkRecord is a share link as the dashboard and admin API list it
+type ShareLinkRecord struct {
+	ShareLink
+	Namespace string `json:"namespace"`
+	PRNumber  int    `json:"pr_number"`
+	Active    bool   `json:"active"`
+}
+
+// ListShareLinks lists the share links of every preview, newest first,
+// without their token hashes
+func (k *K8sService) ListShareLinks(ctx context.Context) ([]ShareLinkRecord, error) {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: "preview=true",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list preview namespaces: %v", err)
+	}
+
+	now := time.Now()
+	records := []ShareLinkRecord{}
+	for _, ns := range namespaces.Items {
+		links, err := decodeShareLinks(ns.Name, ns.Annotations[shareLinksAnnotation])
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+			continue
+		}
+		preview := previewNamespaceOf(&ns, ns.Labels["service"])
+		for _, link := range links {
+			link.TokenHash, link.PasswordHash = "", ""
+			records = append(records, ShareLinkRecord{ShareLink: link, Namespace: ns.Name, PRNumber: preview.PRNumber, Active: link.Active(now)})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.After(records[j].CreatedAt) })
+	return records, nil
+}
+
+// ShareProxy serves previews to share link holders. It answers under
+// /share/<namespace>/<token>/ and forwards to the preview's Service, so the
+// preview's own Ingress and whatever guards it are bypassed.
+type ShareProxy struct {
+	k8s       *K8sService
+	transport http.RoundTripper
+}
+
+func NewShareProxy() (*ShareProxy, error) {
+	k8s, err := NewK8sService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8s service: %v", err)
+	}
+	return &ShareProxy{k8s: k8s, transport: http.DefaultTransport}, nil
+}
+
+// ErrShareLinkInvalid is returned for tokens that don't open the namespace,
+// and ErrSharePassword for a wrong password or a link without one yet
+var (
+	ErrShareLinkInvalid = errors.New("share link is invalid, expired or revoked")
+	ErrSharePassword    = errors.New("share link password is wrong or not set")
+)
+
+// Resolve returns the active link token and password open in namespace
+func (p *ShareProxy) Resolve(ctx context.Context, namespace, token, password string) (ShareLink, error) {
+	links, ok := sharedShareLinks.get(namespace)
+	if !ok {
+		var err error
+		if links, err = p.k8s.ShareLinks(ctx, namespace); err != nil {
+			return ShareLink{}, err
+		}
+		sharedShareLinks.put(namespace, links)
+	}
+
+	hash := hashShareToken(token)
+	for _, link := range links {
+		if hmac.Equal([]byte(link.TokenHash), []byte(hash)) {
+			if !link.Active(time.Now()) {
+				break
+			}
+			if link.PasswordHash == "" || !hmac.Equal([]byte(link.PasswordHash), []byte(hashShareToken(password))) {
+				return ShareLink{}, ErrSharePassword
+			}
+			return link, nil
+		}
+	}
+	return ShareLink{}, ErrShareLinkInvalid
+}
+
+// Revoke revokes one link of a namespace, for the admin API
+func (p *ShareProxy) Revoke(ctx context.Context, namespace, id, user string) (bool, error) {
+	revoked, err := p.k8s.RevokeShareLinks(ctx, namespace, "", id, user)
+	return len(revoked) > 0, err
+}
+
+// SetPassword gives a link of the repo's PR a new password, for the preview
+// API
+func (p *ShareProxy) SetPassword(ctx context.Context, repo string, prNumber int, id string) (ShareLink, string, error) {
+	previews, err := p.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil {
+		return ShareLink{}, "", err
+	}
+	return p.k8s.SetShareLinkPassword(ctx, uniqueNamespaces(previews), id)
+}
+
+// List lists every preview's share links, for the admin API
+func (p *ShareProxy) List(ctx context.Context) ([]ShareLinkRecord, error) {
+	return p.k8s.ListShareLinks(ctx)
+}
+
+// Handler forwards a request for path to the link's Service. prefix is the
+// share path the request came in on; the preview sees it as
+// X-Forwarded-Prefix, and redirects to absolute paths are kept under it.
+// When the Service can't be reached, unavailable gets to answer first, and
+// reports whether it did.
+func (p *ShareProxy) Handler(namespace string, link ShareLink, prefix, path string, unavailable func(http.ResponseWriter, *http.Request) bool) http.Handler {
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("%s.%s.svc:%d", link.Backend, namespace, link.Port)}
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return &httputil.ReverseProxy{
+		Transport: p.transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.Out.URL.Path = "/" + strings.TrimPrefix(path, "/")
+			r.Out.URL.RawPath = ""
+			r.SetXForwarded()
+			r.Out.Header.Set("X-Forwarded-Prefix", prefix)
+			// The token is in the path and the password in the
+			// credentials; don't pass either on
+			r.Out.Header.Del("Referer")
+			r.Out.Header.Del("Authorization")
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			location := resp.Header.Get("Location")
+			if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+				resp.Header.Set("Location", prefix+location)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if unavailable != nil && unavailable(w, r) {
+				return
+			}
+			fmt.Printf("Warning: share proxy to %s/%s failed: %v\n", namespace, link.Backend, err)
+			http.Error(w, "The preview is not responding. It may still be starting; try again in a minute.", http.StatusBadGateway)
+		},
+	}
+}
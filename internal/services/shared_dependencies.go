@@ -0,0 +1,560 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/retry"
+
+	"pr-previews/internal/types"
+)
+
+// A shared dependency's namespace is labelled with the dependency's name and
+// records the digest of the manifest applied and the preview namespaces using
+// it. It isn't labelled preview=true, so PR cleanups and GC leave it to the
+// reference count. Preview namespaces using it carry the user label, which
+// lets them through its isolation policy.
+const (
+	dependencyLabel            = "pr-previews.io/dependency"
+	dependencyDigestAnnotation = "pr-previews.io/manifest-digest"
+	dependentsAnnotation       = "pr-previews.io/dependents"
+	dependencyUserLabelPrefix  = "uses.pr-previews.io/"
+
+	// dependencySweepInterval is how often dependencies whose previews all
+	// went away without a cleanup, e.g. deleted by hand, are torn down
+	dependencySweepInterval = time.Hour
+
+	// dependencyEnvConfigMap holds the env of the dependencies a preview
+	// uses, loaded by every container
+	dependencyEnvConfigMap = "preview-dependencies"
+)
+
+// SharedDependency is a backing service, such as Kafka, provisioned once for
+// all of a repo's previews in a namespace of its own
+type SharedDependency struct {
+	// Manifest is the path of the dependency's manifest in the repo
+	Manifest string `yaml:"manifest"`
+
+	// Services lists the services whose previews use it; empty means all
+	Services []string `yaml:"services"`
+
+	// Env is set on the containers of the previews using it. Values are
+	// templates rendered with .Name and .Namespace, the dependency's
+	// namespace, e.g. "kafka.{{ .Namespace }}.svc.cluster.local:9092"; its
+	// Services are also reachable from the preview by their own names.
+	Env map[string]string `yaml:"env"`
+}
+
+// dependencyData is what a dependency's env templates can use
+type dependencyData struct {
+	Name      string
+	Namespace string
+}
+
+func (d SharedDependency) validate() error {
+	if d.Manifest == "" {
+		return fmt.Errorf("manifest is required")
+	}
+	if filepath.IsAbs(d.Manifest) || strings.HasPrefix(filepath.Clean(d.Manifest), "..") {
+		return fmt.Errorf("manifest %q must be a path inside the repo", d.Manifest)
+	}
+	for _, name := range sortedKeys(d.Env) {
+		if errs := validation.IsEnvVarName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid env name %q: %s", name, strings.Join(errs, "; "))
+		}
+		if _, err := template.New(name).Option("missingkey=error").Parse(d.Env[name]); err != nil {
+			return fmt.Errorf("invalid env template %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (d SharedDependency) usedBy(service string) bool {
+	if len(d.Services) == 0 {
+		return true
+	}
+	cleanServiceName := strings.ReplaceAll(service, "/", "-")
+	for _, user := range d.Services {
+		if user == service || user == cleanServiceName {
+			return true
+		}
+	}
+	return false
+}
+
+// env renders the dependency's env for its namespace
+func (d SharedDependency) env(data dependencyData) (map[string]string, error) {
+	env := make(map[string]string, len(d.Env))
+	for _, name := range sortedKeys(d.Env) {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(d.Env[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid env template %s: %v", name, err)
+		}
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("failed to render env %s: %v", name, err)
+		}
+		env[name] = value.String()
+	}
+	return env, nil
+}
+
+// DependenciesFor names the shared dependencies the service's previews use
+func (c *RepoConfig) DependenciesFor(service string) []string {
+	var names []string
+	for name, dependency := range c.Dependencies {
+		if dependency.usedBy(service) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dependencyNamespace names the namespace a version of a repo's dependency
+// runs in, e.g. preview-dep-acme-shop-kafka-3f2a9c1d. Every manifest digest
+// gets a namespace of its own, so a PR changing the manifest never changes
+// what the other previews use.
+func dependencyNamespace(repo, name, digest string) string {
+	if repo == "" {
+		return SlugifyBranch(fmt.Sprintf("preview-dep-%s-%s", name, digest[:8]))
+	}
+	return SlugifyBranch(fmt.Sprintf("preview-dep-%s-%s-%s", strings.ReplaceAll(repo, "/", "-"), name, digest[:8]))
+}
+
+// manifestDigest identifies a dependency's manifest by what it renders to
+func manifestDigest(manifest *ParsedManifest) (string, error) {
+	rendered, err := RenderManifest(manifest)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(rendered)
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// dependencyUserLabel marks a preview namespace as a user of the dependency
+// namespace
+func dependencyUserLabel(dependencyNS string) string {
+	return dependencyUserLabelPrefix + dependencyNS
+}
+
+// DependencyEnvSource loads the env of the preview's shared dependencies. It
+// is optional, so pods start in namespaces that use none.
+func DependencyEnvSource() corev1.EnvFromSource {
+	return corev1.EnvFromSource{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: dependencyEnvConfigMap},
+			Optional:             boolPtr(true),
+		},
+	}
+}
+
+// addDependencyEnv makes every container load the dependencies' env
+func addDependencyEnv(parsed *ParsedManifest) {
+	addTo := func(spec *corev1.PodSpec) {
+		for i := range spec.Containers {
+			spec.Containers[i].EnvFrom = append(spec.Containers[i].EnvFrom, DependencyEnvSource())
+		}
+	}
+	for i := range parsed.Deployments {
+		addTo(&parsed.Deployments[i].Spec.Template.Spec)
+	}
+	for i := range parsed.StatefulSets {
+		addTo(&parsed.StatefulSets[i].Spec.Template.Spec)
+	}
+}
+
+// wireSharedDependencies provisions the shared dependencies the service's
+// preview uses and wires them in: each Service of a dependency gets an
+// ExternalName Service of the same name in the preview namespace, unless the
+// preview deploys its own, and the dependencies' env goes in a ConfigMap
+// every container loads. The preview namespace is counted as a user of each,
+// and released from the versions of them it used before. Dependency
+// manifests pass the same image policy, policy engine, repo quota and
+// admission preflight as the preview's own.
+func (cs *CommandServiceK8s) wireSharedDependencies(ctx context.Context, cmd *types.Command, repoPath string, repoConfig *RepoConfig, serviceName, namespace string, parsed *ParsedManifest) ([]string, error) {
+	names := repoConfig.DependenciesFor(serviceName)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	own := make(map[string]bool, len(parsed.Services))
+	for _, svc := range parsed.Services {
+		own[svc.Name] = true
+	}
+
+	var resources []string
+	env := map[string]string{}
+	for _, name := range names {
+		dependency := repoConfig.Dependencies[name]
+		manifest, err := NewManifestParser(cs.decryptor).ParseManifestFile(filepath.Join(repoPath, dependency.Manifest))
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		if violations := cs.images.Check(manifest); len(violations) > 0 {
+			return nil, fmt.Errorf("dependency %s: image %s of %s is %s", name, violations[0].Image, violations[0].Object, violations[0].Reason)
+		}
+		if err := cs.checkDependencyPolicy(manifest); err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+
+		digest, err := manifestDigest(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		dependencyNS := dependencyNamespace(cmd.Repo, name, digest)
+
+		// A version not running yet counts against the repo's quota
+		exists, err := cs.k8s.NamespaceExists(ctx, dependencyNS)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		release := func() {}
+		if !exists {
+			requested, _ := manifestRequests(manifest, cs.requestDefaults())
+			if release, err = cs.checkRepoQuota(ctx, cmd.Repo, requested, nil); err != nil {
+				return nil, fmt.Errorf("dependency %s: %v", name, err)
+			}
+		}
+
+		if err := cs.k8s.AnnotateNamespace(ctx, namespace, map[string]string{dependencyUserLabel(dependencyNS): "true"}, nil); err != nil {
+			release()
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		applied, err := cs.k8s.EnsureSharedDependency(ctx, dependencyNS, cmd.Repo, name, digest, manifest, namespace, cs.config.Preview.AdmissionPreflight)
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		if applied {
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Applied shared dependency %s in %s", name, dependencyNS)
+		}
+		released, err := cs.k8s.ReleaseSharedDependencyVersions(ctx, cmd.Repo, name, dependencyNS, namespace)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		for _, old := range released {
+			cs.logTimeline(cmd.Repo, cmd.PRNumber, "Deleted shared dependency %s: its last preview moved to another version", old)
+		}
+
+		for _, svc := range manifest.Services {
+			if own[svc.Name] {
+				continue
+			}
+			target := fmt.Sprintf("%s.%s.svc.cluster.local", svc.Name, dependencyNS)
+			if err := cs.k8s.UpsertExternalNameService(ctx, namespace, svc.Name, target, svc.Spec.Ports); err != nil {
+				return nil, fmt.Errorf("dependency %s: %v", name, err)
+			}
+			resources = append(resources, fmt.Sprintf("Service/%s → %s", svc.Name, dependencyNS))
+		}
+
+		values, err := dependency.env(dependencyData{Name: name, Namespace: dependencyNS})
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %v", name, err)
+		}
+		for key, value := range values {
+			env[key] = value
+		}
+	}
+
+	if len(env) > 0 {
+		if err := cs.k8s.UpsertConfigMap(ctx, namespace, dependencyEnvConfigMap, env); err != nil {
+			return nil, err
+		}
+		addDependencyEnv(parsed)
+		resources = append(resources, fmt.Sprintf("ConfigMap/%s", dependencyEnvConfigMap))
+	}
+	return resources, nil
+}
+
+// checkDependencyPolicy refuses a dependency manifest the policy engine
+// enforces against
+func (cs *CommandServiceK8s) checkDependencyPolicy(manifest *ParsedManifest) error {
+	report, err := cs.policy.Evaluate(manifest)
+	if err != nil {
+		return fmt.Errorf("policy evaluation failed: %v", err)
+	}
+	if !report.Violated() || report.Mode != PolicyEnforce {
+		return nil
+	}
+	var violations []string
+	for _, result := range report.Results {
+		if len(result.Violations) > 0 {
+			violations = append(violations, fmt.Sprintf("%s (%s)", result.Message, strings.Join(result.Violations, ", ")))
+		}
+	}
+	return fmt.Errorf("blocked by policy: %s", strings.Join(violations, "; "))
+}
+
+// EnsureSharedDependency creates the namespace of a dependency version on
+// first use, with the namespace baseline, applies its manifest once, and adds
+// the preview namespace to its users. With preflight, the manifest is dry run
+// through admission control first. It reports whether the manifest was
+// applied.
+func (k *K8sService) EnsureSharedDependency(ctx context.Context, name, repo, dependency, digest string, manifest *ParsedManifest, dependent string, preflight bool) (bool, error) {
+	namespaces := k.client.CoreV1().Namespaces()
+	_, err := namespaces.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				dependencyLabel: dependency,
+				"created-by":    "pr-previews",
+			},
+			Annotations: map[string]string{previewRepoAnnotation: repo},
+		}}
+		if _, err = namespaces.Create(ctx, namespace, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create namespace %s: %v", name, err)
+		}
+		// Its users must reach it through the isolation policy
+		users := []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{dependencyUserLabel(name): "true"},
+		}}}
+		if err := k.applyBaseline(ctx, name, users); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+
+	// Record the user first, so a concurrent release can't delete the
+	// namespace between applying and counting
+	apply := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		namespace, err := namespaces.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if namespace.Labels[dependencyLabel] != dependency {
+			return fmt.Errorf("namespace %s exists but is not the shared dependency %s", name, dependency)
+		}
+		if namespace.Status.Phase == corev1.NamespaceTerminating {
+			return fmt.Errorf("namespace %s is still terminating after its last preview ended; try again shortly", name)
+		}
+		dependents := dependencyDependents(namespace)
+		apply = namespace.Annotations[dependencyDigestAnnotation] != digest
+		if !apply && containsString(dependents, dependent) {
+			return nil
+		}
+		if namespace.Annotations == nil {
+			namespace.Annotations = make(map[string]string)
+		}
+		if !containsString(dependents, dependent) {
+			dependents = append(dependents, dependent)
+			sort.Strings(dependents)
+		}
+		namespace.Annotations[dependentsAnnotation] = strings.Join(dependents, ",")
+		_, err = namespaces.Update(ctx, namespace, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s to the users of %s: %v", dependent, name, err)
+	}
+	if !apply {
+		return false, nil
+	}
+
+	if preflight {
+		if denials := k.SimulateAdmission(ctx, name, manifest); len(denials) > 0 {
+			return false, fmt.Errorf("admission control refused %s: %s", denials[0].Object, strings.Join(denials[0].Reasons, "; "))
+		}
+	}
+	labels := map[string]string{dependencyLabel: dependency, "managed-by": "pr-previews"}
+	if err := k.ApplyParsedManifest(ctx, name, manifest, labels, nil); err != nil {
+		return false, err
+	}
+	if err := k.AnnotateNamespace(ctx, name, nil, map[string]string{dependencyDigestAnnotation: digest}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpsertExternalNameService points a Service in the namespace at a DNS name,
+// copying the ports so clients see the same Service they'd deploy themselves
+func (k *K8sService) UpsertExternalNameService(ctx context.Context, namespace, name, target string, ports []corev1.ServicePort) error {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"managed-by": "pr-previews"},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: target},
+	}
+	for _, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{Name: port.Name, Protocol: port.Protocol, Port: port.Port})
+	}
+
+	client := k.client.CoreV1().Services(namespace)
+	_, err := client.Create(ctx, service, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		var existing *corev1.Service
+		if existing, err = client.Get(ctx, name, metav1.GetOptions{}); err == nil {
+			service.ResourceVersion = existing.ResourceVersion
+			_, err = client.Update(ctx, service, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply service %s: %v", name, err)
+	}
+	return nil
+}
+
+// ReleaseSharedDependencies removes the namespaces from the users of every
+// shared dependency, dropping users that no longer exist along the way, and
+// deletes the dependencies left without any. It returns the namespaces it
+// deleted.
+func (k *K8sService) ReleaseSharedDependencies(ctx context.Context, released []string) ([]string, error) {
+	dependencies, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: dependencyLabel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared dependencies: %v", err)
+	}
+	gone := make(map[string]bool, len(released))
+	for _, name := range released {
+		gone[name] = true
+	}
+	return k.releaseDependencies(ctx, dependencies.Items, gone)
+}
+
+// ReleaseSharedDependencyVersions removes the preview namespace from the
+// users of the repo's other versions of the dependency than keep, deleting
+// the ones left without any
+func (k *K8sService) ReleaseSharedDependencyVersions(ctx context.Context, repo, dependency, keep, dependent string) ([]string, error) {
+	dependencies, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: dependencyLabel + "=" + dependency})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shared dependency %s: %v", dependency, err)
+	}
+	var others []corev1.Namespace
+	for _, namespace := range dependencies.Items {
+		if namespace.Name != keep && strings.EqualFold(namespace.Annotations[previewRepoAnnotation], repo) &&
+			containsString(dependencyDependents(&namespace), dependent) {
+			others = append(others, namespace)
+		}
+	}
+	return k.releaseDependencies(ctx, others, map[string]bool{dependent: true})
+}
+
+// releaseDependencies releases the namespaces from the dependencies and
+// deletes the ones left without users
+func (k *K8sService) releaseDependencies(ctx context.Context, dependencies []corev1.Namespace, gone map[string]bool) ([]string, error) {
+	var deleted, failures []string
+	for _, dependency := range dependencies {
+		if dependency.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		remaining, err := k.releaseDependency(ctx, dependency.Name, gone)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if remaining > 0 {
+			continue
+		}
+		if err := k.DeleteNamespace(ctx, dependency.Name); err != nil && !apierrors.IsNotFound(err) {
+			failures = append(failures, err.Error())
+			continue
+		}
+		deleted = append(deleted, dependency.Name)
+	}
+
+	if len(failures) > 0 {
+		return deleted, fmt.Errorf("failed to release shared dependencies: %s", strings.Join(failures, "; "))
+	}
+	return deleted, nil
+}
+
+// releaseDependency rewrites a dependency's users without the released and
+// vanished namespaces, returning how many are left
+func (k *K8sService) releaseDependency(ctx context.Context, name string, released map[string]bool) (int, error) {
+	remaining := 0
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		namespace, err := k.client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		dependents := dependencyDependents(namespace)
+		var kept []string
+		for _, dependent := range dependents {
+			if released[dependent] {
+				continue
+			}
+			user, err := k.client.CoreV1().Namespaces().Get(ctx, dependent, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) || (err == nil && user.Status.Phase == corev1.NamespaceTerminating) {
+				continue
+			}
+			// A lookup that failed keeps its user, erring on not deleting
+			kept = append(kept, dependent)
+		}
+		remaining = len(kept)
+		if remaining == len(dependents) {
+			return nil
+		}
+		namespace.Annotations[dependentsAnnotation] = strings.Join(kept, ",")
+		_, err = k.client.CoreV1().Namespaces().Update(ctx, namespace, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update the users of %s: %v", name, err)
+	}
+	return remaining, nil
+}
+
+func dependencyDependents(namespace *corev1.Namespace) []string {
+	var dependents []string
+	for _, dependent := range strings.Split(namespace.Annotations[dependentsAnnotation], ",") {
+		if dependent != "" {
+			dependents = append(dependents, dependent)
+		}
+	}
+	return dependents
+}
+
+// releaseSharedDependencies releases the deleted preview namespaces' shared
+// dependencies, logging what was torn down and any failure rather than
+// failing the cleanup that triggered it
+func (cs *CommandServiceK8s) releaseSharedDependencies(ctx context.Context, repo string, prNumber int, deleted []string) []string {
+	released, err := cs.k8s.ReleaseSharedDependencies(ctx, deleted)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		cs.logTimeline(repo, prNumber, "%v", err)
+	}
+	for _, name := range released {
+		cs.logTimeline(repo, prNumber, "Deleted shared dependency %s: its last preview ended", name)
+	}
+	return released
+}
+
+// StartDependencySweeper tears down shared dependencies whose previews went
+// away without releasing them, until ctx is cancelled
+func (cs *CommandServiceK8s) StartDependencySweeper(ctx context.Context) {
+	ticker := time.NewTicker(dependencySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := cs.k8s.ReleaseSharedDependencies(ctx, nil)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		for _, name := range deleted {
+			fmt.Printf("Deleted shared dependency %s: its last preview is gone\n", name)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SopsDecryptor decrypts SOPS-encrypted YAML using the sops CLI
+type SopsDecryptor struct {
+	binary     string
+	ageKeyFile string
+	kmsKeyARN  string
+}
+
+func NewSopsDecryptor(binary, ageKeyFile, kmsKeyARN string) *SopsDecryptor {
+	return &SopsDecryptor{
+		binary:     binary,
+		ageKeyFile: ageKeyFile,
+		kmsKeyARN:  kmsKeyARN,
+	}
+}
+
+// Configured reports whether a decryption key is available
+func (sd *SopsDecryptor) Configured() bool {
+	return sd.ageKeyFile != "" || sd.kmsKeyARN != ""
+}
+
+// IsEncrypted checks for the top-level sops metadata block
+func (sd *SopsDecryptor) IsEncrypted(content []byte) bool {
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal(content, &obj); err != nil {
+		return false
+	}
+	_, ok := obj["sops"]
+	return ok
+}
+
+// Decrypt returns the plaintext YAML for SOPS-encrypted content
+func (sd *SopsDecryptor) Decrypt(content []byte) ([]byte, error) {
+	if !sd.Configured() {
+		return nil, fmt.Errorf("no SOPS key configured (set SOPS_AGE_KEY_FILE or SOPS_KMS_ARN)")
+	}
+
+	cmd := exec.Command(sd.binary, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", "/dev/stdin")
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Env = os.Environ()
+	if sd.ageKeyFile != "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+sd.ageKeyFile)
+	}
+	if sd.kmsKeyARN != "" {
+		cmd.Env = append(cmd.Env, "SOPS_KMS_ARN="+sd.kmsKeyARN)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	plaintext, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sops decrypt failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// StuckNamespaceMonitor periodically looks for preview namespaces stuck in
+// Terminating and keeps the latest findings for the admin API and metrics
+type StuckNamespaceMonitor struct {
+	mu        sync.Mutex
+	k8s       *K8sService
+	threshold time.Duration
+	stuck     []NamespaceBlocker
+	checkedAt time.Time
+}
+
+func NewStuckNamespaceMonitor(threshold time.Duration) *StuckNamespaceMonitor {
+	return &StuckNamespaceMonitor{threshold: threshold}
+}
+
+// Run checks every interval until ctx is cancelled
+func (m *StuckNamespaceMonitor) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if _, err := m.client(); err != nil {
+		fmt.Printf("⚠️  Stuck namespace check disabled: %v\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Check(ctx); err != nil {
+			fmt.Printf("Warning: stuck namespace check failed: %v\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check rescans the cluster, logging namespaces that became stuck since the
+// previous scan
+func (m *StuckNamespaceMonitor) Check(ctx context.Context) error {
+	k8s, err := m.client()
+	if err != nil {
+		return err
+	}
+
+	stuck, err := k8s.ListStuckPreviewNamespaces(ctx, m.threshold)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	known := make(map[string]bool, len(m.stuck))
+	for _, blocker := range m.stuck {
+		known[blocker.Namespace] = true
+	}
+	for _, blocker := range stuck {
+		if !known[blocker.Namespace] {
+			fmt.Printf("⚠️  Namespace %s stuck in Terminating since %s: %s\n",
+				blocker.Namespace, blocker.TerminatingSince.Format(time.RFC3339), strings.Join(blocker.Finalizers, ", "))
+		}
+	}
+
+	m.stuck = stuck
+	m.checkedAt = time.Now()
+	return nil
+}
+
+func (m *StuckNamespaceMonitor) client() (*K8sService, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.k8s == nil {
+		k8s, err := NewK8sService()
+		if err != nil {
+			return nil, err
+		}
+		m.k8s = k8s
+	}
+	return m.k8s, nil
+}
+
+// Snapshot returns the findings of the last check
+func (m *StuckNamespaceMonitor) Snapshot() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stuck := make([]NamespaceBlocker, len(m.stuck))
+	copy(stuck, m.stuck)
+	return map[string]interface{}{
+		"stuck":      stuck,
+		"count":      len(stuck),
+		"threshold":  m.threshold.String(),
+		"checked_at": m.checkedAt,
+	}
+}
+
+// Count returns how many namespaces the last check found stuck
+func (m *StuckNamespaceMonitor) Count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.stuck)
+}
+
+// ForceCleanupNamespace finalizes a stuck preview namespace, returning what
+// was blocking it
+func (cs *CommandServiceK8s) ForceCleanupNamespace(ctx context.Context, namespace string) (*NamespaceBlocker, error) {
+	blocker, err := cs.k8s.GetNamespaceBlocker(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if blocker == nil {
+		return nil, fmt.Errorf("namespace %s not found", namespace)
+	}
+
+	if err := cs.k8s.ForceFinalizeNamespace(ctx, namespace); err != nil {
+		return blocker, err
+	}
+	return blocker, nil
+}
+
+// HandleForceCleanupK8s is the ops command that force-cleans a namespace
+// stuck in Terminating
+func (cs *CommandServiceK8s) HandleForceCleanupK8s(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	namespace := cmd.Args["namespace"]
+
+	blocker, err := cs.ForceCleanupNamespace(ctx, namespace)
+	if err != nil {
+		return &types.CommandResponse{
+			Success: false,
+			Message: "Force cleanup failed",
+			Content: fmt.Sprintf("## ❌ Force Cleanup Failed\n\n**Namespace:** `%s`\n**Error:** %s", namespace, err.Error()),
+		}
+	}
+
+	return &types.CommandResponse{
+		Success: true,
+		Message: "Namespace force-cleaned",
+		Content: fmt.Sprintf("## 🧨 Namespace Force-Cleaned\n\nThe finalizers of `%s` were cleared. It was blocked by:\n\n%s*Objects that were still inside are orphaned; check the blocking controllers above.*\n\n*Triggered by: @%s*",
+			namespace, formatNamespaceBlockers([]NamespaceBlocker{*blocker}), cmd.User),
+		Data: map[string]interface{}{
+			"namespace": namespace,
+			"blocker":   blocker,
+		},
+	}
+}
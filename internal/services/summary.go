@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previewSummaryMarker identifies the bot's summary comment on a PR
+const previewSummaryMarker = "<!-- pr-previews:summary -->"
+
+// Markers around the bot-owned section of the PR description
+const (
+	descriptionSectionStart = "<!-- pr-previews:environments:start -->"
+	descriptionSectionEnd   = "<!-- pr-previews:environments:end -->"
+)
+
+// previewSummaryRow is one preview's current state
+type previewSummaryRow struct {
+	Service    string
+	Status     string
+	Host       string // host plus path when the preview is served under one
+	LastDeploy string
+	Expires    string
+}
+
+// previewSummaryRows collects every preview of the repo's PR, sorted by
+// service
+func (cs *CommandServiceK8s) previewSummaryRows(ctx context.Context, repo string, prNumber int) ([]previewSummaryRow, error) {
+	namespaces, err := cs.k8s.GetPreviewNamespacesByPR(ctx, repo, prNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Service < namespaces[j].Service
+	})
+
+	var rows []previewSummaryRow
+	for _, ns := range namespaces {
+		host := ns.Host
+		if host != "" && ns.Path != "" && ns.Path != "/" {
+			host += ns.Path
+		}
+
+		status := "💤 Asleep; wakes on the next visit"
+		if !ns.Asleep || ns.Terminating() {
+			status = cs.previewSummaryStatus(ctx, ns.Name, ns.Status, ns.Readiness)
+		}
+		row := previewSummaryRow{
+			Service:    ns.Service,
+			Status:     status,
+			Host:       host,
+			LastDeploy: "—",
+			Expires:    "—",
+		}
+		if !ns.CreatedAt.IsZero() {
+			row.LastDeploy = ns.CreatedAt.UTC().Format("2006-01-02 15:04 UTC")
+		}
+		if expiresAt, ok := cs.previewExpiry(ns); ok {
+			row.Expires = expiresAt.UTC().Format("2006-01-02 15:04 UTC")
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// UpdatePreviewSummary rewrites the PR's single summary comment with the
+// current state of every preview, so reviewers don't have to piece it
+// together from the comment history. Notes, such as previews removed along
+// with their service, are listed until the next update. With
+// PREVIEW_DESCRIPTION_LINKS the PR description gets a matching section too.
+func (cs *CommandServiceK8s) UpdatePreviewSummary(ctx context.Context, repo string, prNumber int, notes ...string) error {
+	rows, err := cs.previewSummaryRows(ctx, repo, prNumber)
+	if err != nil {
+		return err
+	}
+
+	var content strings.Builder
+	content.WriteString("## 📌 Preview Summary\n\n")
+
+	if len(rows) == 0 {
+		content.WriteString("No preview environments are active for this PR.\n")
+	} else {
+		content.WriteString("| Service | Status | URL | Last deploy | Expires |\n")
+		content.WriteString("|---------|--------|-----|-------------|---------|\n")
+		for _, row := range rows {
+			url := "—"
+			if row.Host != "" {
+				url = fmt.Sprintf("[%s](https://%s)", row.Host, row.Host)
+			}
+			content.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s | %s |\n",
+				row.Service, row.Status, url, row.LastDeploy, row.Expires))
+		}
+	}
+
+	if len(notes) > 0 {
+		content.WriteString("\n### ♻️ Removed from this PR\n")
+		for _, note := range notes {
+			content.WriteString("- " + note + "\n")
+		}
+	}
+
+	content.WriteString(fmt.Sprintf("\n*Updated %s. This comment is kept up to date by the bot.*", time.Now().UTC().Format("2006-01-02 15:04 UTC")))
+
+	if err := cs.github.UpsertComment(ctx, repo, prNumber, previewSummaryMarker, content.String()); err != nil {
+		return err
+	}
+
+	if cs.config.Preview.DescriptionLinks {
+		return cs.updateDescriptionSection(ctx, repo, prNumber, rows)
+	}
+	return nil
+}
+
+// updateDescriptionSection replaces the bot's section of the PR description,
+// appending it on first use and leaving the author's text untouched
+func (cs *CommandServiceK8s) updateDescriptionSection(ctx context.Context, repo string, prNumber int, rows []previewSummaryRow) error {
+	if repo == "" || cs.config.GitHub.Token == "" {
+		return nil
+	}
+
+	// Read live so a description edit made moments ago isn't overwritten
+	cs.github.InvalidatePullRequest(repo, prNumber)
+	description, err := cs.github.GetPullRequestBody(ctx, repo, prNumber)
+	if err != nil {
+		return err
+	}
+
+	var section strings.Builder
+	section.WriteString(descriptionSectionStart + "\n### 🔍 Preview environments\n\n")
+	if len(rows) == 0 {
+		section.WriteString("_No active previews._\n")
+	}
+	for _, row := range rows {
+		if row.Host != "" {
+			section.WriteString(fmt.Sprintf("- **%s**: https://%s — %s\n", row.Service, row.Host, row.Status))
+		} else {
+			section.WriteString(fmt.Sprintf("- **%s**: %s\n", row.Service, row.Status))
+		}
+	}
+	section.WriteString(descriptionSectionEnd)
+
+	updated := replaceDescriptionSection(description, section.String())
+	if updated == description {
+		return nil
+	}
+	return cs.github.UpdatePullRequestBody(ctx, repo, prNumber, updated)
+}
+
+// replaceDescriptionSection swaps the marked section in, or appends it
+func replaceDescriptionSection(description, section string) string {
+	start := strings.Index(description, descriptionSectionStart)
+	end := strings.Index(description, descriptionSectionEnd)
+	if start >= 0 && end > start {
+		return description[:start] + section + description[end+len(descriptionSectionEnd):]
+	}
+
+	if strings.TrimSpace(description) == "" {
+		return section
+	}
+	return strings.TrimRight(description, "\n") + "\n\n" + section
+}
+
+func (cs *CommandServiceK8s) previewSummaryStatus(ctx context.Context, namespace, phase, readiness string) string {
+	if phase == "Terminating" {
+		return "🗑️ Cleaning up"
+	}
+
+	progress, err := cs.k8s.GetRolloutProgress(ctx, namespace)
+	switch {
+	case err != nil:
+		return "❔ Unknown"
+	case progress.Total == 0:
+		return "⏳ Pending"
+	case progress.Complete() && readiness == ReadinessPending:
+		return fmt.Sprintf("🌐 Checking URL (%d/%d pods ready)", progress.Ready, progress.Total)
+	case progress.Complete() && readiness != "" && readiness != ReadinessPassed:
+		return fmt.Sprintf("⚠️ URL failing (%d/%d pods ready)", progress.Ready, progress.Total)
+	case progress.Complete():
+		return fmt.Sprintf("✅ Ready (%d/%d)", progress.Ready, progress.Total)
+	default:
+		return fmt.Sprintf("🔄 Starting %s", progress)
+	}
+}
+
+// summaryRefreshes runs the background summary updates, one at a time per
+// PR so two can't both create the summary check run. An update asked for
+// while one runs is folded into a single rerun after it, so the last state
+// is what's shown.
+type summaryRefreshes struct {
+	mu      sync.Mutex
+	running map[string]bool
+	pending map[string]bool // PR to whether the rerun includes the comment
+}
+
+var sharedSummaryRefreshes = &summaryRefreshes{
+	running: make(map[string]bool),
+	pending: make(map[string]bool),
+}
+
+// schedule runs refresh(comment) in the background for the PR, or after the
+// refresh already running for it
+func (s *summaryRefreshes) schedule(repo string, prNumber int, comment bool, refresh func(comment bool)) {
+	key := fmt.Sprintf("%s#%d", strings.ToLower(repo), prNumber)
+	s.mu.Lock()
+	if s.running[key] {
+		s.pending[key] = s.pending[key] || comment
+		s.mu.Unlock()
+		return
+	}
+	s.running[key] = true
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			refresh(comment)
+
+			s.mu.Lock()
+			again, ok := s.pending[key]
+			delete(s.pending, key)
+			if !ok {
+				delete(s.running, key)
+				s.mu.Unlock()
+				return
+			}
+			s.mu.Unlock()
+			comment = again
+		}
+	}()
+}
+
+// RefreshPreviewSummary returns at once and updates the summary, and the
+// summary check run with it, on a background goroutine (see
+// summaryRefreshes). Failures are logged rather than failing the command
+// that triggered it.
+func (cs *CommandServiceK8s) RefreshPreviewSummary(repo string, prNumber int) {
+	// The summary is a GitHub comment edited in place; other providers only
+	// get the replies
+	if cs.comments != PullRequestCommenter(cs.github) {
+		return
+	}
+	sharedSummaryRefreshes.schedule(repo, prNumber, true, func(comment bool) {
+		cs.refreshSummaries(repo, prNumber, comment)
+	})
+}
+
+// refreshSummaries updates the summary comment, when asked to, then the
+// summary check run
+func (cs *CommandServiceK8s) refreshSummaries(repo string, prNumber int, comment bool) {
+	if comment {
+		cs.refreshPreviewSummary(repo, prNumber)
+	}
+	cs.refreshCheckSummary(repo, prNumber)
+}
+
+func (cs *CommandServiceK8s) refreshPreviewSummary(repo string, prNumber int) {
+	err := cs.UpdatePreviewSummary(context.Background(), repo, prNumber)
+	if errors.Is(err, errGitHubBudgetLow) {
+		fmt.Printf("Skipped preview summary update for PR #%d: %v\n", prNumber, err)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to update preview summary for PR #%d: %v\n", prNumber, err)
+		cs.logTimeline(repo, prNumber, "Failed to update the preview summary: %v", err)
+	}
+}
+
+// previewTTL is the namespace's lifetime: the PR description's override when
+// set, otherwise PREVIEW_GC_MAX_AGE
+func (cs *CommandServiceK8s) previewTTL(ns PreviewNamespace) time.Duration {
+	if ns.TTL != "" {
+		if ttl, err := time.ParseDuration(ns.TTL); err == nil {
+			return ttl
+		}
+	}
+	return cs.config.Preview.GCMaxAge
+}
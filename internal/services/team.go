@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// deployersKey is where runtime grants live in the artifact store
+const deployersKey = "team/deployers.json"
+
+// rosterRefresh is how long a replica trusts the grants it last read, so a
+// /grant or /revoke handled by another replica reaches it within that time
+const rosterRefresh = 10 * time.Second
+
+// Deployer is a user granted deploy rights at runtime
+type Deployer struct {
+	Login     string    `json:"login"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// DeployerRoster answers who may deploy: the configured core team plus
+// users granted with /grant, which are persisted in the artifact store so
+// they survive restarts and are shared by the replicas
+type DeployerRoster struct {
+	mu       sync.Mutex
+	store    ArtifactStore
+	coreTeam []string
+	granted  map[string]Deployer // lowercased login -> grant
+	loadedAt time.Time
+}
+
+func NewDeployerRoster(store ArtifactStore, coreTeam []string) *DeployerRoster {
+	return &DeployerRoster{store: store, coreTeam: coreTeam}
+}
+
+// IsDeployer reports whether the user is core team or holds a grant. A store
+// that can't be read only loses the runtime grants.
+func (r *DeployerRoster) IsDeployer(ctx context.Context, login string) bool {
+	for _, member := range r.coreTeam {
+		if strings.EqualFold(login, member) {
+			return true
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.loadLocked(ctx); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return false
+	}
+	_, ok := r.granted[strings.ToLower(login)]
+	return ok
+}
+
+// List returns the core team and the runtime grants
+func (r *DeployerRoster) List(ctx context.Context) ([]string, []Deployer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.loadLocked(ctx); err != nil {
+		return nil, nil, err
+	}
+	return r.coreTeam, r.sortedLocked(), nil
+}
+
+// Grant gives login deploy rights
+func (r *DeployerRoster) Grant(ctx context.Context, login, grantedBy string) (Deployer, error) {
+	for _, member := range r.coreTeam {
+		if strings.EqualFold(login, member) {
+			return Deployer{}, fmt.Errorf("@%s is already on the core team", login)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadedAt = time.Time{}
+	if err := r.loadLocked(ctx); err != nil {
+		return Deployer{}, err
+	}
+	if existing, ok := r.granted[strings.ToLower(login)]; ok {
+		return existing, fmt.Errorf("@%s is already a deployer", existing.Login)
+	}
+
+	deployer := Deployer{Login: login, GrantedBy: grantedBy, GrantedAt: time.Now().UTC()}
+	r.granted[strings.ToLower(login)] = deployer
+	if err := r.saveLocked(ctx); err != nil {
+		delete(r.granted, strings.ToLower(login))
+		return Deployer{}, err
+	}
+	return deployer, nil
+}
+
+// Revoke removes a runtime grant; the configured core team can't be revoked
+func (r *DeployerRoster) Revoke(ctx context.Context, login string) (Deployer, error) {
+	for _, member := range r.coreTeam {
+		if strings.EqualFold(login, member) {
+			return Deployer{}, fmt.Errorf("@%s is on the configured core team (GITHUB_CORE_TEAM) and can't be revoked at runtime", login)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadedAt = time.Time{}
+	if err := r.loadLocked(ctx); err != nil {
+		return Deployer{}, err
+	}
+	deployer, ok := r.granted[strings.ToLower(login)]
+	if !ok {
+		return Deployer{}, fmt.Errorf("@%s is not a deployer", login)
+	}
+
+	delete(r.granted, strings.ToLower(login))
+	if err := r.saveLocked(ctx); err != nil {
+		r.granted[strings.ToLower(login)] = deployer
+		return Deployer{}, err
+	}
+	return deployer, nil
+}
+
+// loadLocked re-reads the grants once the last read is older than
+// rosterRefresh
+func (r *DeployerRoster) loadLocked(ctx context.Context) error {
+	if r.granted != nil && time.Since(r.loadedAt) < rosterRefresh {
+		return nil
+	}
+	if r.store == nil {
+		if r.granted == nil {
+			r.granted = map[string]Deployer{}
+		}
+		r.loadedAt = time.Now()
+		return nil
+	}
+
+	keys, err := r.store.List(ctx, deployersKey)
+	if err != nil {
+		return fmt.Errorf("failed to load deployers: %v", err)
+	}
+	granted := map[string]Deployer{}
+	for _, key := range keys {
+		if key != deployersKey {
+			continue
+		}
+		content, err := r.store.Get(ctx, deployersKey)
+		if err != nil {
+			return fmt.Errorf("failed to load deployers: %v", err)
+		}
+		var deployers []Deployer
+		if err := json.Unmarshal(content, &deployers); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", deployersKey, err)
+		}
+		for _, deployer := range deployers {
+			granted[strings.ToLower(deployer.Login)] = deployer
+		}
+	}
+
+	r.granted, r.loadedAt = granted, time.Now()
+	return nil
+}
+
+func (r *DeployerRoster) saveLocked(ctx context.Context) error {
+	if r.store == nil {
+		return fmt.Errorf("granting deployers needs artifact storage (ARTIFACT_BACKEND)")
+	}
+	content, err := json.MarshalIndent(r.sortedLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := r.store.Save(ctx, deployersKey, content); err != nil {
+		return fmt.Errorf("failed to save deployers: %v", err)
+	}
+	return nil
+}
+
+func (r *DeployerRoster) sortedLocked() []Deployer {
+	deployers := make([]Deployer, 0, len(r.granted))
+	for _, deployer := range r.granted {
+		deployers = append(deployers, deployer)
+	}
+	sort.Slice(deployers, func(i, j int) bool {
+		return strings.ToLower(deployers[i].Login) < strings.ToLower(deployers[j].Login)
+	})
+	return deployers
+}
+
+// HandleGrant is the /grant @user deployer ops command. The login is checked
+// against GitHub so typos don't end up on the roster.
+func (r *DeployerRoster) HandleGrant(ctx context.Context, github *GitHubClient, cmd *types.Command) *types.CommandResponse {
+	login, err := github.ResolveUser(ctx, cmd.Args["login"])
+	if err == nil {
+		_, err = r.Grant(ctx, login, cmd.User)
+	}
+	if err != nil {
+		return failedResponse("Grant failed", "Grant Failed", err)
+	}
+
+	return resultResponse(true, "Deployer granted", &types.Result{
+		Status:  types.StatusSuccess,
+		Icon:    "🔑",
+		Title:   "Deployer Granted",
+		Summary: fmt.Sprintf("@%s can now run deployment commands.", login),
+		Footer:  fmt.Sprintf("*Granted by: @%s. Undo with `/revoke @%s`.*", cmd.User, login),
+	}, map[string]interface{}{
+		"login": login,
+		"role":  cmd.Args["role"],
+	})
+}
+
+// HandleRevoke is the /revoke @user ops command
+func (r *DeployerRoster) HandleRevoke(ctx context.Context, cmd *types.Command) *types.CommandResponse {
+	deployer, err := r.Revoke(ctx, cmd.Args["login"])
+	if err != nil {
+		return failedResponse("Revoke failed", "Revoke Failed", err)
+	}
+
+	return resultResponse(true, "Deployer revoked", &types.Result{
+		Status:  types.StatusSuccess,
+		Icon:    "🔒",
+		Title:   "Deployer Revoked",
+		Summary: fmt.Sprintf("@%s can no longer run deployment commands.", deployer.Login),
+		Footer:  fmt.Sprintf("*Revoked by: @%s*", cmd.User),
+	}, map[string]interface{}{
+		"login": deployer.Login,
+	})
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Tenant prefixes replace "preview" in namespace names, so they must keep
+// names valid DNS labels with room for the PR and service
+var validNamespacePrefix = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,18}[a-z0-9])?$`)
+
+// RepoQuota caps the total requests of every preview of one repository. A
+// nil quantity is unlimited.
+type RepoQuota struct {
+	CPU    *resource.Quantity
+	Memory *resource.Quantity
+}
+
+// TenantLimits contains what one repository's previews can reach: their
+// namespaces carry a per-tenant prefix that RBAC and network rules can key
+// on, and their requests are capped together rather than per namespace.
+// Tenants are repositories (owner/repo) or whole owners; an exact repository
+// entry wins.
+type TenantLimits struct {
+	prefixes map[string]string
+	quota    RepoQuota
+	quotas   map[string]RepoQuota
+}
+
+// NewTenantLimits validates TENANT_NAMESPACE_PREFIXES (tenant=prefix),
+// REPO_QUOTA_CPU, REPO_QUOTA_MEMORY and REPO_QUOTAS (tenant=cpu/memory, either
+// side may be empty)
+func NewTenantLimits(prefixes []string, quotaCPU, quotaMemory string, quotas []string) (*TenantLimits, error) {
+	limits := &TenantLimits{
+		prefixes: make(map[string]string),
+		quotas:   make(map[string]RepoQuota),
+	}
+
+	for _, entry := range prefixes {
+		tenant, prefix, ok := strings.Cut(entry, "=")
+		tenant, prefix = strings.ToLower(strings.TrimSpace(tenant)), strings.TrimSpace(prefix)
+		if !ok || tenant == "" {
+			return nil, fmt.Errorf("namespace prefix %q must be tenant=prefix", entry)
+		}
+		if !validNamespacePrefix.MatchString(prefix) {
+			return nil, fmt.Errorf("namespace prefix %q for %s must be a lowercase DNS label of at most 20 characters", prefix, tenant)
+		}
+		limits.prefixes[tenant] = prefix
+	}
+
+	quota, err := parseRepoQuota(quotaCPU, quotaMemory)
+	if err != nil {
+		return nil, err
+	}
+	limits.quota = quota
+
+	for _, entry := range quotas {
+		tenant, value, ok := strings.Cut(entry, "=")
+		tenant = strings.ToLower(strings.TrimSpace(tenant))
+		cpu, memory, hasMemory := strings.Cut(value, "/")
+		if !ok || tenant == "" || !hasMemory {
+			return nil, fmt.Errorf("repo quota %q must be tenant=cpu/memory", entry)
+		}
+		quota, err := parseRepoQuota(strings.TrimSpace(cpu), strings.TrimSpace(memory))
+		if err != nil {
+			return nil, fmt.Errorf("repo quota for %s: %v", tenant, err)
+		}
+		limits.quotas[tenant] = quota
+	}
+	return limits, nil
+}
+
+func parseRepoQuota(cpu, memory string) (RepoQuota, error) {
+	var quota RepoQuota
+	if cpu != "" {
+		quantity, err := resource.ParseQuantity(cpu)
+		if err != nil {
+			return quota, fmt.Errorf("invalid repo CPU quota %q: %v", cpu, err)
+		}
+		quota.CPU = &quantity
+	}
+	if memory != "" {
+		quantity, err := resource.ParseQuantity(memory)
+		if err != nil {
+			return quota, fmt.Errorf("invalid repo memory quota %q: %v", memory, err)
+		}
+		quota.Memory = &quantity
+	}
+	return quota, nil
+}
+
+// tenantKeys are the entries that can apply to repo, most specific first
+func tenantKeys(repo string) []string {
+	repo = strings.ToLower(repo)
+	owner, _, _ := strings.Cut(repo, "/")
+	return []string{repo, owner}
+}
+
+// Namespace is the tenant's name for a preview namespace, or name itself
+// when the repo has no prefix
+func (tl *TenantLimits) Namespace(repo, name string) string {
+	if tl == nil || repo == "" {
+		return name
+	}
+	for _, key := range tenantKeys(repo) {
+		if prefix, ok := tl.prefixes[key]; ok {
+			return prefix + strings.TrimPrefix(name, "preview")
+		}
+	}
+	return name
+}
+
+// Quota is the cap on the repo's previews together
+func (tl *TenantLimits) Quota(repo string) RepoQuota {
+	if tl == nil || repo == "" {
+		return RepoQuota{}
+	}
+	for _, key := range tenantKeys(repo) {
+		if quota, ok := tl.quotas[key]; ok {
+			return quota
+		}
+	}
+	return tl.quota
+}
+
+// Unlimited reports whether the quota caps nothing
+func (rq RepoQuota) Unlimited() bool {
+	return rq.CPU == nil && rq.Memory == nil
+}
+
+// ReplacedWorkloads are the workloads of a namespace a deploy replaces,
+// which stop counting against the repo's quota once the new ones do
+type ReplacedWorkloads struct {
+	Namespace string
+	Names     map[string]bool // Deployments and StatefulSets; nil for all of them
+}
+
+// replacedBy is what applying parsed to namespace replaces: the workloads
+// of the same names
+func replacedBy(namespace string, parsed *ParsedManifest) *ReplacedWorkloads {
+	names := map[string]bool{}
+	if parsed != nil {
+		for _, dep := range parsed.Deployments {
+			names[dep.Name] = true
+		}
+		for _, sts := range parsed.StatefulSets {
+			names[sts.Name] = true
+		}
+	}
+	return &ReplacedWorkloads{Namespace: namespace, Names: names}
+}
+
+func (r *ReplacedWorkloads) replaces(namespace, name string) bool {
+	return r != nil && r.Namespace == namespace && (r.Names == nil || r.Names[name])
+}
+
+// RepoPreviewRequests sums what the repo's running previews and their shared
+// dependencies ask for, from their Deployments and StatefulSets rather than
+// their pods so a rollout in progress counts in full, leaving out the
+// replaced ones. It returns the namespaces counted.
+func (k *K8sService) RepoPreviewRequests(ctx context.Context, repo string, defaults corev1.ResourceList, replaced *ReplacedWorkloads) (ResourceTotals, []string, error) {
+	var totals ResourceTotals
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: "preview=true",
+	})
+	if err != nil {
+		return totals, nil, fmt.Errorf("failed to list preview namespaces: %v", err)
+	}
+	dependencies, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: dependencyLabel,
+	})
+	if err != nil {
+		return totals, nil, fmt.Errorf("failed to list shared dependencies: %v", err)
+	}
+
+	var counted []string
+	for _, ns := range append(namespaces.Items, dependencies.Items...) {
+		if !strings.EqualFold(ns.Annotations[previewRepoAnnotation], repo) || ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		deployments, err := k.client.AppsV1().Deployments(ns.Name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return totals, nil, fmt.Errorf("failed to list deployments in %s: %v", ns.Name, err)
+		}
+		statefulSets, err := k.client.AppsV1().StatefulSets(ns.Name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return totals, nil, fmt.Errorf("failed to list statefulsets in %s: %v", ns.Name, err)
+		}
+		running := &ParsedManifest{}
+		for _, dep := range deployments.Items {
+			if !replaced.replaces(ns.Name, dep.Name) {
+				running.Deployments = append(running.Deployments, dep)
+			}
+		}
+		for _, sts := range statefulSets.Items {
+			if !replaced.replaces(ns.Name, sts.Name) {
+				running.StatefulSets = append(running.StatefulSets, sts)
+			}
+		}
+		requests, _ := manifestRequests(running, defaults)
+		totals.Add(requests)
+		counted = append(counted, ns.Name)
+	}
+	sort.Strings(counted)
+	return totals, counted, nil
+}
+
+// RepoQuotaError is a deploy the repository's quota has no room for
+type RepoQuotaError struct {
+	Repo       string
+	Quota      RepoQuota
+	InUse      ResourceTotals
+	Requested  ResourceTotals
+	Namespaces []string
+}
+
+func (e *RepoQuotaError) Error() string {
+	return fmt.Sprintf("%s is over its preview quota", e.Repo)
+}
+
+// repoQuotaReservations holds what deploys that passed the quota check ask
+// for until their workloads exist, so two deploys of a repo this replica
+// checks at once can't both take the room left
+type repoQuotaReservations struct {
+	mu       sync.Mutex
+	locks    map[string]*sync.Mutex
+	reserved map[string]ResourceTotals
+}
+
+var sharedRepoQuotaReservations = &repoQuotaReservations{locks: map[string]*sync.Mutex{}, reserved: map[string]ResourceTotals{}}
+
+// lock serializes the quota checks of one repo
+func (r *repoQuotaReservations) lock(repo string) *sync.Mutex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lock, ok := r.locks[repo]
+	if !ok {
+		lock = &sync.Mutex{}
+		r.locks[repo] = lock
+	}
+	return lock
+}
+
+func (r *repoQuotaReservations) get(repo string) ResourceTotals {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reserved[repo]
+}
+
+// add reserves requested for repo and returns the release
+func (r *repoQuotaReservations) add(repo string, requested ResourceTotals) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := r.reserved[repo]
+	total.Add(requested)
+	r.reserved[repo] = total
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			total := r.reserved[repo]
+			total.Sub(requested)
+			r.reserved[repo] = total
+		})
+	}
+}
+
+// checkRepoQuota refuses requests the repo's previews have no room left
+// for. The workloads the deploy replaces don't count; a blue/green swap
+// replaces nothing until it completes, so it passes nil. On success the
+// requests stay reserved until release is called, once the deploy's
+// workloads exist or it failed.
+func (cs *CommandServiceK8s) checkRepoQuota(ctx context.Context, repo string, requested ResourceTotals, replaced *ReplacedWorkloads) (func(), error) {
+	quota := cs.tenants.Quota(repo)
+	if quota.Unlimited() {
+		return func() {}, nil
+	}
+
+	key := strings.ToLower(repo)
+	lock := sharedRepoQuotaReservations.lock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	inUse, namespaces, err := cs.k8s.RepoPreviewRequests(ctx, repo, cs.requestDefaults(), replaced)
+	if err != nil {
+		return nil, err
+	}
+	inUse.Add(sharedRepoQuotaReservations.get(key))
+	after := inUse
+	after.Add(requested)
+	if (quota.CPU != nil && after.CPU.Cmp(*quota.CPU) > 0) || (quota.Memory != nil && after.Memory.Cmp(*quota.Memory) > 0) {
+		return nil, &RepoQuotaError{Repo: repo, Quota: quota, InUse: inUse, Requested: requested, Namespaces: namespaces}
+	}
+	return sharedRepoQuotaReservations.add(key, requested), nil
+}
+
+// formatRepoQuotaError explains a rejected deploy and how to make room
+func formatRepoQuotaError(serviceName string, quotaErr *RepoQuotaError) string {
+	limit := func(quantity *resource.Quantity) string {
+		if quantity == nil {
+			return "unlimited"
+		}
+		return quantity.String()
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("## 🚫 Preview Over Repository Quota\n\n**Service:** `%s`\n**Repository:** `%s`\n\n", serviceName, quotaErr.Repo))
+	content.WriteString("| | CPU | Memory |\n|---|-----|--------|\n")
+	content.WriteString(fmt.Sprintf("| In use by %d preview(s) | %s | %s |\n", len(quotaErr.Namespaces), quotaErr.InUse.CPU.String(), quotaErr.InUse.Memory.String()))
+	content.WriteString(fmt.Sprintf("| This deploy | %s | %s |\n", quotaErr.Requested.CPU.String(), quotaErr.Requested.Memory.String()))
+	content.WriteString(fmt.Sprintf("| **Quota** | **%s** | **%s** |\n", limit(quotaErr.Quota.CPU), limit(quotaErr.Quota.Memory)))
+	if len(quotaErr.Namespaces) > 0 {
+		content.WriteString("\n**Previews counted:** ")
+		for i, namespace := range quotaErr.Namespaces {
+			if i > 0 {
+				content.WriteString(", ")
+			}
+			content.WriteString("`" + namespace + "`")
+		}
+		content.WriteString("\n")
+	}
+	content.WriteString("\n*Run `/cleanup` on previews you no longer need, or use a smaller `class`, then try again.*")
+	return content.String()
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TerraformDeployer runs a repo's Terraform/OpenTofu preview module in a
+// per-PR workspace
+type TerraformDeployer struct {
+	binary    string // terraform or tofu
+	moduleDir string // relative to the repo root
+	domain    string
+}
+
+func NewTerraformDeployer(binary, moduleDir, domain string) *TerraformDeployer {
+	return &TerraformDeployer{
+		binary:    binary,
+		moduleDir: moduleDir,
+		domain:    domain,
+	}
+}
+
+// Detect reports whether the repo ships a preview module
+func (td *TerraformDeployer) Detect(repoPath string) bool {
+	files, err := filepath.Glob(filepath.Join(repoPath, td.moduleDir, "*.tf"))
+	return err == nil && len(files) > 0
+}
+
+// Plan returns the human-readable plan for the PR's workspace
+func (td *TerraformDeployer) Plan(ctx context.Context, repoPath string, prNumber int) (string, error) {
+	if err := td.prepare(ctx, repoPath, prNumber); err != nil {
+		return "", err
+	}
+
+	args := append([]string{"plan", "-input=false", "-no-color"}, td.vars(prNumber)...)
+	return td.run(ctx, repoPath, args...)
+}
+
+// Apply provisions the PR's infrastructure and returns its outputs
+func (td *TerraformDeployer) Apply(ctx context.Context, repoPath string, prNumber int) (map[string]string, error) {
+	if err := td.prepare(ctx, repoPath, prNumber); err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"apply", "-input=false", "-no-color", "-auto-approve"}, td.vars(prNumber)...)
+	if _, err := td.run(ctx, repoPath, args...); err != nil {
+		return nil, err
+	}
+
+	raw, err := td.run(ctx, repoPath, "output", "-json")
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs map[string]struct {
+		Value     interface{} `json:"value"`
+		Sensitive bool        `json:"sensitive"`
+	}
+	if err := json.Unmarshal([]byte(raw), &outputs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s outputs: %v", td.binary, err)
+	}
+
+	result := make(map[string]string)
+	for name, output := range outputs {
+		if value, ok := output.Value.(string); ok {
+			result[name] = value
+		} else {
+			encoded, _ := json.Marshal(output.Value)
+			result[name] = string(encoded)
+		}
+	}
+
+	return result, nil
+}
+
+// Destroy tears down the PR's infrastructure and deletes its workspace
+func (td *TerraformDeployer) Destroy(ctx context.Context, repoPath string, prNumber int) error {
+	if err := td.prepare(ctx, repoPath, prNumber); err != nil {
+		return err
+	}
+
+	args := append([]string{"destroy", "-input=false", "-no-color", "-auto-approve"}, td.vars(prNumber)...)
+	if _, err := td.run(ctx, repoPath, args...); err != nil {
+		return err
+	}
+
+	// Workspaces can't be deleted while selected
+	if _, err := td.run(ctx, repoPath, "workspace", "select", "default"); err != nil {
+		return err
+	}
+	_, err := td.run(ctx, repoPath, "workspace", "delete", td.workspace(prNumber))
+	return err
+}
+
+func (td *TerraformDeployer) prepare(ctx context.Context, repoPath string, prNumber int) error {
+	if _, err := td.run(ctx, repoPath, "init", "-input=false", "-no-color"); err != nil {
+		return err
+	}
+	_, err := td.run(ctx, repoPath, "workspace", "select", "-or-create", td.workspace(prNumber))
+	return err
+}
+
+func (td *TerraformDeployer) workspace(prNumber int) string {
+	return fmt.Sprintf("pr-%d", prNumber)
+}
+
+func (td *TerraformDeployer) vars(prNumber int) []string {
+	return []string{
+		"-var", fmt.Sprintf("pr_number=%d", prNumber),
+		"-var", fmt.Sprintf("domain=pr-%d.%s", prNumber, td.domain),
+	}
+}
+
+func (td *TerraformDeployer) run(ctx context.Context, repoPath string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, td.binary, args...)
+	cmd.Dir = filepath.Join(repoPath, td.moduleDir)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %v: %s", td.binary, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// Timeline entry kinds
+const (
+	TimelineComment = "comment" // a comment the bot posted or edited
+	TimelineCommand = "command" // a command the bot ran
+	TimelineLog     = "log"     // a significant warning from background work
+)
+
+// TimelineEntry is one thing the bot did or said on a PR
+type TimelineEntry struct {
+	Time     time.Time              `json:"time"`
+	Kind     string                 `json:"kind"`
+	Repo     string                 `json:"repo,omitempty"`
+	PRNumber int                    `json:"pr_number"`
+	User     string                 `json:"user,omitempty"`
+	Message  string                 `json:"message"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// PreviewTimeline keeps each PR's bot comments, commands and significant
+// logs in the artifact store so postmortems can reconstruct what happened.
+// Every entry is its own object under the repo and PR, so replicas append
+// without rewriting each other's entries. Entries older than the retention,
+// or beyond the newest maxEntries, are dropped on every write.
+type PreviewTimeline struct {
+	store      ArtifactStore
+	retention  time.Duration
+	maxEntries int
+
+	mu     sync.Mutex
+	cached map[string]TimelineEntry // entries never change once written
+}
+
+// NewPreviewTimeline returns nil, which records nothing, when there is no
+// store or the retention is 0
+func NewPreviewTimeline(store ArtifactStore, retention time.Duration, maxEntries int) *PreviewTimeline {
+	if store == nil || retention <= 0 {
+		return nil
+	}
+	return &PreviewTimeline{store: store, retention: retention, maxEntries: maxEntries, cached: map[string]TimelineEntry{}}
+}
+
+// timelinePrefix is where a repo's PR keeps its entries; without a repo
+// it's the prefix of every repo's
+func timelinePrefix(repo string, prNumber int) string {
+	if repo == "" {
+		return "timeline/"
+	}
+	return fmt.Sprintf("timeline/%s/pr-%d/", strings.ToLower(repo), prNumber)
+}
+
+// timelineEntryKey names an entry by its time, so keys sort oldest first,
+// plus a random suffix for entries recorded at once
+func timelineEntryKey(entry TimelineEntry) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	repo := entry.Repo
+	if repo == "" {
+		repo = "_/_"
+	}
+	return fmt.Sprintf("%s%020d-%s.json", timelinePrefix(repo, entry.PRNumber), entry.Time.UnixNano(), hex.EncodeToString(suffix)), nil
+}
+
+// timelineKeyTime reads the time back out of an entry's key
+func timelineKeyTime(key string) (time.Time, bool) {
+	stamp, _, found := strings.Cut(path.Base(key), "-")
+	if !found {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Record appends an entry; failures are logged, never returned, so the
+// timeline can't break what it records
+func (t *PreviewTimeline) Record(ctx context.Context, entry TimelineEntry) {
+	if t == nil || entry.PRNumber <= 0 {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	key, err := timelineEntryKey(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to name timeline entry: %v\n", err)
+		return
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Warning: failed to encode timeline entry: %v\n", err)
+		return
+	}
+	if err := t.store.Save(ctx, key, content); err != nil {
+		fmt.Printf("Warning: failed to write timeline for PR #%d: %v\n", entry.PRNumber, err)
+		return
+	}
+	t.prune(ctx, path.Dir(key)+"/")
+}
+
+// prune deletes the entries under prefix that fall outside the retention
+// window or the entry cap, going by the times in their keys
+func (t *PreviewTimeline) prune(ctx context.Context, prefix string) {
+	keys, err := t.store.List(ctx, prefix)
+	if err != nil {
+		fmt.Printf("Warning: failed to list timeline %s: %v\n", prefix, err)
+		return
+	}
+	sort.Strings(keys)
+	cutoff := time.Now().Add(-t.retention)
+	for i, key := range keys {
+		stamp, ok := timelineKeyTime(key)
+		if ok && stamp.After(cutoff) && (t.maxEntries <= 0 || len(keys)-i <= t.maxEntries) {
+			continue
+		}
+		if err := t.store.Delete(ctx, key); err != nil {
+			fmt.Printf("Warning: failed to drop timeline entry %s: %v\n", key, err)
+		}
+	}
+}
+
+// Entries returns the PR's retained timeline, oldest first, optionally
+// limited to one repository
+func (t *PreviewTimeline) Entries(ctx context.Context, repo string, prNumber int) ([]TimelineEntry, error) {
+	if t == nil {
+		return nil, fmt.Errorf("timeline retention is disabled")
+	}
+
+	entries, err := t.read(ctx, timelinePrefix(repo, prNumber))
+	if err != nil {
+		return nil, err
+	}
+	filtered := []TimelineEntry{}
+	for _, entry := range entries {
+		if entry.PRNumber == prNumber && (repo == "" || strings.EqualFold(entry.Repo, repo)) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return t.retain(filtered), nil
+}
+
+// read loads the entries under prefix, oldest first, getting only the ones
+// this process hasn't read before
+func (t *PreviewTimeline) read(ctx context.Context, prefix string) ([]TimelineEntry, error) {
+	keys, err := t.store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(keys, func(i, j int) bool { return path.Base(keys[i]) < path.Base(keys[j]) })
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	listed := make(map[string]bool, len(keys))
+	entries := []TimelineEntry{}
+	for _, key := range keys {
+		listed[key] = true
+		entry, ok := t.cached[key]
+		if !ok {
+			content, err := t.store.Get(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(content, &entry); err != nil {
+				continue
+			}
+			t.cached[key] = entry
+		}
+		entries = append(entries, entry)
+	}
+	// Forget entries pruned since they were read
+	for key := range t.cached {
+		if strings.HasPrefix(key, prefix) && !listed[key] {
+			delete(t.cached, key)
+		}
+	}
+	return entries, nil
+}
+
+// retain applies the retention window and entry cap
+func (t *PreviewTimeline) retain(entries []TimelineEntry) []TimelineEntry {
+	cutoff := time.Now().Add(-t.retention)
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Time.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	if t.maxEntries > 0 && len(kept) > t.maxEntries {
+		kept = kept[len(kept)-t.maxEntries:]
+	}
+	return kept
+}
+
+// logTimeline records a significant warning from background work
+func (cs *CommandServiceK8s) logTimeline(repo string, prNumber int, format string, args ...interface{}) {
+	cs.timeline.Record(context.Background(), TimelineEntry{
+		Kind:     TimelineLog,
+		Repo:     repo,
+		PRNumber: prNumber,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// RecordCommand adds a command and its outcome to the PR timeline
+func (cs *CommandServiceK8s) RecordCommand(ctx context.Context, cmd *types.Command, commentBody string, response *types.CommandResponse) {
+	cs.timeline.Record(ctx, TimelineEntry{
+		Kind:     TimelineCommand,
+		Repo:     cmd.Repo,
+		PRNumber: cmd.PRNumber,
+		User:     cmd.User,
+		Message:  strings.TrimSpace(commentBody),
+		Details: map[string]interface{}{
+			"type":    cmd.Type,
+			"service": cmd.Service,
+			"success": response.Success,
+			"result":  response.Message,
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultClient talks to the Vault HTTP API for dynamic preview credentials
+type VaultClient struct {
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+// VaultLease is a dynamic secret returned by Vault
+type VaultLease struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+}
+
+func NewVaultClient(address, token string) *VaultClient {
+	return &VaultClient{
+		address:    strings.TrimSuffix(address, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Configured reports whether a Vault address and token are set
+func (vc *VaultClient) Configured() bool {
+	return vc.address != "" && vc.token != ""
+}
+
+// Read fetches a (possibly dynamic) secret, e.g. database/creds/preview
+func (vc *VaultClient) Read(ctx context.Context, path string) (*VaultLease, error) {
+	var lease VaultLease
+	err := vc.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, &lease)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault path %s: %v", path, err)
+	}
+	return &lease, nil
+}
+
+// RenewLease extends a lease by the given increment
+func (vc *VaultClient) RenewLease(ctx context.Context, leaseID string, increment time.Duration) error {
+	body := map[string]interface{}{
+		"lease_id":  leaseID,
+		"increment": int(increment.Seconds()),
+	}
+	if err := vc.do(ctx, http.MethodPut, "/v1/sys/leases/renew", body, nil); err != nil {
+		return fmt.Errorf("failed to renew lease %s: %v", leaseID, err)
+	}
+	return nil
+}
+
+// RevokeLease revokes a lease so the credentials stop working
+func (vc *VaultClient) RevokeLease(ctx context.Context, leaseID string) error {
+	body := map[string]interface{}{
+		"lease_id": leaseID,
+	}
+	if err := vc.do(ctx, http.MethodPut, "/v1/sys/leases/revoke", body, nil); err != nil {
+		return fmt.Errorf("failed to revoke lease %s: %v", leaseID, err)
+	}
+	return nil
+}
+
+func (vc *VaultClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, vc.address+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", vc.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := vc.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Isolation modes for a PR's previews
+const (
+	IsolationNamespace = "namespace"
+	IsolationVCluster  = "vcluster"
+)
+
+const (
+	// previewVClusterName is the virtual cluster in a PR's host namespace
+	previewVClusterName = "preview"
+
+	// vclusterNamespace is where services deploy inside the virtual cluster
+	vclusterNamespace = "default"
+
+	isolationAnnotation = "pr-previews.io/isolation"
+)
+
+// VClusterProvisioner runs a virtual cluster per PR with the vcluster CLI,
+// for repos whose manifests carry CRDs or cluster-scoped resources that
+// can't share the real cluster. The virtual cluster lives in the PR's shared
+// namespace, so deleting that namespace removes it with everything inside.
+type VClusterProvisioner struct {
+	binary  string
+	version string // vcluster chart version, empty for the CLI's own
+	timeout time.Duration
+}
+
+// vclusterClients keeps the client of each running virtual cluster, keyed by
+// host namespace, so only a new or recreated one shells out to the CLI
+var vclusterClients = struct {
+	mu      sync.Mutex
+	clients map[string]vclusterClient
+}{clients: map[string]vclusterClient{}}
+
+type vclusterClient struct {
+	uid     types.UID // of the vcluster's StatefulSet
+	service *K8sService
+}
+
+func NewVClusterProvisioner(binary, version string, timeout time.Duration) *VClusterProvisioner {
+	return &VClusterProvisioner{
+		binary:  binary,
+		version: version,
+		timeout: timeout,
+	}
+}
+
+// Ensure starts the virtual cluster in namespace unless it's already there,
+// and returns a client for it
+func (vp *VClusterProvisioner) Ensure(ctx context.Context, host *K8sService, namespace string) (*K8sService, error) {
+	statefulSet, err := host.client.AppsV1().StatefulSets(namespace).Get(ctx, previewVClusterName, metav1.GetOptions{})
+	if err == nil {
+		vclusterClients.mu.Lock()
+		cached, ok := vclusterClients.clients[namespace]
+		vclusterClients.mu.Unlock()
+		if ok && cached.uid == statefulSet.UID {
+			return cached.service, nil
+		}
+	}
+	if apierrors.IsNotFound(err) {
+		args := []string{"create", previewVClusterName, "--namespace", namespace, "--connect=false"}
+		if vp.version != "" {
+			args = append(args, "--chart-version", vp.version)
+		}
+		if _, err := vp.run(ctx, args...); err != nil {
+			return nil, err
+		}
+		if err := host.AnnotateNamespace(ctx, namespace, nil, map[string]string{isolationAnnotation: IsolationVCluster}); err != nil {
+			return nil, err
+		}
+		statefulSet, err = host.client.AppsV1().StatefulSets(namespace).Get(ctx, previewVClusterName, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check virtual cluster in %s: %v", namespace, err)
+	}
+
+	// Reach the virtual API server through its Service rather than a
+	// port-forward, since the bot runs in the cluster
+	kubeconfig, err := vp.run(ctx, "connect", previewVClusterName, "--namespace", namespace, "--print",
+		"--server", fmt.Sprintf("https://%s.%s.svc:443", previewVClusterName, namespace))
+	if err != nil {
+		return nil, err
+	}
+	virtual, err := NewK8sServiceFromKubeconfig([]byte(kubeconfig))
+	if err != nil {
+		return nil, err
+	}
+
+	vclusterClients.mu.Lock()
+	vclusterClients.clients[namespace] = vclusterClient{uid: statefulSet.UID, service: virtual}
+	vclusterClients.mu.Unlock()
+	return virtual, nil
+}
+
+func (vp *VClusterProvisioner) run(ctx context.Context, args ...string) (string, error) {
+	if vp.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, vp.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, vp.binary, args...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s failed: %v: %s", vp.binary, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// NewK8sServiceFromKubeconfig builds a client for another cluster, such as
+// a preview's virtual cluster
+func NewK8sServiceFromKubeconfig(kubeconfig []byte) (*K8sService, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %v", err)
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8s client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create K8s dynamic client: %v", err)
+	}
+
+	return &K8sService{
+		client:     client,
+		dynamic:    dynamicClient,
+		restConfig: config,
+	}, nil
+}
+
+// vclusterHostName is what the virtual cluster's syncer names an object in
+// the host namespace, hashed like vcluster does when over 63 characters
+func vclusterHostName(name, namespace string) string {
+	full := strings.Join([]string{name, "x", namespace, "x", previewVClusterName}, "-")
+	if len(full) <= 63 {
+		return full
+	}
+	digest := sha256.Sum256([]byte(full))
+	return strings.ReplaceAll(full[:52]+"-"+hex.EncodeToString(digest[:])[:10], ".-", "-")
+}
+
+// MirrorIntoVCluster copies the ConfigMaps and Secrets injected into the
+// host namespace (Kind/name entries) into the virtual cluster, so the
+// preview's pods can mount them there. The copies carry no labels or
+// annotations: vcluster syncs objects back to the host namespace, where
+// the host's labels would make them look like the originals to cleanup.
+func (k *K8sService) MirrorIntoVCluster(ctx context.Context, virtual *K8sService, namespace string, resources []string) error {
+	for _, resource := range resources {
+		kind, name, _ := strings.Cut(resource, "/")
+		switch kind {
+		case "ConfigMap":
+			configMap, err := k.client.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to read configmap %s: %v", name, err)
+			}
+			copied := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Data:       configMap.Data,
+				BinaryData: configMap.BinaryData,
+			}
+			_, err = virtual.client.CoreV1().ConfigMaps(vclusterNamespace).Create(ctx, copied, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				_, err = virtual.client.CoreV1().ConfigMaps(vclusterNamespace).Update(ctx, copied, metav1.UpdateOptions{})
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy configmap %s into the virtual cluster: %v", name, err)
+			}
+		case "Secret":
+			secret, err := k.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to read secret %s: %v", name, err)
+			}
+			copied := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Type:       secret.Type,
+				Data:       secret.Data,
+			}
+			_, err = virtual.client.CoreV1().Secrets(vclusterNamespace).Create(ctx, copied, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				_, err = virtual.client.CoreV1().Secrets(vclusterNamespace).Update(ctx, copied, metav1.UpdateOptions{})
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy secret %s into the virtual cluster: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyObjects server-side applies manifest objects the typed deploy
+// doesn't handle, such as CRDs, their custom resources and cluster-scoped
+// RBAC. CRDs go first, and resources of a kind they add wait for it to be
+// served. Returns Kind/name of each object applied.
+func (k *K8sService) ApplyObjects(ctx context.Context, namespace string, objects []unstructured.Unstructured) ([]string, error) {
+	var crds, rest []unstructured.Unstructured
+	for _, obj := range objects {
+		if obj.GetKind() == "CustomResourceDefinition" {
+			crds = append(crds, obj)
+		} else {
+			rest = append(rest, obj)
+		}
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(k.client.Discovery()))
+	var applied []string
+	for i, obj := range append(crds, rest...) {
+		if i == len(crds) && len(crds) > 0 {
+			mapper.Reset()
+		}
+
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		for attempt := 0; meta.IsNoMatchError(err) && i >= len(crds) && len(crds) > 0 && attempt < 15; attempt++ {
+			select {
+			case <-ctx.Done():
+				return applied, ctx.Err()
+			case <-time.After(2 * time.Second):
+			}
+			mapper.Reset()
+			mapping, err = mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+		if err != nil {
+			return applied, fmt.Errorf("unknown resource type %s: %v", gvk.String(), err)
+		}
+
+		resource := k.dynamic.Resource(mapping.Resource)
+		var target dynamic.ResourceInterface = resource
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(namespace)
+			}
+			target = resource.Namespace(obj.GetNamespace())
+		}
+		// No Force: fields another manager owns are a conflict to report,
+		// not something to take over
+		if _, err := target.Apply(ctx, obj.GetName(), &obj, metav1.ApplyOptions{FieldManager: "pr-previews"}); err != nil {
+			return applied, fmt.Errorf("failed to apply %s/%s: %v", obj.GetKind(), obj.GetName(), err)
+		}
+		applied = append(applied, fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName()))
+	}
+	return applied, nil
+}
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// Warm pool namespaces are labelled with their state. Namespaces can't be
+// renamed, so a claimed one keeps its pool name and carries the name of the
+// preview it stands for in previewAliasLabel.
+const (
+	warmPoolLabel     = "pr-previews.io/pool"
+	warmPoolPending   = "pending" // created, baseline still being applied
+	warmPoolReady     = "ready"
+	warmPoolClaimed   = "claimed"
+	previewAliasLabel = "pr-previews.io/preview-namespace"
+
+	warmPoolPrefix = "preview-pool-"
+
+	// warmPoolPendingTimeout is how long preparing a pool namespace may take
+	// before it counts as failed and is deleted
+	warmPoolPendingTimeout = 10 * time.Minute
+)
+
+// WarmPoolStatus counts the pool namespaces by state
+type WarmPoolStatus struct {
+	Ready   []string `json:"ready"`
+	Pending []string `json:"pending"`
+}
+
+// CreateWarmNamespace prepares one empty pool namespace with the baseline
+// applied. It only becomes claimable once the baseline is in place.
+func (k *K8sService) CreateWarmNamespace(ctx context.Context) (string, error) {
+	name := warmPoolPrefix + utilrand.String(8)
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"created-by":  "pr-previews",
+				warmPoolLabel: warmPoolPending,
+			},
+			Annotations: map[string]string{
+				"pr-previews.io/created-at": time.Now().Format(time.RFC3339),
+			},
+		},
+	}
+	if _, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to create pool namespace %s: %v", name, err)
+	}
+
+	if err := k.applyNamespaceBaseline(ctx, name); err != nil {
+		k.client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+		return "", err
+	}
+	if err := k.AnnotateNamespace(ctx, name, map[string]string{warmPoolLabel: warmPoolReady}, nil); err != nil {
+		k.client.CoreV1().Namespaces().Delete(ctx, name, metav1.DeleteOptions{})
+		return "", err
+	}
+	return name, nil
+}
+
+// ClaimWarmNamespace turns the oldest ready pool namespace into the preview
+// namespace alias, labelled exactly like CreateNamespace would. It returns ""
+// when the pool is empty. Claims race through optimistic concurrency: the
+// loser of a conflicting update moves on to the next namespace.
+func (k *K8sService) ClaimWarmNamespace(ctx context.Context, alias string, prNumber int, service, owner string) (string, error) {
+	// Same rule as creating the namespace under its own name
+	if existing := k.ResolveNamespaceAlias(ctx, "", alias); existing != alias {
+		return "", fmt.Errorf("failed to create namespace %s: already claimed as %s", alias, existing)
+	}
+	if exists, err := k.NamespaceExists(ctx, alias); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("namespace already exists")
+		}
+		return "", fmt.Errorf("failed to create namespace %s: %v", alias, err)
+	}
+
+	pool, err := k.listWarmNamespaces(ctx, warmPoolReady)
+	if err != nil {
+		return "", err
+	}
+
+	for _, namespace := range pool {
+		labels, annotations := previewNamespaceMeta(prNumber, service, owner)
+		labels[warmPoolLabel] = warmPoolClaimed
+		labels[previewAliasLabel] = alias
+		for key, value := range labels {
+			namespace.Labels[key] = value
+		}
+		if namespace.Annotations == nil {
+			namespace.Annotations = make(map[string]string)
+		}
+		for key, value := range annotations {
+			namespace.Annotations[key] = value
+		}
+
+		_, err := k.client.CoreV1().Namespaces().Update(ctx, &namespace, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to claim pool namespace %s: %v", namespace.Name, err)
+		}
+		return namespace.Name, nil
+	}
+	return "", nil
+}
+
+// CreateAliasedNamespace creates a preview namespace under another name,
+// standing for alias the way a claimed pool namespace does
+func (k *K8sService) CreateAliasedNamespace(ctx context.Context, name, alias string, prNumber int, service, owner string) error {
+	// Same rule as creating the namespace under its own name
+	if existing := k.ResolveNamespaceAlias(ctx, "", alias); existing != alias {
+		return fmt.Errorf("failed to create namespace %s: already exists as %s", alias, existing)
+	}
+	if exists, err := k.NamespaceExists(ctx, alias); err != nil || exists {
+		if err == nil {
+			err = fmt.Errorf("namespace already exists")
+		}
+		return fmt.Errorf("failed to create namespace %s: %v", alias, err)
+	}
+
+	labels, annotations := previewNamespaceMeta(prNumber, service, owner)
+	labels[previewAliasLabel] = alias
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+	if _, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("failed to create namespace %s: %v", name, err)
+	}
+	return k.applyNamespaceBaseline(ctx, name)
+}
+
+// ResolveNamespaceAlias returns the claimed pool namespace standing for name
+// in the repo, or name itself when there is none. An empty repo matches any.
+func (k *K8sService) ResolveNamespaceAlias(ctx context.Context, repo, name string) string {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", previewAliasLabel, name),
+	})
+	if err != nil {
+		return name
+	}
+	for _, ns := range namespaces.Items {
+		if ns.Status.Phase != corev1.NamespaceTerminating && previewOfRepo(&ns, repo) {
+			return ns.Name
+		}
+	}
+	return name
+}
+
+// ReplenishWarmPool tops the pool up to size ready or pending namespaces,
+// deletes preparations that never finished and trims the pool when size
+// shrank. It returns the namespaces it created.
+func (k *K8sService) ReplenishWarmPool(ctx context.Context, size int) ([]string, error) {
+	pending, err := k.listWarmNamespaces(ctx, warmPoolPending)
+	if err != nil {
+		return nil, err
+	}
+	ready, err := k.listWarmNamespaces(ctx, warmPoolReady)
+	if err != nil {
+		return nil, err
+	}
+
+	available := len(ready)
+	for _, namespace := range pending {
+		if time.Since(namespace.CreationTimestamp.Time) > warmPoolPendingTimeout {
+			if err := k.DeleteNamespace(ctx, namespace.Name); err != nil {
+				fmt.Printf("Warning: failed to delete stale pool namespace: %v\n", err)
+			}
+			continue
+		}
+		available++
+	}
+
+	// Newest first, so the longest-prepared namespaces are kept
+	for i := len(ready) - 1; i >= 0 && available > size; i-- {
+		if err := k.DeleteNamespace(ctx, ready[i].Name); err != nil {
+			return nil, err
+		}
+		available--
+	}
+
+	var created []string
+	for ; available < size; available++ {
+		name, err := k.CreateWarmNamespace(ctx)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, name)
+	}
+	return created, nil
+}
+
+// GetWarmPoolStatus lists the pool namespaces by state
+func (k *K8sService) GetWarmPoolStatus(ctx context.Context) (*WarmPoolStatus, error) {
+	status := &WarmPoolStatus{Ready: []string{}, Pending: []string{}}
+	for state, names := range map[string]*[]string{warmPoolReady: &status.Ready, warmPoolPending: &status.Pending} {
+		namespaces, err := k.listWarmNamespaces(ctx, state)
+		if err != nil {
+			return nil, err
+		}
+		for _, namespace := range namespaces {
+			*names = append(*names, namespace.Name)
+		}
+	}
+	return status, nil
+}
+
+// listWarmNamespaces returns the live pool namespaces in a state, oldest
+// first
+func (k *K8sService) listWarmNamespaces(ctx context.Context, state string) ([]corev1.Namespace, error) {
+	namespaces, err := k.client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", warmPoolLabel, state),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s pool namespaces: %v", state, err)
+	}
+
+	var result []corev1.Namespace
+	for _, namespace := range namespaces.Items {
+		if namespace.Status.Phase != corev1.NamespaceTerminating {
+			result = append(result, namespace)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreationTimestamp.Equal(&result[j].CreationTimestamp) {
+			return result[i].CreationTimestamp.Before(&result[j].CreationTimestamp)
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// createPreviewNamespace creates a per-service preview namespace, claiming a
+// prepared one from the warm pool when there is one. A repo with a tenant
+// prefix gets a fresh namespace under its own name instead, since pool names
+// carry no prefix. It returns the name the preview actually lives in.
+func (cs *CommandServiceK8s) createPreviewNamespace(ctx context.Context, name, repo string, prNumber int, service, owner string) (string, bool, error) {
+	if tenantName := cs.tenants.Namespace(repo, name); tenantName != name {
+		return tenantName, false, cs.k8s.CreateAliasedNamespace(ctx, tenantName, name, prNumber, service, owner)
+	}
+	if cs.config.WarmPool.Size > 0 {
+		claimed, err := cs.k8s.ClaimWarmNamespace(ctx, name, prNumber, service, owner)
+		if err != nil {
+			return "", false, err
+		}
+		if claimed != "" {
+			// Put one back right away rather than waiting for the next round
+			go func() {
+				if _, err := cs.k8s.CreateWarmNamespace(context.Background()); err != nil {
+					fmt.Printf("Warning: failed to replace claimed pool namespace: %v\n", err)
+				}
+			}()
+			return claimed, true, nil
+		}
+	}
+	return name, false, cs.k8s.CreateNamespace(ctx, name, prNumber, service, owner)
+}
+
+// StartWarmPool keeps WARM_POOL_SIZE prepared namespaces ready until ctx is
+// cancelled
+func (cs *CommandServiceK8s) StartWarmPool(ctx context.Context) {
+	if cs.config.WarmPool.Size <= 0 || cs.config.WarmPool.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cs.config.WarmPool.Interval)
+	defer ticker.Stop()
+
+	for {
+		created, err := cs.k8s.ReplenishWarmPool(ctx, cs.config.WarmPool.Size)
+		if err != nil {
+			fmt.Printf("Warning: warm pool replenish failed: %v\n", err)
+		}
+		if len(created) > 0 {
+			fmt.Printf("♨️  Warm pool: prepared %d namespace(s)\n", len(created))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
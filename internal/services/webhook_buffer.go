@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// WebhookBuffer decouples webhook ingestion from processing with a bounded
+// queue drained by a fixed worker pool
+type WebhookBuffer struct {
+	jobs    chan func(ctx context.Context)
+	workers int
+
+	received  atomic.Int64
+	dropped   atomic.Int64
+	processed atomic.Int64
+	busy      atomic.Int64 // workers running a job right now
+}
+
+func NewWebhookBuffer(size, workers int) *WebhookBuffer {
+	if size < 1 {
+		size = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &WebhookBuffer{
+		jobs:    make(chan func(ctx context.Context), size),
+		workers: workers,
+	}
+}
+
+// Start launches the worker pool; workers exit when ctx is cancelled
+func (wb *WebhookBuffer) Start(ctx context.Context) {
+	for i := 0; i < wb.workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-wb.jobs:
+					wb.busy.Add(1)
+					job(ctx)
+					wb.busy.Add(-1)
+					wb.processed.Add(1)
+				}
+			}
+		}()
+	}
+}
+
+// Submit enqueues a job without blocking; false means the buffer is full
+// and the caller should shed the request
+func (wb *WebhookBuffer) Submit(job func(ctx context.Context)) bool {
+	wb.received.Add(1)
+
+	select {
+	case wb.jobs <- job:
+		return true
+	default:
+		wb.dropped.Add(1)
+		return false
+	}
+}
+
+// WebhookBufferStats are the buffer's depth and throughput counters
+type WebhookBufferStats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Workers       int   `json:"workers"`
+	BusyWorkers   int64 `json:"busy_workers"`
+	Received      int64 `json:"received"`
+	Dropped       int64 `json:"dropped"`
+	Processed     int64 `json:"processed"`
+}
+
+// Stats returns buffer depth and throughput counters
+func (wb *WebhookBuffer) Stats() WebhookBufferStats {
+	return WebhookBufferStats{
+		QueueDepth:    len(wb.jobs),
+		QueueCapacity: cap(wb.jobs),
+		Workers:       wb.workers,
+		BusyWorkers:   wb.busy.Load(),
+		Received:      wb.received.Load(),
+		Dropped:       wb.dropped.Load(),
+		Processed:     wb.processed.Load(),
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// payloadField is a field an event's handler reads, as a dotted path into
+// the delivery, and the JSON type it must have
+type payloadField struct {
+	Path string
+	Kind string // string, number, object or bool
+}
+
+// githubEventSchemas lists, per event the bot acts on, the fields it can't
+// do without. Events not listed are accepted as they come and ignored later.
+var githubEventSchemas = map[string][]payloadField{
+	"issue_comment": {
+		{"action", "string"},
+		{"repository.full_name", "string"},
+		{"issue.number", "number"},
+		{"comment.id", "number"},
+		{"comment.body", "string"},
+		{"comment.user.login", "string"},
+	},
+	"pull_request_review_comment": {
+		{"action", "string"},
+		{"repository.full_name", "string"},
+		{"pull_request.number", "number"},
+		{"comment.id", "number"},
+		{"comment.body", "string"},
+		{"comment.user.login", "string"},
+	},
+	"pull_request_review": {
+		{"action", "string"},
+		{"repository.full_name", "string"},
+		{"pull_request.number", "number"},
+		{"review.id", "number"},
+		{"review.user.login", "string"},
+	},
+	"pull_request": {
+		{"action", "string"},
+		{"repository.full_name", "string"},
+		{"pull_request.number", "number"},
+	},
+	"workflow_run": {
+		{"action", "string"},
+		{"repository.full_name", "string"},
+		{"workflow_run.id", "number"},
+		{"workflow_run.head_sha", "string"},
+	},
+}
+
+// PayloadError lists what a delivery is missing, or has with the wrong type
+type PayloadError struct {
+	Event    string
+	Problems []string
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("%s payload is malformed: %s", e.Event, strings.Join(e.Problems, "; "))
+}
+
+// ValidateGitHubPayload checks a decoded delivery against the fields its
+// event's handler reads, so a truncated or hand-crafted payload is turned
+// away with a description rather than acted on half-parsed
+func ValidateGitHubPayload(event string, payload map[string]interface{}) error {
+	fields, ok := githubEventSchemas[event]
+	if !ok {
+		return nil
+	}
+
+	var problems []string
+	for _, field := range fields {
+		value, found := payloadValue(payload, field.Path)
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("%s is required", field.Path))
+		case jsonKind(value) != field.Kind:
+			problems = append(problems, fmt.Sprintf("%s must be a %s, got %s", field.Path, field.Kind, jsonKind(value)))
+		}
+	}
+	// A review's body is null when it's submitted without one
+	if body, found := payloadValue(payload, "review.body"); found && body != nil && jsonKind(body) != "string" {
+		problems = append(problems, fmt.Sprintf("review.body must be a string, got %s", jsonKind(body)))
+	}
+
+	if len(problems) > 0 {
+		return &PayloadError{Event: event, Problems: problems}
+	}
+	return nil
+}
+
+// payloadValue walks a dotted path through nested objects
+func payloadValue(payload map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = payload
+	for _, key := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// jsonKind names the JSON type of a value decoded by encoding/json
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
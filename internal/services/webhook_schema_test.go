@@ -0,0 +1,78 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateGitHubPayload(t *testing.T) {
+	comment := `{"action": "created", "repository": {"full_name": "acme/app"}, "issue": {"number": 7}, "comment": {"id": 1, "body": "/preview", "user": {"login": "dev"}}}`
+	review := `{"action": "submitted", "repository": {"full_name": "acme/app"}, "pull_request": {"number": 7}, "review": {"id": 2, "body": %s, "user": {"login": "dev"}}}`
+
+	tests := []struct {
+		name     string
+		event    string
+		payload  string
+		problems []string
+	}{
+		{name: "valid comment", event: "issue_comment", payload: comment},
+		{name: "unlisted event", event: "push", payload: `{}`},
+		{name: "review without a body", event: "pull_request_review", payload: strings.Replace(review, "%s", "null", 1)},
+		{name: "review with a body", event: "pull_request_review", payload: strings.Replace(review, "%s", `"lgtm"`, 1)},
+		{
+			name:     "review body of the wrong type",
+			event:    "pull_request_review",
+			payload:  strings.Replace(review, "%s", "3", 1),
+			problems: []string{"review.body must be a string, got number"},
+		},
+		{
+			name:     "missing nested field",
+			event:    "issue_comment",
+			payload:  strings.Replace(comment, `"user": {"login": "dev"}`, `"user": {}`, 1),
+			problems: []string{"comment.user.login is required"},
+		},
+		{
+			name:     "parent of the wrong type",
+			event:    "issue_comment",
+			payload:  strings.Replace(comment, `"issue": {"number": 7}`, `"issue": "7"`, 1),
+			problems: []string{"issue.number is required"},
+		},
+		{
+			name:     "wrong types",
+			event:    "issue_comment",
+			payload:  strings.NewReplacer(`"number": 7`, `"number": "7"`, `"body": "/preview"`, `"body": null`).Replace(comment),
+			problems: []string{"issue.number must be a number, got string", "comment.body must be a string, got null"},
+		},
+		{
+			name:     "empty delivery",
+			event:    "workflow_run",
+			payload:  `{}`,
+			problems: []string{"action is required", "repository.full_name is required", "workflow_run.id is required", "workflow_run.head_sha is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			err := ValidateGitHubPayload(tt.event, payload)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("ValidateGitHubPayload() error = %v", err)
+				}
+				return
+			}
+			var payloadErr *PayloadError
+			if !errors.As(err, &payloadErr) {
+				t.Fatalf("ValidateGitHubPayload() error = %v, want a PayloadError", err)
+			}
+			if strings.Join(payloadErr.Problems, "; ") != strings.Join(tt.problems, "; ") {
+				t.Errorf("problems = %q, want %q", payloadErr.Problems, tt.problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pr-previews/internal/types"
+)
+
+// SimulationRequest describes a webhook delivery in the few terms a tester
+// cares about; BuildSimulatedDelivery fills in the rest of the payload
+type SimulationRequest struct {
+	Event     string `json:"event"`      // issue_comment (default), pull_request_review_comment, pull_request_review or pull_request
+	Action    string `json:"action"`     // created (default) or edited
+	Body      string `json:"body"`       // the comment or review, or the new PR description
+	Previous  string `json:"previous"`   // body before an edit
+	User      string `json:"user"`       // defaults to testuser
+	Repo      string `json:"repo"`       // defaults to octocat/hello-world
+	PRNumber  int    `json:"pr_number"`  // defaults to 123
+	Branch    string `json:"branch"`     // the PR's head branch, normally looked up on GitHub
+	Issue     bool   `json:"issue"`      // comment on an issue rather than a pull request
+	CommentID int64  `json:"comment_id"` // reuse to simulate editing an earlier comment
+	Signature string `json:"signature"`  // sent instead of a valid signature, to try rejection
+	Format    string `json:"format"`     // also render the reply as gitlab, slack or json
+}
+
+// SimulatedSignature is how the delivery fared against GITHUB_WEBHOOK_SECRET.
+// Simulations run regardless; Rejected says a real delivery would have
+// stopped there.
+type SimulatedSignature struct {
+	Header   string `json:"header,omitempty"`
+	Checked  bool   `json:"checked"`
+	Rejected bool   `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SimulatedComment is a comment the pipeline would have posted
+type SimulatedComment struct {
+	Repo     string `json:"repo"`
+	PRNumber int    `json:"pr_number"`
+	Body     string `json:"body"`
+}
+
+// Simulation is one recorded simulated delivery and what it caused
+type Simulation struct {
+	ID        int64                  `json:"id"`
+	Time      time.Time              `json:"time"`
+	Event     string                 `json:"event"`
+	Payload   json.RawMessage        `json:"payload"`
+	Signature SimulatedSignature     `json:"signature"`
+	Outcome   string                 `json:"outcome"`
+	Error     string                 `json:"error,omitempty"`
+	Command   *types.Command         `json:"command,omitempty"`
+	Response  *types.CommandResponse `json:"response,omitempty"`
+	Comments  []SimulatedComment     `json:"comments"`
+	Rendered  string                 `json:"rendered,omitempty"` // the reply in Request.Format
+	Duration  string                 `json:"duration"`
+	Request   SimulationRequest      `json:"request"`
+}
+
+var simulatedCommentIDs atomic.Int64
+
+// BuildSimulatedDelivery turns a request into the event name and JSON body
+// GitHub would send, shaped like the real deliveries the webhook reads
+func BuildSimulatedDelivery(request *SimulationRequest) (string, []byte, error) {
+	if request.Event == "" {
+		request.Event = "issue_comment"
+	}
+	if request.Action == "" {
+		request.Action = "created"
+	}
+	if request.User == "" {
+		request.User = "testuser"
+	}
+	if request.Repo == "" {
+		request.Repo = "octocat/hello-world"
+	}
+	if request.PRNumber == 0 {
+		request.PRNumber = 123
+	}
+	if request.Body == "" {
+		return "", nil, fmt.Errorf("body is required")
+	}
+	owner, name, ok := strings.Cut(request.Repo, "/")
+	if !ok || owner == "" || name == "" {
+		return "", nil, fmt.Errorf("repo must be owner/name")
+	}
+	if request.Format != "" {
+		if _, err := RendererFor(request.Format); err != nil {
+			return "", nil, err
+		}
+	}
+	if request.Action != "created" && request.Action != "edited" {
+		return "", nil, fmt.Errorf("unknown action %q (use created or edited)", request.Action)
+	}
+	action := request.Action
+	if request.Event == "pull_request_review" && action == "created" {
+		// Reviews are submitted rather than created
+		action = "submitted"
+	}
+
+	user := map[string]interface{}{"login": request.User, "type": "User"}
+	payload := map[string]interface{}{
+		"action": action,
+		"repository": map[string]interface{}{
+			"name":      name,
+			"full_name": request.Repo,
+			"owner":     map[string]interface{}{"login": owner},
+		},
+		"sender": user,
+	}
+	if request.Action == "edited" {
+		payload["changes"] = map[string]interface{}{
+			"body": map[string]interface{}{"from": request.Previous},
+		}
+	}
+
+	switch request.Event {
+	case "issue_comment":
+		if request.CommentID == 0 {
+			// Far above real comment IDs, so edit tracking never mixes them up
+			request.CommentID = 1<<52 + simulatedCommentIDs.Add(1)
+		}
+		issue := map[string]interface{}{
+			"number": request.PRNumber,
+			"title":  "Simulated pull request",
+			"user":   user,
+			"state":  "open",
+		}
+		if !request.Issue {
+			issue["pull_request"] = map[string]interface{}{
+				"url": fmt.Sprintf("https://api.github.com/repos/%s/pulls/%d", request.Repo, request.PRNumber),
+			}
+		}
+		payload["issue"] = issue
+		payload["comment"] = map[string]interface{}{
+			"id":   request.CommentID,
+			"body": request.Body,
+			"user": user,
+		}
+	case "pull_request_review_comment", "pull_request_review":
+		if request.CommentID == 0 {
+			request.CommentID = 1<<52 + simulatedCommentIDs.Add(1)
+		}
+		payload["pull_request"] = map[string]interface{}{
+			"number": request.PRNumber,
+			"title":  "Simulated pull request",
+			"user":   user,
+			"state":  "open",
+			"head":   map[string]interface{}{"ref": request.Branch},
+		}
+		key := "comment"
+		if request.Event == "pull_request_review" {
+			key = "review"
+		}
+		payload[key] = map[string]interface{}{
+			"id":   request.CommentID,
+			"body": request.Body,
+			"user": user,
+		}
+	case "pull_request":
+		if request.Action != "edited" {
+			return "", nil, fmt.Errorf("only edited pull_request deliveries can be simulated")
+		}
+		payload["number"] = request.PRNumber
+		payload["pull_request"] = map[string]interface{}{
+			"number": request.PRNumber,
+			"title":  "Simulated pull request",
+			"body":   request.Body,
+			"user":   user,
+			"state":  "open",
+			"head":   map[string]interface{}{"ref": request.Branch},
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown event %q (use issue_comment, pull_request_review_comment, pull_request_review or pull_request)", request.Event)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return request.Event, body, nil
+}
+
+// CommentRecorder collects the comments a simulated delivery would post,
+// standing in for GitHubClient
+type CommentRecorder struct {
+	mu       sync.Mutex
+	comments []SimulatedComment
+}
+
+func NewCommentRecorder() *CommentRecorder {
+	return &CommentRecorder{comments: []SimulatedComment{}}
+}
+
+func (r *CommentRecorder) PostComment(ctx context.Context, repo string, prNumber int, body string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.comments = append(r.comments, SimulatedComment{Repo: repo, PRNumber: prNumber, Body: body})
+	return nil
+}
+
+// Comments returns what has been posted so far
+func (r *CommentRecorder) Comments() []SimulatedComment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SimulatedComment{}, r.comments...)
+}
+
+// SimulationLog keeps the most recent simulations in memory
+type SimulationLog struct {
+	mu      sync.Mutex
+	max     int
+	nextID  int64
+	entries []*Simulation
+}
+
+func NewSimulationLog(max int) *SimulationLog {
+	if max <= 0 {
+		max = 50
+	}
+	return &SimulationLog{max: max}
+}
+
+// Add assigns the simulation its ID and drops the oldest beyond the limit
+func (l *SimulationLog) Add(simulation *Simulation) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.nextID++
+	simulation.ID = l.nextID
+	l.entries = append(l.entries, simulation)
+	if len(l.entries) > l.max {
+		l.entries = l.entries[len(l.entries)-l.max:]
+	}
+}
+
+// List returns the kept simulations, newest first
+func (l *SimulationLog) List() []*Simulation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]*Simulation, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		list = append(list, l.entries[i])
+	}
+	return list
+}
+
+// Get finds a kept simulation by ID
+func (l *SimulationLog) Get(id int64) (*Simulation, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, simulation := range l.entries {
+		if simulation.ID == id {
+			return simulation, true
+		}
+	}
+	return nil, false
+}
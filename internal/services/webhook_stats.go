@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook delivery outcomes
+const (
+	WebhookProcessed = "processed"
+	WebhookIgnored   = "ignored"
+	WebhookError     = "error"
+	WebhookRejected  = "rejected" // signature didn't match GITHUB_WEBHOOK_SECRET
+	WebhookInvalid   = "invalid"  // payload too large, not JSON, or missing fields the event needs
+)
+
+type webhookEventKey struct {
+	Repo    string
+	Event   string
+	Action  string
+	Outcome string
+}
+
+// WebhookEventStats counts GitHub deliveries by repository, event type,
+// action and outcome, to help track down ingestion gaps
+type WebhookEventStats struct {
+	mu       sync.Mutex
+	counts   map[webhookEventKey]int64
+	lastSeen map[string]time.Time // repo -> last delivery
+}
+
+func NewWebhookEventStats() *WebhookEventStats {
+	return &WebhookEventStats{
+		counts:   make(map[webhookEventKey]int64),
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// Record counts one delivery
+func (s *WebhookEventStats) Record(repo, event, action, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counts[webhookEventKey{Repo: repo, Event: event, Action: action, Outcome: outcome}]++
+	s.lastSeen[repo] = time.Now()
+}
+
+// Snapshot returns the counters, totals per outcome and when each repository
+// last delivered
+func (s *WebhookEventStats) Snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := map[string]int64{}
+	var events []map[string]interface{}
+	for _, key := range s.sortedKeysLocked() {
+		count := s.counts[key]
+		totals[key.Outcome] += count
+		events = append(events, map[string]interface{}{
+			"repo":    key.Repo,
+			"event":   key.Event,
+			"action":  key.Action,
+			"outcome": key.Outcome,
+			"count":   count,
+		})
+	}
+
+	lastSeen := make(map[string]string, len(s.lastSeen))
+	for repo, at := range s.lastSeen {
+		lastSeen[repo] = at.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"events":    events,
+		"totals":    totals,
+		"last_seen": lastSeen,
+	}
+}
+
+// WritePrometheus writes the counters in the Prometheus text format
+func (s *WebhookEventStats) WritePrometheus(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pr_previews_webhook_events_total GitHub webhook deliveries by repository, event, action and outcome.")
+	fmt.Fprintln(w, "# TYPE pr_previews_webhook_events_total counter")
+	for _, key := range s.sortedKeysLocked() {
+		fmt.Fprintf(w, "pr_previews_webhook_events_total{repo=\"%s\",event=\"%s\",action=\"%s\",outcome=\"%s\"} %d\n",
+			escapeLabelValue(key.Repo), escapeLabelValue(key.Event), escapeLabelValue(key.Action), escapeLabelValue(key.Outcome), s.counts[key])
+	}
+
+	fmt.Fprintln(w, "# HELP pr_previews_webhook_last_delivery_timestamp_seconds When each repository last delivered a webhook.")
+	fmt.Fprintln(w, "# TYPE pr_previews_webhook_last_delivery_timestamp_seconds gauge")
+	repos := make([]string, 0, len(s.lastSeen))
+	for repo := range s.lastSeen {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		fmt.Fprintf(w, "pr_previews_webhook_last_delivery_timestamp_seconds{repo=\"%s\"} %d\n", escapeLabelValue(repo), s.lastSeen[repo].Unix())
+	}
+}
+
+func (s *WebhookEventStats) sortedKeysLocked() []webhookEventKey {
+	keys := make([]webhookEventKey, 0, len(s.counts))
+	for key := range s.counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		if a.Action != b.Action {
+			return a.Action < b.Action
+		}
+		return a.Outcome < b.Outcome
+	})
+	return keys
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
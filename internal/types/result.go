@@ -0,0 +1,47 @@
+package types
+
+// Result statuses
+const (
+	StatusSuccess = "success"
+	StatusWarning = "warning"
+	StatusError   = "error"
+	StatusInfo    = "info"
+	StatusPending = "pending"
+)
+
+// Result is provider-neutral command output. Renderers turn it into GitHub
+// or GitLab markdown, Slack blocks or JSON, so a handler that returns one
+// serves every integration; handlers that don't return only markdown. Text may use **bold**, `code` and [links](url); renderers
+// translate them for their provider.
+type Result struct {
+	Status   string    `json:"status"`
+	Icon     string    `json:"icon,omitempty"` // emoji shown before the title
+	Title    string    `json:"title"`
+	Summary  string    `json:"summary,omitempty"`
+	Sections []Section `json:"sections,omitempty"`
+	Footer   string    `json:"footer,omitempty"`
+}
+
+// Section is a titled block of a Result; any combination of parts may be set
+// and they render in field, text, item, table order. Collapsed sections start
+// folded where the provider can fold them.
+type Section struct {
+	Title     string   `json:"title,omitempty"`
+	Fields    []Field  `json:"fields,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Items     []string `json:"items,omitempty"`
+	Table     *Table   `json:"table,omitempty"`
+	Collapsed bool     `json:"collapsed,omitempty"`
+}
+
+// Field is a labelled value such as "Namespace: preview-pr-1-web"
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Table is rows of cells under column headings
+type Table struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+}
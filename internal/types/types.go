@@ -11,10 +11,13 @@ type Response struct {
 }
 
 type Command struct {
-	Type     string `json:"type"`    // preview, plan, cleanup, status, help
-	Service  string `json:"service"` // specific service to deploy
-	User     string `json:"user"`    // GitHub username
-	PRNumber int    `json:"pr_number"`
+	Type     string            `json:"type"`    // preview, plan, cleanup, status, help, queue, loadtest
+	Service  string            `json:"service"` // specific service to deploy
+	User     string            `json:"user"`    // GitHub username
+	PRNumber int               `json:"pr_number"`
+	Repo     string            `json:"repo,omitempty"` // owner/name, set for webhook deliveries
+	Branch   string            `json:"branch,omitempty"`
+	Args     map[string]string `json:"args,omitempty"` // --key=value flags
 }
 
 // CommandResponse represents the result of command processing
@@ -22,5 +25,6 @@ type CommandResponse struct {
 	Success bool                   `json:"success"`
 	Message string                 `json:"message"`
 	Content string                 `json:"content,omitempty"` // Markdown content for GitHub
+	Result  *Result                `json:"result,omitempty"`  // structured form of Content, when the handler provides one
 	Data    map[string]interface{} `json:"data,omitempty"`
 }
@@ -0,0 +1,180 @@
+// Package client is a typed Go client for the pr-previews REST API, for CI
+// tooling that deploys previews, waits for them and reads their events and
+// logs without hand-rolled HTTP calls.
+//
+//	c := client.New("https://previews.example.com", client.WithToken(os.Getenv("PREVIEWS_TOKEN")), client.WithRepo("acme/shop"))
+//	if _, err := c.CreatePreview(ctx, client.CreatePreviewRequest{PRNumber: 42, Service: "api"}); err != nil {
+//		return err
+//	}
+//	result, err := c.WaitForPreview(ctx, 42, "api", 10*time.Minute)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls one pr-previews server. It's safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	repo       string
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates as an API key, service token, GitHub token or the
+// admin token, sent as a bearer token
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRepo sets the owner/name repository requests act on, which GitHub
+// tokens are authorized against. Without it the server's API_GITHUB_REPO
+// applies.
+func WithRepo(repo string) Option {
+	return func(c *Client) { c.repo = repo }
+}
+
+// WithHTTPClient replaces the HTTP client. Event and log streams run as long
+// as the server allows, so it should have no overall timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// New returns a client for the server at baseURL, e.g.
+// https://previews.example.com
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is a request the server answered with an error status
+type Error struct {
+	StatusCode int
+	Message    string
+	Detail     string // the underlying error, when the server reports one
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("pr-previews: %s (%d): %s", e.Message, e.StatusCode, e.Detail)
+	}
+	return fmt.Sprintf("pr-previews: %s (%d)", e.Message, e.StatusCode)
+}
+
+// envelope is the server's response wrapper
+type envelope struct {
+	Success   bool            `json:"success"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// newRequest builds a request for an /api/v1 path, adding the repository to
+// the query
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.repo != "" && query.Get("repo") == "" {
+		query.Set("repo", c.repo)
+	}
+	endpoint := c.baseURL + "/api/v1" + path
+	if encoded := query.Encode(); encoded != "" {
+		endpoint += "?" + encoded
+	}
+
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends a request and decodes the envelope's data into out. Statuses in
+// accept are returned with their data instead of as an Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}, accept ...int) (int, error) {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var answer envelope
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		if resp.StatusCode >= 400 {
+			return resp.StatusCode, &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return resp.StatusCode, fmt.Errorf("pr-previews: invalid response: %v", err)
+	}
+
+	accepted := resp.StatusCode < 400
+	for _, status := range accept {
+		accepted = accepted || resp.StatusCode == status
+	}
+	if !accepted {
+		return resp.StatusCode, &Error{StatusCode: resp.StatusCode, Message: answer.Message, Detail: answer.Error}
+	}
+	if out != nil && len(answer.Data) > 0 {
+		if err := json.Unmarshal(answer.Data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("pr-previews: invalid response data: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// stream opens a streaming GET, turning an error status into an Error
+func (c *Client) stream(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var answer envelope
+		if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil || answer.Message == "" {
+			answer.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: answer.Message, Detail: answer.Error}
+	}
+	return resp, nil
+}
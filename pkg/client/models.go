@@ -0,0 +1,90 @@
+package client
+
+import "time"
+
+// Preview is a running preview namespace
+type Preview struct {
+	Namespace string `json:"name"`
+	PRNumber  int    `json:"pr_number"`
+	Service   string `json:"service"` // comma-separated for a shared namespace
+	Owner     string `json:"owner,omitempty"`
+	Repo      string `json:"repo,omitempty"`
+	TTL       string `json:"ttl,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"` // RFC 3339
+	CreatedAt string `json:"created_at"`           // RFC 3339
+	Status    string `json:"status"`               // Active or Terminating
+}
+
+// ListOptions narrows ListPreviews down
+type ListOptions struct {
+	Repo     string // defaults to the client's repository
+	PRNumber int
+}
+
+// CreatePreviewRequest asks for a deploy like a /preview comment would
+type CreatePreviewRequest struct {
+	PRNumber int               `json:"pr_number"`
+	Service  string            `json:"service,omitempty"` // empty deploys the PR description's services, or nginx
+	Args     map[string]string `json:"args,omitempty"`    // /preview flags without the dashes, e.g. "ttl": "2d"
+}
+
+// Accepted is a deploy or cleanup the server took on. It runs in the
+// background and replies on the PR.
+type Accepted struct {
+	Repo     string `json:"repo"`
+	PRNumber int    `json:"pr_number"`
+	Service  string `json:"service,omitempty"`
+	Status   string `json:"status"`
+	Wait     string `json:"wait,omitempty"` // path that waits for the preview
+}
+
+// Where a preview got to while waiting on it
+const (
+	WaitReady   = "ready"
+	WaitFailed  = "failed"
+	WaitTimeout = "timeout"
+)
+
+// WaitResult is where a preview got to
+type WaitResult struct {
+	PRNumber  int    `json:"pr_number"`
+	Service   string `json:"service"`
+	Namespace string `json:"namespace,omitempty"`
+	State     string `json:"state"`
+	URL       string `json:"url,omitempty"`
+	Progress  string `json:"progress,omitempty"`
+	Reason    string `json:"reason,omitempty"` // why it failed, or what it was waiting on
+	Waited    string `json:"waited"`
+}
+
+// Ready reports whether the preview is up
+func (r *WaitResult) Ready() bool {
+	return r.State == WaitReady
+}
+
+// Event is an entry of a PR's timeline: a command, a bot comment or a
+// significant log
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Kind     string                 `json:"kind"`
+	Repo     string                 `json:"repo,omitempty"`
+	PRNumber int                    `json:"pr_number"`
+	User     string                 `json:"user,omitempty"`
+	Message  string                 `json:"message"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+}
+
+// LogOptions selects the logs StreamLogs reads
+type LogOptions struct {
+	Pod       string // every pod of the preview when empty
+	Container string // every container when empty
+	Follow    bool   // keep streaming new lines
+	TailLines int64  // lines of history per container; 0 reads it all
+}
+
+// LogLine is one line a preview container logged
+type LogLine struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Line      string `json:"line"`
+}
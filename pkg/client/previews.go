@@ -0,0 +1,183 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ListPreviews returns the running previews
+func (c *Client) ListPreviews(ctx context.Context, opts ListOptions) ([]Preview, error) {
+	query := url.Values{}
+	if opts.Repo != "" {
+		query.Set("repo", opts.Repo)
+	}
+	if opts.PRNumber > 0 {
+		query.Set("pr", strconv.Itoa(opts.PRNumber))
+	}
+
+	var data struct {
+		Previews []Preview `json:"previews"`
+	}
+	if _, err := c.do(ctx, http.MethodGet, "/previews", query, nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Previews, nil
+}
+
+// CreatePreview deploys a preview. It needs write access; the deploy runs
+// in the background, so follow up with WaitForPreview.
+func (c *Client) CreatePreview(ctx context.Context, request CreatePreviewRequest) (*Accepted, error) {
+	var accepted Accepted
+	if _, err := c.do(ctx, http.MethodPost, "/previews", nil, request, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// DeletePreview cleans up every preview of the PR. It needs write access;
+// the namespaces are deleted in the background.
+func (c *Client) DeletePreview(ctx context.Context, prNumber int) (*Accepted, error) {
+	var accepted Accepted
+	if _, err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/previews/%d", prNumber), nil, nil, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// WaitForPreview blocks until the service's preview on the PR is ready or
+// failed, or timeout passes, asking again whenever the server's own limit
+// on a wait runs out. A failed or timed-out preview is a result, not an
+// error; check Ready.
+func (c *Client) WaitForPreview(ctx context.Context, prNumber int, service string, timeout time.Duration) (*WaitResult, error) {
+	deadline := time.Now().Add(timeout)
+	path := fmt.Sprintf("/previews/%d/%s/wait", prNumber, service)
+	for {
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining < time.Second {
+			remaining = time.Second
+		}
+
+		var result WaitResult
+		query := url.Values{"timeout": {remaining.String()}}
+		if _, err := c.do(ctx, http.MethodGet, path, query, nil, &result, http.StatusUnprocessableEntity, http.StatusRequestTimeout); err != nil {
+			return nil, err
+		}
+		if result.State != WaitTimeout || !time.Now().Before(deadline) {
+			return &result, nil
+		}
+	}
+}
+
+// StreamEvents calls fn with the PR's timeline entries after since (all of
+// them when it's zero), then with new ones as they're recorded, until ctx
+// is cancelled or fn returns an error. Streams the server ends are resumed
+// from the last entry.
+func (c *Client) StreamEvents(ctx context.Context, prNumber int, since time.Time, fn func(Event) error) error {
+	for {
+		query := url.Values{}
+		if !since.IsZero() {
+			query.Set("since", since.Format(time.RFC3339Nano))
+		}
+		resp, err := c.stream(ctx, fmt.Sprintf("/previews/%d/events", prNumber), query)
+		if err != nil {
+			return err
+		}
+
+		err = readEvents(resp, func(event Event) error {
+			since = event.Time
+			return fn(event)
+		})
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// readEvents parses a server-sent event stream until it ends
+func readEvents(resp *http.Response, fn func(Event) error) error {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var name string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "":
+			payload := data.String()
+			data.Reset()
+			switch name {
+			case "timeline":
+				var event Event
+				if err := json.Unmarshal([]byte(payload), &event); err != nil {
+					return fmt.Errorf("pr-previews: invalid event: %v", err)
+				}
+				if err := fn(event); err != nil {
+					return err
+				}
+			case "error":
+				return fmt.Errorf("pr-previews: event stream: %s", payload)
+			}
+			name = ""
+		}
+	}
+	return nil
+}
+
+// StreamLogs calls fn with each line the preview's containers log, until
+// the logs so far are read or, with Follow, the server ends the stream, ctx
+// is cancelled or fn returns an error
+func (c *Client) StreamLogs(ctx context.Context, prNumber int, service string, opts LogOptions, fn func(LogLine) error) error {
+	query := url.Values{}
+	if opts.Pod != "" {
+		query.Set("pod", opts.Pod)
+	}
+	if opts.Container != "" {
+		query.Set("container", opts.Container)
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+	if opts.TailLines > 0 {
+		query.Set("tail", strconv.FormatInt(opts.TailLines, 10))
+	}
+
+	resp, err := c.stream(ctx, fmt.Sprintf("/previews/%d/%s/logs", prNumber, service), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var line LogLine
+		if err := decoder.Decode(&line); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("pr-previews: invalid log line: %v", err)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}